@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/estimate"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// adviseWindow is how far back the growth of sources is measured from.
+const adviseWindow = 90 * 24 * time.Hour
+
+// adviseSoon is how soon a target running out of space is warned about.
+const adviseSoon = 30 * 24 * time.Hour
+
+// advise predicts when each paired target will run out of space.
+func advise(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("advise", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources, and the used space of sources when they were cloned.`)
+	fs.StringVar(configPath, "config", config.DefaultPath, `Path to the JSON configuration file defining the reserves of targets, and how sizes and times are formatted.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s advise [-state <path>] [-config <path>]
+
+Predicts when each attached paired target will run out of space, so that
+larger disks can be budgeted for before then. Sources' growth is measured from
+their used space recorded by clones over the last %d days, and the free space
+of each target, less its reserve_percent, is assumed to fill as fast as its
+source grows. Data that is rewritten rather than added, and kept by snapshots
+retained on targets, is not accounted for, so predictions are optimistic for
+targets that are not pruned.
+`, os.Args[0], int(adviseWindow.Hours()/24))
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	f, err := format.New(cfg.Format)
+	if err != nil {
+		return err
+	}
+	if len(st.Pairings) == 0 {
+		fmt.Println("No targets are paired.")
+		return nil
+	}
+	du := newDiskUtil()
+	now := clk.Now()
+	for _, p := range st.Pairings {
+		info, err := du.Info(ctx, p.TargetUUID)
+		if err != nil {
+			fmt.Printf("%q (%s): not attached - attach it to advise on it\n", p.TargetName, p.TargetUUID)
+			continue
+		}
+		if info.ContainerSize == 0 {
+			fmt.Printf("%q (%s): container size unknown\n", p.TargetName, p.TargetUUID)
+			continue
+		}
+		var free uint64
+		reserve := info.ContainerSize / 100 * uint64(targetReserve(cfg, p))
+		if info.ContainerFree > reserve {
+			free = info.ContainerFree - reserve
+		}
+		fmt.Printf("%q (%s): %s free", p.TargetName, p.TargetUUID, f.Bytes(free))
+		if reserve > 0 {
+			fmt.Printf(" beyond its %s reserve", f.Bytes(reserve))
+		}
+		rate, ok := estimate.GrowthRate(sourceUsage(st, p, now))
+		if !ok {
+			fmt.Println("; its source has not grown, or was not cloned to it at least twice in the window")
+			continue
+		}
+		full := estimate.Exhausted(free, rate, now)
+		soon := ""
+		if full.Sub(now) < adviseSoon {
+			soon = " - REPLACE SOON with a larger disk, or prune more snapshots"
+		}
+		fmt.Printf("; source grows about %s/day, so it is full in about %d days, around %s%s\n", f.Bytes(uint64(rate)), int(full.Sub(now).Hours()/24), f.Time(full), soon)
+	}
+	return nil
+}
+
+// targetReserve returns the largest reserve_percent of the sets that clone to
+// the target of p, by volume UUID or paired name.
+func targetReserve(cfg *config.Config, p state.Pairing) int {
+	reserve := 0
+	for _, s := range cfg.Sets {
+		for _, t := range s.Targets {
+			if (t == p.TargetUUID || t == p.TargetName) && s.ReservePercent > reserve {
+				reserve = s.ReservePercent
+			}
+		}
+	}
+	return reserve
+}
+
+// sourceUsage returns the used space of the source of p when it was cloned to
+// the target of p within adviseWindow of now, oldest first.
+func sourceUsage(st *state.State, p state.Pairing, now time.Time) []estimate.Usage {
+	var usage []estimate.Usage
+	for _, e := range st.History(p.TargetUUID) {
+		if e.SourceUUID != p.SourceUUID || e.SourceUsedBytes == 0 || now.Sub(e.Started) > adviseWindow {
+			continue
+		}
+		usage = append(usage, estimate.Usage{At: e.Started, Bytes: e.SourceUsedBytes})
+	}
+	return usage
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/resources"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// agentLogDir is the directory of the logs of jobs installed by install-agent.
+const agentLogDir = "/Library/Logs/offsite-apfs-backup"
+
+// installAgent installs and loads a launchd job that runs a backup set from the
+// config file on a schedule, or whenever a volume is mounted. Clones require
+// root, so the job is installed as a daemon, like schedule install's.
+func installAgent(args []string) error {
+	fs := flag.NewFlagSet("install-agent", flag.ExitOnError)
+	label := fs.String("label", "", `Label of the launchd job. Defaults to `+defaultLabel+`.<backup set>.`)
+	interval := fs.Duration("interval", 0, `How often to run the backup set, e.g. 24h.`)
+	onMount := fs.Bool("on-mount", false, `If true, run the backup set whenever a volume is mounted, e.g. when a target is attached.`)
+	logPath := fs.String("log", "", `Path of the file the job's output is appended to. Defaults to `+agentLogDir+`/<label>.log.`)
+	cfgPath := fs.String("config", config.DefaultPath, `Path to the JSON configuration file naming the backup set.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s install-agent [-label <label>] [-interval <duration>] [-on-mount] [-log <path>] [-config <path>] [-state <path>] <backup set>
+
+Installs and loads a launchd job that runs <backup set> from the config file in
+-launchd mode, without confirmation, as if by run. At least one of -interval or
+-on-mount is required. The set is read from the config file each time the job
+runs, so changes to it take effect without reinstalling the job. Uninstall the
+job with schedule uninstall -label <label>.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <backup set> is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *interval <= 0 && !*onMount {
+		fmt.Fprintln(fs.Output(), "Error: at least one of -interval or -on-mount is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	setName := fs.Arg(0)
+	// Fail now, rather than every time the job runs.
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		return err
+	}
+	if _, err := cfg.Set(setName); err != nil {
+		return usageError{err}
+	}
+	if *label == "" {
+		*label = defaultLabel + "." + setName
+	}
+	if *logPath == "" {
+		*logPath = filepath.Join(agentLogDir, *label+".log")
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// launchd passes each argument as is, without a shell, so paths need no
+	// quoting, and resolving them now keeps the job working regardless of its
+	// working directory.
+	cfgAbs, err := filepath.Abs(*cfgPath)
+	if err != nil {
+		return err
+	}
+	stateAbs, err := filepath.Abs(*statePath)
+	if err != nil {
+		return err
+	}
+	logAbs, err := filepath.Abs(*logPath)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(logAbs), 0755); err != nil {
+		return fmt.Errorf("error creating log directory: %w", err)
+	}
+	plist, err := resources.LaunchdPlist(resources.LaunchdJob{
+		Label:    *label,
+		Args:     resources.LaunchdRunArgs(exe, []string{"-config", cfgAbs, "-state", stateAbs}, setName),
+		LogPath:  logAbs,
+		Interval: int(interval.Round(time.Second).Seconds()),
+		OnMount:  *onMount,
+	})
+	if err != nil {
+		return err
+	}
+	plistPath, err := installLaunchdJob(*label, plist)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s to run backup set %q. Logs are written to %s.\n", plistPath, setName, logAbs)
+	return nil
+}
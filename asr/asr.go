@@ -4,18 +4,27 @@ package asr
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
+	"time"
 
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
+	"github.com/voidingwarranties/offsite-apfs-backup/cmdtrace"
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/rusage"
 )
 
-// ASR restores a target volume to a source volume's APFS snapshot.
+// ASR restores a target volume to a source volume's APFS snapshot. asr is
+// killed if ctx is done before the restore completes. Interrupted restores are
+// safe to retry. Check returns an error if asr cannot restore snapshots.
 type ASR interface {
-	Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error
-	DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error
+	Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error
+	DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error
+	Check(ctx context.Context) error
 }
 
 type asr struct {
@@ -24,8 +33,15 @@ type asr struct {
 
 // config contains fields shared between asr and dryRunASR.
 type config struct {
-	execCommand func(string, ...string) *exec.Cmd
+	execCommand func(context.Context, string, ...string) *exec.Cmd
 	stdout      io.Writer
+	onProgress  func(percent int)
+	onUsage     func(rusage.Usage)
+	tuning      Tuning
+	trace       io.Writer
+	retry       RetryPolicy
+	sleep       func(context.Context, time.Duration) error
+	clock       clock.Clock
 }
 
 // Option configures the behavior of ASR.
@@ -38,7 +54,72 @@ func Stdout(w io.Writer) Option {
 	}
 }
 
-func withExecCmd(f func(string, ...string) *exec.Cmd) Option {
+// Progress returns an Option that calls f with the percent complete (0 to 100)
+// each time asr reports progress during a restore.
+func Progress(f func(percent int)) Option {
+	return func(conf *config) {
+		conf.onProgress = f
+	}
+}
+
+// ResourceUsage returns an Option that calls f with the CPU time and peak
+// memory used by each asr command once it exits, whether or not it succeeded.
+func ResourceUsage(f func(rusage.Usage)) Option {
+	return func(conf *config) {
+		conf.onUsage = f
+	}
+}
+
+// Trace returns an Option that writes each asr command to w before it is run,
+// e.g. for verbose output.
+func Trace(w io.Writer) Option {
+	return func(conf *config) {
+		conf.trace = w
+	}
+}
+
+// Tuning configures the buffers asr copies data with. The zero value uses
+// asr's defaults.
+type Tuning struct {
+	// Buffers is the number of buffers, or 0 for asr's default.
+	Buffers int `json:"buffers,omitempty"`
+	// BufferSize is the size of each buffer in the syntax of asr, e.g. 8m,
+	// or empty for asr's default.
+	BufferSize string `json:"buffer_size,omitempty"`
+}
+
+func (t Tuning) String() string {
+	buffers, size := "default", "default"
+	if t.Buffers > 0 {
+		buffers = strconv.Itoa(t.Buffers)
+	}
+	if t.BufferSize != "" {
+		size = t.BufferSize
+	}
+	return fmt.Sprintf("%s buffers of %s size", buffers, size)
+}
+
+// args returns the asr arguments that apply the tuning.
+func (t Tuning) args() []string {
+	var args []string
+	if t.Buffers > 0 {
+		args = append(args, "--buffers", strconv.Itoa(t.Buffers))
+	}
+	if t.BufferSize != "" {
+		args = append(args, "--buffersize", t.BufferSize)
+	}
+	return args
+}
+
+// Buffers returns an Option that restores with the buffers configured by t,
+// e.g. as chosen by benchmarking with the bench-asr command.
+func Buffers(t Tuning) Option {
+	return func(conf *config) {
+		conf.tuning = t
+	}
+}
+
+func withExecCmd(f func(context.Context, string, ...string) *exec.Cmd) Option {
 	return func(conf *config) {
 		conf.execCommand = f
 	}
@@ -46,53 +127,98 @@ func withExecCmd(f func(string, ...string) *exec.Cmd) Option {
 
 // New returns a new ASR.
 func New(opts ...Option) ASR {
+	return newASR(opts...)
+}
+
+func newASR(opts ...Option) asr {
 	conf := config{
-		execCommand: exec.Command,
+		execCommand: exec.CommandContext,
 		stdout:      os.Stdout,
+		sleep:       sleepContext,
+		clock:       clock.Real(),
 	}
 	for _, opt := range opts {
 		opt(&conf)
 	}
-	return asr{
-		config: conf,
+	if conf.trace != nil {
+		conf.execCommand = cmdtrace.Wrap(conf.execCommand, conf.trace)
 	}
+	return asr{config: conf}
 }
 
 // Restore the target volume to the source volume's `to` snapshot, from the
 // target volume's `from` snapshot. Both to and from must exist in source. From
 // must also exist in target.
-func (a asr) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
-	cmd := a.execCommand(
-		"asr", "restore",
+func (a asr) Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	args := []string{
+		"restore",
 		"--source", source.Device,
 		"--target", target.Device,
 		"--toSnapshot", to.UUID,
 		"--fromSnapshot", from.UUID,
-		"--erase", "--noprompt")
-	cmd.Stdout = a.stdout
-	stderr := new(bytes.Buffer)
-	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr.String())
+		"--erase", "--noprompt",
 	}
-	return nil
+	return a.withRetries(ctx, func() error {
+		return a.run(ctx, args)
+	})
 }
 
 // DestructiveRestore restores the target volume to the source volume's `to`
 // snapshot. `to` must exist in source. target's previous data and snapshots
 // will be lost. Use with caution!
-func (a asr) DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
-	cmd := a.execCommand(
-		"asr", "restore",
+func (a asr) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	args := []string{
+		"restore",
 		"--source", source.Device,
 		"--target", target.Device,
 		"--toSnapshot", to.UUID,
-		"--erase", "--noprompt")
-	cmd.Stdout = a.stdout
+		"--erase", "--noprompt",
+	}
+	return a.withRetries(ctx, func() error {
+		return a.run(ctx, args)
+	})
+}
+
+// run runs `asr <args>` with the tuning's arguments.
+func (a asr) run(ctx context.Context, args []string) error {
+	cmd := a.execCommand(ctx, "asr", append(args, a.tuning.args()...)...)
+	cmd.Stdout = a.cmdStdout()
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr.String())
+	err := cmd.Run()
+	a.reportUsage(cmd)
+	if err != nil {
+		return newRestoreError(cmd, err, stderr.String())
 	}
 	return nil
 }
+
+func newRestoreError(cmd *exec.Cmd, err error, stderr string) *RestoreError {
+	return &RestoreError{
+		Cmd:      cmd.String(),
+		Err:      err,
+		Stderr:   stderr,
+		IOErrors: parseIOErrors(stderr),
+	}
+}
+
+// reportUsage reports the resources used by cmd, if it ran, to onUsage.
+func (a asr) reportUsage(cmd *exec.Cmd) {
+	if a.onUsage == nil {
+		return
+	}
+	if u, ok := rusage.FromProcessState(cmd.ProcessState); ok {
+		a.onUsage(u)
+	}
+}
+
+// cmdStdout returns the writer to use as the stdout of asr commands.
+func (a asr) cmdStdout() io.Writer {
+	if a.onProgress == nil {
+		return a.stdout
+	}
+	return &progressWriter{
+		w:          a.stdout,
+		onProgress: a.onProgress,
+	}
+}
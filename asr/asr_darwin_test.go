@@ -4,6 +4,7 @@ package asr_test
 
 import (
 	"path/filepath"
+	"context"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -24,12 +25,12 @@ func TestRestore(t *testing.T) {
 	from := diskimage.SourceImg.Snapshots(t)[1]
 
 	r := asr.New()
-	if err := r.Restore(source, target, to, from); err != nil {
+	if err := r.Restore(context.Background(), source, target, to, from); err != nil {
 		t.Fatalf("Restore returned unexpected error: %v, want: nil", err)
 	}
 
 	du := diskutil.New()
-	got, err := du.ListSnapshots(target)
+	got, err := du.ListSnapshots(context.Background(), target)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -140,7 +141,7 @@ func TestRestore_Errors(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			source, target := test.setup(t)
 			r := asr.New()
-			err := r.Restore(source, target, test.to, test.from)
+			err := r.Restore(context.Background(), source, target, test.to, test.from)
 			if err == nil {
 				t.Fatal("Restore returned unexpected error: nil, want: non-nil")
 			}
@@ -1,11 +1,18 @@
 package asr
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"io"
 	"os"
+	"os/exec"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/rusage"
 	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
 )
 
@@ -23,14 +30,14 @@ func TestRestore_WritesOutputToStdout(t *testing.T) {
 
 	a := New(
 		Stdout(pw),
-		withExecCmd(fakecmd.FakeCommand(t,
+		withExecCmd(fakecmd.FakeCommandContext(t,
 			fakecmd.Stdout("asr", "want stdout"),
 		)),
 	)
 
 	dummyVolume := diskutil.VolumeInfo{}
 	dummySnap := diskutil.Snapshot{}
-	err = a.Restore(dummyVolume, dummyVolume, dummySnap, dummySnap)
+	err = a.Restore(context.Background(), dummyVolume, dummyVolume, dummySnap, dummySnap)
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
 	}
@@ -79,8 +86,8 @@ func TestRestore_CmdArgs(t *testing.T) {
 		fakecmd.WantArg("asr", to.UUID),
 		fakecmd.WantArg("asr", from.UUID),
 	}
-	a := New(withExecCmd(fakecmd.FakeCommand(t, opts...)))
-	err := a.Restore(source, target, to, from)
+	a := New(withExecCmd(fakecmd.FakeCommandContext(t, opts...)))
+	err := a.Restore(context.Background(), source, target, to, from)
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
 	}
@@ -88,3 +95,155 @@ func TestRestore_CmdArgs(t *testing.T) {
 		t.Fatalf("Restore returned unexpected error: %v, want: nil", err)
 	}
 }
+
+func TestRestore_Buffers(t *testing.T) {
+	a := New(
+		Buffers(Tuning{Buffers: 8, BufferSize: "8m"}),
+		withExecCmd(fakecmd.FakeCommandContext(t,
+			fakecmd.WantArg("asr", "--buffers"),
+			fakecmd.WantArg("asr", "8"),
+			fakecmd.WantArg("asr", "--buffersize"),
+			fakecmd.WantArg("asr", "8m"),
+		)),
+	)
+	dummyVolume := diskutil.VolumeInfo{}
+	dummySnap := diskutil.Snapshot{}
+	err := a.DestructiveRestore(context.Background(), dummyVolume, dummyVolume, dummySnap)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("DestructiveRestore returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestRestore_Trace(t *testing.T) {
+	var trace bytes.Buffer
+	a := New(
+		Trace(&trace),
+		withExecCmd(fakecmd.FakeCommandContext(t)),
+	)
+	source := diskutil.VolumeInfo{Device: "/dev/disk2s1"}
+	target := diskutil.VolumeInfo{Device: "/dev/disk3s1"}
+	err := a.DestructiveRestore(context.Background(), source, target, diskutil.Snapshot{Name: "snap", UUID: "snap-uuid"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("DestructiveRestore returned unexpected error: %v, want: nil", err)
+	}
+	if got, want := trace.String(), "+ asr restore --source /dev/disk2s1 --target /dev/disk3s1 --toSnapshot snap-uuid --erase --noprompt\n"; got != want {
+		t.Errorf("DestructiveRestore traced %q, want: %q", got, want)
+	}
+}
+
+func TestRestore_ResourceUsage(t *testing.T) {
+	var calls int
+	a := New(
+		ResourceUsage(func(u rusage.Usage) {
+			calls++
+		}),
+		withExecCmd(fakecmd.FakeCommandContext(t,
+			fakecmd.ExitFail("asr"),
+		)),
+	)
+	dummyVolume := diskutil.VolumeInfo{}
+	dummySnap := diskutil.Snapshot{}
+	err := a.Restore(context.Background(), dummyVolume, dummyVolume, dummySnap, dummySnap)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err == nil {
+		t.Fatal("Restore returned unexpected error: nil, want: non-nil")
+	}
+	if calls != 1 {
+		t.Errorf("ResourceUsage func called %d times, want: 1", calls)
+	}
+}
+
+func TestBlockRestore(t *testing.T) {
+	source := diskutil.VolumeInfo{Device: "/dev/source-device"}
+	target := diskutil.VolumeInfo{Device: "/dev/target-device"}
+	err := BlockRestore(context.Background(), source, target,
+		Buffers(Tuning{Buffers: 4}),
+		withExecCmd(fakecmd.FakeCommandContext(t,
+			fakecmd.WantArg("asr", source.Device),
+			fakecmd.WantArg("asr", target.Device),
+			fakecmd.WantArg("asr", "--buffers"),
+		)),
+	)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("BlockRestore returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestTuning_String(t *testing.T) {
+	for tuning, want := range map[Tuning]string{
+		{}:                             "default buffers of default size",
+		{Buffers: 8, BufferSize: "1m"}: "8 buffers of 1m size",
+	} {
+		if got := tuning.String(); got != want {
+			t.Errorf("%#v.String() = %q, want: %q", tuning, got, want)
+		}
+	}
+}
+
+func TestRestore_Cancelled(t *testing.T) {
+	a := New(withExecCmd(fakecmd.FakeCommandContext(t)))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dummyVolume := diskutil.VolumeInfo{}
+	dummySnap := diskutil.Snapshot{}
+	err := a.Restore(ctx, dummyVolume, dummyVolume, dummySnap, dummySnap)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Restore returned unexpected error: %v, want: %v", err, context.Canceled)
+	}
+}
+
+func TestRestore_Errors(t *testing.T) {
+	a := New(withExecCmd(fakecmd.FakeCommandContext(t,
+		fakecmd.Stderr("asr", `Validating target...done
+Restoring  ....10....20
+asr: Couldn't restore - Input/output error
+Read error on /dev/rdisk4s1 at offset 1048576: Input/output error
+Write failed on /dev/disk5s1, block 2048: error 5
+`),
+		fakecmd.ExitFail("asr"),
+	)))
+	dummyVolume := diskutil.VolumeInfo{}
+	dummySnap := diskutil.Snapshot{}
+	err := a.Restore(context.Background(), dummyVolume, dummyVolume, dummySnap, dummySnap)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("Restore returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) {
+		t.Fatalf("Restore returned unexpected error: %v, want type: *RestoreError", err)
+	}
+	want := []IOError{
+		{
+			Line:   "asr: Couldn't restore - Input/output error",
+			Offset: -1,
+		},
+		{
+			Line:   "Read error on /dev/rdisk4s1 at offset 1048576: Input/output error",
+			Device: "/dev/disk4s1",
+			Offset: 1048576,
+		},
+		{
+			Line:   "Write failed on /dev/disk5s1, block 2048: error 5",
+			Device: "/dev/disk5s1",
+			Offset: 2048 * 512,
+		},
+	}
+	if diff := cmp.Diff(want, restoreErr.IOErrors); diff != "" {
+		t.Errorf("Restore returned unexpected IOErrors. -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,69 @@
+package asr
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// ErrUnsupported is returned by Check if asr cannot restore APFS snapshots,
+// e.g. because it is missing, or too old to support snapshot restores.
+var ErrUnsupported = errors.New("asr does not support restoring APFS snapshots")
+
+// snapshotFlags are the flags of `asr restore` that restoring APFS snapshots
+// requires. The minimum supported version of asr is the first to support
+// them, so they are checked for rather than the version.
+var snapshotFlags = []string{"--toSnapshot", "--fromSnapshot"}
+
+var versionRegexp = regexp.MustCompile(`[0-9]+(\.[0-9]+)+`)
+
+// Check returns an error wrapping ErrUnsupported if asr cannot restore APFS
+// snapshots, so that clones fail before modifying targets rather than when asr
+// is first run.
+func (a asr) Check(ctx context.Context) error {
+	version, err := a.output(ctx, "version")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	if v := versionRegexp.FindString(version); v != "" {
+		version = v
+	} else {
+		version = "of unknown version"
+	}
+	// asr prints its usage, including the flags it supports, with help.
+	usage, err := a.output(ctx, "help")
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrUnsupported, err)
+	}
+	for _, flag := range snapshotFlags {
+		if !strings.Contains(usage, flag) {
+			return fmt.Errorf("%w: asr %s does not support %s - update macOS to restore APFS snapshots", ErrUnsupported, version, flag)
+		}
+	}
+	return nil
+}
+
+// output returns the combined stdout and stderr of running asr with args.
+func (a asr) output(ctx context.Context, args ...string) (string, error) {
+	cmd := a.execCommand(ctx, "asr", args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		// asr exits non-zero after printing its usage on some versions.
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) || stdout.Len()+stderr.Len() == 0 {
+			return "", fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr.String())
+		}
+	}
+	return stdout.String() + stderr.String(), nil
+}
+
+// Check never fails, as dry runs never run asr.
+func (dry dryRun) Check(ctx context.Context) error {
+	return nil
+}
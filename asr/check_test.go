@@ -0,0 +1,57 @@
+package asr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []fakecmd.Option
+		wantErr error
+	}{
+		{
+			name: "supported",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("asr", "asr 3.0.1 (asr-640)\nUsage: asr restore --source <source> --target <target> [--toSnapshot <uuid>] [--fromSnapshot <uuid>]\n"),
+			},
+		},
+		{
+			name: "snapshot restores unsupported",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("asr", "asr 2.0 (asr-300)\nUsage: asr restore --source <source> --target <target>\n"),
+			},
+			wantErr: ErrUnsupported,
+		},
+		{
+			name: "usage printed with non-zero exit",
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("asr", "asr 3.0.1 (asr-640)\nUsage: asr restore --source <source> --target <target> [--toSnapshot <uuid>] [--fromSnapshot <uuid>]\n"),
+				fakecmd.ExitFail("asr"),
+			},
+		},
+		{
+			name: "asr fails",
+			opts: []fakecmd.Option{
+				fakecmd.ExitFail("asr"),
+			},
+			wantErr: ErrUnsupported,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := New(withExecCmd(fakecmd.FakeCommandContext(t, test.opts...)))
+			err := a.Check(context.Background())
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Check returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package asr
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -23,12 +24,12 @@ func NewDryRun(opts ...Option) ASR {
 	}
 }
 
-func (dry dryRun) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+func (dry dryRun) Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
 	fmt.Fprintln(dry.stdout, "Restore completed successfully.")
 	return nil
 }
 
-func (dry dryRun) DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+func (dry dryRun) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
 	fmt.Fprintln(dry.stdout, "Restore completed successfully.")
 	return nil
 }
@@ -0,0 +1,79 @@
+package asr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// RestoreError is returned when `asr restore` fails. It wraps the command's
+// error (usually an *exec.ExitError), and describes any device I/O errors
+// that asr reported.
+type RestoreError struct {
+	// Cmd is the failed command, e.g. `asr restore --source ...`.
+	Cmd    string
+	Err    error
+	Stderr string
+	// IOErrors are the device I/O errors reported in asr's stderr, in the
+	// order they were reported.
+	IOErrors []IOError
+}
+
+func (err *RestoreError) Error() string {
+	return fmt.Sprintf("`%s` failed (%s) with stderr: %s", err.Cmd, err.Err, err.Stderr)
+}
+
+func (err *RestoreError) Unwrap() error {
+	return err.Err
+}
+
+// IOError is a device I/O error reported by asr.
+type IOError struct {
+	// Line is the line of asr's stderr that reported the error.
+	Line string
+	// Device is the device node the error occurred on, e.g. /dev/disk4s1,
+	// or empty if asr did not report it.
+	Device string
+	// Offset is the byte offset on Device the error occurred at, or -1 if
+	// asr did not report it.
+	Offset int64
+}
+
+var (
+	ioErrorRegexp = regexp.MustCompile(`(?i)input/output error|i/o error|\berror 5\b|\bEIO\b`)
+	deviceRegexp  = regexp.MustCompile(`/dev/r?disk[0-9]+(s[0-9]+)?`)
+	// asr reports locations either as a byte offset, or as a 512 byte
+	// block (sector) number.
+	offsetRegexp = regexp.MustCompile(`(?i)\boffset:?\s+(0x[0-9a-f]+|[0-9]+)`)
+	blockRegexp  = regexp.MustCompile(`(?i)\b(?:block|sector):?\s+(0x[0-9a-f]+|[0-9]+)`)
+)
+
+// blockSize is the size of the blocks reported by asr.
+const blockSize = 512
+
+// parseIOErrors returns the device I/O errors reported in asr's stderr.
+func parseIOErrors(stderr string) []IOError {
+	var errs []IOError
+	for _, line := range strings.Split(stderr, "\n") {
+		if !ioErrorRegexp.MatchString(line) {
+			continue
+		}
+		e := IOError{
+			Line:   strings.TrimSpace(line),
+			Device: strings.Replace(deviceRegexp.FindString(line), "/dev/rdisk", "/dev/disk", 1),
+			Offset: -1,
+		}
+		if m := offsetRegexp.FindStringSubmatch(line); m != nil {
+			if n, err := strconv.ParseInt(m[1], 0, 64); err == nil {
+				e.Offset = n
+			}
+		} else if m := blockRegexp.FindStringSubmatch(line); m != nil {
+			if n, err := strconv.ParseInt(m[1], 0, 64); err == nil {
+				e.Offset = n * blockSize
+			}
+		}
+		errs = append(errs, e)
+	}
+	return errs
+}
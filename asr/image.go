@@ -0,0 +1,29 @@
+package asr
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// BlockRestore erases the target volume, and restores it to a block copy of
+// the unmounted source volume, without snapshots. It is used to benchmark
+// Tunings on scratch volumes.
+func BlockRestore(ctx context.Context, source, target diskutil.VolumeInfo, opts ...Option) error {
+	a := newASR(opts...)
+	args := []string{
+		"restore",
+		"--source", source.Device,
+		"--target", target.Device,
+		"--erase", "--noprompt",
+	}
+	cmd := a.execCommand(ctx, "asr", append(args, a.tuning.args()...)...)
+	cmd.Stdout = a.cmdStdout()
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return newRestoreError(cmd, err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package asr
+
+import (
+	"io"
+	"strconv"
+)
+
+// progressWriter passes all writes through to w, while parsing the progress
+// percentages that asr writes as it restores (e.g.
+// "Restoring  ....10....20....30") and calling onProgress with each.
+type progressWriter struct {
+	w          io.Writer
+	onProgress func(percent int)
+
+	// digits of the percentage currently being parsed.
+	digits []byte
+	// afterDot is true if the previous byte written was a '.'. Percentages
+	// are always preceded by a '.'.
+	afterDot bool
+}
+
+func (pw *progressWriter) Write(p []byte) (int, error) {
+	n, err := pw.w.Write(p)
+	for _, b := range p[:n] {
+		if '0' <= b && b <= '9' {
+			if pw.afterDot || len(pw.digits) > 0 {
+				pw.digits = append(pw.digits, b)
+			}
+			pw.afterDot = false
+			continue
+		}
+		if len(pw.digits) > 0 {
+			if percent, err := strconv.Atoi(string(pw.digits)); err == nil && percent <= 100 {
+				pw.onProgress(percent)
+			}
+			pw.digits = nil
+		}
+		pw.afterDot = b == '.'
+	}
+	return n, err
+}
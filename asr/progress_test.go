@@ -0,0 +1,62 @@
+package asr
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestProgressWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   []int
+	}{
+		{
+			name:   "single write",
+			writes: []string{"\tRestoring  ....10....20....30\n"},
+			want:   []int{10, 20, 30},
+		},
+		{
+			name:   "percent split across writes",
+			writes: []string{"Restoring  ....1", "0....2", "0....100\n"},
+			want:   []int{10, 20, 100},
+		},
+		{
+			name:   "ignores numbers not preceded by a dot",
+			writes: []string{"Validating target 42...done\n", "....50\n"},
+			want:   []int{50},
+		},
+		{
+			name:   "ignores numbers over 100",
+			writes: []string{"version 3.1415\n"},
+			want:   nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []int
+			out := new(bytes.Buffer)
+			pw := &progressWriter{
+				w: out,
+				onProgress: func(percent int) {
+					got = append(got, percent)
+				},
+			}
+			var wantOut string
+			for _, w := range test.writes {
+				if _, err := pw.Write([]byte(w)); err != nil {
+					t.Fatal(err)
+				}
+				wantOut += w
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("progressWriter reported unexpected progress. -want +got:\n%s", diff)
+			}
+			if out.String() != wantOut {
+				t.Errorf("progressWriter wrote unexpected output: %q, want: %q", out.String(), wantOut)
+			}
+		})
+	}
+}
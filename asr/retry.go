@@ -0,0 +1,136 @@
+package asr
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
+)
+
+// RetryPolicy configures how restores that fail with transient errors, e.g.
+// because a device is busy, are retried. The zero value never retries.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a restore is attempted, including the
+	// first. Values below 2 never retry.
+	MaxAttempts int
+	// Backoff is how long to wait before the first retry. The wait doubles
+	// before each further retry.
+	Backoff time.Duration
+}
+
+// wait returns how long to wait after the given failed attempt, counted from
+// 1, before retrying.
+func (p RetryPolicy) wait(attempt int) time.Duration {
+	return p.Backoff << (attempt - 1)
+}
+
+// Retry returns an Option that retries restores that fail with transient
+// errors according to p.
+func Retry(p RetryPolicy) Option {
+	return func(conf *config) {
+		conf.retry = p
+	}
+}
+
+// transientRegexp matches the errors asr reports when a restore fails only
+// because a device was briefly unavailable, e.g. while Spotlight or another
+// process held it open.
+var transientRegexp = regexp.MustCompile(`(?i)resource busy|\bEBUSY\b`)
+
+// Transient returns true if the restore failed with an error that is likely
+// to go away if the restore is retried, e.g. "Resource busy".
+func (err *RestoreError) Transient() bool {
+	return transientRegexp.MatchString(err.Stderr)
+}
+
+// RetryAttempt describes a failed restore that is about to be retried.
+type RetryAttempt struct {
+	// Attempt is the attempt that failed, counted from 1.
+	Attempt     int
+	MaxAttempts int
+	// Wait is how long until the next attempt.
+	Wait time.Duration
+	Err  *RestoreError
+}
+
+type retryTraceKey struct{}
+
+// WithRetryTrace returns a copy of ctx that calls f before each retry of
+// restores run with it, so that callers can surface retries, like
+// net/http/httptrace.
+func WithRetryTrace(ctx context.Context, f func(RetryAttempt)) context.Context {
+	return context.WithValue(ctx, retryTraceKey{}, f)
+}
+
+// ContextRetryTrace returns the function set by WithRetryTrace on ctx, or nil
+// if none was set, e.g. for other implementations of ASR to report retries.
+func ContextRetryTrace(ctx context.Context) func(RetryAttempt) {
+	f, _ := ctx.Value(retryTraceKey{}).(func(RetryAttempt))
+	return f
+}
+
+type retryDeadlineKey struct{}
+
+// WithRetryDeadline returns a copy of ctx whose restores are not retried if
+// the wait before retrying would end after deadline, e.g. so that retries do
+// not overrun a time budget. Unlike context.WithDeadline, restores in progress
+// at deadline are not killed.
+func WithRetryDeadline(ctx context.Context, deadline time.Time) context.Context {
+	return context.WithValue(ctx, retryDeadlineKey{}, deadline)
+}
+
+// withRetries calls restore until it succeeds, fails with an error that is not
+// transient, or has been attempted MaxAttempts times, waiting between
+// attempts. If ctx is done while waiting, or the wait would end after ctx's
+// retry deadline, the last error is returned.
+func (a asr) withRetries(ctx context.Context, restore func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := restore()
+		var restoreErr *RestoreError
+		if err == nil || attempt >= a.retry.MaxAttempts || !errors.As(err, &restoreErr) || !restoreErr.Transient() {
+			return err
+		}
+		wait := a.retry.wait(attempt)
+		if deadline, ok := ctx.Value(retryDeadlineKey{}).(time.Time); ok && a.clock.Now().Add(wait).After(deadline) {
+			return err
+		}
+		if trace := ContextRetryTrace(ctx); trace != nil {
+			trace(RetryAttempt{
+				Attempt:     attempt,
+				MaxAttempts: a.retry.MaxAttempts,
+				Wait:        wait,
+				Err:         restoreErr,
+			})
+		}
+		if a.sleep(ctx, wait) != nil {
+			return err
+		}
+	}
+}
+
+// sleepContext waits for d, or until ctx is done, in which case it returns
+// ctx's error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func withSleep(f func(context.Context, time.Duration) error) Option {
+	return func(conf *config) {
+		conf.sleep = f
+	}
+}
+
+func withClock(clk clock.Clock) Option {
+	return func(conf *config) {
+		conf.clock = clk
+	}
+}
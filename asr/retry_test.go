@@ -0,0 +1,194 @@
+package asr
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestRestore_Retry(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   RetryPolicy
+		stderr   string
+		failures int
+		// wantCalls is the number of times asr is run.
+		wantCalls int
+		wantWaits []time.Duration
+		wantErr   bool
+	}{
+		{
+			name:      "succeeds after retries",
+			policy:    RetryPolicy{MaxAttempts: 3, Backoff: time.Second},
+			stderr:    "asr: Couldn't set up partitions on target device - Resource busy",
+			failures:  2,
+			wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:      "retries exhausted",
+			policy:    RetryPolicy{MaxAttempts: 3, Backoff: time.Second},
+			stderr:    "asr: Couldn't set up partitions on target device - Resource busy",
+			failures:  3,
+			wantCalls: 3,
+			wantWaits: []time.Duration{time.Second, 2 * time.Second},
+			wantErr:   true,
+		},
+		{
+			name:      "not transient",
+			policy:    RetryPolicy{MaxAttempts: 3, Backoff: time.Second},
+			stderr:    "asr: Couldn't find snapshot",
+			failures:  1,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "no retries",
+			stderr:    "asr: Couldn't set up partitions on target device - Resource busy",
+			failures:  1,
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fail := fakecmd.FakeCommandContext(t,
+				fakecmd.Stderr("asr", test.stderr),
+				fakecmd.ExitFail("asr"),
+			)
+			succeed := fakecmd.FakeCommandContext(t)
+			calls := 0
+			execCommand := func(ctx context.Context, name string, args ...string) *exec.Cmd {
+				calls++
+				if calls <= test.failures {
+					return fail(ctx, name, args...)
+				}
+				return succeed(ctx, name, args...)
+			}
+			var waits []time.Duration
+			a := New(
+				Retry(test.policy),
+				withExecCmd(execCommand),
+				withSleep(func(ctx context.Context, d time.Duration) error {
+					waits = append(waits, d)
+					return nil
+				}),
+			)
+			var attempts []int
+			ctx := WithRetryTrace(context.Background(), func(r RetryAttempt) {
+				if !r.Err.Transient() {
+					t.Errorf("Retried after error that is not transient: %v", r.Err)
+				}
+				attempts = append(attempts, r.Attempt)
+			})
+			err := a.DestructiveRestore(ctx, diskutil.VolumeInfo{}, diskutil.VolumeInfo{}, diskutil.Snapshot{})
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			var restoreErr *RestoreError
+			if gotErr := errors.As(err, &restoreErr); gotErr != test.wantErr {
+				t.Errorf("DestructiveRestore returned unexpected error: %v, want *RestoreError: %t", err, test.wantErr)
+			}
+			if calls != test.wantCalls {
+				t.Errorf("DestructiveRestore ran asr %d times, want: %d", calls, test.wantCalls)
+			}
+			if diff := cmp.Diff(test.wantWaits, waits); diff != "" {
+				t.Errorf("DestructiveRestore waited unexpectedly. -want +got:\n%s", diff)
+			}
+			if len(attempts) != len(test.wantWaits) {
+				t.Errorf("DestructiveRestore traced %d retries, want: %d", len(attempts), len(test.wantWaits))
+			}
+		})
+	}
+}
+
+func TestRestore_RetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	fail := fakecmd.FakeCommandContext(t,
+		fakecmd.Stderr("asr", "Resource busy"),
+		fakecmd.ExitFail("asr"),
+	)
+	a := New(
+		Retry(RetryPolicy{MaxAttempts: 3, Backoff: time.Hour}),
+		withExecCmd(func(ctx context.Context, name string, args ...string) *exec.Cmd {
+			calls++
+			return fail(ctx, name, args...)
+		}),
+	)
+	ctx = WithRetryTrace(ctx, func(RetryAttempt) {
+		cancel()
+	})
+	err := a.Restore(ctx, diskutil.VolumeInfo{}, diskutil.VolumeInfo{}, diskutil.Snapshot{}, diskutil.Snapshot{})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) {
+		t.Errorf("Restore returned unexpected error: %v, want type: *RestoreError", err)
+	}
+	if calls != 1 {
+		t.Errorf("Restore ran asr %d times after being cancelled, want: 1", calls)
+	}
+}
+
+func TestRestore_RetryDeadline(t *testing.T) {
+	clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	calls := 0
+	fail := fakecmd.FakeCommandContext(t,
+		fakecmd.Stderr("asr", "Resource busy"),
+		fakecmd.ExitFail("asr"),
+	)
+	var waits []time.Duration
+	a := New(
+		Retry(RetryPolicy{MaxAttempts: 3, Backoff: time.Minute}),
+		withExecCmd(func(ctx context.Context, name string, args ...string) *exec.Cmd {
+			calls++
+			return fail(ctx, name, args...)
+		}),
+		withSleep(func(ctx context.Context, d time.Duration) error {
+			waits = append(waits, d)
+			clk.Advance(d)
+			return nil
+		}),
+		withClock(clk),
+	)
+	// The first retry waits 1m, and the second 2m, which would end after
+	// the deadline.
+	ctx := WithRetryDeadline(context.Background(), clk.Now().Add(90*time.Second))
+	err := a.Restore(ctx, diskutil.VolumeInfo{}, diskutil.VolumeInfo{}, diskutil.Snapshot{}, diskutil.Snapshot{})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var restoreErr *RestoreError
+	if !errors.As(err, &restoreErr) {
+		t.Errorf("Restore returned unexpected error: %v, want type: *RestoreError", err)
+	}
+	if calls != 2 {
+		t.Errorf("Restore ran asr %d times, want: 2", calls)
+	}
+	if diff := cmp.Diff([]time.Duration{time.Minute}, waits); diff != "" {
+		t.Errorf("Restore waited unexpectedly. -want +got:\n%s", diff)
+	}
+}
+
+func TestRestoreError_Transient(t *testing.T) {
+	for stderr, want := range map[string]bool{
+		"asr: Couldn't set up partitions on target device - Resource busy": true,
+		"Unmount of /dev/disk4s1 failed: EBUSY":                            true,
+		"asr: Couldn't find snapshot":                                      false,
+	} {
+		err := &RestoreError{Stderr: stderr}
+		if got := err.Transient(); got != want {
+			t.Errorf("RestoreError{Stderr: %q}.Transient() = %t, want: %t", stderr, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/audit"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
+)
+
+// showAudit prints the entries of the audit log of destructive operations.
+func showAudit(args []string) error {
+	fs := flag.NewFlagSet("audit", flag.ExitOnError)
+	auditPath := fs.String("audit-log", audit.DefaultPath, `Path to the audit log of destructive operations.`)
+	volume := fs.String("volume", "", `If set, only show operations on (or restoring from) the volume with this UUID.`)
+	run := fs.String("run", "", `If set, only show operations initiated by the run with this ID.`)
+	label := fs.String("label", "", `If set, only show operations initiated by runs with this label.`)
+	operation := fs.String("operation", "", `If set, only show operations of this kind: rename, delete-snapshot, erase, or destructive-restore.`)
+	since := fs.Duration("since", 0, `If set, only show operations within this long ago, e.g. 168h.`)
+	asJSON := fs.Bool("json", false, `If true, print entries as newline-delimited JSON.`)
+	fs.StringVar(configPath, "config", config.DefaultPath, `Path to the JSON configuration file configuring how sizes and times are formatted.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s audit [-audit-log <path>] [-volume <uuid>] [-run <id>] [-label <label>] [-operation <operation>] [-since <duration>] [-json] [-config <path>]
+
+Prints the renames, snapshot deletions, erases, and destructive restores
+recorded in the audit log, oldest first.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	q := audit.Query{
+		VolumeUUID: *volume,
+		RunID:      *run,
+		Label:      *label,
+		Operation:  audit.Operation(*operation),
+	}
+	if *since > 0 {
+		q.Since = clk.Now().Add(-*since)
+	}
+	entries, err := audit.Read(*auditPath, q)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	f, err := loadFormatter(*configPath)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Println(formatAuditEntry(e, f))
+	}
+	return nil
+}
+
+func formatAuditEntry(e audit.Entry, f format.Formatter) string {
+	s := fmt.Sprintf("%s  %s  %s %q (%s)", f.Time(e.Time), describeRun(e.RunID, e.Label), e.Operation, e.VolumeName, e.VolumeUUID)
+	switch e.Operation {
+	case audit.Rename, audit.Erase:
+		s += fmt.Sprintf(" as %q", e.NewName)
+	case audit.DeleteSnapshot:
+		s += fmt.Sprintf(" snapshot %s", e.SnapshotUUID)
+	case audit.DestructiveRestore:
+		s += fmt.Sprintf(" to snapshot %s of %s", e.SnapshotUUID, e.SourceUUID)
+	}
+	if e.Error != "" {
+		s += fmt.Sprintf(" FAILED: %s", e.Error)
+	}
+	return s
+}
@@ -0,0 +1,186 @@
+// Package audit implements an append-only log of operations that destroy data
+// on volumes: renames, snapshot deletions, erases, and destructive restores.
+//
+// The audit log is separate from the normal output and os_log logs, and is
+// never rewritten. Each entry is stored as a single line of JSON.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
+)
+
+// DefaultPath is the location of the audit log used when none is specified.
+const DefaultPath = "/Library/Application Support/offsite-apfs-backup/audit.log"
+
+// Operation is a kind of destructive operation.
+type Operation string
+
+const (
+	Rename             Operation = "rename"
+	DeleteSnapshot     Operation = "delete-snapshot"
+	Erase              Operation = "erase"
+	DestructiveRestore Operation = "destructive-restore"
+)
+
+// Entry records a single destructive operation.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// RunID identifies the invocation that initiated the operation.
+	RunID string `json:"run_id,omitempty"`
+	// Label names the backup routine of the run, if it was labeled.
+	Label      string    `json:"label,omitempty"`
+	Operation  Operation `json:"operation"`
+	VolumeUUID string    `json:"volume_uuid"`
+	VolumeName string    `json:"volume_name"`
+	// NewName is the name the volume was renamed to, for Rename and Erase.
+	NewName string `json:"new_name,omitempty"`
+	// SnapshotUUID is the snapshot deleted by DeleteSnapshot, or restored
+	// by DestructiveRestore.
+	SnapshotUUID string `json:"snapshot_uuid,omitempty"`
+	// SourceUUID is the volume restored from by DestructiveRestore.
+	SourceUUID string `json:"source_uuid,omitempty"`
+	// Error is set if the operation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Option configures a Log.
+type Option func(*Log)
+
+// Clock sets the clock used to timestamp entries. Defaults to the system
+// clock.
+func Clock(c clock.Clock) Option {
+	return func(l *Log) {
+		l.clock = c
+	}
+}
+
+// RunID sets the run ID recorded in entries that do not set their own.
+func RunID(id string) Option {
+	return func(l *Log) {
+		l.runID = id
+	}
+}
+
+// Label sets the run label recorded in entries that do not set their own.
+func Label(label string) Option {
+	return func(l *Log) {
+		l.label = label
+	}
+}
+
+// Log appends entries to the audit log file at a path.
+type Log struct {
+	path  string
+	clock clock.Clock
+	runID string
+	label string
+}
+
+// New returns a Log that appends to the file at path. The file and any
+// missing parent directories are created on the first Append.
+func New(path string, opts ...Option) *Log {
+	l := &Log{
+		path:  path,
+		clock: clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Append timestamps e and appends it to the audit log.
+func (l *Log) Append(e Entry) error {
+	e.Time = l.clock.Now()
+	if e.RunID == "" {
+		e.RunID = l.runID
+	}
+	if e.Label == "" {
+		e.Label = l.label
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("error creating audit log directory: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening audit log: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing audit log: %w", err)
+	}
+	return nil
+}
+
+// Query selects entries of the audit log. Unset fields match all entries.
+type Query struct {
+	VolumeUUID string
+	RunID      string
+	Label      string
+	Operation  Operation
+	// Since excludes entries recorded before it.
+	Since time.Time
+}
+
+// Match returns true if e is selected by q.
+func (q Query) Match(e Entry) bool {
+	switch {
+	case q.VolumeUUID != "" && e.VolumeUUID != q.VolumeUUID && e.SourceUUID != q.VolumeUUID:
+		return false
+	case q.RunID != "" && e.RunID != q.RunID:
+		return false
+	case q.Label != "" && e.Label != q.Label:
+		return false
+	case q.Operation != "" && e.Operation != q.Operation:
+		return false
+	case !q.Since.IsZero() && e.Time.Before(q.Since):
+		return false
+	}
+	return true
+}
+
+// Read returns the entries of the audit log at path that match q, in the order
+// they were recorded. If no file exists at path, no entries are returned.
+func Read(path string, q Query) ([]Entry, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("error parsing audit log %q line %d: %w", path, line, err)
+		}
+		if q.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading audit log: %w", err)
+	}
+	return entries, nil
+}
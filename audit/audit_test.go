@@ -0,0 +1,196 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
+)
+
+func TestRead_MissingFile(t *testing.T) {
+	entries, err := Read(filepath.Join(t.TempDir(), "audit.log"), Query{})
+	if err != nil {
+		t.Fatalf("Read returned unexpected error: %v, want: nil", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("Read returned unexpected entries: %v, want: none", entries)
+	}
+}
+
+func TestAppendRead(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "audit.log")
+	clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	first := New(path, Clock(clk), RunID("run-1"), Label("weekly"))
+	if err := first.Append(Entry{Operation: Rename, VolumeUUID: "target-uuid", NewName: "new-name"}); err != nil {
+		t.Fatalf("Append returned unexpected error: %v, want: nil", err)
+	}
+	clk.Advance(time.Hour)
+	// A second Log must append to, not replace, the existing log.
+	second := New(path, Clock(clk), RunID("run-2"))
+	if err := second.Append(Entry{Operation: Erase, VolumeUUID: "other-uuid"}); err != nil {
+		t.Fatalf("Append returned unexpected error: %v, want: nil", err)
+	}
+
+	renamed := Entry{
+		Time:       time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC),
+		RunID:      "run-1",
+		Label:      "weekly",
+		Operation:  Rename,
+		VolumeUUID: "target-uuid",
+		NewName:    "new-name",
+	}
+	erased := Entry{
+		Time:       time.Date(2021, 3, 1, 21, 35, 9, 0, time.UTC),
+		RunID:      "run-2",
+		Operation:  Erase,
+		VolumeUUID: "other-uuid",
+	}
+	tests := []struct {
+		name  string
+		query Query
+		want  []Entry
+	}{
+		{
+			name: "all",
+			want: []Entry{renamed, erased},
+		},
+		{
+			name:  "volume",
+			query: Query{VolumeUUID: "other-uuid"},
+			want:  []Entry{erased},
+		},
+		{
+			name:  "run",
+			query: Query{RunID: "run-1"},
+			want:  []Entry{renamed},
+		},
+		{
+			name:  "label",
+			query: Query{Label: "weekly"},
+			want:  []Entry{renamed},
+		},
+		{
+			name:  "operation",
+			query: Query{Operation: Erase},
+			want:  []Entry{erased},
+		},
+		{
+			name:  "since",
+			query: Query{Since: erased.Time},
+			want:  []Entry{erased},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Read(path, test.query)
+			if err != nil {
+				t.Fatalf("Read returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Read returned unexpected entries. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+type stubDiskUtil struct {
+	diskutil.DiskUtil
+	err error
+}
+
+func (s stubDiskUtil) Rename(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	return s.err
+}
+
+func (s stubDiskUtil) DeleteSnapshot(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	return s.err
+}
+
+func (s stubDiskUtil) EraseVolume(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	return s.err
+}
+
+type stubASR struct {
+	asr.ASR
+	err error
+}
+
+func (s stubASR) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	return s.err
+}
+
+func TestWrappers(t *testing.T) {
+	source := diskutil.VolumeInfo{UUID: "source-uuid", Name: "source-name"}
+	target := diskutil.VolumeInfo{UUID: "target-uuid", Name: "target-name"}
+	snap := diskutil.Snapshot{UUID: "snap-uuid", Name: "snap-name"}
+	now := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	failure := errors.New("failure")
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l := New(path, Clock(fakeclock.New(now)), RunID("run-1"))
+	du := DiskUtil(stubDiskUtil{}, l)
+	if err := du.Rename(context.Background(), target, "new-name"); err != nil {
+		t.Errorf("Rename returned unexpected error: %v, want: nil", err)
+	}
+	if err := du.DeleteSnapshot(context.Background(), target, snap); err != nil {
+		t.Errorf("DeleteSnapshot returned unexpected error: %v, want: nil", err)
+	}
+	if err := du.EraseVolume(context.Background(), target, "erased-name"); err != nil {
+		t.Errorf("EraseVolume returned unexpected error: %v, want: nil", err)
+	}
+	r := ASR(stubASR{err: failure}, l)
+	if err := r.DestructiveRestore(context.Background(), source, target, snap); !errors.Is(err, failure) {
+		t.Errorf("DestructiveRestore returned unexpected error: %v, want: %v", err, failure)
+	}
+
+	want := []Entry{
+		{
+			Time:       now,
+			RunID:      "run-1",
+			Operation:  Rename,
+			VolumeUUID: target.UUID,
+			VolumeName: target.Name,
+			NewName:    "new-name",
+		},
+		{
+			Time:         now,
+			RunID:        "run-1",
+			Operation:    DeleteSnapshot,
+			VolumeUUID:   target.UUID,
+			VolumeName:   target.Name,
+			SnapshotUUID: snap.UUID,
+		},
+		{
+			Time:       now,
+			RunID:      "run-1",
+			Operation:  Erase,
+			VolumeUUID: target.UUID,
+			VolumeName: target.Name,
+			NewName:    "erased-name",
+		},
+		{
+			Time:         now,
+			RunID:        "run-1",
+			Operation:    DestructiveRestore,
+			VolumeUUID:   target.UUID,
+			VolumeName:   target.Name,
+			SnapshotUUID: snap.UUID,
+			SourceUUID:   source.UUID,
+			Error:        "failure",
+		},
+	}
+	got, err := Read(path, Query{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Wrappers recorded unexpected entries. -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,93 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// record appends e with the result of an operation, returning opErr, or an
+// error if e could not be appended.
+func (l *Log) record(e Entry, opErr error) error {
+	if opErr != nil {
+		e.Error = opErr.Error()
+	}
+	if err := l.Append(e); err != nil {
+		if opErr != nil {
+			return fmt.Errorf("%w (also failed to audit: %v)", opErr, err)
+		}
+		return err
+	}
+	return opErr
+}
+
+type auditedDiskUtil struct {
+	diskutil.DiskUtil
+	log *Log
+}
+
+// DiskUtil returns a DiskUtil that records every Rename, DeleteSnapshot, and
+// EraseVolume of du in l. All other methods are passed through to du.
+func DiskUtil(du diskutil.DiskUtil, l *Log) diskutil.DiskUtil {
+	return auditedDiskUtil{
+		DiskUtil: du,
+		log:      l,
+	}
+}
+
+func (a auditedDiskUtil) Rename(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	err := a.DiskUtil.Rename(ctx, volume, name)
+	return a.log.record(Entry{
+		Operation:  Rename,
+		VolumeUUID: volume.UUID,
+		VolumeName: volume.Name,
+		NewName:    name,
+	}, err)
+}
+
+func (a auditedDiskUtil) DeleteSnapshot(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	err := a.DiskUtil.DeleteSnapshot(ctx, volume, snap)
+	return a.log.record(Entry{
+		Operation:    DeleteSnapshot,
+		VolumeUUID:   volume.UUID,
+		VolumeName:   volume.Name,
+		SnapshotUUID: snap.UUID,
+	}, err)
+}
+
+func (a auditedDiskUtil) EraseVolume(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	err := a.DiskUtil.EraseVolume(ctx, volume, name)
+	return a.log.record(Entry{
+		Operation:  Erase,
+		VolumeUUID: volume.UUID,
+		VolumeName: volume.Name,
+		NewName:    name,
+	}, err)
+}
+
+type auditedASR struct {
+	asr.ASR
+	log *Log
+}
+
+// ASR returns an ASR that records every DestructiveRestore of r in l.
+// Incremental restores are passed through to r without being recorded.
+func ASR(r asr.ASR, l *Log) asr.ASR {
+	return auditedASR{
+		ASR: r,
+		log: l,
+	}
+}
+
+func (a auditedASR) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	err := a.ASR.DestructiveRestore(ctx, source, target, to)
+	return a.log.record(Entry{
+		Operation:    DestructiveRestore,
+		VolumeUUID:   target.UUID,
+		VolumeName:   target.Name,
+		SnapshotUUID: to.UUID,
+		SourceUUID:   source.UUID,
+	}, err)
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/audit"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diagnose"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskhealth"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/keychain"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// batchRequest is a single clone request read by batch.
+type batchRequest struct {
+	// ID is an optional caller-chosen identifier, copied to the result.
+	ID         string   `json:"id"`
+	Source     string   `json:"source"`
+	Targets    []string `json:"targets"`
+	Prune      bool     `json:"prune"`
+	Initialize bool     `json:"initialize"`
+	DryRun     bool     `json:"dryrun"`
+	// VerifyBeforePrune is like -verify-before-prune.
+	VerifyBeforePrune bool `json:"verify_before_prune"`
+	// ToSnapshot is like -to-snapshot.
+	ToSnapshot string `json:"to_snapshot"`
+	// Label names the backup routine the request belongs to.
+	Label string `json:"label"`
+}
+
+// batchResult is the result of a batchRequest written by batch.
+type batchResult struct {
+	ID string `json:"id,omitempty"`
+	// RunID identifies the run in the catalog of the state file.
+	RunID string `json:"run_id"`
+	Label string `json:"label,omitempty"`
+	// Error is set if the request was invalid, or source is not cloneable
+	// to targets. If set, no targets were cloned.
+	Error string `json:"error,omitempty"`
+	// Warnings are the warnings of preflight checks. If the batch is
+	// strict and there are warnings, Error is also set.
+	Warnings []string            `json:"warnings,omitempty"`
+	Targets  []batchTargetResult `json:"targets,omitempty"`
+}
+
+type batchTargetResult struct {
+	Target          string  `json:"target"`
+	OK              bool    `json:"ok"`
+	Error           string  `json:"error,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	// Diagnosis is the likely cause of a failed restore that reported
+	// device I/O errors, e.g. "suspect hardware".
+	Diagnosis string `json:"diagnosis,omitempty"`
+	// Health is the health of the target's disk sampled during the
+	// restore, if it was monitored.
+	Health *diskhealth.Report `json:"health,omitempty"`
+}
+
+// batch reads newline-delimited JSON clone requests from stdin and writes a
+// newline-delimited JSON result for each to stdout. Requests are processed in
+// order, one at a time. Batch mode never prompts for confirmation.
+func batch(args []string) error {
+	fs := flag.NewFlagSet("batch", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	auditPath := fs.String("audit-log", audit.DefaultPath, `Path to the append-only log of renames, snapshot deletions, erases, and destructive restores.`)
+	wait := fs.Bool("wait", false, `If true, wait for other invocations using the same source or targets to finish.
+If false (default), requests fail if another invocation is using the source or any of the targets of the request.`)
+	globalLock := fs.Bool("global-lock", false, `If true, also wait for (or fail because of) any other invocation that sets -global-lock, regardless of the volumes it is using.`)
+	strict := fs.Bool("strict", false, `If true, requests fail if preflight checks warn about anything, e.g. a stale source snapshot.
+If false (default), warnings are reported in results, and targets are cloned anyway.`)
+	healthInterval := fs.Duration("health-interval", time.Minute, `Interval at which to sample the I/O error counters and temperature of targets' disks during restores, included in results.
+If 0, disks are not monitored.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s batch [-wait] [-global-lock] [-strict] [-health-interval <duration>] [-state <path>] [-audit-log <path>]
+
+Reads newline-delimited JSON clone requests from stdin, for example:
+  {"id": "1", "source": "/Volumes/source", "targets": ["/Volumes/target"], "prune": true}
+and writes a JSON result for each request to stdout. Progress is written to
+stderr. Requests may also set "initialize", "dryrun", "verify_before_prune",
+"to_snapshot", and "label".
+
+Batch mode does not ask for confirmation before modifying targets.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	stdout := io.MultiWriter(os.Stderr, logger)
+	b := batcher{
+		statePath:      *statePath,
+		auditPath:      *auditPath,
+		lockDir:        lock.DefaultDir,
+		wait:           *wait,
+		globalLock:     *globalLock,
+		strict:         *strict,
+		healthInterval: *healthInterval,
+		du:             newDiskUtil(),
+		asr:            asr.New(append(cloneASROptions(), asr.Stdout(stdout))...),
+		kc:             keychain.New(),
+		stdout:         stdout,
+	}
+	return b.run(context.Background(), os.Stdin, os.Stdout)
+}
+
+// batcher processes batch requests, reusing the same DiskUtil, ASR, and
+// Keychain for all requests.
+type batcher struct {
+	statePath  string
+	auditPath  string
+	lockDir    string
+	wait       bool
+	globalLock bool
+	// strict is true if requests fail on preflight warnings.
+	strict bool
+	// healthInterval is the interval at which targets' disks are
+	// sampled during restores, or 0 if they are not monitored.
+	healthInterval time.Duration
+	du             diskutil.DiskUtil
+	asr            asr.ASR
+	// kc has the passphrases of locked volumes.
+	kc keychain.Keychain
+	// stdout is where the human-readable output of clones is written.
+	stdout io.Writer
+}
+
+func (b batcher) run(ctx context.Context, r io.Reader, w io.Writer) error {
+	enc := json.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		var req batchRequest
+		var result batchResult
+		if err := json.Unmarshal(line, &req); err != nil {
+			result.Error = fmt.Sprintf("invalid request: %v", err)
+		} else {
+			result = b.clone(ctx, req)
+		}
+		if err := enc.Encode(result); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func (b batcher) clone(ctx context.Context, req batchRequest) batchResult {
+	result := batchResult{
+		ID:    req.ID,
+		RunID: runIDs.NewID(),
+		Label: req.Label,
+	}
+	if err := validateBatchRequest(req); err != nil {
+		result.Error = fmt.Sprintf("invalid request: %v", err)
+		return result
+	}
+
+	du, r := b.du, b.asr
+	if req.DryRun {
+		du = diskutil.NewDryRun(du)
+		r = asr.NewDryRun(asr.Stdout(b.stdout))
+	} else {
+		du, r = auditTools(du, r, b.auditPath, result.RunID, req.Label)
+	}
+	release, err := prepareTargets(ctx, b.stdout, du, b.kc, req.Source, req.Targets, prepareOptions{
+		lockDir:    b.lockDir,
+		globalLock: b.globalLock,
+		wait:       b.wait,
+		// Dry runs do not unlock volumes, as they only print changes.
+		unlock: !req.DryRun,
+		policy: true,
+	})
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer release()
+
+	opts := append(cloneClonerOptions(b.stdout, !req.DryRun),
+		cloner.Prune(req.Prune),
+		cloner.VerifyBeforePrune(req.VerifyBeforePrune),
+		cloner.InitializeTargets(req.Initialize),
+	)
+	if req.ToSnapshot != "" {
+		opts = append(opts, cloner.ToSnapshot(req.ToSnapshot))
+	}
+	c := cloner.New(du, r, opts...)
+	plan, err := c.Preflight(ctx, req.Source, req.Targets...)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	warnings, err := preflightWarnings(b.statePath, plan)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	for _, w := range warnings {
+		result.Warnings = append(result.Warnings, w.String())
+	}
+	if err := checkWarnings(b.stdout, warnings, b.strict); err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var clones []clone
+	for _, target := range req.Targets {
+		fmt.Fprintf(b.stdout, "Cloning %q to %q...\n", req.Source, target)
+		started := clk.Now()
+		var interval time.Duration
+		if !req.DryRun {
+			interval = b.healthInterval
+		}
+		stopMonitor := monitorHealth(ctx, b.stdout, b.du, target, interval)
+		err := c.ClonePlanned(ctx, plan, target)
+		duration := clk.Now().Sub(started)
+		targetResult := batchTargetResult{
+			Target:          target,
+			OK:              err == nil,
+			DurationSeconds: duration.Seconds(),
+			Health:          stopMonitor(),
+		}
+		if targetResult.Health != nil {
+			fmt.Fprint(b.stdout, targetResult.Health)
+		}
+		if err != nil {
+			targetResult.Error = err.Error()
+			if r, ok := diagnose.New().Diagnose(err); ok && r.Verdict != diagnose.NoIOErrors {
+				targetResult.Diagnosis = string(r.Verdict)
+				fmt.Fprint(b.stdout, r)
+			}
+		} else {
+			clones = append(clones, clone{
+				target:      target,
+				started:     started,
+				duration:    duration,
+				initialized: req.Initialize,
+			})
+		}
+		result.Targets = append(result.Targets, targetResult)
+	}
+	if !req.DryRun {
+		if err := recordClones(ctx, b.statePath, du, result.RunID, req.Label, req.Source, clones); err != nil {
+			fmt.Fprintln(b.stdout, "Warning: failed to record completed clones:", err)
+		}
+	}
+	return result
+}
+
+func validateBatchRequest(req batchRequest) error {
+	if req.Source == "" {
+		return errors.New("source is required")
+	}
+	if len(req.Targets) == 0 {
+		return errors.New("at least one target is required")
+	}
+	if err := validateLabel(req.Label); err != nil {
+		return err
+	}
+	return nil
+}
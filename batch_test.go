@@ -0,0 +1,231 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// fakeDiskUtil has volumes and their snapshots, newest first, by UUID.
+type fakeDiskUtil struct {
+	diskutil.DiskUtil
+
+	volumes   map[string]diskutil.VolumeInfo
+	snapshots map[string]diskutil.SnapshotList
+}
+
+func (du *fakeDiskUtil) Info(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	info, ok := du.volumes[volume]
+	if !ok {
+		return diskutil.VolumeInfo{}, fmt.Errorf("no volume %q", volume)
+	}
+	return info, nil
+}
+
+func (du *fakeDiskUtil) ListSnapshots(ctx context.Context, volume diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
+	return du.snapshots[volume.UUID], nil
+}
+
+func (du *fakeDiskUtil) Rename(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	return nil
+}
+
+// fakeASR restores snapshots between the volumes of du, failing restores to
+// the targets in errs with their error. attempts counts the restores to each
+// target.
+type fakeASR struct {
+	du       *fakeDiskUtil
+	errs     map[string][]error
+	attempts map[string]int
+}
+
+func (r *fakeASR) Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return r.DestructiveRestore(ctx, source, target, to)
+}
+
+func (r *fakeASR) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	if r.attempts == nil {
+		r.attempts = make(map[string]int)
+	}
+	r.attempts[target.UUID]++
+	if errs := r.errs[target.UUID]; len(errs) > 0 {
+		r.errs[target.UUID] = errs[1:]
+		if errs[0] != nil {
+			return errs[0]
+		}
+	}
+	r.du.snapshots[target.UUID] = append(diskutil.SnapshotList{to}, r.du.snapshots[target.UUID]...)
+	return nil
+}
+
+func (r *fakeASR) Check(ctx context.Context) error {
+	return nil
+}
+
+var (
+	batchSnap1 = diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	batchSnap2 = diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+)
+
+// newBatchVolumes returns a fakeDiskUtil with a source volume, and targets
+// target-1 and target-2 that have a snapshot in common with it.
+func newBatchVolumes() *fakeDiskUtil {
+	du := &fakeDiskUtil{
+		volumes:   make(map[string]diskutil.VolumeInfo),
+		snapshots: make(map[string]diskutil.SnapshotList),
+	}
+	for _, name := range []string{"source", "target-1", "target-2"} {
+		du.volumes[name+"-uuid"] = diskutil.VolumeInfo{
+			Name:           name,
+			UUID:           name + "-uuid",
+			FileSystemType: "apfs",
+			FileSystem:     "APFS",
+			Writable:       true,
+		}
+		du.snapshots[name+"-uuid"] = diskutil.SnapshotList{batchSnap1}
+	}
+	du.snapshots["source-uuid"] = diskutil.SnapshotList{batchSnap2, batchSnap1}
+	return du
+}
+
+func newTestBatcher(t *testing.T, du *fakeDiskUtil, r *fakeASR) batcher {
+	dir := t.TempDir()
+	return batcher{
+		statePath: filepath.Join(dir, "state.json"),
+		auditPath: filepath.Join(dir, "audit.log"),
+		lockDir:   filepath.Join(dir, "locks"),
+		du:        du,
+		asr:       r,
+		stdout:    io.Discard,
+	}
+}
+
+func TestBatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []string
+		restores map[string][]error
+		want     []batchResult
+	}{
+		{
+			name:     "malformed JSON",
+			requests: []string{`{"id": "1", "source": `},
+			want: []batchResult{
+				{Error: "invalid request: unexpected end of JSON input"},
+			},
+		},
+		{
+			name:     "wrong type",
+			requests: []string{`{"id": "1", "targets": "target-1-uuid"}`},
+			want: []batchResult{
+				{Error: "invalid request: json: cannot unmarshal string into Go struct field batchRequest.targets of type []string"},
+			},
+		},
+		{
+			name:     "no source",
+			requests: []string{`{"id": "1", "targets": ["target-1-uuid"]}`},
+			want: []batchResult{
+				{ID: "1", Error: "invalid request: source is required"},
+			},
+		},
+		{
+			name:     "no targets",
+			requests: []string{`{"id": "1", "source": "source-uuid"}`},
+			want: []batchResult{
+				{ID: "1", Error: "invalid request: at least one target is required"},
+			},
+		},
+		{
+			name:     "invalid label",
+			requests: []string{`{"id": "1", "source": "source-uuid", "targets": ["target-1-uuid"], "label": "a b"}`},
+			want: []batchResult{
+				{ID: "1", Label: "a b", Error: `invalid request: invalid label "a b": must only contain letters, digits, '.', '_', and '-'`},
+			},
+		},
+		{
+			name:     "unknown target",
+			requests: []string{`{"id": "1", "source": "source-uuid", "targets": ["missing-uuid"]}`},
+			want: []batchResult{
+				{ID: "1", Error: `invalid target volume: no volume "missing-uuid"`},
+			},
+		},
+		{
+			name:     "cloned",
+			requests: []string{`{"id": "1", "source": "source-uuid", "targets": ["target-1-uuid", "target-2-uuid"]}`},
+			want: []batchResult{
+				{
+					ID: "1",
+					Targets: []batchTargetResult{
+						{Target: "target-1-uuid", OK: true},
+						{Target: "target-2-uuid", OK: true},
+					},
+				},
+			},
+		},
+		{
+			name:     "one target failed",
+			requests: []string{`{"id": "1", "source": "source-uuid", "targets": ["target-1-uuid", "target-2-uuid"]}`},
+			restores: map[string][]error{"target-1-uuid": {errors.New("asr failed")}},
+			want: []batchResult{
+				{
+					ID: "1",
+					Targets: []batchTargetResult{
+						{Target: "target-1-uuid", Error: "error restoring: asr failed"},
+						{Target: "target-2-uuid", OK: true},
+					},
+				},
+			},
+		},
+		{
+			name: "each request",
+			requests: []string{
+				`{"id": "1", "source": "source-uuid", "targets": ["target-1-uuid"]}`,
+				``,
+				`not JSON`,
+				`{"id": "2", "source": "source-uuid", "targets": ["target-2-uuid"], "label": "nightly"}`,
+			},
+			want: []batchResult{
+				{ID: "1", Targets: []batchTargetResult{{Target: "target-1-uuid", OK: true}}},
+				{Error: "invalid request: invalid character 'o' in literal null (expecting 'u')"},
+				{ID: "2", Label: "nightly", Targets: []batchTargetResult{{Target: "target-2-uuid", OK: true}}},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			du := newBatchVolumes()
+			b := newTestBatcher(t, du, &fakeASR{du: du, errs: test.restores})
+			var out bytes.Buffer
+			if err := b.run(context.Background(), strings.NewReader(strings.Join(test.requests, "\n")), &out); err != nil {
+				t.Fatalf("run returned unexpected error: %v, want: nil", err)
+			}
+			var got []batchResult
+			dec := json.NewDecoder(&out)
+			for dec.More() {
+				var result batchResult
+				if err := dec.Decode(&result); err != nil {
+					t.Fatalf("run wrote an invalid result: %v", err)
+				}
+				got = append(got, result)
+			}
+			opts := []cmp.Option{
+				cmpopts.IgnoreFields(batchResult{}, "RunID", "Warnings"),
+				cmpopts.IgnoreFields(batchTargetResult{}, "DurationSeconds"),
+			}
+			if diff := cmp.Diff(test.want, got, opts...); diff != "" {
+				t.Errorf("run wrote unexpected results. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
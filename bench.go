@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/hdiutil"
+)
+
+// benchTunings are the asr buffer settings tried by bench-asr, starting with
+// asr's defaults.
+var benchTunings = []asr.Tuning{
+	{},
+	{Buffers: 8, BufferSize: "1m"},
+	{Buffers: 16, BufferSize: "1m"},
+	{Buffers: 4, BufferSize: "8m"},
+	{Buffers: 8, BufferSize: "8m"},
+}
+
+// benchASR restores between scratch disk images with each of benchTunings,
+// and saves the fastest to the config file.
+func benchASR(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("bench-asr", flag.ExitOnError)
+	dir := fs.String("dir", os.TempDir(), `Directory to create the scratch disk images in, e.g. a directory on a target disk, to benchmark restores to that disk.`)
+	size := fs.String("size", "2g", `Size of the scratch disk images, in the syntax of hdiutil, e.g. 2g. Larger images give more accurate results, but take longer.`)
+	configPath := fs.String("config", config.DefaultPath, `Path to the JSON configuration file the fastest buffers are saved to.`)
+	dryrun := fs.Bool("dryrun", false, `If true, only print the results. Does not modify the config file.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s bench-asr [-dir <path>] [-size <size>] [-config <path>] [-dryrun]
+
+Benchmarks restores with several asr buffer settings, by restoring between two
+scratch disk images, and saves the fastest to the config file. Clones then use
+it by default.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp(*dir, "bench-asr-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	hu := hdiutil.New()
+	source, detachSource, err := attachScratchImage(hu, filepath.Join(tmp, "source.dmg"), *size)
+	if err != nil {
+		return err
+	}
+	defer detachSource()
+	target, detachTarget, err := attachScratchImage(hu, filepath.Join(tmp, "target.dmg"), *size)
+	if err != nil {
+		return err
+	}
+	defer detachTarget()
+
+	fmt.Println("Filling source image with random data...")
+	if err := fillVolume(source.MountPoint); err != nil {
+		return err
+	}
+	// asr copies blocks from unmounted sources.
+	if err := diskutil.New().Unmount(ctx, source); err != nil {
+		return err
+	}
+
+	var best asr.Tuning
+	var bestDuration time.Duration
+	for _, t := range benchTunings {
+		started := clk.Now()
+		if err := asr.BlockRestore(ctx, source, target, asr.Buffers(t), asr.Stdout(io.Discard)); err != nil {
+			return fmt.Errorf("error restoring with %s: %w", t, err)
+		}
+		d := clk.Now().Sub(started)
+		fmt.Printf("  %s: %s\n", t, d.Round(time.Millisecond))
+		if bestDuration == 0 || d < bestDuration {
+			best, bestDuration = t, d
+		}
+	}
+	fmt.Printf("Fastest: %s.\n", best)
+	if *dryrun {
+		return nil
+	}
+	cfg.ASR = best
+	if err := cfg.Save(*configPath); err != nil {
+		return err
+	}
+	fmt.Printf("Saved to %s; restores now use it by default.\n", *configPath)
+	return nil
+}
+
+// attachScratchImage creates and attaches an empty APFS disk image at path
+// image, and returns its volume and a func that detaches it.
+func attachScratchImage(hu hdiutil.HDIUtil, image, size string) (diskutil.VolumeInfo, func(), error) {
+	name := filepath.Base(image)
+	if err := hu.Create(image, hdiutil.CreateOptions{Size: size, FileSystem: "APFS", VolumeName: name}); err != nil {
+		return diskutil.VolumeInfo{}, nil, fmt.Errorf("error creating scratch image: %w", err)
+	}
+	attached, err := hu.Attach(image, hdiutil.AttachOptions{NoBrowse: true})
+	if err != nil {
+		return diskutil.VolumeInfo{}, nil, fmt.Errorf("error attaching scratch image: %w", err)
+	}
+	detach := func() {
+		if err := hu.Detach(attached.Entities[0].Device, true); err != nil {
+			fmt.Fprintln(os.Stderr, "Warning: error detaching scratch image:", err)
+		}
+	}
+	vol, err := attached.Volume()
+	if err != nil {
+		detach()
+		return diskutil.VolumeInfo{}, nil, err
+	}
+	return diskutil.VolumeInfo{Name: name, Device: vol.Device, MountPoint: vol.MountPoint}, detach, nil
+}
+
+// fillVolume writes random data to the volume mounted at mountPoint until it
+// is full, so that restores copy as much data as possible.
+func fillVolume(mountPoint string) error {
+	f, err := os.Create(filepath.Join(mountPoint, "random"))
+	if err != nil {
+		return err
+	}
+	// Random data cannot be compressed, so it is copied in full.
+	_, err = io.Copy(f, rand.New(rand.NewSource(1)))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil && !errors.Is(err, syscall.ENOSPC) {
+		return fmt.Errorf("error filling scratch volume: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// showCatalog prints the completed clones recorded in the state file.
+func showCatalog(args []string) error {
+	fs := flag.NewFlagSet("catalog", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	label := fs.String("label", "", `If set, only show clones in runs with this label, e.g. weekly-offsite.`)
+	target := fs.String("target", "", `If set, only show clones to this paired target, identified by volume UUID or name.`)
+	fs.StringVar(configPath, "config", config.DefaultPath, `Path to the JSON configuration file configuring how sizes and times are formatted.`)
+	verbose := fs.Bool("verbose", false, `If true, also print the CPU time and peak memory each clone used, by this tool and by asr, e.g. to decide whether clones need to run at a lower priority.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s catalog [-state <path>] [-label <label>] [-target <target>] [-config <path>] [-verbose]
+
+Prints the completed clones recorded in the state file, oldest first.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	f, err := loadFormatter(*configPath)
+	if err != nil {
+		return err
+	}
+	entries := st.Catalog
+	if *label != "" {
+		entries = st.Labeled(*label)
+	}
+	targetUUID := ""
+	if *target != "" {
+		pairing, err := st.Pairing(*target)
+		if err != nil {
+			return err
+		}
+		targetUUID = pairing.TargetUUID
+	}
+	names := make(map[string]string) // Map of target UUID to paired name.
+	for _, p := range st.Pairings {
+		names[p.TargetUUID] = p.TargetName
+	}
+	for _, e := range entries {
+		if targetUUID != "" && e.TargetUUID != targetUUID {
+			continue
+		}
+		fmt.Printf("%s  %s  %q (%s) from %s in %s\n",
+			f.Time(e.Started), describeRun(e.RunID, e.Label),
+			names[e.TargetUUID], e.TargetUUID, e.SourceUUID, e.Duration.Round(time.Second))
+		if e.Retries > 0 {
+			fmt.Printf("\tRestore retried %d time(s) after transient asr errors\n", e.Retries)
+		}
+		if *verbose && e.Resources != nil {
+			r := e.Resources
+			fmt.Printf("\tCPU: %s tool, %s asr; peak memory: %s tool, %s asr\n",
+				r.ToolCPU.Round(time.Millisecond), r.ASRCPU.Round(time.Millisecond),
+				f.Bytes(r.ToolPeakMemory), f.Bytes(r.ASRPeakMemory))
+		}
+	}
+	return nil
+}
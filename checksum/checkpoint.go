@@ -0,0 +1,79 @@
+package checksum
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkpointInterval is the number of files hashed between saves of the
+// checkpoint.
+const checkpointInterval = 100
+
+// checkpoint is the progress of HashFiles, saved so that an interrupted
+// HashFiles resumes where it left off instead of rehashing every file.
+type checkpoint struct {
+	Algorithm Algorithm `json:"algorithm"`
+	Root      string    `json:"root"`
+	// Sums are the checksums of the files hashed so far.
+	Sums map[string]string `json:"sums"`
+	// Failures are the errors hashing files that could not be hashed.
+	// They are hashed again when resuming.
+	Failures map[string]string `json:"failures,omitempty"`
+}
+
+// loadCheckpoint reads the checkpoint of hashing files in root with a from
+// path. If no file exists at path, or it was saved while hashing other files
+// or with another algorithm, an empty checkpoint is returned.
+func loadCheckpoint(path string, a Algorithm, root string) (*checkpoint, error) {
+	empty := &checkpoint{
+		Algorithm: a,
+		Root:      root,
+		Sums:      make(map[string]string),
+		Failures:  make(map[string]string),
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return empty, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading checkpoint: %w", err)
+	}
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		return nil, fmt.Errorf("error parsing checkpoint %q: %w", path, err)
+	}
+	if cp.Algorithm != a || cp.Root != root || cp.Sums == nil {
+		return empty, nil
+	}
+	// Failed files are retried.
+	cp.Failures = make(map[string]string)
+	return &cp, nil
+}
+
+// save writes the checkpoint to path atomically, so that an interruption
+// while saving never loses the previous checkpoint.
+func (cp *checkpoint) save(path string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".checkpoint-*.json")
+	if err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error writing checkpoint: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+// Package checksum implements hashing files with a choice of hash algorithms,
+// using multiple workers in parallel. It is used to compare the contents of
+// files in source and target volumes. Hashing can be checkpointed, so that
+// hashing a large volume resumes where it left off if interrupted.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"lukechampine.com/blake3"
+)
+
+// Algorithm is a hash algorithm.
+type Algorithm string
+
+// Supported hash algorithms.
+const (
+	SHA256 Algorithm = "sha256"
+	// XXHash is xxHash64. It is not cryptographically secure, but is much
+	// faster than the other algorithms.
+	XXHash Algorithm = "xxhash"
+	BLAKE3 Algorithm = "blake3"
+)
+
+// Algorithms are all supported hash algorithms.
+var Algorithms = []Algorithm{SHA256, XXHash, BLAKE3}
+
+// ParseAlgorithm returns the Algorithm with the given name.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	for _, a := range Algorithms {
+		if string(a) == name {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported hash algorithm %q, want one of %v", name, Algorithms)
+}
+
+// New returns a new hash.Hash computing the algorithm's checksum.
+func (a Algorithm) New() (hash.Hash, error) {
+	switch a {
+	case SHA256:
+		return sha256.New(), nil
+	case XXHash:
+		return xxhash.New(), nil
+	case BLAKE3:
+		return blake3.New(32, nil), nil
+	}
+	return nil, fmt.Errorf("unsupported hash algorithm %q", a)
+}
+
+// Hasher hashes files in parallel.
+type Hasher struct {
+	algorithm Algorithm
+	workers   int
+	progress  func(done, total int)
+	// If set, the path of the checkpoint file.
+	checkpointPath string
+}
+
+// Option configures Hasher.
+type Option func(*Hasher)
+
+// Workers returns an Option that sets the number of files hashed in parallel.
+// By default, one file is hashed per CPU.
+func Workers(n int) Option {
+	return func(h *Hasher) {
+		h.workers = n
+	}
+}
+
+// Progress returns an Option that calls f each time a file has been hashed,
+// with the number of files hashed so far and the total number of files. f is
+// never called concurrently.
+func Progress(f func(done, total int)) Option {
+	return func(h *Hasher) {
+		h.progress = f
+	}
+}
+
+// Checkpoint returns an Option that saves the progress of HashFiles to a file
+// at path, including the files that could not be hashed, so that an
+// interrupted HashFiles resumes from the file. Files already hashed are not
+// hashed again, but are reported as done by Progress. The file is removed once
+// all files are hashed.
+func Checkpoint(path string) Option {
+	return func(h *Hasher) {
+		h.checkpointPath = path
+	}
+}
+
+// NewHasher returns a Hasher that hashes files with the given algorithm.
+func NewHasher(a Algorithm, opts ...Option) Hasher {
+	h := Hasher{
+		algorithm: a,
+		workers:   runtime.NumCPU(),
+	}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	if h.workers < 1 {
+		h.workers = 1
+	}
+	return h
+}
+
+// HashFiles returns the hex-encoded checksum of each of paths, which are
+// relative to root. If any file cannot be hashed, an error is returned.
+func (h Hasher) HashFiles(root string, paths []string) (map[string]string, error) {
+	if _, err := h.algorithm.New(); err != nil {
+		return nil, err
+	}
+	cp := &checkpoint{
+		Sums:     make(map[string]string),
+		Failures: make(map[string]string),
+	}
+	if h.checkpointPath != "" {
+		var err error
+		cp, err = loadCheckpoint(h.checkpointPath, h.algorithm, root)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var pending []string
+	for _, path := range paths {
+		if _, ok := cp.Sums[path]; !ok {
+			pending = append(pending, path)
+		}
+	}
+
+	type result struct {
+		path string
+		sum  string
+		err  error
+	}
+	jobs := make(chan string)
+	results := make(chan result)
+	var wg sync.WaitGroup
+	for i := 0; i < h.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range jobs {
+				sum, err := h.hashFile(filepath.Join(root, path))
+				results <- result{path: path, sum: sum, err: err}
+			}
+		}()
+	}
+	go func() {
+		for _, path := range pending {
+			jobs <- path
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	done := len(paths) - len(pending)
+	for r := range results {
+		done++
+		if h.progress != nil {
+			h.progress(done, len(paths))
+		}
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("error hashing %q: %w", r.path, r.err)
+			}
+			cp.Failures[r.path] = r.err.Error()
+		} else {
+			cp.Sums[r.path] = r.sum
+		}
+		if h.checkpointPath != "" && done%checkpointInterval == 0 {
+			if err := cp.save(h.checkpointPath); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	if firstErr != nil {
+		if h.checkpointPath != "" {
+			cp.save(h.checkpointPath)
+		}
+		return nil, firstErr
+	}
+	if h.checkpointPath != "" {
+		if err := os.Remove(h.checkpointPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error removing checkpoint: %w", err)
+		}
+	}
+	sums := make(map[string]string)
+	for _, path := range paths {
+		sums[path] = cp.Sums[path]
+	}
+	return sums, nil
+}
+
+func (h Hasher) hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hash, err := h.algorithm.New()
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,179 @@
+package checksum
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for path, contents := range files {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestHashFiles(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"empty":     "",
+		"dir/hello": "hello world",
+	})
+	tests := []struct {
+		algorithm Algorithm
+		want      map[string]string
+	}{
+		{
+			algorithm: SHA256,
+			want: map[string]string{
+				"empty":     "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				"dir/hello": "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+			},
+		},
+		{
+			algorithm: XXHash,
+			want: map[string]string{
+				"empty":     "ef46db3751d8e999",
+				"dir/hello": "45ab6734b21e6968",
+			},
+		},
+		{
+			algorithm: BLAKE3,
+			want: map[string]string{
+				"empty":     "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262",
+				"dir/hello": "d74981efa70a0c880b8d8c1985d075dbcbf679b99a5f9914e5aaf96b831a9e24",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(string(test.algorithm), func(t *testing.T) {
+			h := NewHasher(test.algorithm, Workers(2))
+			got, err := h.HashFiles(root, []string{"empty", "dir/hello"})
+			if err != nil {
+				t.Fatalf("HashFiles returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("HashFiles returned unexpected checksums. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestHashFiles_Progress(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a": "a",
+		"b": "b",
+		"c": "c",
+	})
+	var got []int
+	h := NewHasher(SHA256, Progress(func(done, total int) {
+		if total != 3 {
+			t.Errorf("Progress called with total: %d, want: 3", total)
+		}
+		got = append(got, done)
+	}))
+	if _, err := h.HashFiles(root, []string{"a", "b", "c"}); err != nil {
+		t.Fatalf("HashFiles returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff([]int{1, 2, 3}, got); diff != "" {
+		t.Errorf("HashFiles reported unexpected progress. -want +got:\n%s", diff)
+	}
+}
+
+func TestHashFiles_Errors(t *testing.T) {
+	root := writeFiles(t, map[string]string{"exists": "foo"})
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+		paths     []string
+	}{
+		{
+			name:      "missing file",
+			algorithm: SHA256,
+			paths:     []string{"exists", "does-not-exist"},
+		},
+		{
+			name:      "unsupported algorithm",
+			algorithm: Algorithm("md4"),
+			paths:     []string{"exists"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewHasher(test.algorithm)
+			if _, err := h.HashFiles(root, test.paths); err == nil {
+				t.Error("HashFiles returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestHashFiles_Checkpoint(t *testing.T) {
+	root := writeFiles(t, map[string]string{
+		"a": "a",
+		"b": "b",
+	})
+	cpPath := filepath.Join(t.TempDir(), "checkpoint.json")
+	paths := []string{"a", "b", "c"}
+
+	// Interrupt hashing with a file that cannot be hashed yet.
+	if _, err := NewHasher(SHA256, Checkpoint(cpPath)).HashFiles(root, paths); err == nil {
+		t.Fatal("HashFiles returned unexpected error: nil, want: non-nil")
+	}
+	if _, err := os.Stat(cpPath); err != nil {
+		t.Fatalf("HashFiles did not save checkpoint: %v", err)
+	}
+
+	// Files already hashed are not hashed again, so changing them does not
+	// change their checksums.
+	if err := os.WriteFile(filepath.Join(root, "a"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "c"), []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var progress []int
+	h := NewHasher(SHA256, Checkpoint(cpPath), Progress(func(done, total int) {
+		progress = append(progress, done)
+	}))
+	got, err := h.HashFiles(root, paths)
+	if err != nil {
+		t.Fatalf("HashFiles returned unexpected error: %v, want: nil", err)
+	}
+	want := map[string]string{
+		"a": "ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+		"b": "3e23e8160039594a33894f6564e1b1348bbd7a0088d42c4acb73eeaed59c009d",
+		"c": "2e7d2c03a9507ae265ecf5b5356885a53393a2029d241394997265a1a25aefc6",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("HashFiles returned unexpected checksums. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff([]int{3}, progress); diff != "" {
+		t.Errorf("HashFiles reported unexpected progress. -want +got:\n%s", diff)
+	}
+	if _, err := os.Stat(cpPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("HashFiles did not remove checkpoint once done (stat error: %v)", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	for _, a := range Algorithms {
+		got, err := ParseAlgorithm(string(a))
+		if err != nil || got != a {
+			t.Errorf("ParseAlgorithm(%q) returned (%q, %v), want: (%q, nil)", a, got, err, a)
+		}
+	}
+	if _, err := ParseAlgorithm("md5"); err == nil {
+		t.Error("ParseAlgorithm(\"md5\") returned unexpected error: nil, want: non-nil")
+	}
+}
@@ -0,0 +1,134 @@
+// Package cliio implements the terminal handling shared by commands: detecting
+// whether output is a terminal, and styling it with ANSI colors when it is.
+//
+// Colors are disabled when output is not a terminal, when the NO_COLOR
+// environment variable is set (see https://no-color.org), and when TERM is
+// dumb, so that logs and pipes never contain escape sequences.
+package cliio
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+)
+
+// IsTerminal returns true if f is a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Colors styles text printed to a single output. The zero value does not style
+// text.
+type Colors struct {
+	enabled bool
+}
+
+// For returns the Colors of output printed to f: enabled if f is a terminal,
+// unless noColor is true (e.g. with -no-color), NO_COLOR is set, or TERM is
+// dumb.
+func For(f *os.File, noColor bool) Colors {
+	return newColors(IsTerminal(f), noColor, os.Getenv)
+}
+
+func newColors(terminal, noColor bool, getenv func(string) string) Colors {
+	// NO_COLOR disables colors when set to any non-empty value.
+	return Colors{enabled: terminal && !noColor && getenv("NO_COLOR") == "" && getenv("TERM") != "dumb"}
+}
+
+// Enabled returns true if text is styled.
+func (c Colors) Enabled() bool {
+	return c.enabled
+}
+
+const reset = "\x1b[0m"
+
+func (c Colors) style(code, s string) string {
+	if !c.enabled || s == "" {
+		return s
+	}
+	return code + s + reset
+}
+
+// Bold returns s in bold, e.g. for headings.
+func (c Colors) Bold(s string) string {
+	return c.style("\x1b[1m", s)
+}
+
+// Red returns s in red, e.g. for errors.
+func (c Colors) Red(s string) string {
+	return c.style("\x1b[31m", s)
+}
+
+// Yellow returns s in yellow, e.g. for warnings.
+func (c Colors) Yellow(s string) string {
+	return c.style("\x1b[33m", s)
+}
+
+// Green returns s in green, e.g. for successes.
+func (c Colors) Green(s string) string {
+	return c.style("\x1b[32m", s)
+}
+
+// highlighter is an io.Writer that styles whole lines by how they start or
+// end.
+type highlighter struct {
+	w      io.Writer
+	colors Colors
+	// blank is true if nothing but whitespace has been written since the
+	// last newline, so that the next text written starts a line.
+	blank bool
+}
+
+// Highlight returns a writer that writes to w, styling lines with colors:
+// errors (starting with "Error:" or "failed to ") in red, warnings (starting
+// with "Warning:") in yellow, completions (starting with "Completed") in green,
+// and phases (ending with "...") in bold. Leading whitespace, e.g. written by
+// an indenting writer, is ignored. Lines are only styled if they are written
+// whole, by a single Write; other lines are written as is. If colors are
+// disabled, Highlight returns w.
+func Highlight(w io.Writer, colors Colors) io.Writer {
+	if !colors.Enabled() {
+		return w
+	}
+	return &highlighter{w: w, colors: colors, blank: true}
+}
+
+func (h *highlighter) Write(p []byte) (int, error) {
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(p, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		text := strings.TrimRight(string(line), "\n")
+		complete := len(text) < len(line)
+		trimmed := strings.TrimLeft(text, " \t")
+		if h.blank && complete && trimmed != "" {
+			indent := text[:len(text)-len(trimmed)]
+			out.WriteString(indent + h.style(trimmed) + "\n")
+		} else {
+			out.Write(line)
+		}
+		h.blank = complete || (h.blank && trimmed == "")
+	}
+	if _, err := h.w.Write(out.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// style styles line by how it starts or ends.
+func (h *highlighter) style(line string) string {
+	switch {
+	case strings.HasPrefix(line, "Error:"), strings.HasPrefix(line, "failed to "):
+		return h.colors.Red(line)
+	case strings.HasPrefix(line, "Warning:"):
+		return h.colors.Yellow(line)
+	case strings.HasPrefix(line, "Completed"):
+		return h.colors.Green(line)
+	case strings.HasSuffix(line, "..."):
+		return h.colors.Bold(line)
+	}
+	return line
+}
@@ -0,0 +1,107 @@
+package cliio
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestNewColors(t *testing.T) {
+	tests := []struct {
+		name     string
+		terminal bool
+		noColor  bool
+		env      map[string]string
+		want     bool
+	}{
+		{
+			name:     "terminal",
+			terminal: true,
+			env:      map[string]string{"TERM": "xterm-256color"},
+			want:     true,
+		},
+		{
+			name: "not a terminal",
+			env:  map[string]string{"TERM": "xterm-256color"},
+		},
+		{
+			name:     "-no-color",
+			terminal: true,
+			noColor:  true,
+		},
+		{
+			name:     "NO_COLOR",
+			terminal: true,
+			env:      map[string]string{"NO_COLOR": "1"},
+		},
+		{
+			name:     "empty NO_COLOR",
+			terminal: true,
+			env:      map[string]string{"NO_COLOR": ""},
+			want:     true,
+		},
+		{
+			name:     "dumb terminal",
+			terminal: true,
+			env:      map[string]string{"TERM": "dumb"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			getenv := func(key string) string {
+				return test.env[key]
+			}
+			if got := newColors(test.terminal, test.noColor, getenv).Enabled(); got != test.want {
+				t.Errorf("newColors(%t, %t, ...).Enabled() = %t, want: %t", test.terminal, test.noColor, got, test.want)
+			}
+		})
+	}
+}
+
+func TestColors(t *testing.T) {
+	on := Colors{enabled: true}
+	if got, want := on.Red("failed"), "\x1b[31mfailed\x1b[0m"; got != want {
+		t.Errorf("Red(failed) = %q, want: %q", got, want)
+	}
+	if got, want := on.Bold(""), ""; got != want {
+		t.Errorf("Bold(\"\") = %q, want: %q", got, want)
+	}
+	var off Colors
+	if got, want := off.Red("failed"), "failed"; got != want {
+		t.Errorf("Red(failed) = %q, want: %q", got, want)
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	var buf bytes.Buffer
+	w := Highlight(&buf, Colors{enabled: true})
+	for _, s := range []string{
+		"Cloning \"/Volumes/source\" to \"/Volumes/target\"...\n",
+		// As written by an indenting writer.
+		"\t", "Warning: target is on the same physical disk as source\n",
+		"\tRestoring to latest snapshot in source...\n\tasr output\n",
+		"\t", "Completed in 12m3s.\n",
+		"Error: ", "written in parts\n",
+		"failed to clone \"/Volumes/source\" to \"/Volumes/target\": asr failed\n",
+	} {
+		if n, err := w.Write([]byte(s)); err != nil || n != len(s) {
+			t.Fatalf("Write(%q) = %d, %v, want: %d, nil", s, n, err, len(s))
+		}
+	}
+	want := "\x1b[1mCloning \"/Volumes/source\" to \"/Volumes/target\"...\x1b[0m\n" +
+		"\t\x1b[33mWarning: target is on the same physical disk as source\x1b[0m\n" +
+		"\t\x1b[1mRestoring to latest snapshot in source...\x1b[0m\n" +
+		"\tasr output\n" +
+		"\t\x1b[32mCompleted in 12m3s.\x1b[0m\n" +
+		"Error: written in parts\n" +
+		"\x1b[31mfailed to clone \"/Volumes/source\" to \"/Volumes/target\": asr failed\x1b[0m\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Highlight wrote %q, want: %q", got, want)
+	}
+}
+
+func TestHighlight_Disabled(t *testing.T) {
+	var buf bytes.Buffer
+	if w := Highlight(&buf, Colors{}); w != &buf {
+		t.Errorf("Highlight(w, Colors{}) = %v, want: w", w)
+	}
+}
@@ -0,0 +1,49 @@
+// Package clock provides the current time and unique IDs behind interfaces, so
+// that time-dependent behavior can be tested with fakes (see
+// testutils/fakeclock) rather than reading time.Now directly.
+package clock
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Clock tells the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// IDGenerator generates unique IDs, e.g. to identify runs.
+type IDGenerator interface {
+	NewID() string
+}
+
+type realClock struct{}
+
+// Real returns a Clock that tells the system time.
+func Real() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+type randomIDs struct{}
+
+// RandomIDs returns an IDGenerator that generates random 128 bit IDs, encoded
+// as hex.
+func RandomIDs() IDGenerator {
+	return randomIDs{}
+}
+
+func (randomIDs) NewID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand only fails if the system's source of randomness
+		// is unavailable, in which case nothing can be done.
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,20 @@
+package clock
+
+import (
+	"testing"
+)
+
+func TestRandomIDs(t *testing.T) {
+	ids := RandomIDs()
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id := ids.NewID()
+		if len(id) != 32 {
+			t.Errorf("NewID() returned %q, want 32 hex characters", id)
+		}
+		if seen[id] {
+			t.Fatalf("NewID() returned duplicate ID %q", id)
+		}
+		seen[id] = true
+	}
+}
@@ -0,0 +1,186 @@
+package cloner
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/snapshotdiff"
+)
+
+// CheckStatus is the outcome of a Check.
+type CheckStatus string
+
+const (
+	// Pass means the rule is satisfied.
+	Pass CheckStatus = "pass"
+	// Warn means the rule is not satisfied, but cloning is still possible,
+	// e.g. because the backup would be less reliable.
+	Warn CheckStatus = "warn"
+	// Fail means the rule is not satisfied, and Preflight fails.
+	Fail CheckStatus = "fail"
+)
+
+// Check is the result of checking a rule of whether a source volume is
+// cloneable to a target volume.
+type Check struct {
+	// Rule identifies the rule, e.g. same-file-system.
+	Rule   string
+	Status CheckStatus
+	// Err describes why the rule is not satisfied, or is nil if Status is
+	// Pass.
+	Err error
+}
+
+func (c Check) String() string {
+	if c.Err == nil {
+		return fmt.Sprintf("%s: %s", c.Status, c.Rule)
+	}
+	return fmt.Sprintf("%s: %s: %v", c.Status, c.Rule, c.Err)
+}
+
+func newCheck(rule string, status CheckStatus, err error) Check {
+	if err == nil {
+		status = Pass
+	}
+	return Check{Rule: rule, Status: status, Err: err}
+}
+
+// VerifyCloneable checks every rule of whether source is cloneable to target,
+// given their snapshots, and returns the result of each rule in the order
+// Preflight checks them. If initialize is true, target is checked as a target
+// to be initialized instead of incrementally cloned to.
+//
+// Unlike Preflight, VerifyCloneable does not stop at the first failure, and
+// does not look up volumes or snapshots, so it can be called as often as
+// needed, e.g. to show which rules pass as the user picks volumes.
+func VerifyCloneable(source, target diskutil.VolumeInfo, sourceSnaps, targetSnaps diskutil.SnapshotList, initialize bool) []Check {
+	checks := append(volumeChecks(source, target), CheckSnapshots(sourceSnaps, targetSnaps, initialize))
+	return append(checks, warningChecks(source, target)...)
+}
+
+// volumeChecks checks the rules of source and target that do not depend on
+// their snapshots.
+func volumeChecks(source, target diskutil.VolumeInfo) []Check {
+	return []Check{
+		CheckSourceAPFS(source),
+		CheckSourceUnlocked(source),
+		CheckDifferentVolumes(source, target),
+		CheckTargetAPFS(target),
+		CheckSameFileSystem(source, target),
+		CheckTargetWritable(target),
+	}
+}
+
+// failed returns true if any of checks failed.
+func failed(checks []Check) bool {
+	for _, check := range checks {
+		if check.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+// warningChecks checks the rules of source and target that only warn.
+func warningChecks(source, target diskutil.VolumeInfo) []Check {
+	return []Check{
+		CheckSeparateDisks(source, target),
+		CheckTargetSMART(target),
+	}
+}
+
+// CheckSourceAPFS checks that source contains an APFS file system.
+func CheckSourceAPFS(source diskutil.VolumeInfo) Check {
+	var err error
+	if source.FileSystemType != "apfs" {
+		err = errors.New("invalid source volume: does not contain an APFS file system")
+	}
+	return newCheck("source-apfs", Fail, err)
+}
+
+// CheckSourceUnlocked checks that source is not a locked encrypted volume,
+// e.g. a FileVault volume whose users have not logged in, whose snapshots
+// cannot be listed or restored from.
+func CheckSourceUnlocked(source diskutil.VolumeInfo) Check {
+	var err error
+	if source.Locked {
+		err = fmt.Errorf("%w: unlock %q, e.g. with `diskutil apfs unlockVolume %s`, or by logging in to it", ErrSourceLocked, source.Name, source.Device)
+	}
+	return newCheck("source-unlocked", Fail, err)
+}
+
+// CheckDifferentVolumes checks that source and target are different volumes.
+func CheckDifferentVolumes(source, target diskutil.VolumeInfo) Check {
+	var err error
+	if source.UUID == target.UUID {
+		err = errors.New("source and target must be different volumes")
+	}
+	return newCheck("different-volumes", Fail, err)
+}
+
+// CheckTargetAPFS checks that target contains an APFS file system.
+func CheckTargetAPFS(target diskutil.VolumeInfo) Check {
+	var err error
+	if target.FileSystemType != "apfs" {
+		err = errors.New("invalid target volume: does not contain an APFS file system")
+	}
+	return newCheck("target-apfs", Fail, err)
+}
+
+// CheckSameFileSystem checks that source and target have the same file
+// system, e.g. both are case-sensitive APFS.
+func CheckSameFileSystem(source, target diskutil.VolumeInfo) Check {
+	var err error
+	// `asr restore` will restore the target volume to the same file system
+	// as source. To be safe, fail to prevent changing the file system
+	// without the user knowing.
+	if source.FileSystem != target.FileSystem {
+		err = fmt.Errorf("invalid source + target combination: source is formatted as %s, but target is formatted as %s", source.FileSystem, target.FileSystem)
+	}
+	return newCheck("same-file-system", Fail, err)
+}
+
+// CheckTargetWritable checks that target is writable.
+func CheckTargetWritable(target diskutil.VolumeInfo) Check {
+	var err error
+	if !target.Writable {
+		err = errors.New("invalid target volume: volume not writable")
+	}
+	return newCheck("target-writable", Fail, err)
+}
+
+// CheckSnapshots checks that target can be cloned to from sourceSnaps. If
+// initialize is true, target must have no snapshots (target-empty).
+// Otherwise, it must have a snapshot in common with source that is older than
+// source's latest snapshot (common-snapshot).
+func CheckSnapshots(sourceSnaps, targetSnaps diskutil.SnapshotList, initialize bool) Check {
+	if initialize {
+		var err error
+		if len(targetSnaps) > 0 {
+			err = ErrTargetHasSnapshots
+		}
+		return newCheck("target-empty", Fail, err)
+	}
+	_, _, err := latestCommonSnapshot(sourceSnaps, targetSnaps, snapshotdiff.LatestInTarget)
+	return newCheck("common-snapshot", Fail, err)
+}
+
+// CheckSeparateDisks warns if source and target are on the same physical
+// disk, so that a disk failure would lose both.
+func CheckSeparateDisks(source, target diskutil.VolumeInfo) Check {
+	var err error
+	if disk, ok := sharedDisk(source, target); ok {
+		err = fmt.Errorf("target is on the same physical disk as source (%s), so a disk failure would lose both", disk)
+	}
+	return newCheck("separate-disks", Warn, err)
+}
+
+// CheckTargetSMART warns if target's disk's S.M.A.R.T. status is failing.
+func CheckTargetSMART(target diskutil.VolumeInfo) Check {
+	var err error
+	if target.SMARTStatus == "Failing" {
+		err = errors.New("disk S.M.A.R.T. status is Failing")
+	}
+	return newCheck("target-smart", Warn, err)
+}
@@ -0,0 +1,171 @@
+package cloner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestVerifyCloneable(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		UUID:           "source-uuid",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		PhysicalStores: []diskutil.PhysicalStore{{Device: "disk0s2"}},
+	}
+	target := diskutil.VolumeInfo{
+		UUID:           "target-uuid",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		PhysicalStores: []diskutil.PhysicalStore{{Device: "disk4s2"}},
+	}
+	common := diskutil.Snapshot{Name: "common-snap", UUID: "common-snap-uuid"}
+	latest := diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+	sourceSnaps := diskutil.SnapshotList{latest, common}
+
+	tests := []struct {
+		name        string
+		source      diskutil.VolumeInfo
+		target      diskutil.VolumeInfo
+		targetSnaps diskutil.SnapshotList
+		initialize  bool
+		// want maps the rules that do not pass to their status.
+		want map[string]CheckStatus
+	}{
+		{
+			name:        "all pass",
+			source:      source,
+			target:      target,
+			targetSnaps: diskutil.SnapshotList{common},
+			want:        map[string]CheckStatus{},
+		},
+		{
+			name:       "initialize",
+			source:     source,
+			target:     target,
+			initialize: true,
+			want:       map[string]CheckStatus{},
+		},
+		{
+			name:   "all failures are reported",
+			source: source,
+			target: func() diskutil.VolumeInfo {
+				v := target
+				v.Writable = false
+				v.FileSystem = "Case-sensitive APFS"
+				return v
+			}(),
+			want: map[string]CheckStatus{
+				"same-file-system": Fail,
+				"target-writable":  Fail,
+				"common-snapshot":  Fail,
+			},
+		},
+		{
+			name:        "initialize target with snapshots",
+			source:      source,
+			target:      target,
+			targetSnaps: diskutil.SnapshotList{common},
+			initialize:  true,
+			want: map[string]CheckStatus{
+				"target-empty": Fail,
+			},
+		},
+		{
+			name:   "same volume",
+			source: source,
+			target: func() diskutil.VolumeInfo {
+				v := source
+				v.Writable = true
+				return v
+			}(),
+			targetSnaps: sourceSnaps,
+			want: map[string]CheckStatus{
+				"different-volumes": Fail,
+				"common-snapshot":   Fail,
+				"separate-disks":    Warn,
+			},
+		},
+		{
+			name: "not APFS",
+			source: func() diskutil.VolumeInfo {
+				v := source
+				v.FileSystemType = "hfs"
+				return v
+			}(),
+			target: func() diskutil.VolumeInfo {
+				v := target
+				v.FileSystemType = "hfs"
+				return v
+			}(),
+			targetSnaps: diskutil.SnapshotList{common},
+			want: map[string]CheckStatus{
+				"source-apfs": Fail,
+				"target-apfs": Fail,
+			},
+		},
+		{
+			name: "locked source",
+			source: func() diskutil.VolumeInfo {
+				v := source
+				v.Encrypted = true
+				v.Locked = true
+				return v
+			}(),
+			target:      target,
+			targetSnaps: diskutil.SnapshotList{common},
+			want: map[string]CheckStatus{
+				"source-unlocked": Fail,
+			},
+		},
+		{
+			name:   "warnings",
+			source: source,
+			target: func() diskutil.VolumeInfo {
+				v := target
+				v.PhysicalStores = source.PhysicalStores
+				v.SMARTStatus = "Failing"
+				return v
+			}(),
+			targetSnaps: diskutil.SnapshotList{common},
+			want: map[string]CheckStatus{
+				"separate-disks": Warn,
+				"target-smart":   Warn,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			checks := VerifyCloneable(test.source, test.target, sourceSnaps, test.targetSnaps, test.initialize)
+			got := make(map[string]CheckStatus)
+			for _, c := range checks {
+				if (c.Status == Pass) != (c.Err == nil) {
+					t.Errorf("check %s has status %s and error %v", c.Rule, c.Status, c.Err)
+				}
+				if c.Status != Pass {
+					got[c.Rule] = c.Status
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("VerifyCloneable returned unexpected statuses. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheckSnapshots_Errors(t *testing.T) {
+	latest := diskutil.Snapshot{Name: "latest-snap", UUID: "latest-snap-uuid"}
+	other := diskutil.Snapshot{Name: "other-snap", UUID: "other-snap-uuid"}
+	check := CheckSnapshots(diskutil.SnapshotList{latest}, diskutil.SnapshotList{other}, false)
+	if !errors.Is(check.Err, ErrNoCommonSnapshot) {
+		t.Errorf("CheckSnapshots returned unexpected error: %v, want: %v", check.Err, ErrNoCommonSnapshot)
+	}
+	check = CheckSnapshots(diskutil.SnapshotList{latest}, diskutil.SnapshotList{other}, true)
+	if !errors.Is(check.Err, ErrTargetHasSnapshots) {
+		t.Errorf("CheckSnapshots returned unexpected error: %v, want: %v", check.Err, ErrTargetHasSnapshots)
+	}
+}
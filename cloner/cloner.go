@@ -2,13 +2,48 @@
 package cloner
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/history"
+	"github.com/voidingwarranties/offsite-apfs-backup/snapshotdiff"
+)
+
+var (
+	// ErrNoCommonSnapshot is returned if source and target have no
+	// snapshots in common, so target cannot be incrementally cloned to.
+	ErrNoCommonSnapshot = snapshotdiff.ErrNoCommonSnapshot
+	// ErrTargetHasSnapshots is returned if a target to be initialized
+	// already has snapshots.
+	ErrTargetHasSnapshots = errors.New("invalid target: target has snapshots - erase the disk before using initialize")
+	// ErrSnapshotTooOld is returned by Preflight if source's latest
+	// snapshot is older than the maximum set by MaxSnapshotAge.
+	ErrSnapshotTooOld = errors.New("latest source snapshot is too old")
+	// ErrPruneSkipped is returned if a target restored by Clone could not
+	// be verified with VerifyBeforePrune, so its common snapshot was not
+	// pruned.
+	ErrPruneSkipped = errors.New("target could not be verified, so its common snapshot was not pruned")
+	// ErrNoSourceSnapshots is returned if source has no snapshots that may
+	// be cloned.
+	ErrNoSourceSnapshots = errors.New("invalid source: no snapshots to clone")
+	// ErrBelowReserve is returned by Preflight if a clone would likely
+	// leave a target's container with less free space than its Reserve.
+	ErrBelowReserve = errors.New("clone would leave target's container below its free space reserve")
+	// ErrInsufficientSpace is returned by Preflight if a target's container
+	// likely does not have enough free space for a clone, which would
+	// otherwise fail partway through the restore.
+	ErrInsufficientSpace = errors.New("insufficient space: target's container does not have enough free space for the clone")
+	// ErrSourceLocked is returned if source is an encrypted volume that is
+	// locked, e.g. a FileVault volume, so its snapshots cannot be read.
+	ErrSourceLocked = errors.New("invalid source: source is an encrypted volume that is locked")
 )
 
 // Option configures Cloner.
@@ -22,6 +57,34 @@ func Prune(prune bool) Option {
 	}
 }
 
+// PruneSource returns an Option that, if prune is true, makes
+// Cloner.PruneSource delete the snapshots that source had in common with
+// targets before they were cloned to, mirroring Prune for source.
+func PruneSource(prune bool) Option {
+	return func(c *Cloner) {
+		c.pruneSource = prune
+	}
+}
+
+// MinKeep returns an Option that, if n is positive, never prunes a target (or
+// source, with PruneSource) below n snapshots. A prune that would leave fewer
+// is skipped, so that a misconfigured policy cannot leave a target with a
+// single snapshot.
+func MinKeep(n int) Option {
+	return func(c *Cloner) {
+		c.minKeep = n
+	}
+}
+
+// Keep returns an Option that, if n is positive, prunes every snapshot from
+// target except the newest n, instead of only the snapshot that source and
+// target had in common. Keep enables PhasePrune even without Prune(true).
+func Keep(n int) Option {
+	return func(c *Cloner) {
+		c.keep = n
+	}
+}
+
 // InitializeTargets returns an Option that, if initTargets is true, changes
 // the behavior of Clone to do a destructive clone of source's latest snapshot
 // to target, rather than a nondestructive incremental clone. To avoid
@@ -33,6 +96,138 @@ func InitializeTargets(initTargets bool) Option {
 	}
 }
 
+// History returns an Option that, if enabled is true, records the snapshots of
+// each target on the target after cloning to it, and returns an error if a
+// target's snapshots no longer match its record when it is next cloned to or
+// verified. See the history package.
+func History(enabled bool) Option {
+	return func(c *Cloner) {
+		c.history = enabled
+	}
+}
+
+// VerifyBeforePrune returns an Option that, if enabled is true, runs
+// PhaseVerify before PhasePrune, and skips the prune if the verification
+// fails, so that the snapshot in common is kept if the clone is suspect.
+func VerifyBeforePrune(enabled bool) Option {
+	return func(c *Cloner) {
+		c.verifyBeforePrune = enabled
+	}
+}
+
+// Phase is a step of Clone.
+type Phase string
+
+const (
+	// PhaseRestore restores target to the latest snapshot in source.
+	PhaseRestore Phase = "restore"
+	// PhaseVerify verifies that the latest snapshot in target is the
+	// latest snapshot in source.
+	PhaseVerify Phase = "verify"
+	// PhasePrune deletes from target the latest snapshot that source and
+	// target had in common before the restore.
+	PhasePrune Phase = "prune"
+)
+
+// Only returns an Option that restricts Clone to the given phases. By default,
+// Clone runs PhaseRestore, and PhasePrune if Prune(true).
+//
+// Running PhasePrune without PhaseRestore prunes from a target that has
+// already been restored: the target must contain the latest snapshot in
+// source, and the snapshot pruned is the latest snapshot that source and
+// target have in common before it. This is useful to recover from a clone
+// that was interrupted after the restore completed.
+func Only(phases ...Phase) Option {
+	return func(c *Cloner) {
+		c.phases = make(map[Phase]bool)
+		for _, p := range phases {
+			c.phases[p] = true
+		}
+	}
+}
+
+// Clock returns an Option that sets the clock used to tell the age of
+// snapshots. Defaults to the system clock.
+func Clock(clk clock.Clock) Option {
+	return func(c *Cloner) {
+		c.clock = clk
+	}
+}
+
+// StaleAfter returns an Option that sets the age of source's latest snapshot
+// after which Preflight warns that it is stale. If d is 0, no warning is
+// given. Defaults to DefaultStaleAfter.
+func StaleAfter(d time.Duration) Option {
+	return func(c *Cloner) {
+		c.staleAfter = d
+	}
+}
+
+// SnapshotFilter returns an Option that restricts the source snapshots that are
+// cloned to those for which keep returns true. Other source snapshots are
+// ignored, as if they did not exist.
+func SnapshotFilter(keep func(diskutil.Snapshot) bool) Option {
+	return func(c *Cloner) {
+		c.snapshotFilter = keep
+	}
+}
+
+// MaxSnapshotAge returns an Option that makes Preflight fail if the latest
+// source snapshot that may be cloned is older than d. If d is 0 (default),
+// snapshots of any age may be cloned.
+func MaxSnapshotAge(d time.Duration) Option {
+	return func(c *Cloner) {
+		c.maxSnapshotAge = d
+	}
+}
+
+// ToSnapshot returns an Option that clones the source snapshot whose name or
+// UUID is id, instead of the latest source snapshot. Newer source snapshots are
+// ignored, as if they did not exist.
+func ToSnapshot(id string) Option {
+	return func(c *Cloner) {
+		c.toSnapshot = id
+	}
+}
+
+// Reserve returns an Option that keeps at least percent of each target's APFS
+// container free for future snapshots. Preflight fails if a clone would likely
+// leave less free, and after each clone, the oldest snapshots on the target are
+// pruned until the reserve is free again, without pruning its latest snapshot
+// or leaving fewer than MinKeep. If percent is 0 (default), no space is
+// reserved.
+func Reserve(percent int) Option {
+	return func(c *Cloner) {
+		c.reserve = percent
+	}
+}
+
+// CommonStrategy returns an Option that chooses which snapshot in common
+// between source and each target the target is incrementally restored from.
+// Defaults to snapshotdiff.LatestInTarget.
+func CommonStrategy(s snapshotdiff.Strategy) Option {
+	return func(c *Cloner) {
+		c.strategy = s
+	}
+}
+
+// PhaseTimes returns an Option that calls f with the duration of each phase of
+// Clone that completes successfully, measured by the Clock.
+func PhaseTimes(f func(p Phase, d time.Duration)) Option {
+	return func(c *Cloner) {
+		c.phaseTimes = f
+	}
+}
+
+// Retried returns an Option that calls f with the number of times asr retried
+// each restore after transient errors, as configured by asr.Retry, whether or
+// not the restore finally succeeded.
+func Retried(f func(retries int)) Option {
+	return func(c *Cloner) {
+		c.retried = f
+	}
+}
+
 // Stdout returns an Option that sets the stdout to the given io.Writer.
 func Stdout(w io.Writer) Option {
 	return func(c *Cloner) {
@@ -47,9 +242,12 @@ func New(du diskutil.DiskUtil, r asr.ASR, opts ...Option) Cloner {
 		asr:      r,
 
 		stdout: os.Stdout,
+		clock:  clock.Real(),
+		sleep:  sleepContext,
 
 		prune:       false,
 		initTargets: false,
+		staleAfter:  DefaultStaleAfter,
 	}
 	for _, opt := range opts {
 		opt(&c)
@@ -63,9 +261,65 @@ type Cloner struct {
 	asr      asr.ASR
 
 	stdout io.Writer
+	clock  clock.Clock
 
 	prune       bool
+	pruneSource bool
 	initTargets bool
+	history     bool
+	staleAfter  time.Duration
+	// If set, the target is verified before it is pruned.
+	verifyBeforePrune bool
+	// If positive, the minimum number of snapshots to keep on a target.
+	minKeep int
+	// If positive, the number of snapshots to prune targets down to.
+	keep int
+	// If set, only source snapshots for which snapshotFilter returns true
+	// are cloned.
+	snapshotFilter func(diskutil.Snapshot) bool
+	maxSnapshotAge time.Duration
+	// If set, the name or UUID of the source snapshot to clone.
+	toSnapshot string
+	// If positive, the percent of targets' containers to keep free.
+	reserve int
+	// Waits between polls of the space reclaimed from pruned snapshots.
+	sleep func(context.Context, time.Duration) error
+	// Set of phases to run. If nil, the default phases are run.
+	phases map[Phase]bool
+	// If set, called with the duration of each completed phase.
+	phaseTimes func(Phase, time.Duration)
+	// If set, called with the number of times each restore was retried.
+	retried func(retries int)
+	// Chooses the snapshot in common targets are restored from.
+	strategy snapshotdiff.Strategy
+}
+
+// Strategy returns the snapshotdiff.Strategy that chooses which snapshot in
+// common targets are restored from, as set by CommonStrategy.
+func (c Cloner) Strategy() snapshotdiff.Strategy {
+	return c.strategy
+}
+
+// runs returns true if Clone runs phase p.
+func (c Cloner) runs(p Phase) bool {
+	if c.phases == nil {
+		return p == PhaseRestore || (p == PhasePrune && (c.prune || c.keep > 0))
+	}
+	return c.phases[p]
+}
+
+// keepCount returns the number of snapshots to prune targets down to with
+// Keep, or the minimum to keep if it is greater.
+func (c Cloner) keepCount() int {
+	if c.keep < c.minKeep {
+		return c.minKeep
+	}
+	return c.keep
+}
+
+// keeps returns true if a target may be left with remaining snapshots.
+func (c Cloner) keeps(remaining int) bool {
+	return c.minKeep <= 0 || remaining >= c.minKeep
 }
 
 // Cloneable returns nil if source is cloneable to all targets, where cloneable
@@ -76,188 +330,766 @@ type Cloner struct {
 //   - All targets are writable.
 //   - All targets must have a snapshot in common with source.
 //   - The snapshot in common must not be the latest snapshot in source.
-func (c Cloner) Cloneable(source string, targets ...string) error {
-	sourceInfo, err := c.diskutil.Info(source)
-	if err != nil {
-		return fmt.Errorf("invalid source volume: %v", err)
+//   - All targets' containers must have enough free space for the clone,
+//     estimated from how much source's used space exceeds target's.
+//   - asr supports restoring APFS snapshots, if targets are restored to.
+//
+// Use VerifyCloneable to check each rule individually.
+func (c Cloner) Cloneable(ctx context.Context, source string, targets ...string) error {
+	_, err := c.Preflight(ctx, source, targets...)
+	return err
+}
+
+// Plan is the source and targets resolved by Preflight, along with their
+// snapshots. ClonePlanned clones using a Plan instead of looking the volumes
+// and snapshots up again, so that they cannot change between preflight and
+// clone, e.g. while waiting for the user to confirm.
+type Plan struct {
+	Source      diskutil.VolumeInfo
+	SourceSnaps diskutil.SnapshotList
+	Targets     []TargetPlan
+	// Warnings are problems that do not prevent cloning, but may indicate
+	// a mistake or an unreliable backup.
+	Warnings []Warning
+}
+
+// TargetPlan is the part of a Plan specific to a single target.
+type TargetPlan struct {
+	// Arg is the target as it was passed to Preflight.
+	Arg         string
+	Target      diskutil.VolumeInfo
+	TargetSnaps diskutil.SnapshotList
+	// Common is the latest snapshot in common between source and target,
+	// which target is incrementally cloned from. Common is unset if targets
+	// are initialized.
+	Common diskutil.Snapshot
+	// CommonReason explains why Common was chosen, by CommonStrategy.
+	CommonReason string
+}
+
+// Target returns the TargetPlan of target, as it was passed to Preflight.
+func (p Plan) Target(target string) (TargetPlan, bool) {
+	for _, t := range p.Targets {
+		if t.Arg == target {
+			return t, true
+		}
 	}
-	if sourceInfo.FileSystemType != "apfs" {
-		return errors.New("invalid source volume: does not contain an APFS file system")
+	return TargetPlan{}, false
+}
+
+// Preflight is like Cloneable, but also returns the resolved Plan for use by
+// ClonePlanned. The Plan includes warnings about source's latest snapshot being
+// stale, source and a target sharing a physical disk, and disks with failing
+// S.M.A.R.T. status.
+func (c Cloner) Preflight(ctx context.Context, source string, targets ...string) (Plan, error) {
+	if err := c.checkASR(ctx); err != nil {
+		return Plan{}, err
 	}
-	sourceSnaps, err := c.diskutil.ListSnapshots(sourceInfo)
+	sourceInfo, sourceSnaps, err := c.preflightSource(ctx, source)
 	if err != nil {
-		return fmt.Errorf("error listing snapshots of source: %v", err)
-	}
-	if len(sourceSnaps) == 0 {
-		return errors.New("invalid source: no snapshots to clone")
+		return Plan{}, err
 	}
 
 	if len(targets) == 0 {
-		return errors.New("no targets")
+		return Plan{}, errors.New("no targets")
+	}
+	plan := Plan{
+		Source:      sourceInfo,
+		SourceSnaps: sourceSnaps,
+		Warnings:    c.sourceWarnings(sourceInfo, sourceSnaps),
 	}
 	// Map of target UUIDs to the target argument.
 	targetUUIDs := make(map[string]string)
 	for _, t := range targets {
-		targetInfo, err := c.diskutil.Info(t)
+		targetInfo, err := c.diskutil.Info(ctx, t)
 		if err != nil {
-			return fmt.Errorf("invalid target volume: %v", err)
+			return Plan{}, fmt.Errorf("invalid target volume: %v", err)
 		}
-		if sourceInfo.UUID == targetInfo.UUID {
-			return errors.New("source and target must be different volumes")
+		if check := CheckDifferentVolumes(sourceInfo, targetInfo); check.Status == Fail {
+			return Plan{}, check.Err
 		}
 		if duplicate := targetUUIDs[targetInfo.UUID]; duplicate != "" {
-			return fmt.Errorf("invalid target: %q is the same as %q", t, duplicate)
+			return Plan{}, fmt.Errorf("invalid target: %q is the same as %q", t, duplicate)
 		}
 		targetUUIDs[targetInfo.UUID] = t
-		if targetInfo.FileSystemType != "apfs" {
-			return errors.New("invalid target volume: does not contain an APFS file system")
+		for _, check := range volumeChecks(sourceInfo, targetInfo) {
+			if check.Status == Fail {
+				return Plan{}, check.Err
+			}
 		}
-		// `asr restore` will restore the target volume to the same file system
-		// as source. To be safe, error here to prevent changing the file
-		// system without the user knowing.
-		if sourceInfo.FileSystem != targetInfo.FileSystem {
-			return fmt.Errorf("invalid source + target combination: source is formatted as %s, but target is formatted as %s", sourceInfo.FileSystem, targetInfo.FileSystem)
+
+		targetSnaps, err := c.diskutil.ListSnapshots(ctx, targetInfo)
+		if err != nil {
+			return Plan{}, fmt.Errorf("error listing snapshots of target: %w", err)
+		}
+		common, reason, err := c.cloneable(sourceSnaps, targetSnaps)
+		if err != nil {
+			return Plan{}, err
 		}
-		if !targetInfo.Writable {
-			return errors.New("invalid target volume: volume not writable")
+		if err := checkSpace(sourceInfo, targetInfo); err != nil {
+			return Plan{}, err
+		}
+		if err := c.checkReserve(sourceInfo, targetInfo); err != nil {
+			return Plan{}, err
+		}
+		plan.Warnings = append(plan.Warnings, targetWarnings(t, sourceInfo, targetInfo)...)
+		if w, ok := c.olderCommonWarning(t, sourceSnaps, targetSnaps, common); ok {
+			plan.Warnings = append(plan.Warnings, w)
+		}
+		plan.Targets = append(plan.Targets, TargetPlan{
+			Arg:          t,
+			Target:       targetInfo,
+			TargetSnaps:  targetSnaps,
+			Common:       common,
+			CommonReason: reason,
+		})
+	}
+	return plan, nil
+}
+
+// checkASR returns an error if restores are run, but asr cannot restore APFS
+// snapshots. Clones that never restore, e.g. verifying with a nil ASR, are
+// never checked.
+func (c Cloner) checkASR(ctx context.Context) error {
+	if c.asr == nil || !c.runs(PhaseRestore) {
+		return nil
+	}
+	return c.asr.Check(ctx)
+}
+
+// preflightSource resolves source and its snapshots that may be cloned, and
+// returns an error if source is not cloneable to any target.
+func (c Cloner) preflightSource(ctx context.Context, source string) (diskutil.VolumeInfo, diskutil.SnapshotList, error) {
+	sourceInfo, err := c.diskutil.Info(ctx, source)
+	if err != nil {
+		return diskutil.VolumeInfo{}, nil, fmt.Errorf("invalid source volume: %v", err)
+	}
+	if check := CheckSourceAPFS(sourceInfo); check.Status == Fail {
+		return diskutil.VolumeInfo{}, nil, check.Err
+	}
+	if check := CheckSourceUnlocked(sourceInfo); check.Status == Fail {
+		return diskutil.VolumeInfo{}, nil, check.Err
+	}
+	sourceSnaps, err := c.listSourceSnapshots(ctx, sourceInfo)
+	if err != nil {
+		return diskutil.VolumeInfo{}, nil, fmt.Errorf("error listing snapshots of source: %w", err)
+	}
+	if len(sourceSnaps) == 0 {
+		if c.snapshotFilter != nil {
+			return diskutil.VolumeInfo{}, nil, fmt.Errorf("%w: none are allowed by the snapshot filter - create a snapshot with an allowed tool", ErrNoSourceSnapshots)
 		}
+		return diskutil.VolumeInfo{}, nil, ErrNoSourceSnapshots
+	}
+	if err := c.checkSnapshotAge(sourceSnaps); err != nil {
+		return diskutil.VolumeInfo{}, nil, err
+	}
+	return sourceInfo, sourceSnaps, nil
+}
 
-		targetSnaps, err := c.diskutil.ListSnapshots(targetInfo)
+// EligibleTargets returns the TargetPlans of every attached APFS volume that
+// source is cloneable to, as checked by Preflight, so that targets can be
+// discovered rather than guessed. Each TargetPlan's Arg is the volume's UUID.
+// Volumes that are not cloneable, or cannot be inspected, are skipped.
+func (c Cloner) EligibleTargets(ctx context.Context, source string) ([]TargetPlan, error) {
+	sourceInfo, sourceSnaps, err := c.preflightSource(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	volumes, err := c.diskutil.APFSVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing APFS volumes: %w", err)
+	}
+	var eligible []TargetPlan
+	for _, v := range volumes {
+		targetInfo, err := c.diskutil.Info(ctx, v.UUID)
+		if err != nil || failed(volumeChecks(sourceInfo, targetInfo)) {
+			continue
+		}
+		targetSnaps, err := c.diskutil.ListSnapshots(ctx, targetInfo)
 		if err != nil {
-			return fmt.Errorf("error listing snapshots of target: %v", err)
+			continue
 		}
-		if err := c.cloneable(sourceSnaps, targetSnaps); err != nil {
-			return err
+		common, reason, err := c.cloneable(sourceSnaps, targetSnaps)
+		if err != nil {
+			continue
 		}
+		eligible = append(eligible, TargetPlan{
+			Arg:          v.UUID,
+			Target:       targetInfo,
+			TargetSnaps:  targetSnaps,
+			Common:       common,
+			CommonReason: reason,
+		})
 	}
-	return nil
+	return eligible, nil
 }
 
-func (c Cloner) cloneable(sourceSnaps, targetSnaps []diskutil.Snapshot) error {
-	if !c.initTargets {
-		_, err := latestCommonSnapshot(sourceSnaps, targetSnaps)
-		return err
+// checkSnapshotAge returns an error wrapping ErrSnapshotTooOld if the latest
+// of sourceSnaps is older than the maximum snapshot age.
+func (c Cloner) checkSnapshotAge(sourceSnaps diskutil.SnapshotList) error {
+	latest, ok := sourceSnaps.Latest()
+	if !ok || c.maxSnapshotAge <= 0 || latest.Created.IsZero() {
+		return nil
 	}
-	if len(targetSnaps) > 0 {
-		return errors.New("invalid target: target has snapshots - erase the disk before using initialize")
+	if age := c.clock.Now().Sub(latest.Created); age > c.maxSnapshotAge {
+		return fmt.Errorf("%w: %s was created %s ago, more than the maximum of %s - create a new snapshot before cloning", ErrSnapshotTooOld, latest, age.Round(time.Minute), c.maxSnapshotAge)
 	}
 	return nil
 }
 
+// listSourceSnapshots lists the snapshots of source that may be cloned.
+func (c Cloner) listSourceSnapshots(ctx context.Context, source diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
+	snaps, err := c.diskutil.ListSnapshots(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+	if c.snapshotFilter != nil {
+		snaps = snaps.Filter(c.snapshotFilter)
+	}
+	if c.toSnapshot == "" {
+		return snaps, nil
+	}
+	to, ok := snaps.Find(c.toSnapshot)
+	if !ok {
+		return nil, fmt.Errorf("source has no snapshot %q that may be cloned", c.toSnapshot)
+	}
+	return append(diskutil.SnapshotList{to}, snaps.Before(to.UUID)...), nil
+}
+
+// cloneable returns the common snapshot of sourceSnaps and targetSnaps chosen
+// by the CommonStrategy, and why it was chosen, or an error if they are not
+// cloneable.
+func (c Cloner) cloneable(sourceSnaps, targetSnaps diskutil.SnapshotList) (diskutil.Snapshot, string, error) {
+	if c.initTargets {
+		if check := CheckSnapshots(sourceSnaps, targetSnaps, true); check.Status == Fail {
+			return diskutil.Snapshot{}, "", check.Err
+		}
+		return diskutil.Snapshot{}, "", nil
+	}
+	// CheckSnapshots is not used, as it checks the common snapshot chosen
+	// by the default strategy.
+	return latestCommonSnapshot(sourceSnaps, targetSnaps, c.strategy)
+}
+
 // Clone the latest snapshot in source to target, from the most recent common
 // snapshot present in both source and target.
-func (c Cloner) Clone(source, target string) error {
-	sourceInfo, err := c.diskutil.Info(source)
+func (c Cloner) Clone(ctx context.Context, source, target string) error {
+	if err := c.checkASR(ctx); err != nil {
+		return err
+	}
+	sourceInfo, err := c.diskutil.Info(ctx, source)
 	if err != nil {
 		return fmt.Errorf("error getting volume info of source %q: %v", source, err)
 	}
-	targetInfo, err := c.diskutil.Info(target)
+	if check := CheckSourceUnlocked(sourceInfo); check.Status == Fail {
+		return check.Err
+	}
+	targetInfo, err := c.diskutil.Info(ctx, target)
 	if err != nil {
 		return fmt.Errorf("error getting volume info of target %q: %v", target, err)
 	}
+	sourceSnaps, err := c.listSourceSnapshots(ctx, sourceInfo)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of source: %w", err)
+	}
+	if _, ok := sourceSnaps.Latest(); !ok {
+		return ErrNoSourceSnapshots
+	}
+	targetSnaps, err := c.diskutil.ListSnapshots(ctx, targetInfo)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of target: %w", err)
+	}
+	return c.cloneTarget(ctx, sourceInfo, sourceSnaps, TargetPlan{
+		Arg:         target,
+		Target:      targetInfo,
+		TargetSnaps: targetSnaps,
+	})
+}
 
-	if c.initTargets {
-		if err := c.destructiveClone(sourceInfo, targetInfo); err != nil {
+// ClonePlanned is like Clone, but clones to target using the volumes and
+// snapshots resolved by Preflight instead of looking them up again. target
+// must be one of the targets passed to Preflight.
+func (c Cloner) ClonePlanned(ctx context.Context, p Plan, target string) error {
+	t, ok := p.Target(target)
+	if !ok {
+		return fmt.Errorf("target %q is not in the plan", target)
+	}
+	return c.cloneTarget(ctx, p.Source, p.SourceSnaps, t)
+}
+
+func (c Cloner) cloneTarget(ctx context.Context, sourceInfo diskutil.VolumeInfo, sourceSnaps diskutil.SnapshotList, t TargetPlan) error {
+	targetInfo, targetSnaps := t.Target, t.TargetSnaps
+	latestSourceSnap, ok := sourceSnaps.Latest()
+	if !ok {
+		return ErrNoSourceSnapshots
+	}
+	fmt.Fprintf(c.stdout, "Latest snapshot in source:\n\t%s\n", latestSourceSnap)
+
+	var (
+		commonSnap diskutil.Snapshot
+		err        error
+	)
+	if c.runs(PhaseRestore) {
+		err := c.timed(PhaseRestore, func() error {
+			if err := c.checkHistory(ctx, targetInfo, targetSnaps); err != nil {
+				return err
+			}
+			var err error
+			if c.initTargets {
+				err = c.destructiveClone(ctx, sourceInfo, targetInfo, latestSourceSnap, targetSnaps)
+			} else {
+				commonSnap, err = c.incrementalClone(ctx, sourceInfo, targetInfo, sourceSnaps, targetSnaps, t.Common)
+			}
+			if err != nil {
+				return err
+			}
+			// ASR renames the volume to source's name after a restore.
+			// Change it back.
+			if err := c.diskutil.Rename(ctx, targetInfo, targetInfo.Name); err != nil {
+				return fmt.Errorf("error renaming volume to original name: %v", err)
+			}
+			return c.recordHistory(ctx, sourceInfo, targetInfo)
+		})
+		if err != nil {
 			return err
 		}
-	} else {
-		if err := c.clone(sourceInfo, targetInfo); err != nil {
+	} else if c.runs(PhasePrune) && !c.initTargets && c.keep > 0 {
+		if !targetSnaps.Contains(latestSourceSnap.UUID) {
+			return errors.New("target does not contain the latest snapshot in source; refusing to prune the snapshots needed to restore it")
+		}
+	} else if c.runs(PhasePrune) && !c.initTargets {
+		commonSnap, err = previousCommonSnapshot(sourceSnaps, targetSnaps)
+		if err != nil {
+			return fmt.Errorf("error finding snapshot to prune: %v", err)
+		}
+		fmt.Fprintf(c.stdout, "Snapshot in common before latest snapshot:\n\t%s\n", commonSnap)
+	}
+
+	if c.runs(PhaseVerify) {
+		err := c.timed(PhaseVerify, func() error {
+			return c.verify(ctx, targetInfo, latestSourceSnap)
+		})
+		if err != nil {
 			return err
 		}
 	}
-	// ASR renames the volume to source's name after a restore. Change it
-	// back.
-	if err := c.diskutil.Rename(targetInfo, targetInfo.Name); err != nil {
-		return fmt.Errorf("error renaming volume to original name: %v", err)
+
+	if c.runs(PhasePrune) && c.initTargets {
+		// An initialized target only contains the latest source snapshot,
+		// so there is nothing on it to prune.
+		fmt.Fprintln(c.stdout, "Target was initialized; nothing to prune from target.")
+	} else if c.runs(PhasePrune) {
+		err := c.timed(PhasePrune, func() error {
+			if c.verifyBeforePrune && !c.runs(PhaseVerify) {
+				if err := c.verify(ctx, targetInfo, latestSourceSnap); err != nil {
+					return fmt.Errorf("%w: %v", ErrPruneSkipped, err)
+				}
+			}
+			// A restore adds source's latest snapshot to target.
+			if c.runs(PhaseRestore) {
+				targetSnaps = append(diskutil.SnapshotList{latestSourceSnap}, targetSnaps...)
+			}
+			if c.keep > 0 {
+				return c.pruneToKeep(ctx, sourceInfo, targetInfo, targetSnaps)
+			}
+			remaining := len(targetSnaps) - 1
+			if !c.keeps(remaining) {
+				fmt.Fprintf(c.stdout, "Not pruning common snapshot from target: target must keep at least %d snapshots.\n", c.minKeep)
+				return nil
+			}
+			if err := c.diskutil.DeleteSnapshot(ctx, targetInfo, commonSnap); err != nil {
+				return fmt.Errorf("error deleting snapshot %q from target", commonSnap)
+			}
+			fmt.Fprintln(c.stdout, "Pruned common snapshot from target.")
+			return c.recordHistory(ctx, sourceInfo, targetInfo)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if c.reserve > 0 && (c.runs(PhaseRestore) || c.runs(PhasePrune)) {
+		return c.thinToReserve(ctx, sourceInfo, targetInfo)
 	}
 	return nil
 }
 
-func (c Cloner) clone(source, target diskutil.VolumeInfo) error {
-	sourceSnaps, err := c.diskutil.ListSnapshots(source)
-	if err != nil {
-		return fmt.Errorf("error listing snapshots of source: %v", err)
+// timed runs f, the body of phase p, and reports its duration to PhaseTimes
+// if it succeeds.
+func (c Cloner) timed(p Phase, f func() error) error {
+	start := c.clock.Now()
+	if err := f(); err != nil {
+		return err
 	}
-	if len(sourceSnaps) == 0 {
-		return errors.New("source does not contain any snapshots")
+	if c.phaseTimes != nil {
+		c.phaseTimes(p, c.clock.Now().Sub(start))
 	}
-	// TODO: document that this relies on the snapshots being in the right order.
-	latestSourceSnap := sourceSnaps[0]
-	fmt.Fprintf(c.stdout, "Latest snapshot in source:\n\t%s\n", latestSourceSnap)
+	return nil
+}
 
-	targetSnaps, err := c.diskutil.ListSnapshots(target)
+// PruneSource deletes from source the snapshots that it had in common with
+// each target before the target was restored to source's latest snapshot, if
+// PruneSource(true). Once every target has source's latest snapshot, none of
+// them needs the snapshots to be incrementally cloned to, so PruneSource must
+// only be called after source was successfully cloned to all of its targets.
+// Nothing is pruned if any target does not contain source's latest snapshot.
+func (c Cloner) PruneSource(ctx context.Context, source string, targets ...string) error {
+	if !c.pruneSource {
+		return nil
+	}
+	sourceInfo, err := c.diskutil.Info(ctx, source)
 	if err != nil {
-		return fmt.Errorf("error listing snapshots of target: %v", err)
+		return fmt.Errorf("error getting volume info of source %q: %v", source, err)
 	}
-	commonSnap, err := latestCommonSnapshot(sourceSnaps, targetSnaps)
+	sourceSnaps, err := c.listSourceSnapshots(ctx, sourceInfo)
 	if err != nil {
-		return fmt.Errorf("error finding latest snapshot in common between source and target: %v", err)
+		return fmt.Errorf("error listing snapshots of source: %w", err)
+	}
+	latest, ok := sourceSnaps.Latest()
+	if !ok {
+		return ErrNoSourceSnapshots
+	}
+	var prunable diskutil.SnapshotList
+	for _, t := range targets {
+		targetInfo, err := c.diskutil.Info(ctx, t)
+		if err != nil {
+			return fmt.Errorf("error getting volume info of target %q: %v", t, err)
+		}
+		targetSnaps, err := c.diskutil.ListSnapshots(ctx, targetInfo)
+		if err != nil {
+			return fmt.Errorf("error listing snapshots of target: %w", err)
+		}
+		if !targetSnaps.Contains(latest.UUID) {
+			return fmt.Errorf("target %q does not contain the latest snapshot in source; refusing to prune source", t)
+		}
+		// Initialized targets had no snapshot in common with source.
+		common, exists := sourceSnaps.Before(latest.UUID).CommonWith(targetSnaps)
+		if exists && !prunable.Contains(common.UUID) {
+			prunable = append(prunable, common)
+		}
+	}
+	if len(prunable) == 0 {
+		fmt.Fprintln(c.stdout, "Source had no other snapshots in common with targets; nothing to prune from source.")
+		return nil
+	}
+	if !c.keeps(len(sourceSnaps) - len(prunable)) {
+		fmt.Fprintf(c.stdout, "Not pruning common snapshots from source: source must keep at least %d snapshots.\n", c.minKeep)
+		return nil
+	}
+	for _, snap := range prunable {
+		if err := c.diskutil.DeleteSnapshot(ctx, sourceInfo, snap); err != nil {
+			return fmt.Errorf("error deleting snapshot %q from source: %w", snap, err)
+		}
+		fmt.Fprintf(c.stdout, "Pruned snapshot from source:\n\t%s\n", snap)
+	}
+	return nil
+}
+
+// Prunes returns the snapshots that cloning to t, a target of p, would prune
+// from it, newest first, assuming the clone succeeds.
+func (c Cloner) Prunes(p Plan, t TargetPlan) diskutil.SnapshotList {
+	if !c.runs(PhasePrune) || c.initTargets {
+		return nil
+	}
+	latest, ok := p.SourceSnaps.Latest()
+	if !ok {
+		return nil
+	}
+	snaps, common := t.TargetSnaps, t.Common
+	if c.runs(PhaseRestore) {
+		// A restore adds source's latest snapshot to target.
+		snaps = append(diskutil.SnapshotList{latest}, snaps...)
+	} else if !snaps.Contains(latest.UUID) {
+		return nil
+	} else if c.keep == 0 {
+		var err error
+		if common, err = previousCommonSnapshot(p.SourceSnaps, snaps); err != nil {
+			return nil
+		}
+	}
+	if c.keep > 0 {
+		if keep := c.keepCount(); len(snaps) > keep {
+			return snaps[keep:]
+		}
+		return nil
+	}
+	if common.UUID == "" || !c.keeps(len(snaps)-1) {
+		return nil
+	}
+	return diskutil.SnapshotList{common}
+}
+
+// pruneToKeep deletes every snapshot of target, whose snapshots are snaps,
+// except the newest Keep, or the minimum to keep if it is greater.
+func (c Cloner) pruneToKeep(ctx context.Context, source, target diskutil.VolumeInfo, snaps diskutil.SnapshotList) error {
+	keep := c.keepCount()
+	if len(snaps) <= keep {
+		fmt.Fprintf(c.stdout, "Target has %d snapshots; nothing to prune to keep %d.\n", len(snaps), keep)
+		return nil
+	}
+	for _, snap := range snaps[keep:] {
+		if err := c.diskutil.DeleteSnapshot(ctx, target, snap); err != nil {
+			return fmt.Errorf("error deleting snapshot %q from target: %w", snap, err)
+		}
+		fmt.Fprintf(c.stdout, "Pruned snapshot from target:\n\t%s\n", snap)
+	}
+	return c.recordHistory(ctx, source, target)
+}
+
+// incrementalClone restores target from commonSnap to the latest snapshot in
+// source. If commonSnap is unset, the latest common snapshot is found from
+// sourceSnaps and targetSnaps.
+func (c Cloner) incrementalClone(ctx context.Context, source, target diskutil.VolumeInfo, sourceSnaps, targetSnaps diskutil.SnapshotList, commonSnap diskutil.Snapshot) (diskutil.Snapshot, error) {
+	if commonSnap.UUID == "" {
+		var err error
+		commonSnap, _, err = latestCommonSnapshot(sourceSnaps, targetSnaps, c.strategy)
+		if err != nil {
+			return diskutil.Snapshot{}, fmt.Errorf("error finding latest snapshot in common between source and target: %w", err)
+		}
+		// Preflight warns of planned clones' older common snapshots.
+		if w, ok := c.olderCommonWarning(target.Name, sourceSnaps, targetSnaps, commonSnap); ok {
+			fmt.Fprintf(c.stdout, "Warning: %s\n", w.Message)
+		}
 	}
 	fmt.Fprintf(c.stdout, "Snapshot in common:\n\t%s\n", commonSnap)
 
 	fmt.Fprintln(c.stdout, "Restoring to latest snapshot in source from common snapshot...")
-	if err := c.asr.Restore(source, target, latestSourceSnap, commonSnap); err != nil {
-		return fmt.Errorf("error restoring: %v", err)
+	latestSourceSnap, _ := sourceSnaps.Latest()
+	err := c.restore(ctx, func(ctx context.Context) error {
+		return c.asr.Restore(ctx, source, target, latestSourceSnap, commonSnap)
+	})
+	if err != nil {
+		return diskutil.Snapshot{}, fmt.Errorf("error restoring: %w", err)
 	}
+	return commonSnap, nil
+}
 
-	if c.prune {
-		if err := c.diskutil.DeleteSnapshot(target, commonSnap); err != nil {
-			return fmt.Errorf("error deleting snapshot %q from target", commonSnap)
-		}
-		fmt.Fprintln(c.stdout, "Pruned common snapshot from target.")
+func (c Cloner) destructiveClone(ctx context.Context, source, target diskutil.VolumeInfo, latestSourceSnap diskutil.Snapshot, targetSnaps diskutil.SnapshotList) error {
+	if len(targetSnaps) > 0 {
+		return fmt.Errorf("aborting because target contains snapshots that would be erased: %w", ErrTargetHasSnapshots)
+	}
+	fmt.Fprintln(c.stdout, "Restoring to latest snapshot in source...")
+	err := c.restore(ctx, func(ctx context.Context) error {
+		return c.asr.DestructiveRestore(ctx, source, target, latestSourceSnap)
+	})
+	if err != nil {
+		return fmt.Errorf("error restoring: %w", err)
 	}
 	return nil
 }
 
-func (c Cloner) destructiveClone(source, target diskutil.VolumeInfo) error {
-	sourceSnaps, err := c.diskutil.ListSnapshots(source)
-	if err != nil {
-		return fmt.Errorf("error listing snapshots of source: %v", err)
+// restore calls restore, printing each time asr retries it after a transient
+// error, and reports how many times it was retried.
+func (c Cloner) restore(ctx context.Context, restore func(context.Context) error) error {
+	retries := 0
+	ctx = asr.WithRetryTrace(ctx, func(r asr.RetryAttempt) {
+		retries++
+		fmt.Fprintf(c.stdout, "Warning: restore attempt %d of %d failed with a transient error; retrying in %s: %s\n", r.Attempt, r.MaxAttempts, r.Wait, strings.TrimSpace(r.Err.Stderr))
+	})
+	err := restore(ctx)
+	if err == nil && retries > 0 {
+		fmt.Fprintf(c.stdout, "Restore succeeded after %d retries.\n", retries)
 	}
-	if len(sourceSnaps) == 0 {
-		return errors.New("source does not contain any snapshots")
+	if c.retried != nil {
+		c.retried(retries)
 	}
-	// TODO: document that this relies on the snapshots being in the right order.
-	latestSourceSnap := sourceSnaps[0]
-	fmt.Fprintf(c.stdout, "Latest snapshot in source:\n\t%s\n", latestSourceSnap)
+	return err
+}
 
-	targetSnaps, err := c.diskutil.ListSnapshots(target)
+// verify returns an error if the latest snapshot in target is not want.
+func (c Cloner) verify(ctx context.Context, target diskutil.VolumeInfo, want diskutil.Snapshot) error {
+	targetSnaps, err := c.diskutil.ListSnapshots(ctx, target)
 	if err != nil {
-		return fmt.Errorf("error listing snapshots of target: %v", err)
+		return fmt.Errorf("error listing snapshots of target: %w", err)
 	}
-	if len(targetSnaps) > 0 {
-		return errors.New("aborting because target contains snapshots that would be erased")
+	latest, ok := targetSnaps.Latest()
+	if !ok {
+		return fmt.Errorf("verification failed: target does not contain any snapshots, want latest snapshot %s", want)
 	}
-	fmt.Fprintln(c.stdout, "Restoring to latest snapshot in source...")
-	if err := c.asr.DestructiveRestore(source, target, latestSourceSnap); err != nil {
-		return fmt.Errorf("error restoring: %v", err)
+	if latest.UUID != want.UUID {
+		return fmt.Errorf("verification failed: latest snapshot in target is %s, want %s", latest, want)
 	}
+	if err := c.checkHistory(ctx, target, targetSnaps); err != nil {
+		return fmt.Errorf("verification failed: %w", err)
+	}
+	fmt.Fprintln(c.stdout, "Verified latest snapshot in target.")
 	return nil
 }
 
-// TODO: document that this relies on the snapshots being in the right order.
-func latestCommonSnapshot(source, target []diskutil.Snapshot) (diskutil.Snapshot, error) {
-	commonSourceI, commonTargetI, exists := latestCommonSnapshotIndices(source, target)
-	if !exists {
-		return diskutil.Snapshot{}, errors.New("source and target have no snapshots in common")
+// latestCommonSnapshot returns the snapshot that target can be incrementally
+// restored from to source's latest snapshot, as chosen by strategy with
+// snapshotdiff.Choose, and why it was chosen.
+func latestCommonSnapshot(source, target diskutil.SnapshotList, strategy snapshotdiff.Strategy) (diskutil.Snapshot, string, error) {
+	common, analysis, err := snapshotdiff.Choose(source, target, strategy)
+	if err != nil {
+		return diskutil.Snapshot{}, "", err
 	}
-	if commonSourceI == 0 && commonTargetI == 0 {
-		return diskutil.Snapshot{}, errors.New("both source and target have the same latest snapshot")
+	if analysis.UpToDate() {
+		return diskutil.Snapshot{}, "", errors.New("both source and target have the same latest snapshot")
 	}
-	// TODO: is this logic correct? Shouldn't it be `commonSourceI < commonTargetI`?
-	if commonSourceI == 0 {
-		return diskutil.Snapshot{}, errors.New("target has a snapshot ahead of source")
+	// TODO: is this logic correct? Shouldn't it also error if target's
+	// latest snapshot is more recent than common?
+	if analysis.Behind == 0 {
+		return diskutil.Snapshot{}, "", errors.New("target has a snapshot ahead of source")
 	}
-	return source[commonSourceI], nil
+	return common, analysis.Reason, nil
+}
+
+// VolumePair is a source volume and the target volume it is cloned to.
+type VolumePair struct {
+	Source diskutil.VolumeInfo
+	// Target is the volume in the target container with the same name as
+	// Source. If Missing, only Target's Name and Container are set.
+	Target  diskutil.VolumeInfo
+	Missing bool
 }
 
-func latestCommonSnapshotIndices(source, target []diskutil.Snapshot) (sourceIndex, targetIndex int, exists bool) {
-	for targetIndex, ts := range target {
-		for sourceIndex, ss := range source {
-			if ss.UUID == ts.UUID {
-				return sourceIndex, targetIndex, true
+// ContainerPairs pairs each volume in source's APFS container with the volume
+// of the same name in target's APFS container. source and target may identify
+// any volume in their containers. Volumes in source's container that have no
+// counterpart in target's container are returned with Missing set; use
+// CreateTarget to create them.
+func (c Cloner) ContainerPairs(ctx context.Context, source, target string) ([]VolumePair, error) {
+	sourceInfo, err := c.diskutil.Info(ctx, source)
+	if err != nil {
+		return nil, fmt.Errorf("invalid source volume: %v", err)
+	}
+	targetInfo, err := c.diskutil.Info(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target volume: %v", err)
+	}
+	if sourceInfo.Container == "" {
+		return nil, errors.New("invalid source volume: not in an APFS container")
+	}
+	if targetInfo.Container == "" {
+		return nil, errors.New("invalid target volume: not in an APFS container")
+	}
+	if sourceInfo.Container == targetInfo.Container {
+		return nil, errors.New("source and target must be in different containers")
+	}
+	sourceVolumes, err := c.diskutil.ContainerVolumes(ctx, sourceInfo.Container)
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumes of source container: %v", err)
+	}
+	targetVolumes, err := c.diskutil.ContainerVolumes(ctx, targetInfo.Container)
+	if err != nil {
+		return nil, fmt.Errorf("error listing volumes of target container: %v", err)
+	}
+	targetsByName := make(map[string]diskutil.VolumeInfo)
+	for _, v := range targetVolumes {
+		if _, duplicate := targetsByName[v.Name]; duplicate {
+			return nil, fmt.Errorf("invalid target container: multiple volumes are named %q", v.Name)
+		}
+		targetsByName[v.Name] = v
+	}
+
+	var pairs []VolumePair
+	for _, v := range sourceVolumes {
+		s, err := c.diskutil.Info(ctx, v.UUID)
+		if err != nil {
+			return nil, fmt.Errorf("error getting volume info of source volume %q: %v", v.Name, err)
+		}
+		s.Quota, s.Reserve = v.Quota, v.Reserve
+		pair := VolumePair{Source: s}
+		if t, exists := targetsByName[v.Name]; exists {
+			pair.Target = t
+		} else {
+			pair.Target = diskutil.VolumeInfo{
+				Name:      v.Name,
+				Container: targetInfo.Container,
 			}
+			pair.Missing = true
+		}
+		pairs = append(pairs, pair)
+	}
+	return pairs, nil
+}
+
+// CreateTarget creates the missing target volume of pair, with the same name,
+// file system, quota, and reserve as the source volume, and returns the
+// updated pair.
+func (c Cloner) CreateTarget(ctx context.Context, pair VolumePair) (VolumePair, error) {
+	if !pair.Missing {
+		return pair, nil
+	}
+	volume := diskutil.VolumeInfo{
+		Name:       pair.Target.Name,
+		FileSystem: pair.Source.FileSystem,
+		Quota:      pair.Source.Quota,
+		Reserve:    pair.Source.Reserve,
+	}
+	if err := c.diskutil.AddVolume(ctx, pair.Target.Container, volume); err != nil {
+		return pair, fmt.Errorf("error creating target volume %q: %v", pair.Target.Name, err)
+	}
+	volumes, err := c.diskutil.ContainerVolumes(ctx, pair.Target.Container)
+	if err != nil {
+		return pair, fmt.Errorf("error listing volumes of target container: %v", err)
+	}
+	for _, v := range volumes {
+		if v.Name == pair.Target.Name {
+			pair.Target = v
+			pair.Missing = false
+			fmt.Fprintf(c.stdout, "Created target volume %q.\n", v.Name)
+			return pair, nil
 		}
 	}
-	return 0, 0, false
+	return pair, fmt.Errorf("created target volume %q, but it does not exist", pair.Target.Name)
+}
+
+// checkHistory returns a *history.DivergedError if history is enabled and
+// target's snapshots, targetSnaps, do not match target's history record.
+func (c Cloner) checkHistory(ctx context.Context, target diskutil.VolumeInfo, targetSnaps diskutil.SnapshotList) error {
+	if !c.history {
+		return nil
+	}
+	// The target may have been remounted elsewhere (e.g. by asr).
+	target, err := c.diskutil.Info(ctx, target.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of target: %v", err)
+	}
+	if target.MountPoint == "" {
+		return nil
+	}
+	r, exists, err := history.Read(target.MountPoint)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return nil
+	}
+	return r.Verify(targetSnaps)
+}
+
+// recordHistory writes target's history record, if history is enabled.
+func (c Cloner) recordHistory(ctx context.Context, source, target diskutil.VolumeInfo) error {
+	if !c.history {
+		return nil
+	}
+	// The target may have been remounted elsewhere (e.g. by asr).
+	target, err := c.diskutil.Info(ctx, target.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of target: %v", err)
+	}
+	if target.MountPoint == "" {
+		fmt.Fprintln(c.stdout, "Target is not mounted; not recording its history.")
+		return nil
+	}
+	snaps, err := c.diskutil.ListSnapshots(ctx, target)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of target: %w", err)
+	}
+	return history.Write(target.MountPoint, history.New(source.UUID, snaps))
+}
+
+// previousCommonSnapshot returns the latest snapshot that source and target
+// had in common before target was restored to source's latest snapshot.
+func previousCommonSnapshot(source, target diskutil.SnapshotList) (diskutil.Snapshot, error) {
+	latest, _ := source.Latest()
+	if !target.Contains(latest.UUID) {
+		return diskutil.Snapshot{}, errors.New("target does not contain the latest snapshot in source; refusing to prune the snapshot needed to restore it")
+	}
+	common, exists := source.Before(latest.UUID).CommonWith(target)
+	if !exists {
+		return diskutil.Snapshot{}, errors.New("source and target have no other snapshots in common")
+	}
+	return common, nil
 }
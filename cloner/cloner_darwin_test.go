@@ -3,6 +3,7 @@
 package cloner_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -53,7 +54,7 @@ func TestCloneable(t *testing.T) {
 			// nil so that test panics of any asr methods are called.
 			var r asr.ASR = nil
 			c := cloner.New(du, r, test.opts...)
-			if err := c.Cloneable(test.source, test.targets...); err != nil {
+			if err := c.Cloneable(context.Background(), test.source, test.targets...); err != nil {
 				t.Errorf("Cloneable returned error: %q, want: nil", err)
 			}
 		})
@@ -150,7 +151,7 @@ func TestCloneable_Errors(t *testing.T) {
 			// nil so that test panics of any asr methods are called.
 			var r asr.ASR = nil
 			c := cloner.New(du, r, test.opts...)
-			if err := c.Cloneable(test.source, test.targets...); err == nil {
+			if err := c.Cloneable(context.Background(), test.source, test.targets...); err == nil {
 				t.Error("Cloneable returned error: nil, want: non-nil")
 			}
 		})
@@ -166,12 +167,12 @@ func TestClone_DryRun(t *testing.T) {
 	du := diskutil.NewDryRun(diskutil.New())
 	r := asr.NewDryRun()
 	c := cloner.New(du, r)
-	if err := c.Clone(sourceInfo.Device, targetInfo.Device); err != nil {
+	if err := c.Clone(context.Background(), sourceInfo.Device, targetInfo.Device); err != nil {
 		t.Fatalf("Clone returned unexpected error: %q, want: nil", err)
 	}
 
 	t.Run("target's volume not modified", func(t *testing.T) {
-		gotInfo, err := du.Info(targetInfo.Device)
+		gotInfo, err := du.Info(context.Background(), targetInfo.Device)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -180,7 +181,7 @@ func TestClone_DryRun(t *testing.T) {
 		}
 	})
 	t.Run("target's snapshots not modified", func(t *testing.T) {
-		gotSnaps, err := du.ListSnapshots(targetInfo)
+		gotSnaps, err := du.ListSnapshots(context.Background(), targetInfo)
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -195,7 +196,7 @@ func TestClone_Incremental(t *testing.T) {
 		name            string
 		opts            []cloner.Option
 		setup           func(*testing.T) (source, target string)
-		wantTargetSnaps []diskutil.Snapshot
+		wantTargetSnaps diskutil.SnapshotList
 	}{
 		{
 			name: "default options",
@@ -221,17 +222,17 @@ func TestClone_Incremental(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			source, target := test.setup(t)
 			du := diskutil.New()
-			wantTargetInfo, err := du.Info(target)
+			wantTargetInfo, err := du.Info(context.Background(), target)
 			if err != nil {
 				t.Fatal(err)
 			}
 
 			c := cloner.New(diskutil.New(), asr.New(), test.opts...)
-			if err := c.Clone(source, target); err != nil {
+			if err := c.Clone(context.Background(), source, target); err != nil {
 				t.Fatalf("Clone returned unexpected error: %v, want: nil", err)
 			}
 
-			gotTargetInfo, err := du.Info(target)
+			gotTargetInfo, err := du.Info(context.Background(), target)
 			if err != nil {
 				t.Fatal(err)
 			}
@@ -245,7 +246,7 @@ func TestClone_Incremental(t *testing.T) {
 				}
 			})
 			t.Run("target has expected snapshots", func(t *testing.T) {
-				gotTargetSnaps, err := du.ListSnapshots(gotTargetInfo)
+				gotTargetSnaps, err := du.ListSnapshots(context.Background(), gotTargetInfo)
 				if err != nil {
 					t.Fatal(err)
 				}
@@ -262,17 +263,17 @@ func TestClone_InitializeTargets(t *testing.T) {
 	target := mounter.MountRW(t, diskimage.UninitializedTargetImg).Device
 
 	du := diskutil.New()
-	wantTargetInfo, err := du.Info(target)
+	wantTargetInfo, err := du.Info(context.Background(), target)
 	if err != nil {
 		t.Fatal(err)
 	}
 
 	c := cloner.New(diskutil.New(), asr.New(), cloner.InitializeTargets(true))
-	if err := c.Clone(source, target); err != nil {
+	if err := c.Clone(context.Background(), source, target); err != nil {
 		t.Fatalf("Clone returned unexpected error: %v, want: nil", err)
 	}
 
-	gotTargetInfo, err := du.Info(target)
+	gotTargetInfo, err := du.Info(context.Background(), target)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -289,11 +290,11 @@ func TestClone_InitializeTargets(t *testing.T) {
 		}
 	})
 	t.Run("target has latest source snapshot", func(t *testing.T) {
-		gotTargetSnaps, err := du.ListSnapshots(gotTargetInfo)
+		gotTargetSnaps, err := du.ListSnapshots(context.Background(), gotTargetInfo)
 		if err != nil {
 			t.Fatal(err)
 		}
-		wantTargetSnaps := []diskutil.Snapshot{
+		wantTargetSnaps := diskutil.SnapshotList{
 			diskimage.SourceImg.Snapshots(t)[0],
 		}
 		if diff := cmp.Diff(wantTargetSnaps, gotTargetSnaps); diff != "" {
@@ -342,7 +343,7 @@ func TestClone_Errors(t *testing.T) {
 			// nil so that test panics of any asr methods are called.
 			var r asr.ASR = nil
 			c := cloner.New(du, r, test.opts...)
-			if err := c.Clone(source, target); err == nil {
+			if err := c.Clone(context.Background(), source, target); err == nil {
 				t.Fatal("Clone returned unexpected error: nil, want: non-nil")
 			}
 		})
@@ -1,8 +1,10 @@
 package cloner
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sort"
 	"testing"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
@@ -95,7 +97,9 @@ func (d *fakeDevices) AddSnapshot(volumeUUID string, snapshot diskutil.Snapshot)
 			return errors.New("snapshot already exists")
 		}
 	}
-	d.snapshots[volumeUUID] = append(d.snapshots[volumeUUID], snapshot)
+	// Snapshots are ordered most recent first, like
+	// diskutil.DiskUtil.ListSnapshots.
+	d.snapshots[volumeUUID] = append([]diskutil.Snapshot{snapshot}, d.snapshots[volumeUUID]...)
 	return nil
 }
 
@@ -122,11 +126,11 @@ type fakeDiskUtil struct {
 	devices *fakeDevices
 }
 
-func (du *fakeDiskUtil) Info(volume string) (diskutil.VolumeInfo, error) {
+func (du *fakeDiskUtil) Info(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
 	return du.devices.Volume(volume)
 }
 
-func (du *fakeDiskUtil) Rename(volume diskutil.VolumeInfo, name string) error {
+func (du *fakeDiskUtil) Rename(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
 	snaps, err := du.devices.Snapshots(volume.UUID)
 	if err != nil {
 		return err
@@ -139,33 +143,109 @@ func (du *fakeDiskUtil) Rename(volume diskutil.VolumeInfo, name string) error {
 	return du.devices.AddVolume(volume, snaps...)
 }
 
-func (du *fakeDiskUtil) ListSnapshots(volume diskutil.VolumeInfo) ([]diskutil.Snapshot, error) {
+func (du *fakeDiskUtil) ListSnapshots(ctx context.Context, volume diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
 	return du.devices.Snapshots(volume.UUID)
 }
 
-func (du *fakeDiskUtil) DeleteSnapshot(volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+func (du *fakeDiskUtil) DeleteSnapshot(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
 	return du.devices.DeleteSnapshot(volume.UUID, snap.UUID)
 }
 
+func (du *fakeDiskUtil) EraseVolume(ctx context.Context, volume diskutil.VolumeInfo, name string) error {
+	if err := du.devices.RemoveVolume(volume.UUID); err != nil {
+		return err
+	}
+	volume.Name = name
+	return du.devices.AddVolume(volume)
+}
+
+func (du *fakeDiskUtil) ContainerVolumes(ctx context.Context, container string) ([]diskutil.VolumeInfo, error) {
+	var volumes []diskutil.VolumeInfo
+	for _, info := range du.devices.volumes {
+		if info.Container == container {
+			volumes = append(volumes, info)
+		}
+	}
+	if len(volumes) == 0 {
+		return nil, errors.New("container not found")
+	}
+	sort.Slice(volumes, func(i, ii int) bool {
+		return volumes[i].Name < volumes[ii].Name
+	})
+	return volumes, nil
+}
+
+func (du *fakeDiskUtil) APFSVolumes(ctx context.Context) ([]diskutil.VolumeInfo, error) {
+	var volumes []diskutil.VolumeInfo
+	for _, info := range du.devices.volumes {
+		volumes = append(volumes, info)
+	}
+	sort.Slice(volumes, func(i, ii int) bool {
+		return volumes[i].Name < volumes[ii].Name
+	})
+	return volumes, nil
+}
+
+func (du *fakeDiskUtil) AddVolume(ctx context.Context, container string, volume diskutil.VolumeInfo) error {
+	volume.UUID = fmt.Sprintf("%s-%s-uuid", container, volume.Name)
+	volume.Device = fmt.Sprintf("/dev/%s-%s", container, volume.Name)
+	volume.Writable = true
+	volume.FileSystemType = "apfs"
+	volume.Container = container
+	return du.devices.AddVolume(volume)
+}
+
+func (du *fakeDiskUtil) Mount(ctx context.Context, volume diskutil.VolumeInfo) error {
+	return errors.New("not implemented")
+}
+
+func (du *fakeDiskUtil) MountReadOnly(ctx context.Context, volume diskutil.VolumeInfo) error {
+	return errors.New("not implemented")
+}
+
+func (du *fakeDiskUtil) Unmount(ctx context.Context, volume diskutil.VolumeInfo) error {
+	return errors.New("not implemented")
+}
+
+func (du *fakeDiskUtil) Eject(ctx context.Context, volume diskutil.VolumeInfo) error {
+	return errors.New("not implemented")
+}
+
+func (du *fakeDiskUtil) UnlockVolume(ctx context.Context, volume diskutil.VolumeInfo, passphrase string) error {
+	return errors.New("not implemented")
+}
+
+func (du *fakeDiskUtil) LockVolume(ctx context.Context, volume diskutil.VolumeInfo) error {
+	return errors.New("not implemented")
+}
+
 type readonlyFakeDiskUtil struct {
 	du *fakeDiskUtil
 
 	diskutil.DiskUtil
 }
 
-func (du *readonlyFakeDiskUtil) Info(volume string) (diskutil.VolumeInfo, error) {
-	return du.du.Info(volume)
+func (du *readonlyFakeDiskUtil) Info(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	return du.du.Info(ctx, volume)
+}
+
+func (du *readonlyFakeDiskUtil) ListSnapshots(ctx context.Context, volume diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
+	return du.du.ListSnapshots(ctx, volume)
+}
+
+func (du *readonlyFakeDiskUtil) APFSVolumes(ctx context.Context) ([]diskutil.VolumeInfo, error) {
+	return du.du.APFSVolumes(ctx)
 }
 
-func (du *readonlyFakeDiskUtil) ListSnapshots(volume diskutil.VolumeInfo) ([]diskutil.Snapshot, error) {
-	return du.du.ListSnapshots(volume)
+func (du *readonlyFakeDiskUtil) ContainerVolumes(ctx context.Context, container string) ([]diskutil.VolumeInfo, error) {
+	return du.du.ContainerVolumes(ctx, container)
 }
 
 type fakeASR struct {
 	devices *fakeDevices
 }
 
-func (asr *fakeASR) Restore(source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+func (asr *fakeASR) Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
 	// Validate source and target volumes exist.
 	if _, err := asr.devices.Volume(source.UUID); err != nil {
 		return err
@@ -202,7 +282,11 @@ func (asr *fakeASR) Restore(source, target diskutil.VolumeInfo, to, from diskuti
 	return asr.devices.AddVolume(target, snaps...)
 }
 
-func (asr *fakeASR) DestructiveRestore(source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+func (asr *fakeASR) Check(ctx context.Context) error {
+	return nil
+}
+
+func (asr *fakeASR) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
 	// Validate source and target volumes exist.
 	if _, err := asr.devices.Volume(source.UUID); err != nil {
 		return err
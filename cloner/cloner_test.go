@@ -1,6 +1,11 @@
 package cloner
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -9,6 +14,9 @@ import (
 
 	"github.com/voidingwarranties/offsite-apfs-backup/asr"
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/history"
+	"github.com/voidingwarranties/offsite-apfs-backup/snapshotdiff"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
 )
 
 func TestCloneable(t *testing.T) {
@@ -136,7 +144,7 @@ func TestCloneable(t *testing.T) {
 			var r asr.ASR = nil
 
 			c := New(du, r, test.opts...)
-			if err := c.Cloneable(test.source, test.targets...); err != nil {
+			if err := c.Cloneable(context.Background(), test.source, test.targets...); err != nil {
 				t.Errorf("Cloneable returned error: %q, want: nil", err)
 			}
 		})
@@ -199,6 +207,16 @@ func TestCloneable_Errors(t *testing.T) {
 		FileSystem:     "APFS",
 	}
 
+	locked := diskutil.VolumeInfo{
+		Name:           "locked-name",
+		UUID:           "123-locked-uuid",
+		Device:         "/dev/disk-locked",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		Encrypted:      true,
+		Locked:         true,
+	}
+
 	latestSnap := diskutil.Snapshot{
 		Name: "latest-snap",
 		UUID: "latest-snap-uuid",
@@ -307,6 +325,15 @@ func TestCloneable_Errors(t *testing.T) {
 			source:  hfs.UUID,
 			targets: []string{target.UUID},
 		},
+		{
+			name: "source is locked",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(locked, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			),
+			source:  locked.UUID,
+			targets: []string{target.UUID},
+		},
 		{
 			name: "target is not an APFS volume",
 			fakeDevices: newFakeDevices(t,
@@ -344,6 +371,16 @@ func TestCloneable_Errors(t *testing.T) {
 			source:  source.Device,
 			targets: []string{uninitializedTarget.Device},
 		},
+		{
+			name: "to snapshot not in source",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(source, latestSnap, commonSnap),
+				withFakeVolume(target, commonSnap),
+			),
+			opts:    []Option{ToSnapshot("does-not-exist")},
+			source:  source.UUID,
+			targets: []string{target.UUID},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -355,7 +392,7 @@ func TestCloneable_Errors(t *testing.T) {
 			var r asr.ASR = nil
 
 			c := New(du, r, test.opts...)
-			if err := c.Cloneable(test.source, test.targets...); err == nil {
+			if err := c.Cloneable(context.Background(), test.source, test.targets...); err == nil {
 				t.Error("Cloneable returnd error: nil, want: non-nil")
 			}
 		})
@@ -381,8 +418,8 @@ func TestClone(t *testing.T) {
 		source      string
 		target      string
 
-		wantSourceSnaps []diskutil.Snapshot
-		wantTargetSnaps []diskutil.Snapshot
+		wantSourceSnaps diskutil.SnapshotList
+		wantTargetSnaps diskutil.SnapshotList
 	}{
 		{
 			name: "incremental clone - default options",
@@ -416,6 +453,96 @@ func TestClone(t *testing.T) {
 				snap1,
 			},
 		},
+		{
+			name: "incremental clone - snapshot filter",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					diskutil.Snapshot{
+						Name:    "filtered-snap",
+						UUID:    "123-filtered-uuid",
+						Created: snap2.Created.Add(time.Hour),
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap1,
+				),
+			),
+			opts: []Option{
+				SnapshotFilter(func(s diskutil.Snapshot) bool {
+					return s.Name != "filtered-snap"
+				}),
+			},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+			wantSourceSnaps: []diskutil.Snapshot{
+				{
+					Name:    "filtered-snap",
+					UUID:    "123-filtered-uuid",
+					Created: snap2.Created.Add(time.Hour),
+				},
+				snap2,
+				snap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+		},
+		{
+			name: "incremental clone - to snapshot",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					diskutil.Snapshot{
+						Name:    "bad-snap",
+						UUID:    "123-bad-uuid",
+						Created: snap2.Created.Add(time.Hour),
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap1,
+				),
+			),
+			opts:   []Option{ToSnapshot(snap2.UUID)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+			wantSourceSnaps: []diskutil.Snapshot{
+				{
+					Name:    "bad-snap",
+					UUID:    "123-bad-uuid",
+					Created: snap2.Created.Add(time.Hour),
+				},
+				snap2,
+				snap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+		},
 		{
 			name: "incremental clone - prune target",
 			fakeDevices: newFakeDevices(t,
@@ -479,29 +606,160 @@ func TestClone(t *testing.T) {
 				snap2,
 			},
 		},
+		{
+			name: "initialize clone - prune",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+				),
+			),
+			opts:   []Option{InitializeTargets(true), Prune(true)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+			wantSourceSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{
+				snap2,
+			},
+		},
+		{
+			name: "restore and verify",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap1,
+				),
+			),
+			opts:   []Option{Only(PhaseRestore, PhaseVerify)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+			wantSourceSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+		},
+		{
+			name: "verify only",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+			),
+			opts:   []Option{Only(PhaseVerify)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+			wantSourceSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+		},
+		{
+			name: "prune only - after restore",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+			),
+			opts:   []Option{Only(PhasePrune)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+			wantSourceSnaps: []diskutil.Snapshot{
+				snap2,
+				snap1,
+			},
+			wantTargetSnaps: []diskutil.Snapshot{
+				snap2,
+			},
+		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			du := &fakeDiskUtil{test.fakeDevices}
 			r := &fakeASR{test.fakeDevices}
 			c := New(du, r, test.opts...)
-			if err := c.Clone(test.source, test.target); err != nil {
+			if err := c.Clone(context.Background(), test.source, test.target); err != nil {
 				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
 			}
 
-			sourceInfo, err := du.Info(test.source)
+			sourceInfo, err := du.Info(context.Background(), test.source)
 			if err != nil {
 				t.Fatal(err)
 			}
-			targetInfo, err := du.Info(test.target)
+			targetInfo, err := du.Info(context.Background(), test.target)
 			if err != nil {
 				t.Fatal(err)
 			}
-			gotSourceSnaps, err := du.ListSnapshots(sourceInfo)
+			gotSourceSnaps, err := du.ListSnapshots(context.Background(), sourceInfo)
 			if err != nil {
 				t.Fatalf("error listing snapshots: %v", err)
 			}
-			gotTargetSnaps, err := du.ListSnapshots(targetInfo)
+			gotTargetSnaps, err := du.ListSnapshots(context.Background(), targetInfo)
 			if err != nil {
 				t.Fatalf("error listing snapshots: %v", err)
 			}
@@ -539,8 +797,8 @@ func TestClone_DryRun(t *testing.T) {
 		source      string
 		target      string
 
-		wantSourceSnaps []diskutil.Snapshot
-		wantTargetSnaps []diskutil.Snapshot
+		wantSourceSnaps diskutil.SnapshotList
+		wantTargetSnaps diskutil.SnapshotList
 	}{
 		{
 			name: "incremental prune dryrun",
@@ -614,23 +872,23 @@ func TestClone_DryRun(t *testing.T) {
 			})
 			r := asr.NewDryRun()
 			c := New(du, r, test.opts...)
-			if err := c.Clone(test.source, test.target); err != nil {
+			if err := c.Clone(context.Background(), test.source, test.target); err != nil {
 				t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
 			}
 
-			sourceInfo, err := du.Info(test.source)
+			sourceInfo, err := du.Info(context.Background(), test.source)
 			if err != nil {
 				t.Fatal(err)
 			}
-			targetInfo, err := du.Info(test.target)
+			targetInfo, err := du.Info(context.Background(), test.target)
 			if err != nil {
 				t.Fatal(err)
 			}
-			gotSourceSnaps, err := du.ListSnapshots(sourceInfo)
+			gotSourceSnaps, err := du.ListSnapshots(context.Background(), sourceInfo)
 			if err != nil {
 				t.Fatalf("error listing snapshots: %v", err)
 			}
-			gotTargetSnaps, err := du.ListSnapshots(targetInfo)
+			gotTargetSnaps, err := du.ListSnapshots(context.Background(), targetInfo)
 			if err != nil {
 				t.Fatalf("error listing snapshots: %v", err)
 			}
@@ -839,20 +1097,1691 @@ func TestClone_Errors(t *testing.T) {
 			source: "/foo/mount/point",
 			target: "/bar/mount/point",
 		},
-	}
-	for _, test := range tests {
-		t.Run(test.name, func(t *testing.T) {
-			// readonly so that test panics if any modifying methods are called.
-			du := &readonlyFakeDiskUtil{
-				du: &fakeDiskUtil{test.fakeDevices},
-			}
-			// nil so that test panics if asr is called.
-			var r asr.ASR = nil
-
-			c := New(du, r, test.opts...)
-			if err := c.Clone(test.source, test.target); err == nil {
-				t.Fatal("Clone(...) returned unexpected error: nil, want: non-nil")
-			}
-		})
+		{
+			name: "verify only - target not restored",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap1,
+				),
+			),
+			opts:   []Option{Only(PhaseVerify)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+		},
+		{
+			name: "prune only - target not restored",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap1,
+				),
+			),
+			opts:   []Option{Only(PhasePrune)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+		},
+		{
+			name: "prune only - no previous common snapshot",
+			fakeDevices: newFakeDevices(t,
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "foo-name",
+						UUID:       "123-foo-uuid",
+						MountPoint: "/foo/mount/point",
+					},
+					snap2,
+					snap1,
+				),
+				withFakeVolume(
+					diskutil.VolumeInfo{
+						Name:       "bar-name",
+						UUID:       "123-bar-uuid",
+						MountPoint: "/bar/mount/point",
+					},
+					snap2,
+				),
+			),
+			opts:   []Option{Only(PhasePrune)},
+			source: "/foo/mount/point",
+			target: "/bar/mount/point",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// readonly so that test panics if any modifying methods are called.
+			du := &readonlyFakeDiskUtil{
+				du: &fakeDiskUtil{test.fakeDevices},
+			}
+			// nil so that test panics if asr is called.
+			var r asr.ASR = nil
+
+			c := New(du, r, test.opts...)
+			if err := c.Clone(context.Background(), test.source, test.target); err == nil {
+				t.Fatal("Clone(...) returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestClone_History(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	snap3 := diskutil.Snapshot{
+		Name: "snap-3",
+		UUID: "123-snap-3-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:       "foo-name",
+		UUID:       "123-foo-uuid",
+		MountPoint: "/foo/mount/point",
+	}
+	target := diskutil.VolumeInfo{
+		Name:       "bar-name",
+		UUID:       "123-bar-uuid",
+		MountPoint: t.TempDir(),
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, snap3, snap2, snap1),
+		withFakeVolume(target, snap1),
+	)
+	du := &fakeDiskUtil{devices}
+	r := &fakeASR{devices}
+
+	// Clone and verify while the record matches.
+	c := New(du, r, History(true), Only(PhaseRestore, PhaseVerify))
+	if err := c.Clone(context.Background(), source.UUID, target.UUID); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %q, want: nil", err)
+	}
+	if _, exists, err := history.Read(target.MountPoint); err != nil || !exists {
+		t.Fatalf("Clone(...) did not record history (exists: %t, err: %v)", exists, err)
+	}
+
+	// Delete a snapshot from target behind Cloner's back.
+	if err := devices.DeleteSnapshot(target.UUID, snap1.UUID); err != nil {
+		t.Fatal(err)
+	}
+	c = New(du, nil, History(true), Only(PhaseVerify))
+	err := c.Clone(context.Background(), source.UUID, target.UUID)
+	var divergedErr *history.DivergedError
+	if !errors.As(err, &divergedErr) {
+		t.Fatalf("Clone(...) of diverged target returned unexpected error: %v, want type: *history.DivergedError", err)
+	}
+	if diff := cmp.Diff([]string{snap1.UUID}, divergedErr.Missing); diff != "" {
+		t.Errorf("Clone(...) returned unexpected missing snapshots. -want +got:\n%s", diff)
+	}
+}
+
+// noopASR is an asr.ASR that reports success without restoring anything, like
+// a restore that silently did not complete.
+type noopASR struct{}
+
+func (noopASR) Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	return nil
+}
+
+func (noopASR) DestructiveRestore(ctx context.Context, source, target diskutil.VolumeInfo, to diskutil.Snapshot) error {
+	return nil
+}
+
+func (noopASR) Check(ctx context.Context) error {
+	return nil
+}
+
+func TestClone_VerifyBeforePrune(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:       "foo-name",
+		UUID:       "123-foo-uuid",
+		MountPoint: "/foo/mount/point",
+	}
+	target := diskutil.VolumeInfo{
+		Name:       "bar-name",
+		UUID:       "123-bar-uuid",
+		MountPoint: "/bar/mount/point",
+	}
+	tests := []struct {
+		name            string
+		asr             func(*fakeDevices) asr.ASR
+		wantErr         error
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "verified",
+			asr:             func(d *fakeDevices) asr.ASR { return &fakeASR{d} },
+			wantTargetSnaps: []diskutil.Snapshot{snap2},
+		},
+		{
+			name:            "not verified",
+			asr:             func(*fakeDevices) asr.ASR { return noopASR{} },
+			wantErr:         ErrPruneSkipped,
+			wantTargetSnaps: []diskutil.Snapshot{snap1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap2, snap1),
+				withFakeVolume(target, snap1),
+			)
+			c := New(&fakeDiskUtil{devices}, test.asr(devices), Prune(true), VerifyBeforePrune(true))
+			if err := c.Clone(context.Background(), source.UUID, target.UUID); !errors.Is(err, test.wantErr) {
+				t.Errorf("Clone(...) returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+			gotTargetSnaps, err := devices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps); diff != "" {
+				t.Errorf("Clone(...) left unexpected target snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_PhaseTimes(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:       "foo-name",
+		UUID:       "123-foo-uuid",
+		MountPoint: "/foo/mount/point",
+	}
+	target := diskutil.VolumeInfo{
+		Name:       "bar-name",
+		UUID:       "123-bar-uuid",
+		MountPoint: "/bar/mount/point",
+	}
+	tests := []struct {
+		name   string
+		phases []Phase
+		asr    func(*fakeDevices) asr.ASR
+		want   map[Phase]time.Duration
+	}{
+		{
+			name:   "all phases",
+			phases: []Phase{PhaseRestore, PhaseVerify, PhasePrune},
+			asr:    func(d *fakeDevices) asr.ASR { return &fakeASR{d} },
+			want: map[Phase]time.Duration{
+				PhaseRestore: 0,
+				PhaseVerify:  0,
+				PhasePrune:   0,
+			},
+		},
+		{
+			name:   "failed phase",
+			phases: []Phase{PhaseRestore, PhaseVerify},
+			asr:    func(*fakeDevices) asr.ASR { return noopASR{} },
+			want: map[Phase]time.Duration{
+				PhaseRestore: 0,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap2, snap1),
+				withFakeVolume(target, snap1),
+			)
+			got := make(map[Phase]time.Duration)
+			c := New(&fakeDiskUtil{devices}, test.asr(devices),
+				Only(test.phases...),
+				Clock(fakeclock.New(time.Now())),
+				PhaseTimes(func(p Phase, d time.Duration) {
+					got[p] = d
+				}),
+			)
+			c.Clone(context.Background(), source.UUID, target.UUID)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Clone(...) reported unexpected phase times. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestClone_Keep(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	snap3 := diskutil.Snapshot{
+		Name: "snap-3",
+		UUID: "123-snap-3-uuid",
+	}
+	snap4 := diskutil.Snapshot{
+		Name: "snap-4",
+		UUID: "123-snap-4-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:       "foo-name",
+		UUID:       "123-foo-uuid",
+		MountPoint: "/foo/mount/point",
+	}
+	target := diskutil.VolumeInfo{
+		Name:       "bar-name",
+		UUID:       "123-bar-uuid",
+		MountPoint: "/bar/mount/point",
+	}
+	tests := []struct {
+		name            string
+		keep            int
+		minKeep         int
+		targetSnaps     []diskutil.Snapshot
+		only            []Phase
+		wantErr         bool
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "prunes to keep",
+			keep:            2,
+			targetSnaps:     []diskutil.Snapshot{snap3, snap2, snap1},
+			wantTargetSnaps: []diskutil.Snapshot{snap4, snap3},
+		},
+		{
+			name:            "fewer than keep",
+			keep:            5,
+			targetSnaps:     []diskutil.Snapshot{snap3, snap2, snap1},
+			wantTargetSnaps: []diskutil.Snapshot{snap4, snap3, snap2, snap1},
+		},
+		{
+			name:            "minimum kept",
+			keep:            1,
+			minKeep:         3,
+			targetSnaps:     []diskutil.Snapshot{snap3, snap2, snap1},
+			wantTargetSnaps: []diskutil.Snapshot{snap4, snap3, snap2},
+		},
+		{
+			name:            "prune only",
+			keep:            1,
+			targetSnaps:     []diskutil.Snapshot{snap4, snap3, snap2},
+			only:            []Phase{PhasePrune},
+			wantTargetSnaps: []diskutil.Snapshot{snap4},
+		},
+		{
+			name:            "prune only without latest source snapshot",
+			keep:            1,
+			targetSnaps:     []diskutil.Snapshot{snap3, snap2},
+			only:            []Phase{PhasePrune},
+			wantErr:         true,
+			wantTargetSnaps: []diskutil.Snapshot{snap3, snap2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap4, snap3, snap2, snap1),
+				withFakeVolume(target, test.targetSnaps...),
+			)
+			opts := []Option{Keep(test.keep), MinKeep(test.minKeep)}
+			if test.only != nil {
+				opts = append(opts, Only(test.only...))
+			}
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, opts...)
+			err := c.Clone(context.Background(), source.UUID, target.UUID)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("Clone(...) returned unexpected error: %v, want error: %v", err, test.wantErr)
+			}
+			gotTargetSnaps, err := devices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps); diff != "" {
+				t.Errorf("Clone(...) left unexpected target snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPruneSource(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	snap3 := diskutil.Snapshot{
+		Name: "snap-3",
+		UUID: "123-snap-3-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:       "foo-name",
+		UUID:       "123-foo-uuid",
+		MountPoint: "/foo/mount/point",
+	}
+	target1 := diskutil.VolumeInfo{
+		Name:       "bar-name",
+		UUID:       "123-bar-uuid",
+		MountPoint: "/bar/mount/point",
+	}
+	target2 := diskutil.VolumeInfo{
+		Name:       "baz-name",
+		UUID:       "123-baz-uuid",
+		MountPoint: "/baz/mount/point",
+	}
+	tests := []struct {
+		name            string
+		pruneSource     bool
+		minKeep         int
+		target1Snaps    []diskutil.Snapshot
+		target2Snaps    []diskutil.Snapshot
+		wantErr         bool
+		wantSourceSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "prunes previous common snapshots",
+			pruneSource:     true,
+			target1Snaps:    []diskutil.Snapshot{snap3, snap2},
+			target2Snaps:    []diskutil.Snapshot{snap3, snap1},
+			wantSourceSnaps: []diskutil.Snapshot{snap3},
+		},
+		{
+			name:            "shared common snapshot",
+			pruneSource:     true,
+			target1Snaps:    []diskutil.Snapshot{snap3, snap2},
+			target2Snaps:    []diskutil.Snapshot{snap3, snap2},
+			wantSourceSnaps: []diskutil.Snapshot{snap3, snap1},
+		},
+		{
+			name:            "disabled",
+			target1Snaps:    []diskutil.Snapshot{snap3, snap2},
+			target2Snaps:    []diskutil.Snapshot{snap3, snap1},
+			wantSourceSnaps: []diskutil.Snapshot{snap3, snap2, snap1},
+		},
+		{
+			name:            "initialized target",
+			pruneSource:     true,
+			target1Snaps:    []diskutil.Snapshot{snap3},
+			target2Snaps:    []diskutil.Snapshot{snap3},
+			wantSourceSnaps: []diskutil.Snapshot{snap3, snap2, snap1},
+		},
+		{
+			name:            "minimum kept",
+			pruneSource:     true,
+			minKeep:         2,
+			target1Snaps:    []diskutil.Snapshot{snap3, snap2},
+			target2Snaps:    []diskutil.Snapshot{snap3, snap1},
+			wantSourceSnaps: []diskutil.Snapshot{snap3, snap2, snap1},
+		},
+		{
+			name:            "target without latest source snapshot",
+			pruneSource:     true,
+			target1Snaps:    []diskutil.Snapshot{snap3, snap2},
+			target2Snaps:    []diskutil.Snapshot{snap1},
+			wantErr:         true,
+			wantSourceSnaps: []diskutil.Snapshot{snap3, snap2, snap1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap3, snap2, snap1),
+				withFakeVolume(target1, test.target1Snaps...),
+				withFakeVolume(target2, test.target2Snaps...),
+			)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, PruneSource(test.pruneSource), MinKeep(test.minKeep))
+			err := c.PruneSource(context.Background(), source.UUID, target1.UUID, target2.UUID)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Errorf("PruneSource(...) returned unexpected error: %v, want error: %v", err, test.wantErr)
+			}
+			gotSourceSnaps, err := devices.Snapshots(source.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantSourceSnaps, gotSourceSnaps); diff != "" {
+				t.Errorf("PruneSource(...) left unexpected source snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPrunes(t *testing.T) {
+	snap1 := diskutil.Snapshot{Name: "snap-1", UUID: "123-snap-1-uuid"}
+	snap2 := diskutil.Snapshot{Name: "snap-2", UUID: "123-snap-2-uuid"}
+	snap3 := diskutil.Snapshot{Name: "snap-3", UUID: "123-snap-3-uuid"}
+	snap4 := diskutil.Snapshot{Name: "snap-4", UUID: "123-snap-4-uuid"}
+	plan := Plan{SourceSnaps: []diskutil.Snapshot{snap4, snap3, snap2, snap1}}
+	tests := []struct {
+		name   string
+		opts   []Option
+		target TargetPlan
+		want   diskutil.SnapshotList
+	}{
+		{
+			name: "no prune",
+			target: TargetPlan{
+				TargetSnaps: []diskutil.Snapshot{snap3, snap2},
+				Common:      snap3,
+			},
+		},
+		{
+			name: "prune",
+			opts: []Option{Prune(true)},
+			target: TargetPlan{
+				TargetSnaps: []diskutil.Snapshot{snap3, snap2},
+				Common:      snap3,
+			},
+			want: []diskutil.Snapshot{snap3},
+		},
+		{
+			name: "prune below minimum kept",
+			opts: []Option{Prune(true), MinKeep(3)},
+			target: TargetPlan{
+				TargetSnaps: []diskutil.Snapshot{snap3, snap2},
+				Common:      snap3,
+			},
+		},
+		{
+			name: "keep",
+			opts: []Option{Keep(2)},
+			target: TargetPlan{
+				TargetSnaps: []diskutil.Snapshot{snap3, snap2, snap1},
+				Common:      snap3,
+			},
+			want: []diskutil.Snapshot{snap2, snap1},
+		},
+		{
+			name: "initialize",
+			opts: []Option{Prune(true), InitializeTargets(true)},
+		},
+		{
+			name: "prune only",
+			opts: []Option{Prune(true), Only(PhasePrune)},
+			target: TargetPlan{
+				TargetSnaps: []diskutil.Snapshot{snap4, snap3, snap2},
+			},
+			want: []diskutil.Snapshot{snap3},
+		},
+		{
+			name: "prune only without latest source snapshot",
+			opts: []Option{Prune(true), Only(PhasePrune)},
+			target: TargetPlan{
+				TargetSnaps: []diskutil.Snapshot{snap3, snap2},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New(nil, nil, test.opts...)
+			got := c.Prunes(plan, test.target)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Prunes(...) returned unexpected snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestEligibleTargets(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "source-uuid",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	eligible := diskutil.VolumeInfo{
+		Name:           "eligible-name",
+		UUID:           "eligible-uuid",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	readonly := diskutil.VolumeInfo{
+		Name:           "readonly-name",
+		UUID:           "readonly-uuid",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	caseSensitive := diskutil.VolumeInfo{
+		Name:           "case-sensitive-name",
+		UUID:           "case-sensitive-uuid",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "Case-sensitive APFS",
+	}
+	unrelated := diskutil.VolumeInfo{
+		Name:           "unrelated-name",
+		UUID:           "unrelated-uuid",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, snap2, snap1),
+		withFakeVolume(eligible, snap1),
+		withFakeVolume(readonly, snap1),
+		withFakeVolume(caseSensitive, snap1),
+		withFakeVolume(unrelated),
+	)
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices})
+	got, err := c.EligibleTargets(context.Background(), source.UUID)
+	if err != nil {
+		t.Fatalf("EligibleTargets(...) returned unexpected error: %v, want: nil", err)
+	}
+	want := []TargetPlan{{
+		Arg:          eligible.UUID,
+		Target:       eligible,
+		TargetSnaps:  diskutil.SnapshotList{snap1},
+		Common:       snap1,
+		CommonReason: "latest common snapshot in target",
+	}}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("EligibleTargets(...) returned unexpected targets. -want +got:\n%s", diff)
+	}
+
+	// Initialized targets must have no snapshots instead.
+	c = New(&fakeDiskUtil{devices}, &fakeASR{devices}, InitializeTargets(true))
+	got, err = c.EligibleTargets(context.Background(), source.UUID)
+	if err != nil {
+		t.Fatalf("EligibleTargets(...) returned unexpected error: %v, want: nil", err)
+	}
+	want = []TargetPlan{{
+		Arg:    unrelated.UUID,
+		Target: unrelated,
+	}}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("EligibleTargets(...) with InitializeTargets returned unexpected targets. -want +got:\n%s", diff)
+	}
+}
+
+func TestClone_MinKeep(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	snap3 := diskutil.Snapshot{
+		Name: "snap-3",
+		UUID: "123-snap-3-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:       "foo-name",
+		UUID:       "123-foo-uuid",
+		MountPoint: "/foo/mount/point",
+	}
+	target := diskutil.VolumeInfo{
+		Name:       "bar-name",
+		UUID:       "123-bar-uuid",
+		MountPoint: "/bar/mount/point",
+	}
+	tests := []struct {
+		name            string
+		minKeep         int
+		targetSnaps     []diskutil.Snapshot
+		only            []Phase
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "no minimum",
+			targetSnaps:     []diskutil.Snapshot{snap2},
+			wantTargetSnaps: []diskutil.Snapshot{snap3},
+		},
+		{
+			name:            "minimum kept",
+			minKeep:         2,
+			targetSnaps:     []diskutil.Snapshot{snap2, snap1},
+			wantTargetSnaps: []diskutil.Snapshot{snap3, snap1},
+		},
+		{
+			name:            "prune skipped",
+			minKeep:         2,
+			targetSnaps:     []diskutil.Snapshot{snap2},
+			wantTargetSnaps: []diskutil.Snapshot{snap3, snap2},
+		},
+		{
+			name:            "prune only skipped",
+			minKeep:         2,
+			targetSnaps:     []diskutil.Snapshot{snap3, snap2},
+			only:            []Phase{PhasePrune},
+			wantTargetSnaps: []diskutil.Snapshot{snap3, snap2},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap3, snap2, snap1),
+				withFakeVolume(target, test.targetSnaps...),
+			)
+			opts := []Option{Prune(true), MinKeep(test.minKeep)}
+			if test.only != nil {
+				opts = append(opts, Only(test.only...))
+			}
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, opts...)
+			if err := c.Clone(context.Background(), source.UUID, target.UUID); err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %v, want: nil", err)
+			}
+			gotTargetSnaps, err := devices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, gotTargetSnaps); diff != "" {
+				t.Errorf("Clone(...) left unexpected target snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+// Test that ClonePlanned clones the snapshots chosen by Preflight, even if
+// source has a newer snapshot by the time of the clone.
+func TestClonePlanned(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	snap3 := diskutil.Snapshot{
+		Name: "snap-3",
+		UUID: "123-snap-3-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "foo-name",
+		UUID:           "123-foo-uuid",
+		MountPoint:     "/foo/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "bar-name",
+		UUID:           "123-bar-uuid",
+		MountPoint:     "/bar/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, snap2, snap1),
+		withFakeVolume(target, snap1),
+	)
+	du := &fakeDiskUtil{devices}
+	c := New(du, &fakeASR{devices})
+
+	plan, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint)
+	if err != nil {
+		t.Fatalf("Preflight(...) returned unexpected error: %q, want: nil", err)
+	}
+	if err := devices.AddSnapshot(source.UUID, snap3); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ClonePlanned(context.Background(), plan, target.MountPoint); err != nil {
+		t.Fatalf("ClonePlanned(...) returned unexpected error: %q, want: nil", err)
+	}
+	gotTargetSnaps, err := du.ListSnapshots(context.Background(), target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := diskutil.SnapshotList{snap2, snap1}
+	if diff := cmp.Diff(want, gotTargetSnaps); diff != "" {
+		t.Errorf("ClonePlanned(...) resulted in unexpected snapshots in target. -want +got:\n%s", diff)
+	}
+
+	if err := c.ClonePlanned(context.Background(), plan, "/not/planned"); err == nil {
+		t.Error("ClonePlanned(...) of unplanned target returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestPreflight_Warnings(t *testing.T) {
+	now := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+	fresh := diskutil.Snapshot{
+		Name:    "fresh",
+		UUID:    "123-fresh-uuid",
+		Created: now.Add(-time.Hour),
+	}
+	stale := diskutil.Snapshot{
+		Name:    "stale",
+		UUID:    "123-stale-uuid",
+		Created: now.Add(-8 * 24 * time.Hour),
+	}
+	older := diskutil.Snapshot{
+		Name:    "older",
+		UUID:    "123-older-uuid",
+		Created: now.Add(-30 * 24 * time.Hour),
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		PhysicalStores: []diskutil.PhysicalStore{{Device: "disk0s2"}},
+		SMARTStatus:    "Verified",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		PhysicalStores: []diskutil.PhysicalStore{{Device: "disk4s2"}},
+		SMARTStatus:    "Verified",
+	}
+	sameDiskTarget := target
+	sameDiskTarget.PhysicalStores = []diskutil.PhysicalStore{{Device: "disk0s3"}}
+	failingTarget := target
+	failingTarget.SMARTStatus = "Failing"
+	fullSource := source
+	fullSource.ContainerSize = 1000e9
+	fullSource.ContainerFree = 50e9
+	notFullSource := source
+	notFullSource.ContainerSize = 1000e9
+	notFullSource.ContainerFree = 100e9
+
+	tests := []struct {
+		name        string
+		source      diskutil.VolumeInfo
+		sourceSnaps []diskutil.Snapshot
+		target      diskutil.VolumeInfo
+		targetSnaps []diskutil.Snapshot
+		opts        []Option
+		want        []Warning
+	}{
+		{
+			name:        "no warnings",
+			sourceSnaps: []diskutil.Snapshot{fresh, older},
+			target:      target,
+		},
+		{
+			name:        "nearly full source container",
+			source:      fullSource,
+			sourceSnaps: []diskutil.Snapshot{fresh, older},
+			target:      target,
+			want: []Warning{
+				{Message: "APFS container is 95% full (50.0 GB free), which slows down and may fail snapshots and restores - thin source's snapshots, e.g. with `tmutil thinlocalsnapshots`"},
+			},
+		},
+		{
+			name:        "source container not nearly full",
+			source:      notFullSource,
+			sourceSnaps: []diskutil.Snapshot{fresh, older},
+			target:      target,
+		},
+		{
+			name:        "stale snapshot",
+			sourceSnaps: []diskutil.Snapshot{stale, older},
+			target:      target,
+			want: []Warning{
+				{Message: "latest snapshot stale (123-stale-uuid) is stale: it was created 192h0m0s ago"},
+			},
+		},
+		{
+			name:        "stale snapshot - disabled",
+			sourceSnaps: []diskutil.Snapshot{stale, older},
+			target:      target,
+			opts:        []Option{StaleAfter(0)},
+		},
+		{
+			name:        "same physical disk",
+			sourceSnaps: []diskutil.Snapshot{fresh, older},
+			target:      sameDiskTarget,
+			want: []Warning{
+				{Target: "/target/mount/point", Message: "target is on the same physical disk as source (disk0), so a disk failure would lose both"},
+			},
+		},
+		{
+			name:        "failing SMART status",
+			sourceSnaps: []diskutil.Snapshot{fresh, older},
+			target:      failingTarget,
+			want: []Warning{
+				{Target: "/target/mount/point", Message: "disk S.M.A.R.T. status is Failing"},
+			},
+		},
+		{
+			name:        "older common snapshot",
+			sourceSnaps: []diskutil.Snapshot{fresh, stale, older},
+			target:      target,
+			targetSnaps: []diskutil.Snapshot{older, stale},
+			want: []Warning{
+				{Target: "/target/mount/point", Message: "restoring from common snapshot older (123-older-uuid) created at 2021-02-13T12:00:00Z, though stale (123-stale-uuid) created at 2021-03-07T12:00:00Z is a newer snapshot in common, so asr transfers more data than needed - check that snapshot names are parsed with the right timestamps"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.source.UUID == "" {
+				test.source = source
+			}
+			if test.targetSnaps == nil {
+				test.targetSnaps = []diskutil.Snapshot{older}
+			}
+			devices := newFakeDevices(t,
+				withFakeVolume(test.source, test.sourceSnaps...),
+				withFakeVolume(test.target, test.targetSnaps...),
+			)
+			opts := append([]Option{Clock(fakeclock.New(now))}, test.opts...)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, opts...)
+			plan, err := c.Preflight(context.Background(), source.MountPoint, test.target.MountPoint)
+			if err != nil {
+				t.Fatalf("Preflight(...) returned unexpected error: %q, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, plan.Warnings, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Preflight(...) returned unexpected warnings. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPreflight_MaxSnapshotAge(t *testing.T) {
+	now := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+	recent := diskutil.Snapshot{
+		Name:    "other-tool.recent",
+		UUID:    "123-recent-uuid",
+		Created: now.Add(-time.Hour),
+	}
+	old := diskutil.Snapshot{
+		Name:    "allowed-tool.old",
+		UUID:    "123-old-uuid",
+		Created: now.Add(-72 * time.Hour),
+	}
+	older := diskutil.Snapshot{
+		Name:    "allowed-tool.older",
+		UUID:    "123-older-uuid",
+		Created: now.Add(-96 * time.Hour),
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	allowedTool := SnapshotFilter(func(s diskutil.Snapshot) bool {
+		return strings.HasPrefix(s.Name, "allowed-tool.")
+	})
+
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr error
+	}{
+		{
+			name: "latest snapshot is recent",
+			opts: []Option{MaxSnapshotAge(48 * time.Hour)},
+		},
+		{
+			name:    "latest allowed snapshot is too old",
+			opts:    []Option{MaxSnapshotAge(48 * time.Hour), allowedTool},
+			wantErr: ErrSnapshotTooOld,
+		},
+		{
+			name: "latest allowed snapshot is within maximum",
+			opts: []Option{MaxSnapshotAge(80 * time.Hour), allowedTool},
+		},
+		{
+			name: "no maximum",
+			opts: []Option{allowedTool},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, recent, old, older),
+				withFakeVolume(target, older),
+			)
+			opts := append([]Option{Clock(fakeclock.New(now))}, test.opts...)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, opts...)
+			_, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Preflight(...) returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreflight_Reserve(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		UsedBytes:      60e9,
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		UsedBytes:      50e9,
+		ContainerSize:  100e9,
+		ContainerFree:  40e9,
+	}
+	tests := []struct {
+		name    string
+		reserve int
+		wantErr error
+	}{
+		{
+			name:    "leaves reserve free",
+			reserve: 30,
+		},
+		{
+			name:    "leaves less than reserve free",
+			reserve: 31,
+			wantErr: ErrBelowReserve,
+		},
+		{
+			name: "no reserve",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap2, snap1),
+				withFakeVolume(target, snap1),
+			)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, Reserve(test.reserve))
+			_, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Preflight(...) returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreflight_InsufficientSpace(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+		UsedBytes:      50e9,
+		ContainerSize:  100e9,
+		ContainerFree:  40e9,
+	}
+	tests := []struct {
+		name          string
+		sourceUsed    uint64
+		containerSize uint64
+		wantErr       error
+	}{
+		{
+			name:          "fits",
+			sourceUsed:    90e9,
+			containerSize: 100e9,
+		},
+		{
+			name:          "source shrank",
+			sourceUsed:    10e9,
+			containerSize: 100e9,
+		},
+		{
+			name:          "does not fit",
+			sourceUsed:    90e9 + 1,
+			containerSize: 100e9,
+			wantErr:       ErrInsufficientSpace,
+		},
+		{
+			name:       "unknown container size",
+			sourceUsed: 200e9,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source := source
+			source.UsedBytes = test.sourceUsed
+			target := target
+			target.ContainerSize = test.containerSize
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snap2, snap1),
+				withFakeVolume(target, snap1),
+			)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices})
+			_, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Preflight(...) returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestPreflight_CommonStrategy(t *testing.T) {
+	older := diskutil.Snapshot{
+		Name:    "older",
+		UUID:    "123-older-uuid",
+		XID:     100,
+		Created: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+	}
+	newer := diskutil.Snapshot{
+		Name:    "newer",
+		UUID:    "123-newer-uuid",
+		XID:     200,
+		Created: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC),
+	}
+	latest := diskutil.Snapshot{
+		Name:    "latest",
+		UUID:    "123-latest-uuid",
+		XID:     300,
+		Created: time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC),
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	tests := []struct {
+		name         string
+		strategy     snapshotdiff.Strategy
+		wantCommon   diskutil.Snapshot
+		wantReason   string
+		wantWarnings int
+	}{
+		{
+			name:         "latest in target",
+			strategy:     snapshotdiff.LatestInTarget,
+			wantCommon:   older,
+			wantReason:   "latest common snapshot in target",
+			wantWarnings: 1,
+		},
+		{
+			name:       "prefer newest common",
+			strategy:   snapshotdiff.PreferNewestCommon,
+			wantCommon: newer,
+			wantReason: "newest of 2 common snapshot(s) by the timestamps in their names",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// target lists its snapshots out of order.
+			devices := newFakeDevices(t,
+				withFakeVolume(source, latest, newer, older),
+				withFakeVolume(target, older, newer),
+			)
+			c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, StaleAfter(0), CommonStrategy(test.strategy))
+			plan, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint)
+			if err != nil {
+				t.Fatalf("Preflight(...) returned unexpected error: %v, want: nil", err)
+			}
+			got := plan.Targets[0]
+			if got.Common != test.wantCommon || got.CommonReason != test.wantReason {
+				t.Errorf("Preflight(...) planned common snapshot %v (%q), want: %v (%q)", got.Common, got.CommonReason, test.wantCommon, test.wantReason)
+			}
+			if len(plan.Warnings) != test.wantWarnings {
+				t.Errorf("Preflight(...) returned warnings %v, want %d warning(s)", plan.Warnings, test.wantWarnings)
+			}
+		})
+	}
+}
+
+// unsupportedASR is an asr.ASR that cannot restore APFS snapshots.
+type unsupportedASR struct {
+	noopASR
+}
+
+func (unsupportedASR) Check(ctx context.Context) error {
+	return asr.ErrUnsupported
+}
+
+func TestCheckASR(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, snap2, snap1),
+		withFakeVolume(target, snap1),
+	)
+
+	c := New(&fakeDiskUtil{devices}, unsupportedASR{})
+	if _, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint); !errors.Is(err, asr.ErrUnsupported) {
+		t.Errorf("Preflight(...) returned unexpected error: %v, want: %v", err, asr.ErrUnsupported)
+	}
+	if err := c.Clone(context.Background(), source.MountPoint, target.MountPoint); !errors.Is(err, asr.ErrUnsupported) {
+		t.Errorf("Clone(...) returned unexpected error: %v, want: %v", err, asr.ErrUnsupported)
+	}
+
+	// Clones that do not restore do not need asr.
+	c = New(&fakeDiskUtil{devices}, unsupportedASR{}, Only(PhaseVerify))
+	if _, err := c.Preflight(context.Background(), source.MountPoint, target.MountPoint); errors.Is(err, asr.ErrUnsupported) {
+		t.Errorf("Preflight(...) with Only(PhaseVerify) returned unexpected error: %v", err)
+	}
+}
+
+// snapshotSpaceDiskUtil is a fakeDiskUtil whose volumes' containers have free
+// bytes free, less snapshotSize bytes per snapshot of the volume.
+type snapshotSpaceDiskUtil struct {
+	*fakeDiskUtil
+	free         uint64
+	snapshotSize uint64
+}
+
+func (du snapshotSpaceDiskUtil) Info(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	info, err := du.fakeDiskUtil.Info(ctx, volume)
+	if err != nil {
+		return diskutil.VolumeInfo{}, err
+	}
+	snaps, err := du.devices.Snapshots(info.UUID)
+	if err != nil {
+		return diskutil.VolumeInfo{}, err
+	}
+	info.ContainerFree = du.free - uint64(len(snaps))*du.snapshotSize
+	return info, nil
+}
+
+func TestClone_Reserve(t *testing.T) {
+	snaps := make([]diskutil.Snapshot, 5)
+	for i := range snaps {
+		snaps[i] = diskutil.Snapshot{
+			Name: fmt.Sprintf("snap-%d", i+1),
+			UUID: fmt.Sprintf("123-snap-%d-uuid", i+1),
+		}
+	}
+	source := diskutil.VolumeInfo{
+		Name: "source-name",
+		UUID: "123-source-uuid",
+	}
+	target := diskutil.VolumeInfo{
+		Name:          "target-name",
+		UUID:          "123-target-uuid",
+		ContainerSize: 100e9,
+	}
+	tests := []struct {
+		name            string
+		opts            []Option
+		wantTargetSnaps []diskutil.Snapshot
+	}{
+		{
+			name:            "thins to reserve",
+			opts:            []Option{Reserve(50)},
+			wantTargetSnaps: []diskutil.Snapshot{snaps[4], snaps[3], snaps[2]},
+		},
+		{
+			name:            "keeps min keep",
+			opts:            []Option{Reserve(50), MinKeep(4)},
+			wantTargetSnaps: []diskutil.Snapshot{snaps[4], snaps[3], snaps[2], snaps[1]},
+		},
+		{
+			name:            "keeps latest snapshot",
+			opts:            []Option{Reserve(80)},
+			wantTargetSnaps: []diskutil.Snapshot{snaps[4]},
+		},
+		{
+			name:            "reserve already free",
+			opts:            []Option{Reserve(20)},
+			wantTargetSnaps: []diskutil.Snapshot{snaps[4], snaps[3], snaps[2], snaps[1], snaps[0]},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snaps[4], snaps[3], snaps[2], snaps[1], snaps[0]),
+				withFakeVolume(target, snaps[3], snaps[2], snaps[1], snaps[0]),
+			)
+			// Each snapshot uses 10 GB, so that the target has 30 GB free
+			// after it is restored.
+			du := snapshotSpaceDiskUtil{&fakeDiskUtil{devices}, 80e9, 10e9}
+			c := New(du, &fakeASR{devices}, append([]Option{Stdout(io.Discard)}, test.opts...)...)
+			if err := c.Clone(context.Background(), source.UUID, target.UUID); err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %v, want: nil", err)
+			}
+			got, err := devices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, got); diff != "" {
+				t.Errorf("Clone(...) left unexpected target snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+// laggingSpaceDiskUtil is a snapshotSpaceDiskUtil whose containers' free space
+// lags behind deleted snapshots, like APFS reclaiming their space in the
+// background: the space of a deleted snapshot is only reported free after lag
+// more calls to Info. If lag is negative, the space is never reported free.
+type laggingSpaceDiskUtil struct {
+	snapshotSpaceDiskUtil
+	lag int
+
+	// reclaiming is the number of deleted snapshots whose space is not
+	// reported free yet, and polls the number of calls to Info since the
+	// last deletion.
+	reclaiming, polls int
+}
+
+func (du *laggingSpaceDiskUtil) DeleteSnapshot(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot) error {
+	du.reclaiming++
+	du.polls = 0
+	return du.snapshotSpaceDiskUtil.DeleteSnapshot(ctx, volume, snap)
+}
+
+func (du *laggingSpaceDiskUtil) Info(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	info, err := du.snapshotSpaceDiskUtil.Info(ctx, volume)
+	if err != nil {
+		return diskutil.VolumeInfo{}, err
+	}
+	if du.lag >= 0 && du.polls >= du.lag {
+		du.reclaiming = 0
+	}
+	du.polls++
+	info.ContainerFree -= uint64(du.reclaiming) * du.snapshotSize
+	return info, nil
+}
+
+func TestClone_ReserveLaggingFreeSpace(t *testing.T) {
+	snaps := make([]diskutil.Snapshot, 5)
+	for i := range snaps {
+		snaps[i] = diskutil.Snapshot{
+			Name: fmt.Sprintf("snap-%d", i+1),
+			UUID: fmt.Sprintf("123-snap-%d-uuid", i+1),
+		}
+	}
+	source := diskutil.VolumeInfo{
+		Name: "source-name",
+		UUID: "123-source-uuid",
+	}
+	target := diskutil.VolumeInfo{
+		Name:          "target-name",
+		UUID:          "123-target-uuid",
+		ContainerSize: 100e9,
+	}
+	tests := []struct {
+		name            string
+		lag             int
+		wantTargetSnaps []diskutil.Snapshot
+		wantSleeps      int
+	}{
+		{
+			name:            "reclaimed after polling",
+			lag:             3,
+			wantTargetSnaps: []diskutil.Snapshot{snaps[4], snaps[3], snaps[2]},
+			wantSleeps:      2 * 3,
+		},
+		{
+			name:            "never reclaimed",
+			lag:             -1,
+			wantTargetSnaps: []diskutil.Snapshot{snaps[4], snaps[3], snaps[2], snaps[1]},
+			wantSleeps:      reclaimPolls - 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(source, snaps[4], snaps[3], snaps[2], snaps[1], snaps[0]),
+				withFakeVolume(target, snaps[3], snaps[2], snaps[1], snaps[0]),
+			)
+			// Each snapshot uses 10 GB, so that the target has 30 GB free
+			// after it is restored, and 50 GB after pruning 2 snapshots.
+			du := &laggingSpaceDiskUtil{
+				snapshotSpaceDiskUtil: snapshotSpaceDiskUtil{&fakeDiskUtil{devices}, 80e9, 10e9},
+				lag:                   test.lag,
+			}
+			sleeps := 0
+			sleep := func(ctx context.Context, d time.Duration) error {
+				if d != reclaimInterval {
+					t.Errorf("Clone(...) waited %s between polls, want: %s", d, reclaimInterval)
+				}
+				sleeps++
+				return nil
+			}
+			c := New(du, &fakeASR{devices}, Stdout(io.Discard), Reserve(50), withSleep(sleep))
+			if err := c.Clone(context.Background(), source.UUID, target.UUID); err != nil {
+				t.Fatalf("Clone(...) returned unexpected error: %v, want: nil", err)
+			}
+			got, err := devices.Snapshots(target.UUID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(test.wantTargetSnaps, got); diff != "" {
+				t.Errorf("Clone(...) left unexpected target snapshots. -want +got:\n%s", diff)
+			}
+			if sleeps != test.wantSleeps {
+				t.Errorf("Clone(...) polled free space after %d waits, want: %d", sleeps, test.wantSleeps)
+			}
+		})
+	}
+}
+
+func TestContainerPairs(t *testing.T) {
+	sourceData := diskutil.VolumeInfo{
+		Name:       "Data",
+		UUID:       "source-data-uuid",
+		Device:     "/dev/disk1s1",
+		FileSystem: "APFS",
+		Container:  "disk1",
+	}
+	sourcePhotos := diskutil.VolumeInfo{
+		Name:       "Photos",
+		UUID:       "source-photos-uuid",
+		Device:     "/dev/disk1s2",
+		FileSystem: "Case-sensitive APFS",
+		Container:  "disk1",
+		Quota:      2000000000,
+		Reserve:    1000000000,
+	}
+	targetData := diskutil.VolumeInfo{
+		Name:      "Data",
+		UUID:      "target-data-uuid",
+		Device:    "/dev/disk2s1",
+		Container: "disk2",
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(sourceData),
+		withFakeVolume(sourcePhotos),
+		withFakeVolume(targetData),
+	)
+	c := New(&fakeDiskUtil{devices}, nil)
+
+	pairs, err := c.ContainerPairs(context.Background(), sourcePhotos.Device, targetData.UUID)
+	if err != nil {
+		t.Fatalf("ContainerPairs(...) returned unexpected error: %q, want: nil", err)
+	}
+	want := []VolumePair{
+		{Source: sourceData, Target: targetData},
+		{
+			Source: sourcePhotos,
+			Target: diskutil.VolumeInfo{
+				Name:      "Photos",
+				Container: "disk2",
+			},
+			Missing: true,
+		},
+	}
+	if diff := cmp.Diff(want, pairs); diff != "" {
+		t.Fatalf("ContainerPairs(...) returned unexpected pairs. -want +got:\n%s", diff)
+	}
+
+	created, err := c.CreateTarget(context.Background(), pairs[1])
+	if err != nil {
+		t.Fatalf("CreateTarget(...) returned unexpected error: %q, want: nil", err)
+	}
+	if created.Missing {
+		t.Errorf("CreateTarget(...) returned pair with Missing: true, want: false")
+	}
+	info, err := devices.Volume(created.Target.UUID)
+	if err != nil {
+		t.Fatalf("CreateTarget(...) did not create target volume: %v", err)
+	}
+	wantInfo := diskutil.VolumeInfo{
+		Name:           "Photos",
+		UUID:           created.Target.UUID,
+		Device:         created.Target.Device,
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     sourcePhotos.FileSystem,
+		Container:      "disk2",
+		Quota:          sourcePhotos.Quota,
+		Reserve:        sourcePhotos.Reserve,
+	}
+	if diff := cmp.Diff(wantInfo, info); diff != "" {
+		t.Errorf("CreateTarget(...) created unexpected volume. -want +got:\n%s", diff)
+	}
+}
+
+func TestContainerPairs_Errors(t *testing.T) {
+	source := diskutil.VolumeInfo{
+		Name:      "source",
+		UUID:      "source-uuid",
+		Container: "disk1",
+	}
+	sibling := diskutil.VolumeInfo{
+		Name:      "sibling",
+		UUID:      "sibling-uuid",
+		Container: "disk1",
+	}
+	noContainer := diskutil.VolumeInfo{
+		Name: "hfs",
+		UUID: "hfs-uuid",
+	}
+	duplicate1 := diskutil.VolumeInfo{
+		Name:      "source",
+		UUID:      "duplicate-1-uuid",
+		Container: "disk2",
+	}
+	duplicate2 := diskutil.VolumeInfo{
+		Name:      "source",
+		UUID:      "duplicate-2-uuid",
+		Container: "disk2",
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(source),
+		withFakeVolume(sibling),
+		withFakeVolume(noContainer),
+		withFakeVolume(duplicate1),
+		withFakeVolume(duplicate2),
+	)
+	c := New(&fakeDiskUtil{devices}, nil)
+
+	tests := []struct {
+		name   string
+		source string
+		target string
+	}{
+		{
+			name:   "source does not exist",
+			source: "does-not-exist",
+			target: duplicate1.UUID,
+		},
+		{
+			name:   "target does not exist",
+			source: source.UUID,
+			target: "does-not-exist",
+		},
+		{
+			name:   "source not in container",
+			source: noContainer.UUID,
+			target: duplicate1.UUID,
+		},
+		{
+			name:   "target not in container",
+			source: source.UUID,
+			target: noContainer.UUID,
+		},
+		{
+			name:   "same container",
+			source: source.UUID,
+			target: sibling.UUID,
+		},
+		{
+			name:   "ambiguous target volume names",
+			source: source.UUID,
+			target: duplicate1.UUID,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := c.ContainerPairs(context.Background(), test.source, test.target); err == nil {
+				t.Errorf("ContainerPairs(%q, %q) returned nil error, want non-nil", test.source, test.target)
+			}
+		})
+	}
+}
+
+// retryingASR is a fakeASR whose restores are retried after transient errors
+// the given number of times before they succeed.
+type retryingASR struct {
+	*fakeASR
+	retries int
+}
+
+func (r retryingASR) Restore(ctx context.Context, source, target diskutil.VolumeInfo, to, from diskutil.Snapshot) error {
+	trace := asr.ContextRetryTrace(ctx)
+	for i := 1; i <= r.retries; i++ {
+		trace(asr.RetryAttempt{
+			Attempt:     i,
+			MaxAttempts: r.retries + 1,
+			Wait:        time.Duration(i) * time.Second,
+			Err:         &asr.RestoreError{Stderr: "Resource busy\n"},
+		})
+	}
+	return r.fakeASR.Restore(ctx, source, target, to, from)
+}
+
+func TestClone_Retried(t *testing.T) {
+	snap1 := diskutil.Snapshot{
+		Name: "snap-1",
+		UUID: "123-snap-1-uuid",
+	}
+	snap2 := diskutil.Snapshot{
+		Name: "snap-2",
+		UUID: "123-snap-2-uuid",
+	}
+	source := diskutil.VolumeInfo{
+		Name:           "source-name",
+		UUID:           "123-source-uuid",
+		MountPoint:     "/source/mount/point",
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	target := diskutil.VolumeInfo{
+		Name:           "target-name",
+		UUID:           "123-target-uuid",
+		MountPoint:     "/target/mount/point",
+		Writable:       true,
+		FileSystemType: "apfs",
+		FileSystem:     "APFS",
+	}
+	devices := newFakeDevices(t,
+		withFakeVolume(source, snap2, snap1),
+		withFakeVolume(target, snap1),
+	)
+
+	var got []int
+	var stdout strings.Builder
+	c := New(&fakeDiskUtil{devices}, retryingASR{&fakeASR{devices}, 2},
+		Retried(func(retries int) {
+			got = append(got, retries)
+		}),
+		Stdout(&stdout),
+	)
+	if err := c.Clone(context.Background(), source.MountPoint, target.MountPoint); err != nil {
+		t.Fatalf("Clone(...) returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff([]int{2}, got); diff != "" {
+		t.Errorf("Clone(...) reported unexpected retries. -want +got:\n%s", diff)
+	}
+	for _, want := range []string{
+		"Warning: restore attempt 1 of 3 failed with a transient error; retrying in 1s: Resource busy\n",
+		"Restore succeeded after 2 retries.\n",
+	} {
+		if !strings.Contains(stdout.String(), want) {
+			t.Errorf("Clone(...) printed %q, want it to contain %q", stdout.String(), want)
+		}
 	}
 }
@@ -0,0 +1,156 @@
+package cloner
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// reserveBytes returns the number of bytes of target's container to keep
+// free, or 0 if no space is reserved or the container's size is unknown.
+func (c Cloner) reserveBytes(target diskutil.VolumeInfo) uint64 {
+	if c.reserve <= 0 {
+		return 0
+	}
+	return target.ContainerSize / 100 * uint64(c.reserve)
+}
+
+// CloneBytes estimates the space of target's container that cloning source to
+// target uses, and so about how many bytes the clone copies, as the growth of
+// source's used space over target's, as a restore leaves target with source's
+// data, in addition to target's snapshots. This underestimates clones whose
+// changes are mostly rewrites of existing data.
+func CloneBytes(source, target diskutil.VolumeInfo) uint64 {
+	if source.UsedBytes > target.UsedBytes {
+		return source.UsedBytes - target.UsedBytes
+	}
+	return 0
+}
+
+// checkSpace returns ErrInsufficientSpace if target's container likely does not
+// have enough free space to clone source to target. Nothing is checked if the
+// container's size is unknown.
+func checkSpace(source, target diskutil.VolumeInfo) error {
+	if target.ContainerSize == 0 {
+		return nil
+	}
+	if needed := CloneBytes(source, target); needed > target.ContainerFree {
+		return fmt.Errorf("%w: target %q has %s free, and the clone needs about %s - prune old snapshots from target or use a larger target",
+			ErrInsufficientSpace, target.Name, diskutil.FormatBytes(target.ContainerFree), diskutil.FormatBytes(needed))
+	}
+	return nil
+}
+
+// checkReserve returns ErrBelowReserve if cloning source to target would likely
+// leave target's container with less free space than the reserve. As the space
+// a clone needs is underestimated by CloneBytes, the reserve should leave room
+// for rewrites of existing data.
+func (c Cloner) checkReserve(source, target diskutil.VolumeInfo) error {
+	reserve := c.reserveBytes(target)
+	if reserve == 0 {
+		return nil
+	}
+	needed := CloneBytes(source, target)
+	if needed > target.ContainerFree || target.ContainerFree-needed < reserve {
+		return fmt.Errorf("%w: target %q has %s free, the clone needs about %s, and %d%% (%s) is reserved - prune old snapshots from target or lower its reserve",
+			ErrBelowReserve, target.Name, diskutil.FormatBytes(target.ContainerFree), diskutil.FormatBytes(needed), c.reserve, diskutil.FormatBytes(reserve))
+	}
+	return nil
+}
+
+// reclaimPolls and reclaimInterval are how many times, and how often,
+// thinToReserve polls the free space of a target's container for the space of
+// a pruned snapshot to be reclaimed.
+const (
+	reclaimPolls    = 30
+	reclaimInterval = time.Second
+)
+
+// thinToReserve prunes the oldest snapshots from target until its container
+// has its reserve free, without pruning its latest snapshot or leaving fewer
+// than MinKeep. Space freed by deleting a snapshot is reclaimed by APFS in the
+// background, so after each deletion the container's free space is polled
+// until it grows. If it does not grow in time, no more snapshots are pruned,
+// rather than pruning snapshots based on stale free space.
+func (c Cloner) thinToReserve(ctx context.Context, source, target diskutil.VolumeInfo) error {
+	info, err := c.diskutil.Info(ctx, target.UUID)
+	if err != nil {
+		return fmt.Errorf("error getting free space of target: %w", err)
+	}
+	if info.ContainerFree >= c.reserveBytes(info) {
+		return nil
+	}
+	snaps, err := c.diskutil.ListSnapshots(ctx, target)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of target: %w", err)
+	}
+	pruned := 0
+	for info.ContainerFree < c.reserveBytes(info) {
+		remaining := len(snaps) - pruned - 1
+		if remaining < 1 || !c.keeps(remaining) {
+			fmt.Fprintf(c.stdout, "Warning: target's container has %s free, less than its %d%% reserve (%s), but no more snapshots can be pruned from target.\n", diskutil.FormatBytes(info.ContainerFree), c.reserve, diskutil.FormatBytes(c.reserveBytes(info)))
+			break
+		}
+		oldest := snaps[remaining]
+		if err := c.diskutil.DeleteSnapshot(ctx, target, oldest); err != nil {
+			return fmt.Errorf("error deleting snapshot %q from target: %w", oldest, err)
+		}
+		fmt.Fprintf(c.stdout, "Pruned snapshot from target to keep %d%% of its container free:\n\t%s\n", c.reserve, oldest)
+		pruned++
+		reclaimed, err := c.awaitReclaim(ctx, target, info.ContainerFree)
+		if err != nil {
+			return err
+		}
+		if reclaimed.ContainerFree <= info.ContainerFree {
+			fmt.Fprintf(c.stdout, "Warning: the space of pruned snapshots was not reclaimed from target's container within %s; not pruning more snapshots from target.\n", reclaimPolls*reclaimInterval)
+			break
+		}
+		info = reclaimed
+	}
+	if pruned == 0 {
+		return nil
+	}
+	return c.recordHistory(ctx, source, target)
+}
+
+// awaitReclaim polls target's info until its container has more than free
+// bytes free, or reclaimPolls polls. The last info polled is returned.
+func (c Cloner) awaitReclaim(ctx context.Context, target diskutil.VolumeInfo, free uint64) (diskutil.VolumeInfo, error) {
+	var info diskutil.VolumeInfo
+	for i := 0; i < reclaimPolls; i++ {
+		if i > 0 {
+			if err := c.sleep(ctx, reclaimInterval); err != nil {
+				return diskutil.VolumeInfo{}, err
+			}
+		}
+		var err error
+		if info, err = c.diskutil.Info(ctx, target.UUID); err != nil {
+			return diskutil.VolumeInfo{}, fmt.Errorf("error getting free space of target: %w", err)
+		}
+		if info.ContainerFree > free {
+			break
+		}
+	}
+	return info, nil
+}
+
+// sleepContext waits for d, or until ctx is done, in which case it returns
+// ctx's error.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+func withSleep(f func(context.Context, time.Duration) error) Option {
+	return func(c *Cloner) {
+		c.sleep = f
+	}
+}
@@ -0,0 +1,144 @@
+package cloner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/simfailure"
+)
+
+var (
+	simSource = diskutil.VolumeInfo{
+		Name:           "source",
+		UUID:           "source-uuid",
+		MountPoint:     "/source",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	simTarget = diskutil.VolumeInfo{
+		Name:           "target",
+		UUID:           "target-uuid",
+		MountPoint:     "/target",
+		Writable:       true,
+		FileSystemType: "apfs",
+	}
+	simNewest = time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+)
+
+func TestClone_ThousandsOfSnapshots(t *testing.T) {
+	sourceSnaps := simfailure.Snapshots(simSource.Name, 10000, simNewest)
+	// Target was last cloned to 100 snapshots ago, and has kept every
+	// snapshot since it was initialized.
+	targetSnaps := sourceSnaps[100:]
+	devices := newFakeDevices(t,
+		withFakeVolume(simSource, sourceSnaps...),
+		withFakeVolume(simTarget, targetSnaps...),
+	)
+	c := New(&fakeDiskUtil{devices}, &fakeASR{devices}, Prune(false))
+	plan, err := c.Preflight(context.Background(), simSource.MountPoint, simTarget.MountPoint)
+	if err != nil {
+		t.Fatalf("Preflight returned unexpected error: %v, want: nil", err)
+	}
+	if got, want := plan.Targets[0].Common, sourceSnaps[100]; got != want {
+		t.Errorf("Preflight planned common snapshot %s, want: %s", got, want)
+	}
+	if err := c.ClonePlanned(context.Background(), plan, simTarget.MountPoint); err != nil {
+		t.Fatalf("ClonePlanned returned unexpected error: %v, want: nil", err)
+	}
+	got, err := devices.Snapshots(simTarget.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(targetSnaps)+1 || got[0] != sourceSnaps[0] {
+		t.Errorf("ClonePlanned resulted in %d target snapshots, latest %s, want: %d, latest %s", len(got), got[0], len(targetSnaps)+1, sourceSnaps[0])
+	}
+}
+
+// listErrDiskUtil fails to list the snapshots of volumes in errs.
+type listErrDiskUtil struct {
+	*fakeDiskUtil
+	// Map of volume UUID to the error listing its snapshots.
+	errs map[string]error
+}
+
+func (du listErrDiskUtil) ListSnapshots(ctx context.Context, volume diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
+	if err := du.errs[volume.UUID]; err != nil {
+		return nil, err
+	}
+	return du.fakeDiskUtil.ListSnapshots(ctx, volume)
+}
+
+func TestPreflight_SimulatedFailures(t *testing.T) {
+	snaps := simfailure.Snapshots(simSource.Name, 3, simNewest)
+	listErr := func(err error) error {
+		return fmt.Errorf("`diskutil apfs listsnapshots -plist /dev/disk4s1` returned %w", err)
+	}
+
+	tests := []struct {
+		name        string
+		sourceSnaps diskutil.SnapshotList
+		targetSnaps diskutil.SnapshotList
+		listErrs    map[string]error
+		initialize  bool
+		wantErr     error
+	}{
+		{
+			name:        "source has no snapshots",
+			targetSnaps: snaps,
+			wantErr:     ErrNoSourceSnapshots,
+		},
+		{
+			// The target has data, but no snapshots, e.g. because
+			// they were deleted by hand.
+			name:        "target has no snapshots",
+			sourceSnaps: snaps,
+			wantErr:     ErrNoCommonSnapshot,
+		},
+		{
+			name:        "initialize target with snapshots",
+			sourceSnaps: snaps,
+			targetSnaps: snaps[1:],
+			initialize:  true,
+			wantErr:     ErrTargetHasSnapshots,
+		},
+		{
+			name:        "source snapshots out of order",
+			sourceSnaps: snaps,
+			targetSnaps: snaps[1:],
+			listErrs:    map[string]error{simSource.UUID: listErr(diskutil.ErrSnapshotOrder)},
+			wantErr:     diskutil.ErrSnapshotOrder,
+		},
+		{
+			name:        "duplicate target snapshots",
+			sourceSnaps: snaps,
+			targetSnaps: snaps[1:],
+			listErrs:    map[string]error{simTarget.UUID: listErr(diskutil.ErrDuplicateSnapshot)},
+			wantErr:     diskutil.ErrDuplicateSnapshot,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			devices := newFakeDevices(t,
+				withFakeVolume(simSource, test.sourceSnaps...),
+				withFakeVolume(simTarget, test.targetSnaps...),
+			)
+			du := listErrDiskUtil{
+				fakeDiskUtil: &fakeDiskUtil{devices},
+				errs:         test.listErrs,
+			}
+			c := New(du, &fakeASR{devices}, InitializeTargets(test.initialize))
+			_, err := c.Preflight(context.Background(), simSource.MountPoint, simTarget.MountPoint)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Preflight returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+			err = c.Clone(context.Background(), simSource.MountPoint, simTarget.MountPoint)
+			if !errors.Is(err, test.wantErr) {
+				t.Errorf("Clone returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package cloner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/snapshotdiff"
+)
+
+// DefaultStaleAfter is the default age of source's latest snapshot after
+// which Preflight warns that the snapshot is stale.
+const DefaultStaleAfter = 7 * 24 * time.Hour
+
+// NearlyFullPercent is the percent of source's APFS container in use above
+// which Preflight warns that it is nearly full. Creating snapshots and
+// restoring them with asr both slow down, and may fail, as a container fills.
+const NearlyFullPercent = 90
+
+// Warning is a problem found by Preflight that does not prevent cloning, but
+// may indicate a mistake or an unreliable backup.
+type Warning struct {
+	// Target is the target the warning applies to, as passed to
+	// Preflight, or empty if it applies to source.
+	Target  string
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Target == "" {
+		return fmt.Sprintf("source: %s", w.Message)
+	}
+	return fmt.Sprintf("target %q: %s", w.Target, w.Message)
+}
+
+// sourceWarnings returns warnings about source and its snapshots.
+func (c Cloner) sourceWarnings(source diskutil.VolumeInfo, snaps diskutil.SnapshotList) []Warning {
+	var warnings []Warning
+	if latest, ok := snaps.Latest(); ok && !latest.Created.IsZero() && c.staleAfter > 0 {
+		if age := c.clock.Now().Sub(latest.Created); age > c.staleAfter {
+			warnings = append(warnings, Warning{
+				Message: fmt.Sprintf("latest snapshot %s is stale: it was created %s ago", latest, age.Round(time.Minute)),
+			})
+		}
+	}
+	if source.SMARTStatus == "Failing" {
+		warnings = append(warnings, Warning{
+			Message: "disk S.M.A.R.T. status is Failing",
+		})
+	}
+	if used, ok := source.ContainerUsed(); ok && used > NearlyFullPercent {
+		warnings = append(warnings, Warning{
+			Message: fmt.Sprintf("APFS container is %.0f%% full (%s free), which slows down and may fail snapshots and restores - thin source's snapshots, e.g. with `tmutil thinlocalsnapshots`", used, diskutil.FormatBytes(source.ContainerFree)),
+		})
+	}
+	return warnings
+}
+
+// targetWarnings returns warnings about cloning source to target.
+func targetWarnings(arg string, source, target diskutil.VolumeInfo) []Warning {
+	var warnings []Warning
+	for _, check := range warningChecks(source, target) {
+		if check.Status == Warn {
+			warnings = append(warnings, Warning{
+				Target:  arg,
+				Message: check.Err.Error(),
+			})
+		}
+	}
+	return warnings
+}
+
+// olderCommonWarning returns a warning if common, the latest snapshot in
+// common between source and target, is older than another snapshot they have
+// in common, e.g. because the snapshots of source or target are out of order.
+// Restoring target from the older snapshot makes asr transfer more data.
+// Snapshots chosen by snapshotdiff.PreferNewestCommon are never warned about,
+// as it prefers XIDs over timestamps, and the plan explains its choice.
+func (c Cloner) olderCommonWarning(arg string, source, target diskutil.SnapshotList, common diskutil.Snapshot) (Warning, bool) {
+	if common.UUID == "" || c.strategy == snapshotdiff.PreferNewestCommon {
+		return Warning{}, false
+	}
+	_, analysis, err := snapshotdiff.LatestCommon(source, target)
+	if err != nil || !analysis.OlderBase(common) {
+		return Warning{}, false
+	}
+	return Warning{
+		Target:  arg,
+		Message: fmt.Sprintf("restoring from common snapshot %s created at %s, though %s created at %s is a newer snapshot in common, so asr transfers more data than needed - check that snapshot names are parsed with the right timestamps", common, common.Created.Format(time.RFC3339), analysis.Newest, analysis.Newest.Created.Format(time.RFC3339)),
+	}, true
+}
+
+// sharedDisk returns a physical disk that backs both a and b, if any.
+func sharedDisk(a, b diskutil.VolumeInfo) (string, bool) {
+	disks := make(map[string]bool)
+	for _, s := range a.PhysicalStores {
+		disks[s.WholeDisk()] = true
+	}
+	for _, s := range b.PhysicalStores {
+		if disk := s.WholeDisk(); disks[disk] {
+			return disk, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,39 @@
+// Package cmdtrace implements printing external commands as they are run, like
+// the shell's set -x, e.g. so that verbose output shows every diskutil and asr
+// command a clone runs.
+package cmdtrace
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Wrap returns a function that creates commands with execCommand, first
+// writing each to w, e.g. "+ diskutil info -plist /dev/disk2s1".
+func Wrap(execCommand func(context.Context, string, ...string) *exec.Cmd, w io.Writer) func(context.Context, string, ...string) *exec.Cmd {
+	return func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		fmt.Fprintf(w, "+ %s\n", Format(name, args...))
+		return execCommand(ctx, name, args...)
+	}
+}
+
+// Format formats a command as it would be typed at a shell, quoting arguments
+// that are empty or contain spaces or quotes.
+func Format(name string, args ...string) string {
+	words := []string{quote(name)}
+	for _, arg := range args {
+		words = append(words, quote(arg))
+	}
+	return strings.Join(words, " ")
+}
+
+func quote(word string) string {
+	if word == "" || strings.ContainsAny(word, " \t\n\"'\\$`") {
+		return strconv.Quote(word)
+	}
+	return word
+}
@@ -0,0 +1,65 @@
+package cmdtrace
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "plain",
+			args: []string{"info", "-plist", "/dev/disk2s1"},
+			want: "diskutil info -plist /dev/disk2s1",
+		},
+		{
+			name: "spaces",
+			args: []string{"rename", "/dev/disk2s1", "Offsite B"},
+			want: `diskutil rename /dev/disk2s1 "Offsite B"`,
+		},
+		{
+			name: "empty",
+			args: []string{"rename", "/dev/disk2s1", ""},
+			want: `diskutil rename /dev/disk2s1 ""`,
+		},
+		{
+			name: "quotes",
+			args: []string{"rename", "/dev/disk2s1", `Bob's "backup"`},
+			want: `diskutil rename /dev/disk2s1 "Bob's \"backup\""`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Format("diskutil", test.args...); got != test.want {
+				t.Errorf("Format(diskutil, %q) = %s, want: %s", test.args, got, test.want)
+			}
+		})
+	}
+}
+
+func TestWrap(t *testing.T) {
+	var buf bytes.Buffer
+	var ran []string
+	execCommand := Wrap(func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		ran = append(ran, name)
+		return exec.CommandContext(ctx, name, args...)
+	}, &buf)
+	cmd := execCommand(context.Background(), "asr", "restore", "--source", "/dev/disk2s1")
+	if got, want := cmd.Args, []string{"asr", "restore", "--source", "/dev/disk2s1"}; !cmp.Equal(got, want) {
+		t.Errorf("Wrap(...) created command with args %q, want: %q", got, want)
+	}
+	if diff := cmp.Diff([]string{"asr"}, ran); diff != "" {
+		t.Errorf("Wrap(...) created unexpected commands. -want +got:\n%s", diff)
+	}
+	if got, want := buf.String(), "+ asr restore --source /dev/disk2s1\n"; got != want {
+		t.Errorf("Wrap(...) wrote %q, want: %q", got, want)
+	}
+}
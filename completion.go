@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/resources"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// completion prints the shell completion script for a shell.
+func completion(args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s completion <shell>
+
+Prints the completion script for <shell>, one of: %s.
+`, os.Args[0], strings.Join(resources.Shells, ", "))
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <shell> is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	script, err := resources.Completion(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(script)
+	return err
+}
+
+func printVersion(args []string) error {
+	fmt.Println(version)
+	return nil
+}
+
+// runbook prints the runbook for rotating the paired targets off-site.
+func runbook(args []string) error {
+	fs := flag.NewFlagSet("runbook", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s runbook [-state <path>]
+
+Prints a runbook, in Markdown, for rotating the paired targets off-site.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	st, err := state.Load(*statePath)
+	if err != nil {
+		return err
+	}
+	b, err := resources.Runbook(st, clk.Now())
+	if err != nil {
+		return err
+	}
+	_, err = os.Stdout.Write(b)
+	return err
+}
@@ -0,0 +1,392 @@
+// Package config implements the configuration file, which names backup sets
+// so that clones can be run by set name rather than by volume. For example:
+//
+//	{
+//	  "sets": [
+//	    {
+//	      "name": "homefolder",
+//	      "source": "1A2B3C4D-0000-4000-8000-000000000001",
+//	      "snapshot_creators": ["com.bombich.ccc.6F4C2D1E-5B3A-4C2D-9E8F-7A6B5C4D3E2F"],
+//	      "max_snapshot_age": "48h",
+//	      "targets": ["5E6F7A8B-0000-4000-8000-000000000002"],
+//	      "prune": true,
+//	      "min_keep": 2,
+//	      "reserve_percent": 10
+//	    }
+//	  ],
+//	  "groups": [
+//	    {
+//	      "name": "offsite",
+//	      "targets": ["offsite-a", "offsite-b", "offsite-c"],
+//	      "max_age": "336h"
+//	    }
+//	  ],
+//	  "priorities": {"offsite-a": 10}
+//	}
+//
+// Groups are reported on by status, e.g. "2 of 3 offsite copies updated
+// within 14 days (quorum: 2)". Targets with higher priorities are cloned to
+// first, so that the most important target is cloned to even if a run is cut
+// short, e.g. by -max-runtime.
+//
+// When a disk is replaced, only its set needs to be updated. A missing
+// configuration file has no sets.
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
+	"github.com/voidingwarranties/offsite-apfs-backup/logfile"
+)
+
+// DefaultPath is the location of the configuration file used when none is
+// specified.
+const DefaultPath = "/Library/Application Support/offsite-apfs-backup/config.json"
+
+// Config is the configuration of the backup utility.
+type Config struct {
+	Sets []Set `json:"sets"`
+	// Groups are groups of targets whose quorum is reported by status.
+	Groups []Group `json:"groups,omitempty"`
+	// Priorities maps targets, by paired name or volume UUID, to their
+	// priorities. Targets are cloned to in order of decreasing priority,
+	// and otherwise in the order they are given. Targets without a
+	// priority have priority 0.
+	Priorities map[string]int `json:"priorities,omitempty"`
+	// ASR configures the buffers of every restore, e.g. as chosen by the
+	// bench-asr command.
+	ASR asr.Tuning `json:"asr"`
+	// SnapshotTimeZone is the time zone of the timestamps in snapshot
+	// names: "UTC" (default), "Local" for the local time zone, or an IANA
+	// time zone name, e.g. "America/Los_Angeles". Snapshots named by the
+	// local time they were created at, e.g. by Time Machine, need "Local"
+	// for their ages to be correct.
+	SnapshotTimeZone string `json:"snapshot_time_zone,omitempty"`
+	// SnapshotTimestamps are the timestamps of snapshots named by other
+	// tools, tried in order before the default yyyy-mm-dd-hhmmss, e.g. to
+	// order snapshots named by Unix time or ISO 8601 times.
+	SnapshotTimestamps []Timestamp `json:"snapshot_timestamps,omitempty"`
+	// Format configures how reports, e.g. status and catalog, format sizes
+	// and times.
+	Format format.Options `json:"format"`
+	// Log configures the log file clones are logged to, in addition to
+	// os_log.
+	Log Log `json:"log"`
+}
+
+// Log is the configuration of the log file, rotated once it grows too large.
+type Log struct {
+	// File, if set, is the path of the log file, e.g.
+	// ~/Library/Logs/offsite-apfs-backup.log. Overridden by -log-file.
+	File string `json:"file,omitempty"`
+	// MaxSizeMB is the size in megabytes the log grows to before it is
+	// rotated. Defaults to 10.
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+	// MaxAge, if set, is how long rotated logs are kept, e.g. 720h, in the
+	// syntax of time.ParseDuration. Older rotated logs are deleted.
+	MaxAge string `json:"max_age,omitempty"`
+	// MaxBackups is the number of rotated logs kept. Defaults to 5.
+	MaxBackups int `json:"max_backups,omitempty"`
+	// Compress, if true, compresses rotated logs with gzip.
+	Compress bool `json:"compress,omitempty"`
+}
+
+// Timestamp is a timestamp in snapshot names: a regexp matching it, and its
+// layout, as in Go's time.Parse (e.g. "2006-01-02T15:04:05Z07:00"), or "epoch"
+// for Unix times in seconds. If the regexp has a subexpression named
+// timestamp, only its match is parsed.
+type Timestamp struct {
+	Regexp string `json:"regexp"`
+	Layout string `json:"layout"`
+}
+
+// Set is a backup set: a source volume, which of its snapshots to clone, and
+// the targets to clone them to.
+type Set struct {
+	// Name identifies the set on the command line, e.g. homefolder.
+	Name string `json:"name"`
+	// Source is the source volume, as a mount point, /dev/ path, or volume
+	// UUID.
+	Source string `json:"source"`
+	// SnapshotFilter, if set, is a regular expression matching the names
+	// of the source snapshots to clone. Other source snapshots are
+	// ignored.
+	SnapshotFilter string `json:"snapshot_filter,omitempty"`
+	// SnapshotCreators, if set, are the name prefixes of the snapshots
+	// created by the tools allowed to create the source snapshots to
+	// clone, e.g. com.bombich.ccc.<task UUID> for a Carbon Copy Cloner
+	// task. Other source snapshots are ignored.
+	SnapshotCreators []string `json:"snapshot_creators,omitempty"`
+	// MaxSnapshotAge, if set, is the maximum age of the latest source
+	// snapshot to clone, e.g. 48h, in the syntax of time.ParseDuration.
+	// Older snapshots fail preflight checks.
+	MaxSnapshotAge string `json:"max_snapshot_age,omitempty"`
+	// Targets are the target volumes, as mount points, /dev/ paths, or
+	// volume UUIDs.
+	Targets []string `json:"targets"`
+
+	// Prune, PruneSource, Initialize, and DryRun set the flags of the same
+	// names (e.g. -prune-source) for every run of the set, in addition to
+	// the flags given on the command line. Initialize is usually only set
+	// until the set's targets are first cloned to, as it fails for targets
+	// that have snapshots.
+	Prune       bool `json:"prune,omitempty"`
+	PruneSource bool `json:"prune_source,omitempty"`
+	Initialize  bool `json:"initialize,omitempty"`
+	DryRun      bool `json:"dryrun,omitempty"`
+	// MinKeep, if set, is the minimum number of snapshots to keep on each
+	// target, and on source if PruneSource. Prunes that would leave fewer
+	// are skipped.
+	MinKeep int `json:"min_keep,omitempty"`
+	// ReservePercent, if set, is the percent of each target's APFS
+	// container to keep free for future snapshots. Clones that would
+	// likely leave less free fail preflight checks, and targets' oldest
+	// snapshots are pruned after each clone until it is free again.
+	ReservePercent int `json:"reserve_percent,omitempty"`
+}
+
+var validSetName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// Load reads the configuration stored at path. If no file exists at path, an
+// empty configuration is returned. An error is returned if any set is
+// invalid.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Config{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	var c Config
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("error parsing config file %q: %w", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %q: %w", path, err)
+	}
+	return &c, nil
+}
+
+// Save writes the configuration to path atomically.
+func (c *Config) Save(path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating config directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".config-*.json")
+	if err != nil {
+		return fmt.Errorf("error writing config: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing config: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing config: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error writing config: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) validate() error {
+	names := make(map[string]bool)
+	for i, s := range c.Sets {
+		if !validSetName.MatchString(s.Name) {
+			return fmt.Errorf("set %d: invalid name %q: may only contain letters, digits, '.', '_', and '-'", i, s.Name)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("set %q: duplicate name", s.Name)
+		}
+		names[s.Name] = true
+		if s.Source == "" {
+			return fmt.Errorf("set %q: source is required", s.Name)
+		}
+		if len(s.Targets) == 0 {
+			return fmt.Errorf("set %q: at least one target is required", s.Name)
+		}
+		if _, err := s.Filter(); err != nil {
+			return fmt.Errorf("set %q: %w", s.Name, err)
+		}
+		if _, err := s.MaxAge(); err != nil {
+			return fmt.Errorf("set %q: %w", s.Name, err)
+		}
+		if s.MinKeep < 0 {
+			return fmt.Errorf("set %q: invalid min_keep %d: must not be negative", s.Name, s.MinKeep)
+		}
+		if s.ReservePercent < 0 || s.ReservePercent >= 100 {
+			return fmt.Errorf("set %q: invalid reserve_percent %d: must be between 0 and 99", s.Name, s.ReservePercent)
+		}
+	}
+	groups := make(map[string]bool)
+	for _, g := range c.Groups {
+		if err := g.validate(); err != nil {
+			return err
+		}
+		if groups[g.Name] {
+			return fmt.Errorf("group %q: duplicate name", g.Name)
+		}
+		groups[g.Name] = true
+	}
+	for target := range c.Priorities {
+		if target == "" {
+			return errors.New("invalid priorities: empty target")
+		}
+	}
+	if c.ASR.Buffers < 0 {
+		return fmt.Errorf("invalid asr buffers %d: must not be negative", c.ASR.Buffers)
+	}
+	if _, err := c.SnapshotLocation(); err != nil {
+		return err
+	}
+	if _, err := c.SnapshotTimestampPatterns(); err != nil {
+		return err
+	}
+	if err := c.Format.Validate(); err != nil {
+		return fmt.Errorf("invalid format: %w", err)
+	}
+	if _, err := c.Log.Options(); err != nil {
+		return fmt.Errorf("invalid log: %w", err)
+	}
+	return nil
+}
+
+// Options returns the options of the log file.
+func (l Log) Options() ([]logfile.Option, error) {
+	if l.MaxSizeMB < 0 {
+		return nil, fmt.Errorf("invalid max_size_mb %d: must not be negative", l.MaxSizeMB)
+	}
+	if l.MaxBackups < 0 {
+		return nil, fmt.Errorf("invalid max_backups %d: must not be negative", l.MaxBackups)
+	}
+	opts := []logfile.Option{logfile.Compress(l.Compress)}
+	if l.MaxSizeMB > 0 {
+		opts = append(opts, logfile.MaxSize(int64(l.MaxSizeMB)<<20))
+	}
+	if l.MaxBackups > 0 {
+		opts = append(opts, logfile.MaxBackups(l.MaxBackups))
+	}
+	if l.MaxAge != "" {
+		d, err := time.ParseDuration(l.MaxAge)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid max_age %q: must be a positive duration, e.g. 720h", l.MaxAge)
+		}
+		opts = append(opts, logfile.MaxAge(d))
+	}
+	return opts, nil
+}
+
+// SnapshotLocation returns the time zone of the timestamps in snapshot names,
+// as configured by SnapshotTimeZone.
+func (c *Config) SnapshotLocation() (*time.Location, error) {
+	if c.SnapshotTimeZone == "" {
+		return time.UTC, nil
+	}
+	// LoadLocation returns time.Local for "Local", and time.UTC for "UTC".
+	loc, err := time.LoadLocation(c.SnapshotTimeZone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot_time_zone %q: must be UTC, Local, or an IANA time zone name, e.g. America/Los_Angeles", c.SnapshotTimeZone)
+	}
+	return loc, nil
+}
+
+// SnapshotTimestampPatterns returns the patterns of the timestamps in snapshot
+// names configured by SnapshotTimestamps, in order.
+func (c *Config) SnapshotTimestampPatterns() ([]diskutil.TimestampPattern, error) {
+	var patterns []diskutil.TimestampPattern
+	for i, ts := range c.SnapshotTimestamps {
+		p, err := diskutil.NewTimestampPattern(ts.Regexp, ts.Layout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid snapshot_timestamps %d: %w", i, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// Priority returns the priority of the target identified by the first of ids,
+// e.g. its paired name and volume UUID, that has a priority, or 0 if none do.
+func (c *Config) Priority(ids ...string) int {
+	for _, id := range ids {
+		if p, ok := c.Priorities[id]; ok {
+			return p
+		}
+	}
+	return 0
+}
+
+// Set returns the set named name.
+func (c *Config) Set(name string) (Set, error) {
+	for _, s := range c.Sets {
+		if s.Name == name {
+			return s, nil
+		}
+	}
+	return Set{}, fmt.Errorf("no backup set named %q", name)
+}
+
+// Filter returns a func that returns true for the source snapshots of the set
+// to clone, for use with cloner.SnapshotFilter. Snapshots must match both the
+// snapshot filter and, if any, one of the snapshot creators. If the set
+// restricts neither, nil is returned.
+func (s Set) Filter() (func(diskutil.Snapshot) bool, error) {
+	if s.SnapshotFilter == "" && len(s.SnapshotCreators) == 0 {
+		return nil, nil
+	}
+	re, err := regexp.Compile(s.SnapshotFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid snapshot_filter: %w", err)
+	}
+	for _, prefix := range s.SnapshotCreators {
+		if prefix == "" {
+			return nil, errors.New("invalid snapshot_creators: empty prefix")
+		}
+	}
+	return func(snap diskutil.Snapshot) bool {
+		return re.MatchString(snap.Name) && s.createdByAllowed(snap)
+	}, nil
+}
+
+// createdByAllowed returns true if snap was created by one of the set's
+// snapshot creators, or the set allows all creators.
+func (s Set) createdByAllowed(snap diskutil.Snapshot) bool {
+	if len(s.SnapshotCreators) == 0 {
+		return true
+	}
+	for _, prefix := range s.SnapshotCreators {
+		if strings.HasPrefix(snap.Name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxAge returns the maximum age of the latest source snapshot to clone, or 0
+// if there is no maximum.
+func (s Set) MaxAge() (time.Duration, error) {
+	if s.MaxSnapshotAge == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s.MaxSnapshotAge)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_snapshot_age %q: must be a positive duration, e.g. 48h", s.MaxSnapshotAge)
+	}
+	return d, nil
+}
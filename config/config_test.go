@@ -0,0 +1,422 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `{"sets": [
+		{"name": "homefolder", "source": "source-uuid", "snapshot_filter": "^daily-", "targets": ["target-1", "target-2"], "prune": true, "min_keep": 2},
+		{"name": "photos", "source": "/Volumes/Photos", "targets": ["target-3"]}
+	]}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	got, err := c.Set("homefolder")
+	if err != nil {
+		t.Fatalf("Set returned unexpected error: %v, want: nil", err)
+	}
+	want := Set{
+		Name:           "homefolder",
+		Source:         "source-uuid",
+		SnapshotFilter: "^daily-",
+		Targets:        []string{"target-1", "target-2"},
+		Prune:          true,
+		MinKeep:        2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Set returned unexpected set. -want +got:\n%s", diff)
+	}
+	if _, err := c.Set("missing"); err == nil {
+		t.Error("Set(missing) returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dir", "config.json")
+	want := &Config{
+		Sets: []Set{{Name: "homefolder", Source: "source-uuid", Targets: []string{"target-1"}}},
+		ASR:  asr.Tuning{Buffers: 8, BufferSize: "8m"},
+		Format: format.Options{
+			SizeUnits: format.Binary,
+			TimeZone:  format.UTC,
+		},
+	}
+	if err := want.Save(path); err != nil {
+		t.Fatalf("Save returned unexpected error: %v, want: nil", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Load returned unexpected config after Save. -want +got:\n%s", diff)
+	}
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	c, err := Load(filepath.Join(t.TempDir(), "config.json"))
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	if len(c.Sets) != 0 {
+		t.Errorf("Load of missing file returned sets %v, want: none", c.Sets)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "invalid JSON",
+			content: `{"sets": `,
+		},
+		{
+			name:    "invalid name",
+			content: `{"sets": [{"name": "home folder", "source": "s", "targets": ["t"]}]}`,
+		},
+		{
+			name:    "duplicate name",
+			content: `{"sets": [{"name": "a", "source": "s", "targets": ["t"]}, {"name": "a", "source": "s", "targets": ["t"]}]}`,
+		},
+		{
+			name:    "missing source",
+			content: `{"sets": [{"name": "a", "targets": ["t"]}]}`,
+		},
+		{
+			name:    "missing targets",
+			content: `{"sets": [{"name": "a", "source": "s"}]}`,
+		},
+		{
+			name:    "empty snapshot creator",
+			content: `{"sets": [{"name": "a", "source": "s", "snapshot_creators": [""], "targets": ["t"]}]}`,
+		},
+		{
+			name:    "bad max snapshot age",
+			content: `{"sets": [{"name": "a", "source": "s", "max_snapshot_age": "2 days", "targets": ["t"]}]}`,
+		},
+		{
+			name:    "negative max snapshot age",
+			content: `{"sets": [{"name": "a", "source": "s", "max_snapshot_age": "-1h", "targets": ["t"]}]}`,
+		},
+		{
+			name:    "negative min keep",
+			content: `{"sets": [{"name": "a", "source": "s", "targets": ["t"], "min_keep": -1}]}`,
+		},
+		{
+			name:    "reserve percent too large",
+			content: `{"sets": [{"name": "a", "source": "s", "targets": ["t"], "reserve_percent": 100}]}`,
+		},
+		{
+			name:    "empty priority target",
+			content: `{"priorities": {"": 1}}`,
+		},
+		{
+			name:    "negative asr buffers",
+			content: `{"asr": {"buffers": -1}}`,
+		},
+		{
+			name:    "invalid snapshot time zone",
+			content: `{"snapshot_time_zone": "Mars/Olympus_Mons"}`,
+		},
+		{
+			name:    "invalid snapshot timestamp regexp",
+			content: `{"snapshot_timestamps": [{"regexp": "(", "layout": "epoch"}]}`,
+		},
+		{
+			name:    "missing snapshot timestamp layout",
+			content: `{"snapshot_timestamps": [{"regexp": "\\d+"}]}`,
+		},
+		{
+			name:    "invalid size units",
+			content: `{"format": {"size_units": "GiB"}}`,
+		},
+		{
+			name:    "negative log max size",
+			content: `{"log": {"file": "/tmp/offsite-apfs-backup.log", "max_size_mb": -1}}`,
+		},
+		{
+			name:    "negative log max backups",
+			content: `{"log": {"max_backups": -1}}`,
+		},
+		{
+			name:    "bad log max age",
+			content: `{"log": {"max_age": "30 days"}}`,
+		},
+		{
+			name:    "bad snapshot filter",
+			content: `{"sets": [{"name": "a", "source": "s", "snapshot_filter": "(", "targets": ["t"]}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Load(writeConfig(t, test.content)); err == nil {
+				t.Error("Load returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestConfig_Priority(t *testing.T) {
+	c := &Config{Priorities: map[string]int{"offsite-a": 10, "uuid-b": -1}}
+	tests := []struct {
+		ids  []string
+		want int
+	}{
+		{ids: []string{"offsite-a", "uuid-a"}, want: 10},
+		{ids: []string{"offsite-b", "uuid-b"}, want: -1},
+		{ids: []string{"offsite-c", "uuid-c"}, want: 0},
+		{ids: nil, want: 0},
+	}
+	for _, test := range tests {
+		if got := c.Priority(test.ids...); got != test.want {
+			t.Errorf("Priority(%q) = %d, want: %d", test.ids, got, test.want)
+		}
+	}
+}
+
+func TestSet_MaxAge(t *testing.T) {
+	got, err := Set{MaxSnapshotAge: "48h"}.MaxAge()
+	if err != nil || got != 48*time.Hour {
+		t.Errorf("MaxAge() returned (%s, %v), want: (48h0m0s, nil)", got, err)
+	}
+	got, err = Set{}.MaxAge()
+	if err != nil || got != 0 {
+		t.Errorf("MaxAge() of set without maximum returned (%s, %v), want: (0s, nil)", got, err)
+	}
+}
+
+func TestSet_Filter(t *testing.T) {
+	keep, err := Set{SnapshotFilter: "^daily-"}.Filter()
+	if err != nil {
+		t.Fatalf("Filter returned unexpected error: %v, want: nil", err)
+	}
+	for name, want := range map[string]bool{
+		"daily-2021-03-01":  true,
+		"hourly-2021-03-01": false,
+	} {
+		if got := keep(diskutil.Snapshot{Name: name}); got != want {
+			t.Errorf("filter(%q) = %t, want: %t", name, got, want)
+		}
+	}
+
+	keep, err = Set{
+		SnapshotFilter:   "\\.2021-",
+		SnapshotCreators: []string{"com.bombich.ccc.task-uuid.", "offsite."},
+	}.Filter()
+	if err != nil {
+		t.Fatalf("Filter returned unexpected error: %v, want: nil", err)
+	}
+	for name, want := range map[string]bool{
+		"com.bombich.ccc.task-uuid.2021-03-01":       true,
+		"offsite.2021-03-01":                         true,
+		"offsite.2020-03-01":                         false,
+		"com.bombich.ccc.other-task-uuid.2021-03-01": false,
+		"com.apple.TimeMachine.2021-03-01":           false,
+	} {
+		if got := keep(diskutil.Snapshot{Name: name}); got != want {
+			t.Errorf("filter(%q) = %t, want: %t", name, got, want)
+		}
+	}
+
+	keep, err = Set{}.Filter()
+	if err != nil || keep != nil {
+		t.Errorf("Filter of set without snapshot filter returned (%p, %v), want: (nil, nil)", keep, err)
+	}
+}
+
+func TestConfig_SnapshotLocation(t *testing.T) {
+	tests := []struct {
+		zone string
+		want *time.Location
+	}{
+		{zone: "", want: time.UTC},
+		{zone: "UTC", want: time.UTC},
+		{zone: "Local", want: time.Local},
+	}
+	for _, test := range tests {
+		c := &Config{SnapshotTimeZone: test.zone}
+		got, err := c.SnapshotLocation()
+		if err != nil {
+			t.Fatalf("SnapshotLocation() with %q returned unexpected error: %v, want: nil", test.zone, err)
+		}
+		if got != test.want {
+			t.Errorf("SnapshotLocation() with %q returned %v, want: %v", test.zone, got, test.want)
+		}
+	}
+}
+
+func TestConfig_SnapshotTimestampPatterns(t *testing.T) {
+	path := writeConfig(t, `{"snapshot_timestamps": [
+		{"regexp": "\\.(?P<timestamp>\\d{10})$", "layout": "epoch"},
+		{"regexp": "\\d{4}-\\d{2}-\\d{2}T\\d{2}:\\d{2}:\\d{2}Z", "layout": "2006-01-02T15:04:05Z07:00"}
+	]}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	got, err := c.SnapshotTimestampPatterns()
+	if err != nil {
+		t.Fatalf("SnapshotTimestampPatterns() returned unexpected error: %v, want: nil", err)
+	}
+	var layouts []string
+	for _, p := range got {
+		layouts = append(layouts, p.Layout)
+	}
+	want := []string{diskutil.EpochLayout, time.RFC3339}
+	if diff := cmp.Diff(want, layouts); diff != "" {
+		t.Errorf("SnapshotTimestampPatterns() returned unexpected layouts. -want +got:\n%s", diff)
+	}
+}
+
+func TestLoad_Groups(t *testing.T) {
+	path := writeConfig(t, `{"groups": [
+		{"name": "offsite", "targets": ["offsite-a", "offsite-b", "offsite-c"], "max_age": "336h"}
+	]}`)
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	got, err := c.Group("offsite")
+	if err != nil {
+		t.Fatalf("Group returned unexpected error: %v, want: nil", err)
+	}
+	want := Group{
+		Name:    "offsite",
+		Targets: []string{"offsite-a", "offsite-b", "offsite-c"},
+		MaxAge:  "336h",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Group returned unexpected group. -want +got:\n%s", diff)
+	}
+	if _, err := c.Group("missing"); err == nil {
+		t.Error("Group(missing) returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestLoad_GroupErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name:    "invalid name",
+			content: `{"groups": [{"name": "off site", "targets": ["t"], "max_age": "1h"}]}`,
+		},
+		{
+			name:    "duplicate name",
+			content: `{"groups": [{"name": "a", "targets": ["t"], "max_age": "1h"}, {"name": "a", "targets": ["t"], "max_age": "1h"}]}`,
+		},
+		{
+			name:    "missing targets",
+			content: `{"groups": [{"name": "a", "max_age": "1h"}]}`,
+		},
+		{
+			name:    "missing max age",
+			content: `{"groups": [{"name": "a", "targets": ["t"]}]}`,
+		},
+		{
+			name:    "quorum larger than targets",
+			content: `{"groups": [{"name": "a", "targets": ["t"], "max_age": "1h", "quorum": 2}]}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Load(writeConfig(t, test.content)); err == nil {
+				t.Error("Load returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestGroup_Check(t *testing.T) {
+	now := time.Date(2021, 3, 15, 12, 0, 0, 0, time.UTC)
+	lastCloned := map[string]time.Time{
+		"offsite-a": now.Add(-24 * time.Hour),
+		"offsite-b": now.Add(-14 * 24 * time.Hour),
+		"offsite-c": now.Add(-30 * 24 * time.Hour),
+	}
+	lookup := func(target string) time.Time { return lastCloned[target] }
+
+	tests := []struct {
+		name       string
+		group      Group
+		want       Quorum
+		wantMet    bool
+		wantString string
+	}{
+		{
+			name: "default majority",
+			group: Group{
+				Name:    "offsite",
+				Targets: []string{"offsite-a", "offsite-b", "offsite-c"},
+				MaxAge:  "336h",
+			},
+			want: Quorum{
+				Group:    "offsite",
+				Fresh:    []string{"offsite-a", "offsite-b"},
+				Stale:    []string{"offsite-c"},
+				Required: 2,
+				MaxAge:   336 * time.Hour,
+			},
+			wantMet:    true,
+			wantString: "2 of 3 offsite copies updated within 14 days (quorum: 2)",
+		},
+		{
+			name: "quorum lost",
+			group: Group{
+				Name:    "offsite",
+				Targets: []string{"offsite-a", "offsite-b", "offsite-c", "never-cloned"},
+				MaxAge:  "36h",
+				Quorum:  2,
+			},
+			want: Quorum{
+				Group:    "offsite",
+				Fresh:    []string{"offsite-a"},
+				Stale:    []string{"offsite-b", "offsite-c", "never-cloned"},
+				Required: 2,
+				MaxAge:   36 * time.Hour,
+			},
+			wantMet:    false,
+			wantString: "1 of 4 offsite copies updated within 36h0m0s (quorum: 2)",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := test.group.Check(lookup, now)
+			if err != nil {
+				t.Fatalf("Check returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Check returned unexpected quorum. -want +got:\n%s", diff)
+			}
+			if got.Met() != test.wantMet {
+				t.Errorf("Met() = %t, want: %t", got.Met(), test.wantMet)
+			}
+			if got.String() != test.wantString {
+				t.Errorf("String() = %q, want: %q", got.String(), test.wantString)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"time"
+)
+
+// Group is a group of targets that are copies of the same data, e.g. the disks
+// rotated off-site. A group has quorum if enough of its targets were cloned to
+// recently.
+type Group struct {
+	// Name identifies the group, e.g. offsite.
+	Name string `json:"name"`
+	// Targets are the group's targets, as paired target names or volume
+	// UUIDs.
+	Targets []string `json:"targets"`
+	// MaxAge is how recently a target must have been cloned to to count
+	// towards quorum, e.g. 336h, in the syntax of time.ParseDuration.
+	MaxAge string `json:"max_age"`
+	// Quorum is the number of targets that must have been cloned to
+	// within MaxAge. Defaults to a majority of Targets.
+	Quorum int `json:"quorum,omitempty"`
+}
+
+func (g Group) validate() error {
+	if !validSetName.MatchString(g.Name) {
+		return fmt.Errorf("group %q: invalid name: may only contain letters, digits, '.', '_', and '-'", g.Name)
+	}
+	if len(g.Targets) == 0 {
+		return fmt.Errorf("group %q: at least one target is required", g.Name)
+	}
+	if _, err := g.maxAge(); err != nil {
+		return fmt.Errorf("group %q: %w", g.Name, err)
+	}
+	if g.Quorum < 0 || g.Quorum > len(g.Targets) {
+		return fmt.Errorf("group %q: invalid quorum %d: must be between 1 and the number of targets (%d)", g.Name, g.Quorum, len(g.Targets))
+	}
+	return nil
+}
+
+func (g Group) maxAge() (time.Duration, error) {
+	d, err := time.ParseDuration(g.MaxAge)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid max_age %q: must be a positive duration, e.g. 336h", g.MaxAge)
+	}
+	return d, nil
+}
+
+// Group returns the group named name.
+func (c *Config) Group(name string) (Group, error) {
+	for _, g := range c.Groups {
+		if g.Name == name {
+			return g, nil
+		}
+	}
+	return Group{}, fmt.Errorf("no target group named %q", name)
+}
+
+// Quorum is whether a group had quorum at a point in time.
+type Quorum struct {
+	Group string
+	// Fresh are the targets cloned to within MaxAge, and Stale the others,
+	// in the order of the group's targets.
+	Fresh, Stale []string
+	Required     int
+	MaxAge       time.Duration
+}
+
+// Met returns true if enough targets are fresh.
+func (q Quorum) Met() bool {
+	return len(q.Fresh) >= q.Required
+}
+
+// String describes the quorum, e.g. "2 of 3 offsite copies updated within 14
+// days (quorum: 2)".
+func (q Quorum) String() string {
+	return fmt.Sprintf("%d of %d %s copies updated within %s (quorum: %d)", len(q.Fresh), len(q.Fresh)+len(q.Stale), q.Group, formatAge(q.MaxAge), q.Required)
+}
+
+// formatAge formats whole days as e.g. "14 days", and other durations as by
+// time.Duration.String.
+func formatAge(d time.Duration) string {
+	const day = 24 * time.Hour
+	switch {
+	case d == day:
+		return "1 day"
+	case d%day == 0:
+		return fmt.Sprintf("%d days", d/day)
+	}
+	return d.String()
+}
+
+// Check returns the group's quorum at now. lastCloned returns when a target,
+// as named in Targets, was last cloned to, or the zero time if it never was.
+func (g Group) Check(lastCloned func(target string) time.Time, now time.Time) (Quorum, error) {
+	maxAge, err := g.maxAge()
+	if err != nil {
+		return Quorum{}, err
+	}
+	q := Quorum{
+		Group:    g.Name,
+		Required: g.Quorum,
+		MaxAge:   maxAge,
+	}
+	if q.Required == 0 {
+		q.Required = len(g.Targets)/2 + 1
+	}
+	for _, t := range g.Targets {
+		if last := lastCloned(t); !last.IsZero() && now.Sub(last) <= maxAge {
+			q.Fresh = append(q.Fresh, t)
+		} else {
+			q.Stale = append(q.Stale, t)
+		}
+	}
+	return q, nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/cliio"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+)
+
+// cloneContainer clones every volume in source's APFS container to the volume
+// of the same name in target's APFS container. Target volumes that do not
+// exist are created and initialized.
+func cloneContainer(ctx context.Context, source, target string) error {
+	out := cliio.Highlight(progressOutput(), colors(os.Stdout))
+	errOut := cliio.Highlight(os.Stderr, colors(os.Stderr))
+	stdout := newPrefixWriter([]byte("\t"), io.MultiWriter(out, logger))
+	runID := runIDs.NewID()
+	du := newDiskUtil()
+	var r asr.ASR = asr.New(append(cloneASROptions(), asr.Stdout(stdout))...)
+	if *dryrun {
+		du = diskutil.NewDryRun(du)
+		r = asr.NewDryRun(asr.Stdout(stdout))
+	} else {
+		du, r = auditTools(du, r, *auditPath, runID, *label)
+	}
+	opts := append(cloneClonerOptions(stdout, !*dryrun),
+		cloner.Prune(*prune),
+		cloner.Keep(*keep),
+		cloner.VerifyBeforePrune(*verifyBeforePrune),
+	)
+	c := cloner.New(du, r, append(opts, cloner.InitializeTargets(*initialize))...)
+	// New target volumes have no snapshots, so they are always initialized.
+	initializer := cloner.New(du, r, append(opts, cloner.InitializeTargets(true))...)
+
+	pairs, err := c.ContainerPairs(ctx, source, target)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	for _, p := range pairs {
+		if !p.Missing {
+			existing = append(existing, p.Target.UUID)
+		}
+	}
+	// New target volumes are empty, so only existing target volumes need to
+	// be locked, and allowed by policy.
+	release, err := prepareTargets(ctx, out, du, nil, source, existing, prepareOptions{
+		lockDir:    lock.DefaultDir,
+		globalLock: *globalLock,
+		wait:       *wait,
+		policy:     true,
+	})
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// Map of source volume UUID to the plan of cloning it to its existing
+	// target volume.
+	plans := make(map[string]cloner.Plan)
+	for _, p := range pairs {
+		if p.Missing {
+			continue
+		}
+		plan, err := c.Preflight(ctx, p.Source.UUID, p.Target.UUID)
+		if err != nil {
+			return fmt.Errorf("volume %q: %w", p.Source.Name, err)
+		}
+		plans[p.Source.UUID] = plan
+	}
+	var planned []cloner.Plan
+	for _, p := range pairs {
+		if !p.Missing {
+			planned = append(planned, plans[p.Source.UUID])
+		}
+	}
+	warnings, err := preflightWarnings(*statePath, planned...)
+	if err != nil {
+		return err
+	}
+	if err := checkWarnings(errOut, warnings, *strict); err != nil {
+		return err
+	}
+	if !*dryrun {
+		if err := confirmContainer(pairs); err != nil {
+			return err
+		}
+	}
+
+	failed := 0
+	for _, p := range pairs {
+		fmt.Fprintf(out, "Cloning %q to %q...\n", p.Source.Name, p.Target.Name)
+		logger.Log(oslog.Default, "Cloning volume %q to %q (%s)", p.Source.Name, p.Target.Name, describeRun(runID, *label))
+		started := clk.Now()
+		var err error
+		if p.Missing {
+			p, err = createAndClone(ctx, initializer, stdout, p)
+		} else {
+			err = c.ClonePlanned(ctx, plans[p.Source.UUID], p.Target.UUID)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(errOut, "failed to clone %q to %q: %v\n", p.Source.Name, p.Target.Name, err)
+			logger.Log(oslog.Error, "failed to clone volume %q to %q: %v", p.Source.Name, p.Target.Name, err)
+			printDiagnosis(errOut, err)
+			printExplanation(errOut, err)
+			continue
+		}
+		duration := clk.Now().Sub(started)
+		fmt.Fprintf(stdout, "Completed in %s.\n", duration.Round(time.Second))
+		if *quiet {
+			fmt.Println(colors(os.Stdout).Green(fmt.Sprintf("Cloned %q to %q in %s.", p.Source.Name, p.Target.Name, duration.Round(time.Second))))
+		}
+		if *dryrun {
+			continue
+		}
+		cl := clone{
+			target:      p.Target.UUID,
+			started:     started,
+			duration:    duration,
+			initialized: p.Missing || *initialize,
+		}
+		if err := recordClones(ctx, *statePath, du, runID, *label, p.Source.UUID, []clone{cl}); err != nil {
+			fmt.Fprintln(errOut, "Warning: failed to record completed clone:", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to clone %d/%d volumes", failed, len(pairs))
+	}
+	return nil
+}
+
+// createAndClone creates the missing target volume of p and initializes it
+// with c, returning the updated pair. In a dry run, it only prints the volume
+// that would be created.
+func createAndClone(ctx context.Context, c cloner.Cloner, stdout *prefixWriter, p cloner.VolumePair) (cloner.VolumePair, error) {
+	if *dryrun {
+		fmt.Fprintf(stdout, "Would create target volume %q in %s and initialize it.\n", p.Target.Name, p.Target.Container)
+		return p, nil
+	}
+	p, err := c.CreateTarget(ctx, p)
+	if err != nil {
+		return p, err
+	}
+	return p, c.Clone(ctx, p.Source.UUID, p.Target.UUID)
+}
+
+func confirmContainer(pairs []cloner.VolumePair) error {
+	fmt.Println("This will clone the following volumes:")
+	for _, p := range pairs {
+		switch {
+		case p.Missing:
+			fmt.Printf("  - %s -> %s (new volume in %s)\n", p.Source.Name, p.Target.Name, p.Target.Container)
+		case *initialize:
+			fmt.Printf("  - %s -> %s (all data will be deleted)\n", p.Source.Name, p.Target.Name)
+		default:
+			fmt.Printf("  - %s -> %s\n", p.Source.Name, p.Target.Name)
+		}
+	}
+	return confirmPrompt()
+}
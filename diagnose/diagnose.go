@@ -0,0 +1,144 @@
+// Package diagnose implements diagnosing whether a failed restore was caused by
+// failing hardware, so that users know whether to replace a disk, and
+// summarizing the performance of completed clones for bug reports.
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+)
+
+// Verdict summarizes the likely cause of a failed restore.
+type Verdict string
+
+const (
+	// NoIOErrors means asr did not report any device I/O errors, so the
+	// failure is unlikely to be caused by hardware.
+	NoIOErrors Verdict = "no I/O errors reported"
+	// Inconclusive means asr reported I/O errors, but re-reading the
+	// implicated device regions succeeded, or asr did not report where
+	// the errors occurred. The errors may be transient (e.g. a loose
+	// cable), and the restore should be retried.
+	Inconclusive Verdict = "inconclusive"
+	// SuspectHardware means asr reported I/O errors, and re-reading an
+	// implicated device region failed. The device is likely failing.
+	SuspectHardware Verdict = "suspect hardware"
+)
+
+// readTestSize is the number of bytes read around each implicated offset.
+// Reads are aligned to readTestSize, as raw devices only support reads of
+// whole blocks.
+const readTestSize = 1 << 20
+
+// ReadTest is the result of re-reading the device region implicated by an
+// asr.IOError.
+type ReadTest struct {
+	Device string
+	// Offset and Length describe the region that was read.
+	Offset int64
+	Length int64
+	// Err is the error reading the region, or nil if it was read
+	// successfully.
+	Err error
+}
+
+// Report describes the diagnosis of a failed restore.
+type Report struct {
+	Verdict   Verdict
+	IOErrors  []asr.IOError
+	ReadTests []ReadTest
+}
+
+func (r Report) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "Diagnosis: %s\n", r.Verdict)
+	for _, e := range r.IOErrors {
+		fmt.Fprintf(b, "  asr reported: %s\n", e.Line)
+	}
+	for _, t := range r.ReadTests {
+		result := "ok"
+		if t.Err != nil {
+			result = fmt.Sprintf("failed: %v", t.Err)
+		}
+		fmt.Fprintf(b, "  read test of %s at offset %d (%d bytes): %s\n", t.Device, t.Offset, t.Length, result)
+	}
+	return b.String()
+}
+
+// Diagnoser diagnoses failed restores.
+type Diagnoser struct {
+	open func(device string) (io.ReaderAt, io.Closer, error)
+}
+
+// New returns a Diagnoser that reads devices directly.
+func New() Diagnoser {
+	return Diagnoser{
+		open: openRaw,
+	}
+}
+
+// Diagnose returns a report of the device I/O errors reported by asr in err,
+// if err wraps an *asr.RestoreError. Each device region implicated by an I/O
+// error is re-read to determine whether the error is persistent. ok is false
+// if err is not caused by a failed restore.
+func (d Diagnoser) Diagnose(err error) (r Report, ok bool) {
+	var restoreErr *asr.RestoreError
+	if !errors.As(err, &restoreErr) {
+		return Report{}, false
+	}
+	r.IOErrors = restoreErr.IOErrors
+	if len(r.IOErrors) == 0 {
+		r.Verdict = NoIOErrors
+		return r, true
+	}
+	r.Verdict = Inconclusive
+	for _, e := range r.IOErrors {
+		if e.Device == "" || e.Offset < 0 {
+			continue
+		}
+		t := d.readTest(e.Device, e.Offset)
+		if t.Err != nil {
+			r.Verdict = SuspectHardware
+		}
+		r.ReadTests = append(r.ReadTests, t)
+	}
+	return r, true
+}
+
+func (d Diagnoser) readTest(device string, offset int64) ReadTest {
+	t := ReadTest{
+		Device: device,
+		Offset: offset - offset%readTestSize,
+		Length: readTestSize,
+	}
+	r, c, err := d.open(device)
+	if err != nil {
+		t.Err = err
+		return t
+	}
+	defer c.Close()
+	buf := make([]byte, t.Length)
+	n, err := r.ReadAt(buf, t.Offset)
+	if err == io.EOF && n > 0 {
+		// The region extends past the end of the device.
+		err = nil
+	}
+	t.Err = err
+	return t
+}
+
+// openRaw opens the raw (character) device of device, e.g. /dev/rdisk4s1 for
+// /dev/disk4s1, bypassing the buffer cache so that reads go to the disk.
+func openRaw(device string) (io.ReaderAt, io.Closer, error) {
+	raw := strings.Replace(device, "/dev/disk", "/dev/rdisk", 1)
+	f, err := os.Open(raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, f, nil
+}
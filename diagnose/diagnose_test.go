@@ -0,0 +1,145 @@
+package diagnose
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+)
+
+// fakeDevice is a device of the given size that fails to read any region
+// containing a bad offset.
+type fakeDevice struct {
+	size int64
+	bad  []int64
+}
+
+func (d fakeDevice) ReadAt(p []byte, off int64) (int, error) {
+	for _, b := range d.bad {
+		if b >= off && b < off+int64(len(p)) {
+			return 0, errors.New("input/output error")
+		}
+	}
+	if off+int64(len(p)) > d.size {
+		return int(d.size - off), io.EOF
+	}
+	return len(p), nil
+}
+
+func (d fakeDevice) Close() error {
+	return nil
+}
+
+func newTestDiagnoser(devices map[string]fakeDevice) Diagnoser {
+	return Diagnoser{
+		open: func(device string) (io.ReaderAt, io.Closer, error) {
+			d, exists := devices[device]
+			if !exists {
+				return nil, nil, fmt.Errorf("%s: no such file or directory", device)
+			}
+			return d, d, nil
+		},
+	}
+}
+
+func TestDiagnose(t *testing.T) {
+	d := newTestDiagnoser(map[string]fakeDevice{
+		"/dev/disk4s1": {size: 10 * readTestSize, bad: []int64{3*readTestSize + 512}},
+	})
+	tests := []struct {
+		name          string
+		ioErrors      []asr.IOError
+		wantVerdict   Verdict
+		wantReadTests []ReadTest
+	}{
+		{
+			name:        "no I/O errors",
+			wantVerdict: NoIOErrors,
+		},
+		{
+			name: "unknown location",
+			ioErrors: []asr.IOError{
+				{Line: "Input/output error", Offset: -1},
+			},
+			wantVerdict: Inconclusive,
+		},
+		{
+			name: "region reads successfully",
+			ioErrors: []asr.IOError{
+				{Line: "Input/output error", Device: "/dev/disk4s1", Offset: readTestSize + 512},
+			},
+			wantVerdict: Inconclusive,
+			wantReadTests: []ReadTest{
+				{Device: "/dev/disk4s1", Offset: readTestSize, Length: readTestSize},
+			},
+		},
+		{
+			name: "region past end of device",
+			ioErrors: []asr.IOError{
+				{Line: "Input/output error", Device: "/dev/disk4s1", Offset: 9*readTestSize + 1},
+			},
+			wantVerdict: Inconclusive,
+			wantReadTests: []ReadTest{
+				{Device: "/dev/disk4s1", Offset: 9 * readTestSize, Length: readTestSize},
+			},
+		},
+		{
+			name: "region fails to read",
+			ioErrors: []asr.IOError{
+				{Line: "Input/output error", Device: "/dev/disk4s1", Offset: readTestSize},
+				{Line: "Input/output error", Device: "/dev/disk4s1", Offset: 3 * readTestSize},
+			},
+			wantVerdict: SuspectHardware,
+			wantReadTests: []ReadTest{
+				{Device: "/dev/disk4s1", Offset: readTestSize, Length: readTestSize},
+				{Device: "/dev/disk4s1", Offset: 3 * readTestSize, Length: readTestSize, Err: errors.New("input/output error")},
+			},
+		},
+		{
+			name: "device cannot be opened",
+			ioErrors: []asr.IOError{
+				{Line: "Input/output error", Device: "/dev/disk9s1", Offset: 0},
+			},
+			wantVerdict: SuspectHardware,
+			wantReadTests: []ReadTest{
+				{Device: "/dev/disk9s1", Offset: 0, Length: readTestSize, Err: errors.New("/dev/disk9s1: no such file or directory")},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := fmt.Errorf("error restoring: %w", &asr.RestoreError{IOErrors: test.ioErrors})
+			r, ok := d.Diagnose(err)
+			if !ok {
+				t.Fatalf("Diagnose returned ok: false, want: true")
+			}
+			if r.Verdict != test.wantVerdict {
+				t.Errorf("Diagnose returned verdict: %q, want: %q", r.Verdict, test.wantVerdict)
+			}
+			if diff := cmp.Diff(test.ioErrors, r.IOErrors, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Diagnose returned unexpected IOErrors. -want +got:\n%s", diff)
+			}
+			equateErrMsg := cmp.Comparer(func(x, y error) bool {
+				if x == nil || y == nil {
+					return x == nil && y == nil
+				}
+				return x.Error() == y.Error()
+			})
+			if diff := cmp.Diff(test.wantReadTests, r.ReadTests, equateErrMsg, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Diagnose returned unexpected ReadTests. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiagnose_NotRestoreError(t *testing.T) {
+	d := newTestDiagnoser(nil)
+	if _, ok := d.Diagnose(errors.New("invalid target volume")); ok {
+		t.Error("Diagnose returned ok: true, want: false")
+	}
+}
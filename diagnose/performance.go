@@ -0,0 +1,142 @@
+package diagnose
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// Performance is an anonymized summary of the performance of completed
+// clones, for bug reports about slowness. It only contains aggregate
+// statistics: no volume names, UUIDs, labels, or times of day.
+type Performance struct {
+	// Clones is the number of clones summarized.
+	Clones int `json:"clones"`
+	// Duration is the distribution of the total durations of clones, in
+	// seconds.
+	Duration Distribution `json:"duration_seconds"`
+	// Phases are the distributions of the durations of each phase of
+	// clones, in seconds, by phase name. Only clones that recorded their
+	// phase durations are included.
+	Phases map[string]Distribution `json:"phases_seconds,omitempty"`
+	// Throughput is the distribution of the effective throughput of
+	// restores, in MB/s: the space used by the source volume divided by the
+	// duration of the restore phase. Incremental restores copy less than
+	// the source's used space, so their effective throughput exceeds the
+	// disk's actual throughput.
+	Throughput Distribution `json:"throughput_mb_per_second"`
+	// TargetClasses are the number of clones to each class of target
+	// disk, e.g. "USB SSD".
+	TargetClasses map[string]int `json:"target_classes,omitempty"`
+	// Hardware is the class of the machine that ran the clones.
+	Hardware Hardware `json:"hardware"`
+}
+
+// Hardware describes the class of a machine, without identifying it.
+type Hardware struct {
+	// Family is the model family, e.g. MacBookPro, without the model
+	// number.
+	Family string `json:"family,omitempty"`
+	Arch   string `json:"arch"`
+	CPUs   int    `json:"cpus"`
+}
+
+// ModelFamily returns the family of a hardware model identifier, e.g.
+// MacBookPro for MacBookPro18,3.
+func ModelFamily(model string) string {
+	return strings.TrimRightFunc(model, func(r rune) bool {
+		return unicode.IsDigit(r) || r == ','
+	})
+}
+
+// Distribution summarizes a set of values.
+type Distribution struct {
+	Count  int     `json:"count"`
+	Min    float64 `json:"min,omitempty"`
+	Median float64 `json:"median,omitempty"`
+	P90    float64 `json:"p90,omitempty"`
+	Max    float64 `json:"max,omitempty"`
+}
+
+// NewDistribution returns the distribution of values.
+func NewDistribution(values []float64) Distribution {
+	if len(values) == 0 {
+		return Distribution{}
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return Distribution{
+		Count:  len(sorted),
+		Min:    round(sorted[0]),
+		Median: round(percentile(sorted, 50)),
+		P90:    round(percentile(sorted, 90)),
+		Max:    round(sorted[len(sorted)-1]),
+	}
+}
+
+// percentile returns the nearest-rank pth percentile of sorted.
+func percentile(sorted []float64, p int) float64 {
+	rank := int(math.Ceil(float64(p) / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// round rounds v to 2 decimal places, so that reports do not carry
+// meaningless precision.
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// NewPerformance summarizes the performance of the clones in catalog, run on
+// hw.
+func NewPerformance(catalog []state.CatalogEntry, hw Hardware) Performance {
+	p := Performance{
+		Clones:   len(catalog),
+		Hardware: hw,
+	}
+	var durations, throughputs []float64
+	phases := make(map[string][]float64)
+	classes := make(map[string]int)
+	for _, e := range catalog {
+		durations = append(durations, e.Duration.Seconds())
+		for phase, d := range e.Phases {
+			phases[phase] = append(phases[phase], d.Seconds())
+		}
+		if restore := e.Phases["restore"]; restore > 0 && e.SourceUsedBytes > 0 {
+			throughputs = append(throughputs, float64(e.SourceUsedBytes)/1e6/restore.Seconds())
+		}
+		if e.TargetClass != "" {
+			classes[e.TargetClass]++
+		}
+	}
+	p.Duration = NewDistribution(durations)
+	p.Throughput = NewDistribution(throughputs)
+	if len(phases) > 0 {
+		p.Phases = make(map[string]Distribution)
+		for phase, ds := range phases {
+			p.Phases[phase] = NewDistribution(ds)
+		}
+	}
+	if len(classes) > 0 {
+		p.TargetClasses = classes
+	}
+	return p
+}
+
+// TargetClass describes the class of a target's disk, e.g. "USB SSD", from its
+// bus protocol and whether it is solid state.
+func TargetClass(busProtocol string, solidState bool) string {
+	kind := "HDD"
+	if solidState {
+		kind = "SSD"
+	}
+	if busProtocol == "" {
+		return kind
+	}
+	return busProtocol + " " + kind
+}
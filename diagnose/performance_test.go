@@ -0,0 +1,118 @@
+package diagnose
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+func TestNewPerformance(t *testing.T) {
+	hw := Hardware{Family: "MacBookPro", Arch: "arm64", CPUs: 10}
+	tests := []struct {
+		name    string
+		catalog []state.CatalogEntry
+		want    Performance
+	}{
+		{
+			name: "empty",
+			want: Performance{Hardware: hw},
+		},
+		{
+			name: "without phases",
+			catalog: []state.CatalogEntry{
+				{Duration: 10 * time.Second},
+				{Duration: 30 * time.Second},
+			},
+			want: Performance{
+				Clones:   2,
+				Duration: Distribution{Count: 2, Min: 10, Median: 10, P90: 30, Max: 30},
+				Hardware: hw,
+			},
+		},
+		{
+			name: "with phases",
+			catalog: []state.CatalogEntry{
+				{
+					Duration: 60 * time.Second,
+					Phases: map[string]time.Duration{
+						"restore": 50 * time.Second,
+						"prune":   2 * time.Second,
+					},
+					SourceUsedBytes: 500e6,
+					TargetClass:     "USB SSD",
+				},
+				{
+					Duration: 120 * time.Second,
+					Phases: map[string]time.Duration{
+						"restore": 100 * time.Second,
+					},
+					SourceUsedBytes: 500e6,
+					TargetClass:     "USB SSD",
+				},
+				{
+					Duration:    30 * time.Second,
+					TargetClass: "Thunderbolt SSD",
+				},
+			},
+			want: Performance{
+				Clones:   3,
+				Duration: Distribution{Count: 3, Min: 30, Median: 60, P90: 120, Max: 120},
+				Phases: map[string]Distribution{
+					"restore": {Count: 2, Min: 50, Median: 50, P90: 100, Max: 100},
+					"prune":   {Count: 1, Min: 2, Median: 2, P90: 2, Max: 2},
+				},
+				Throughput: Distribution{Count: 2, Min: 5, Median: 5, P90: 10, Max: 10},
+				TargetClasses: map[string]int{
+					"USB SSD":         2,
+					"Thunderbolt SSD": 1,
+				},
+				Hardware: hw,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := NewPerformance(test.catalog, hw)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("NewPerformance returned unexpected performance. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestModelFamily(t *testing.T) {
+	tests := []struct {
+		model string
+		want  string
+	}{
+		{"MacBookPro18,3", "MacBookPro"},
+		{"Mac14,2", "Mac"},
+		{"VirtualMac2,1", "VirtualMac"},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if got := ModelFamily(test.model); got != test.want {
+			t.Errorf("ModelFamily(%q) = %q, want: %q", test.model, got, test.want)
+		}
+	}
+}
+
+func TestTargetClass(t *testing.T) {
+	tests := []struct {
+		bus        string
+		solidState bool
+		want       string
+	}{
+		{"USB", true, "USB SSD"},
+		{"Thunderbolt", false, "Thunderbolt HDD"},
+		{"", true, "SSD"},
+	}
+	for _, test := range tests {
+		if got := TargetClass(test.bus, test.solidState); got != test.want {
+			t.Errorf("TargetClass(%q, %t) = %q, want: %q", test.bus, test.solidState, got, test.want)
+		}
+	}
+}
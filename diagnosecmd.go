@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diagnose"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// diagnostics is the bundle printed by the diagnose command, to attach to bug
+// reports. It is redacted: it names no volumes, snapshots, labels, or paths.
+type diagnostics struct {
+	Version string `json:"version"`
+	// OSVersion is the macOS version, e.g. 13.4.1.
+	OSVersion string `json:"os_version,omitempty"`
+	Arch      string `json:"arch"`
+	// StateVersion is the format version of the state file.
+	StateVersion   int `json:"state_version"`
+	PairedTargets  int `json:"paired_targets"`
+	RetiredTargets int `json:"retired_targets"`
+	// Performance is only included if requested with -performance.
+	Performance *diagnose.Performance `json:"performance,omitempty"`
+}
+
+// diagnoseCommand prints a diagnostics bundle to attach to bug reports.
+func diagnoseCommand(args []string) error {
+	fs := flag.NewFlagSet("diagnose", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	performance := fs.Bool("performance", false, `If true, include anonymized performance statistics of the clones in the catalog: the distributions of phase durations and throughput, the classes of target disks, and the class of this machine.`)
+	output := fs.String("o", "", `Path to write the bundle to. Defaults to stdout.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s diagnose [-performance] [-state <path>] [-o <path>]
+
+Prints a diagnostics bundle, as JSON, to attach to bug reports. The bundle is
+gathered locally and redacted: it names no volumes, snapshots, labels, or
+paths. Review it before sharing it.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	d := diagnostics{
+		Version:        version,
+		OSVersion:      commandOutput("sw_vers", "-productVersion"),
+		Arch:           runtime.GOARCH,
+		StateVersion:   st.Version,
+		PairedTargets:  len(st.Pairings),
+		RetiredTargets: len(st.Retired),
+	}
+	if *performance {
+		p := diagnose.NewPerformance(st.Catalog, diagnose.Hardware{
+			Family: diagnose.ModelFamily(commandOutput("sysctl", "-n", "hw.model")),
+			Arch:   runtime.GOARCH,
+			CPUs:   runtime.NumCPU(),
+		})
+		d.Performance = &p
+	}
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *output == "" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return fmt.Errorf("error writing diagnostics: %w", err)
+	}
+	fmt.Printf("Wrote diagnostics to %s. Review it before sharing it.\n", *output)
+	return nil
+}
+
+// commandOutput returns the trimmed stdout of a command, or "" if it fails,
+// as diagnostics are best effort.
+func commandOutput(name string, args ...string) string {
+	var stdout bytes.Buffer
+	cmd := exec.Command(name, args...)
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return ""
+	}
+	return strings.TrimSpace(stdout.String())
+}
@@ -0,0 +1,330 @@
+// Package diskhealth implements monitoring a disk's I/O error counters and
+// temperature during long restores, to help identify failing disks and USB
+// bridges that corrupt long transfers.
+//
+// Error counters are read from the disk's IOBlockStorageDriver statistics
+// with MacOS's ioreg. Temperatures are read with smartctl, if it is installed
+// (e.g. from Homebrew's smartmontools), and the disk's bridge passes S.M.A.R.T.
+// data through.
+package diskhealth
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
+	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
+)
+
+// DefaultMaxTemperature is the temperature, in degrees Celsius, above which
+// Monitor warns by default. Most disks are rated to operate below 60°C.
+const DefaultMaxTemperature = 60
+
+// Counters are a disk's cumulative I/O error and retry counts since it was
+// attached.
+type Counters struct {
+	ReadErrors   int64 `json:"read_errors"`
+	WriteErrors  int64 `json:"write_errors"`
+	ReadRetries  int64 `json:"read_retries"`
+	WriteRetries int64 `json:"write_retries"`
+}
+
+func (c Counters) sub(o Counters) Counters {
+	return Counters{
+		ReadErrors:   c.ReadErrors - o.ReadErrors,
+		WriteErrors:  c.WriteErrors - o.WriteErrors,
+		ReadRetries:  c.ReadRetries - o.ReadRetries,
+		WriteRetries: c.WriteRetries - o.WriteRetries,
+	}
+}
+
+// increased returns true if any counter is positive.
+func (c Counters) increased() bool {
+	return c.ReadErrors > 0 || c.WriteErrors > 0 || c.ReadRetries > 0 || c.WriteRetries > 0
+}
+
+func (c Counters) String() string {
+	return fmt.Sprintf("%d read errors, %d write errors, %d read retries, %d write retries", c.ReadErrors, c.WriteErrors, c.ReadRetries, c.WriteRetries)
+}
+
+// Sample is a disk's health at a point in time.
+type Sample struct {
+	Time     time.Time `json:"time"`
+	Counters `json:"counters"`
+	// Temperature is in degrees Celsius, or 0 if unavailable.
+	Temperature int `json:"temperature,omitempty"`
+}
+
+// Sampler samples the health of whole disks, e.g. disk4.
+type Sampler interface {
+	Sample(disk string) (Sample, error)
+}
+
+type sampler struct {
+	execCommand func(string, ...string) *exec.Cmd
+	pl          plutil.PLUtil
+	clock       clock.Clock
+}
+
+// Option configures the Sampler returned by New.
+type Option func(*sampler)
+
+func withExecCommand(f func(string, ...string) *exec.Cmd) Option {
+	return func(s *sampler) {
+		s.execCommand = f
+	}
+}
+
+// Clock sets the clock used to timestamp samples. Defaults to the system
+// clock.
+func Clock(c clock.Clock) Option {
+	return func(s *sampler) {
+		s.clock = c
+	}
+}
+
+// New returns a Sampler that reads disks' health with ioreg and smartctl.
+func New(opts ...Option) Sampler {
+	s := sampler{
+		execCommand: exec.Command,
+		pl:          plutil.New(),
+		clock:       clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(&s)
+	}
+	return s
+}
+
+// ioregEntry is an entry of the IO registry, as output by `ioreg -a`.
+type ioregEntry struct {
+	BSDName    string           `json:"BSD Name"`
+	Statistics *ioregStatistics `json:"Statistics"`
+	Children   []ioregEntry     `json:"IORegistryEntryChildren"`
+}
+
+type ioregStatistics struct {
+	ReadErrors   int64 `json:"Errors (Read)"`
+	WriteErrors  int64 `json:"Errors (Write)"`
+	ReadRetries  int64 `json:"Retries (Read)"`
+	WriteRetries int64 `json:"Retries (Write)"`
+}
+
+// hasMedia returns true if e or any of its descendants is the media of disk.
+func (e ioregEntry) hasMedia(disk string) bool {
+	if e.BSDName == disk {
+		return true
+	}
+	for _, c := range e.Children {
+		if c.hasMedia(disk) {
+			return true
+		}
+	}
+	return false
+}
+
+// Sample returns the current health of disk. The temperature is omitted if
+// smartctl is not installed, or cannot read the disk's temperature.
+func (s sampler) Sample(disk string) (Sample, error) {
+	disk = strings.TrimPrefix(disk, "/dev/")
+	cmd := s.execCommand("ioreg", "-a", "-r", "-c", "IOBlockStorageDriver")
+	stdout, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return Sample{}, fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, exitErr.Stderr)
+	}
+	if err != nil {
+		return Sample{}, fmt.Errorf("`%s` failed (%w)", cmd, err)
+	}
+	var drivers []ioregEntry
+	if err := s.pl.Unmarshal(stdout, &drivers); err != nil {
+		return Sample{}, fmt.Errorf("error parsing plist: %w", err)
+	}
+	for _, d := range drivers {
+		if d.Statistics == nil || !d.hasMedia(disk) {
+			continue
+		}
+		return Sample{
+			Time: s.clock.Now(),
+			Counters: Counters{
+				ReadErrors:   d.Statistics.ReadErrors,
+				WriteErrors:  d.Statistics.WriteErrors,
+				ReadRetries:  d.Statistics.ReadRetries,
+				WriteRetries: d.Statistics.WriteRetries,
+			},
+			Temperature: s.temperature(disk),
+		}, nil
+	}
+	return Sample{}, fmt.Errorf("no storage driver statistics found for %s", disk)
+}
+
+// temperature returns the temperature of disk, or 0 if it is unavailable.
+func (s sampler) temperature(disk string) int {
+	// smartctl's exit status is a bit mask that is non-zero for many
+	// reasons other than failing to read the disk, e.g. if any
+	// S.M.A.R.T. attribute was ever below its threshold, so only its
+	// output is checked.
+	stdout, _ := s.execCommand("smartctl", "-j", "-A", "/dev/"+disk).Output()
+	var out struct {
+		Temperature struct {
+			Current int `json:"current"`
+		} `json:"temperature"`
+	}
+	if err := json.Unmarshal(stdout, &out); err != nil {
+		return 0
+	}
+	return out.Temperature.Current
+}
+
+// Report is the health of a disk sampled over the course of a restore.
+type Report struct {
+	Disk    string   `json:"disk"`
+	Samples []Sample `json:"samples"`
+	// Warnings describe the samples that crossed thresholds.
+	Warnings []string `json:"warnings,omitempty"`
+	// Errors are the errors sampling the disk, e.g. if it was
+	// disconnected.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// Increase returns how much the disk's counters increased over the samples.
+func (r Report) Increase() Counters {
+	if len(r.Samples) == 0 {
+		return Counters{}
+	}
+	return r.Samples[len(r.Samples)-1].Counters.sub(r.Samples[0].Counters)
+}
+
+// MaxTemperature returns the highest temperature sampled, or 0 if none was.
+func (r Report) MaxTemperature() int {
+	max := 0
+	for _, s := range r.Samples {
+		if s.Temperature > max {
+			max = s.Temperature
+		}
+	}
+	return max
+}
+
+func (r Report) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "Disk health of %s (%d samples): %s", r.Disk, len(r.Samples), r.Increase())
+	if t := r.MaxTemperature(); t > 0 {
+		fmt.Fprintf(b, ", max temperature %d°C", t)
+	}
+	fmt.Fprintln(b)
+	for _, w := range r.Warnings {
+		fmt.Fprintf(b, "  warning: %s\n", w)
+	}
+	if n := len(r.Errors); n > 0 {
+		fmt.Fprintf(b, "  %d samples failed, the last with: %s\n", n, r.Errors[n-1])
+	}
+	return b.String()
+}
+
+// Monitor periodically samples a disk's health until stopped.
+type Monitor struct {
+	sampler        Sampler
+	maxTemperature int
+	warn           func(string)
+	stop           chan struct{}
+	done           chan struct{}
+
+	mu     sync.Mutex
+	report Report
+}
+
+// MonitorOption configures a Monitor.
+type MonitorOption func(*Monitor)
+
+// MaxTemperature sets the temperature, in degrees Celsius, above which the
+// Monitor warns. Defaults to DefaultMaxTemperature.
+func MaxTemperature(celsius int) MonitorOption {
+	return func(m *Monitor) {
+		m.maxTemperature = celsius
+	}
+}
+
+// Warn sets a func that is called with each warning as soon as it occurs,
+// in addition to recording it in the report.
+func Warn(f func(warning string)) MonitorOption {
+	return func(m *Monitor) {
+		m.warn = f
+	}
+}
+
+func newMonitor(s Sampler, disk string, opts ...MonitorOption) *Monitor {
+	m := &Monitor{
+		sampler:        s,
+		maxTemperature: DefaultMaxTemperature,
+		warn:           func(string) {},
+		stop:           make(chan struct{}),
+		done:           make(chan struct{}),
+		report:         Report{Disk: strings.TrimPrefix(disk, "/dev/")},
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Start samples disk immediately, then every interval until Stop is called.
+func Start(s Sampler, disk string, interval time.Duration, opts ...MonitorOption) *Monitor {
+	m := newMonitor(s, disk, opts...)
+	m.poll()
+	go func() {
+		defer close(m.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				m.poll()
+			case <-m.stop:
+				return
+			}
+		}
+	}()
+	return m
+}
+
+// Stop samples the disk a final time, stops monitoring it, and returns the
+// report of all samples.
+func (m *Monitor) Stop() Report {
+	close(m.stop)
+	<-m.done
+	m.poll()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.report
+}
+
+// poll samples the disk, and warns if its counters increased since the last
+// sample, or it is too hot.
+func (m *Monitor) poll() {
+	sample, err := m.sampler.Sample(m.report.Disk)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		m.report.Errors = append(m.report.Errors, err.Error())
+		return
+	}
+	var warnings []string
+	if n := len(m.report.Samples); n > 0 {
+		prev := m.report.Samples[n-1]
+		if inc := sample.Counters.sub(prev.Counters); inc.increased() {
+			warnings = append(warnings, fmt.Sprintf("%s: %s since %s", m.report.Disk, inc, prev.Time.Format("15:04:05")))
+		}
+	}
+	if sample.Temperature > m.maxTemperature {
+		warnings = append(warnings, fmt.Sprintf("%s: temperature %d°C is above %d°C", m.report.Disk, sample.Temperature, m.maxTemperature))
+	}
+	m.report.Samples = append(m.report.Samples, sample)
+	m.report.Warnings = append(m.report.Warnings, warnings...)
+	for _, w := range warnings {
+		m.warn(w)
+	}
+}
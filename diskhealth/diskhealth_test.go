@@ -0,0 +1,221 @@
+package diskhealth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+const ioregOutput = `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<array>
+	<dict>
+		<key>IOObjectClass</key>
+		<string>IOBlockStorageDriver</string>
+		<key>Statistics</key>
+		<dict>
+			<key>Errors (Read)</key>
+			<integer>0</integer>
+			<key>Errors (Write)</key>
+			<integer>0</integer>
+			<key>Retries (Read)</key>
+			<integer>0</integer>
+			<key>Retries (Write)</key>
+			<integer>0</integer>
+		</dict>
+		<key>IORegistryEntryChildren</key>
+		<array>
+			<dict>
+				<key>BSD Name</key>
+				<string>disk0</string>
+			</dict>
+		</array>
+	</dict>
+	<dict>
+		<key>IOObjectClass</key>
+		<string>IOBlockStorageDriver</string>
+		<key>Statistics</key>
+		<dict>
+			<key>Errors (Read)</key>
+			<integer>1</integer>
+			<key>Errors (Write)</key>
+			<integer>2</integer>
+			<key>Retries (Read)</key>
+			<integer>3</integer>
+			<key>Retries (Write)</key>
+			<integer>4</integer>
+		</dict>
+		<key>IORegistryEntryChildren</key>
+		<array>
+			<dict>
+				<key>BSD Name</key>
+				<string>disk4</string>
+				<key>Content Hint</key>
+				<data>AAAA</data>
+				<key>IORegistryEntryChildren</key>
+				<array>
+					<dict>
+						<key>BSD Name</key>
+						<string>disk4s2</string>
+					</dict>
+				</array>
+			</dict>
+		</array>
+	</dict>
+</array>
+</plist>`
+
+func newWithFakeCmd(t *testing.T, now time.Time, opts ...fakecmd.Option) Sampler {
+	return New(
+		withExecCommand(fakecmd.FakeCommand(t, opts...)),
+		Clock(fakeclock.New(now)),
+	)
+}
+
+func TestSample(t *testing.T) {
+	now := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	s := newWithFakeCmd(t, now,
+		fakecmd.Stdout("ioreg", ioregOutput),
+		fakecmd.WantArg("ioreg", "IOBlockStorageDriver"),
+		fakecmd.Stdout("smartctl", `{"temperature": {"current": 41}}`),
+		fakecmd.WantArg("smartctl", "/dev/disk4"),
+	)
+	got, err := s.Sample("/dev/disk4")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Sample returned unexpected error: %v, want: nil", err)
+	}
+	want := Sample{
+		Time:        now,
+		Counters:    Counters{ReadErrors: 1, WriteErrors: 2, ReadRetries: 3, WriteRetries: 4},
+		Temperature: 41,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Sample returned unexpected sample. -want +got:\n%s", diff)
+	}
+}
+
+func TestSample_NoTemperature(t *testing.T) {
+	s := newWithFakeCmd(t, time.Time{},
+		fakecmd.Stdout("ioreg", ioregOutput),
+		fakecmd.Stderr("smartctl", "smartctl: command not found"),
+		fakecmd.ExitFail("smartctl"),
+	)
+	got, err := s.Sample("disk0")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Sample returned unexpected error: %v, want: nil", err)
+	}
+	if got.Temperature != 0 {
+		t.Errorf("Sample returned temperature %d, want: 0", got.Temperature)
+	}
+}
+
+func TestSample_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		disk string
+		opts []fakecmd.Option
+	}{
+		{
+			name: "ioreg fails",
+			disk: "disk4",
+			opts: []fakecmd.Option{fakecmd.ExitFail("ioreg")},
+		},
+		{
+			name: "unknown disk",
+			disk: "disk9",
+			opts: []fakecmd.Option{fakecmd.Stdout("ioreg", ioregOutput)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := newWithFakeCmd(t, time.Time{}, test.opts...)
+			_, err := s.Sample(test.disk)
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Error("Sample returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+// fakeSampler returns its samples in order, then errors.
+type fakeSampler struct {
+	samples []Sample
+}
+
+func (s *fakeSampler) Sample(disk string) (Sample, error) {
+	if len(s.samples) == 0 {
+		return Sample{}, errors.New("disk disconnected")
+	}
+	sample := s.samples[0]
+	s.samples = s.samples[1:]
+	return sample, nil
+}
+
+func TestMonitor(t *testing.T) {
+	start := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	samples := []Sample{
+		{Time: start, Temperature: 40},
+		{Time: start.Add(time.Minute), Temperature: 45},
+		{Time: start.Add(2 * time.Minute), Counters: Counters{WriteErrors: 2, WriteRetries: 5}, Temperature: 55},
+		{Time: start.Add(3 * time.Minute), Counters: Counters{WriteErrors: 2, WriteRetries: 5}, Temperature: 62},
+	}
+	var warned []string
+	m := newMonitor(&fakeSampler{samples: samples}, "/dev/disk4",
+		MaxTemperature(60),
+		Warn(func(w string) { warned = append(warned, w) }),
+	)
+	for i := 0; i < len(samples)+1; i++ {
+		m.poll()
+	}
+	got := m.report
+	want := Report{
+		Disk:    "disk4",
+		Samples: samples,
+		Warnings: []string{
+			"disk4: 0 read errors, 2 write errors, 0 read retries, 5 write retries since 20:36:09",
+			"disk4: temperature 62°C is above 60°C",
+		},
+		Errors: []string{"disk disconnected"},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Monitor recorded unexpected report. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(want.Warnings, warned); diff != "" {
+		t.Errorf("Monitor warned unexpectedly. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(Counters{WriteErrors: 2, WriteRetries: 5}, got.Increase()); diff != "" {
+		t.Errorf("Increase returned unexpected counters. -want +got:\n%s", diff)
+	}
+	if got := got.MaxTemperature(); got != 62 {
+		t.Errorf("MaxTemperature() = %d, want: 62", got)
+	}
+}
+
+func TestMonitor_StartStop(t *testing.T) {
+	samples := []Sample{{Temperature: 40}, {Temperature: 41}}
+	// The interval is long enough that only the first and final samples
+	// are taken.
+	m := Start(&fakeSampler{samples: samples}, "disk4", time.Hour)
+	got := m.Stop()
+	if diff := cmp.Diff(samples, got.Samples); diff != "" {
+		t.Errorf("Stop returned unexpected samples. -want +got:\n%s", diff)
+	}
+}
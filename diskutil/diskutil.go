@@ -4,51 +4,107 @@ package diskutil
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os/exec"
-	"regexp"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/voidingwarranties/offsite-apfs-backup/cmdtrace"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
 	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
 )
 
-// DiskUtil reads and modifies metadata of local volumes.
+var (
+	// ErrSnapshotOrder is returned by ListSnapshots if diskutil lists
+	// snapshots out of order of the timestamps in their names.
+	ErrSnapshotOrder = errors.New("snapshots in an unexpected order")
+	// ErrDuplicateSnapshot is returned by ListSnapshots if diskutil lists
+	// more than one snapshot with the same name or UUID.
+	ErrDuplicateSnapshot = errors.New("duplicate snapshot")
+)
+
+// DiskUtil reads and modifies metadata of local volumes. diskutil is killed if
+// ctx is done before it exits.
 type DiskUtil interface {
-	Info(volume string) (VolumeInfo, error)
-	Rename(volume VolumeInfo, name string) error
-	ListSnapshots(volume VolumeInfo) ([]Snapshot, error)
-	DeleteSnapshot(volume VolumeInfo, snap Snapshot) error
+	Info(ctx context.Context, volume string) (VolumeInfo, error)
+	Rename(ctx context.Context, volume VolumeInfo, name string) error
+	ListSnapshots(ctx context.Context, volume VolumeInfo) (SnapshotList, error)
+	DeleteSnapshot(ctx context.Context, volume VolumeInfo, snap Snapshot) error
+	EraseVolume(ctx context.Context, volume VolumeInfo, name string) error
+	ContainerVolumes(ctx context.Context, container string) ([]VolumeInfo, error)
+	APFSVolumes(ctx context.Context) ([]VolumeInfo, error)
+	AddVolume(ctx context.Context, container string, volume VolumeInfo) error
+	Mount(ctx context.Context, volume VolumeInfo) error
+	MountReadOnly(ctx context.Context, volume VolumeInfo) error
+	Unmount(ctx context.Context, volume VolumeInfo) error
+	Eject(ctx context.Context, volume VolumeInfo) error
+	UnlockVolume(ctx context.Context, volume VolumeInfo, passphrase string) error
+	LockVolume(ctx context.Context, volume VolumeInfo) error
 }
 
 type diskUtil struct {
-	execCommand func(string, ...string) *exec.Cmd
+	execCommand func(context.Context, string, ...string) *exec.Cmd
 	pl          plutil.PLUtil
+	snapshotLoc *time.Location
+	timestamps  []TimestampPattern
+	trace       io.Writer
 }
 
-type option func(*diskUtil)
+// Option configures a DiskUtil.
+type Option func(*diskUtil)
 
-func withExecCommand(f func(string, ...string) *exec.Cmd) option {
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) Option {
 	return func(du *diskUtil) {
 		du.execCommand = f
 	}
 }
 
-func withPLUtil(pl plutil.PLUtil) option {
+// SnapshotTimeZone returns an Option that parses the timestamps in snapshot
+// names as times in loc, e.g. time.Local for snapshots named by the local
+// time they were created at. Defaults to UTC.
+func SnapshotTimeZone(loc *time.Location) Option {
+	return func(du *diskUtil) {
+		du.snapshotLoc = loc
+	}
+}
+
+// SnapshotTimestamps returns an Option that parses the timestamps in snapshot
+// names with the first of patterns that matches each name, e.g. to order the
+// snapshots of tools that name them by Unix time. Names that none of patterns
+// match are parsed with DefaultTimestampPatterns, so that the snapshots of
+// this utility are always ordered.
+func SnapshotTimestamps(patterns ...TimestampPattern) Option {
+	return func(du *diskUtil) {
+		du.timestamps = append(append([]TimestampPattern(nil), patterns...), DefaultTimestampPatterns...)
+	}
+}
+
+// Trace returns an Option that writes each diskutil command to w before it is
+// run, e.g. for verbose output.
+func Trace(w io.Writer) Option {
 	return func(du *diskUtil) {
-		du.pl = pl
+		du.trace = w
 	}
 }
 
 // New returns a new DiskUtil.
-func New(opts ...option) DiskUtil {
+func New(opts ...Option) DiskUtil {
 	du := diskUtil{
-		execCommand: exec.Command,
+		execCommand: exec.CommandContext,
 		pl:          plutil.New(),
+		snapshotLoc: time.UTC,
+		timestamps:  DefaultTimestampPatterns,
 	}
 	for _, opt := range opts {
 		opt(&du)
 	}
+	if du.trace != nil {
+		du.execCommand = cmdtrace.Wrap(du.execCommand, du.trace)
+	}
 	return du
 }
 
@@ -66,20 +122,80 @@ type VolumeInfo struct {
 	FileSystemType string `json:"FilesystemType"`
 	// e.g. APFS, Case-sensitive APFS.
 	FileSystem string `json:"FilesystemName"`
+	// APFS container of the volume, e.g. disk3. Empty if the volume is
+	// not an APFS volume.
+	Container string `json:"APFSContainerReference"`
+	// Quota and Reserve are the APFS volume's quota and reserve sizes in
+	// bytes, or 0 if the volume has none. Only set by ContainerVolumes.
+	Quota   uint64 `json:"-"`
+	Reserve uint64 `json:"-"`
+	// Encrypted is true if the volume is an encrypted APFS volume. Locked
+	// is true if it is encrypted and has not been unlocked, in which case
+	// it cannot be mounted until it is unlocked with UnlockVolume.
+	Encrypted bool `json:"Encryption"`
+	Locked    bool `json:"Locked"`
+	// PhysicalStores are the partitions backing the volume's APFS
+	// container, e.g. disk0s2. Empty if the volume is not an APFS volume.
+	PhysicalStores []PhysicalStore `json:"APFSPhysicalStores"`
+	// SMARTStatus is the S.M.A.R.T. status of the volume's disk, e.g.
+	// Verified, Failing, or Not Supported.
+	SMARTStatus string `json:"SMARTStatus"`
+	// UsedBytes is the space used by the volume in bytes.
+	UsedBytes uint64 `json:"CapacityInUse"`
+	// ContainerSize and ContainerFree are the size and free space of the
+	// volume's APFS container in bytes, or 0 if the volume is not an APFS
+	// volume. Only set by Info.
+	ContainerSize uint64 `json:"APFSContainerSize"`
+	ContainerFree uint64 `json:"APFSContainerFree"`
+	// BusProtocol is the protocol of the volume's disk's bus, e.g. USB,
+	// Thunderbolt, or PCI-Express. SolidState is true if the disk is an
+	// SSD.
+	BusProtocol string `json:"BusProtocol"`
+	SolidState  bool   `json:"SolidState"`
+}
+
+// ContainerUsed returns the percent of the volume's APFS container that is in
+// use, or false if the container's size is unknown.
+func (v VolumeInfo) ContainerUsed() (percent float64, ok bool) {
+	if v.ContainerSize == 0 || v.ContainerFree > v.ContainerSize {
+		return 0, false
+	}
+	return 100 * float64(v.ContainerSize-v.ContainerFree) / float64(v.ContainerSize), true
+}
+
+// FormatBytes formats n bytes in decimal units, e.g. 1.5 GB, like Finder and
+// diskutil.
+func FormatBytes(n uint64) string {
+	return format.DecimalBytes(n)
+}
+
+// PhysicalStore is a partition backing an APFS container.
+type PhysicalStore struct {
+	// e.g. disk0s2
+	Device string `json:"APFSPhysicalStore"`
+}
+
+// WholeDisk returns the whole disk of the partition, e.g. disk0 for disk0s2.
+func (ps PhysicalStore) WholeDisk() string {
+	ref := strings.TrimPrefix(ps.Device, "/dev/")
+	if i := strings.LastIndex(ref, "s"); i > len("disk") {
+		ref = ref[:i]
+	}
+	return ref
 }
 
 // Info returns the VolumeInfo of volume. Volume may be a volume name, UUID,
 // mount point, or device node.
-func (du diskUtil) Info(volume string) (VolumeInfo, error) {
-	cmd := du.execCommand("diskutil", "info", "-plist", volume)
+func (du diskUtil) Info(ctx context.Context, volume string) (VolumeInfo, error) {
+	cmd := du.execCommand(ctx, "diskutil", "info", "-plist", volume)
 	var info VolumeInfo
 	err := du.runAndDecodePlist(cmd, &info)
 	return info, err
 }
 
 // Rename volume to name.
-func (du diskUtil) Rename(volume VolumeInfo, name string) error {
-	cmd := du.execCommand("diskutil", "rename", volume.Device, name)
+func (du diskUtil) Rename(ctx context.Context, volume VolumeInfo, name string) error {
+	cmd := du.execCommand(ctx, "diskutil", "rename", volume.Device, name)
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
@@ -90,8 +206,12 @@ func (du diskUtil) Rename(volume VolumeInfo, name string) error {
 
 // Snapshot describes an APFS volume's snapshot.
 type Snapshot struct {
-	Name    string    `json:"SnapshotName"`
-	UUID    string    `json:"SnapshotUUID"`
+	Name string `json:"SnapshotName"`
+	UUID string `json:"SnapshotUUID"`
+	// XID is the APFS transaction ID of the snapshot, which increases with
+	// each snapshot of a volume's container, or 0 if diskutil does not
+	// report it.
+	XID     uint64    `json:"SnapshotXID"`
 	Created time.Time `json:"-"`
 }
 
@@ -102,8 +222,8 @@ func (s Snapshot) String() string {
 // ListSnapshots returns a volume's APFS snapshots. The snapshots are returned
 // in the order of most recent snapshot first. Note that this is the reverse of
 // the order returned by 'diskutil apfs listsnapshots`.
-func (du diskUtil) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
-	cmd := du.execCommand("diskutil", "apfs", "listsnapshots", "-plist", volume.Device)
+func (du diskUtil) ListSnapshots(ctx context.Context, volume VolumeInfo) (SnapshotList, error) {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "listsnapshots", "-plist", volume.Device)
 	var snapshotList struct {
 		Snapshots []Snapshot `json:"Snapshots"`
 	}
@@ -113,9 +233,9 @@ func (du diskUtil) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
 	}
 
 	// TODO: document why we sort here.
-	var snapshots []Snapshot
+	var snapshots SnapshotList
 	for _, snap := range snapshotList.Snapshots {
-		created, err := parseTimeFromSnapshotName(snap.Name)
+		created, err := parseTimeFromSnapshotName(snap.Name, du.timestamps, du.snapshotLoc)
 		if err != nil {
 			return nil, err
 		}
@@ -129,9 +249,23 @@ func (du diskUtil) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
 	})
 	if !isSorted {
 		return nil, validationError{
-			fmt.Errorf("`%s` returned snapshots in an unexpected order", cmd),
+			fmt.Errorf("`%s` returned %w", cmd, ErrSnapshotOrder),
 		}
 	}
+	// Snapshots are identified by name as well as UUID, e.g. by
+	// -to-snapshot (see SnapshotList.Find) and in the snapshots printed as
+	// pruned, so neither may be ambiguous.
+	names := make(map[string]bool)
+	uuids := make(map[string]bool)
+	for _, snap := range snapshots {
+		if names[snap.Name] || uuids[snap.UUID] {
+			return nil, validationError{
+				fmt.Errorf("`%s` returned %w: %s", cmd, ErrDuplicateSnapshot, snap),
+			}
+		}
+		names[snap.Name] = true
+		uuids[snap.UUID] = true
+	}
 	for i, ii := 0, len(snapshots)-1; i < ii; i, ii = i+1, ii-1 {
 		snapshots[i], snapshots[ii] = snapshots[ii], snapshots[i]
 	}
@@ -142,26 +276,184 @@ type validationError struct {
 	error
 }
 
-func parseTimeFromSnapshotName(name string) (time.Time, error) {
-	timeRegex := regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`)
-	timeMatch := timeRegex.FindString(name)
-	if len(timeMatch) == 0 {
-		return time.Time{}, validationError{
-			fmt.Errorf("snapshot name (%q) does not contain a timestamp of the form yyyy-mm-dd-hhmmss", name),
-		}
+func (err validationError) Unwrap() error {
+	return err.error
+}
+
+// DeleteSnapshot removes the given snapshot from the given volume.
+func (du diskUtil) DeleteSnapshot(ctx context.Context, volume VolumeInfo, snap Snapshot) error {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "deletesnapshot", volume.Device, "-uuid", snap.UUID)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// EraseVolume erases all data and snapshots on the APFS volume, leaving an
+// empty volume with the given name in its place. The volume is replaced with a
+// new volume, so the data of an encrypted volume is unrecoverable once its old
+// keys are discarded. Use with caution!
+func (du diskUtil) EraseVolume(ctx context.Context, volume VolumeInfo, name string) error {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "eraseVolume", volume.Device, "-name", name)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
 	}
-	created, err := time.Parse("2006-01-02-150405", string(timeMatch))
+	return nil
+}
+
+// ContainerVolumes returns the volumes in the given APFS container. Container
+// may be a container reference (e.g. disk3) or device node (e.g. /dev/disk3).
+// Only the UUID, Name, Device, Container, Quota, and Reserve of each VolumeInfo
+// are set; use Info for the rest.
+func (du diskUtil) ContainerVolumes(ctx context.Context, container string) ([]VolumeInfo, error) {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "list", "-plist", container)
+	containers, err := du.apfsList(cmd)
 	if err != nil {
-		return time.Time{}, validationError{
-			fmt.Errorf("failed to parse time substring (%q) from snapshot name", timeMatch),
+		return nil, err
+	}
+	if len(containers) != 1 {
+		return nil, fmt.Errorf("`%s` returned %d containers, want 1", cmd, len(containers))
+	}
+	return containers[0], nil
+}
+
+// APFSVolumes returns the volumes of every attached APFS container, e.g. to
+// find volumes that could be cloned to. Only the same fields are set as by
+// ContainerVolumes.
+func (du diskUtil) APFSVolumes(ctx context.Context) ([]VolumeInfo, error) {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "list", "-plist")
+	containers, err := du.apfsList(cmd)
+	if err != nil {
+		return nil, err
+	}
+	var volumes []VolumeInfo
+	for _, c := range containers {
+		volumes = append(volumes, c...)
+	}
+	return volumes, nil
+}
+
+// apfsList runs cmd, a `diskutil apfs list -plist`, and returns the volumes of
+// each container it lists.
+func (du diskUtil) apfsList(cmd *exec.Cmd) ([][]VolumeInfo, error) {
+	var list struct {
+		Containers []struct {
+			ContainerReference string `json:"ContainerReference"`
+			Volumes            []struct {
+				DeviceIdentifier string `json:"DeviceIdentifier"`
+				Name             string `json:"Name"`
+				UUID             string `json:"APFSVolumeUUID"`
+				Quota            uint64 `json:"CapacityQuota"`
+				Reserve          uint64 `json:"CapacityReserve"`
+			} `json:"Volumes"`
+		} `json:"Containers"`
+	}
+	if err := du.runAndDecodePlist(cmd, &list); err != nil {
+		return nil, err
+	}
+	containers := make([][]VolumeInfo, len(list.Containers))
+	for i, c := range list.Containers {
+		for _, v := range c.Volumes {
+			containers[i] = append(containers[i], VolumeInfo{
+				UUID:      v.UUID,
+				Name:      v.Name,
+				Device:    "/dev/" + v.DeviceIdentifier,
+				Container: c.ContainerReference,
+				Quota:     v.Quota,
+				Reserve:   v.Reserve,
+			})
 		}
 	}
-	return created, nil
+	return containers, nil
 }
 
-// DeleteSnapshot removes the given snapshot from the given volume.
-func (du diskUtil) DeleteSnapshot(volume VolumeInfo, snap Snapshot) error {
-	cmd := du.execCommand("diskutil", "apfs", "deletesnapshot", volume.Device, "-uuid", snap.UUID)
+// AddVolume creates a new, empty APFS volume in container with the Name,
+// FileSystem (e.g. APFS or Case-sensitive APFS), Quota, and Reserve of volume.
+func (du diskUtil) AddVolume(ctx context.Context, container string, volume VolumeInfo) error {
+	args := []string{"apfs", "addVolume", container, volume.FileSystem, volume.Name}
+	if volume.Quota > 0 {
+		args = append(args, "-quota", fmt.Sprintf("%dB", volume.Quota))
+	}
+	if volume.Reserve > 0 {
+		args = append(args, "-reserve", fmt.Sprintf("%dB", volume.Reserve))
+	}
+	cmd := du.execCommand(ctx, "diskutil", args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// Mount mounts the volume at its default mount point, e.g. /Volumes/name.
+func (du diskUtil) Mount(ctx context.Context, volume VolumeInfo) error {
+	cmd := du.execCommand(ctx, "diskutil", "mount", volume.Device)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// MountReadOnly mounts the volume read-only at its default mount point, e.g.
+// to read a target without risk of modifying it.
+func (du diskUtil) MountReadOnly(ctx context.Context, volume VolumeInfo) error {
+	cmd := du.execCommand(ctx, "diskutil", "mount", "readOnly", volume.Device)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// Unmount unmounts the volume.
+func (du diskUtil) Unmount(ctx context.Context, volume VolumeInfo) error {
+	cmd := du.execCommand(ctx, "diskutil", "unmount", volume.Device)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// Eject unmounts every volume of the disk the volume is on, and ejects the
+// disk, so that it is safe to unplug.
+func (du diskUtil) Eject(ctx context.Context, volume VolumeInfo) error {
+	cmd := du.execCommand(ctx, "diskutil", "eject", volume.Device)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// UnlockVolume unlocks, and mounts, the encrypted APFS volume using
+// passphrase.
+func (du diskUtil) UnlockVolume(ctx context.Context, volume VolumeInfo, passphrase string) error {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "unlockVolume", volume.Device, "-stdinpassphrase")
+	// Pass the passphrase on stdin rather than as an argument, so that it
+	// is not visible to other processes.
+	cmd.Stdin = strings.NewReader(passphrase)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+// LockVolume unmounts, and locks, the encrypted APFS volume.
+func (du diskUtil) LockVolume(ctx context.Context, volume VolumeInfo) error {
+	cmd := du.execCommand(ctx, "diskutil", "apfs", "lockVolume", volume.Device)
 	stderr := new(bytes.Buffer)
 	cmd.Stderr = stderr
 	if err := cmd.Run(); err != nil {
@@ -3,6 +3,7 @@
 package diskutil_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -59,7 +60,7 @@ func TestInfo(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			want := test.setup(t)
 			du := diskutil.New()
-			got, err := du.Info(test.volume)
+			got, err := du.Info(context.Background(), test.volume)
 			if err != nil {
 				t.Fatalf("Info returned unexpected error: %v, want: nil", err)
 			}
@@ -72,7 +73,7 @@ func TestInfo(t *testing.T) {
 
 func TestInfo_Errors(t *testing.T) {
 	du := diskutil.New()
-	_, err := du.Info(t.TempDir())
+	_, err := du.Info(context.Background(), t.TempDir())
 	if err == nil {
 		t.Fatal("Info returned unexpected error: nil, want: non-nil", err)
 	}
@@ -81,7 +82,7 @@ func TestInfo_Errors(t *testing.T) {
 func TestListSnapshots(t *testing.T) {
 	info := mounter.MountRO(t, diskimage.SourceImg)
 	du := diskutil.New()
-	got, err := du.ListSnapshots(info)
+	got, err := du.ListSnapshots(context.Background(), info)
 	if err != nil {
 		t.Fatalf("ListSnapshots returned unexpected error: %v, want: nil", err)
 	}
@@ -93,7 +94,7 @@ func TestListSnapshots(t *testing.T) {
 
 func TestListSnapshots_Error(t *testing.T) {
 	du := diskutil.New()
-	_, err := du.ListSnapshots(nonexistentVolume)
+	_, err := du.ListSnapshots(context.Background(), nonexistentVolume)
 	if err == nil {
 		t.Fatal("ListSnapshots returned unexpected error: nil, want: non-nil", err)
 	}
@@ -102,10 +103,10 @@ func TestListSnapshots_Error(t *testing.T) {
 func TestRename(t *testing.T) {
 	info := mounter.MountRW(t, diskimage.SourceImg)
 	du := diskutil.New()
-	if err := du.Rename(info, "newname"); err != nil {
+	if err := du.Rename(context.Background(), info, "newname"); err != nil {
 		t.Fatalf("Rename returned unexpected error: %v, want: nil", err)
 	}
-	got, err := du.Info(info.Device)
+	got, err := du.Info(context.Background(), info.Device)
 	if err != nil {
 		t.Fatalf("Info returned unexpected error: %v, want: nil", err)
 	}
@@ -118,7 +119,7 @@ func TestRename(t *testing.T) {
 
 func TestRename_Errors(t *testing.T) {
 	du := diskutil.New()
-	err := du.Rename(nonexistentVolume, "newname")
+	err := du.Rename(context.Background(), nonexistentVolume, "newname")
 	if err == nil {
 		t.Fatal("Rename returned unexpected error: nil, want: non-nil")
 	}
@@ -127,11 +128,11 @@ func TestRename_Errors(t *testing.T) {
 func TestDeleteSnapshot(t *testing.T) {
 	info := mounter.MountRW(t, diskimage.SourceImg)
 	du := diskutil.New()
-	err := du.DeleteSnapshot(info, diskimage.SourceImg.Snapshots(t)[1])
+	err := du.DeleteSnapshot(context.Background(), info, diskimage.SourceImg.Snapshots(t)[1])
 	if err != nil {
 		t.Fatalf("DeleteSnapshot returned unexpected error: %v, want: nil", err)
 	}
-	got, err := du.ListSnapshots(info)
+	got, err := du.ListSnapshots(context.Background(), info)
 	if err != nil {
 		t.Fatalf("ListSnapshots returned unexpected error: %v, want: nil", err)
 	}
@@ -140,11 +141,11 @@ func TestDeleteSnapshot(t *testing.T) {
 		t.Errorf("DeleteSnapshot resulted in unexpected snapshots. -want +got:\n%s", diff)
 	}
 
-	err = du.DeleteSnapshot(info, diskimage.SourceImg.Snapshots(t)[0])
+	err = du.DeleteSnapshot(context.Background(), info, diskimage.SourceImg.Snapshots(t)[0])
 	if err != nil {
 		t.Fatalf("DeleteSnapshot returned unexpected error: %v, want: nil", err)
 	}
-	got, err = du.ListSnapshots(info)
+	got, err = du.ListSnapshots(context.Background(), info)
 	if err != nil {
 		t.Fatalf("ListSnapshots returned unexpected error: %v, want: nil", err)
 	}
@@ -189,7 +190,7 @@ func TestDeleteSnapshot_Errors(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			volume := test.setup(t)
 			du := diskutil.New()
-			err := du.DeleteSnapshot(volume, test.snap)
+			err := du.DeleteSnapshot(context.Background(), volume, test.snap)
 			if err == nil {
 				t.Fatal("DeleteSnapshot returned unexpected error: nil, want: non-nil")
 			}
@@ -1,6 +1,8 @@
 package diskutil
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"os/exec"
 	"reflect"
@@ -10,7 +12,6 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 
-	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
 	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
 )
 
@@ -19,12 +20,7 @@ func TestHelperProcess(t *testing.T) {
 }
 
 func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) DiskUtil {
-	execCmd := fakecmd.FakeCommand(t, opts...)
-	pl := plutil.New(plutil.WithExecCommand(execCmd))
-	return New(
-		withExecCommand(execCmd),
-		withPLUtil(pl),
-	)
+	return New(withExecCommand(fakecmd.FakeCommandContext(t, opts...)))
 }
 
 func TestInfo(t *testing.T) {
@@ -36,17 +32,50 @@ func TestInfo(t *testing.T) {
 		{
 			name: "success",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "<plist diskutil output>"),
-				fakecmd.Stdout("plutil", `{
-					"VolumeUUID": "foo-uuid",
-					"VolumeName": "foo-name",
-					"MountPoint": "/foo/mount/point",
-					"DeviceNode": "/dev/disk1s2",
-					"WritableVolume": true,
-					"FilesystemType": "apfs",
-					"FilesystemName": "Case-sensitive APFS"
-				}`),
-				fakecmd.WantStdin("plutil", "<plist diskutil output>"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>VolumeUUID</key>
+	<string>foo-uuid</string>
+	<key>VolumeName</key>
+	<string>foo-name</string>
+	<key>MountPoint</key>
+	<string>/foo/mount/point</string>
+	<key>DeviceNode</key>
+	<string>/dev/disk1s2</string>
+	<key>WritableVolume</key>
+	<true/>
+	<key>FilesystemType</key>
+	<string>apfs</string>
+	<key>FilesystemName</key>
+	<string>Case-sensitive APFS</string>
+	<key>APFSContainerReference</key>
+	<string>disk1</string>
+	<key>Encryption</key>
+	<true/>
+	<key>Locked</key>
+	<false/>
+	<key>APFSPhysicalStores</key>
+	<array>
+		<dict>
+			<key>APFSPhysicalStore</key>
+			<string>disk0s2</string>
+		</dict>
+	</array>
+	<key>SMARTStatus</key>
+	<string>Verified</string>
+	<key>CapacityInUse</key>
+	<integer>600000000000</integer>
+	<key>APFSContainerSize</key>
+	<integer>1000000000000</integer>
+	<key>APFSContainerFree</key>
+	<integer>350000000000</integer>
+	<key>BusProtocol</key>
+	<string>USB</string>
+	<key>SolidState</key>
+	<true/>
+</dict>
+</plist>`),
 			},
 			want: VolumeInfo{
 				UUID:           "foo-uuid",
@@ -56,24 +85,40 @@ func TestInfo(t *testing.T) {
 				Writable:       true,
 				FileSystemType: "apfs",
 				FileSystem:     "Case-sensitive APFS",
+				Container:      "disk1",
+				Encrypted:      true,
+				PhysicalStores: []PhysicalStore{{Device: "disk0s2"}},
+				SMARTStatus:    "Verified",
+				UsedBytes:      600e9,
+				ContainerSize:  1000e9,
+				ContainerFree:  350e9,
+				BusProtocol:    "USB",
+				SolidState:     true,
 			},
 		},
 		{
 			name: "ignores stderr (if exit code 0)",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "<plist diskutil output>"),
-				fakecmd.Stdout("plutil", `{
-					"VolumeUUID": "bar-uuid",
-					"VolumeName": "bar-name",
-					"MountPoint": "/bar/mount/point",
-					"DeviceNode": "/dev/disk3s4",
-					"WritableVolume": false,
-					"FilesystemType": "hfs",
-					"FilesystemName": "HFS+"
-				}`),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>VolumeUUID</key>
+	<string>bar-uuid</string>
+	<key>VolumeName</key>
+	<string>bar-name</string>
+	<key>MountPoint</key>
+	<string>/bar/mount/point</string>
+	<key>DeviceNode</key>
+	<string>/dev/disk3s4</string>
+	<key>WritableVolume</key>
+	<false/>
+	<key>FilesystemType</key>
+	<string>hfs</string>
+	<key>FilesystemName</key>
+	<string>HFS+</string>
+</dict>
+</plist>`),
 				fakecmd.Stderr("diskutil", "diskutil-stderr"),
-				fakecmd.Stderr("plutil", "plutil-stderr"),
-				fakecmd.WantStdin("plutil", "<plist diskutil output>"),
 			},
 			want: VolumeInfo{
 				UUID:           "bar-uuid",
@@ -89,7 +134,7 @@ func TestInfo(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			du := newWithFakeCmd(t, test.opts...)
-			got, err := du.Info("/example/volume")
+			got, err := du.Info(context.Background(), "/example/volume")
 			if err := fakecmd.AsHelperProcessErr(err); err != nil {
 				t.Fatal(err)
 			}
@@ -103,6 +148,74 @@ func TestInfo(t *testing.T) {
 	}
 }
 
+func TestPhysicalStore_WholeDisk(t *testing.T) {
+	tests := []struct {
+		device string
+		want   string
+	}{
+		{device: "disk0s2", want: "disk0"},
+		{device: "/dev/disk12s1", want: "disk12"},
+		{device: "disk4", want: "disk4"},
+	}
+	for _, test := range tests {
+		if got := (PhysicalStore{Device: test.device}).WholeDisk(); got != test.want {
+			t.Errorf("WholeDisk() of %q = %q, want: %q", test.device, got, test.want)
+		}
+	}
+}
+
+func TestVolumeInfo_ContainerUsed(t *testing.T) {
+	tests := []struct {
+		name   string
+		info   VolumeInfo
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "partly used",
+			info:   VolumeInfo{ContainerSize: 1000e9, ContainerFree: 250e9},
+			want:   75,
+			wantOK: true,
+		},
+		{
+			name:   "full",
+			info:   VolumeInfo{ContainerSize: 1000e9},
+			want:   100,
+			wantOK: true,
+		},
+		{
+			name: "unknown size",
+			info: VolumeInfo{ContainerFree: 250e9},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.info.ContainerUsed()
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("ContainerUsed() = %v, %t, want: %v, %t", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 999, want: "999 B"},
+		{n: 1500, want: "1.5 kB"},
+		{n: 12345678901, want: "12.3 GB"},
+		{n: 2e12, want: "2.0 TB"},
+	}
+	for _, test := range tests {
+		if got := FormatBytes(test.n); got != test.want {
+			t.Errorf("FormatBytes(%d) = %q, want: %q", test.n, got, test.want)
+		}
+	}
+}
+
 func TestInfo_Errors(t *testing.T) {
 	var exitErr *exec.ExitError
 	var plistErr plistError
@@ -115,31 +228,27 @@ func TestInfo_Errors(t *testing.T) {
 		{
 			name: "diskutil exec errors",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", "{}"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict/>
+</plist>`),
 				fakecmd.Stderr("diskutil", "stderr"),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
 				fakecmd.ExitFail("diskutil"),
 			},
 			wantErrAs: &exitErr,
 		},
-		{
-			name: "plutil exec errors",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", "{}"),
-				fakecmd.Stderr("plutil", "stderr"),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
-				fakecmd.ExitFail("plutil"),
-			},
-			wantErrAs: &exitErr,
-		},
 		{
 			name: "diskutil plist error output - returns plist error",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "diskutil-plist-err"),
-				fakecmd.Stdout("plutil", `{"Error": true, "ErrorMessage": "diskutil err message"}`),
-				fakecmd.WantStdin("plutil", "diskutil-plist-err"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Error</key>
+	<true/>
+	<key>ErrorMessage</key>
+	<string>diskutil err message</string>
+</dict>
+</plist>`),
 				fakecmd.ExitFail("diskutil"),
 			},
 			wantErrAs: &plistErr,
@@ -147,9 +256,15 @@ func TestInfo_Errors(t *testing.T) {
 		{
 			name: "diskutil plist error output - plist error wraps exec.ExitError",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "diskutil-plist-err"),
-				fakecmd.Stdout("plutil", `{"Error": true, "ErrorMessage": "diskutil err message"}`),
-				fakecmd.WantStdin("plutil", "diskutil-plist-err"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Error</key>
+	<true/>
+	<key>ErrorMessage</key>
+	<string>diskutil err message</string>
+</dict>
+</plist>`),
 				fakecmd.ExitFail("diskutil"),
 			},
 			wantErrAs: &exitErr,
@@ -158,7 +273,7 @@ func TestInfo_Errors(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			du := newWithFakeCmd(t, test.opts...)
-			_, err := du.Info("/example/volume")
+			_, err := du.Info(context.Background(), "/example/volume")
 			if err := fakecmd.AsHelperProcessErr(err); err != nil {
 				t.Fatal(err)
 			}
@@ -185,31 +300,39 @@ func TestListSnapshots(t *testing.T) {
 	tests := []struct {
 		name string
 		opts []fakecmd.Option
-		want []Snapshot
+		want SnapshotList
 	}{
 		{
 			name: "multiple snapshots",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "<plist diskutil output>"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "foo-snapshot-name-2021-03-02-012345",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						},
-						{
-							"SnapshotName": "bar.snapshot.name.2021-04-03-012345",
-							"SnapshotUUID": "bar-snapshot-uuid"
-						},
-						{
-							"SnapshotName": "baz_2021-05-04-012345_snapshot_name",
-							"SnapshotUUID": "baz-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "<plist diskutil output>"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>foo-snapshot-name-2021-03-02-012345</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+		</dict>
+		<dict>
+			<key>SnapshotName</key>
+			<string>bar.snapshot.name.2021-04-03-012345</string>
+			<key>SnapshotUUID</key>
+			<string>bar-snapshot-uuid</string>
+		</dict>
+		<dict>
+			<key>SnapshotName</key>
+			<string>baz_2021-05-04-012345_snapshot_name</string>
+			<key>SnapshotUUID</key>
+			<string>baz-snapshot-uuid</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
 			},
-			want: []Snapshot{
+			want: SnapshotList{
 				{
 					Name:    "baz_2021-05-04-012345_snapshot_name",
 					UUID:    "baz-snapshot-uuid",
@@ -227,22 +350,53 @@ func TestListSnapshots(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "snapshot XIDs",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>foo-snapshot-name-2021-03-02-012345</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+			<key>SnapshotXID</key>
+			<integer>1234567</integer>
+		</dict>
+	</array>
+</dict>
+</plist>`),
+			},
+			want: SnapshotList{
+				{
+					Name:    "foo-snapshot-name-2021-03-02-012345",
+					UUID:    "foo-snapshot-uuid",
+					XID:     1234567,
+					Created: time.Date(2021, 3, 2, 1, 23, 45, 0, time.UTC),
+				},
+			},
+		},
 		{
 			name: "no snapshots",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "<plist diskutil output>"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": []
-				}`),
-				fakecmd.WantStdin("plutil", "<plist diskutil output>"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array/>
+</dict>
+</plist>`),
 			},
-			want: []Snapshot{},
+			want: SnapshotList{},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			du := newWithFakeCmd(t, test.opts...)
-			got, err := du.ListSnapshots(exampleVolumeInfo)
+			got, err := du.ListSnapshots(context.Background(), exampleVolumeInfo)
 			if err := fakecmd.AsHelperProcessErr(err); err != nil {
 				t.Fatal(err)
 			}
@@ -259,14 +413,106 @@ func TestListSnapshots(t *testing.T) {
 	}
 }
 
+func TestListSnapshots_SnapshotTimeZone(t *testing.T) {
+	loc := time.FixedZone("PDT", -7*60*60)
+	du := New(
+		withExecCommand(fakecmd.FakeCommandContext(t,
+			fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>com.apple.TimeMachine.2021-05-04-012345.local</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
+		)),
+		SnapshotTimeZone(loc),
+	)
+	got, err := du.ListSnapshots(context.Background(), exampleVolumeInfo)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("ListSnapshots returned unexpected error: %q, want: nil", err)
+	}
+	want := time.Date(2021, 5, 4, 8, 23, 45, 0, time.UTC)
+	if len(got) != 1 || !got[0].Created.Equal(want) {
+		t.Errorf("ListSnapshots returned %v, want one snapshot created at %s", got, want)
+	}
+}
+
+func TestListSnapshots_SnapshotTimestamps(t *testing.T) {
+	epoch, err := NewTimestampPattern(`^zrepl\.(?P<timestamp>\d+)$`, EpochLayout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	du := New(
+		withExecCommand(fakecmd.FakeCommandContext(t,
+			fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>zrepl.1620091425</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+		</dict>
+		<dict>
+			<key>SnapshotName</key>
+			<string>offsite.homefolder.2021-05-05-000000</string>
+			<key>SnapshotUUID</key>
+			<string>bar-snapshot-uuid</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
+		)),
+		SnapshotTimestamps(epoch),
+	)
+	got, err := du.ListSnapshots(context.Background(), exampleVolumeInfo)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("ListSnapshots returned unexpected error: %q, want: nil", err)
+	}
+	want := SnapshotList{
+		{
+			Name:    "offsite.homefolder.2021-05-05-000000",
+			UUID:    "bar-snapshot-uuid",
+			Created: time.Date(2021, 5, 5, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			Name:    "zrepl.1620091425",
+			UUID:    "foo-snapshot-uuid",
+			Created: time.Date(2021, 5, 4, 1, 23, 45, 0, time.UTC),
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ListSnapshots returned unexpected SnapshotList. -want +got:\n%s", diff)
+	}
+}
+
 func TestListSnapshots_IDsVolumesByDevice(t *testing.T) {
 	du := newWithFakeCmd(t,
-		fakecmd.Stdout("plutil", `{
-			"Snapshots": []
-		}`),
+		fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array/>
+</dict>
+</plist>`),
 		fakecmd.WantArg("diskutil", exampleVolumeInfo.Device),
 	)
-	_, err := du.ListSnapshots(exampleVolumeInfo)
+	_, err := du.ListSnapshots(context.Background(), exampleVolumeInfo)
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
 	}
@@ -287,82 +533,86 @@ func TestListSnapshots_Errors(t *testing.T) {
 		{
 			name: "snapshots in unexpected order",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "bar-snapshot-name-2021-04-03-012345",
-							"SnapshotUUID": "bar-snapshot-uuid"
-						},
-						{
-							"SnapshotName": "foo-snapshot-name-2021-03-02-012345",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>bar-snapshot-name-2021-04-03-012345</string>
+			<key>SnapshotUUID</key>
+			<string>bar-snapshot-uuid</string>
+		</dict>
+		<dict>
+			<key>SnapshotName</key>
+			<string>foo-snapshot-name-2021-03-02-012345</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
 			},
 			wantErrAs: &validationErr,
 		},
 		{
 			name: "no time in name",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "foo-snapshot-name",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>foo-snapshot-name</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
 			},
 			wantErrAs: &validationErr,
 		},
 		{
 			name: "invalid time in name",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", `{
-					"Snapshots": [
-						{
-							"SnapshotName": "foo-snapshot-name-2021-13-01-000000",
-							"SnapshotUUID": "foo-snapshot-uuid"
-						}
-					]
-				}`),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+		<dict>
+			<key>SnapshotName</key>
+			<string>foo-snapshot-name-2021-13-01-000000</string>
+			<key>SnapshotUUID</key>
+			<string>foo-snapshot-uuid</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
 			},
 			wantErrAs: &validationErr,
 		},
 		{
 			name: "diskutil exec errors",
 			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", "{}"),
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict/>
+</plist>`),
 				fakecmd.Stderr("diskutil", "stderr"),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
 				fakecmd.ExitFail("diskutil"),
 			},
 			wantErrAs: &exitErr,
 		},
-		{
-			name: "plutil exec errors",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("diskutil", "foo-stdout"),
-				fakecmd.Stdout("plutil", "{}"),
-				fakecmd.Stderr("plutil", "stderr"),
-				fakecmd.WantStdin("plutil", "foo-stdout"),
-				fakecmd.ExitFail("plutil"),
-			},
-			wantErrAs: &exitErr,
-		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			du := newWithFakeCmd(t, test.opts...)
-			_, err := du.ListSnapshots(exampleVolumeInfo)
+			_, err := du.ListSnapshots(context.Background(), exampleVolumeInfo)
 			if err := fakecmd.AsHelperProcessErr(err); err != nil {
 				t.Fatal(err)
 			}
@@ -375,7 +625,7 @@ func TestListSnapshots_Errors(t *testing.T) {
 
 func TestRename(t *testing.T) {
 	du := newWithFakeCmd(t)
-	err := du.Rename(exampleVolumeInfo, "newname")
+	err := du.Rename(context.Background(), exampleVolumeInfo, "newname")
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
 	}
@@ -386,7 +636,7 @@ func TestRename(t *testing.T) {
 
 func TestRename_IDsVolumesByDevice(t *testing.T) {
 	du := newWithFakeCmd(t, fakecmd.WantArg("diskutil", exampleVolumeInfo.Device))
-	err := du.Rename(exampleVolumeInfo, "newname")
+	err := du.Rename(context.Background(), exampleVolumeInfo, "newname")
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
 	}
@@ -395,13 +645,28 @@ func TestRename_IDsVolumesByDevice(t *testing.T) {
 	}
 }
 
+func TestRename_Trace(t *testing.T) {
+	var trace bytes.Buffer
+	du := New(Trace(&trace), withExecCommand(fakecmd.FakeCommandContext(t)))
+	err := du.Rename(context.Background(), exampleVolumeInfo, "new name")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Rename returned unexpected error: %v, want: nil", err)
+	}
+	if got, want := trace.String(), "+ diskutil rename /dev/example-volume \"new name\"\n"; got != want {
+		t.Errorf("Rename traced %q, want: %q", got, want)
+	}
+}
+
 func TestRename_Errors(t *testing.T) {
 	opts := []fakecmd.Option{
 		fakecmd.Stderr("diskutil", "example stderr"),
 		fakecmd.ExitFail("diskutil"),
 	}
 	du := newWithFakeCmd(t, opts...)
-	err := du.Rename(exampleVolumeInfo, "newname")
+	err := du.Rename(context.Background(), exampleVolumeInfo, "newname")
 	if err := fakecmd.AsHelperProcessErr(err); err != nil {
 		t.Fatal(err)
 	}
@@ -411,9 +676,19 @@ func TestRename_Errors(t *testing.T) {
 	}
 }
 
+func TestRename_Cancelled(t *testing.T) {
+	du := newWithFakeCmd(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := du.Rename(ctx, exampleVolumeInfo, "newname")
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Rename returned unexpected error: %v, want: %v", err, context.Canceled)
+	}
+}
+
 func TestDeleteSnapshot(t *testing.T) {
 	du := newWithFakeCmd(t)
-	err := du.DeleteSnapshot(exampleVolumeInfo, Snapshot{
+	err := du.DeleteSnapshot(context.Background(), exampleVolumeInfo, Snapshot{
 		Name: "example-snapshot",
 		UUID: "example-snapshot-uuid",
 	})
@@ -427,7 +702,7 @@ func TestDeleteSnapshot(t *testing.T) {
 
 func TestDeleteSnapshot_IDsVolumesByDevice(t *testing.T) {
 	du := newWithFakeCmd(t, fakecmd.WantArg("diskutil", exampleVolumeInfo.Device))
-	err := du.DeleteSnapshot(exampleVolumeInfo, Snapshot{
+	err := du.DeleteSnapshot(context.Background(), exampleVolumeInfo, Snapshot{
 		Name: "example-snapshot",
 		UUID: "example-snapshot-uuid",
 	})
@@ -445,7 +720,7 @@ func TestDeleteSnapshot_Errors(t *testing.T) {
 		fakecmd.ExitFail("diskutil"),
 	}
 	du := newWithFakeCmd(t, opts...)
-	err := du.DeleteSnapshot(exampleVolumeInfo, Snapshot{
+	err := du.DeleteSnapshot(context.Background(), exampleVolumeInfo, Snapshot{
 		Name: "example-snapshot",
 		UUID: "example-snapshot-uuid",
 	})
@@ -457,3 +732,466 @@ func TestDeleteSnapshot_Errors(t *testing.T) {
 		t.Errorf("DeleteSnapshot returned unexpected error: %v, want type: *exec.ExitError", err)
 	}
 }
+
+func TestEraseVolume(t *testing.T) {
+	du := newWithFakeCmd(t)
+	err := du.EraseVolume(context.Background(), exampleVolumeInfo, "newname")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("EraseVolume returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestEraseVolume_IDsVolumesByDevice(t *testing.T) {
+	du := newWithFakeCmd(t, fakecmd.WantArg("diskutil", exampleVolumeInfo.Device))
+	err := du.EraseVolume(context.Background(), exampleVolumeInfo, "newname")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("EraseVolume returned unexpected error: %q, want: nil", err)
+	}
+}
+
+func TestEraseVolume_Errors(t *testing.T) {
+	opts := []fakecmd.Option{
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	}
+	du := newWithFakeCmd(t, opts...)
+	err := du.EraseVolume(context.Background(), exampleVolumeInfo, "newname")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("EraseVolume returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestContainerVolumes(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Containers</key>
+	<array>
+		<dict>
+			<key>ContainerReference</key>
+			<string>disk3</string>
+			<key>Volumes</key>
+			<array>
+				<dict>
+					<key>DeviceIdentifier</key>
+					<string>disk3s1</string>
+					<key>Name</key>
+					<string>foo-name</string>
+					<key>APFSVolumeUUID</key>
+					<string>foo-uuid</string>
+					<key>CapacityQuota</key>
+					<integer>0</integer>
+					<key>CapacityReserve</key>
+					<integer>0</integer>
+				</dict>
+				<dict>
+					<key>DeviceIdentifier</key>
+					<string>disk3s2</string>
+					<key>Name</key>
+					<string>bar-name</string>
+					<key>APFSVolumeUUID</key>
+					<string>bar-uuid</string>
+					<key>CapacityQuota</key>
+					<integer>2000000000</integer>
+					<key>CapacityReserve</key>
+					<integer>1000000000</integer>
+				</dict>
+			</array>
+		</dict>
+	</array>
+</dict>
+</plist>`),
+		fakecmd.WantArg("diskutil", "disk3"),
+	)
+	got, err := du.ContainerVolumes(context.Background(), "disk3")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("ContainerVolumes returned unexpected error: %v, want: nil", err)
+	}
+	want := []VolumeInfo{
+		{
+			UUID:      "foo-uuid",
+			Name:      "foo-name",
+			Device:    "/dev/disk3s1",
+			Container: "disk3",
+		},
+		{
+			UUID:      "bar-uuid",
+			Name:      "bar-name",
+			Device:    "/dev/disk3s2",
+			Container: "disk3",
+			Quota:     2000000000,
+			Reserve:   1000000000,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ContainerVolumes returned unexpected volumes. -want +got:\n%s", diff)
+	}
+}
+
+func TestAPFSVolumes(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Containers</key>
+	<array>
+		<dict>
+			<key>ContainerReference</key>
+			<string>disk3</string>
+			<key>Volumes</key>
+			<array>
+				<dict>
+					<key>DeviceIdentifier</key>
+					<string>disk3s1</string>
+					<key>Name</key>
+					<string>foo-name</string>
+					<key>APFSVolumeUUID</key>
+					<string>foo-uuid</string>
+				</dict>
+			</array>
+		</dict>
+		<dict>
+			<key>ContainerReference</key>
+			<string>disk5</string>
+			<key>Volumes</key>
+			<array>
+				<dict>
+					<key>DeviceIdentifier</key>
+					<string>disk5s1</string>
+					<key>Name</key>
+					<string>bar-name</string>
+					<key>APFSVolumeUUID</key>
+					<string>bar-uuid</string>
+				</dict>
+			</array>
+		</dict>
+	</array>
+</dict>
+</plist>`),
+		fakecmd.WantArg("diskutil", "apfs"),
+		fakecmd.WantArg("diskutil", "list"),
+		fakecmd.WantArg("diskutil", "-plist"),
+	)
+	got, err := du.APFSVolumes(context.Background())
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("APFSVolumes returned unexpected error: %v, want: nil", err)
+	}
+	want := []VolumeInfo{
+		{
+			UUID:      "foo-uuid",
+			Name:      "foo-name",
+			Device:    "/dev/disk3s1",
+			Container: "disk3",
+		},
+		{
+			UUID:      "bar-uuid",
+			Name:      "bar-name",
+			Device:    "/dev/disk5s1",
+			Container: "disk5",
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("APFSVolumes returned unexpected volumes. -want +got:\n%s", diff)
+	}
+}
+
+func TestContainerVolumes_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []fakecmd.Option
+	}{
+		{
+			name: "diskutil exec errors",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict/>
+</plist>`),
+				fakecmd.ExitFail("diskutil"),
+			},
+		},
+		{
+			name: "not a container",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("diskutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Containers</key>
+	<array/>
+</dict>
+</plist>`),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			du := newWithFakeCmd(t, test.opts...)
+			_, err := du.ContainerVolumes(context.Background(), "disk3")
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Error("ContainerVolumes returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestAddVolume(t *testing.T) {
+	tests := []struct {
+		name   string
+		volume VolumeInfo
+		opts   []fakecmd.Option
+	}{
+		{
+			name: "without quota or reserve",
+			volume: VolumeInfo{
+				Name:       "newname",
+				FileSystem: "Case-sensitive APFS",
+			},
+			opts: []fakecmd.Option{
+				fakecmd.WantArg("diskutil", "disk3"),
+				fakecmd.WantArg("diskutil", "Case-sensitive APFS"),
+				fakecmd.WantArg("diskutil", "newname"),
+			},
+		},
+		{
+			name: "with quota and reserve",
+			volume: VolumeInfo{
+				Name:       "newname",
+				FileSystem: "APFS",
+				Quota:      2000000000,
+				Reserve:    1000000000,
+			},
+			opts: []fakecmd.Option{
+				fakecmd.WantArg("diskutil", "newname"),
+				fakecmd.WantArg("diskutil", "-quota"),
+				fakecmd.WantArg("diskutil", "2000000000B"),
+				fakecmd.WantArg("diskutil", "-reserve"),
+				fakecmd.WantArg("diskutil", "1000000000B"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			du := newWithFakeCmd(t, test.opts...)
+			err := du.AddVolume(context.Background(), "disk3", test.volume)
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err != nil {
+				t.Fatalf("AddVolume returned unexpected error: %v, want: nil", err)
+			}
+		})
+	}
+}
+
+func TestAddVolume_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.AddVolume(context.Background(), "disk3", VolumeInfo{Name: "newname", FileSystem: "APFS"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("AddVolume returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestMount(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.WantArg("diskutil", "mount"),
+		fakecmd.WantArg("diskutil", "/dev/disk1s2"),
+	)
+	err := du.Mount(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Mount returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestMount_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.Mount(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("Mount returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestMountReadOnly(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.WantArg("diskutil", "mount"),
+		fakecmd.WantArg("diskutil", "readOnly"),
+		fakecmd.WantArg("diskutil", "/dev/disk1s2"),
+	)
+	err := du.MountReadOnly(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("MountReadOnly returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestMountReadOnly_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.MountReadOnly(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("MountReadOnly returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestUnmount(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.WantArg("diskutil", "unmount"),
+		fakecmd.WantArg("diskutil", "/dev/disk1s2"),
+	)
+	err := du.Unmount(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Unmount returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestUnmount_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.Unmount(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("Unmount returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestEject(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.WantArg("diskutil", "eject"),
+		fakecmd.WantArg("diskutil", "/dev/disk1s2"),
+	)
+	err := du.Eject(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Eject returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestEject_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.Eject(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("Eject returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestUnlockVolume(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.WantArg("diskutil", "unlockVolume"),
+		fakecmd.WantArg("diskutil", "/dev/disk1s2"),
+		fakecmd.WantArg("diskutil", "-stdinpassphrase"),
+		fakecmd.WantStdin("diskutil", "example passphrase"),
+	)
+	err := du.UnlockVolume(context.Background(), VolumeInfo{Device: "/dev/disk1s2"}, "example passphrase")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("UnlockVolume returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestLockVolume(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.WantArg("diskutil", "lockVolume"),
+		fakecmd.WantArg("diskutil", "/dev/disk1s2"),
+	)
+	err := du.LockVolume(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("LockVolume returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestLockVolume_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.LockVolume(context.Background(), VolumeInfo{Device: "/dev/disk1s2"})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("LockVolume returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestUnlockVolume_Errors(t *testing.T) {
+	du := newWithFakeCmd(t,
+		fakecmd.Stderr("diskutil", "example stderr"),
+		fakecmd.WantStdin("diskutil", "wrong passphrase"),
+		fakecmd.ExitFail("diskutil"),
+	)
+	err := du.UnlockVolume(context.Background(), VolumeInfo{Device: "/dev/disk1s2"}, "wrong passphrase")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("UnlockVolume returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
@@ -1,30 +1,72 @@
 package diskutil
 
+import "context"
+
 type dryRun struct {
 	du DiskUtil
 }
 
 // NewDryRun returns a DiskUtil that cannot modify any volumes. All
-// readonly methods (Info and ListSnapshots) are passed through to the
-// underlying DiskUtil, du.
+// readonly methods (Info, ListSnapshots, ContainerVolumes, and APFSVolumes)
+// are passed through to the underlying DiskUtil, du.
 func NewDryRun(du DiskUtil) DiskUtil {
 	return dryRun{
 		du: du,
 	}
 }
 
-func (dry dryRun) Info(volume string) (VolumeInfo, error) {
-	return dry.du.Info(volume)
+func (dry dryRun) Info(ctx context.Context, volume string) (VolumeInfo, error) {
+	return dry.du.Info(ctx, volume)
+}
+
+func (dry dryRun) Rename(ctx context.Context, volume VolumeInfo, name string) error {
+	return nil
+}
+
+func (dry dryRun) ListSnapshots(ctx context.Context, volume VolumeInfo) (SnapshotList, error) {
+	return dry.du.ListSnapshots(ctx, volume)
 }
 
-func (dry dryRun) Rename(volume VolumeInfo, name string) error {
+func (dry dryRun) DeleteSnapshot(ctx context.Context, volume VolumeInfo, snap Snapshot) error {
 	return nil
 }
 
-func (dry dryRun) ListSnapshots(volume VolumeInfo) ([]Snapshot, error) {
-	return dry.du.ListSnapshots(volume)
+func (dry dryRun) EraseVolume(ctx context.Context, volume VolumeInfo, name string) error {
+	return nil
+}
+
+func (dry dryRun) ContainerVolumes(ctx context.Context, container string) ([]VolumeInfo, error) {
+	return dry.du.ContainerVolumes(ctx, container)
+}
+
+func (dry dryRun) APFSVolumes(ctx context.Context) ([]VolumeInfo, error) {
+	return dry.du.APFSVolumes(ctx)
+}
+
+func (dry dryRun) AddVolume(ctx context.Context, container string, volume VolumeInfo) error {
+	return nil
+}
+
+func (dry dryRun) Mount(ctx context.Context, volume VolumeInfo) error {
+	return nil
+}
+
+func (dry dryRun) MountReadOnly(ctx context.Context, volume VolumeInfo) error {
+	return nil
+}
+
+func (dry dryRun) Unmount(ctx context.Context, volume VolumeInfo) error {
+	return nil
+}
+
+func (dry dryRun) Eject(ctx context.Context, volume VolumeInfo) error {
+	return nil
+}
+
+func (dry dryRun) UnlockVolume(ctx context.Context, volume VolumeInfo, passphrase string) error {
+	return nil
 }
 
-func (dry dryRun) DeleteSnapshot(volume VolumeInfo, snap Snapshot) error {
+func (dry dryRun) LockVolume(ctx context.Context, volume VolumeInfo) error {
 	return nil
 }
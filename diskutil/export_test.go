@@ -0,0 +1,6 @@
+package diskutil
+
+// WithExecCommand exports withExecCommand to external tests, such as those
+// using the simfailure package, which cannot be imported by internal tests
+// without an import cycle.
+var WithExecCommand = withExecCommand
@@ -0,0 +1,43 @@
+package diskutil
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultSnapshotNameTemplate is the template of the names of snapshots
+// created by this utility, e.g. offsite.homefolder.2021-03-01-203509.
+const DefaultSnapshotNameTemplate = "offsite.{set}.{timestamp}"
+
+// snapshotTimestampLayout is the layout of the timestamps in snapshot names,
+// as parsed by parseTimeFromSnapshotName.
+const snapshotTimestampLayout = "2006-01-02-150405"
+
+// SnapshotName returns the name of a snapshot of set created at t, by
+// expanding template's {set} and {timestamp} placeholders. The timestamp is
+// t in UTC, in the form yyyy-mm-dd-hhmmss, so that ListSnapshots orders the
+// snapshot like the snapshots of other tools.
+//
+// An error is returned if template has no {timestamp} placeholder, or if the
+// name would not be parsed as created at t, e.g. because set contains
+// another timestamp.
+func SnapshotName(template, set string, t time.Time) (string, error) {
+	if !strings.Contains(template, "{timestamp}") {
+		return "", errors.New("invalid snapshot name template: missing {timestamp}")
+	}
+	t = t.UTC().Truncate(time.Second)
+	name := strings.NewReplacer(
+		"{set}", set,
+		"{timestamp}", t.Format(snapshotTimestampLayout),
+	).Replace(template)
+	created, err := parseTimeFromSnapshotName(name, DefaultTimestampPatterns, time.UTC)
+	if err != nil {
+		return "", err
+	}
+	if !created.Equal(t) {
+		return "", fmt.Errorf("snapshot name %q would be parsed as created at %s, not %s", name, created, t)
+	}
+	return name, nil
+}
@@ -0,0 +1,48 @@
+package diskutil
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotName(t *testing.T) {
+	created := time.Date(2021, 3, 1, 20, 35, 9, 0, time.FixedZone("PST", -8*60*60))
+	got, err := SnapshotName(DefaultSnapshotNameTemplate, "homefolder", created)
+	if err != nil {
+		t.Fatalf("SnapshotName returned unexpected error: %v, want: nil", err)
+	}
+	if want := "offsite.homefolder.2021-03-02-043509"; got != want {
+		t.Errorf("SnapshotName returned %q, want: %q", got, want)
+	}
+	parsed, err := parseTimeFromSnapshotName(got, DefaultTimestampPatterns, time.UTC)
+	if err != nil || !parsed.Equal(created) {
+		t.Errorf("parseTimeFromSnapshotName(%q) returned (%s, %v), want: (%s, nil)", got, parsed, err, created)
+	}
+}
+
+func TestSnapshotName_Errors(t *testing.T) {
+	created := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	tests := []struct {
+		name     string
+		template string
+		set      string
+	}{
+		{
+			name:     "missing timestamp",
+			template: "offsite.{set}",
+			set:      "homefolder",
+		},
+		{
+			name:     "set contains timestamp",
+			template: DefaultSnapshotNameTemplate,
+			set:      "2020-01-01-000000",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got, err := SnapshotName(test.template, test.set, created); err == nil {
+				t.Errorf("SnapshotName returned (%q, nil), want: non-nil error", got)
+			}
+		})
+	}
+}
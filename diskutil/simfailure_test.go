@@ -0,0 +1,35 @@
+package diskutil_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/simfailure"
+)
+
+func TestListSnapshots_SimulatedFailures(t *testing.T) {
+	newest := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	for _, test := range simfailure.Cases(newest) {
+		t.Run(test.Name, func(t *testing.T) {
+			du := diskutil.New(diskutil.WithExecCommand(fakecmd.FakeCommandContext(t,
+				fakecmd.Stdout("diskutil", test.Output),
+			)))
+			got, err := du.ListSnapshots(context.Background(), diskutil.VolumeInfo{Device: "/dev/disk4s1"})
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if !errors.Is(err, test.WantErr) {
+				t.Fatalf("ListSnapshots returned unexpected error: %v, want: %v", err, test.WantErr)
+			}
+			if diff := cmp.Diff(test.Want, got); diff != "" {
+				t.Errorf("ListSnapshots returned unexpected snapshots. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package diskutil
+
+import (
+	"strings"
+)
+
+// MountedSnapshot is an APFS snapshot mounted read-only, e.g. with
+// `mount_apfs -s` or by Time Machine.
+type MountedSnapshot struct {
+	// Snapshot is the name of the snapshot.
+	Snapshot string
+	// Device is the /dev/ path of the snapshot's volume.
+	Device string
+	// MountPoint is where the snapshot is mounted.
+	MountPoint string
+}
+
+// SnapshotMountedAt returns the snapshot mounted at path. ok is false if path
+// is not the mount point of a snapshot, e.g. if it is the mount point of a
+// volume. Snapshots are only detected on MacOS.
+func SnapshotMountedAt(path string) (snap MountedSnapshot, ok bool, err error) {
+	return snapshotMountedAt(path)
+}
+
+// parseMountedFrom parses the source of a mount, as reported by statfs(2).
+// Snapshot mounts are mounted from <snapshot name>@<volume device>.
+func parseMountedFrom(from, mountPoint string) (snap MountedSnapshot, ok bool) {
+	i := strings.LastIndex(from, "@/dev/")
+	if i <= 0 {
+		return MountedSnapshot{}, false
+	}
+	return MountedSnapshot{
+		Snapshot:   from[:i],
+		Device:     from[i+1:],
+		MountPoint: mountPoint,
+	}, true
+}
@@ -0,0 +1,34 @@
+// +build darwin
+
+package diskutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"syscall"
+)
+
+func snapshotMountedAt(path string) (MountedSnapshot, bool, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return MountedSnapshot{}, false, fmt.Errorf("error getting mount of %q: %w", path, err)
+	}
+	mountPoint := cString(stat.Mntonname[:])
+	// Paths within a mounted snapshot are not the snapshot itself.
+	if abs, err := filepath.Abs(path); err != nil || filepath.Clean(abs) != mountPoint {
+		return MountedSnapshot{}, false, nil
+	}
+	snap, ok := parseMountedFrom(cString(stat.Mntfromname[:]), mountPoint)
+	return snap, ok, nil
+}
+
+func cString(b []int8) string {
+	s := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == 0 {
+			break
+		}
+		s = append(s, byte(c))
+	}
+	return string(s)
+}
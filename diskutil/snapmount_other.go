@@ -0,0 +1,7 @@
+// +build !darwin
+
+package diskutil
+
+func snapshotMountedAt(path string) (MountedSnapshot, bool, error) {
+	return MountedSnapshot{}, false, nil
+}
@@ -0,0 +1,56 @@
+package diskutil
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseMountedFrom(t *testing.T) {
+	tests := []struct {
+		name   string
+		from   string
+		want   MountedSnapshot
+		wantOK bool
+	}{
+		{
+			name: "time machine snapshot",
+			from: "com.apple.TimeMachine.2021-03-01-203509.local@/dev/disk1s1",
+			want: MountedSnapshot{
+				Snapshot:   "com.apple.TimeMachine.2021-03-01-203509.local",
+				Device:     "/dev/disk1s1",
+				MountPoint: "/Volumes/snap",
+			},
+			wantOK: true,
+		},
+		{
+			name: "snapshot name containing @",
+			from: "user@host.2021-03-01-203509@/dev/disk2s1",
+			want: MountedSnapshot{
+				Snapshot:   "user@host.2021-03-01-203509",
+				Device:     "/dev/disk2s1",
+				MountPoint: "/Volumes/snap",
+			},
+			wantOK: true,
+		},
+		{
+			name: "volume",
+			from: "/dev/disk1s1",
+		},
+		{
+			name: "network share",
+			from: "//user@server/share",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseMountedFrom(test.from, "/Volumes/snap")
+			if ok != test.wantOK {
+				t.Fatalf("parseMountedFrom(%q) returned ok: %t, want: %t", test.from, ok, test.wantOK)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("parseMountedFrom(%q) returned unexpected snapshot. -want +got:\n%s", test.from, diff)
+			}
+		})
+	}
+}
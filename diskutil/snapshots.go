@@ -0,0 +1,115 @@
+package diskutil
+
+import (
+	"time"
+)
+
+// SnapshotList is a list of a volume's APFS snapshots, ordered most recent
+// first, as returned by ListSnapshots.
+type SnapshotList []Snapshot
+
+// Latest returns the most recent snapshot. ok is false if the list is empty.
+func (l SnapshotList) Latest() (snap Snapshot, ok bool) {
+	if len(l) == 0 {
+		return Snapshot{}, false
+	}
+	return l[0], true
+}
+
+// Contains returns true if the list contains the snapshot with the given
+// UUID.
+func (l SnapshotList) Contains(uuid string) bool {
+	return l.index(uuid) >= 0
+}
+
+// CommonWith returns the most recent snapshot in l that is also in other. ok is
+// false if l and other have no snapshots in common.
+func (l SnapshotList) CommonWith(other SnapshotList) (snap Snapshot, ok bool) {
+	// Index other's UUIDs, so that long lists are not compared pairwise.
+	uuids := make(map[string]bool, len(other))
+	for _, s := range other {
+		uuids[s.UUID] = true
+	}
+	for _, s := range l {
+		if uuids[s.UUID] {
+			return s, true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// Since returns the snapshots created after t, most recent first.
+func (l SnapshotList) Since(t time.Time) SnapshotList {
+	var since SnapshotList
+	for _, s := range l {
+		if s.Created.After(t) {
+			since = append(since, s)
+		}
+	}
+	return since
+}
+
+// Filter returns the snapshots for which keep returns true, most recent first.
+func (l SnapshotList) Filter(keep func(Snapshot) bool) SnapshotList {
+	var kept SnapshotList
+	for _, s := range l {
+		if keep(s) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+// Oldest returns the n oldest snapshots, most recent first. If the list has
+// fewer than n snapshots, all snapshots are returned.
+func (l SnapshotList) Oldest(n int) SnapshotList {
+	if n <= 0 {
+		return nil
+	}
+	if n > len(l) {
+		n = len(l)
+	}
+	return l[len(l)-n:]
+}
+
+// Before returns the snapshots older than the snapshot with the given UUID,
+// most recent first. If the list does not contain the snapshot, Before
+// returns nil.
+func (l SnapshotList) Before(uuid string) SnapshotList {
+	i := l.index(uuid)
+	if i < 0 {
+		return nil
+	}
+	return l[i+1:]
+}
+
+// After returns the snapshots more recent than the snapshot with the given
+// UUID, most recent first. If the list does not contain the snapshot, After
+// returns nil.
+func (l SnapshotList) After(uuid string) SnapshotList {
+	i := l.index(uuid)
+	if i < 0 {
+		return nil
+	}
+	return l[:i]
+}
+
+// Find returns the snapshot whose name or UUID is id. ok is false if the list
+// does not contain the snapshot.
+func (l SnapshotList) Find(id string) (snap Snapshot, ok bool) {
+	for _, s := range l {
+		if s.Name == id || s.UUID == id {
+			return s, true
+		}
+	}
+	return Snapshot{}, false
+}
+
+func (l SnapshotList) index(uuid string) int {
+	for i, s := range l {
+		if s.UUID == uuid {
+			return i
+		}
+	}
+	return -1
+}
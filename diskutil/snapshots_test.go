@@ -0,0 +1,144 @@
+package diskutil
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+var (
+	snap1 = Snapshot{Name: "snap-1", UUID: "snap-1-uuid", Created: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)}
+	snap2 = Snapshot{Name: "snap-2", UUID: "snap-2-uuid", Created: time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)}
+	snap3 = Snapshot{Name: "snap-3", UUID: "snap-3-uuid", Created: time.Date(2021, 3, 3, 0, 0, 0, 0, time.UTC)}
+)
+
+func TestSnapshotList_Latest(t *testing.T) {
+	got, ok := SnapshotList{snap3, snap2, snap1}.Latest()
+	if !ok || got != snap3 {
+		t.Errorf("Latest() returned (%v, %t), want: (%v, true)", got, ok, snap3)
+	}
+	if _, ok := (SnapshotList{}).Latest(); ok {
+		t.Error("Latest() of empty list returned ok: true, want: false")
+	}
+}
+
+func TestSnapshotList_Contains(t *testing.T) {
+	l := SnapshotList{snap3, snap1}
+	if !l.Contains(snap1.UUID) {
+		t.Errorf("Contains(%q) returned false, want: true", snap1.UUID)
+	}
+	if l.Contains(snap2.UUID) {
+		t.Errorf("Contains(%q) returned true, want: false", snap2.UUID)
+	}
+}
+
+func TestSnapshotList_CommonWith(t *testing.T) {
+	tests := []struct {
+		name   string
+		l      SnapshotList
+		other  SnapshotList
+		want   Snapshot
+		wantOK bool
+	}{
+		{
+			name:   "latest common",
+			l:      SnapshotList{snap3, snap2, snap1},
+			other:  SnapshotList{snap2, snap1},
+			want:   snap2,
+			wantOK: true,
+		},
+		{
+			name:  "none in common",
+			l:     SnapshotList{snap3, snap2},
+			other: SnapshotList{snap1},
+		},
+		{
+			name: "empty",
+			l:    SnapshotList{snap1},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.l.CommonWith(test.other)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("CommonWith(...) returned (%v, %t), want: (%v, %t)", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestSnapshotList_Since(t *testing.T) {
+	l := SnapshotList{snap3, snap2, snap1}
+	got := l.Since(snap1.Created)
+	if diff := cmp.Diff(SnapshotList{snap3, snap2}, got); diff != "" {
+		t.Errorf("Since(...) returned unexpected snapshots. -want +got:\n%s", diff)
+	}
+	if got := l.Since(snap3.Created); len(got) != 0 {
+		t.Errorf("Since(latest) returned %v, want: empty", got)
+	}
+}
+
+func TestSnapshotList_Filter(t *testing.T) {
+	l := SnapshotList{snap3, snap2, snap1}
+	got := l.Filter(func(s Snapshot) bool {
+		return s.Name != "snap-2"
+	})
+	if diff := cmp.Diff(SnapshotList{snap3, snap1}, got); diff != "" {
+		t.Errorf("Filter(...) returned unexpected snapshots. -want +got:\n%s", diff)
+	}
+}
+
+func TestSnapshotList_Oldest(t *testing.T) {
+	l := SnapshotList{snap3, snap2, snap1}
+	tests := []struct {
+		n    int
+		want SnapshotList
+	}{
+		{n: 0, want: nil},
+		{n: 2, want: SnapshotList{snap2, snap1}},
+		{n: 5, want: SnapshotList{snap3, snap2, snap1}},
+	}
+	for _, test := range tests {
+		got := l.Oldest(test.n)
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("Oldest(%d) returned unexpected snapshots. -want +got:\n%s", test.n, diff)
+		}
+	}
+}
+
+func TestSnapshotList_Find(t *testing.T) {
+	l := SnapshotList{snap3, snap2, snap1}
+	for _, id := range []string{snap2.Name, snap2.UUID} {
+		if got, ok := l.Find(id); !ok || got != snap2 {
+			t.Errorf("Find(%q) returned (%v, %t), want: (%v, true)", id, got, ok, snap2)
+		}
+	}
+	if got, ok := l.Find("does-not-exist"); ok {
+		t.Errorf("Find(missing snapshot) returned (%v, true), want: (_, false)", got)
+	}
+}
+
+func TestSnapshotList_Before(t *testing.T) {
+	l := SnapshotList{snap3, snap2, snap1}
+	if diff := cmp.Diff(SnapshotList{snap2, snap1}, l.Before(snap3.UUID)); diff != "" {
+		t.Errorf("Before(...) returned unexpected snapshots. -want +got:\n%s", diff)
+	}
+	if got := l.Before("does-not-exist"); got != nil {
+		t.Errorf("Before(missing snapshot) returned %v, want: nil", got)
+	}
+}
+
+func TestSnapshotList_After(t *testing.T) {
+	l := SnapshotList{snap3, snap2, snap1}
+	if diff := cmp.Diff(SnapshotList{snap3, snap2}, l.After(snap1.UUID)); diff != "" {
+		t.Errorf("After(...) returned unexpected snapshots. -want +got:\n%s", diff)
+	}
+	if got := l.After(snap3.UUID); len(got) != 0 {
+		t.Errorf("After(latest snapshot) returned %v, want: empty", got)
+	}
+	if got := l.After("does-not-exist"); got != nil {
+		t.Errorf("After(missing snapshot) returned %v, want: nil", got)
+	}
+}
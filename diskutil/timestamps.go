@@ -0,0 +1,103 @@
+package diskutil
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EpochLayout is the Layout of TimestampPatterns whose timestamps are Unix
+// times in seconds, e.g. 1620091425.
+const EpochLayout = "epoch"
+
+// TimestampPattern matches and parses the timestamps in snapshot names, so
+// that snapshots named by other tools can be ordered by creation time.
+type TimestampPattern struct {
+	// Regexp matches the timestamp in a snapshot name. If it has a
+	// subexpression named timestamp, e.g. `\.(?P<timestamp>\d{10})$`, only
+	// its match is parsed, e.g. to match a timestamp by its surroundings.
+	Regexp *regexp.Regexp
+	// Layout is the layout of the matched timestamp, as in time.Parse, or
+	// EpochLayout. Timestamps whose layouts have zones, e.g.
+	// time.RFC3339, are parsed in their zones rather than in the time
+	// zone set by SnapshotTimeZone.
+	Layout string
+}
+
+// NewTimestampPattern returns the TimestampPattern matching timestamps with
+// expr, and parsing them with layout.
+func NewTimestampPattern(expr, layout string) (TimestampPattern, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return TimestampPattern{}, fmt.Errorf("invalid timestamp regexp %q: %v", expr, err)
+	}
+	if layout == "" {
+		return TimestampPattern{}, fmt.Errorf("timestamp regexp %q: layout is required", expr)
+	}
+	return TimestampPattern{Regexp: re, Layout: layout}, nil
+}
+
+// DefaultTimestampPatterns match the timestamps of the form yyyy-mm-dd-hhmmss
+// in the names of snapshots created by this utility, Time Machine, and Carbon
+// Copy Cloner.
+var DefaultTimestampPatterns = []TimestampPattern{
+	{
+		Regexp: regexp.MustCompile(`\d{4}-\d{2}-\d{2}-\d{6}`),
+		Layout: snapshotTimestampLayout,
+	},
+}
+
+func (p TimestampPattern) String() string {
+	return fmt.Sprintf("%s (%s)", p.Regexp, p.Layout)
+}
+
+// match returns the timestamp in name, or false if p does not match name.
+func (p TimestampPattern) match(name string) (string, bool) {
+	m := p.Regexp.FindStringSubmatch(name)
+	if m == nil {
+		return "", false
+	}
+	if i := p.Regexp.SubexpIndex("timestamp"); i > 0 {
+		return m[i], true
+	}
+	return m[0], true
+}
+
+// parse parses timestamp as a time in loc.
+func (p TimestampPattern) parse(timestamp string, loc *time.Location) (time.Time, error) {
+	if p.Layout == EpochLayout {
+		secs, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.Unix(secs, 0).In(loc), nil
+	}
+	return time.ParseInLocation(p.Layout, timestamp, loc)
+}
+
+// parseTimeFromSnapshotName parses the timestamp in name as a time in loc,
+// with the first of patterns that matches name.
+func parseTimeFromSnapshotName(name string, patterns []TimestampPattern, loc *time.Location) (time.Time, error) {
+	for _, p := range patterns {
+		timestamp, ok := p.match(name)
+		if !ok {
+			continue
+		}
+		created, err := p.parse(timestamp, loc)
+		if err != nil {
+			return time.Time{}, validationError{
+				fmt.Errorf("failed to parse time substring (%q) from snapshot name %q with layout %q: %v", timestamp, name, p.Layout, err),
+			}
+		}
+		return created, nil
+	}
+	var tried []string
+	for _, p := range patterns {
+		tried = append(tried, p.String())
+	}
+	return time.Time{}, validationError{
+		fmt.Errorf("snapshot name (%q) does not contain a timestamp matching any of: %s", name, strings.Join(tried, ", ")),
+	}
+}
@@ -0,0 +1,123 @@
+package diskutil
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestParseTimeFromSnapshotName(t *testing.T) {
+	epoch := TimestampPattern{
+		Regexp: regexp.MustCompile(`\.(?P<timestamp>\d{10})$`),
+		Layout: EpochLayout,
+	}
+	iso8601 := TimestampPattern{
+		Regexp: regexp.MustCompile(`\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(Z|[+-]\d{2}:\d{2})`),
+		Layout: time.RFC3339,
+	}
+	dated := TimestampPattern{
+		Regexp: regexp.MustCompile(`\d{8}`),
+		Layout: "20060102",
+	}
+	pdt := time.FixedZone("PDT", -7*60*60)
+	tests := []struct {
+		name     string
+		snapshot string
+		patterns []TimestampPattern
+		loc      *time.Location
+		want     time.Time
+	}{
+		{
+			name:     "default",
+			snapshot: "com.apple.TimeMachine.2021-05-04-012345.local",
+			patterns: DefaultTimestampPatterns,
+			loc:      time.UTC,
+			want:     time.Date(2021, 5, 4, 1, 23, 45, 0, time.UTC),
+		},
+		{
+			name:     "default in time zone",
+			snapshot: "com.apple.TimeMachine.2021-05-04-012345.local",
+			patterns: DefaultTimestampPatterns,
+			loc:      pdt,
+			want:     time.Date(2021, 5, 4, 8, 23, 45, 0, time.UTC),
+		},
+		{
+			name:     "epoch",
+			snapshot: "zrepl.1620091425",
+			patterns: []TimestampPattern{epoch},
+			loc:      pdt,
+			want:     time.Date(2021, 5, 4, 1, 23, 45, 0, time.UTC),
+		},
+		{
+			name:     "ISO 8601 with zone",
+			snapshot: "backup-2021-05-04T01:23:45+02:00",
+			patterns: []TimestampPattern{iso8601},
+			loc:      time.UTC,
+			want:     time.Date(2021, 5, 3, 23, 23, 45, 0, time.UTC),
+		},
+		{
+			name:     "first matching pattern",
+			snapshot: "offsite.20200101.2021-05-04-012345",
+			patterns: append([]TimestampPattern{epoch}, DefaultTimestampPatterns...),
+			loc:      time.UTC,
+			want:     time.Date(2021, 5, 4, 1, 23, 45, 0, time.UTC),
+		},
+		{
+			name:     "earlier pattern takes precedence",
+			snapshot: "offsite.20200101.2021-05-04-012345",
+			patterns: append([]TimestampPattern{dated}, DefaultTimestampPatterns...),
+			loc:      time.UTC,
+			want:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTimeFromSnapshotName(test.snapshot, test.patterns, test.loc)
+			if err != nil {
+				t.Fatalf("parseTimeFromSnapshotName(%q) returned unexpected error: %v, want: nil", test.snapshot, err)
+			}
+			if !got.Equal(test.want) {
+				t.Errorf("parseTimeFromSnapshotName(%q) = %s, want: %s", test.snapshot, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTimeFromSnapshotName_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot string
+		patterns []TimestampPattern
+	}{
+		{
+			name:     "no matching pattern",
+			snapshot: "zrepl.1620091425",
+			patterns: DefaultTimestampPatterns,
+		},
+		{
+			name:     "invalid timestamp",
+			snapshot: "offsite.2021-13-04-012345",
+			patterns: DefaultTimestampPatterns,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTimeFromSnapshotName(test.snapshot, test.patterns, time.UTC)
+			if err == nil {
+				t.Errorf("parseTimeFromSnapshotName(%q) returned unexpected error: nil, want: non-nil", test.snapshot)
+			}
+		})
+	}
+}
+
+func TestNewTimestampPattern(t *testing.T) {
+	if _, err := NewTimestampPattern(`\d{10}`, EpochLayout); err != nil {
+		t.Errorf("NewTimestampPattern returned unexpected error: %v, want: nil", err)
+	}
+	if _, err := NewTimestampPattern(`(\d{10}`, EpochLayout); err == nil {
+		t.Error("NewTimestampPattern with invalid regexp returned unexpected error: nil, want: non-nil")
+	}
+	if _, err := NewTimestampPattern(`\d{10}`, ""); err == nil {
+		t.Error("NewTimestampPattern without layout returned unexpected error: nil, want: non-nil")
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/lsof"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+)
+
+// ejectAttempts is how many times ejectTarget tries to eject a target, waiting
+// ejectBackoff after the first failure, and twice as long after each failure
+// after that.
+const (
+	ejectAttempts = 4
+	ejectBackoff  = 5 * time.Second
+)
+
+// ejectTarget ejects target, so that it is safe to unplug. Ejects fail while
+// any process has files open on the target, which Spotlight and antivirus
+// software often do briefly after a clone, so failed ejects are retried with
+// backoff, printing the processes holding files open.
+func ejectTarget(ctx context.Context, w io.Writer, du diskutil.DiskUtil, target string) error {
+	info, err := du.Info(ctx, target)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of %q: %w", target, err)
+	}
+	backoff := ejectBackoff
+	for attempt := 1; attempt <= ejectAttempts; attempt++ {
+		if attempt > 1 {
+			fmt.Fprintf(w, "Failed to eject target; retrying in %s: %v\n", backoff, err)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = du.Eject(ctx, info); err == nil {
+			fmt.Fprintln(w, "Ejected target.")
+			return nil
+		}
+		if holders := openOn(ctx, info); holders != "" {
+			err = fmt.Errorf("%w (files held open by %s)", err, holders)
+		}
+	}
+	logger.Log(oslog.Error, "failed to eject %q: %v", target, err)
+	return fmt.Errorf("error ejecting %q: %w", target, err)
+}
+
+// openOn returns the processes with files open on volume, or "" if there are
+// none or they could not be listed.
+func openOn(ctx context.Context, volume diskutil.VolumeInfo) string {
+	if volume.MountPoint == "" {
+		return ""
+	}
+	procs, err := lsof.New().OpenOn(ctx, volume.MountPoint)
+	if err != nil {
+		return ""
+	}
+	return lsof.Join(procs)
+}
@@ -0,0 +1,187 @@
+// Package estimate implements estimating the time remaining to clone a source
+// volume to one or more targets.
+package estimate
+
+import (
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
+)
+
+// Tracker estimates the time remaining to clone to each of a fixed number of
+// targets, one target at a time.
+//
+// The time remaining for the current target is extrapolated from its elapsed
+// time and progress. The time remaining for targets that have not yet started
+// is estimated from the average duration of completed targets, or the
+// projected duration of the current target if no target has completed yet.
+type Tracker struct {
+	targets int
+	clock   clock.Clock
+
+	completed []time.Duration
+	started   time.Time
+	percent   int
+}
+
+// Option configures a Tracker.
+type Option func(*Tracker)
+
+// Clock sets the clock used to measure elapsed time. Defaults to the system
+// clock.
+func Clock(c clock.Clock) Option {
+	return func(t *Tracker) {
+		t.clock = c
+	}
+}
+
+// NewTracker returns a Tracker for cloning to the given number of targets.
+func NewTracker(targets int, opts ...Option) *Tracker {
+	t := &Tracker{
+		targets: targets,
+		clock:   clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// Start records the start of the clone to the next target.
+func (t *Tracker) Start() {
+	t.started = t.clock.Now()
+	t.percent = 0
+}
+
+// Progress records the percent complete (0 to 100) of the current target.
+func (t *Tracker) Progress(percent int) {
+	t.percent = percent
+}
+
+// Finish records the end of the clone to the current target, and returns its
+// duration.
+func (t *Tracker) Finish() time.Duration {
+	d := t.clock.Now().Sub(t.started)
+	t.completed = append(t.completed, d)
+	t.started = time.Time{}
+	t.percent = 0
+	return d
+}
+
+// Remaining returns the estimated time remaining for the current target and
+// for all targets. ok is false if there is not yet enough information to make
+// an estimate.
+func (t *Tracker) Remaining() (target, overall time.Duration, ok bool) {
+	if t.started.IsZero() || t.percent <= 0 {
+		return 0, 0, false
+	}
+	elapsed := t.clock.Now().Sub(t.started)
+	projected := elapsed * 100 / time.Duration(t.percent)
+	target = projected - elapsed
+
+	perTarget := projected
+	if len(t.completed) > 0 {
+		var total time.Duration
+		for _, d := range t.completed {
+			total += d
+		}
+		perTarget = total / time.Duration(len(t.completed))
+	}
+	notStarted := t.targets - len(t.completed) - 1
+	if notStarted < 0 {
+		notStarted = 0
+	}
+	overall = target + time.Duration(notStarted)*perTarget
+	return target, overall, true
+}
+
+// HistoryRuns is the number of most recent clones to a target used to estimate
+// the duration of its next clone.
+const HistoryRuns = 5
+
+// FromHistory estimates the duration of the next clone from the durations of
+// previous clones, ordered oldest first. The estimate is the average of the
+// most recent HistoryRuns durations. runs is the number of durations the
+// estimate is based on, and is 0 if there is no history.
+func FromHistory(history []time.Duration) (estimate time.Duration, runs int) {
+	if len(history) > HistoryRuns {
+		history = history[len(history)-HistoryRuns:]
+	}
+	if len(history) == 0 {
+		return 0, 0
+	}
+	var total time.Duration
+	for _, d := range history {
+		total += d
+	}
+	return total / time.Duration(len(history)), len(history)
+}
+
+// Clone is a previous clone to a target.
+type Clone struct {
+	// Bytes is about how many bytes the clone copied, or 0 if unknown.
+	Bytes    uint64
+	Duration time.Duration
+}
+
+// Throughput returns the average throughput, in bytes per second, of the most
+// recent HistoryRuns clones of history, ordered oldest first, that copied a
+// known number of bytes. runs is the number of clones the throughput is based
+// on, and is 0 if none copied a known number of bytes.
+func Throughput(history []Clone) (bytesPerSecond float64, runs int) {
+	var bytes uint64
+	var total time.Duration
+	for i := len(history) - 1; i >= 0 && runs < HistoryRuns; i-- {
+		c := history[i]
+		if c.Bytes == 0 || c.Duration <= 0 {
+			continue
+		}
+		bytes += c.Bytes
+		total += c.Duration
+		runs++
+	}
+	if runs == 0 {
+		return 0, 0
+	}
+	return float64(bytes) / total.Seconds(), runs
+}
+
+// FromThroughput estimates the duration of a clone that copies bytes from the
+// throughput of previous clones, as returned by Throughput. runs is the number
+// of clones the estimate is based on, and is 0 if there is no throughput to
+// base it on.
+func FromThroughput(bytes uint64, history []Clone) (estimate time.Duration, runs int) {
+	throughput, runs := Throughput(history)
+	if runs == 0 {
+		return 0, 0
+	}
+	return time.Duration(float64(bytes) / throughput * float64(time.Second)), runs
+}
+
+// Usage is a volume's used space at a point in time.
+type Usage struct {
+	At    time.Time
+	Bytes uint64
+}
+
+// GrowthRate returns the average growth of used space, in bytes per day,
+// between the first and last of usage, ordered oldest first. ok is false if
+// usage does not span any time, or used space did not grow.
+func GrowthRate(usage []Usage) (bytesPerDay float64, ok bool) {
+	if len(usage) < 2 {
+		return 0, false
+	}
+	first, last := usage[0], usage[len(usage)-1]
+	days := last.At.Sub(first.At).Hours() / 24
+	if days <= 0 || last.Bytes <= first.Bytes {
+		return 0, false
+	}
+	return float64(last.Bytes-first.Bytes) / days, true
+}
+
+// Exhausted returns when free bytes will be used up, growing by bytesPerDay
+// from now.
+func Exhausted(free uint64, bytesPerDay float64, now time.Time) time.Time {
+	days := float64(free) / bytesPerDay
+	return now.Add(time.Duration(days * 24 * float64(time.Hour)))
+}
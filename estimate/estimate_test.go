@@ -0,0 +1,200 @@
+package estimate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
+)
+
+func TestTracker(t *testing.T) {
+	clock := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	tracker := NewTracker(3, Clock(clock))
+
+	if _, _, ok := tracker.Remaining(); ok {
+		t.Error("Remaining before Start returned ok: true, want: false")
+	}
+
+	// First target: no completed targets, so the overall estimate uses
+	// the projected duration of the current target.
+	tracker.Start()
+	if _, _, ok := tracker.Remaining(); ok {
+		t.Error("Remaining before any progress returned ok: true, want: false")
+	}
+	clock.Advance(time.Minute)
+	tracker.Progress(25)
+	target, overall, ok := tracker.Remaining()
+	if !ok {
+		t.Fatal("Remaining returned ok: false, want: true")
+	}
+	if want := 3 * time.Minute; target != want {
+		t.Errorf("Remaining returned target: %v, want: %v", target, want)
+	}
+	if want := 3*time.Minute + 2*4*time.Minute; overall != want {
+		t.Errorf("Remaining returned overall: %v, want: %v", overall, want)
+	}
+	clock.Advance(time.Minute)
+	if got, want := tracker.Finish(), 2*time.Minute; got != want {
+		t.Errorf("Finish returned: %v, want: %v", got, want)
+	}
+
+	// Second target: the overall estimate uses the average duration of
+	// completed targets.
+	tracker.Start()
+	clock.Advance(time.Minute)
+	tracker.Progress(50)
+	target, overall, ok = tracker.Remaining()
+	if !ok {
+		t.Fatal("Remaining returned ok: false, want: true")
+	}
+	if want := time.Minute; target != want {
+		t.Errorf("Remaining returned target: %v, want: %v", target, want)
+	}
+	if want := time.Minute + 2*time.Minute; overall != want {
+		t.Errorf("Remaining returned overall: %v, want: %v", overall, want)
+	}
+}
+
+func TestFromHistory(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []time.Duration
+		want     time.Duration
+		wantRuns int
+	}{
+		{
+			name:     "no history",
+			history:  nil,
+			want:     0,
+			wantRuns: 0,
+		},
+		{
+			name:     "fewer than HistoryRuns",
+			history:  []time.Duration{time.Minute, 3 * time.Minute},
+			want:     2 * time.Minute,
+			wantRuns: 2,
+		},
+		{
+			name: "only most recent HistoryRuns",
+			history: []time.Duration{
+				time.Hour,
+				time.Minute, time.Minute, time.Minute, time.Minute, time.Minute,
+			},
+			want:     time.Minute,
+			wantRuns: HistoryRuns,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, gotRuns := FromHistory(test.history)
+			if got != test.want || gotRuns != test.wantRuns {
+				t.Errorf("FromHistory returned (%v, %d), want: (%v, %d)", got, gotRuns, test.want, test.wantRuns)
+			}
+		})
+	}
+}
+
+func TestFromThroughput(t *testing.T) {
+	tests := []struct {
+		name     string
+		history  []Clone
+		bytes    uint64
+		want     time.Duration
+		wantRuns int
+	}{
+		{
+			name:     "no history",
+			history:  nil,
+			bytes:    1000,
+			want:     0,
+			wantRuns: 0,
+		},
+		{
+			name: "unknown bytes skipped",
+			history: []Clone{
+				{Bytes: 1000, Duration: time.Second},
+				{Bytes: 0, Duration: time.Hour},
+				{Bytes: 3000, Duration: time.Second},
+			},
+			bytes:    6000,
+			want:     3 * time.Second,
+			wantRuns: 2,
+		},
+		{
+			name: "only most recent HistoryRuns",
+			history: []Clone{
+				{Bytes: 1, Duration: time.Hour},
+				{Bytes: 1000, Duration: time.Second},
+				{Bytes: 1000, Duration: time.Second},
+				{Bytes: 1000, Duration: time.Second},
+				{Bytes: 1000, Duration: time.Second},
+				{Bytes: 1000, Duration: time.Second},
+			},
+			bytes:    60000,
+			want:     time.Minute,
+			wantRuns: HistoryRuns,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, gotRuns := FromThroughput(test.bytes, test.history)
+			if got != test.want || gotRuns != test.wantRuns {
+				t.Errorf("FromThroughput returned (%v, %d), want: (%v, %d)", got, gotRuns, test.want, test.wantRuns)
+			}
+		})
+	}
+}
+
+func TestGrowthRate(t *testing.T) {
+	day := func(n int) time.Time {
+		return time.Date(2021, 3, 1+n, 0, 0, 0, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		usage  []Usage
+		want   float64
+		wantOK bool
+	}{
+		{
+			name:   "no usage",
+			usage:  nil,
+			wantOK: false,
+		},
+		{
+			name:   "single sample",
+			usage:  []Usage{{At: day(0), Bytes: 100}},
+			wantOK: false,
+		},
+		{
+			name:   "no growth",
+			usage:  []Usage{{At: day(0), Bytes: 100}, {At: day(2), Bytes: 100}},
+			wantOK: false,
+		},
+		{
+			name: "growth between first and last",
+			usage: []Usage{
+				{At: day(0), Bytes: 100},
+				{At: day(1), Bytes: 1000},
+				{At: day(4), Bytes: 500},
+			},
+			want:   100,
+			wantOK: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, gotOK := GrowthRate(test.usage)
+			if got != test.want || gotOK != test.wantOK {
+				t.Errorf("GrowthRate returned (%v, %t), want: (%v, %t)", got, gotOK, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestExhausted(t *testing.T) {
+	now := time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	want := time.Date(2021, 3, 11, 12, 0, 0, 0, time.UTC)
+	if got := Exhausted(1050, 100, now); !got.Equal(want) {
+		t.Errorf("Exhausted(1050, 100, %v) = %v, want: %v", now, got, want)
+	}
+}
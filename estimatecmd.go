@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/estimate"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// estimateCommand prints about how much a clone would copy, and how long it
+// would take, without cloning.
+func estimateCommand(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("estimate", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources, and the durations of previous clones.`)
+	fs.StringVar(configPath, "config", config.DefaultPath, `Path to the JSON configuration file configuring how sizes and times are formatted.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s estimate [-state <path>] [-config <path>] <source volume> <target volume>
+
+Prints about how much cloning <source volume> to <target volume> would copy,
+and how long it would take, based on the throughput of previous clones to
+target, without cloning, e.g. to decide whether there is time to clone before
+leaving with target. Nothing is modified.
+
+  <target volume>
+    	May be the name of a paired target, mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <source volume> and one <target volume> are required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	du := newDiskUtil()
+	sourceInfo, err := du.Info(ctx, fs.Arg(0))
+	if err != nil {
+		return usageErrorf("invalid source volume: %v", err)
+	}
+	targetInfo, err := resolveTarget(ctx, *statePath, du, fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	f, err := loadFormatter(*configPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Cloning %q (%s) to %q (%s):\n", sourceInfo.Name, sourceInfo.UUID, targetInfo.Name, targetInfo.UUID)
+	// asr is nil, as preflight checks never restore.
+	c := cloner.New(du, nil, cloner.Stdout(io.Discard))
+	if p, err := c.Preflight(ctx, sourceInfo.UUID, targetInfo.UUID); err != nil {
+		fmt.Printf("\tWarning: the clone would fail: %v\n", err)
+		printExplanation(os.Stdout, err)
+	} else {
+		t := p.Targets[0]
+		fmt.Printf("\tIncrementally restores target from snapshot %s, %d source snapshot(s) behind.\n", t.Common.Name, len(p.SourceSnaps.After(t.Common.UUID)))
+	}
+	bytes := cloner.CloneBytes(sourceInfo, targetInfo)
+	fmt.Printf("\tCopies about %s (source uses %s, target uses %s).\n", f.Bytes(bytes), f.Bytes(sourceInfo.UsedBytes), f.Bytes(targetInfo.UsedBytes))
+
+	history := st.History(targetInfo.UUID)
+	clones := cloneHistory(history)
+	if d, runs := estimate.FromThroughput(bytes, clones); runs > 0 {
+		throughput, _ := estimate.Throughput(clones)
+		fmt.Printf("\tTakes about %s, at %s/s, the throughput of the last %d clone(s).\n", d.Round(time.Minute), f.Bytes(uint64(throughput)), runs)
+		return nil
+	}
+	var durations []time.Duration
+	for _, e := range history {
+		durations = append(durations, e.Duration)
+	}
+	if d, runs := estimate.FromHistory(durations); runs > 0 {
+		fmt.Printf("\tTakes about %s, the average duration of the last %d clone(s).\n", d.Round(time.Minute), runs)
+		return nil
+	}
+	fmt.Println("\tTarget has not been cloned to, so the duration cannot be estimated.")
+	return nil
+}
+
+// cloneHistory returns the previous clones to a target, oldest first, given
+// its catalog entries. As the bytes a clone copied are not recorded, they are
+// estimated as the growth of source's used space since the previous clone, as
+// by cloner.CloneBytes, and are unknown for the first clone.
+func cloneHistory(entries []state.CatalogEntry) []estimate.Clone {
+	var clones []estimate.Clone
+	for i, e := range entries {
+		c := estimate.Clone{Duration: e.Duration}
+		if i > 0 {
+			prev := entries[i-1]
+			if prev.SourceUUID == e.SourceUUID && prev.SourceUsedBytes > 0 && e.SourceUsedBytes > prev.SourceUsedBytes {
+				c.Bytes = e.SourceUsedBytes - prev.SourceUsedBytes
+			}
+		}
+		clones = append(clones, c)
+	}
+	return clones
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/localauth"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+)
+
+// Exit codes, so that wrapper scripts and launchd jobs can react differently
+// to each class of failure. Failures of other classes exit with exitFailure.
+const (
+	exitFailure = 1
+	// exitUsage means the arguments or flags are invalid.
+	exitUsage = 2
+	// exitDeferred means the run exceeded -max-runtime before cloning to
+	// all targets, but did not otherwise fail.
+	exitDeferred = 3
+	// exitPreflight means preflight checks failed, e.g. because source and
+	// a target have no snapshot in common, so nothing was cloned.
+	exitPreflight = 4
+	// exitRestore means asr failed to restore each target that failed.
+	exitRestore = 5
+	// exitPartial means cloning to some targets failed, and to others
+	// succeeded.
+	exitPartial = 6
+	// exitDeclined means the user declined to confirm, so nothing was
+	// modified.
+	exitDeclined = 7
+)
+
+// Exit codes of -launchd runs, from sysexits.h. Outside of -launchd mode,
+// they are reported as exitUsage and exitFailure respectively.
+const (
+	// exitTempFail means the run could not start, e.g. because source is
+	// not attached, or another invocation holds a lock, and may succeed the
+	// next time the job runs.
+	exitTempFail = 75
+	// exitConfig means the job is misconfigured, e.g. with invalid flags,
+	// and fails every time it runs until it is reinstalled.
+	exitConfig = 78
+)
+
+// exitCode returns code in -launchd mode, and its equivalent outside of
+// -launchd mode otherwise.
+func exitCode(code int) int {
+	return launchdExitCode(code, *launchdMode)
+}
+
+func launchdExitCode(code int, launchd bool) int {
+	if launchd {
+		return code
+	}
+	switch code {
+	case exitConfig:
+		return exitUsage
+	case exitTempFail:
+		return exitFailure
+	}
+	return code
+}
+
+// preflightErrs are the errors of preflight checks that may also be returned
+// by clones that skip preflight checks, e.g. with -only.
+var preflightErrs = []error{
+	cloner.ErrNoCommonSnapshot,
+	cloner.ErrTargetHasSnapshots,
+	cloner.ErrSnapshotTooOld,
+	cloner.ErrNoSourceSnapshots,
+	cloner.ErrBelowReserve,
+	cloner.ErrInsufficientSpace,
+	cloner.ErrSourceLocked,
+	asr.ErrUnsupported,
+}
+
+// cloneExitCode returns the exit code of a run that cloned to succeeded
+// targets, deferred others to the next run, and failed to clone to the targets
+// in errs, a map of target to its clone error.
+func cloneExitCode(errs map[string]error, succeeded, deferred int) int {
+	switch {
+	case len(errs) == 0 && deferred > 0:
+		return exitDeferred
+	case len(errs) == 0:
+		return 0
+	case succeeded > 0:
+		return exitPartial
+	}
+	// Every target attempted failed, so the run exits with the class of
+	// their failures, whether or not any targets were deferred.
+	code := 0
+	for _, err := range errs {
+		c := exitFailure
+		var restoreErr *asr.RestoreError
+		if errors.As(err, &restoreErr) {
+			c = exitRestore
+		}
+		for _, preflightErr := range preflightErrs {
+			if errors.Is(err, preflightErr) {
+				c = exitPreflight
+			}
+		}
+		if code != 0 && c != code {
+			return exitFailure
+		}
+		code = c
+	}
+	return code
+}
+
+// errDeclined is returned when the user declines to confirm at a prompt.
+var errDeclined = errors.New("confirmation rejected")
+
+// usageError is an error caused by a command's invalid arguments or flags.
+type usageError struct {
+	err error
+}
+
+// usageErrorf returns a usageError formatted like fmt.Errorf.
+func usageErrorf(format string, a ...interface{}) error {
+	return usageError{fmt.Errorf(format, a...)}
+}
+
+func (err usageError) Error() string {
+	return err.err.Error()
+}
+
+func (err usageError) Unwrap() error {
+	return err.err
+}
+
+// configError is an error caused by a misconfigured run, e.g. a target the
+// policy file does not allow.
+type configError struct {
+	err error
+}
+
+func (err configError) Error() string {
+	return err.err.Error()
+}
+
+func (err configError) Unwrap() error {
+	return err.err
+}
+
+// errExitCode returns the exit code of a run that failed with err, by err's
+// class: usageErrors exit with exitUsage, declined confirmations with
+// exitDeclined, and configErrors and locks held by other invocations with
+// exitConfig and exitTempFail, as reported by exitCode.
+func errExitCode(err error) int {
+	var usageErr usageError
+	var configErr configError
+	var heldErr *lock.HeldError
+	switch {
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.As(err, &configErr):
+		return exitCode(exitConfig)
+	case errors.Is(err, errDeclined), errors.Is(err, localauth.ErrRejected):
+		return exitDeclined
+	case errors.As(err, &heldErr):
+		return exitCode(exitTempFail)
+	}
+	return exitFailure
+}
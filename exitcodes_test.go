@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/localauth"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+)
+
+func TestCloneExitCode(t *testing.T) {
+	restoreErr := fmt.Errorf("error restoring: %w", &asr.RestoreError{})
+	preflightErr := fmt.Errorf("preflight failed: %w", cloner.ErrNoCommonSnapshot)
+	otherErr := errors.New("other")
+	tests := []struct {
+		name      string
+		errs      map[string]error
+		succeeded int
+		deferred  int
+		want      int
+	}{
+		{
+			name:      "all succeeded",
+			succeeded: 2,
+			want:      0,
+		},
+		{
+			name:      "deferred",
+			succeeded: 1,
+			deferred:  1,
+			want:      exitDeferred,
+		},
+		{
+			name:      "partial",
+			errs:      map[string]error{"a": restoreErr},
+			succeeded: 1,
+			want:      exitPartial,
+		},
+		{
+			name:      "partial with deferred",
+			errs:      map[string]error{"a": restoreErr},
+			succeeded: 1,
+			deferred:  1,
+			want:      exitPartial,
+		},
+		{
+			name: "all restores failed",
+			errs: map[string]error{"a": restoreErr, "b": restoreErr},
+			want: exitRestore,
+		},
+		{
+			name:     "attempted restores failed and rest deferred",
+			errs:     map[string]error{"a": restoreErr},
+			deferred: 1,
+			want:     exitRestore,
+		},
+		{
+			name: "all preflight failed",
+			errs: map[string]error{"a": preflightErr},
+			want: exitPreflight,
+		},
+		{
+			name: "other failure",
+			errs: map[string]error{"a": otherErr},
+			want: exitFailure,
+		},
+		{
+			name: "mixed failures",
+			errs: map[string]error{"a": restoreErr, "b": preflightErr},
+			want: exitFailure,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := cloneExitCode(test.errs, test.succeeded, test.deferred); got != test.want {
+				t.Errorf("cloneExitCode(%v, %d, %d) = %d, want: %d", test.errs, test.succeeded, test.deferred, got, test.want)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		code    int
+		launchd bool
+		want    int
+	}{
+		{code: exitConfig, launchd: true, want: exitConfig},
+		{code: exitConfig, launchd: false, want: exitUsage},
+		{code: exitTempFail, launchd: true, want: exitTempFail},
+		{code: exitTempFail, launchd: false, want: exitFailure},
+		{code: exitPreflight, launchd: true, want: exitPreflight},
+		{code: exitPreflight, launchd: false, want: exitPreflight},
+	}
+	for _, test := range tests {
+		if got := launchdExitCode(test.code, test.launchd); got != test.want {
+			t.Errorf("launchdExitCode(%d, %t) = %d, want: %d", test.code, test.launchd, got, test.want)
+		}
+	}
+}
+
+func TestErrExitCode(t *testing.T) {
+	heldErr := fmt.Errorf("error acquiring lock: %w", &lock.HeldError{Name: "global", PID: 123})
+	tests := []struct {
+		name    string
+		err     error
+		launchd bool
+		want    int
+	}{
+		{
+			name: "usage",
+			err:  usageErrorf("unknown error code %q", "foo"),
+			want: exitUsage,
+		},
+		{
+			name: "declined",
+			err:  errDeclined,
+			want: exitDeclined,
+		},
+		{
+			name: "Touch ID rejected",
+			err:  fmt.Errorf("confirmation rejected: %w", localauth.ErrRejected),
+			want: exitDeclined,
+		},
+		{
+			name:    "lock held by launchd job",
+			err:     heldErr,
+			launchd: true,
+			want:    exitTempFail,
+		},
+		{
+			name: "lock held",
+			err:  heldErr,
+			want: exitFailure,
+		},
+		{
+			name: "other",
+			err:  errors.New("other"),
+			want: exitFailure,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func(launchd bool) {
+				*launchdMode = launchd
+			}(*launchdMode)
+			*launchdMode = test.launchd
+			if got := errExitCode(test.err); got != test.want {
+				t.Errorf("errExitCode(%v) = %d, want: %d", test.err, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/explain"
+)
+
+// explainCode prints the explanation of an error code, or lists all error
+// codes.
+func explainCode(args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s explain [<error code>]
+
+Prints what the error identified by <error code> means, its likely causes, and
+how to fix it. Error codes are printed with errors. Without <error code>, lists
+all error codes.
+`, os.Args[0])
+	}
+	fs.Parse(args)
+	switch fs.NArg() {
+	case 0:
+		for _, code := range explain.Codes() {
+			e, _ := explain.Lookup(code)
+			fmt.Printf("%s\n\t%s\n", code, e.Summary)
+		}
+		return nil
+	case 1:
+		e, ok := explain.Lookup(fs.Arg(0))
+		if !ok {
+			return usageErrorf("unknown error code %q, want one of: %s", fs.Arg(0), strings.Join(explain.Codes(), ", "))
+		}
+		fmt.Print(e)
+		return nil
+	}
+	fmt.Fprintln(fs.Output(), "Error: at most one <error code> is allowed")
+	fs.Usage()
+	os.Exit(exitUsage)
+	return nil
+}
+
+// jsonError is the error that failed a run, as printed by -json-errors.
+type jsonError struct {
+	jsonTargetError
+	// Errors are the errors of each target, if the run failed because
+	// clones to several targets failed.
+	Errors []jsonTargetError `json:"errors,omitempty"`
+}
+
+// jsonTargetError is an error, and the target volume it applies to, if any.
+type jsonTargetError struct {
+	// Code is the error code, if the error is a known kind of error. See
+	// the explain command.
+	Code    string `json:"code,omitempty"`
+	Message string `json:"message"`
+	Target  string `json:"target,omitempty"`
+	// Remediation are the steps to fix the error, in order, if it is a
+	// known kind of error.
+	Remediation []string `json:"remediation,omitempty"`
+}
+
+func newJSONTargetError(err error, target string) jsonTargetError {
+	e := jsonTargetError{
+		Message: err.Error(),
+		Target:  target,
+	}
+	if x, ok := explain.Classify(err); ok {
+		e.Code = x.Code
+		e.Remediation = x.Remediation
+	}
+	return e
+}
+
+// printJSONError prints err, the error that failed the run, as JSON on stderr
+// if -json-errors is true. target is the target err applies to, if any.
+func printJSONError(err error, target string) {
+	if *jsonErrors {
+		writeJSONError(os.Stderr, jsonError{jsonTargetError: newJSONTargetError(err, target)})
+	}
+}
+
+// printJSONTargetErrors prints the error that failed a run whose clones to
+// several targets failed, as JSON on stderr if -json-errors is true. errs maps
+// each failed target to its error.
+func printJSONTargetErrors(message string, errs map[string]error) {
+	if *jsonErrors {
+		writeJSONError(os.Stderr, newJSONTargetErrors(message, errs))
+	}
+}
+
+// newJSONTargetErrors returns the error of a run whose clones to the targets
+// in errs failed, with message, or the error of the target if only one failed.
+func newJSONTargetErrors(message string, errs map[string]error) jsonError {
+	var targets []string
+	for t := range errs {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	e := jsonError{jsonTargetError: jsonTargetError{Message: message}}
+	for _, t := range targets {
+		e.Errors = append(e.Errors, newJSONTargetError(errs[t], t))
+	}
+	// A single failed target is the run's error.
+	if len(e.Errors) == 1 {
+		e = jsonError{jsonTargetError: e.Errors[0]}
+	}
+	return e
+}
+
+func writeJSONError(w io.Writer, e jsonError) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%s\n", data)
+}
+
+// printExplanation prints the explanation of err if -explain is true, and
+// otherwise how to look it up. Nothing is printed for errors without an
+// explanation.
+func printExplanation(w io.Writer, err error) {
+	e, ok := explain.Classify(err)
+	if !ok {
+		return
+	}
+	if *explainErrors {
+		fmt.Fprint(w, e)
+		return
+	}
+	fmt.Fprintf(w, "Run `%s explain %s` for likely causes and remediation.\n", os.Args[0], e.Code)
+}
@@ -0,0 +1,328 @@
+// Package explain describes errors in depth: what they mean, their likely
+// causes, and how to fix them. Each kind of error has a short code, which is
+// printed with the error, so that users can look up its explanation.
+package explain
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/history"
+	"github.com/voidingwarranties/offsite-apfs-backup/localauth"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// Explanation describes a kind of error.
+type Explanation struct {
+	// Code identifies the kind of error, e.g. no-common-snapshot.
+	Code    string
+	Summary string
+	Causes  []string
+	// Remediation are the steps to fix the error, in order.
+	Remediation []string
+	// matches returns true if err is this kind of error.
+	matches func(err error) bool
+}
+
+func (e Explanation) String() string {
+	b := new(strings.Builder)
+	fmt.Fprintf(b, "%s: %s\n", e.Code, e.Summary)
+	fmt.Fprintln(b, "\nLikely causes:")
+	for _, c := range e.Causes {
+		fmt.Fprintf(b, "  - %s\n", c)
+	}
+	fmt.Fprintln(b, "\nRemediation:")
+	for i, r := range e.Remediation {
+		fmt.Fprintf(b, "  %d. %s\n", i+1, r)
+	}
+	return b.String()
+}
+
+func is(target error) func(error) bool {
+	return func(err error) bool {
+		return errors.Is(err, target)
+	}
+}
+
+var explanations = []Explanation{
+	{
+		Code:    "no-common-snapshot",
+		Summary: "Source and target have no snapshots in common, so target cannot be incrementally cloned to.",
+		Causes: []string{
+			"The target was never initialized from this source.",
+			"The snapshot last cloned to the target was deleted from source, e.g. by Time Machine or another backup utility's retention policy.",
+			"The source disk was replaced, and the new source was not restored from the old one with asr.",
+		},
+		Remediation: []string{
+			"Check that the source and target are the intended volumes.",
+			"If the source disk was replaced, run migrate-source to re-pair its targets.",
+			"Re-initialize the target with -initialize, which erases all data on it.",
+		},
+		matches: is(cloner.ErrNoCommonSnapshot),
+	},
+	{
+		Code:    "snapshot-too-old",
+		Summary: "The latest source snapshot allowed by the backup set is older than its max_snapshot_age.",
+		Causes: []string{
+			"The tool that creates the set's snapshots, e.g. a Carbon Copy Cloner task, has not run recently, or is failing.",
+			"The tool's snapshot names no longer start with the set's snapshot_creators, e.g. because its task was recreated with a new UUID.",
+		},
+		Remediation: []string{
+			"Run the tool to create a new snapshot, and check that its snapshots are listed by `diskutil apfs listsnapshots`.",
+			"If the tool's snapshot names changed, update snapshot_creators in the config file.",
+		},
+		matches: is(cloner.ErrSnapshotTooOld),
+	},
+	{
+		Code:    "prune-skipped",
+		Summary: "A target was restored, but could not be verified, so its snapshot in common with source was kept instead of pruned.",
+		Causes: []string{
+			"The target was disconnected or modified during or after the restore.",
+			"The restore did not complete, e.g. because asr exited early without an error.",
+		},
+		Remediation: []string{
+			"Read the verification failure in the error for the specific cause.",
+			"Verify the target with -only verify. If it passes, prune it with -only prune.",
+			"Otherwise, retry the clone. The kept snapshot in common is still usable to restore the target.",
+		},
+		matches: is(cloner.ErrPruneSkipped),
+	},
+	{
+		Code:    "below-reserve",
+		Summary: "Cloning would likely leave the target's APFS container with less free space than the backup set's reserve_percent.",
+		Causes: []string{
+			"Source grew since the target was last cloned to, e.g. by adding large files.",
+			"The target keeps many old snapshots, e.g. because its set has no prune or min_keep is high.",
+			"The target's container holds other volumes that use its free space.",
+		},
+		Remediation: []string{
+			"Prune old snapshots from the target, e.g. with -only prune -keep <n>, then retry.",
+			"Lower the set's reserve_percent, if the reserve is larger than needed.",
+			"Replace the target with a larger disk.",
+		},
+		matches: is(cloner.ErrBelowReserve),
+	},
+	{
+		Code:    "source-locked",
+		Summary: "The source is an encrypted APFS volume that is locked, so its snapshots cannot be read.",
+		Causes: []string{
+			"The source is a FileVault volume whose users have not logged in since it was attached or the Mac started.",
+			"The source is an encrypted external volume that was attached without being unlocked.",
+			"The source's passphrase is not in the keychain, and the clone ran without a terminal to prompt for it, e.g. as a launchd job.",
+		},
+		Remediation: []string{
+			"Unlock the source, e.g. by logging in or with `diskutil apfs unlockVolume <device>`, then retry.",
+			"Store the source's passphrase in the keychain (see README), so that clones can unlock it.",
+		},
+		matches: is(cloner.ErrSourceLocked),
+	},
+	{
+		Code:    "insufficient-space",
+		Summary: "The target's APFS container likely does not have enough free space for the clone, so it was not started.",
+		Causes: []string{
+			"Source grew since the target was last cloned to, e.g. by adding large files.",
+			"The target keeps many old snapshots, whose data cannot be freed while they exist.",
+			"The target's container holds other volumes that use its free space.",
+		},
+		Remediation: []string{
+			"Prune old snapshots from the target, e.g. with -only prune -keep <n>, then retry.",
+			"Delete other volumes from the target's container, if they are not needed.",
+			"Replace the target with a larger disk.",
+		},
+		matches: is(cloner.ErrInsufficientSpace),
+	},
+	{
+		Code:    "asr-unsupported",
+		Summary: "asr cannot restore APFS snapshots on this Mac, so the clone was not started.",
+		Causes: []string{
+			"macOS is too old for its asr to support --toSnapshot and --fromSnapshot.",
+			"asr is missing from PATH, e.g. because the clone ran with a restricted environment.",
+		},
+		Remediation: []string{
+			"Update macOS, then retry.",
+			"Run the clone with /usr/sbin in PATH.",
+		},
+		matches: is(asr.ErrUnsupported),
+	},
+	{
+		Code:    "target-has-snapshots",
+		Summary: "A target to be initialized already has snapshots.",
+		Causes: []string{
+			"-initialize was given for a target that was already initialized, or that is in use for something else.",
+		},
+		Remediation: []string{
+			"Check that the target is the intended volume.",
+			"If the target has been initialized before, clone to it without -initialize.",
+			"Otherwise, erase the target with Disk Utility, then initialize it.",
+		},
+		matches: is(cloner.ErrTargetHasSnapshots),
+	},
+	{
+		Code:    "invalid-snapshot-list",
+		Summary: "diskutil listed a volume's snapshots ambiguously, e.g. two snapshots with the same name, or out of order of the timestamps in their names.",
+		Causes: []string{
+			"A snapshot was created with a timestamp in its name that does not match when it was created, e.g. by a tool with a misconfigured clock or time zone.",
+			"The volume's file system is damaged.",
+		},
+		Remediation: []string{
+			"List the volume's snapshots with `diskutil apfs listsnapshots`, and compare them to the error.",
+			"Run First Aid on the volume in Disk Utility.",
+			"Delete the offending snapshots with `diskutil apfs deletesnapshot`, if they are not needed.",
+		},
+		matches: func(err error) bool {
+			return errors.Is(err, diskutil.ErrSnapshotOrder) || errors.Is(err, diskutil.ErrDuplicateSnapshot)
+		},
+	},
+	{
+		Code:    "history-diverged",
+		Summary: "The target's snapshots changed since it was last cloned to, by something other than this utility.",
+		Causes: []string{
+			"Snapshots were deleted from the target while it was off-site.",
+			"The target was used by another backup utility, or was restored by hand.",
+		},
+		Remediation: []string{
+			"Compare the missing and unexpected snapshots in the error to the target's snapshots, listed with `diskutil apfs listsnapshots`.",
+			"If the changes are expected, re-initialize the target with -initialize.",
+			"Otherwise, treat the target's data as suspect, and investigate before cloning to it again.",
+		},
+		matches: func(err error) bool {
+			var diverged *history.DivergedError
+			return errors.As(err, &diverged)
+		},
+	},
+	{
+		Code:    "lock-held",
+		Summary: "Another invocation is using the same targets.",
+		Causes: []string{
+			"A scheduled or concurrently started clone is in progress.",
+		},
+		Remediation: []string{
+			"Wait for the other invocation to finish, or pass -wait to wait for it automatically.",
+			"Check the process ID in the error with `ps -p <pid>` to see what is running.",
+		},
+		matches: func(err error) bool {
+			var held *lock.HeldError
+			return errors.As(err, &held)
+		},
+	},
+	{
+		Code:    "device-io-error",
+		Summary: "asr failed with device I/O errors while restoring the target.",
+		Causes: []string{
+			"A loose or faulty cable, hub, or enclosure.",
+			"A failing disk, if the errors are reported as \"suspect hardware\".",
+		},
+		Remediation: []string{
+			"Reconnect the disk directly to the computer, and retry the clone.",
+			"If the failure is reported as \"suspect hardware\", replace the disk and retire the target.",
+		},
+		matches: func(err error) bool {
+			var restoreErr *asr.RestoreError
+			return errors.As(err, &restoreErr) && len(restoreErr.IOErrors) > 0
+		},
+	},
+	{
+		Code:    "restore-failed",
+		Summary: "asr failed to restore the target.",
+		Causes: []string{
+			"The target was unmounted or disconnected during the restore.",
+			"The target ran out of space.",
+			"The process does not have Full Disk Access, or is not run as root.",
+		},
+		Remediation: []string{
+			"Read asr's stderr in the error for the specific cause.",
+			"Run with sudo, from a terminal with Full Disk Access.",
+			"Retry the clone. Interrupted restores are safe to retry.",
+		},
+		matches: func(err error) bool {
+			var restoreErr *asr.RestoreError
+			return errors.As(err, &restoreErr)
+		},
+	},
+	{
+		Code:    "state-newer-format",
+		Summary: "The state file was written by a newer version of this utility.",
+		Causes: []string{
+			"A newer version was run, e.g. from a different checkout or release, and upgraded the state file's format.",
+		},
+		Remediation: []string{
+			"Run the newer version, or upgrade to it.",
+			"Pass a different state file with -state.",
+		},
+		matches: is(state.ErrNewerFormat),
+	},
+	{
+		Code:    "history-newer-format",
+		Summary: "A target's history was written by a newer version of this utility.",
+		Causes: []string{
+			"The target was last cloned to by a newer version, e.g. on another Mac.",
+		},
+		Remediation: []string{
+			"Clone to the target with the newer version, or upgrade to it.",
+		},
+		matches: is(history.ErrNewerFormat),
+	},
+	{
+		Code:    "touch-id-unavailable",
+		Summary: "-touch-id was given, but Touch ID is unavailable.",
+		Causes: []string{
+			"The computer has no Touch ID sensor, or it is disabled, e.g. with the lid closed.",
+			"The session is remote, e.g. over SSH.",
+		},
+		Remediation: []string{
+			"Confirm by typing at the prompt instead, by omitting -touch-id.",
+		},
+		matches: is(localauth.ErrUnavailable),
+	},
+	{
+		Code:    "touch-id-rejected",
+		Summary: "Touch ID confirmation was cancelled or failed.",
+		Causes: []string{
+			"The confirmation was cancelled, or the fingerprint was not recognized.",
+		},
+		Remediation: []string{
+			"Retry, and confirm with an enrolled finger.",
+		},
+		matches: is(localauth.ErrRejected),
+	},
+}
+
+// Lookup returns the explanation of the kind of error identified by code.
+func Lookup(code string) (Explanation, bool) {
+	for _, e := range explanations {
+		if e.Code == code {
+			return e, true
+		}
+	}
+	return Explanation{}, false
+}
+
+// Codes returns the codes of all kinds of errors, sorted.
+func Codes() []string {
+	var codes []string
+	for _, e := range explanations {
+		codes = append(codes, e.Code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Classify returns the explanation of err. ok is false if err is not a kind
+// of error with an explanation.
+func Classify(err error) (e Explanation, ok bool) {
+	if err == nil {
+		return Explanation{}, false
+	}
+	for _, e := range explanations {
+		if e.matches(err) {
+			return e, true
+		}
+	}
+	return Explanation{}, false
+}
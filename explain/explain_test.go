@@ -0,0 +1,133 @@
+package explain
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/history"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{
+			name: "wrapped sentinel",
+			err:  fmt.Errorf("error finding latest snapshot in common between source and target: %w", cloner.ErrNoCommonSnapshot),
+			want: "no-common-snapshot",
+		},
+		{
+			name: "snapshot too old",
+			err:  fmt.Errorf("%w: snap was created 72h0m0s ago", cloner.ErrSnapshotTooOld),
+			want: "snapshot-too-old",
+		},
+		{
+			name: "prune skipped",
+			err:  fmt.Errorf("%w: verification failed: latest snapshot in target is snap-1, want snap-2", cloner.ErrPruneSkipped),
+			want: "prune-skipped",
+		},
+		{
+			name: "below reserve",
+			err:  fmt.Errorf("%w: target has 10 GB free", cloner.ErrBelowReserve),
+			want: "below-reserve",
+		},
+		{
+			name: "source locked",
+			err:  fmt.Errorf("%w: unlock \"source\"", cloner.ErrSourceLocked),
+			want: "source-locked",
+		},
+		{
+			name: "asr unsupported",
+			err:  fmt.Errorf("%w: asr 2.0 does not support --toSnapshot", asr.ErrUnsupported),
+			want: "asr-unsupported",
+		},
+		{
+			name: "insufficient space",
+			err:  fmt.Errorf("%w: target has 10 GB free", cloner.ErrInsufficientSpace),
+			want: "insufficient-space",
+		},
+		{
+			name: "duplicate snapshot",
+			err:  fmt.Errorf("error listing snapshots of target: %w", fmt.Errorf("`diskutil apfs listsnapshots` returned %w: snap", diskutil.ErrDuplicateSnapshot)),
+			want: "invalid-snapshot-list",
+		},
+		{
+			name: "newer state format",
+			err:  fmt.Errorf("error migrating state file %q: %w", "state.json", state.ErrNewerFormat),
+			want: "state-newer-format",
+		},
+		{
+			name: "newer history format",
+			err:  fmt.Errorf("error reading history of target: %w", history.ErrNewerFormat),
+			want: "history-newer-format",
+		},
+		{
+			name: "wrapped type",
+			err:  fmt.Errorf("verification failed: %w", &history.DivergedError{Missing: []string{"snap-uuid"}}),
+			want: "history-diverged",
+		},
+		{
+			name: "lock held",
+			err:  fmt.Errorf("error acquiring lock: %w", &lock.HeldError{Name: "global", PID: 42}),
+			want: "lock-held",
+		},
+		{
+			name: "restore with I/O errors",
+			err: &asr.RestoreError{
+				Err:      &exec.ExitError{},
+				IOErrors: []asr.IOError{{Line: "Input/output error", Offset: -1}},
+			},
+			want: "device-io-error",
+		},
+		{
+			name: "restore without I/O errors",
+			err:  &asr.RestoreError{Err: &exec.ExitError{}},
+			want: "restore-failed",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := Classify(test.err)
+			if !ok || got.Code != test.want {
+				t.Errorf("Classify(%v) returned (%q, %t), want: (%q, true)", test.err, got.Code, ok, test.want)
+			}
+		})
+	}
+}
+
+func TestClassify_Unknown(t *testing.T) {
+	for _, err := range []error{nil, errors.New("unknown error")} {
+		if got, ok := Classify(err); ok {
+			t.Errorf("Classify(%v) returned (%q, true), want: (_, false)", err, got.Code)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	for _, code := range Codes() {
+		e, ok := Lookup(code)
+		if !ok {
+			t.Errorf("Lookup(%q) returned ok: false, want: true", code)
+			continue
+		}
+		if e.Summary == "" || len(e.Causes) == 0 || len(e.Remediation) == 0 {
+			t.Errorf("Lookup(%q) returned incomplete explanation: %+v", code, e)
+		}
+		if s := e.String(); !strings.HasPrefix(s, code+": ") {
+			t.Errorf("Explanation.String() = %q, want prefix %q", s, code+": ")
+		}
+	}
+	if _, ok := Lookup("nonexistent"); ok {
+		t.Error("Lookup(nonexistent) returned ok: true, want: false")
+	}
+}
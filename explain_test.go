@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+func TestWriteJSONError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  jsonError
+		want string
+	}{
+		{
+			name: "unknown error",
+			err:  jsonError{jsonTargetError: newJSONTargetError(errors.New("something failed"), "")},
+			want: `{"message":"something failed"}` + "\n",
+		},
+		{
+			name: "known error",
+			err:  jsonError{jsonTargetError: newJSONTargetError(fmt.Errorf("%w: verification failed", cloner.ErrPruneSkipped), "")},
+			want: `{"code":"prune-skipped","message":"target could not be verified, so its common snapshot was not pruned: verification failed","remediation":["Read the verification failure in the error for the specific cause.","Verify the target with -only verify. If it passes, prune it with -only prune.","Otherwise, retry the clone. The kept snapshot in common is still usable to restore the target."]}` + "\n",
+		},
+		{
+			name: "target error",
+			err:  jsonError{jsonTargetError: newJSONTargetError(fmt.Errorf("%w: verification failed", cloner.ErrPruneSkipped), "/Volumes/target-1")},
+			want: `{"code":"prune-skipped","message":"target could not be verified, so its common snapshot was not pruned: verification failed","target":"/Volumes/target-1","remediation":["Read the verification failure in the error for the specific cause.","Verify the target with -only verify. If it passes, prune it with -only prune.","Otherwise, retry the clone. The kept snapshot in common is still usable to restore the target."]}` + "\n",
+		},
+		{
+			name: "one failed target",
+			err: newJSONTargetErrors("failed to clone to 1/2 targets", map[string]error{
+				"/Volumes/target-2": errors.New("asr failed"),
+			}),
+			want: `{"message":"asr failed","target":"/Volumes/target-2"}` + "\n",
+		},
+		{
+			name: "several failed targets",
+			err: newJSONTargetErrors("failed to clone to 2/2 targets", map[string]error{
+				"/Volumes/target-2": errors.New("asr failed"),
+				"/Volumes/target-1": fmt.Errorf("%w: verification failed", cloner.ErrPruneSkipped),
+			}),
+			want: `{"message":"failed to clone to 2/2 targets","errors":[` +
+				`{"code":"prune-skipped","message":"target could not be verified, so its common snapshot was not pruned: verification failed","target":"/Volumes/target-1","remediation":["Read the verification failure in the error for the specific cause.","Verify the target with -only verify. If it passes, prune it with -only prune.","Otherwise, retry the clone. The kept snapshot in common is still usable to restore the target."]},` +
+				`{"message":"asr failed","target":"/Volumes/target-2"}` +
+				`]}` + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got bytes.Buffer
+			writeJSONError(&got, test.err)
+			if diff := cmp.Diff(test.want, got.String()); diff != "" {
+				t.Errorf("writeJSONError wrote unexpected JSON. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+// Package fileverify implements verifying that a restore produced identical
+// data, by comparing the files in the same snapshot of a source volume and a
+// target volume. The snapshot is mounted read-only on both volumes with
+// mount_apfs, and the files are compared by checksum.
+package fileverify
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/checksum"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Result is the result of comparing files in source and target.
+type Result struct {
+	// Snapshot is the snapshot the files were compared in.
+	Snapshot diskutil.Snapshot
+	// Compared is the number of files in both source and target.
+	Compared int
+	// Mismatched are the files whose contents differ, Missing the files
+	// only in source, and Unexpected the files only in target. Files are
+	// relative to the volumes' roots.
+	Mismatched []string
+	Missing    []string
+	Unexpected []string
+}
+
+// OK returns true if the files are identical in source and target.
+func (r Result) OK() bool {
+	return len(r.Mismatched) == 0 && len(r.Missing) == 0 && len(r.Unexpected) == 0
+}
+
+func (r Result) String() string {
+	if r.OK() {
+		return fmt.Sprintf("%d files are identical in snapshot %s", r.Compared, r.Snapshot)
+	}
+	var details []string
+	for _, d := range []struct {
+		desc  string
+		files []string
+	}{
+		{"differ", r.Mismatched},
+		{"are missing from target", r.Missing},
+		{"are only in target", r.Unexpected},
+	} {
+		if len(d.files) > 0 {
+			details = append(details, fmt.Sprintf("%d files %s: %s", len(d.files), d.desc, strings.Join(d.files, ", ")))
+		}
+	}
+	return fmt.Sprintf("files in snapshot %s do not match: %s", r.Snapshot, strings.Join(details, "; "))
+}
+
+// Verifier compares files in source and target volumes.
+type Verifier struct {
+	diskutil    diskutil.DiskUtil
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+	algorithm   checksum.Algorithm
+}
+
+// Option configures Verifier.
+type Option func(*Verifier)
+
+// Algorithm returns an Option that sets the algorithm files are hashed with.
+// Defaults to checksum.XXHash.
+func Algorithm(a checksum.Algorithm) Option {
+	return func(v *Verifier) {
+		v.algorithm = a
+	}
+}
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) Option {
+	return func(v *Verifier) {
+		v.execCommand = f
+	}
+}
+
+// New returns a new Verifier.
+func New(du diskutil.DiskUtil, opts ...Option) Verifier {
+	v := Verifier{
+		diskutil:    du,
+		execCommand: exec.CommandContext,
+		algorithm:   checksum.XXHash,
+	}
+	for _, opt := range opts {
+		opt(&v)
+	}
+	return v
+}
+
+// Verify compares the files at paths, relative to the volumes' roots, in
+// target's latest snapshot, which must also be a snapshot of source. Paths
+// that are directories are compared recursively; "." compares every file.
+// Only regular files are compared.
+func (v Verifier) Verify(ctx context.Context, source, target diskutil.VolumeInfo, paths []string) (result Result, err error) {
+	for _, p := range paths {
+		if filepath.IsAbs(p) || strings.HasPrefix(filepath.Clean(p)+"/", "../") {
+			return Result{}, fmt.Errorf("invalid path %q: must be relative to the volume's root", p)
+		}
+	}
+	targetSnaps, err := v.diskutil.ListSnapshots(ctx, target)
+	if err != nil {
+		return Result{}, fmt.Errorf("error listing snapshots of target: %w", err)
+	}
+	snap, ok := targetSnaps.Latest()
+	if !ok {
+		return Result{}, fmt.Errorf("target %q has no snapshots", target.Name)
+	}
+	sourceSnaps, err := v.diskutil.ListSnapshots(ctx, source)
+	if err != nil {
+		return Result{}, fmt.Errorf("error listing snapshots of source: %w", err)
+	}
+	sourceSnap, ok := sourceSnaps.Find(snap.UUID)
+	if !ok || sourceSnap.UUID != snap.UUID {
+		return Result{}, fmt.Errorf("latest snapshot in target, %s, is not in source", snap)
+	}
+
+	sourceRoot, unmountSource, err := v.mountSnapshot(ctx, source, sourceSnap)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		if unmountErr := unmountSource(); err == nil {
+			err = unmountErr
+		}
+	}()
+	targetRoot, unmountTarget, err := v.mountSnapshot(ctx, target, snap)
+	if err != nil {
+		return Result{}, err
+	}
+	defer func() {
+		if unmountErr := unmountTarget(); err == nil {
+			err = unmountErr
+		}
+	}()
+	result, err = compare(sourceRoot, targetRoot, paths, checksum.NewHasher(v.algorithm))
+	if err != nil {
+		return Result{}, err
+	}
+	result.Snapshot = snap
+	return result, nil
+}
+
+// mountSnapshot mounts snap of volume read-only at a new temporary directory,
+// and returns the directory and a func that unmounts it.
+func (v Verifier) mountSnapshot(ctx context.Context, volume diskutil.VolumeInfo, snap diskutil.Snapshot) (string, func() error, error) {
+	dir, err := os.MkdirTemp("", "offsite-apfs-backup-snapshot-")
+	if err != nil {
+		return "", nil, fmt.Errorf("error creating mount point: %w", err)
+	}
+	cmd := v.execCommand(ctx, "mount_apfs", "-o", "rdonly", "-s", snap.Name, volume.Device, dir)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		os.Remove(dir)
+		return "", nil, fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	unmount := func() error {
+		// Unmount even if ctx is done, so that the snapshot is never
+		// left mounted.
+		cmd := v.execCommand(context.Background(), "umount", dir)
+		stderr := new(bytes.Buffer)
+		cmd.Stderr = stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+		}
+		return os.Remove(dir)
+	}
+	return dir, unmount, nil
+}
+
+// compare compares the files at paths in sourceRoot and targetRoot.
+func compare(sourceRoot, targetRoot string, paths []string, h checksum.Hasher) (Result, error) {
+	sourceFiles, err := listFiles(sourceRoot, paths)
+	if err != nil {
+		return Result{}, fmt.Errorf("error listing files in source: %w", err)
+	}
+	targetFiles, err := listFiles(targetRoot, paths)
+	if err != nil {
+		return Result{}, fmt.Errorf("error listing files in target: %w", err)
+	}
+	var result Result
+	var common []string
+	for _, f := range sourceFiles {
+		if targetFiles.contains(f) {
+			common = append(common, f)
+		} else {
+			result.Missing = append(result.Missing, f)
+		}
+	}
+	for _, f := range targetFiles {
+		if !sourceFiles.contains(f) {
+			result.Unexpected = append(result.Unexpected, f)
+		}
+	}
+
+	sourceSums, err := h.HashFiles(sourceRoot, common)
+	if err != nil {
+		return Result{}, fmt.Errorf("error hashing files in source: %w", err)
+	}
+	targetSums, err := h.HashFiles(targetRoot, common)
+	if err != nil {
+		return Result{}, fmt.Errorf("error hashing files in target: %w", err)
+	}
+	for _, f := range common {
+		if sourceSums[f] != targetSums[f] {
+			result.Mismatched = append(result.Mismatched, f)
+		}
+	}
+	result.Compared = len(common)
+	return result, nil
+}
+
+// fileList is a sorted list of files, relative to a root.
+type fileList []string
+
+func (l fileList) contains(f string) bool {
+	i := sort.SearchStrings(l, f)
+	return i < len(l) && l[i] == f
+}
+
+// listFiles returns the regular files at paths in root, relative to root.
+// Paths that do not exist in root are skipped.
+func listFiles(root string, paths []string) (fileList, error) {
+	seen := make(map[string]bool)
+	var files fileList
+	for _, p := range paths {
+		err := filepath.WalkDir(filepath.Join(root, p), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if !seen[rel] {
+				seen[rel] = true
+				files = append(files, rel)
+			}
+			return nil
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
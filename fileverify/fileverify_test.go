@@ -0,0 +1,247 @@
+package fileverify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/checksum"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+type fakeDiskUtil struct {
+	diskutil.DiskUtil
+	snaps map[string]diskutil.SnapshotList
+}
+
+func (du fakeDiskUtil) ListSnapshots(_ context.Context, volume diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
+	return du.snaps[volume.UUID], nil
+}
+
+var (
+	source = diskutil.VolumeInfo{Name: "source", UUID: "source-uuid", Device: "/dev/disk1s1"}
+	target = diskutil.VolumeInfo{Name: "target", UUID: "target-uuid", Device: "/dev/disk2s1"}
+	snap1  = diskutil.Snapshot{Name: "snap1", UUID: "snap1-uuid"}
+	snap2  = diskutil.Snapshot{Name: "snap2", UUID: "snap2-uuid"}
+)
+
+func newWithFakeCmd(t *testing.T, du diskutil.DiskUtil, opts ...fakecmd.Option) Verifier {
+	return New(du, withExecCommand(fakecmd.FakeCommandContext(t, opts...)))
+}
+
+func TestVerify(t *testing.T) {
+	du := fakeDiskUtil{snaps: map[string]diskutil.SnapshotList{
+		source.UUID: {snap2, snap1},
+		target.UUID: {snap1},
+	}}
+	v := newWithFakeCmd(t, du,
+		fakecmd.WantArg("mount_apfs", "rdonly"),
+		fakecmd.WantArg("mount_apfs", "snap1"),
+	)
+	got, err := v.Verify(context.Background(), source, target, []string{"."})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Verify returned unexpected error: %v, want: nil", err)
+	}
+	if want := (Result{Snapshot: snap1}); !cmp.Equal(got, want) {
+		t.Errorf("Verify returned unexpected result, diff (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestVerify_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		snaps       map[string]diskutil.SnapshotList
+		paths       []string
+		opts        []fakecmd.Option
+		wantExitErr bool
+	}{
+		{
+			name: "target has no snapshots",
+			snaps: map[string]diskutil.SnapshotList{
+				source.UUID: {snap1},
+			},
+			paths: []string{"."},
+		},
+		{
+			name: "latest target snapshot not in source",
+			snaps: map[string]diskutil.SnapshotList{
+				source.UUID: {snap1},
+				target.UUID: {snap2, snap1},
+			},
+			paths: []string{"."},
+		},
+		{
+			name: "absolute path",
+			snaps: map[string]diskutil.SnapshotList{
+				source.UUID: {snap1},
+				target.UUID: {snap1},
+			},
+			paths: []string{"/Users"},
+		},
+		{
+			name: "path outside volume",
+			snaps: map[string]diskutil.SnapshotList{
+				source.UUID: {snap1},
+				target.UUID: {snap1},
+			},
+			paths: []string{"Users/../.."},
+		},
+		{
+			name: "mount_apfs fails",
+			snaps: map[string]diskutil.SnapshotList{
+				source.UUID: {snap1},
+				target.UUID: {snap1},
+			},
+			paths: []string{"."},
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("mount_apfs", "example stderr"),
+				fakecmd.ExitFail("mount_apfs"),
+			},
+			wantExitErr: true,
+		},
+		{
+			name: "umount fails",
+			snaps: map[string]diskutil.SnapshotList{
+				source.UUID: {snap1},
+				target.UUID: {snap1},
+			},
+			paths: []string{"."},
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("umount", "example stderr"),
+				fakecmd.ExitFail("umount"),
+			},
+			wantExitErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			v := newWithFakeCmd(t, fakeDiskUtil{snaps: test.snaps}, test.opts...)
+			_, err := v.Verify(context.Background(), source, target, test.paths)
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Fatal("Verify returned unexpected error: nil, want: non-nil")
+			}
+			var exitErr *exec.ExitError
+			if test.wantExitErr && !errors.As(err, &exitErr) {
+				t.Errorf("Verify returned unexpected error: %v, want type: *exec.ExitError", err)
+			}
+		})
+	}
+}
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, contents := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestCompare(t *testing.T) {
+	sourceRoot := writeFiles(t, map[string]string{
+		"Users/a/same":      "same",
+		"Users/a/differs":   "source",
+		"Users/a/missing":   "missing",
+		"Users/b/same":      "same",
+		"Applications/same": "same",
+	})
+	targetRoot := writeFiles(t, map[string]string{
+		"Users/a/same":       "same",
+		"Users/a/differs":    "target",
+		"Users/a/unexpected": "unexpected",
+		"Users/b/same":       "same",
+	})
+	tests := []struct {
+		name  string
+		paths []string
+		want  Result
+	}{
+		{
+			name:  "all",
+			paths: []string{"."},
+			want: Result{
+				Compared:   3,
+				Mismatched: []string{"Users/a/differs"},
+				Missing:    []string{"Applications/same", "Users/a/missing"},
+				Unexpected: []string{"Users/a/unexpected"},
+			},
+		},
+		{
+			name:  "identical",
+			paths: []string{"Users/b"},
+			want:  Result{Compared: 1},
+		},
+		{
+			name:  "file",
+			paths: []string{"Users/a/differs"},
+			want: Result{
+				Compared:   1,
+				Mismatched: []string{"Users/a/differs"},
+			},
+		},
+		{
+			name:  "overlapping paths",
+			paths: []string{"Users/b", "Users"},
+			want: Result{
+				Compared:   3,
+				Mismatched: []string{"Users/a/differs"},
+				Missing:    []string{"Users/a/missing"},
+				Unexpected: []string{"Users/a/unexpected"},
+			},
+		},
+		{
+			name:  "missing from target",
+			paths: []string{"Applications"},
+			want: Result{
+				Missing: []string{"Applications/same"},
+			},
+		},
+		{
+			name:  "in neither",
+			paths: []string{"Library"},
+			want:  Result{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := compare(sourceRoot, targetRoot, test.paths, checksum.NewHasher(checksum.SHA256))
+			if err != nil {
+				t.Fatalf("compare returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("compare returned unexpected result, diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResult_OK(t *testing.T) {
+	if !(Result{Compared: 1}).OK() {
+		t.Error("OK returned false for identical files, want: true")
+	}
+	if (Result{Compared: 1, Missing: []string{"a"}}).OK() {
+		t.Error("OK returned true for missing files, want: false")
+	}
+}
@@ -0,0 +1,134 @@
+// Package format implements formatting sizes and times consistently in
+// reports, as configured by Options.
+package format
+
+import (
+	"fmt"
+	"time"
+)
+
+// Size units of Options.SizeUnits.
+const (
+	// Decimal formats sizes in powers of 1000, e.g. 1.5 GB, like Finder
+	// and diskutil.
+	Decimal = "decimal"
+	// Binary formats sizes in powers of 1024, e.g. 1.4 GiB, like df -h.
+	Binary = "binary"
+)
+
+// Time zones of Options.TimeZone.
+const (
+	// Local formats times in the local time zone.
+	Local = "local"
+	// UTC formats times in UTC.
+	UTC = "utc"
+)
+
+// Time styles of Options.TimeStyle.
+const (
+	// ISO8601 formats times as ISO 8601 (RFC 3339), e.g.
+	// 2024-05-01T09:30:00+02:00.
+	ISO8601 = "iso8601"
+	// Readable formats times for reading, e.g. 2024-05-01 09:30:00 CEST.
+	Readable = "readable"
+)
+
+const readableLayout = "2006-01-02 15:04:05 MST"
+
+// Options configures how sizes and times are formatted. The zero Options
+// formats sizes in decimal units, and times as ISO 8601 in the local time
+// zone.
+type Options struct {
+	// SizeUnits is Decimal (default) or Binary.
+	SizeUnits string `json:"size_units,omitempty"`
+	// TimeZone is Local (default) or UTC.
+	TimeZone string `json:"time_zone,omitempty"`
+	// TimeStyle is ISO8601 (default) or Readable.
+	TimeStyle string `json:"time_style,omitempty"`
+}
+
+// Validate returns an error if any option is not one of its values.
+func (o Options) Validate() error {
+	_, err := New(o)
+	return err
+}
+
+// Formatter formats sizes and times. The zero Formatter formats them as the
+// zero Options does.
+type Formatter struct {
+	binary   bool
+	utc      bool
+	readable bool
+}
+
+// New returns a Formatter that formats sizes and times as configured by o.
+func New(o Options) (Formatter, error) {
+	var f Formatter
+	switch o.SizeUnits {
+	case "", Decimal:
+	case Binary:
+		f.binary = true
+	default:
+		return Formatter{}, fmt.Errorf("invalid size_units %q: must be %q or %q", o.SizeUnits, Decimal, Binary)
+	}
+	switch o.TimeZone {
+	case "", Local:
+	case UTC:
+		f.utc = true
+	default:
+		return Formatter{}, fmt.Errorf("invalid time_zone %q: must be %q or %q", o.TimeZone, Local, UTC)
+	}
+	switch o.TimeStyle {
+	case "", ISO8601:
+	case Readable:
+		f.readable = true
+	default:
+		return Formatter{}, fmt.Errorf("invalid time_style %q: must be %q or %q", o.TimeStyle, ISO8601, Readable)
+	}
+	return f, nil
+}
+
+// Time formats t in the configured time zone, regardless of t's location, so
+// that times parsed in UTC, e.g. from snapshot names, and times recorded in
+// the local time zone are formatted alike.
+func (f Formatter) Time(t time.Time) string {
+	if f.utc {
+		t = t.UTC()
+	} else {
+		t = t.Local()
+	}
+	if f.readable {
+		return t.Format(readableLayout)
+	}
+	return t.Format(time.RFC3339)
+}
+
+// Bytes formats n bytes in the configured units.
+func (f Formatter) Bytes(n uint64) string {
+	if f.binary {
+		return BinaryBytes(n)
+	}
+	return DecimalBytes(n)
+}
+
+// DecimalBytes formats n bytes in decimal units, e.g. 1.5 GB.
+func DecimalBytes(n uint64) string {
+	return formatBytes(n, 1000, "kMGTPE", "B")
+}
+
+// BinaryBytes formats n bytes in binary units, e.g. 1.4 GiB.
+func BinaryBytes(n uint64) string {
+	return formatBytes(n, 1024, "KMGTPE", "iB")
+}
+
+func formatBytes(n, unit uint64, prefixes, suffix string) string {
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := unit, 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%s", float64(n)/float64(div), prefixes[exp], suffix)
+}
@@ -0,0 +1,93 @@
+package format
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatter_Time(t *testing.T) {
+	defer func(loc *time.Location) { time.Local = loc }(time.Local)
+	time.Local = time.FixedZone("CEST", 2*60*60)
+	// A time parsed in UTC, e.g. from a snapshot name.
+	tm := time.Date(2024, 5, 1, 7, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{
+			name: "default",
+			want: "2024-05-01T09:30:00+02:00",
+		},
+		{
+			name: "utc",
+			opts: Options{TimeZone: UTC},
+			want: "2024-05-01T07:30:00Z",
+		},
+		{
+			name: "readable",
+			opts: Options{TimeStyle: Readable},
+			want: "2024-05-01 09:30:00 CEST",
+		},
+		{
+			name: "readable utc",
+			opts: Options{TimeZone: UTC, TimeStyle: Readable},
+			want: "2024-05-01 07:30:00 UTC",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := New(test.opts)
+			if err != nil {
+				t.Fatalf("New(%+v) returned error: %v", test.opts, err)
+			}
+			if got := f.Time(tm); got != test.want {
+				t.Errorf("Time(%v) = %q, want: %q", tm, got, test.want)
+			}
+			// Times in the local time zone are formatted alike.
+			if got := f.Time(tm.Local()); got != test.want {
+				t.Errorf("Time(%v) = %q, want: %q", tm.Local(), got, test.want)
+			}
+		})
+	}
+}
+
+func TestFormatter_Bytes(t *testing.T) {
+	tests := []struct {
+		opts Options
+		n    uint64
+		want string
+	}{
+		{n: 0, want: "0 B"},
+		{n: 999, want: "999 B"},
+		{n: 1500, want: "1.5 kB"},
+		{n: 12345678901, want: "12.3 GB"},
+		{n: 2e12, want: "2.0 TB"},
+		{opts: Options{SizeUnits: Decimal}, n: 2e12, want: "2.0 TB"},
+		{opts: Options{SizeUnits: Binary}, n: 1023, want: "1023 B"},
+		{opts: Options{SizeUnits: Binary}, n: 1536, want: "1.5 KiB"},
+		{opts: Options{SizeUnits: Binary}, n: 12345678901, want: "11.5 GiB"},
+	}
+	for _, test := range tests {
+		f, err := New(test.opts)
+		if err != nil {
+			t.Fatalf("New(%+v) returned error: %v", test.opts, err)
+		}
+		if got := f.Bytes(test.n); got != test.want {
+			t.Errorf("Bytes(%d) with %+v = %q, want: %q", test.n, test.opts, got, test.want)
+		}
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	for _, opts := range []Options{
+		{SizeUnits: "GiB"},
+		{TimeZone: "Europe/Berlin"},
+		{TimeStyle: "rfc3339"},
+	} {
+		if _, err := New(opts); err == nil {
+			t.Errorf("New(%+v) returned error: nil, want: non-nil", opts)
+		}
+	}
+}
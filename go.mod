@@ -2,4 +2,8 @@ module github.com/voidingwarranties/offsite-apfs-backup
 
 go 1.16
 
-require github.com/google/go-cmp v0.5.4
+require (
+	github.com/cespare/xxhash/v2 v2.2.0
+	github.com/google/go-cmp v0.5.4
+	lukechampine.com/blake3 v1.1.7
+)
@@ -0,0 +1,235 @@
+// Package hdiutil implements creating, attaching, detaching, compacting, and
+// inspecting disk images (e.g. .dmg and .sparsebundle) using MacOS's hdiutil.
+package hdiutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
+)
+
+// HDIUtil creates, attaches, detaches, compacts, and inspects disk images.
+type HDIUtil interface {
+	Attach(image string, opts AttachOptions) (Attached, error)
+	Detach(device string, force bool) error
+	Create(image string, opts CreateOptions) error
+	Compact(image string) error
+	ImageInfo(image string) (ImageInfo, error)
+}
+
+type hdiUtil struct {
+	execCommand func(string, ...string) *exec.Cmd
+	pl          plutil.PLUtil
+}
+
+type option func(*hdiUtil)
+
+func withExecCommand(f func(string, ...string) *exec.Cmd) option {
+	return func(h *hdiUtil) {
+		h.execCommand = f
+	}
+}
+
+// New returns a new HDIUtil.
+func New(opts ...option) HDIUtil {
+	h := hdiUtil{
+		execCommand: exec.Command,
+		pl:          plutil.New(),
+	}
+	for _, opt := range opts {
+		opt(&h)
+	}
+	return h
+}
+
+// AttachOptions configures Attach.
+type AttachOptions struct {
+	// MountPoint, if set, is where the image's volume is mounted, instead
+	// of under /Volumes. Only supported for images with a single volume.
+	MountPoint string
+	ReadOnly   bool
+	// Shadow, if set, is a shadow file that all writes are redirected to,
+	// leaving the image unmodified.
+	Shadow string
+	// NoBrowse hides the attached volumes from Finder.
+	NoBrowse bool
+	// NoMount attaches the image's devices without mounting any volumes.
+	NoMount bool
+}
+
+// Attached describes the devices of an attached image.
+type Attached struct {
+	Entities []Entity `json:"system-entities"`
+}
+
+// Entity is a device of an attached image, e.g. the whole disk, a partition,
+// or a volume.
+type Entity struct {
+	// e.g. /dev/disk5s1
+	Device string `json:"dev-entry"`
+	// MountPoint is empty if the entity is not a mounted volume.
+	MountPoint string `json:"mount-point"`
+	// e.g. Apple_APFS, GUID_partition_scheme.
+	ContentHint string `json:"content-hint"`
+	// e.g. apfs, hfs.
+	VolumeKind string `json:"volume-kind"`
+}
+
+// Volumes returns the mounted volumes of the attached image.
+func (a Attached) Volumes() []Entity {
+	var volumes []Entity
+	for _, e := range a.Entities {
+		if e.MountPoint != "" {
+			volumes = append(volumes, e)
+		}
+	}
+	return volumes
+}
+
+// Volume returns the only mounted volume of the attached image, or an error if
+// the image does not have exactly one mounted volume.
+func (a Attached) Volume() (Entity, error) {
+	volumes := a.Volumes()
+	switch len(volumes) {
+	case 0:
+		return Entity{}, errors.New("attached image has no mounted volumes")
+	case 1:
+		return volumes[0], nil
+	}
+	return Entity{}, fmt.Errorf("attached image has %d mounted volumes, want 1", len(volumes))
+}
+
+// Attach attaches the disk image at path image.
+func (h hdiUtil) Attach(image string, opts AttachOptions) (Attached, error) {
+	args := []string{"attach", "-plist"}
+	if opts.NoBrowse {
+		args = append(args, "-nobrowse")
+	}
+	if opts.NoMount {
+		args = append(args, "-nomount")
+	}
+	if opts.ReadOnly {
+		args = append(args, "-readonly")
+	}
+	if opts.Shadow != "" {
+		args = append(args, "-shadow", opts.Shadow)
+	}
+	if opts.MountPoint != "" {
+		args = append(args, "-mountpoint", opts.MountPoint)
+	}
+	args = append(args, image)
+	var attached Attached
+	err := h.runAndDecodePlist(h.execCommand("hdiutil", args...), &attached)
+	return attached, err
+}
+
+// Detach detaches device, which may be any device of an attached image, e.g.
+// an Entity's Device. If force is true, the image is detached even if its
+// volumes are in use.
+func (h hdiUtil) Detach(device string, force bool) error {
+	args := []string{"detach"}
+	if force {
+		args = append(args, "-force")
+	}
+	args = append(args, device)
+	return h.run(h.execCommand("hdiutil", args...))
+}
+
+// CreateOptions configures Create.
+type CreateOptions struct {
+	// Size of the image, in the syntax of hdiutil, e.g. 100m or 2t.
+	Size string
+	// FileSystem of the image's volume, e.g. APFS or "Case-sensitive
+	// APFS".
+	FileSystem string
+	VolumeName string
+	// Type of image, e.g. UDIF (default), SPARSE, or SPARSEBUNDLE.
+	Type string
+}
+
+// Create creates an empty disk image at path image.
+func (h hdiUtil) Create(image string, opts CreateOptions) error {
+	args := []string{"create"}
+	if opts.Size != "" {
+		args = append(args, "-size", opts.Size)
+	}
+	if opts.FileSystem != "" {
+		args = append(args, "-fs", opts.FileSystem)
+	}
+	if opts.VolumeName != "" {
+		args = append(args, "-volname", opts.VolumeName)
+	}
+	if opts.Type != "" {
+		args = append(args, "-type", opts.Type)
+	}
+	args = append(args, image)
+	return h.run(h.execCommand("hdiutil", args...))
+}
+
+// Compact reclaims unused space in the sparse or sparse bundle image at path
+// image. The image must not be attached.
+func (h hdiUtil) Compact(image string) error {
+	return h.run(h.execCommand("hdiutil", "compact", image))
+}
+
+// ImageInfo describes a disk image file.
+type ImageInfo struct {
+	// e.g. UDZO, UDSB.
+	Format            string
+	FormatDescription string
+	Encrypted         bool
+	Partitioned       bool
+	// TotalBytes is the size of the image's devices when attached.
+	TotalBytes uint64
+}
+
+// ImageInfo returns the ImageInfo of the disk image at path image.
+func (h hdiUtil) ImageInfo(image string) (ImageInfo, error) {
+	var info struct {
+		Format            string `json:"Format"`
+		FormatDescription string `json:"Format Description"`
+		Properties        struct {
+			Encrypted   bool `json:"Encrypted"`
+			Partitioned bool `json:"Partitioned"`
+		} `json:"Properties"`
+		SizeInformation struct {
+			TotalBytes uint64 `json:"Total Bytes"`
+		} `json:"Size Information"`
+	}
+	if err := h.runAndDecodePlist(h.execCommand("hdiutil", "imageinfo", "-plist", image), &info); err != nil {
+		return ImageInfo{}, err
+	}
+	return ImageInfo{
+		Format:            info.Format,
+		FormatDescription: info.FormatDescription,
+		Encrypted:         info.Properties.Encrypted,
+		Partitioned:       info.Properties.Partitioned,
+		TotalBytes:        info.SizeInformation.TotalBytes,
+	}, nil
+}
+
+func (h hdiUtil) run(cmd *exec.Cmd) error {
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
+
+func (h hdiUtil) runAndDecodePlist(cmd *exec.Cmd, v interface{}) error {
+	stdout, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, exitErr.Stderr)
+	}
+	if err != nil {
+		return fmt.Errorf("`%s` failed (%w)", cmd, err)
+	}
+	if err := h.pl.Unmarshal(stdout, v); err != nil {
+		return fmt.Errorf("error parsing plist: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package hdiutil
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) HDIUtil {
+	return New(withExecCommand(fakecmd.FakeCommand(t, opts...)))
+}
+
+func TestAttach(t *testing.T) {
+	h := newWithFakeCmd(t,
+		fakecmd.Stdout("hdiutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>system-entities</key>
+	<array>
+		<dict>
+			<key>dev-entry</key>
+			<string>/dev/disk5</string>
+			<key>content-hint</key>
+			<string>GUID_partition_scheme</string>
+		</dict>
+		<dict>
+			<key>dev-entry</key>
+			<string>/dev/disk5s1</string>
+			<key>content-hint</key>
+			<string>Apple_APFS</string>
+		</dict>
+		<dict>
+			<key>dev-entry</key>
+			<string>/dev/disk6s1</string>
+			<key>mount-point</key>
+			<string>/mount/point</string>
+			<key>volume-kind</key>
+			<string>apfs</string>
+		</dict>
+	</array>
+</dict>
+</plist>`),
+		fakecmd.WantArg("hdiutil", "attach"),
+		fakecmd.WantArg("hdiutil", "-nobrowse"),
+		fakecmd.WantArg("hdiutil", "-readonly"),
+		fakecmd.WantArg("hdiutil", "-shadow"),
+		fakecmd.WantArg("hdiutil", "/shadow/file"),
+		fakecmd.WantArg("hdiutil", "-mountpoint"),
+		fakecmd.WantArg("hdiutil", "/mount/point"),
+		fakecmd.WantArg("hdiutil", "/example.dmg"),
+	)
+	got, err := h.Attach("/example.dmg", AttachOptions{
+		MountPoint: "/mount/point",
+		ReadOnly:   true,
+		Shadow:     "/shadow/file",
+		NoBrowse:   true,
+	})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Attach returned unexpected error: %v, want: nil", err)
+	}
+	want := Entity{
+		Device:     "/dev/disk6s1",
+		MountPoint: "/mount/point",
+		VolumeKind: "apfs",
+	}
+	gotVolume, err := got.Volume()
+	if err != nil {
+		t.Fatalf("Volume returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(want, gotVolume); diff != "" {
+		t.Errorf("Attach returned unexpected volume. -want +got:\n%s", diff)
+	}
+	if len(got.Entities) != 3 {
+		t.Errorf("Attach returned %d entities, want: 3", len(got.Entities))
+	}
+}
+
+func TestAttached_Volume_Errors(t *testing.T) {
+	tests := []struct {
+		name     string
+		attached Attached
+	}{
+		{
+			name: "no volumes",
+			attached: Attached{Entities: []Entity{
+				{Device: "/dev/disk5"},
+			}},
+		},
+		{
+			name: "multiple volumes",
+			attached: Attached{Entities: []Entity{
+				{Device: "/dev/disk5s1", MountPoint: "/a"},
+				{Device: "/dev/disk5s2", MountPoint: "/b"},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := test.attached.Volume(); err == nil {
+				t.Error("Volume returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestDetach(t *testing.T) {
+	h := newWithFakeCmd(t,
+		fakecmd.WantArg("hdiutil", "detach"),
+		fakecmd.WantArg("hdiutil", "-force"),
+		fakecmd.WantArg("hdiutil", "/dev/disk5"),
+	)
+	err := h.Detach("/dev/disk5", true)
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Errorf("Detach returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	h := newWithFakeCmd(t,
+		fakecmd.WantArg("hdiutil", "create"),
+		fakecmd.WantArg("hdiutil", "-size"),
+		fakecmd.WantArg("hdiutil", "100m"),
+		fakecmd.WantArg("hdiutil", "-fs"),
+		fakecmd.WantArg("hdiutil", "APFS"),
+		fakecmd.WantArg("hdiutil", "-volname"),
+		fakecmd.WantArg("hdiutil", "example"),
+		fakecmd.WantArg("hdiutil", "-type"),
+		fakecmd.WantArg("hdiutil", "SPARSEBUNDLE"),
+		fakecmd.WantArg("hdiutil", "/example.sparsebundle"),
+	)
+	err := h.Create("/example.sparsebundle", CreateOptions{
+		Size:       "100m",
+		FileSystem: "APFS",
+		VolumeName: "example",
+		Type:       "SPARSEBUNDLE",
+	})
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Errorf("Create returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestCompact_Errors(t *testing.T) {
+	h := newWithFakeCmd(t,
+		fakecmd.Stderr("hdiutil", "hdiutil: compact failed - Resource busy"),
+		fakecmd.ExitFail("hdiutil"),
+		fakecmd.WantArg("hdiutil", "compact"),
+		fakecmd.WantArg("hdiutil", "/example.sparsebundle"),
+	)
+	err := h.Compact("/example.sparsebundle")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		t.Errorf("Compact returned unexpected error: %v, want type: *exec.ExitError", err)
+	}
+}
+
+func TestImageInfo(t *testing.T) {
+	h := newWithFakeCmd(t,
+		fakecmd.Stdout("hdiutil", `<?xml version="1.0" encoding="UTF-8"?>
+<plist version="1.0">
+<dict>
+	<key>Format</key>
+	<string>UDSB</string>
+	<key>Format Description</key>
+	<string>sparse bundle disk image</string>
+	<key>Properties</key>
+	<dict>
+		<key>Encrypted</key>
+		<true/>
+		<key>Partitioned</key>
+		<false/>
+	</dict>
+	<key>Size Information</key>
+	<dict>
+		<key>Total Bytes</key>
+		<integer>104857600</integer>
+	</dict>
+</dict>
+</plist>`),
+		fakecmd.WantArg("hdiutil", "imageinfo"),
+		fakecmd.WantArg("hdiutil", "/example.sparsebundle"),
+	)
+	got, err := h.ImageInfo("/example.sparsebundle")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("ImageInfo returned unexpected error: %v, want: nil", err)
+	}
+	want := ImageInfo{
+		Format:            "UDSB",
+		FormatDescription: "sparse bundle disk image",
+		Encrypted:         true,
+		TotalBytes:        104857600,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ImageInfo returned unexpected info. -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskhealth"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+)
+
+// monitorHealth samples the health of target's disk every interval until the
+// returned func is called, which returns the report of all samples. Warnings
+// are written to w as soon as thresholds are crossed. If interval is 0, or
+// target's disk cannot be found, nothing is monitored, and the returned func
+// returns nil.
+func monitorHealth(ctx context.Context, w io.Writer, du diskutil.DiskUtil, target string, interval time.Duration) (stop func() *diskhealth.Report) {
+	noop := func() *diskhealth.Report { return nil }
+	if interval <= 0 {
+		return noop
+	}
+	info, err := du.Info(ctx, target)
+	if err != nil || len(info.PhysicalStores) == 0 {
+		return noop
+	}
+	sampler := diskhealth.New(diskhealth.Clock(clk))
+	m := diskhealth.Start(sampler, info.PhysicalStores[0].WholeDisk(), interval,
+		diskhealth.Warn(func(warning string) {
+			// asr does not end its progress output with a newline
+			// until the restore is complete, so start a new line.
+			fmt.Fprintf(w, "\nWarning: %s\n", warning)
+			logger.Log(oslog.Error, "%s", warning)
+		}),
+	)
+	return func() *diskhealth.Report {
+		r := m.Stop()
+		return &r
+	}
+}
@@ -0,0 +1,179 @@
+// Package history implements a record, stored on each target volume, of the
+// snapshots the target contained after it was last cloned to. Comparing the
+// record to the target's snapshots detects if the target was modified by
+// something other than this utility between clones (e.g. snapshots deleted
+// while the target was off-site).
+//
+// The record is written to the root of the target volume after the target's
+// latest snapshot, so it is discarded by the next restore, and rewritten after
+// it. Records of older formats are migrated when read, and records of newer
+// formats are refused rather than misinterpreted.
+package history
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/schema"
+)
+
+// Filename is the name of the file the record is stored in, relative to the
+// target volume's mount point.
+const Filename = ".offsite-apfs-backup-history.json"
+
+// FormatVersion is the version of the format of records written by Write.
+const FormatVersion = 1
+
+// migrations migrate records from each format version to the next:
+// migrations[i] migrates version i to version i+1. FormatVersion must be
+// len(migrations).
+var migrations = []schema.Migration{
+	// Version 0 records predate format versions, and are otherwise the
+	// same as version 1.
+	func(map[string]interface{}) error { return nil },
+}
+
+// ErrNewerFormat is returned by Read if the record was written in a newer
+// format than FormatVersion.
+var ErrNewerFormat = errors.New("target history was written by a newer version of offsite-apfs-backup")
+
+// Record is the history of a target volume.
+type Record struct {
+	// Version is the format version of the record as it was read, before it
+	// was migrated. Write writes FormatVersion.
+	Version    int    `json:"version"`
+	SourceUUID string `json:"source_uuid"`
+	// Snapshots are the UUIDs of the target's snapshots, oldest first.
+	Snapshots []string `json:"snapshots"`
+	// Chain is the hash chain of Snapshots.
+	Chain string `json:"chain"`
+}
+
+// New returns the record of a target containing snaps, ordered most recent
+// first like diskutil.DiskUtil.ListSnapshots.
+func New(sourceUUID string, snaps diskutil.SnapshotList) Record {
+	uuids := oldestFirst(snaps)
+	return Record{
+		Version:    FormatVersion,
+		SourceUUID: sourceUUID,
+		Snapshots:  uuids,
+		Chain:      Chain(uuids),
+	}
+}
+
+// Chain returns the hash chain of the given snapshot UUIDs. Each link is the
+// SHA-256 of the previous link and the next UUID, so the chain depends on both
+// the UUIDs and their order.
+func Chain(uuids []string) string {
+	link := sha256.Sum256(nil)
+	for _, uuid := range uuids {
+		link = sha256.Sum256(append(link[:], uuid...))
+	}
+	return hex.EncodeToString(link[:])
+}
+
+// DivergedError is returned by Verify if a target's snapshots do not match
+// its record.
+type DivergedError struct {
+	// Missing are the UUIDs of snapshots in the record, but not in the
+	// target.
+	Missing []string
+	// Unexpected are the UUIDs of snapshots in the target, but not in the
+	// record.
+	Unexpected []string
+}
+
+func (err *DivergedError) Error() string {
+	var details []string
+	if len(err.Missing) > 0 {
+		details = append(details, fmt.Sprintf("missing snapshots %s", strings.Join(err.Missing, ", ")))
+	}
+	if len(err.Unexpected) > 0 {
+		details = append(details, fmt.Sprintf("unexpected snapshots %s", strings.Join(err.Unexpected, ", ")))
+	}
+	if len(details) == 0 {
+		details = append(details, "snapshots are out of order")
+	}
+	return fmt.Sprintf("target history diverged: %s", strings.Join(details, "; "))
+}
+
+// Verify returns a *DivergedError if snaps, ordered most recent first, are not
+// the snapshots in the record.
+func (r Record) Verify(snaps diskutil.SnapshotList) error {
+	uuids := oldestFirst(snaps)
+	if Chain(uuids) == r.Chain {
+		return nil
+	}
+	got := make(map[string]bool)
+	for _, uuid := range uuids {
+		got[uuid] = true
+	}
+	want := make(map[string]bool)
+	for _, uuid := range r.Snapshots {
+		want[uuid] = true
+	}
+	err := &DivergedError{}
+	for _, uuid := range r.Snapshots {
+		if !got[uuid] {
+			err.Missing = append(err.Missing, uuid)
+		}
+	}
+	for _, uuid := range uuids {
+		if !want[uuid] {
+			err.Unexpected = append(err.Unexpected, uuid)
+		}
+	}
+	return err
+}
+
+// Read reads the record of the volume mounted at mountPoint. exists is false
+// if the volume has no record.
+func Read(mountPoint string) (r Record, exists bool, err error) {
+	data, err := os.ReadFile(filepath.Join(mountPoint, Filename))
+	if errors.Is(err, os.ErrNotExist) {
+		return Record{}, false, nil
+	}
+	if err != nil {
+		return Record{}, false, fmt.Errorf("error reading history: %w", err)
+	}
+	data, err = schema.Migrate(data, migrations)
+	var newer *schema.NewerError
+	if errors.As(err, &newer) {
+		return Record{}, false, fmt.Errorf("%w: %v", ErrNewerFormat, err)
+	}
+	if err != nil {
+		return Record{}, false, fmt.Errorf("error migrating history: %w", err)
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		return Record{}, false, fmt.Errorf("error parsing history: %w", err)
+	}
+	return r, true, nil
+}
+
+// Write writes the record to the volume mounted at mountPoint.
+func Write(mountPoint string, r Record) error {
+	r.Version = FormatVersion
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(mountPoint, Filename), data, 0644); err != nil {
+		return fmt.Errorf("error writing history: %w", err)
+	}
+	return nil
+}
+
+func oldestFirst(snaps diskutil.SnapshotList) []string {
+	uuids := make([]string, len(snaps))
+	for i, s := range snaps {
+		uuids[len(snaps)-1-i] = s.UUID
+	}
+	return uuids
+}
@@ -0,0 +1,129 @@
+package history
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	snap1 = diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	snap2 = diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	snap3 = diskutil.Snapshot{Name: "snap-3", UUID: "snap-3-uuid"}
+)
+
+func TestChain_DependsOnOrder(t *testing.T) {
+	if Chain([]string{"a", "b"}) == Chain([]string{"b", "a"}) {
+		t.Error("Chain returned the same hash for differently ordered UUIDs")
+	}
+	if Chain([]string{"a", "b"}) != Chain([]string{"a", "b"}) {
+		t.Error("Chain returned different hashes for the same UUIDs")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	r := New("source-uuid", []diskutil.Snapshot{snap2, snap1})
+	if err := r.Verify([]diskutil.Snapshot{snap2, snap1}); err != nil {
+		t.Errorf("Verify returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestVerify_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		snaps []diskutil.Snapshot
+		want  *DivergedError
+	}{
+		{
+			name:  "snapshot deleted",
+			snaps: []diskutil.Snapshot{snap2},
+			want:  &DivergedError{Missing: []string{snap1.UUID}},
+		},
+		{
+			name:  "snapshot added",
+			snaps: []diskutil.Snapshot{snap3, snap2, snap1},
+			want:  &DivergedError{Unexpected: []string{snap3.UUID}},
+		},
+		{
+			name:  "snapshot replaced",
+			snaps: []diskutil.Snapshot{snap3, snap1},
+			want: &DivergedError{
+				Missing:    []string{snap2.UUID},
+				Unexpected: []string{snap3.UUID},
+			},
+		},
+		{
+			name:  "out of order",
+			snaps: []diskutil.Snapshot{snap1, snap2},
+			want:  &DivergedError{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := New("source-uuid", []diskutil.Snapshot{snap2, snap1})
+			err := r.Verify(test.snaps)
+			var got *DivergedError
+			if !errors.As(err, &got) {
+				t.Fatalf("Verify returned unexpected error: %v, want type: *DivergedError", err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Verify returned unexpected DivergedError. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	mountPoint := t.TempDir()
+	if _, exists, err := Read(mountPoint); err != nil || exists {
+		t.Fatalf("Read of volume without history returned (exists: %t, err: %v), want: (false, nil)", exists, err)
+	}
+	want := New("source-uuid", []diskutil.Snapshot{snap2, snap1})
+	if err := Write(mountPoint, want); err != nil {
+		t.Fatalf("Write returned unexpected error: %v, want: nil", err)
+	}
+	got, exists, err := Read(mountPoint)
+	if err != nil || !exists {
+		t.Fatalf("Read returned (exists: %t, err: %v), want: (true, nil)", exists, err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Read returned unexpected record. -want +got:\n%s", diff)
+	}
+}
+
+func TestRead_Migrates(t *testing.T) {
+	mountPoint := t.TempDir()
+	data := `{"source_uuid": "source-uuid", "snapshots": ["snap-1-uuid"], "chain": "chain"}`
+	if err := os.WriteFile(filepath.Join(mountPoint, Filename), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, exists, err := Read(mountPoint)
+	if err != nil || !exists {
+		t.Fatalf("Read returned (exists: %t, err: %v), want: (true, nil)", exists, err)
+	}
+	want := Record{
+		SourceUUID: "source-uuid",
+		Snapshots:  []string{"snap-1-uuid"},
+		Chain:      "chain",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Read returned unexpected record. -want +got:\n%s", diff)
+	}
+}
+
+func TestRead_NewerFormat(t *testing.T) {
+	mountPoint := t.TempDir()
+	data := `{"version": 1000, "source_uuid": "source-uuid"}`
+	if err := os.WriteFile(filepath.Join(mountPoint, Filename), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := Read(mountPoint); !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("Read returned unexpected error: %v, want: %v", err, ErrNewerFormat)
+	}
+}
@@ -0,0 +1,109 @@
+package jsonschema
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type embedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type example struct {
+	embedded
+	String   string `json:"string"`
+	Optional int    `json:"optional,omitempty"`
+	Untagged bool
+	Skipped  string            `json:"-"`
+	private  string            // Not encoded.
+	Float    float64           `json:"float"`
+	Time     time.Time         `json:"time"`
+	Duration time.Duration     `json:"duration"`
+	Data     []byte            `json:"data"`
+	Pointer  *embedded         `json:"pointer,omitempty"`
+	List     []string          `json:"list"`
+	Map      map[string]int    `json:"map"`
+	Any      interface{}       `json:"any"`
+	Nested   []map[string]bool `json:"nested"`
+}
+
+func TestNew(t *testing.T) {
+	want := &Schema{
+		Schema: Draft,
+		Title:  "example",
+		Type:   "object",
+		Properties: map[string]*Schema{
+			"embedded": {Type: "string"},
+			"string":   {Type: "string"},
+			"optional": {Type: "integer"},
+			"Untagged": {Type: "boolean"},
+			"float":    {Type: "number"},
+			"time":     {Type: "string", Format: "date-time"},
+			"duration": {Type: "integer"},
+			"data":     {Type: "string", ContentEncoding: "base64"},
+			"pointer": {
+				Type:       "object",
+				Properties: map[string]*Schema{"embedded": {Type: "string"}},
+			},
+			"list": {Type: "array", Items: &Schema{Type: "string"}},
+			"map":  {Type: "object", AdditionalProperties: &Schema{Type: "integer"}},
+			"any":  {},
+			"nested": {
+				Type:  "array",
+				Items: &Schema{Type: "object", AdditionalProperties: &Schema{Type: "boolean"}},
+			},
+		},
+	}
+	got, err := New(example{}, "example")
+	if err != nil {
+		t.Fatalf("New returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("New returned unexpected schema. -want +got:\n%s", diff)
+	}
+}
+
+func TestNew_Output(t *testing.T) {
+	got, err := New(example{}, "example", Output(true))
+	if err != nil {
+		t.Fatalf("New returned unexpected error: %v, want: nil", err)
+	}
+	want := []string{"embedded", "string", "Untagged", "float", "time", "duration", "data", "list", "map", "any", "nested"}
+	if diff := cmp.Diff(want, got.Required); diff != "" {
+		t.Errorf("New returned unexpected required properties. -want +got:\n%s", diff)
+	}
+	if pointer := got.Properties["pointer"]; len(pointer.Required) != 1 {
+		t.Errorf("New returned unexpected required properties of nested object: %v, want: [embedded]", pointer.Required)
+	}
+}
+
+func TestNew_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{
+			name: "channel",
+			v:    make(chan int),
+		},
+		{
+			name: "non-string map key",
+			v:    map[int]string{},
+		},
+		{
+			name: "unsupported field",
+			v: struct {
+				F func() `json:"f"`
+			}{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New(test.v, test.name); err == nil {
+				t.Error("New returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+// Package keychain implements looking up passphrases stored in the MacOS
+// keychain using MacOS's security.
+package keychain
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// Service is the service of the keychain items storing the passphrases of
+// encrypted targets, whose accounts are the targets' volume UUIDs.
+const Service = "offsite-apfs-backup"
+
+// ErrNotFound is returned by Passphrase if the keychain has no matching item.
+var ErrNotFound = errors.New("passphrase not found in keychain")
+
+// Keychain looks up passphrases. security is killed if ctx is done before it
+// exits.
+type Keychain interface {
+	// Passphrase returns the password of the generic password item with
+	// service and account in the user's keychain search list.
+	Passphrase(ctx context.Context, service, account string) (string, error)
+}
+
+type keychain struct {
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+type option func(*keychain)
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) option {
+	return func(kc *keychain) {
+		kc.execCommand = f
+	}
+}
+
+// New returns a new Keychain.
+func New(opts ...option) Keychain {
+	kc := keychain{
+		execCommand: exec.CommandContext,
+	}
+	for _, opt := range opts {
+		opt(&kc)
+	}
+	return kc
+}
+
+// notFoundMsg is in security's stderr if no item matches, e.g.
+// "security: SecKeychainSearchCopyNext: The specified item could not be found
+// in the keychain."
+const notFoundMsg = "could not be found in the keychain"
+
+func (kc keychain) Passphrase(ctx context.Context, service, account string) (string, error) {
+	cmd := kc.execCommand(ctx, "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), notFoundMsg) {
+			return "", fmt.Errorf("%w: no item with service %q and account %q", ErrNotFound, service, account)
+		}
+		return "", fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	passphrase := strings.TrimSuffix(string(stdout), "\n")
+	if passphrase == "" {
+		return "", fmt.Errorf("`%s` returned an empty passphrase", cmd)
+	}
+	return passphrase, nil
+}
@@ -0,0 +1,90 @@
+package keychain
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) Keychain {
+	return New(withExecCommand(fakecmd.FakeCommandContext(t, opts...)))
+}
+
+func TestPassphrase(t *testing.T) {
+	kc := newWithFakeCmd(t,
+		fakecmd.Stdout("security", "example passphrase\n"),
+		fakecmd.WantArg("security", "find-generic-password"),
+		fakecmd.WantArg("security", Service),
+		fakecmd.WantArg("security", "123-target-uuid"),
+		fakecmd.WantArg("security", "-w"),
+	)
+	got, err := kc.Passphrase(context.Background(), Service, "123-target-uuid")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("Passphrase returned unexpected error: %v, want: nil", err)
+	}
+	if want := "example passphrase"; got != want {
+		t.Errorf("Passphrase returned %q, want: %q", got, want)
+	}
+}
+
+func TestPassphrase_Errors(t *testing.T) {
+	var exitErr *exec.ExitError
+
+	tests := []struct {
+		name      string
+		opts      []fakecmd.Option
+		wantErrIs error
+		wantErrAs interface{}
+	}{
+		{
+			name: "not found",
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("security", "security: SecKeychainSearchCopyNext: The specified item could not be found in the keychain.\n"),
+				fakecmd.ExitFail("security"),
+			},
+			wantErrIs: ErrNotFound,
+		},
+		{
+			name: "security exec errors",
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("security", "example stderr"),
+				fakecmd.ExitFail("security"),
+			},
+			wantErrAs: &exitErr,
+		},
+		{
+			name: "empty passphrase",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("security", "\n"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kc := newWithFakeCmd(t, test.opts...)
+			_, err := kc.Passphrase(context.Background(), Service, "123-target-uuid")
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Fatal("Passphrase returned error: nil, want: non-nil")
+			}
+			if test.wantErrIs != nil && !errors.Is(err, test.wantErrIs) {
+				t.Errorf("Passphrase returned unexpected error: %v, want: %v", err, test.wantErrIs)
+			}
+			if test.wantErrAs != nil && !errors.As(err, test.wantErrAs) {
+				t.Errorf("Passphrase returned unexpected error: %v, want type: %T", err, test.wantErrAs)
+			}
+		})
+	}
+}
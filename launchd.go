@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+const (
+	// launchdVolumeWait is how long -launchd runs wait for source and
+	// targets to be attached. Jobs started by StartOnMount may start while
+	// other volumes of the same disk are still being mounted.
+	launchdVolumeWait = 15 * time.Second
+	// launchdPollInterval is how often -launchd runs check whether source
+	// and targets are attached.
+	launchdPollInterval = time.Second
+)
+
+// attachedTargets waits up to timeout for source and all targets to be
+// attached, and returns the targets that are attached. An error is returned if
+// source is not attached.
+func attachedTargets(ctx context.Context, du diskutil.DiskUtil, source string, targets []string, timeout time.Duration) ([]string, error) {
+	deadline := clk.Now().Add(timeout)
+	for {
+		_, sourceErr := du.Info(ctx, source)
+		var attached []string
+		for _, t := range targets {
+			if _, err := du.Info(ctx, t); err == nil {
+				attached = append(attached, t)
+			}
+		}
+		done := sourceErr == nil && len(attached) == len(targets)
+		if done || !clk.Now().Before(deadline) {
+			if sourceErr != nil {
+				return nil, fmt.Errorf("source %q is not attached: %v", source, sourceErr)
+			}
+			return attached, nil
+		}
+		time.Sleep(launchdPollInterval)
+	}
+}
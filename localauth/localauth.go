@@ -0,0 +1,28 @@
+// Package localauth asks the user to authenticate with Touch ID, using MacOS's
+// LocalAuthentication framework. Unlike typing "y" at a prompt, Touch ID can
+// only be confirmed by someone whose fingerprint is enrolled on the machine.
+//
+// On other platforms, when built without cgo, or on machines without Touch ID,
+// Authenticate returns ErrUnavailable.
+package localauth
+
+import (
+	"errors"
+)
+
+var (
+	// ErrUnavailable is returned by Authenticate if Touch ID cannot be
+	// used, e.g. because the machine has no Touch ID sensor or no
+	// fingerprints are enrolled.
+	ErrUnavailable = errors.New("Touch ID is unavailable")
+	// ErrRejected is returned by Authenticate if the user failed or
+	// cancelled authentication.
+	ErrRejected = errors.New("Touch ID authentication rejected")
+)
+
+// Authenticate prompts the user to authenticate with Touch ID, displaying
+// reason in the prompt, and blocks until they do. It returns nil only if
+// authentication succeeded.
+func Authenticate(reason string) error {
+	return authenticate(reason)
+}
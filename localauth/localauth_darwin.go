@@ -0,0 +1,67 @@
+// +build darwin,cgo
+
+package localauth
+
+/*
+#cgo CFLAGS: -x objective-c -fobjc-arc
+#cgo LDFLAGS: -framework Foundation -framework LocalAuthentication
+#import <Foundation/Foundation.h>
+#import <LocalAuthentication/LocalAuthentication.h>
+#include <stdlib.h>
+
+enum {
+	AUTH_OK = 0,
+	AUTH_UNAVAILABLE = 1,
+	AUTH_REJECTED = 2,
+};
+
+// localauth_evaluate blocks until the user responds to the Touch ID prompt.
+// On failure, *msg is set to a description of the error, which the caller
+// must free.
+static int localauth_evaluate(const char *reason, char **msg) {
+	LAContext *ctx = [[LAContext alloc] init];
+	NSError *err = nil;
+	if (![ctx canEvaluatePolicy:LAPolicyDeviceOwnerAuthenticationWithBiometrics error:&err]) {
+		*msg = strdup(err.localizedDescription.UTF8String);
+		return AUTH_UNAVAILABLE;
+	}
+	dispatch_semaphore_t done = dispatch_semaphore_create(0);
+	__block int result = AUTH_OK;
+	__block char *desc = NULL;
+	[ctx evaluatePolicy:LAPolicyDeviceOwnerAuthenticationWithBiometrics
+	    localizedReason:[NSString stringWithUTF8String:reason]
+	              reply:^(BOOL success, NSError *error) {
+		if (!success) {
+			result = AUTH_REJECTED;
+			desc = strdup(error.localizedDescription.UTF8String);
+		}
+		dispatch_semaphore_signal(done);
+	}];
+	dispatch_semaphore_wait(done, DISPATCH_TIME_FOREVER);
+	*msg = desc;
+	return result;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+func authenticate(reason string) error {
+	creason := C.CString(reason)
+	defer C.free(unsafe.Pointer(creason))
+	var msg *C.char
+	result := C.localauth_evaluate(creason, &msg)
+	if msg != nil {
+		defer C.free(unsafe.Pointer(msg))
+	}
+	switch result {
+	case C.AUTH_OK:
+		return nil
+	case C.AUTH_UNAVAILABLE:
+		return fmt.Errorf("%w: %s", ErrUnavailable, C.GoString(msg))
+	}
+	return fmt.Errorf("%w: %s", ErrRejected, C.GoString(msg))
+}
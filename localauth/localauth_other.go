@@ -0,0 +1,7 @@
+// +build !darwin !cgo
+
+package localauth
+
+func authenticate(reason string) error {
+	return ErrUnavailable
+}
@@ -0,0 +1,14 @@
+// +build !darwin !cgo
+
+package localauth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAuthenticate_Unavailable(t *testing.T) {
+	if err := Authenticate("test"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Authenticate returned unexpected error: %v, want: %v", err, ErrUnavailable)
+	}
+}
@@ -0,0 +1,161 @@
+// Package lock implements system-wide locks that prevent concurrent
+// invocations of the backup utility from modifying the same volumes.
+//
+// A lock is a file held with flock(2), so that it is released by the kernel
+// when its holder exits, however it exits. The file contains the PID of the
+// holder, which is only used to report who holds the lock.
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DefaultDir is the directory lock files are created in. It is cleared on
+// boot, so locks never outlive a restart.
+const DefaultDir = "/var/run/offsite-apfs-backup"
+
+// HeldError is returned when a lock is held by another running process. PID
+// is 0 if the holder has not written its PID to the lock yet.
+type HeldError struct {
+	Name string
+	PID  int
+}
+
+func (err *HeldError) Error() string {
+	if err.PID == 0 {
+		return fmt.Sprintf("lock %q is held by another process", err.Name)
+	}
+	return fmt.Sprintf("lock %q is held by process %d", err.Name, err.PID)
+}
+
+// Lock is an acquired lock.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire acquires the lock with the given name in dir. If the lock is held by
+// another running process, a *HeldError is returned.
+func Acquire(dir, name string) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("error creating lock directory: %w", err)
+	}
+	path := filepath.Join(dir, name+".lock")
+	for {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("error opening lock %q: %w", name, err)
+		}
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			pid, _ := readPID(f)
+			f.Close()
+			return nil, &HeldError{Name: name, PID: pid}
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("error locking %q: %w", name, err)
+		}
+		// The previous holder removes the file when releasing it, so the
+		// file locked may no longer be the one at path. If so, lock the
+		// file now at path instead.
+		if same, err := samePath(f, path); err != nil || !same {
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("error locking %q: %w", name, err)
+			}
+			continue
+		}
+		if err := writePID(f); err != nil {
+			os.Remove(path)
+			f.Close()
+			return nil, fmt.Errorf("error writing lock %q: %w", name, err)
+		}
+		return &Lock{f: f}, nil
+	}
+}
+
+// AcquireWait is like Acquire, but if the lock is held by another process it
+// polls every interval until the lock is released, or ctx is done. onWait, if
+// not nil, is called once with the first *HeldError encountered.
+func AcquireWait(ctx context.Context, dir, name string, interval time.Duration, onWait func(*HeldError)) (*Lock, error) {
+	waiting := false
+	for {
+		l, err := Acquire(dir, name)
+		var heldErr *HeldError
+		if !errors.As(err, &heldErr) {
+			return l, err
+		}
+		if !waiting && onWait != nil {
+			onWait(heldErr)
+		}
+		waiting = true
+		t := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil, fmt.Errorf("error waiting for %v: %w", heldErr, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	// Remove the file while it is still locked, so that no other process
+	// acquires it between the removal and the unlock.
+	err := os.Remove(l.f.Name())
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("error releasing lock: %w", err)
+	}
+	return nil
+}
+
+// samePath returns true if f is the file at path.
+func samePath(f *os.File, path string) (bool, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	pathInfo, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return os.SameFile(info, pathInfo), nil
+}
+
+func writePID(f *os.File) error {
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := f.WriteAt([]byte(fmt.Sprintf("%d\n", os.Getpid())), 0)
+	return err
+}
+
+// readPID returns the PID written to the lock file f, or 0 if its holder has
+// not written it yet.
+func readPID(f *os.File) (int, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(s)
+}
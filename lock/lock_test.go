@@ -0,0 +1,126 @@
+package lock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Acquire(dir, "example")
+	if err != nil {
+		t.Fatalf("Acquire returned unexpected error: %v, want: nil", err)
+	}
+
+	_, err = Acquire(dir, "example")
+	var heldErr *HeldError
+	if !errors.As(err, &heldErr) {
+		t.Fatalf("Acquire of held lock returned unexpected error: %v, want type: *HeldError", err)
+	}
+	if heldErr.PID != os.Getpid() {
+		t.Errorf("Acquire of held lock returned HeldError.PID: %d, want: %d", heldErr.PID, os.Getpid())
+	}
+
+	if _, err := Acquire(dir, "other"); err != nil {
+		t.Errorf("Acquire of different lock returned unexpected error: %v, want: nil", err)
+	}
+
+	if err := l.Release(); err != nil {
+		t.Fatalf("Release returned unexpected error: %v, want: nil", err)
+	}
+	if _, err := Acquire(dir, "example"); err != nil {
+		t.Errorf("Acquire of released lock returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestAcquire_StaleLock(t *testing.T) {
+	// Find the PID of a process that is no longer running.
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	deadPID := cmd.Process.Pid
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "example.lock")
+	if err := os.WriteFile(path, []byte(fmt.Sprintf("%d\n", deadPID)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(dir, "example"); err != nil {
+		t.Fatalf("Acquire of stale lock returned unexpected error: %v, want: nil", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("%d\n", os.Getpid()); string(data) != want {
+		t.Errorf("Acquire wrote unexpected lock contents: %q, want: %q", data, want)
+	}
+}
+
+func TestAcquire_RemovedWhileLocking(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Acquire(dir, "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "example.lock")
+	// Simulate another process that opened the lock before it was released,
+	// and locks the removed file afterwards.
+	stale, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stale.Close()
+	if err := l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if err := syscall.Flock(int(stale.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(dir, "example"); err != nil {
+		t.Errorf("Acquire returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestAcquireWait(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Acquire(dir, "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waited := make(chan *HeldError, 1)
+	go func() {
+		<-waited
+		l.Release()
+	}()
+	_, err = AcquireWait(context.Background(), dir, "example", time.Millisecond, func(heldErr *HeldError) {
+		waited <- heldErr
+	})
+	if err != nil {
+		t.Fatalf("AcquireWait returned unexpected error: %v, want: nil", err)
+	}
+}
+
+func TestAcquireWait_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Acquire(dir, "example"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := AcquireWait(ctx, dir, "example", time.Millisecond, func(*HeldError) {
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("AcquireWait returned unexpected error: %v, want: %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,288 @@
+// Package logfile implements a log file that rotates itself once it grows too
+// large, e.g. a single rolling log under ~/Library/Logs, for users who want a
+// plain text log rather than os_log.
+//
+// Rotated logs are renamed with the time they were rotated, e.g.
+// offsite-apfs-backup-2021-03-01T20-35-09.000.log, optionally compressed with
+// gzip, and deleted once there are too many of them, or they are too old.
+package logfile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
+)
+
+// DefaultMaxSize is the size in bytes the log grows to before it is rotated by
+// default.
+const DefaultMaxSize = 10 << 20
+
+// DefaultMaxBackups is the number of rotated logs kept by default.
+const DefaultMaxBackups = 5
+
+// rotatedLayout is the layout of the times in the names of rotated logs. It
+// has no colons, which Finder displays as slashes.
+const rotatedLayout = "2006-01-02T15-04-05.000"
+
+// File is a log file that is rotated once it exceeds its maximum size. Each
+// line written to it is prefixed with the time it was written. File is safe
+// for concurrent use.
+type File struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	clock      clock.Clock
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+	// partial is the last line written, if it is not yet complete.
+	partial bytes.Buffer
+}
+
+// Option configures a File.
+type Option func(*File)
+
+// MaxSize returns an Option that rotates the log once writing to it would grow
+// it beyond n bytes. If n is 0, the log is never rotated. Defaults to
+// DefaultMaxSize.
+func MaxSize(n int64) Option {
+	return func(f *File) {
+		f.maxSize = n
+	}
+}
+
+// MaxAge returns an Option that deletes rotated logs once they were rotated
+// more than d ago. If d is 0 (default), rotated logs are kept regardless of
+// age.
+func MaxAge(d time.Duration) Option {
+	return func(f *File) {
+		f.maxAge = d
+	}
+}
+
+// MaxBackups returns an Option that keeps at most n rotated logs, deleting the
+// oldest. If n is 0, rotated logs are kept regardless of how many there are.
+// Defaults to DefaultMaxBackups.
+func MaxBackups(n int) Option {
+	return func(f *File) {
+		f.maxBackups = n
+	}
+}
+
+// Compress returns an Option that compresses rotated logs with gzip.
+func Compress(compress bool) Option {
+	return func(f *File) {
+		f.compress = compress
+	}
+}
+
+// Clock returns an Option that sets the clock used to timestamp lines and name
+// rotated logs. Defaults to the system clock.
+func Clock(clk clock.Clock) Option {
+	return func(f *File) {
+		f.clock = clk
+	}
+}
+
+// Open opens the log at path for appending, creating it and its directory if
+// they do not exist.
+func Open(path string, opts ...Option) (*File, error) {
+	f := &File{
+		path:       path,
+		maxSize:    DefaultMaxSize,
+		maxBackups: DefaultMaxBackups,
+		clock:      clock.Real(),
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("error creating log directory: %w", err)
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *File) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("error opening log: %w", err)
+	}
+	f.f = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write writes each complete line of p to the log, prefixed with the current
+// time. Partial lines are buffered until they are completed by a later Write.
+// If rotating the log fails, the lines are still written, and the error is
+// returned with n = len(p).
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.partial.Write(p)
+	var lines bytes.Buffer
+	now := f.clock.Now().Format(time.RFC3339)
+	for {
+		i := bytes.IndexByte(f.partial.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		fmt.Fprintf(&lines, "%s %s", now, f.partial.Next(i+1))
+	}
+	if lines.Len() == 0 {
+		return len(p), nil
+	}
+	// Lines are written even if rotating fails, to the rotated log if the
+	// new one could not be opened, so that they are never lost.
+	var rotateErr error
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(lines.Len()) > f.maxSize {
+		rotateErr = f.rotate()
+	}
+	n, err := f.f.Write(lines.Bytes())
+	f.size += int64(n)
+	if err != nil {
+		return 0, fmt.Errorf("error writing log: %w", err)
+	}
+	return len(p), rotateErr
+}
+
+// Close closes the log. Partial lines that were never completed are written
+// as is.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.partial.Len() > 0 {
+		fmt.Fprintf(f.f, "%s %s\n", f.clock.Now().Format(time.RFC3339), f.partial.Bytes())
+		f.partial.Reset()
+	}
+	return f.f.Close()
+}
+
+// rotate renames the log with the current time, opens a new, empty log,
+// compresses the rotated log if configured, and deletes old rotated logs. The
+// old log is kept open until the new log is opened, so that f always has an
+// open log to write to, even if rotate fails.
+func (f *File) rotate() error {
+	rotated := f.rotatedPath(f.clock.Now())
+	if err := os.Rename(f.path, rotated); err != nil {
+		return fmt.Errorf("error rotating log: %w", err)
+	}
+	old := f.f
+	if err := f.open(); err != nil {
+		return fmt.Errorf("error rotating log: %w", err)
+	}
+	if err := old.Close(); err != nil {
+		return fmt.Errorf("error rotating log: %w", err)
+	}
+	if f.compress {
+		if err := compress(rotated); err != nil {
+			return fmt.Errorf("error compressing rotated log: %w", err)
+		}
+	}
+	return f.deleteOld()
+}
+
+// rotatedPath returns the path the log is renamed to when rotated at t.
+func (f *File) rotatedPath(t time.Time) string {
+	ext := filepath.Ext(f.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(f.path, ext), t.UTC().Format(rotatedLayout), ext)
+}
+
+// compress replaces the file at path with its gzip compression at path.gz.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err := io.Copy(zw, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// rotatedLog is a rotated log, and when it was rotated.
+type rotatedLog struct {
+	path    string
+	rotated time.Time
+}
+
+// deleteOld deletes the rotated logs beyond MaxBackups, and those older than
+// MaxAge.
+func (f *File) deleteOld() error {
+	logs, err := f.rotatedLogs()
+	if err != nil {
+		return err
+	}
+	now := f.clock.Now()
+	for i, l := range logs {
+		tooMany := f.maxBackups > 0 && i >= f.maxBackups
+		tooOld := f.maxAge > 0 && now.Sub(l.rotated) > f.maxAge
+		if !tooMany && !tooOld {
+			continue
+		}
+		if err := os.Remove(l.path); err != nil {
+			return fmt.Errorf("error deleting rotated log: %w", err)
+		}
+	}
+	return nil
+}
+
+// rotatedLogs returns the rotated logs of the log, newest first.
+func (f *File) rotatedLogs() ([]rotatedLog, error) {
+	ext := filepath.Ext(f.path)
+	prefix := filepath.Base(strings.TrimSuffix(f.path, ext)) + "-"
+	entries, err := os.ReadDir(filepath.Dir(f.path))
+	if err != nil {
+		return nil, fmt.Errorf("error listing rotated logs: %w", err)
+	}
+	var logs []rotatedLog
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".gz")
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		rotated, err := time.Parse(rotatedLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		logs = append(logs, rotatedLog{path: filepath.Join(filepath.Dir(f.path), e.Name()), rotated: rotated})
+	}
+	sort.Slice(logs, func(i, j int) bool {
+		return logs[i].rotated.After(logs[j].rotated)
+	})
+	return logs, nil
+}
@@ -0,0 +1,253 @@
+package logfile
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if filepath.Ext(path) == ".gz" {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// listDir returns the names of the files in dir, sorted.
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Logs", "offsite-apfs-backup.log")
+	clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	f, err := Open(path, Clock(clk))
+	if err != nil {
+		t.Fatalf("Open returned unexpected error: %v, want: nil", err)
+	}
+	for _, w := range []string{"Cloning...\n", "....10", "....20\nCompleted.\n", "partial"} {
+		n, err := f.Write([]byte(w))
+		if err != nil {
+			t.Fatalf("Write(%q) returned unexpected error: %v, want: nil", w, err)
+		}
+		if n != len(w) {
+			t.Errorf("Write(%q) returned n: %d, want: %d", w, n, len(w))
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close returned unexpected error: %v, want: nil", err)
+	}
+	want := "2021-03-01T20:35:09Z Cloning...\n" +
+		"2021-03-01T20:35:09Z ....10....20\n" +
+		"2021-03-01T20:35:09Z Completed.\n" +
+		"2021-03-01T20:35:09Z partial\n"
+	if diff := cmp.Diff(want, readFile(t, path)); diff != "" {
+		t.Errorf("File wrote unexpected log. -want +got:\n%s", diff)
+	}
+}
+
+func TestOpen_Appends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsite-apfs-backup.log")
+	if err := os.WriteFile(path, []byte("earlier\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	f, err := Open(path, Clock(clk))
+	if err != nil {
+		t.Fatalf("Open returned unexpected error: %v, want: nil", err)
+	}
+	f.Write([]byte("later\n"))
+	f.Close()
+	want := "earlier\n2021-03-01T20:35:09Z later\n"
+	if diff := cmp.Diff(want, readFile(t, path)); diff != "" {
+		t.Errorf("File wrote unexpected log. -want +got:\n%s", diff)
+	}
+}
+
+func TestRotate(t *testing.T) {
+	// Each line is 32 bytes, so the log is rotated every 2 lines.
+	const line = "0123456789\n"
+	tests := []struct {
+		name      string
+		opts      []Option
+		lines     int
+		wantFiles []string
+	}{
+		{
+			name:  "not rotated",
+			opts:  []Option{MaxSize(64)},
+			lines: 2,
+			wantFiles: []string{
+				"offsite-apfs-backup.log",
+			},
+		},
+		{
+			name:  "rotated",
+			opts:  []Option{MaxSize(64)},
+			lines: 3,
+			wantFiles: []string{
+				"offsite-apfs-backup-2021-03-01T20-35-12.000.log",
+				"offsite-apfs-backup.log",
+			},
+		},
+		{
+			name:  "compressed",
+			opts:  []Option{MaxSize(64), Compress(true)},
+			lines: 3,
+			wantFiles: []string{
+				"offsite-apfs-backup-2021-03-01T20-35-12.000.log.gz",
+				"offsite-apfs-backup.log",
+			},
+		},
+		{
+			name:  "max backups",
+			opts:  []Option{MaxSize(64), MaxBackups(2)},
+			lines: 7,
+			wantFiles: []string{
+				"offsite-apfs-backup-2021-03-01T20-35-14.000.log",
+				"offsite-apfs-backup-2021-03-01T20-35-16.000.log",
+				"offsite-apfs-backup.log",
+			},
+		},
+		{
+			name:  "max age",
+			opts:  []Option{MaxSize(64), MaxBackups(0), MaxAge(3 * time.Second)},
+			lines: 7,
+			wantFiles: []string{
+				"offsite-apfs-backup-2021-03-01T20-35-14.000.log",
+				"offsite-apfs-backup-2021-03-01T20-35-16.000.log",
+				"offsite-apfs-backup.log",
+			},
+		},
+		{
+			name:  "never rotated",
+			opts:  []Option{MaxSize(0)},
+			lines: 7,
+			wantFiles: []string{
+				"offsite-apfs-backup.log",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "offsite-apfs-backup.log")
+			// Files not rotated from the log are left alone.
+			other := filepath.Join(dir, "offsite-apfs-backup-other.log")
+			if err := os.WriteFile(other, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+			f, err := Open(path, append(test.opts, Clock(clk))...)
+			if err != nil {
+				t.Fatalf("Open returned unexpected error: %v, want: nil", err)
+			}
+			for i := 0; i < test.lines; i++ {
+				clk.Advance(time.Second)
+				if _, err := f.Write([]byte(line)); err != nil {
+					t.Fatalf("Write returned unexpected error: %v, want: nil", err)
+				}
+			}
+			f.Close()
+			want := append([]string{"offsite-apfs-backup-other.log"}, test.wantFiles...)
+			sort.Strings(want)
+			if diff := cmp.Diff(want, listDir(t, dir)); diff != "" {
+				t.Errorf("Unexpected files after rotating. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRotate_KeepsLines(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "offsite-apfs-backup.log")
+	clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	f, err := Open(path, MaxSize(64), Compress(true), Clock(clk))
+	if err != nil {
+		t.Fatalf("Open returned unexpected error: %v, want: nil", err)
+	}
+	for _, w := range []string{"0123456789\n", "abcdefghij\n", "klmnopqrst\n"} {
+		f.Write([]byte(w))
+	}
+	f.Close()
+	rotated := filepath.Join(dir, "offsite-apfs-backup-2021-03-01T20-35-09.000.log.gz")
+	if diff := cmp.Diff("2021-03-01T20:35:09Z 0123456789\n2021-03-01T20:35:09Z abcdefghij\n", readFile(t, rotated)); diff != "" {
+		t.Errorf("Unexpected rotated log. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff("2021-03-01T20:35:09Z klmnopqrst\n", readFile(t, path)); diff != "" {
+		t.Errorf("Unexpected log after rotating. -want +got:\n%s", diff)
+	}
+}
+
+func TestRotate_CompressFails(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "offsite-apfs-backup.log")
+	// A directory in the way of the compressed log makes compressing fail.
+	if err := os.Mkdir(filepath.Join(dir, "offsite-apfs-backup-2021-03-01T20-35-09.000.log.gz"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	clk := fakeclock.New(time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC))
+	f, err := Open(path, MaxSize(64), Compress(true), Clock(clk))
+	if err != nil {
+		t.Fatalf("Open returned unexpected error: %v, want: nil", err)
+	}
+	for _, w := range []string{"0123456789\n", "abcdefghij\n"} {
+		if _, err := f.Write([]byte(w)); err != nil {
+			t.Fatalf("Write(%q) returned unexpected error: %v, want: nil", w, err)
+		}
+	}
+	w := "klmnopqrst\n"
+	n, err := f.Write([]byte(w))
+	if err == nil {
+		t.Errorf("Write(%q) that rotates returned error: nil, want: non-nil", w)
+	}
+	if n != len(w) {
+		t.Errorf("Write(%q) that rotates returned n: %d, want: %d", w, n, len(w))
+	}
+	w = "uvwxyz\n"
+	if _, err := f.Write([]byte(w)); err != nil {
+		t.Errorf("Write(%q) after failed rotation returned unexpected error: %v, want: nil", w, err)
+	}
+	f.Close()
+	rotated := filepath.Join(dir, "offsite-apfs-backup-2021-03-01T20-35-09.000.log")
+	if diff := cmp.Diff("2021-03-01T20:35:09Z 0123456789\n2021-03-01T20:35:09Z abcdefghij\n", readFile(t, rotated)); diff != "" {
+		t.Errorf("Unexpected rotated log. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff("2021-03-01T20:35:09Z klmnopqrst\n2021-03-01T20:35:09Z uvwxyz\n", readFile(t, path)); diff != "" {
+		t.Errorf("Unexpected log after failed rotation. -want +got:\n%s", diff)
+	}
+}
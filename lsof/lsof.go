@@ -0,0 +1,111 @@
+// Package lsof implements listing the processes that have files open on a
+// volume using lsof, e.g. to explain why the volume could not be ejected.
+package lsof
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Process is a process with files open.
+type Process struct {
+	PID     int
+	Command string
+}
+
+// String returns e.g. "mds_stores (pid 123)".
+func (p Process) String() string {
+	return fmt.Sprintf("%s (pid %d)", p.Command, p.PID)
+}
+
+// LSOF lists open files. lsof is killed if ctx is done before it exits.
+type LSOF interface {
+	// OpenOn returns the processes with files open on the volume mounted
+	// at mountPoint, e.g. Spotlight's mds_stores or antivirus software.
+	OpenOn(ctx context.Context, mountPoint string) ([]Process, error)
+}
+
+type lsof struct {
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+type option func(*lsof)
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) option {
+	return func(l *lsof) {
+		l.execCommand = f
+	}
+}
+
+// New returns a new LSOF.
+func New(opts ...option) LSOF {
+	l := lsof{
+		execCommand: exec.CommandContext,
+	}
+	for _, opt := range opts {
+		opt(&l)
+	}
+	return l
+}
+
+func (l lsof) OpenOn(ctx context.Context, mountPoint string) ([]Process, error) {
+	// -F pc prints each process as a line of its PID prefixed with p,
+	// followed by a line of its command prefixed with c. +f -- treats
+	// mountPoint as a file system, listing every file open on it.
+	cmd := l.execCommand(ctx, "lsof", "-w", "-F", "pc", "+f", "--", mountPoint)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		// lsof exits with status 1, printing nothing, if no files are
+		// open.
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 && len(stdout) == 0 && stderr.Len() == 0 {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return parse(stdout)
+}
+
+// parse parses the output of lsof -F pc.
+func parse(out []byte) ([]Process, error) {
+	var procs []Process
+	s := bufio.NewScanner(bytes.NewReader(out))
+	for s.Scan() {
+		line := s.Text()
+		if line == "" {
+			continue
+		}
+		switch field, value := line[0], line[1:]; field {
+		case 'p':
+			pid, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid lsof pid %q: %w", value, err)
+			}
+			procs = append(procs, Process{PID: pid})
+		case 'c':
+			if len(procs) == 0 {
+				return nil, fmt.Errorf("lsof printed command %q before its pid", value)
+			}
+			procs[len(procs)-1].Command = value
+		}
+	}
+	return procs, s.Err()
+}
+
+// Join returns procs as a comma separated list, e.g. "mds_stores (pid 123),
+// bztransmit (pid 456)".
+func Join(procs []Process) string {
+	s := make([]string, len(procs))
+	for i, p := range procs {
+		s[i] = p.String()
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,117 @@
+package lsof
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) LSOF {
+	return New(withExecCommand(fakecmd.FakeCommandContext(t, opts...)))
+}
+
+func TestOpenOn(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []fakecmd.Option
+		want []Process
+	}{
+		{
+			name: "open files",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("lsof", "p123\ncmds_stores\np456\ncbztransmit\n"),
+			},
+			want: []Process{
+				{PID: 123, Command: "mds_stores"},
+				{PID: 456, Command: "bztransmit"},
+			},
+		},
+		{
+			name: "no open files",
+			opts: []fakecmd.Option{
+				fakecmd.ExitFail("lsof"),
+			},
+			want: nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append(test.opts,
+				fakecmd.WantArg("lsof", "+f"),
+				fakecmd.WantArg("lsof", "/Volumes/target"),
+			)
+			l := newWithFakeCmd(t, opts...)
+			got, err := l.OpenOn(context.Background(), "/Volumes/target")
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err != nil {
+				t.Fatalf("OpenOn returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("OpenOn returned unexpected processes. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestOpenOn_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []fakecmd.Option
+		wantExitErr bool
+	}{
+		{
+			name: "lsof fails",
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("lsof", "example stderr"),
+				fakecmd.ExitFail("lsof"),
+			},
+			wantExitErr: true,
+		},
+		{
+			name: "invalid pid",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("lsof", "pnot-a-pid\ncmds_stores\n"),
+			},
+		},
+		{
+			name: "command without pid",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("lsof", "cmds_stores\n"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l := newWithFakeCmd(t, test.opts...)
+			_, err := l.OpenOn(context.Background(), "/Volumes/target")
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Fatal("OpenOn returned unexpected error: nil, want: non-nil")
+			}
+			var exitErr *exec.ExitError
+			if test.wantExitErr && !errors.As(err, &exitErr) {
+				t.Errorf("OpenOn returned unexpected error: %v, want type: *exec.ExitError", err)
+			}
+		})
+	}
+}
+
+func TestJoin(t *testing.T) {
+	got := Join([]Process{{PID: 123, Command: "mds_stores"}, {PID: 456, Command: "bztransmit"}})
+	if want := "mds_stores (pid 123), bztransmit (pid 456)"; got != want {
+		t.Errorf("Join returned %q, want: %q", got, want)
+	}
+}
@@ -3,41 +3,215 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/asr"
+	"github.com/voidingwarranties/offsite-apfs-backup/audit"
+	"github.com/voidingwarranties/offsite-apfs-backup/cliio"
+	"github.com/voidingwarranties/offsite-apfs-backup/clock"
 	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diagnose"
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/estimate"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
+	"github.com/voidingwarranties/offsite-apfs-backup/keychain"
+	"github.com/voidingwarranties/offsite-apfs-backup/localauth"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+	"github.com/voidingwarranties/offsite-apfs-backup/logfile"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+	"github.com/voidingwarranties/offsite-apfs-backup/policy"
+	"github.com/voidingwarranties/offsite-apfs-backup/rusage"
+	"github.com/voidingwarranties/offsite-apfs-backup/snapshotdiff"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
 )
 
 var (
 	prune = flag.Bool("prune", false, `If true, prune from target the latest snapshot that source and target had in common before the clone.
 If false (default), no snapshots are removed from target.
-Incompatible with -initialize.`)
+Initialized targets have no previous snapshot in common, so nothing is pruned from them.`)
 	initialize = flag.Bool("initialize", false, `If true, initialize targets to the latest snapshot in source. All data on targets will be lost.
 Set -initialize to true when first setting up an off-site backup volume.
 If false (default), nondestructively clone the latest APFS snapshot in source to targets using the latest snapshot in common.
-Incompatible with -prune.`)
-	dryrun = flag.Bool("dryrun", false, `If true, only print the changes that would have been made to targets.
-Does not modify targets in any way.`)
+The snapshot targets are initialized to is recorded in the state file as their baseline.`)
+	keep = flag.Int("keep", 0, `If positive, prune every snapshot from targets except the newest <keep> after each clone, instead of only the snapshot in common before the clone.
+Implies -prune.`)
+	pruneSource = flag.Bool("prune-source", false, `If true, after every target is cloned to successfully, prune from source the snapshots it had in common with targets before the clone.
+Skipped if source has paired targets that were not cloned to, which may still need the snapshots.`)
+	verifyBeforePrune = flag.Bool("verify-before-prune", false, `If true, verify that the latest snapshot in targets is the latest snapshot in source before pruning them.
+Targets that fail verification are not pruned, and their clones fail.`)
+	dryrun = flag.Bool("dryrun", false, `If true, only print the plan of the clone: for each target, the snapshot it would be restored from, about how much would be copied, and the snapshots that would be pruned.
+With -only, the commands each phase would run are printed instead. Does not modify targets in any way.`)
+	only = flag.String("only", "", `Comma-separated list of phases to run, for debugging and manual recovery. Phases are:
+  preflight: check that source is cloneable to targets.
+  restore: restore targets to the latest snapshot in source.
+  verify: check that the latest snapshot in targets is the latest snapshot in source.
+  prune: delete from targets the snapshot in common before the latest snapshot in source.
+If empty (default), run preflight and restore, and prune if -prune is true.`)
+	wait = flag.Bool("wait", false, `If true, wait for other invocations using the same source or targets to finish.
+If false (default), exit with an error if another invocation is using the source or any of the targets.`)
+	globalLock = flag.Bool("global-lock", false, `If true, also wait for (or fail because of) any other invocation that sets -global-lock, regardless of the volumes it is using.`)
+	statePath  = flag.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	touchID    = flag.Bool("touch-id", false, `If true, confirm destructive operations with Touch ID instead of by typing at a prompt.
+Fails if Touch ID is unavailable.`)
+	label = flag.String("label", "", `Name of the backup routine this run belongs to, e.g. weekly-offsite.
+Recorded with the run in the state file and audit log, to filter history by routine.`)
+	auditPath = flag.String("audit-log", audit.DefaultPath, `Path to the append-only log of renames, snapshot deletions, erases, and destructive restores.`)
+	container = flag.Bool("container", false, `If true, clone every volume in source's APFS container to the volume of the same name in target's APFS container.
+Target volumes that do not exist are created, with the quota and reserve sizes of their source volumes, and initialized.
+Requires exactly one <target volume>, which may be any volume in the target container. Incompatible with -only.`)
+	noPLUtil    = flag.Bool("no-plutil", false, `Deprecated: has no effect, since plutil is never run.`)
+	launchdMode = flag.Bool("launchd", false, `If true, run as a launchd job: never prompt for confirmation, wait for other invocations, and skip targets that are not attached.
+Exits with 75 (EX_TEMPFAIL) if source is not attached, and 78 (EX_CONFIG) if flags are invalid.`)
+	configPath    = flag.String("config", config.DefaultPath, `Path to the JSON configuration file naming the backup sets cloned by run.`)
+	jsonErrors    = flag.Bool("json-errors", false, `If true, also print the error that fails the run as a single line of JSON on stderr, after the human-readable output, for tools that only capture stderr. See "schema error" for its JSON Schema.`)
+	explainErrors = flag.Bool("explain", false, `If true, print the likely causes of errors, and how to fix them.
+If false (default), print the error code to look up with explain.`)
+	assumeYes = flag.Bool("yes", false, `If true, do not ask for confirmation before modifying targets, e.g. when running from cron.
+If false (default), confirmation is asked for at the terminal, and the clone fails if stdin is not a terminal.`)
+	healthInterval = flag.Duration("health-interval", time.Minute, `Interval at which to sample the I/O error counters and temperature of targets' disks during restores.
+Increased error counters and temperatures above 60°C are warned about, and the samples are summarized after each restore.
+If 0, disks are not monitored.`)
+	planPath = flag.String("plan", "", `If set, write the plan of the clone as JSON to <path> after preflight checks, e.g. with -dryrun.
+Compare plans with "plan diff".`)
+	toSnapshot = flag.String("to-snapshot", "", `Name or UUID of the source snapshot to clone, e.g. to ship a known-good snapshot when the latest is known to be bad.
+If empty (default), the latest source snapshot is cloned. Newer source snapshots are ignored.`)
+	snapshotBeforeClone = flag.Bool("snapshot-before-clone", false, `If true, create a local snapshot of source with tmutil immediately before cloning, so that targets are cloned to as fresh a snapshot as possible.`)
+	eject               = flag.Bool("eject", false, `If true, eject targets after they are cloned to, so that they are safe to unplug.
+Ejects that fail because files are held open on a target, e.g. by Spotlight or antivirus software, are retried, and the processes holding them are printed.`)
+	maxRuntime = flag.Duration("max-runtime", 0, `If set, the longest the run may clone for, e.g. to finish before leaving with targets.
+Once exceeded, the clone in progress is finished, remaining targets are skipped and reported as deferred, and the run exits with 3.
+Restores are not retried if waiting to retry them would exceed it.
+If 0 (default), the run is not limited.`)
+	preferNewestCommon = flag.Bool("prefer-newest-common", false, `If true, restore targets from the newest snapshot they have in common with source, ordered by XID if the timestamps in snapshot names disagree, instead of from the latest common snapshot in target.
+This minimizes how much asr transfers when snapshots are listed out of order, e.g. because another tool's snapshot names are parsed with the wrong timestamps. Plans show which snapshot was chosen and why.`)
+	metricsTextfile = flag.String("metrics-textfile", "", `If set, write the metrics of every paired target to <path> in the Prometheus text format after cloning, for node_exporter's textfile collector, e.g. /usr/local/var/node_exporter/offsite-apfs-backup.prom.`)
+	logFile         = flag.String("log-file", "", `If set, also log clones to <path>, e.g. ~/Library/Logs/offsite-apfs-backup.log, rotating it once it grows too large as configured by the config file's log section.
+Overrides the config file's log file.`)
+	noColor     = flag.Bool("no-color", false, `If true, never color output. Output is only colored when it is a terminal, and NO_COLOR is not set.`)
+	asrAttempts = flag.Int("asr-attempts", 3, `Most times to attempt each restore. Restores that fail with transient asr errors, e.g. "Resource busy", are retried until they succeed or have been attempted <asr-attempts> times.
+If 1, restores are never retried.`)
+	asrBackoff = flag.Duration("asr-backoff", 30*time.Second, `How long to wait before retrying a restore that failed with a transient asr error. The wait doubles before each further retry.`)
+	verbose    = flag.Bool("v", false, `If true, also print every diskutil and asr command before it is run.`)
+	quiet      = flag.Bool("q", false, `If true, only print a one-line result for each target, and errors. Other output is still logged.
+Incompatible with -v.`)
+	strict = flag.Bool("strict", false, `If true, fail if preflight checks warn about anything, e.g. a stale source snapshot or a target on the same physical disk as source.
+If false (default), warnings are printed before asking for confirmation.`)
 )
 
+// version is the version of the binary, set at build time by the release
+// command.
+var version = "dev"
+
+// clk and runIDs are the sources of the current time and of IDs identifying
+// each run.
+var (
+	clk    clock.Clock       = clock.Real()
+	runIDs clock.IDGenerator = clock.RandomIDs()
+)
+
+// stdin is where responses to prompts are read from, and stdinIsTerminal
+// returns whether it is a terminal.
+var (
+	stdin           io.Reader = os.Stdin
+	stdinIsTerminal           = func() bool { return cliio.IsTerminal(os.Stdin) }
+)
+
+// logger logs clone activity to os_log, in addition to the output printed to
+// the terminal.
+var logger = oslog.New("clone")
+
+// commands maps subcommand names to their implementations. If the first
+// argument is not a subcommand name, the arguments are handled as a clone,
+// as if by the clone subcommand.
+var commands = map[string]func(args []string) error{
+	"advise":         advise,
+	"audit":          showAudit,
+	"batch":          batch,
+	"bench-asr":      benchASR,
+	"catalog":        showCatalog,
+	"clone":          cloneCommand,
+	"completion":     completion,
+	"diagnose":       diagnoseCommand,
+	"estimate":       estimateCommand,
+	"explain":        explainCode,
+	"install-agent":  installAgent,
+	"list-snapshots": listSnapshots,
+	"list-targets":   listTargets,
+	"migrate-source": migrateSource,
+	"mount":          mount,
+	"plan":           planCommand,
+	"restore":        restoreCommand,
+	"retire":         retire,
+	"run":            runSet,
+	"runbook":        runbook,
+	"schedule":       schedule,
+	"schema":         printSchema,
+	"state":          stateCommand,
+	"status":         status,
+	"unmount":        unmount,
+	"verify":         verify,
+	"version":        printVersion,
+	"watch":          watchCommand,
+}
+
 func init() {
+	flag.BoolVar(assumeYes, "force", false, `Alias of -yes.`)
 	flag.Usage = func() {
-		fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [-prune] [-initialize] [-dryrun] [--] <source volume> <target volume> [<target volume>...]
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage: %s [clone] [-prune] [-keep <n>] [-prune-source] [-verify-before-prune] [-initialize] [-dryrun] [-plan <path>] [-only <phases>] [-wait] [-global-lock] [-state <path>] [-audit-log <path>] [-label <label>] [-touch-id] [-yes] [-container] [-strict] [-health-interval <duration>] [-max-runtime <duration>] [-to-snapshot <snapshot>] [-snapshot-before-clone] [-eject] [-log-file <path>] [-asr-attempts <n>] [-asr-backoff <duration>] [-v | -q] [-no-color] [-launchd] [-explain] [-json-errors] [--] <source volume> <target volume> [<target volume>...]
+       %[1]s run [<flags>] <backup set>
+       %[1]s restore [<flags>] <offsite volume> <local volume>
+       %[1]s verify [-path <path>]... [-hash <algorithm>] <source volume> <target volume> [<target volume>...]
+       %[1]s verify -from-last-run [-state <path>] [-path <path>]... [-hash <algorithm>]
+       %[1]s list-snapshots [-state <path>] [-config <path>] <volume>
+       %[1]s list-targets [-initialize] <source volume>
+       %[1]s estimate [-state <path>] [-config <path>] <source volume> <target volume>
+       %[1]s status [-state <path>] [-config <path>] [-notify] [-json]
+       %[1]s advise [-state <path>] [-config <path>]
+       %[1]s plan diff <before plan> <after plan>
+       %[1]s schema <format>
+       %[1]s bench-asr [-dir <path>] [-size <size>] [-config <path>] [-dryrun]
+       %[1]s batch [-wait] [-global-lock] [-strict] [-state <path>] [-audit-log <path>]
+       %[1]s retire [-erase] [-touch-id] [-state <path>] [-audit-log <path>] <target volume>
+       %[1]s migrate-source [-dryrun] [-state <path>] [-config <path>] <old source volume> <new source volume>
+       %[1]s state migrate [-dryrun] [-state <path>] [<target volume>...]
+       %[1]s mount [-read-only] [-state <path>] <target volume>
+       %[1]s unmount [-state <path>] <target volume>
+       %[1]s schedule install|uninstall [<flags>] ...
+       %[1]s watch [-interval <duration>] [-config <path>] [-state <path>] [-metrics-listen <address>] [-dryrun]
+       %[1]s install-agent [-label <label>] [-interval <duration>] [-on-mount] [-log <path>] [-config <path>] [-state <path>] <backup set>
+       %[1]s runbook [-state <path>]
+       %[1]s catalog [-state <path>] [-label <label>] [-target <target>] [-config <path>] [-verbose]
+       %[1]s audit [-audit-log <path>] [-volume <uuid>] [-run <id>] [-label <label>] [-operation <operation>] [-since <duration>] [-json] [-config <path>]
+       %[1]s diagnose [-performance] [-state <path>] [-o <path>]
+       %[1]s explain [<error code>]
+       %[1]s completion bash|zsh
+       %[1]s version
 
   <source volume>
     	Source APFS volume to clone.
     	May be a mount point, /dev/ path, or volume UUID.
+    	May also be the mount point of a snapshot, e.g. a mounted Time Machine snapshot, to clone up to that snapshot.
   <target volume>
     	Target APFS volume(s) to clone to.
     	May be specified multiple times.
     	May be a mount point, /dev/ path, or volume UUID.
+  <backup set>
+    	Name of a backup set in the -config file, naming a source volume and its targets.
+  <offsite volume> <local volume>
+    	Target APFS volume to restore from, and the APFS volume to restore to, e.g. the original source.
+    	May be mount points, /dev/ paths, or volume UUIDs.
 `, os.Args[0])
 		flag.CommandLine.PrintDefaults()
 	}
@@ -55,57 +229,1058 @@ func (f *targetsFlag) Set(value string) error {
 }
 
 func main() {
-	flag.Parse()
+	state.ToolVersion = version
+	cmd, args, err := dispatch(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+		printJSONError(err, "")
+		flag.Usage()
+		os.Exit(errExitCode(err))
+	}
+	if err := cmd(args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		printJSONError(err, "")
+		os.Exit(errExitCode(err))
+	}
+}
+
+// dispatch returns the command that handles args, and the arguments to pass
+// it. Cloning volumes is the default command, so that "clone" may be omitted,
+// but a first argument that is neither a subcommand, a flag, nor a volume is
+// rejected as an unknown subcommand, rather than cloned from as a source
+// volume, e.g. a misspelled subcommand.
+func dispatch(args []string) (cmd func(args []string) error, cmdArgs []string, err error) {
+	if len(args) == 0 {
+		return cloneCommand, args, nil
+	}
+	if cmd, ok := commands[args[0]]; ok {
+		return cmd, args[1:], nil
+	}
+	if strings.HasPrefix(args[0], "-") || volumeRE.MatchString(args[0]) {
+		return cloneCommand, args, nil
+	}
+	return nil, nil, usageErrorf("unknown command %q", args[0])
+}
+
+// volumeRE matches the ways volumes may be given: mount points, /dev/ paths,
+// disk identifiers, and volume UUIDs.
+var volumeRE = regexp.MustCompile(`/|^disk[0-9]+(s[0-9]+)*$|^[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}$`)
+
+// cloneCommand clones the source volume to the target volumes given as
+// arguments.
+func cloneCommand(args []string) error {
+	flag.CommandLine.Parse(args)
 	source, targets, err := parseArguments()
 	if err != nil {
 		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+		printJSONError(err, "")
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitCode(exitConfig))
+	}
+	cloneVolumes(source, targets)
+	return nil
+}
+
+// runSet clones the backup set named by the only argument, using the same
+// flags as cloning volumes.
+func runSet(args []string) error {
+	ctx := context.Background()
+	flag.CommandLine.Parse(args)
+	if flag.NArg() != 1 {
+		fmt.Fprintln(flag.CommandLine.Output(), "Error: exactly one <backup set> is required")
+		flag.Usage()
+		os.Exit(exitCode(exitConfig))
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	set, err := cfg.Set(flag.Arg(0))
+	if err != nil {
+		return usageError{err}
+	}
+	filter, err := set.Filter()
+	if err != nil {
+		return err
+	}
+	maxAge, err := set.MaxAge()
+	if err != nil {
+		return err
+	}
+	var opts []cloner.Option
+	if filter != nil {
+		opts = append(opts, cloner.SnapshotFilter(filter))
+	}
+	if maxAge > 0 {
+		opts = append(opts, cloner.MaxSnapshotAge(maxAge))
+	}
+	if set.MinKeep > 0 {
+		opts = append(opts, cloner.MinKeep(set.MinKeep))
 	}
+	if set.ReservePercent > 0 {
+		opts = append(opts, cloner.Reserve(set.ReservePercent))
+	}
+	if *container && len(opts) > 0 {
+		return usageErrorf("-container cannot clone set %q, which restricts the snapshots to clone or keep", set.Name)
+	}
+	*prune = *prune || set.Prune
+	*pruneSource = *pruneSource || set.PruneSource
+	*initialize = *initialize || set.Initialize
+	*dryrun = *dryrun || set.DryRun
+	// Scheduled runs wait for volumes to be attached instead.
+	if !*launchdMode && !*container {
+		if err := checkSetVolumes(ctx, newDiskUtil(), set); err != nil {
+			return err
+		}
+	}
+	// Runs of a set are labeled with its name, unless labeled otherwise.
+	if *label == "" {
+		*label = set.Name
+	}
+	cloneVolumes(set.Source, set.Targets, clonerOptions(opts...))
+	return nil
+}
+
+// checkSetVolumes returns an error if any of set's volumes are unknown, e.g.
+// because they are not attached or their UUIDs in the config file are wrong.
+func checkSetVolumes(ctx context.Context, du diskutil.DiskUtil, set config.Set) error {
+	var unknown []string
+	for _, v := range append([]string{set.Source}, set.Targets...) {
+		if _, err := du.Info(ctx, v); err != nil {
+			unknown = append(unknown, strconv.Quote(v))
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("backup set %q names unknown volumes %s: check that they are attached, and that the config file %q names them correctly", set.Name, strings.Join(unknown, ", "), *configPath)
+	}
+	return nil
+}
+
+// runConfig configures a run of cloneVolumes beyond its flags.
+type runConfig struct {
+	clonerOpts []cloner.Option
+	// record is true if completed clones are recorded in the state file and
+	// metrics.
+	record bool
+	// history is true if clones are recorded in the history file of each
+	// target.
+	history bool
+	// eject is true if -eject ejects the targets cloned to.
+	eject bool
+	// policy is true if the policy file must allow the targets.
+	policy bool
+}
+
+// runOption configures a run of cloneVolumes.
+type runOption func(*runConfig)
+
+func newRunConfig(opts ...runOption) runConfig {
+	cfg := runConfig{
+		record:  true,
+		history: true,
+		eject:   true,
+		policy:  true,
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return cfg
+}
+
+// clonerOptions returns a runOption that adds opts to the options of the
+// cloner, e.g. the options of a backup set.
+func clonerOptions(opts ...cloner.Option) runOption {
+	return func(cfg *runConfig) {
+		cfg.clonerOpts = append(cfg.clonerOpts, opts...)
+	}
+}
+
+// recordRun returns a runOption that sets whether completed clones are
+// recorded in the state file and metrics. Defaults to true.
+func recordRun(record bool) runOption {
+	return func(cfg *runConfig) {
+		cfg.record = record
+	}
+}
+
+// targetHistory returns a runOption that sets whether clones are recorded in
+// the history file of each target, unless -dryrun. Defaults to true.
+func targetHistory(history bool) runOption {
+	return func(cfg *runConfig) {
+		cfg.history = history
+	}
+}
+
+// ejectTargets returns a runOption that sets whether -eject ejects the targets
+// cloned to. Defaults to true.
+func ejectTargets(eject bool) runOption {
+	return func(cfg *runConfig) {
+		cfg.eject = eject
+	}
+}
+
+// targetPolicy returns a runOption that sets whether the policy file must allow
+// the targets. Defaults to true.
+func targetPolicy(policy bool) runOption {
+	return func(cfg *runConfig) {
+		cfg.policy = policy
+	}
+}
+
+// cloneVolumes clones source to targets as configured by flags and opts,
+// exiting on failure.
+func cloneVolumes(source string, targets []string, opts ...runOption) {
+	ctx := context.Background()
+	cfg := newRunConfig(opts...)
+	// out is where progress is printed, and errOut where errors are.
+	out := cliio.Highlight(progressOutput(), colors(os.Stdout))
+	errOut := cliio.Highlight(os.Stderr, colors(os.Stderr))
 	if err := validateFlags(targets); err != nil {
 		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+		printJSONError(err, "")
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitCode(exitConfig))
+	}
+
+	if err := mirrorLog(); err != nil {
+		fmt.Fprintln(errOut, "Warning: not logging to file:", err)
+	}
+
+	if *launchdMode {
+		// Jobs run whenever any volume is mounted, or at intervals, so
+		// targets are often not attached.
+		var err error
+		targets, err = attachedTargets(ctx, newDiskUtil(), source, targets, launchdVolumeWait)
+		if err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			logger.Log(oslog.Error, "%v", err)
+			os.Exit(exitTempFail)
+		}
+		if len(targets) == 0 {
+			fmt.Println("No targets are attached; nothing to clone.")
+			return
+		}
+	}
+
+	// A source that is a mounted snapshot, e.g. a Time Machine snapshot, is
+	// cloned from its volume, up to that snapshot.
+	if snap, ok, err := diskutil.SnapshotMountedAt(source); err == nil && ok {
+		if *container {
+			err := fmt.Errorf("-container cannot clone from mounted snapshot %q", source)
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			os.Exit(exitCode(exitConfig))
+		}
+		if *snapshotBeforeClone {
+			err := fmt.Errorf("-snapshot-before-clone cannot snapshot mounted snapshot %q", source)
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			os.Exit(exitCode(exitConfig))
+		}
+		if *toSnapshot != "" {
+			err := fmt.Errorf("-to-snapshot cannot be given with mounted snapshot %q, which is cloned up to", source)
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			os.Exit(exitCode(exitConfig))
+		}
+		fmt.Printf("Source %q is snapshot %q of %s; cloning up to that snapshot.\n", source, snap.Snapshot, snap.Device)
+		source = snap.Device
+		cfg.clonerOpts = append(cfg.clonerOpts, cloner.ToSnapshot(snap.Snapshot))
+	}
+
+	if *toSnapshot != "" {
+		cfg.clonerOpts = append(cfg.clonerOpts, cloner.ToSnapshot(*toSnapshot))
+	}
+
+	if *container {
+		if err := cloneContainer(ctx, source, targets[0]); err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+			printExplanation(errOut, err)
+			printJSONError(err, "")
+			os.Exit(errExitCode(err))
+		}
+		return
+	}
+
+	if *snapshotBeforeClone {
+		if err := snapshotSource(ctx, os.Stdout, newDiskUtil(), source); err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			logger.Log(oslog.Error, "%v", err)
+			os.Exit(errExitCode(err))
+		}
+	}
+
+	targets, priorities, err := prioritizeTargets(ctx, out, newDiskUtil(), targets)
+	if err != nil {
+		fmt.Fprintln(errOut, "Error:", err)
+		printJSONError(err, "")
+		os.Exit(exitCode(exitConfig))
 	}
 
 	// Indent the stdout of cloner, diskutil, and asr with a single tab, to
 	// help separate different clones to different targets.
-	stdout := newPrefixWriter([]byte("\t"), os.Stdout)
-	du := diskutil.New()
-	var r asr.ASR = asr.New(asr.Stdout(stdout))
+	stdout := newPrefixWriter([]byte("\t"), io.MultiWriter(out, logger))
+	tracker := estimate.NewTracker(len(targets), estimate.Clock(clk))
+	// asrUsage accumulates the resources used by asr during the current
+	// clone.
+	var asrUsage rusage.Usage
+	asrOpts := append(cloneASROptions(), asr.ResourceUsage(func(u rusage.Usage) {
+		asrUsage = asrUsage.Add(u)
+	}))
+	// When stdout is a terminal, render asr's progress as a live progress
+	// bar, and only log its raw output.
+	var bar *progressBar
+	if !*dryrun && !*quiet && cliio.IsTerminal(os.Stdout) {
+		bar = &progressBar{w: os.Stdout}
+		asrOpts = append(asrOpts,
+			asr.Stdout(newPrefixWriter([]byte("\t"), logger)),
+			asr.Progress(func(percent int) {
+				tracker.Progress(percent)
+				status, _ := remaining(tracker)
+				bar.update(percent, status)
+			}),
+		)
+	} else {
+		asrOpts = append(asrOpts,
+			asr.Stdout(stdout),
+			asr.Progress(func(percent int) {
+				tracker.Progress(percent)
+				printRemaining(stdout, tracker)
+			}),
+		)
+	}
+	runID := runIDs.NewID()
+	du := newDiskUtil()
+	var r asr.ASR = asr.New(asrOpts...)
 	if *dryrun {
 		du = diskutil.NewDryRun(du)
-		r = asr.NewDryRun(asr.Stdout(stdout))
+		r = asr.NewDryRun(asrOpts...)
+	} else {
+		du, r = auditTools(du, r, *auditPath, runID, *label)
 	}
-	c := cloner.New(
-		du, r,
+	preflight, phases, _ := parseOnly()
+	// phaseTimes records the durations of the phases of the current clone,
+	// and retries the number of times its restore was retried.
+	var phaseTimes map[string]time.Duration
+	var retries int
+	clonerOpts := append(cloneClonerOptions(stdout, cfg.history && !*dryrun),
 		cloner.Prune(*prune),
+		cloner.Keep(*keep),
+		cloner.PruneSource(*pruneSource),
+		cloner.VerifyBeforePrune(*verifyBeforePrune),
 		cloner.InitializeTargets(*initialize),
-		cloner.Stdout(stdout),
+		cloner.PhaseTimes(func(p cloner.Phase, d time.Duration) {
+			phaseTimes[string(p)] = d
+		}),
+		cloner.Retried(func(n int) {
+			retries += n
+		}),
 	)
-	if err := c.Cloneable(source, targets...); err != nil {
-		fmt.Fprintln(os.Stderr, "Error:", err)
-		os.Exit(1)
+	if phases != nil {
+		clonerOpts = append(clonerOpts, cloner.Only(phases...))
+	}
+	c := cloner.New(du, r, append(clonerOpts, cfg.clonerOpts...)...)
+	release, err := prepareTargets(ctx, out, du, keychain.New(), source, targets, prepareOptions{
+		lockDir:    lock.DefaultDir,
+		globalLock: *globalLock,
+		wait:       *wait || *launchdMode,
+		// Dry runs do not unlock volumes, as they only print changes.
+		unlock: !*dryrun,
+		prompt: !*launchdMode && stdinIsTerminal(),
+		policy: cfg.policy,
+	})
+	if err != nil {
+		fmt.Fprintln(errOut, "Error:", err)
+		printExplanation(errOut, err)
+		printJSONError(err, "")
+		os.Exit(errExitCode(err))
 	}
-	if !*dryrun {
-		if err := confirm(source, targets); err != nil {
+	defer release()
+	// plan is only set if preflight checks run. Clones then reuse the
+	// volumes and snapshots resolved by preflight, so that the snapshots
+	// confirmed by the user are the snapshots cloned.
+	var plan *cloner.Plan
+	if preflight {
+		p, err := c.Preflight(ctx, source, targets...)
+		if err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+			printExplanation(errOut, err)
+			printJSONError(err, "")
+			release()
+			os.Exit(exitPreflight)
+		}
+		plan = &p
+		if *planPath != "" {
+			if err := writePlan(*planPath, c, p, priorities); err != nil {
+				fmt.Fprintln(errOut, "Error:", err)
+				printJSONError(err, "")
+				release()
+				os.Exit(errExitCode(err))
+			}
+		}
+		warnings, err := preflightWarnings(*statePath, p)
+		if err == nil {
+			err = checkWarnings(errOut, warnings, *strict)
+		}
+		if err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			release()
+			os.Exit(exitPreflight)
+		}
+	}
+	if phases != nil && len(phases) == 0 {
+		fmt.Println("Preflight checks passed.")
+		return
+	}
+	// Dry runs of whole clones print the plan for review, rather than the
+	// commands each phase would run.
+	if *dryrun && plan != nil && phases == nil {
+		if err := printPlan(c, *plan, priorities); err != nil {
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			release()
+			os.Exit(errExitCode(err))
+		}
+		if *pruneSource {
+			fmt.Println("Then prune source of the snapshots no longer needed by any target.")
+		}
+		return
+	}
+	restore := phases == nil || containsPhase(phases, cloner.PhaseRestore)
+	if restore && !*quiet {
+		printEstimates(ctx, du, targets)
+	}
+	destructive := restore || containsPhase(phases, cloner.PhasePrune)
+	if !*dryrun && !*launchdMode && destructive {
+		if err := confirm(source, targets, restore); err != nil {
 			fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
-			os.Exit(1)
+			printExplanation(flag.CommandLine.Output(), err)
+			printJSONError(err, "")
+			release()
+			os.Exit(errExitCode(err))
 		}
 	}
 
 	errs := make(map[string]error) // Map of target volume to clone error.
-	for _, target := range targets {
-		fmt.Printf("Cloning %q to %q...\n", source, target)
-		if err := c.Clone(source, target); err != nil {
+	var clones []clone
+	var deferred []string
+	// The runtime budget starts once cloning starts, so that waiting for
+	// locks or confirmation does not count against it.
+	cloneStarted := clk.Now()
+	// Restores are not retried once the budget is exceeded, as the targets
+	// after them would be deferred anyway.
+	cloneCtx := ctx
+	if *maxRuntime > 0 {
+		cloneCtx = asr.WithRetryDeadline(ctx, cloneStarted.Add(*maxRuntime))
+	}
+	deferred = cloneWithin(clk, cloneStarted, *maxRuntime, targets, func(target string) {
+		fmt.Fprintf(out, "Cloning %q to %q...\n", source, target)
+		logger.Log(oslog.Default, "Cloning %q to %q (%s)", source, target, describeRun(runID, *label))
+		started := clk.Now()
+		phaseTimes = make(map[string]time.Duration)
+		retries = 0
+		asrUsage = rusage.Usage{}
+		toolBefore, _ := rusage.Self()
+		tracker.Start()
+		var interval time.Duration
+		if restore && !*dryrun {
+			interval = *healthInterval
+		}
+		stopMonitor := monitorHealth(ctx, stdout, du, target, interval)
+		var err error
+		if plan != nil {
+			err = c.ClonePlanned(cloneCtx, *plan, target)
+		} else {
+			err = c.Clone(cloneCtx, source, target)
+		}
+		duration := tracker.Finish()
+		if bar != nil {
+			bar.finish()
+		}
+		if health := stopMonitor(); health != nil {
+			fmt.Fprint(stdout, health)
+		}
+		if err != nil {
 			errs[target] = err
-			fmt.Fprintf(os.Stderr, "failed to clone %q to %q: %v\n", source, target, err)
+			fmt.Fprintf(errOut, "failed to clone %q to %q: %v\n", source, target, err)
+			logger.Log(oslog.Error, "failed to clone %q to %q: %v", source, target, err)
+			printDiagnosis(errOut, err)
+			printExplanation(errOut, err)
+			return
+		}
+		fmt.Fprintf(stdout, "Completed in %s.\n", duration.Round(time.Second))
+		if *quiet {
+			fmt.Println(colors(os.Stdout).Green(fmt.Sprintf("Cloned %q to %q in %s.", source, target, duration.Round(time.Second))))
+		}
+		done := clone{
+			target:      target,
+			started:     started,
+			duration:    duration,
+			phases:      phaseTimes,
+			initialized: *initialize && restore,
+			retries:     retries,
+		}
+		if toolAfter, err := rusage.Self(); err == nil {
+			tool := toolAfter.Sub(toolBefore)
+			done.resources = &state.Resources{
+				ToolCPU:        tool.CPU(),
+				ASRCPU:         asrUsage.CPU(),
+				ToolPeakMemory: tool.MaxRSS,
+				ASRPeakMemory:  asrUsage.MaxRSS,
+			}
+		}
+		clones = append(clones, done)
+	})
+	if !*dryrun && restore && cfg.record {
+		if err := recordClones(ctx, *statePath, du, runID, *label, source, clones); err != nil {
+			fmt.Fprintln(errOut, "Warning: failed to record completed clones:", err)
 		}
 	}
+	if *metricsTextfile != "" && !*dryrun && cfg.record {
+		if err := writeMetrics(ctx, *metricsTextfile, *statePath); err != nil {
+			fmt.Fprintln(errOut, "Warning: failed to write metrics:", err)
+		}
+	}
+	outcomes := make(map[string]targetOutcome)
+	for t, err := range errs {
+		outcomes[t] = targetOutcome{cloneErr: err}
+	}
+	for _, t := range deferred {
+		outcomes[t] = targetOutcome{deferred: true}
+	}
+	var ejectFailed int
+	if *eject && cfg.eject && !*dryrun {
+		for _, c := range clones {
+			fmt.Fprintf(out, "Ejecting %q...\n", c.target)
+			err := ejectTarget(ctx, stdout, du, c.target)
+			if err != nil {
+				fmt.Fprintln(errOut, "Error:", err)
+				ejectFailed++
+			}
+			outcomes[c.target] = targetOutcome{ejected: err == nil, ejectErr: err}
+		}
+	}
+	if *pruneSource && len(errs) == 0 && len(deferred) == 0 && (phases == nil || containsPhase(phases, cloner.PhasePrune)) {
+		if err := pruneSourceSnapshots(ctx, stdout, du, c, source, targets); err != nil {
+			logger.Log(oslog.Error, "failed to prune source %q: %v", source, err)
+			err = fmt.Errorf("failed to prune source: %w", err)
+			fmt.Fprintln(errOut, "Error:", err)
+			printJSONError(err, "")
+			release()
+			os.Exit(errExitCode(err))
+		}
+	}
+	printUnplugVerdicts(ctx, out, colors(os.Stdout), du, targets, outcomes)
 	if len(errs) > 0 {
-		fmt.Fprintf(os.Stderr, "failed to clone to %d/%d targets\n", len(errs), len(targets))
-		os.Exit(1)
+		fmt.Fprintf(errOut, "failed to clone to %d/%d targets\n", len(errs), len(targets))
+		printJSONTargetErrors(fmt.Sprintf("failed to clone to %d/%d targets", len(errs), len(targets)), errs)
+		release()
+		os.Exit(cloneExitCode(errs, len(clones), len(deferred)))
+	}
+	if ejectFailed > 0 {
+		err := fmt.Errorf("failed to eject %d/%d targets", ejectFailed, len(clones))
+		fmt.Fprintln(errOut, err)
+		printJSONError(err, "")
+		release()
+		os.Exit(errExitCode(err))
+	}
+	if len(deferred) > 0 {
+		err := deferredError(*maxRuntime, deferred, targets)
+		fmt.Fprintln(errOut, err)
+		printJSONError(err, "")
+		logger.Log(oslog.Error, "%v", err)
+		release()
+		os.Exit(cloneExitCode(nil, len(clones), len(deferred)))
+	}
+}
+
+// cloneWithin calls cloneTarget with each of targets in order, until
+// maxRuntime has passed since started, and returns the targets deferred to the
+// next run because it passed. If maxRuntime is 0, no targets are deferred.
+func cloneWithin(clk clock.Clock, started time.Time, maxRuntime time.Duration, targets []string, cloneTarget func(target string)) (deferred []string) {
+	for i, target := range targets {
+		if maxRuntime > 0 && clk.Now().Sub(started) >= maxRuntime {
+			return targets[i:]
+		}
+		cloneTarget(target)
+	}
+	return nil
+}
+
+// deferredError returns the error of a run that deferred targets because
+// maxRuntime passed.
+func deferredError(maxRuntime time.Duration, deferred, targets []string) error {
+	return fmt.Errorf("-max-runtime %s exceeded: deferred %d/%d targets to the next run: %s", maxRuntime, len(deferred), len(targets), strings.Join(deferred, ", "))
+}
+
+// prepareOptions configures prepareTargets.
+type prepareOptions struct {
+	// lockDir is the directory of the locks acquired.
+	lockDir string
+	// globalLock and wait are as passed to acquireLocks.
+	globalLock, wait bool
+	// unlock is true if locked volumes are unlocked, and prompt is true if
+	// their passphrases may be read from the terminal.
+	unlock, prompt bool
+	// policy is true if the policy file must allow the targets.
+	policy bool
+}
+
+// prepareTargets prepares to clone source to targets, the same way for every
+// kind of run: it acquires the locks of source and targets, unlocks source and targets if
+// they are locked, and checks that the policy file allows targets, as
+// configured by opts. Policy errors are returned as configErrors. The returned
+// func locks the unlocked volumes again, then releases the locks.
+func prepareTargets(ctx context.Context, w io.Writer, du diskutil.DiskUtil, kc keychain.Keychain, source string, targets []string, opts prepareOptions) (release func(), err error) {
+	release, err = acquireLocks(ctx, w, du, opts.lockDir, source, targets, opts.globalLock, opts.wait)
+	if err != nil {
+		return nil, err
+	}
+	if opts.unlock {
+		relock, err := unlockVolumes(ctx, w, du, kc, append([]string{source}, targets...), opts.prompt)
+		if err != nil {
+			release()
+			return nil, err
+		}
+		// Lock volumes unlocked for the clone again before releasing
+		// their locks.
+		releaseLocks := release
+		release = func() {
+			relock()
+			releaseLocks()
+		}
+	}
+	if opts.policy {
+		if err := checkPolicy(ctx, du, targets); err != nil {
+			release()
+			return nil, configError{err}
+		}
+	}
+	return release, nil
+}
+
+// acquireLocks acquires a lock in dir for source and each target, and the
+// global lock if global is true, so that concurrent invocations never clone to
+// the same target, or prune snapshots of a source another invocation is
+// cloning from. Only invocations that set global acquire the global lock, so
+// it only excludes other invocations that set it. If wait is true,
+// acquireLocks waits for locks held by other invocations to be released.
+// Volumes that do not exist are skipped. The returned func releases all
+// acquired locks.
+func acquireLocks(ctx context.Context, w io.Writer, du diskutil.DiskUtil, dir, source string, targets []string, global, wait bool) (release func(), err error) {
+	var names []string
+	if info, err := du.Info(ctx, source); err == nil {
+		names = append(names, "source-"+info.UUID)
+	}
+	for _, t := range targets {
+		info, err := du.Info(ctx, t)
+		if err != nil {
+			continue
+		}
+		names = append(names, "target-"+info.UUID)
+	}
+	// Always acquire locks in the same order to avoid deadlocks between
+	// waiting invocations.
+	sort.Strings(names)
+	if global {
+		names = append([]string{"global"}, names...)
+	}
+
+	var locks []*lock.Lock
+	release = func() {
+		for _, l := range locks {
+			if err := l.Release(); err != nil {
+				fmt.Fprintln(os.Stderr, "Warning:", err)
+			}
+		}
+	}
+	for _, name := range names {
+		var l *lock.Lock
+		if wait {
+			l, err = lock.AcquireWait(ctx, dir, name, time.Second, func(heldErr *lock.HeldError) {
+				fmt.Fprintf(w, "Waiting for %v...\n", heldErr)
+			})
+		} else {
+			l, err = lock.Acquire(dir, name)
+		}
+		if err != nil {
+			release()
+			return nil, fmt.Errorf("error acquiring lock: %w", err)
+		}
+		locks = append(locks, l)
 	}
+	return release, nil
+}
+
+// printDiagnosis prints whether a clone error is likely caused by failing
+// hardware, if the error is a failed restore that reported I/O errors.
+func printDiagnosis(w io.Writer, err error) {
+	r, ok := diagnose.New().Diagnose(err)
+	if !ok || r.Verdict == diagnose.NoIOErrors {
+		return
+	}
+	fmt.Fprint(w, r)
+	logger.Log(oslog.Error, "%s", r)
+}
+
+// printEstimates prints how long the clone to each target is likely to take,
+// based on the durations of previous clones recorded in the catalog.
+func printEstimates(ctx context.Context, du diskutil.DiskUtil, targets []string) {
+	st, err := loadState(*statePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: unable to estimate clone durations:", err)
+		return
+	}
+	for _, t := range targets {
+		info, err := du.Info(ctx, t)
+		if err != nil {
+			continue
+		}
+		var history []time.Duration
+		for _, e := range st.History(info.UUID) {
+			history = append(history, e.Duration)
+		}
+		d, runs := estimate.FromHistory(history)
+		if runs == 0 {
+			continue
+		}
+		basis := "the last run"
+		if runs > 1 {
+			basis = fmt.Sprintf("the last %d runs", runs)
+		}
+		fmt.Printf("Cloning to %q will take about %s based on %s.\n", t, d.Round(time.Minute), basis)
+	}
+}
+
+// clone describes a successful clone to a target.
+type clone struct {
+	target   string
+	started  time.Time
+	duration time.Duration
+	// phases are the durations of the clone's phases, by phase name, if
+	// known.
+	phases map[string]time.Duration
+	// initialized is true if target was initialized by the clone.
+	initialized bool
+	// resources is the CPU time and peak memory the clone used, if known.
+	resources *state.Resources
+	// retries is the number of times the clone's restore was retried.
+	retries int
+}
+
+// recordClones records in the state file that the target of each clone is
+// paired with source, and adds each clone to the catalog under runID and
+// label. The latest snapshot of each initialized target is recorded as its
+// baseline.
+func recordClones(ctx context.Context, statePath string, du diskutil.DiskUtil, runID, label, source string, clones []clone) error {
+	if len(clones) == 0 {
+		return nil
+	}
+	st, err := loadState(statePath)
+	if err != nil {
+		return err
+	}
+	sourceInfo, err := du.Info(ctx, source)
+	if err != nil {
+		return err
+	}
+	for _, c := range clones {
+		targetInfo, err := du.Info(ctx, c.target)
+		if err != nil {
+			return err
+		}
+		st.Pair(sourceInfo.UUID, targetInfo.UUID, targetInfo.Name, clk.Now())
+		entry := state.CatalogEntry{
+			RunID:           runID,
+			Label:           label,
+			SourceUUID:      sourceInfo.UUID,
+			TargetUUID:      targetInfo.UUID,
+			Started:         c.started,
+			Duration:        c.duration,
+			ToolVersion:     version,
+			Phases:          c.phases,
+			SourceUsedBytes: sourceInfo.UsedBytes,
+			Resources:       c.resources,
+			Retries:         c.retries,
+		}
+		if targetInfo.BusProtocol != "" {
+			entry.TargetClass = diagnose.TargetClass(targetInfo.BusProtocol, targetInfo.SolidState)
+		}
+		// Once cloned to, target's latest snapshot is the source snapshot
+		// it was cloned to.
+		if snaps, err := du.ListSnapshots(ctx, targetInfo); err == nil {
+			if latest, ok := snaps.Latest(); ok {
+				entry.SnapshotUUID = latest.UUID
+				entry.SnapshotName = latest.Name
+			}
+		}
+		st.Record(entry)
+		if c.initialized {
+			if err := recordBaseline(ctx, st, du, targetInfo); err != nil {
+				return err
+			}
+		}
+	}
+	return st.Save(statePath)
+}
+
+// loadState loads the state file at path, warning if it was last saved by a
+// newer version, which may have recorded information this version drops when
+// it saves the state file.
+func loadState(path string) (*state.State, error) {
+	st, err := state.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if st.WrittenByNewer() {
+		fmt.Fprintf(os.Stderr, "Warning: state file %q was last saved by version %s, which is newer than this version (%s). Upgrade to avoid losing information it recorded.\n", path, st.WrittenBy, version)
+	}
+	return st, nil
+}
+
+// loadFormatter returns the Formatter configured by the configuration file at
+// path.
+func loadFormatter(path string) (format.Formatter, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return format.Formatter{}, err
+	}
+	return format.New(cfg.Format)
+}
+
+// describeRun describes a run for logs, e.g. "run 1a2b (weekly-offsite)".
+func describeRun(runID, label string) string {
+	if label == "" {
+		return "run " + runID
+	}
+	return fmt.Sprintf("run %s (%s)", runID, label)
+}
+
+// validateLabel returns an error if label is not a valid run label.
+func validateLabel(label string) error {
+	if !labelRE.MatchString(label) {
+		return fmt.Errorf("invalid label %q: must only contain letters, digits, '.', '_', and '-'", label)
+	}
+	return nil
+}
+
+var labelRE = regexp.MustCompile(`^[A-Za-z0-9._-]*$`)
+
+func recordBaseline(ctx context.Context, st *state.State, du diskutil.DiskUtil, target diskutil.VolumeInfo) error {
+	snaps, err := du.ListSnapshots(ctx, target)
+	if err != nil {
+		return err
+	}
+	latest, ok := snaps.Latest()
+	if !ok {
+		return fmt.Errorf("initialized target %q has no snapshots", target.Name)
+	}
+	return st.SetBaseline(target.UUID, state.Baseline{
+		SnapshotUUID: latest.UUID,
+		SnapshotName: latest.Name,
+		Created:      latest.Created,
+		Initialized:  clk.Now(),
+	})
+}
+
+// commonStrategy returns the strategy that chooses which snapshot in common
+// targets are restored from, as set by -prefer-newest-common.
+func commonStrategy() snapshotdiff.Strategy {
+	if *preferNewestCommon {
+		return snapshotdiff.PreferNewestCommon
+	}
+	return snapshotdiff.LatestInTarget
+}
+
+// newDiskUtil returns a new DiskUtil that parses snapshot names with the
+// timestamps, and in the time zone, configured in the config file. Errors
+// loading the config file are printed as warnings, and snapshot names are
+// parsed as default timestamps in UTC. With -v, every diskutil command is
+// printed before it is run.
+func newDiskUtil() diskutil.DiskUtil {
+	var opts []diskutil.Option
+	if *verbose {
+		opts = append(opts, diskutil.Trace(commandTrace()))
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: parsing snapshot names as UTC:", err)
+		return diskutil.New(opts...)
+	}
+	loc, err := cfg.SnapshotLocation()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: parsing snapshot names as UTC:", err)
+		return diskutil.New(opts...)
+	}
+	opts = append(opts, diskutil.SnapshotTimeZone(loc))
+	patterns, err := cfg.SnapshotTimestampPatterns()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: parsing snapshot names with default timestamps:", err)
+		return diskutil.New(opts...)
+	}
+	return diskutil.New(append(opts, diskutil.SnapshotTimestamps(patterns...))...)
+}
+
+// progressOutput returns where the progress of clones is printed: stdout,
+// unless -q.
+func progressOutput() io.Writer {
+	if *quiet {
+		return io.Discard
+	}
+	return os.Stdout
+}
+
+// colors returns the Colors of output printed to f, unless -no-color.
+func colors(f *os.File) cliio.Colors {
+	return cliio.For(f, *noColor)
+}
+
+// commandTrace returns where -v prints commands: indented like the output of
+// clones, and logged.
+func commandTrace() io.Writer {
+	return newPrefixWriter([]byte("\t"), io.MultiWriter(os.Stdout, logger))
+}
+
+// mirrorLog also logs clone activity to the log file named by -log-file, or
+// else by the config file, if any.
+func mirrorLog() error {
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	path := cfg.Log.File
+	if *logFile != "" {
+		path = *logFile
+	}
+	if path == "" {
+		return nil
+	}
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		path = filepath.Join(home, path[2:])
+	}
+	opts, err := cfg.Log.Options()
+	if err != nil {
+		return err
+	}
+	f, err := logfile.Open(path, opts...)
+	if err != nil {
+		return err
+	}
+	logger.Mirror(f)
+	return nil
+}
+
+// cloneASROptions returns the asr.Options of every kind of run: the buffers of
+// the config file, retries of transient failures, and with -v, tracing asr's
+// commands.
+func cloneASROptions() []asr.Option {
+	opts := []asr.Option{asrBuffers(), asrRetry()}
+	if *verbose {
+		opts = append(opts, asr.Trace(commandTrace()))
+	}
+	return opts
+}
+
+// cloneClonerOptions returns the cloner.Options of every kind of run, whose
+// clones print to stdout, and record their history on targets if history is
+// true.
+func cloneClonerOptions(stdout io.Writer, history bool) []cloner.Option {
+	return []cloner.Option{
+		cloner.History(history),
+		cloner.Stdout(stdout),
+		cloner.Clock(clk),
+		cloner.CommonStrategy(commonStrategy()),
+	}
+}
+
+// auditTools returns du and r wrapped to record the changes they make in the
+// audit log at path, attributed to the run with runID and label.
+func auditTools(du diskutil.DiskUtil, r asr.ASR, path, runID, label string) (diskutil.DiskUtil, asr.ASR) {
+	auditLog := audit.New(path, audit.Clock(clk), audit.RunID(runID), audit.Label(label))
+	return audit.DiskUtil(du, auditLog), audit.ASR(r, auditLog)
+}
+
+// asrRetry returns the asr.Option that retries restores as configured by
+// -asr-attempts and -asr-backoff.
+func asrRetry() asr.Option {
+	return asr.Retry(asr.RetryPolicy{MaxAttempts: *asrAttempts, Backoff: *asrBackoff})
+}
+
+// asrBuffers returns the asr.Option that sets the buffers configured in the
+// config file. Errors loading the config file are printed as warnings, and
+// asr's default buffers are used.
+func asrBuffers() asr.Option {
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning: using asr's default buffers:", err)
+		return asr.Buffers(asr.Tuning{})
+	}
+	return asr.Buffers(cfg.ASR)
+}
+
+// checkPolicy returns an error if the administrator's policy file does not
+// allow any of targets to be cloned to.
+func checkPolicy(ctx context.Context, du diskutil.DiskUtil, targets []string) error {
+	pol, err := policy.Load(policy.DefaultPath)
+	if err != nil {
+		return err
+	}
+	for _, t := range targets {
+		info, err := du.Info(ctx, t)
+		if err != nil {
+			return fmt.Errorf("invalid target volume: %v", err)
+		}
+		if err := pol.CheckTargets(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preflightWarnings returns the warnings found by preflight checks of plans,
+// and warnings about targets that were renamed after they were paired.
+func preflightWarnings(statePath string, plans ...cloner.Plan) ([]cloner.Warning, error) {
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	var warnings []cloner.Warning
+	for _, plan := range plans {
+		warnings = append(warnings, plan.Warnings...)
+		for _, t := range plan.Targets {
+			p, err := st.Pairing(t.Target.UUID)
+			if err != nil {
+				// Targets that are not yet paired have no name to drift from.
+				continue
+			}
+			if p.TargetName != t.Target.Name {
+				warnings = append(warnings, cloner.Warning{
+					Target:  t.Arg,
+					Message: fmt.Sprintf("target was paired as %q, but is now named %q", p.TargetName, t.Target.Name),
+				})
+			}
+		}
+	}
+	return warnings, nil
+}
+
+// checkWarnings prints warnings to w. If strict is true, an error is returned
+// if there are any warnings.
+func checkWarnings(w io.Writer, warnings []cloner.Warning, strict bool) error {
+	for _, warning := range warnings {
+		fmt.Fprintln(w, "Warning:", warning)
+	}
+	if strict && len(warnings) > 0 {
+		return fmt.Errorf("%d preflight warning(s), and -strict is set", len(warnings))
+	}
+	return nil
 }
 
 func parseArguments() (source string, targets []string, err error) {
@@ -130,14 +1305,92 @@ func parseArguments() (source string, targets []string, err error) {
 }
 
 func validateFlags(targets []string) error {
-	if *initialize && *prune {
-		return errors.New("-initialize and -prune are incompatible")
+	preflight, phases, err := parseOnly()
+	if err != nil {
+		return err
+	}
+	if err := validateLabel(*label); err != nil {
+		return err
+	}
+	if *container && len(targets) != 1 {
+		return errors.New("-container requires exactly one <target volume>")
+	}
+	if *container && *only != "" {
+		return errors.New("-container and -only are incompatible")
+	}
+	if *keep < 0 {
+		return fmt.Errorf("invalid -keep %d: must not be negative", *keep)
+	}
+	if *asrAttempts < 1 {
+		return fmt.Errorf("invalid -asr-attempts %d: must be at least 1", *asrAttempts)
+	}
+	if *asrBackoff < 0 {
+		return fmt.Errorf("invalid -asr-backoff %s: must not be negative", *asrBackoff)
+	}
+	if *verbose && *quiet {
+		return errors.New("-v and -q are incompatible")
+	}
+	if *maxRuntime < 0 {
+		return fmt.Errorf("invalid -max-runtime %s: must not be negative", *maxRuntime)
+	}
+	if *pruneSource && *container {
+		return errors.New("-prune-source and -container are incompatible")
+	}
+	if *snapshotBeforeClone && (*container || *only != "") {
+		return errors.New("-snapshot-before-clone is incompatible with -container and -only")
+	}
+	if *planPath != "" && (*container || !preflight) {
+		return errors.New("-plan requires preflight checks, and is incompatible with -container")
+	}
+	if *toSnapshot != "" && (*container || *snapshotBeforeClone) {
+		return errors.New("-to-snapshot is incompatible with -container and -snapshot-before-clone")
+	}
+	if *launchdMode && (*initialize || *container || *touchID) {
+		return errors.New("-launchd is incompatible with -initialize, -container, and -touch-id")
+	}
+	if *assumeYes && *touchID {
+		return errors.New("-yes and -touch-id are incompatible")
+	}
+	if *initialize && containsPhase(phases, cloner.PhasePrune) {
+		return errors.New("-initialize and -only prune are incompatible")
 	}
 	return nil
 }
 
-func confirm(source string, targets []string) error {
-	if *initialize {
+// parseOnly parses the -only flag. preflight is true if Cloneable should be
+// called before cloning. phases is nil if cloner's default phases should be
+// run.
+func parseOnly() (preflight bool, phases []cloner.Phase, err error) {
+	if *only == "" {
+		return true, nil, nil
+	}
+	phases = []cloner.Phase{}
+	for _, p := range strings.Split(*only, ",") {
+		switch p := cloner.Phase(strings.TrimSpace(p)); p {
+		case "preflight":
+			preflight = true
+		case cloner.PhaseRestore, cloner.PhaseVerify, cloner.PhasePrune:
+			phases = append(phases, p)
+		default:
+			return false, nil, fmt.Errorf("-only: unknown phase %q", p)
+		}
+	}
+	return preflight, phases, nil
+}
+
+func containsPhase(phases []cloner.Phase, p cloner.Phase) bool {
+	for _, phase := range phases {
+		if phase == p {
+			return true
+		}
+	}
+	return false
+}
+
+func confirm(source string, targets []string, restore bool) error {
+	if !restore {
+		fmt.Println("This will delete the snapshot in common before the latest snapshot in source from the following volumes.")
+	} else if *initialize {
 		fmt.Printf("This will delete all data on the following volumes before restoring them to %s's most recent snapshot.\n", source)
 	} else {
 		fmt.Println("This will keep existing snapshots but delete any data written to the following volume's after their most recent snapshot.")
@@ -145,8 +1398,22 @@ func confirm(source string, targets []string) error {
 	for _, t := range targets {
 		fmt.Printf("  - %s\n", t)
 	}
+	return confirmPrompt()
+}
+
+func confirmPrompt() error {
+	if *assumeYes {
+		fmt.Println("Confirmed by -yes.")
+		return nil
+	}
+	if *touchID {
+		return confirmTouchID("modify the listed volumes")
+	}
+	if err := checkInteractive(); err != nil {
+		return usageErrorf("%v - pass -yes to skip confirmation", err)
+	}
 	fmt.Print("This cannot be undone. Are you sure? y/N: ")
-	r := bufio.NewReader(os.Stdin)
+	r := bufio.NewReader(stdin)
 	response, err := r.ReadString('\n')
 	if err != nil {
 		return err
@@ -157,7 +1424,27 @@ func confirm(source string, targets []string) error {
 	case "yes":
 		return nil
 	}
-	return errors.New("-initialize confirmation rejected")
+	return errDeclined
+}
+
+// checkInteractive returns an error if stdin is not a terminal, so that
+// prompts fail instead of waiting for input that never comes, e.g. when run
+// from cron.
+func checkInteractive() error {
+	if !stdinIsTerminal() {
+		return errors.New("confirmation is required, but stdin is not a terminal")
+	}
+	return nil
+}
+
+// confirmTouchID asks the user to confirm with Touch ID. reason completes the
+// sentence "offsite-apfs-backup is trying to ..." in the Touch ID prompt.
+func confirmTouchID(reason string) error {
+	fmt.Println("Confirm with Touch ID to continue.")
+	if err := localauth.Authenticate(reason); err != nil {
+		return fmt.Errorf("confirmation rejected: %w", err)
+	}
+	return nil
 }
 
 type prefixWriter struct {
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakeclock"
+)
+
+func TestAcquireLocks(t *testing.T) {
+	du := &fakeDiskUtil{volumes: map[string]diskutil.VolumeInfo{
+		"source-1": {Name: "source-1", UUID: "source-1-uuid"},
+		"source-2": {Name: "source-2", UUID: "source-2-uuid"},
+		"target-1": {Name: "target-1", UUID: "target-1-uuid"},
+		"target-2": {Name: "target-2", UUID: "target-2-uuid"},
+	}}
+	type run struct {
+		source  string
+		targets []string
+		global  bool
+	}
+	tests := []struct {
+		name        string
+		held, other run
+		wantHeld    bool
+	}{
+		{
+			name:     "same target",
+			held:     run{source: "source-1", targets: []string{"target-1"}},
+			other:    run{source: "source-2", targets: []string{"target-2", "target-1"}},
+			wantHeld: true,
+		},
+		{
+			name:     "same source",
+			held:     run{source: "source-1", targets: []string{"target-1"}},
+			other:    run{source: "source-1", targets: []string{"target-2"}},
+			wantHeld: true,
+		},
+		{
+			name:  "other volumes",
+			held:  run{source: "source-1", targets: []string{"target-1"}},
+			other: run{source: "source-2", targets: []string{"target-2"}},
+		},
+		{
+			name:     "both global",
+			held:     run{source: "source-1", targets: []string{"target-1"}, global: true},
+			other:    run{source: "source-2", targets: []string{"target-2"}, global: true},
+			wantHeld: true,
+		},
+		{
+			name:  "only other global",
+			held:  run{source: "source-1", targets: []string{"target-1"}},
+			other: run{source: "source-2", targets: []string{"target-2"}, global: true},
+		},
+		{
+			name:  "missing volumes",
+			held:  run{source: "missing", targets: []string{"missing"}},
+			other: run{source: "missing", targets: []string{"missing"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			release, err := acquireLocks(ctx, io.Discard, du, dir, test.held.source, test.held.targets, test.held.global, false)
+			if err != nil {
+				t.Fatalf("acquireLocks returned unexpected error: %v, want: nil", err)
+			}
+			defer release()
+
+			release, err = acquireLocks(ctx, io.Discard, du, dir, test.other.source, test.other.targets, test.other.global, false)
+			if err == nil {
+				release()
+			}
+			var heldErr *lock.HeldError
+			if got := errors.As(err, &heldErr); got != test.wantHeld {
+				t.Errorf("acquireLocks returned error: %v, want *lock.HeldError: %t", err, test.wantHeld)
+			}
+		})
+	}
+}
+
+func TestCloneWithin(t *testing.T) {
+	targets := []string{"target-1", "target-2", "target-3", "target-4"}
+	tests := []struct {
+		name         string
+		maxRuntime   time.Duration
+		failed       map[string]bool
+		wantCloned   []string
+		wantDeferred []string
+		wantErr      string
+		wantCode     int
+	}{
+		{
+			name:       "no max runtime",
+			wantCloned: targets,
+		},
+		{
+			name:       "within max runtime",
+			maxRuntime: 4 * time.Hour,
+			wantCloned: targets,
+		},
+		{
+			name:         "max runtime exceeded",
+			maxRuntime:   time.Hour,
+			wantCloned:   []string{"target-1", "target-2"},
+			wantDeferred: []string{"target-3", "target-4"},
+			wantErr:      "-max-runtime 1h0m0s exceeded: deferred 2/4 targets to the next run: target-3, target-4",
+			wantCode:     exitDeferred,
+		},
+		{
+			name:         "max runtime exceeded exactly",
+			maxRuntime:   80 * time.Minute,
+			wantCloned:   []string{"target-1", "target-2"},
+			wantDeferred: []string{"target-3", "target-4"},
+			wantErr:      "-max-runtime 1h20m0s exceeded: deferred 2/4 targets to the next run: target-3, target-4",
+			wantCode:     exitDeferred,
+		},
+		{
+			name:         "max runtime exceeded after failures",
+			maxRuntime:   time.Hour,
+			failed:       map[string]bool{"target-1": true, "target-2": true},
+			wantCloned:   []string{"target-1", "target-2"},
+			wantDeferred: []string{"target-3", "target-4"},
+			wantErr:      "-max-runtime 1h0m0s exceeded: deferred 2/4 targets to the next run: target-3, target-4",
+			wantCode:     exitFailure,
+		},
+		{
+			name:         "max runtime exceeded after some failures",
+			maxRuntime:   time.Hour,
+			failed:       map[string]bool{"target-2": true},
+			wantCloned:   []string{"target-1", "target-2"},
+			wantDeferred: []string{"target-3", "target-4"},
+			wantErr:      "-max-runtime 1h0m0s exceeded: deferred 2/4 targets to the next run: target-3, target-4",
+			wantCode:     exitPartial,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clk := fakeclock.New(time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+			var cloned []string
+			errs := make(map[string]error)
+			deferred := cloneWithin(clk, clk.Now(), test.maxRuntime, targets, func(target string) {
+				// Each clone takes 40 minutes.
+				clk.Advance(40 * time.Minute)
+				cloned = append(cloned, target)
+				if test.failed[target] {
+					errs[target] = errors.New("clone failed")
+				}
+			})
+			if diff := cmp.Diff(test.wantCloned, cloned); diff != "" {
+				t.Errorf("cloneWithin cloned unexpected targets. -want +got:\n%s", diff)
+			}
+			if diff := cmp.Diff(test.wantDeferred, deferred); diff != "" {
+				t.Errorf("cloneWithin deferred unexpected targets. -want +got:\n%s", diff)
+			}
+			var gotErr string
+			if len(deferred) > 0 {
+				gotErr = deferredError(test.maxRuntime, deferred, targets).Error()
+			}
+			if gotErr != test.wantErr {
+				t.Errorf("deferredError() = %q, want: %q", gotErr, test.wantErr)
+			}
+			if got := cloneExitCode(errs, len(cloned)-len(errs), len(deferred)); got != test.wantCode {
+				t.Errorf("cloneExitCode() = %d, want: %d", got, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestConfirmPrompt(t *testing.T) {
+	tests := []struct {
+		name     string
+		yes      bool
+		terminal bool
+		input    string
+		wantErr  bool
+		wantCode int
+	}{
+		{
+			name:     "yes",
+			yes:      true,
+			terminal: true,
+		},
+		{
+			name: "yes without terminal",
+			yes:  true,
+		},
+		{
+			name:     "confirmed",
+			terminal: true,
+			input:    "y\n",
+		},
+		{
+			name:     "confirmed in full",
+			terminal: true,
+			input:    " YES \n",
+		},
+		{
+			name:     "declined",
+			terminal: true,
+			input:    "n\n",
+			wantErr:  true,
+			wantCode: exitDeclined,
+		},
+		{
+			name:     "declined by default",
+			terminal: true,
+			input:    "\n",
+			wantErr:  true,
+			wantCode: exitDeclined,
+		},
+		{
+			name:     "no terminal",
+			input:    "y\n",
+			wantErr:  true,
+			wantCode: exitUsage,
+		},
+		{
+			name:     "no response",
+			terminal: true,
+			wantErr:  true,
+			wantCode: exitFailure,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			oldYes, oldStdin, oldIsTerminal := *assumeYes, stdin, stdinIsTerminal
+			defer func() {
+				*assumeYes, stdin, stdinIsTerminal = oldYes, oldStdin, oldIsTerminal
+			}()
+			*assumeYes = test.yes
+			stdin = strings.NewReader(test.input)
+			stdinIsTerminal = func() bool { return test.terminal }
+
+			err := confirmPrompt()
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("confirmPrompt returned unexpected error: %v, want error: %t", err, test.wantErr)
+			}
+			if err == nil {
+				return
+			}
+			if got := errExitCode(err); got != test.wantCode {
+				t.Errorf("errExitCode(%v) = %d, want: %d", err, got, test.wantCode)
+			}
+		})
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantCmd  string
+		wantArgs []string
+	}{
+		{
+			name:     "clone",
+			args:     []string{"clone", "-prune", "/Volumes/source", "/Volumes/target"},
+			wantCmd:  "clone",
+			wantArgs: []string{"-prune", "/Volumes/source", "/Volumes/target"},
+		},
+		{
+			name:     "verify",
+			args:     []string{"verify", "-from-last-run"},
+			wantCmd:  "verify",
+			wantArgs: []string{"-from-last-run"},
+		},
+		{
+			name:     "list-snapshots",
+			args:     []string{"list-snapshots", "/Volumes/source"},
+			wantCmd:  "list-snapshots",
+			wantArgs: []string{"/Volumes/source"},
+		},
+		{
+			name:    "status",
+			args:    []string{"status"},
+			wantCmd: "status",
+		},
+		{
+			name:     "implicit clone with flags",
+			args:     []string{"-prune", "/Volumes/source", "/Volumes/target"},
+			wantCmd:  "clone",
+			wantArgs: []string{"-prune", "/Volumes/source", "/Volumes/target"},
+		},
+		{
+			name:     "implicit clone of mount point",
+			args:     []string{"/Volumes/source", "/Volumes/target"},
+			wantCmd:  "clone",
+			wantArgs: []string{"/Volumes/source", "/Volumes/target"},
+		},
+		{
+			name:     "implicit clone of disk identifier",
+			args:     []string{"disk3s1", "/dev/disk4s1"},
+			wantCmd:  "clone",
+			wantArgs: []string{"disk3s1", "/dev/disk4s1"},
+		},
+		{
+			name:     "implicit clone of UUID",
+			args:     []string{"01234567-89AB-CDEF-0123-456789ABCDEF", "/Volumes/target"},
+			wantCmd:  "clone",
+			wantArgs: []string{"01234567-89AB-CDEF-0123-456789ABCDEF", "/Volumes/target"},
+		},
+		{
+			name:     "no arguments",
+			wantCmd:  "clone",
+			wantArgs: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, args, err := dispatch(test.args)
+			if err != nil {
+				t.Fatalf("dispatch(%q) returned unexpected error: %v, want: nil", test.args, err)
+			}
+			if got, want := reflect.ValueOf(cmd).Pointer(), reflect.ValueOf(commands[test.wantCmd]).Pointer(); got != want {
+				t.Errorf("dispatch(%q) returned unexpected command, want: %s", test.args, test.wantCmd)
+			}
+			if diff := cmp.Diff(test.wantArgs, args, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("dispatch(%q) returned unexpected arguments. -want +got:\n%s", test.args, diff)
+			}
+		})
+	}
+}
+
+func TestDispatch_UnknownCommand(t *testing.T) {
+	for _, args := range [][]string{
+		{"verfy", "/Volumes/source", "/Volumes/target"},
+		{"list"},
+	} {
+		_, _, err := dispatch(args)
+		if err == nil {
+			t.Errorf("dispatch(%q) returned error: nil, want: non-nil", args)
+			continue
+		}
+		if got := errExitCode(err); got != exitUsage {
+			t.Errorf("errExitCode(%v) = %d, want: %d", err, got, exitUsage)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Package metrics implements exporting metrics of targets' backups in the
+// Prometheus text exposition format, so that backups can be monitored like any
+// other service: as a file read by node_exporter's textfile collector, or over
+// HTTP.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Target is the metrics of a single target's backups.
+type Target struct {
+	Name       string
+	UUID       string
+	SourceUUID string
+	// LastSuccess is when the last successful clone to the target
+	// finished, or zero if it was never cloned to successfully.
+	LastSuccess time.Time
+	// Duration is how long the last successful clone took.
+	Duration time.Duration
+	// Bytes is about how much the last successful clone restored, or 0 if
+	// unknown.
+	Bytes uint64
+	// Behind is how many snapshots of its source the target is behind, or
+	// nil if unknown, e.g. because it is not attached.
+	Behind *int
+}
+
+// metric is a metric family: its name, help, and the value of each target, or
+// false if a target has no value.
+type metric struct {
+	name  string
+	help  string
+	value func(Target) (float64, bool)
+}
+
+var metricFamilies = []metric{
+	{
+		name: "offsite_apfs_backup_last_success_timestamp_seconds",
+		help: "Unix time the last successful clone to the target finished.",
+		value: func(t Target) (float64, bool) {
+			if t.LastSuccess.IsZero() {
+				return 0, false
+			}
+			return float64(t.LastSuccess.UnixNano()) / 1e9, true
+		},
+	},
+	{
+		name: "offsite_apfs_backup_last_duration_seconds",
+		help: "Duration of the last successful clone to the target.",
+		value: func(t Target) (float64, bool) {
+			return t.Duration.Seconds(), !t.LastSuccess.IsZero()
+		},
+	},
+	{
+		name: "offsite_apfs_backup_last_restored_bytes",
+		help: "Estimated bytes restored by the last successful clone to the target.",
+		value: func(t Target) (float64, bool) {
+			return float64(t.Bytes), t.Bytes > 0
+		},
+	},
+	{
+		name: "offsite_apfs_backup_snapshots_behind",
+		help: "Snapshots of its source the target is behind, if it is attached.",
+		value: func(t Target) (float64, bool) {
+			if t.Behind == nil {
+				return 0, false
+			}
+			return float64(*t.Behind), true
+		},
+	},
+}
+
+// Write writes the metrics of targets to w in the Prometheus text exposition
+// format. Every metric is a gauge labeled by target name, target UUID, and
+// source UUID.
+func Write(w io.Writer, targets []Target) error {
+	var buf bytes.Buffer
+	for _, m := range metricFamilies {
+		fmt.Fprintf(&buf, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", m.name)
+		for _, t := range targets {
+			v, ok := m.value(t)
+			if !ok {
+				continue
+			}
+			fmt.Fprintf(&buf, "%s{target=%s,target_uuid=%s,source_uuid=%s} %g\n", m.name, quote(t.Name), quote(t.UUID), quote(t.SourceUUID), v)
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// quote quotes a label value, escaping backslashes, double quotes, and
+// newlines as the text exposition format requires.
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+// WriteFile writes the metrics of targets to the file at path, replacing it
+// atomically so that the textfile collector never reads a partial file.
+func WriteFile(path string, targets []Target) error {
+	var buf bytes.Buffer
+	if err := Write(&buf, targets); err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, ".metrics-*.prom")
+	if err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	// CreateTemp creates files only their owner can read, but collectors
+	// usually run as another user.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error writing metrics: %w", err)
+	}
+	return nil
+}
+
+// Handler returns an http.Handler that serves the metrics of the targets
+// returned by collect, which is called on every request so that metrics are
+// never stale.
+func Handler(collect func() ([]Target, error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		targets, err := collect()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, targets)
+	})
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+var testTargets = func() []Target {
+	behind := 2
+	return []Target{
+		{
+			Name:        "offsite-a",
+			UUID:        "target-a-uuid",
+			SourceUUID:  "source-uuid",
+			LastSuccess: time.Date(2021, 5, 4, 1, 23, 45, 0, time.UTC),
+			Duration:    90 * time.Second,
+			Bytes:       5e9,
+			Behind:      &behind,
+		},
+		{
+			Name:       `offsite "b"`,
+			UUID:       "target-b-uuid",
+			SourceUUID: "source-uuid",
+		},
+	}
+}()
+
+const wantMetrics = `# HELP offsite_apfs_backup_last_success_timestamp_seconds Unix time the last successful clone to the target finished.
+# TYPE offsite_apfs_backup_last_success_timestamp_seconds gauge
+offsite_apfs_backup_last_success_timestamp_seconds{target="offsite-a",target_uuid="target-a-uuid",source_uuid="source-uuid"} 1.620091425e+09
+# HELP offsite_apfs_backup_last_duration_seconds Duration of the last successful clone to the target.
+# TYPE offsite_apfs_backup_last_duration_seconds gauge
+offsite_apfs_backup_last_duration_seconds{target="offsite-a",target_uuid="target-a-uuid",source_uuid="source-uuid"} 90
+# HELP offsite_apfs_backup_last_restored_bytes Estimated bytes restored by the last successful clone to the target.
+# TYPE offsite_apfs_backup_last_restored_bytes gauge
+offsite_apfs_backup_last_restored_bytes{target="offsite-a",target_uuid="target-a-uuid",source_uuid="source-uuid"} 5e+09
+# HELP offsite_apfs_backup_snapshots_behind Snapshots of its source the target is behind, if it is attached.
+# TYPE offsite_apfs_backup_snapshots_behind gauge
+offsite_apfs_backup_snapshots_behind{target="offsite-a",target_uuid="target-a-uuid",source_uuid="source-uuid"} 2
+`
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testTargets); err != nil {
+		t.Fatalf("Write returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(wantMetrics, buf.String()); diff != "" {
+		t.Errorf("Write wrote unexpected metrics. -want +got:\n%s", diff)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got, want := quote("a \"b\"\\c\nd"), `"a \"b\"\\c\nd"`; got != want {
+		t.Errorf("quote(...) = %s, want: %s", got, want)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "offsite-apfs-backup.prom")
+	if err := os.WriteFile(path, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(path, testTargets); err != nil {
+		t.Fatalf("WriteFile returned unexpected error: %v, want: nil", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(wantMetrics, string(got)); diff != "" {
+		t.Errorf("WriteFile wrote unexpected metrics. -want +got:\n%s", diff)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler(func() ([]Target, error) {
+		return testTargets, nil
+	}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Handler responded with status %d, want: %d", rec.Code, http.StatusOK)
+	}
+	if diff := cmp.Diff(wantMetrics, rec.Body.String()); diff != "" {
+		t.Errorf("Handler responded with unexpected metrics. -want +got:\n%s", diff)
+	}
+
+	rec = httptest.NewRecorder()
+	Handler(func() ([]Target, error) {
+		return nil, errors.New("state file is corrupt")
+	}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Handler responded with status %d, want: %d", rec.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/metrics"
+)
+
+// collectMetrics returns the metrics of every paired target: of its last
+// clone, as recorded in the state file at statePath, and how far behind its
+// source it is, if both are attached.
+func collectMetrics(ctx context.Context, statePath string) ([]metrics.Target, error) {
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	report, err := newStatusReport(ctx, st, nil)
+	if err != nil {
+		return nil, err
+	}
+	var targets []metrics.Target
+	for _, ts := range report.Targets {
+		t := metrics.Target{
+			Name:       ts.Name,
+			UUID:       ts.UUID,
+			SourceUUID: ts.SourceUUID,
+			Behind:     ts.Behind,
+		}
+		if h := st.History(ts.UUID); len(h) > 0 {
+			last := h[len(h)-1]
+			t.LastSuccess = last.Started.Add(last.Duration)
+			t.Duration = last.Duration
+			// As for estimate, the bytes restored are estimated from
+			// the growth of source since the previous clone.
+			clones := cloneHistory(h)
+			t.Bytes = clones[len(clones)-1].Bytes
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// writeMetrics writes the metrics of every paired target to the textfile
+// collector file at path.
+func writeMetrics(ctx context.Context, path, statePath string) error {
+	targets, err := collectMetrics(ctx, statePath)
+	if err != nil {
+		return err
+	}
+	return metrics.WriteFile(path, targets)
+}
+
+// serveMetrics serves the metrics of every paired target at /metrics on addr
+// until ctx is done. Metrics are collected on every request.
+func serveMetrics(ctx context.Context, addr, statePath string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler(func() ([]metrics.Target, error) {
+		return collectMetrics(ctx, statePath)
+	}))
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
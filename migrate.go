@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// migrateSource re-pairs the targets of a replaced source volume to its
+// replacement, and reports which targets can still be incrementally cloned
+// to.
+func migrateSource(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("migrate-source", flag.ExitOnError)
+	dryrun := fs.Bool("dryrun", false, `If true, only report which targets would be re-paired. Does not modify the state file.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	configPath := fs.String("config", config.DefaultPath, `Path to the JSON configuration file naming backup sets, checked for sets that name the old source.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s migrate-source [-dryrun] [-state <path>] [-config <path>] <old source volume> <new source volume>
+
+Re-pairs the targets of <old source volume> to <new source volume>, e.g. after
+migrating to a new Mac or disk.
+
+Targets can only be incrementally cloned to from the new source if it still
+has a snapshot in common with them, e.g. if the new source was restored from
+the old source with asr. Each attached target is checked, and targets without
+a snapshot in common must be re-initialized with -initialize.
+
+  <old source volume>
+    	Volume UUID of the replaced source, or the volume itself if it is still attached.
+  <new source volume>
+    	New source APFS volume.
+    	May be a mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fmt.Fprintln(fs.Output(), "Error: <old source volume> and <new source volume> are required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	du := newDiskUtil()
+	oldUUID := fs.Arg(0)
+	// The old source is usually no longer attached, so it is only resolved
+	// if it is.
+	if info, err := du.Info(ctx, oldUUID); err == nil {
+		oldUUID = info.UUID
+	}
+	newSource, err := du.Info(ctx, fs.Arg(1))
+	if err != nil {
+		return usageErrorf("invalid new source volume: %v", err)
+	}
+	if newSource.UUID == oldUUID {
+		return errors.New("old and new source are the same volume")
+	}
+	newSnaps, err := du.ListSnapshots(ctx, newSource)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of new source: %v", err)
+	}
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	pairings := st.ReplaceSource(oldUUID, newSource.UUID)
+	if len(pairings) == 0 {
+		return fmt.Errorf("no targets are paired with source %q", oldUUID)
+	}
+	var reinitialize int
+	for _, p := range pairings {
+		fmt.Printf("Target %q (%s): ", p.TargetName, p.TargetUUID)
+		info, err := du.Info(ctx, p.TargetUUID)
+		if err != nil {
+			fmt.Println("not attached, so continuity could not be checked. If cloning to it fails with \"no snapshots in common\", re-initialize it.")
+			continue
+		}
+		targetSnaps, err := du.ListSnapshots(ctx, info)
+		if err != nil {
+			return fmt.Errorf("error listing snapshots of target %q: %v", p.TargetName, err)
+		}
+		if common, ok := newSnaps.CommonWith(targetSnaps); ok {
+			fmt.Printf("can be incrementally cloned to from snapshot %s.\n", common)
+			continue
+		}
+		reinitialize++
+		fmt.Println("no snapshots in common with the new source; it must be re-initialized with -initialize.")
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Warning:", err)
+	} else {
+		for _, set := range cfg.Sets {
+			if set.Source == oldUUID {
+				fmt.Printf("Backup set %q names the old source; update its source to %s.\n", set.Name, newSource.UUID)
+			}
+		}
+	}
+
+	if *dryrun {
+		fmt.Printf("Would re-pair %d target(s) to %q (%s).\n", len(pairings), newSource.Name, newSource.UUID)
+		return nil
+	}
+	if err := st.Save(*statePath); err != nil {
+		return err
+	}
+	fmt.Printf("Re-paired %d target(s) to %q (%s); %d must be re-initialized.\n", len(pairings), newSource.Name, newSource.UUID, reinitialize)
+	return nil
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// mount mounts a target, unlocking it first if it is encrypted and locked.
+func mount(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("mount", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	readOnly := fs.Bool("read-only", false, `If true, mount the target read-only, e.g. to inspect it without risk of modifying it.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s mount [-read-only] [-state <path>] <target volume>
+
+  <target volume>
+    	Target volume to mount. If the volume is encrypted and locked, prompts for its passphrase.
+    	May be the name of a paired target, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	target := parseTargetArg(fs, args)
+
+	du := newDiskUtil()
+	info, err := resolveTarget(ctx, *statePath, du, target)
+	if err != nil {
+		return err
+	}
+	if info.MountPoint != "" {
+		fmt.Printf("%q is already mounted at %s.\n", info.Name, info.MountPoint)
+		return nil
+	}
+	if info.Locked {
+		passphrase, err := readPassphrase(fmt.Sprintf("Passphrase for %q: ", info.Name))
+		if err != nil {
+			return err
+		}
+		// Unlocking also mounts the volume.
+		if err := du.UnlockVolume(ctx, info, passphrase); err != nil {
+			return fmt.Errorf("error unlocking %q: %v", info.Name, err)
+		}
+		// Unlocked volumes are mounted read-write, so remount them.
+		if *readOnly {
+			if err := du.Unmount(ctx, info); err != nil {
+				return fmt.Errorf("error unmounting %q to remount it read-only: %v", info.Name, err)
+			}
+			if err := du.MountReadOnly(ctx, info); err != nil {
+				return fmt.Errorf("error mounting %q read-only: %v", info.Name, err)
+			}
+		}
+	} else if *readOnly {
+		if err := du.MountReadOnly(ctx, info); err != nil {
+			return fmt.Errorf("error mounting %q read-only: %v", info.Name, err)
+		}
+	} else if err := du.Mount(ctx, info); err != nil {
+		return fmt.Errorf("error mounting %q: %v", info.Name, err)
+	}
+	info, err = du.Info(ctx, info.UUID)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Mounted %q at %s.\n", info.Name, info.MountPoint)
+	return nil
+}
+
+// unmount unmounts a target.
+func unmount(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("unmount", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s unmount [-state <path>] <target volume>
+
+  <target volume>
+    	Target volume to unmount.
+    	May be the name of a paired target, mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	target := parseTargetArg(fs, args)
+
+	du := newDiskUtil()
+	info, err := resolveTarget(ctx, *statePath, du, target)
+	if err != nil {
+		return err
+	}
+	if info.MountPoint == "" {
+		fmt.Printf("%q is not mounted.\n", info.Name)
+		return nil
+	}
+	if err := du.Unmount(ctx, info); err != nil {
+		return fmt.Errorf("error unmounting %q: %v", info.Name, err)
+	}
+	fmt.Printf("Unmounted %q.\n", info.Name)
+	return nil
+}
+
+// parseTargetArg parses args with fs, and returns the single <target volume>
+// argument. Exits if there is not exactly one argument.
+func parseTargetArg(fs *flag.FlagSet, args []string) string {
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <target volume> is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	return fs.Arg(0)
+}
+
+// resolveTarget returns the VolumeInfo of target, which may be the name or
+// UUID of a target paired in the state file, or any volume identifier
+// accepted by diskutil.
+func resolveTarget(ctx context.Context, statePath string, du diskutil.DiskUtil, target string) (diskutil.VolumeInfo, error) {
+	st, err := loadState(statePath)
+	if err != nil {
+		return diskutil.VolumeInfo{}, err
+	}
+	if p, err := st.Pairing(target); err == nil {
+		info, err := du.Info(ctx, p.TargetUUID)
+		if err != nil {
+			return diskutil.VolumeInfo{}, fmt.Errorf("paired target %q is not attached: %v", p.TargetName, err)
+		}
+		return info, nil
+	}
+	info, err := du.Info(ctx, target)
+	if err != nil {
+		return diskutil.VolumeInfo{}, usageErrorf("invalid target volume: %v", err)
+	}
+	return info, nil
+}
+
+// readPassphrase prompts for a passphrase without echoing it to the terminal.
+func readPassphrase(prompt string) (string, error) {
+	fmt.Print(prompt)
+	if err := stty("-echo"); err != nil {
+		return "", err
+	}
+	r := bufio.NewReader(os.Stdin)
+	passphrase, err := r.ReadString('\n')
+	// Restore echo before handling any read error.
+	sttyErr := stty("echo")
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	if sttyErr != nil {
+		return "", sttyErr
+	}
+	passphrase = strings.TrimSuffix(passphrase, "\n")
+	if passphrase == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return passphrase, nil
+}
+
+func stty(arg string) error {
+	cmd := exec.Command("stty", arg)
+	cmd.Stdin = os.Stdin
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with output: %s", cmd, err, out)
+	}
+	return nil
+}
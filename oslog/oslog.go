@@ -0,0 +1,92 @@
+// Package oslog writes log entries to MacOS's unified logging system (os_log)
+// under the Subsystem subsystem, so that Console.app and `log show` display
+// backup activity alongside system disk events. On other platforms, or when
+// built without cgo, log entries are discarded.
+package oslog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Subsystem is the os_log subsystem of all log entries. For example, to stream
+// log entries:
+//
+//	log stream --level info --predicate 'subsystem == "com.voidingwarranties.offsite-apfs-backup"'
+const Subsystem = "com.voidingwarranties.offsite-apfs-backup"
+
+// Level is the os_log type of a log entry.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Default
+	Error
+	Fault
+)
+
+// Logger writes log entries to os_log under a single category.
+type Logger struct {
+	write func(level Level, msg string)
+
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	mirror io.Writer
+}
+
+// New returns a Logger for the given category, e.g. "clone".
+func New(category string) *Logger {
+	return newLogger(newWriteFunc(category))
+}
+
+func newLogger(write func(level Level, msg string)) *Logger {
+	return &Logger{write: write}
+}
+
+// Mirror also writes every later log entry to w, one per line, e.g. to keep
+// a plain text log alongside os_log. Errors writing to w are ignored, like
+// those of os_log.
+func (l *Logger) Mirror(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.mirror = w
+}
+
+// Log writes a log entry at the given level.
+func (l *Logger) Log(level Level, format string, a ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entry(level, fmt.Sprintf(format, a...))
+}
+
+// entry writes a log entry to os_log and the mirror, if any. l.mu must be
+// held.
+func (l *Logger) entry(level Level, msg string) {
+	l.write(level, msg)
+	if l.mirror != nil {
+		fmt.Fprintf(l.mirror, "%s\n", msg)
+	}
+}
+
+// Write logs each complete line of p as a separate entry at the Default
+// level, so that a Logger can be used as the output of other writers. Partial
+// lines are buffered until they are completed by a later Write.
+func (l *Logger) Write(p []byte) (n int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(p)
+	for {
+		i := bytes.IndexByte(l.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(l.buf.Next(i + 1))
+		if line = line[:len(line)-1]; line != "" {
+			l.entry(Default, line)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,41 @@
+// +build darwin,cgo
+
+package oslog
+
+/*
+#include <os/log.h>
+#include <stdlib.h>
+
+// os_log_with_type is a macro that requires a constant format string, so it
+// cannot be called directly from Go.
+static void oslog_write(os_log_t log, os_log_type_t type, const char *msg) {
+	os_log_with_type(log, type, "%{public}s", msg);
+}
+*/
+import "C"
+
+import (
+	"unsafe"
+)
+
+var types = map[Level]C.os_log_type_t{
+	Debug:   C.OS_LOG_TYPE_DEBUG,
+	Info:    C.OS_LOG_TYPE_INFO,
+	Default: C.OS_LOG_TYPE_DEFAULT,
+	Error:   C.OS_LOG_TYPE_ERROR,
+	Fault:   C.OS_LOG_TYPE_FAULT,
+}
+
+func newWriteFunc(category string) func(level Level, msg string) {
+	subsystem := C.CString(Subsystem)
+	defer C.free(unsafe.Pointer(subsystem))
+	cat := C.CString(category)
+	defer C.free(unsafe.Pointer(cat))
+	log := C.os_log_create(subsystem, cat)
+
+	return func(level Level, msg string) {
+		cmsg := C.CString(msg)
+		defer C.free(unsafe.Pointer(cmsg))
+		C.oslog_write(log, types[level], cmsg)
+	}
+}
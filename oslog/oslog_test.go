@@ -0,0 +1,68 @@
+package oslog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type entry struct {
+	Level Level
+	Msg   string
+}
+
+func TestLogger(t *testing.T) {
+	var got []entry
+	l := newLogger(func(level Level, msg string) {
+		got = append(got, entry{level, msg})
+	})
+
+	l.Log(Error, "failed to clone %q", "target")
+	for _, w := range []string{"Cloning...\n\t", "....10", "....20\n\nCompleted.\n", "partial"} {
+		n, err := l.Write([]byte(w))
+		if err != nil {
+			t.Fatalf("Write(%q) returned unexpected error: %v, want: nil", w, err)
+		}
+		if n != len(w) {
+			t.Errorf("Write(%q) returned n: %d, want: %d", w, n, len(w))
+		}
+	}
+
+	want := []entry{
+		{Error, `failed to clone "target"`},
+		{Default, "Cloning..."},
+		{Default, "\t....10....20"},
+		{Default, "Completed."},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Logger wrote unexpected entries. -want +got:\n%s", diff)
+	}
+}
+
+func TestLogger_Mirror(t *testing.T) {
+	var got []entry
+	l := newLogger(func(level Level, msg string) {
+		got = append(got, entry{level, msg})
+	})
+	var mirror bytes.Buffer
+
+	l.Log(Info, "before mirroring")
+	l.Mirror(&mirror)
+	l.Log(Error, "failed to clone %q", "target")
+	l.Write([]byte("Cloning...\nCompleted.\n"))
+
+	want := []entry{
+		{Info, "before mirroring"},
+		{Error, `failed to clone "target"`},
+		{Default, "Cloning..."},
+		{Default, "Completed."},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Logger wrote unexpected entries. -want +got:\n%s", diff)
+	}
+	wantMirror := "failed to clone \"target\"\nCloning...\nCompleted.\n"
+	if diff := cmp.Diff(wantMirror, mirror.String()); diff != "" {
+		t.Errorf("Logger mirrored unexpected entries. -want +got:\n%s", diff)
+	}
+}
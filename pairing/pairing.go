@@ -0,0 +1,106 @@
+// Package pairing implements finding which of the targets paired in the state
+// file are attached, for tools that report on or react to targets, such as
+// the status and watch commands, without each discovering volumes itself, and
+// checking that a restore is from a target of the volume being restored.
+package pairing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// Target is an attached paired target.
+type Target struct {
+	state.Pairing
+	// Volume is the target's volume, as currently attached.
+	Volume diskutil.VolumeInfo
+	// LastCloned is when target was last cloned to, or zero if it never
+	// was.
+	LastCloned time.Time
+}
+
+// AttachedTargets returns the targets paired in st that are attached, in the
+// order they were paired. AttachedTargets only reads volumes and st; it never
+// modifies either.
+func AttachedTargets(ctx context.Context, du diskutil.DiskUtil, st *state.State) ([]Target, error) {
+	// List attached volumes once, rather than looking up each paired
+	// target, most of which are usually off-site.
+	volumes, err := du.APFSVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing APFS volumes: %w", err)
+	}
+	attached := make(map[string]bool, len(volumes))
+	for _, v := range volumes {
+		attached[v.UUID] = true
+	}
+	var targets []Target
+	for _, p := range st.Pairings {
+		if !attached[p.TargetUUID] {
+			continue
+		}
+		info, err := du.Info(ctx, p.TargetUUID)
+		if err != nil {
+			// Detached since it was listed.
+			continue
+		}
+		t := Target{
+			Pairing: p,
+			Volume:  info,
+		}
+		if h := st.History(p.TargetUUID); len(h) > 0 {
+			t.LastCloned = h[len(h)-1].Started
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// MismatchError is returned by CheckRestore when the offsite volume is a paired
+// target of a source other than the local volume.
+type MismatchError struct {
+	Pairing state.Pairing
+	Local   diskutil.VolumeInfo
+}
+
+func (err *MismatchError) Error() string {
+	return fmt.Sprintf("%q was cloned from %s, not %q (%s)", err.Pairing.TargetName, err.Pairing.SourceUUID, err.Local.Name, err.Local.UUID)
+}
+
+// CheckRestore returns a *MismatchError if offsite is a paired target in st of
+// a source other than local, e.g. because the wrong local volume was given to
+// restore to. It is not an error for offsite to be unpaired, or, if initialize
+// is true, for local to be a new volume without snapshots, e.g. a replacement
+// for a lost source.
+func CheckRestore(ctx context.Context, du diskutil.DiskUtil, st *state.State, offsite, local string, initialize bool) error {
+	offsiteInfo, err := du.Info(ctx, offsite)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of offsite volume: %w", err)
+	}
+	p, err := st.Pairing(offsiteInfo.UUID)
+	if err != nil {
+		return nil
+	}
+	localInfo, err := du.Info(ctx, local)
+	if err != nil {
+		return fmt.Errorf("error getting volume info of local volume: %w", err)
+	}
+	if localInfo.UUID == p.SourceUUID {
+		return nil
+	}
+	if initialize {
+		// A volume with snapshots is in use, and more likely the
+		// wrong volume than a replacement for the source.
+		snaps, err := du.ListSnapshots(ctx, localInfo)
+		if err != nil {
+			return fmt.Errorf("error listing snapshots of local volume: %w", err)
+		}
+		if len(snaps) == 0 {
+			return nil
+		}
+	}
+	return &MismatchError{Pairing: p, Local: localInfo}
+}
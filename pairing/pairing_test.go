@@ -0,0 +1,194 @@
+package pairing
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// fakeDiskUtil has the volumes attached, with the snapshots of each volume's
+// UUID. listed, if set, are the volumes listed by APFSVolumes instead, e.g.
+// to simulate a volume detaching between calls.
+type fakeDiskUtil struct {
+	diskutil.DiskUtil
+
+	attached  []diskutil.VolumeInfo
+	snapshots map[string]diskutil.SnapshotList
+	listed    []diskutil.VolumeInfo
+	err       error
+}
+
+func (du *fakeDiskUtil) APFSVolumes(ctx context.Context) ([]diskutil.VolumeInfo, error) {
+	if du.err != nil {
+		return nil, du.err
+	}
+	if du.listed != nil {
+		return du.listed, nil
+	}
+	return du.attached, nil
+}
+
+func (du *fakeDiskUtil) Info(ctx context.Context, volume string) (diskutil.VolumeInfo, error) {
+	for _, v := range du.attached {
+		if v.UUID == volume {
+			return v, nil
+		}
+	}
+	return diskutil.VolumeInfo{}, errors.New("no such volume")
+}
+
+func (du *fakeDiskUtil) ListSnapshots(ctx context.Context, volume diskutil.VolumeInfo) (diskutil.SnapshotList, error) {
+	return du.snapshots[volume.UUID], nil
+}
+
+var (
+	source  = diskutil.VolumeInfo{Name: "source", UUID: "source-uuid"}
+	target1 = diskutil.VolumeInfo{Name: "target-1", UUID: "target-1-uuid", MountPoint: "/Volumes/target-1"}
+	target2 = diskutil.VolumeInfo{Name: "target-2", UUID: "target-2-uuid"}
+	target3 = diskutil.VolumeInfo{Name: "target-3", UUID: "target-3-uuid"}
+	paired  = time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC)
+	cloned  = time.Date(2021, 3, 2, 0, 0, 0, 0, time.UTC)
+)
+
+func newState() *state.State {
+	st := &state.State{}
+	for _, t := range []diskutil.VolumeInfo{target1, target2, target3} {
+		st.Pair(source.UUID, t.UUID, t.Name, paired)
+	}
+	st.Record(state.CatalogEntry{SourceUUID: source.UUID, TargetUUID: target2.UUID, Started: cloned})
+	return st
+}
+
+func TestAttachedTargets(t *testing.T) {
+	tests := []struct {
+		name string
+		du   *fakeDiskUtil
+		want []Target
+	}{
+		{
+			name: "some attached",
+			du:   &fakeDiskUtil{attached: []diskutil.VolumeInfo{target2, source, target1}},
+			want: []Target{
+				{
+					Pairing: state.Pairing{SourceUUID: source.UUID, TargetUUID: target1.UUID, TargetName: target1.Name, Paired: paired},
+					Volume:  target1,
+				},
+				{
+					Pairing:    state.Pairing{SourceUUID: source.UUID, TargetUUID: target2.UUID, TargetName: target2.Name, Paired: paired},
+					Volume:     target2,
+					LastCloned: cloned,
+				},
+			},
+		},
+		{
+			name: "none attached",
+			du:   &fakeDiskUtil{attached: []diskutil.VolumeInfo{source}},
+		},
+		{
+			name: "detached after listing",
+			du: &fakeDiskUtil{
+				attached: []diskutil.VolumeInfo{source},
+				listed:   []diskutil.VolumeInfo{source, target3},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := AttachedTargets(context.Background(), test.du, newState())
+			if err != nil {
+				t.Fatalf("AttachedTargets returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("AttachedTargets returned unexpected targets. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAttachedTargets_Errors(t *testing.T) {
+	du := &fakeDiskUtil{err: errors.New("diskutil failed")}
+	if _, err := AttachedTargets(context.Background(), du, newState()); err == nil {
+		t.Error("AttachedTargets returned error: nil, want: non-nil")
+	}
+}
+
+func TestCheckRestore(t *testing.T) {
+	other := diskutil.VolumeInfo{Name: "other", UUID: "other-uuid"}
+	replacement := diskutil.VolumeInfo{Name: "replacement", UUID: "replacement-uuid"}
+	unpaired := diskutil.VolumeInfo{Name: "unpaired", UUID: "unpaired-uuid"}
+	du := &fakeDiskUtil{
+		attached: []diskutil.VolumeInfo{source, target1, other, replacement, unpaired},
+		snapshots: map[string]diskutil.SnapshotList{
+			other.UUID: {{Name: "com.apple.TimeMachine.2021-03-01-000000.local"}},
+		},
+	}
+	tests := []struct {
+		name           string
+		offsite, local string
+		initialize     bool
+		wantMismatch   bool
+		wantErr        bool
+	}{
+		{
+			name:    "paired source",
+			offsite: target1.UUID,
+			local:   source.UUID,
+		},
+		{
+			name:    "unpaired offsite",
+			offsite: unpaired.UUID,
+			local:   other.UUID,
+		},
+		{
+			name:         "new volume without initialize",
+			offsite:      target1.UUID,
+			local:        replacement.UUID,
+			wantMismatch: true,
+			wantErr:      true,
+		},
+		{
+			name:       "new volume with initialize",
+			offsite:    target1.UUID,
+			local:      replacement.UUID,
+			initialize: true,
+		},
+		{
+			name:         "volume with snapshots with initialize",
+			offsite:      target1.UUID,
+			local:        other.UUID,
+			initialize:   true,
+			wantMismatch: true,
+			wantErr:      true,
+		},
+		{
+			name:    "missing offsite",
+			offsite: target2.UUID,
+			local:   source.UUID,
+			wantErr: true,
+		},
+		{
+			name:    "missing local",
+			offsite: target1.UUID,
+			local:   "missing-uuid",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := CheckRestore(context.Background(), du, newState(), test.offsite, test.local, test.initialize)
+			if gotErr := err != nil; gotErr != test.wantErr {
+				t.Fatalf("CheckRestore returned unexpected error: %v, want error: %t", err, test.wantErr)
+			}
+			var mismatchErr *MismatchError
+			if got := errors.As(err, &mismatchErr); got != test.wantMismatch {
+				t.Errorf("CheckRestore returned error: %v, want *MismatchError: %t", err, test.wantMismatch)
+			}
+		})
+	}
+}
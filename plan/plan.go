@@ -0,0 +1,311 @@
+// Package plan implements a JSON record of what a clone would do: which source
+// snapshot it would restore each target to, from which snapshot, and which
+// snapshots it would prune. Plans are written before cloning, e.g. with
+// -dryrun, so that the impact of a change, such as to a backup set's retention
+// options, can be reviewed by comparing the plans before and after it with
+// Diff.
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// Plan is what a clone of a source volume to its targets would do.
+type Plan struct {
+	Source Volume `json:"source"`
+	// Snapshot is the source snapshot targets would be restored to.
+	Snapshot Snapshot `json:"snapshot"`
+	// Strategy is the snapshotdiff.Strategy that chose targets' base
+	// snapshots, e.g. prefer-newest-common. Empty in plans written before
+	// strategies were recorded.
+	Strategy string   `json:"strategy,omitempty"`
+	Targets  []Target `json:"targets"`
+}
+
+// Volume identifies an APFS volume.
+type Volume struct {
+	Name string `json:"name"`
+	UUID string `json:"uuid"`
+}
+
+// Snapshot identifies an APFS snapshot.
+type Snapshot struct {
+	Name    string     `json:"name"`
+	UUID    string     `json:"uuid"`
+	Created *time.Time `json:"created,omitempty"`
+}
+
+func (s Snapshot) String() string {
+	return fmt.Sprintf("%s (%s)", s.Name, s.UUID)
+}
+
+// Target is what a clone would do to a single target. Targets are cloned to
+// in the order of Plan.Targets.
+type Target struct {
+	Volume
+	// Priority is the target's configured priority, which ordered it
+	// among the plan's targets.
+	Priority int `json:"priority,omitempty"`
+	// Initialize is true if the target would be erased and initialized to
+	// Snapshot.
+	Initialize bool `json:"initialize,omitempty"`
+	// Base is the snapshot the target would be incrementally restored
+	// from, unless it is initialized.
+	Base *Snapshot `json:"base,omitempty"`
+	// BaseReason explains why Strategy chose Base.
+	BaseReason string `json:"base_reason,omitempty"`
+	// Prune are the snapshots that would be pruned from the target after
+	// it is restored, newest first.
+	Prune []Snapshot `json:"prune"`
+	// EstimatedBytes is about how many bytes the restore would copy, as
+	// estimated by cloner.CloneBytes.
+	EstimatedBytes uint64 `json:"estimated_bytes,omitempty"`
+}
+
+// New returns the Plan of cloning with c, as resolved by c.Preflight.
+func New(c cloner.Cloner, p cloner.Plan) Plan {
+	latest, _ := p.SourceSnaps.Latest()
+	plan := Plan{
+		Source:   volume(p.Source),
+		Snapshot: snapshot(latest),
+		Strategy: c.Strategy().String(),
+		Targets:  []Target{},
+	}
+	for _, t := range p.Targets {
+		target := Target{
+			Volume:         volume(t.Target),
+			Initialize:     t.Common.UUID == "",
+			Prune:          []Snapshot{},
+			EstimatedBytes: cloner.CloneBytes(p.Source, t.Target),
+		}
+		if !target.Initialize {
+			base := snapshot(t.Common)
+			target.Base = &base
+			target.BaseReason = t.CommonReason
+		}
+		for _, s := range c.Prunes(p, t) {
+			target.Prune = append(target.Prune, snapshot(s))
+		}
+		plan.Targets = append(plan.Targets, target)
+	}
+	return plan
+}
+
+func volume(v diskutil.VolumeInfo) Volume {
+	return Volume{Name: v.Name, UUID: v.UUID}
+}
+
+func snapshot(s diskutil.Snapshot) Snapshot {
+	snap := Snapshot{Name: s.Name, UUID: s.UUID}
+	if !s.Created.IsZero() {
+		created := s.Created
+		snap.Created = &created
+	}
+	return snap
+}
+
+// Print prints what the plan would do, target by target in the order they
+// would be cloned to, formatting sizes with formatBytes.
+func Print(w io.Writer, p Plan, formatBytes func(uint64) string) {
+	fmt.Fprintf(w, "Plan: restore targets to snapshot %s of %q (%s).\n", p.Snapshot, p.Source.Name, p.Source.UUID)
+	if p.Strategy != "" {
+		fmt.Fprintf(w, "Base snapshots are chosen by the %s strategy.\n", p.Strategy)
+	}
+	for _, t := range p.Targets {
+		priority := ""
+		if t.Priority != 0 {
+			priority = fmt.Sprintf(", priority %d", t.Priority)
+		}
+		fmt.Fprintf(w, "Target %q (%s%s):\n", t.Name, t.UUID, priority)
+		if t.Initialize {
+			fmt.Fprintln(w, "\tErase and initialize target, deleting all of its data and snapshots")
+		} else {
+			fmt.Fprintf(w, "\tIncrementally restore from snapshot %s", t.Base)
+			if t.BaseReason != "" {
+				fmt.Fprintf(w, " - the %s", t.BaseReason)
+			}
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "\tCopy about %s\n", formatBytes(t.EstimatedBytes))
+		if len(t.Prune) == 0 {
+			fmt.Fprintln(w, "\tPrune no snapshots")
+			continue
+		}
+		fmt.Fprintln(w, "\tPrune snapshots:")
+		for _, s := range t.Prune {
+			fmt.Fprintf(w, "\t\t%s\n", s)
+		}
+	}
+}
+
+// Read reads the plan stored at path.
+func Read(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf("error reading plan: %w", err)
+	}
+	var p Plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return Plan{}, fmt.Errorf("error parsing plan %q: %w", path, err)
+	}
+	if p.Source.UUID == "" {
+		return Plan{}, fmt.Errorf("invalid plan %q: no source", path)
+	}
+	for _, t := range p.Targets {
+		if !t.Initialize && t.Base == nil {
+			return Plan{}, fmt.Errorf("invalid plan %q: target %q is neither initialized nor has a base snapshot", path, t.Name)
+		}
+	}
+	return p, nil
+}
+
+// Write writes the plan to path.
+func Write(path string, p Plan) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing plan: %w", err)
+	}
+	return nil
+}
+
+// Diff explains how plan after differs from plan before, e.g. that a target
+// would be pruned of more snapshots. Diff returns nil if the plans would do
+// the same thing.
+func Diff(before, after Plan) []string {
+	var changes []string
+	if before.Source.UUID != after.Source.UUID {
+		changes = append(changes, fmt.Sprintf("clones from source %q (%s) instead of %q (%s)", after.Source.Name, after.Source.UUID, before.Source.Name, before.Source.UUID))
+	}
+	if before.Snapshot.UUID != after.Snapshot.UUID {
+		changes = append(changes, fmt.Sprintf("restores targets to snapshot %s instead of %s", after.Snapshot, before.Snapshot))
+	}
+	if before.Strategy != "" && after.Strategy != "" && before.Strategy != after.Strategy {
+		changes = append(changes, fmt.Sprintf("chooses base snapshots by the %s strategy instead of %s", after.Strategy, before.Strategy))
+	}
+	beforeTargets := make(map[string]Target)
+	for _, t := range before.Targets {
+		beforeTargets[t.UUID] = t
+	}
+	if b, a := commonOrder(before, after), commonOrder(after, before); !equalStrings(b, a) {
+		changes = append(changes, fmt.Sprintf("clones targets in order %s instead of %s", strings.Join(a, ", "), strings.Join(b, ", ")))
+	}
+	afterTargets := make(map[string]bool)
+	for _, t := range after.Targets {
+		afterTargets[t.UUID] = true
+		b, ok := beforeTargets[t.UUID]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("target %q (%s): is newly cloned to%s", t.Name, t.UUID, prunesSummary(t.Prune)))
+			continue
+		}
+		for _, c := range diffTarget(b, t) {
+			changes = append(changes, fmt.Sprintf("target %q (%s): %s", t.Name, t.UUID, c))
+		}
+	}
+	for _, t := range before.Targets {
+		if !afterTargets[t.UUID] {
+			changes = append(changes, fmt.Sprintf("target %q (%s): is no longer cloned to", t.Name, t.UUID))
+		}
+	}
+	return changes
+}
+
+// commonOrder returns the names of the targets of p that are also targets of
+// other, in the order of p.
+func commonOrder(p, other Plan) []string {
+	in := make(map[string]bool)
+	for _, t := range other.Targets {
+		in[t.UUID] = true
+	}
+	var names []string
+	for _, t := range p.Targets {
+		if in[t.UUID] {
+			names = append(names, fmt.Sprintf("%q", t.Name))
+		}
+	}
+	return names
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// diffTarget explains how target after differs from target before.
+func diffTarget(before, after Target) []string {
+	var changes []string
+	if after.Priority != before.Priority {
+		changes = append(changes, fmt.Sprintf("has priority %d instead of %d", after.Priority, before.Priority))
+	}
+	switch {
+	case after.Initialize && !before.Initialize:
+		changes = append(changes, "is initialized, erasing all of its data, instead of incrementally restored")
+	case before.Initialize && !after.Initialize:
+		changes = append(changes, fmt.Sprintf("is incrementally restored from %s instead of initialized", after.Base))
+	case !after.Initialize && after.Base.UUID != before.Base.UUID:
+		changes = append(changes, fmt.Sprintf("is incrementally restored from %s instead of %s", after.Base, before.Base))
+	}
+	added, removed := diffSnapshots(before.Prune, after.Prune)
+	if len(added) > 0 {
+		changes = append(changes, "also prunes "+joinSnapshots(added))
+	}
+	if len(removed) > 0 {
+		changes = append(changes, "no longer prunes "+joinSnapshots(removed))
+	}
+	return changes
+}
+
+// diffSnapshots returns the snapshots only in after, and only in before.
+func diffSnapshots(before, after []Snapshot) (added, removed []Snapshot) {
+	in := func(snaps []Snapshot, uuid string) bool {
+		for _, s := range snaps {
+			if s.UUID == uuid {
+				return true
+			}
+		}
+		return false
+	}
+	for _, s := range after {
+		if !in(before, s.UUID) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range before {
+		if !in(after, s.UUID) {
+			removed = append(removed, s)
+		}
+	}
+	return added, removed
+}
+
+func prunesSummary(prune []Snapshot) string {
+	if len(prune) == 0 {
+		return ""
+	}
+	return ", and pruned of " + joinSnapshots(prune)
+}
+
+func joinSnapshots(snaps []Snapshot) string {
+	s := make([]string, len(snaps))
+	for i, snap := range snaps {
+		s[i] = snap.String()
+	}
+	return strings.Join(s, ", ")
+}
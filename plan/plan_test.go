@@ -0,0 +1,248 @@
+package plan
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+var (
+	snap1 = Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	snap2 = Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	snap3 = Snapshot{Name: "snap-3", UUID: "snap-3-uuid"}
+)
+
+func TestNew(t *testing.T) {
+	created := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	latest := diskutil.Snapshot{Name: "snap-3", UUID: "snap-3-uuid", Created: created}
+	common := diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	p := cloner.Plan{
+		Source:      diskutil.VolumeInfo{Name: "source", UUID: "source-uuid", UsedBytes: 3000},
+		SourceSnaps: []diskutil.Snapshot{latest, common},
+		Targets: []cloner.TargetPlan{
+			{
+				Target:       diskutil.VolumeInfo{Name: "target", UUID: "target-uuid", UsedBytes: 2000},
+				TargetSnaps:  []diskutil.Snapshot{common},
+				Common:       common,
+				CommonReason: "latest common snapshot in target",
+			},
+			{
+				Target: diskutil.VolumeInfo{Name: "new", UUID: "new-uuid"},
+			},
+		},
+	}
+	want := Plan{
+		Source:   Volume{Name: "source", UUID: "source-uuid"},
+		Snapshot: Snapshot{Name: "snap-3", UUID: "snap-3-uuid", Created: &created},
+		Strategy: "latest-in-target",
+		Targets: []Target{
+			{
+				Volume:         Volume{Name: "target", UUID: "target-uuid"},
+				Base:           &snap2,
+				BaseReason:     "latest common snapshot in target",
+				Prune:          []Snapshot{snap2},
+				EstimatedBytes: 1000,
+			},
+			{
+				Volume:         Volume{Name: "new", UUID: "new-uuid"},
+				Initialize:     true,
+				Prune:          []Snapshot{},
+				EstimatedBytes: 3000,
+			},
+		},
+	}
+	got := New(cloner.New(nil, nil, cloner.Prune(true)), p)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("New returned unexpected plan. -want +got:\n%s", diff)
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "plan.json")
+	want := Plan{
+		Source:   Volume{Name: "source", UUID: "source-uuid"},
+		Snapshot: snap3,
+		Targets: []Target{{
+			Volume: Volume{Name: "target", UUID: "target-uuid"},
+			Base:   &snap2,
+			Prune:  []Snapshot{snap2},
+		}},
+	}
+	if err := Write(path, want); err != nil {
+		t.Fatalf("Write returned unexpected error: %v, want: nil", err)
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatalf("Read returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Read returned unexpected plan. -want +got:\n%s", diff)
+	}
+}
+
+func TestRead_Errors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Read(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("Read of missing file returned unexpected error: nil, want: non-nil")
+	}
+	path := filepath.Join(dir, "plan.json")
+	if err := Write(path, Plan{
+		Source:  Volume{Name: "source", UUID: "source-uuid"},
+		Targets: []Target{{Volume: Volume{Name: "target", UUID: "target-uuid"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(path); err == nil {
+		t.Error("Read of target without base returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	source := Volume{Name: "source", UUID: "source-uuid"}
+	target := func(base *Snapshot, prune ...Snapshot) Target {
+		return Target{
+			Volume:     Volume{Name: "target", UUID: "target-uuid"},
+			Initialize: base == nil,
+			Base:       base,
+			Prune:      prune,
+		}
+	}
+	before := Plan{
+		Source:   source,
+		Snapshot: snap3,
+		Targets:  []Target{target(&snap2, snap2)},
+	}
+	tests := []struct {
+		name  string
+		after Plan
+		want  []string
+	}{
+		{
+			name:  "same",
+			after: before,
+		},
+		{
+			name: "extra deletions",
+			after: Plan{
+				Source:   source,
+				Snapshot: snap3,
+				Targets:  []Target{target(&snap2, snap2, snap1)},
+			},
+			want: []string{`target "target" (target-uuid): also prunes snap-1 (snap-1-uuid)`},
+		},
+		{
+			name: "different snapshots",
+			after: Plan{
+				Source:   source,
+				Snapshot: snap2,
+				Targets:  []Target{target(&snap1)},
+			},
+			want: []string{
+				"restores targets to snapshot snap-2 (snap-2-uuid) instead of snap-3 (snap-3-uuid)",
+				`target "target" (target-uuid): is incrementally restored from snap-1 (snap-1-uuid) instead of snap-2 (snap-2-uuid)`,
+				`target "target" (target-uuid): no longer prunes snap-2 (snap-2-uuid)`,
+			},
+		},
+		{
+			name: "initialized",
+			after: Plan{
+				Source:   source,
+				Snapshot: snap3,
+				Targets:  []Target{target(nil)},
+			},
+			want: []string{
+				`target "target" (target-uuid): is initialized, erasing all of its data, instead of incrementally restored`,
+				`target "target" (target-uuid): no longer prunes snap-2 (snap-2-uuid)`,
+			},
+		},
+		{
+			name: "targets added and removed",
+			after: Plan{
+				Source:   source,
+				Snapshot: snap3,
+				Targets: []Target{{
+					Volume: Volume{Name: "other", UUID: "other-uuid"},
+					Base:   &snap2,
+					Prune:  []Snapshot{snap2},
+				}},
+			},
+			want: []string{
+				`target "other" (other-uuid): is newly cloned to, and pruned of snap-2 (snap-2-uuid)`,
+				`target "target" (target-uuid): is no longer cloned to`,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := Diff(before, test.after)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("Diff returned unexpected changes. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiff_Priorities(t *testing.T) {
+	source := Volume{Name: "source", UUID: "source-uuid"}
+	a := Target{Volume: Volume{Name: "a", UUID: "a-uuid"}, Base: &snap2}
+	b := Target{Volume: Volume{Name: "b", UUID: "b-uuid"}, Base: &snap2}
+	before := Plan{Source: source, Snapshot: snap3, Targets: []Target{a, b}}
+	b.Priority = 10
+	after := Plan{Source: source, Snapshot: snap3, Targets: []Target{b, a}}
+
+	want := []string{
+		`clones targets in order "b", "a" instead of "a", "b"`,
+		`target "b" (b-uuid): has priority 10 instead of 0`,
+	}
+	got := Diff(before, after)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Diff returned unexpected changes. -want +got:\n%s", diff)
+	}
+}
+
+func TestPrint(t *testing.T) {
+	p := Plan{
+		Source:   Volume{Name: "source", UUID: "source-uuid"},
+		Snapshot: snap3,
+		Strategy: "prefer-newest-common",
+		Targets: []Target{
+			{
+				Volume:         Volume{Name: "target", UUID: "target-uuid"},
+				Priority:       10,
+				Base:           &snap2,
+				BaseReason:     "newest of 2 common snapshot(s) by the timestamps in their names",
+				Prune:          []Snapshot{snap2, snap1},
+				EstimatedBytes: 1000,
+			},
+			{
+				Volume:     Volume{Name: "new", UUID: "new-uuid"},
+				Initialize: true,
+			},
+		},
+	}
+	want := `Plan: restore targets to snapshot snap-3 (snap-3-uuid) of "source" (source-uuid).
+Base snapshots are chosen by the prefer-newest-common strategy.
+Target "target" (target-uuid, priority 10):
+	Incrementally restore from snapshot snap-2 (snap-2-uuid) - the newest of 2 common snapshot(s) by the timestamps in their names
+	Copy about 1000 bytes
+	Prune snapshots:
+		snap-2 (snap-2-uuid)
+		snap-1 (snap-1-uuid)
+Target "new" (new-uuid):
+	Erase and initialize target, deleting all of its data and snapshots
+	Copy about 0 bytes
+	Prune no snapshots
+`
+	var got strings.Builder
+	Print(&got, p, func(n uint64) string { return fmt.Sprintf("%d bytes", n) })
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("Print printed unexpected plan. -want +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/plan"
+)
+
+// planCommand compares the plans of clones written with -plan.
+func planCommand(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "diff":
+			return planDiff(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, `Usage: %[1]s plan diff <before plan> <after plan>
+`, os.Args[0])
+	os.Exit(exitUsage)
+	return nil
+}
+
+// planDiff explains what would change between two plans, e.g. written with
+// -dryrun -plan before and after changing a backup set's retention options.
+func planDiff(args []string) error {
+	if len(args) != 2 {
+		fmt.Fprintf(os.Stderr, `Usage: %s plan diff <before plan> <after plan>
+
+Explains how the clone planned in <after plan> differs from the clone planned
+in <before plan>, e.g. extra snapshots pruned, or different snapshots
+restored. Write plans with -plan, e.g. with -dryrun.
+`, os.Args[0])
+		os.Exit(exitUsage)
+	}
+	before, err := plan.Read(args[0])
+	if err != nil {
+		return err
+	}
+	after, err := plan.Read(args[1])
+	if err != nil {
+		return err
+	}
+	changes := plan.Diff(before, after)
+	if len(changes) == 0 {
+		fmt.Println("The plans are the same.")
+		return nil
+	}
+	for _, c := range changes {
+		fmt.Println(c)
+	}
+	return nil
+}
+
+// newPlan returns the plan of cloning with c, as resolved by preflight checks.
+// priorities maps target volume UUIDs to their configured priorities.
+func newPlan(c cloner.Cloner, p cloner.Plan, priorities map[string]int) plan.Plan {
+	pl := plan.New(c, p)
+	for i, t := range pl.Targets {
+		pl.Targets[i].Priority = priorities[t.UUID]
+	}
+	return pl
+}
+
+// printPlan prints the plan of cloning with c, as resolved by preflight
+// checks, e.g. for review in dry runs.
+func printPlan(c cloner.Cloner, p cloner.Plan, priorities map[string]int) error {
+	f, err := loadFormatter(*configPath)
+	if err != nil {
+		return err
+	}
+	plan.Print(os.Stdout, newPlan(c, p, priorities), f.Bytes)
+	return nil
+}
+
+// writePlan writes the plan of cloning with c, as resolved by preflight
+// checks, to path.
+func writePlan(path string, c cloner.Cloner, p cloner.Plan, priorities map[string]int) error {
+	if err := plan.Write(path, newPlan(c, p, priorities)); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote plan to %q.\n", path)
+	return nil
+}
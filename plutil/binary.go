@@ -0,0 +1,312 @@
+package plutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// binaryMagic starts every binary plist.
+const binaryMagic = "bplist00"
+
+// binaryTrailerSize is the size of the trailer at the end of a binary plist.
+const binaryTrailerSize = 32
+
+// binaryEpoch is the Unix time that binary plist dates are relative to,
+// 2001-01-01T00:00:00Z.
+const binaryEpoch = 978307200
+
+// binaryDecoder decodes a binary plist. The format is documented in
+// CFBinaryPList.c of Apple's CoreFoundation sources: objects are referenced by
+// index into an offset table, which is located by the trailer.
+type binaryDecoder struct {
+	data          []byte
+	offsets       []uint64
+	objectRefSize int
+	// decoding is the set of objects currently being decoded, to detect
+	// cycles.
+	decoding map[uint64]bool
+}
+
+// decodeBinary decodes a binary plist into the same values as decodeXML.
+func decodeBinary(data []byte) (interface{}, error) {
+	if !bytes.HasPrefix(data, []byte(binaryMagic)) {
+		return nil, errors.New("not a binary plist")
+	}
+	if len(data) < len(binaryMagic)+binaryTrailerSize {
+		return nil, errors.New("binary plist is truncated")
+	}
+	trailer := data[len(data)-binaryTrailerSize:]
+	offsetIntSize := int(trailer[6])
+	objectRefSize := int(trailer[7])
+	numObjects := binary.BigEndian.Uint64(trailer[8:])
+	topObject := binary.BigEndian.Uint64(trailer[16:])
+	offsetTableOffset := binary.BigEndian.Uint64(trailer[24:])
+	if !validIntSize(offsetIntSize) || !validIntSize(objectRefSize) {
+		return nil, fmt.Errorf("invalid binary plist trailer: offset size %d, reference size %d", offsetIntSize, objectRefSize)
+	}
+	tableEnd := uint64(len(data) - binaryTrailerSize)
+	if offsetTableOffset < uint64(len(binaryMagic)) || offsetTableOffset > tableEnd || numObjects > (tableEnd-offsetTableOffset)/uint64(offsetIntSize) {
+		return nil, errors.New("invalid binary plist trailer: offset table out of bounds")
+	}
+	if topObject >= numObjects {
+		return nil, fmt.Errorf("invalid binary plist trailer: top object %d of %d objects", topObject, numObjects)
+	}
+	d := &binaryDecoder{
+		data:          data[:offsetTableOffset],
+		offsets:       make([]uint64, numObjects),
+		objectRefSize: objectRefSize,
+		decoding:      make(map[uint64]bool),
+	}
+	table := data[offsetTableOffset:]
+	for i := range d.offsets {
+		d.offsets[i] = readUint(table[i*offsetIntSize:], offsetIntSize)
+	}
+	return d.decodeObject(topObject)
+}
+
+func validIntSize(size int) bool {
+	return size == 1 || size == 2 || size == 4 || size == 8
+}
+
+// readUint reads a big-endian unsigned integer of size bytes from b, which
+// must be at least size bytes long.
+func readUint(b []byte, size int) uint64 {
+	var n uint64
+	for _, c := range b[:size] {
+		n = n<<8 | uint64(c)
+	}
+	return n
+}
+
+// bytesAt returns the n bytes at offset, or an error if they are out of
+// bounds.
+func (d *binaryDecoder) bytesAt(offset, n uint64) ([]byte, error) {
+	if offset > uint64(len(d.data)) || n > uint64(len(d.data))-offset {
+		return nil, fmt.Errorf("object at offset %d is truncated", offset)
+	}
+	return d.data[offset : offset+n], nil
+}
+
+// decodeObject decodes the object with index ref.
+func (d *binaryDecoder) decodeObject(ref uint64) (interface{}, error) {
+	if ref >= uint64(len(d.offsets)) {
+		return nil, fmt.Errorf("reference to object %d of %d objects", ref, len(d.offsets))
+	}
+	if d.decoding[ref] {
+		return nil, fmt.Errorf("object %d contains itself", ref)
+	}
+	d.decoding[ref] = true
+	defer delete(d.decoding, ref)
+
+	offset := d.offsets[ref]
+	marker, err := d.bytesAt(offset, 1)
+	if err != nil {
+		return nil, err
+	}
+	kind, info := marker[0]>>4, marker[0]&0x0f
+	offset++
+	switch kind {
+	case 0x0:
+		switch info {
+		case 0x8:
+			return false, nil
+		case 0x9:
+			return true, nil
+		}
+	case 0x1:
+		return d.decodeInt(offset, info)
+	case 0x2:
+		return d.decodeReal(offset, info)
+	case 0x3:
+		if info != 0x3 {
+			break
+		}
+		b, err := d.bytesAt(offset, 8)
+		if err != nil {
+			return nil, err
+		}
+		secs := math.Float64frombits(binary.BigEndian.Uint64(b))
+		// Dates are limited to thousands of years either side of the
+		// epoch, so that they convert to Unix times without overflow.
+		if !(math.Abs(secs) < 1e14) {
+			return nil, fmt.Errorf("invalid date %v", secs)
+		}
+		whole, frac := math.Modf(secs)
+		return time.Unix(binaryEpoch+int64(whole), int64(frac*1e9)).UTC(), nil
+	case 0x4:
+		count, offset, err := d.decodeCount(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytesAt(offset, count)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case 0x5:
+		count, offset, err := d.decodeCount(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := d.bytesAt(offset, count)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case 0x6:
+		count, offset, err := d.decodeCount(offset, info)
+		if err != nil {
+			return nil, err
+		}
+		if count > math.MaxUint64/2 {
+			return nil, fmt.Errorf("object at offset %d is truncated", offset)
+		}
+		b, err := d.bytesAt(offset, 2*count)
+		if err != nil {
+			return nil, err
+		}
+		units := make([]uint16, count)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		}
+		return string(utf16.Decode(units)), nil
+	case 0xa:
+		refs, err := d.decodeRefs(offset, info, 1)
+		if err != nil {
+			return nil, err
+		}
+		array := make([]interface{}, len(refs))
+		for i, r := range refs {
+			if array[i], err = d.decodeObject(r); err != nil {
+				return nil, err
+			}
+		}
+		return array, nil
+	case 0xd:
+		refs, err := d.decodeRefs(offset, info, 2)
+		if err != nil {
+			return nil, err
+		}
+		keys, values := refs[:len(refs)/2], refs[len(refs)/2:]
+		dict := make(map[string]interface{}, len(keys))
+		for i := range keys {
+			k, err := d.decodeObject(keys[i])
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("unexpected %T dict key, want string", k)
+			}
+			value, err := d.decodeObject(values[i])
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			dict[key] = value
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported object type 0x%02x at offset %d", marker[0], offset-1)
+}
+
+// decodeInt decodes an integer of 2^info bytes at offset. Integers of 8 bytes
+// are signed, and of 16 bytes hold unsigned 64-bit integers too large to be
+// signed.
+func (d *binaryDecoder) decodeInt(offset uint64, info byte) (json.Number, error) {
+	if info > 4 {
+		return "", fmt.Errorf("unsupported integer size %d", 1<<info)
+	}
+	size := uint64(1) << info
+	b, err := d.bytesAt(offset, size)
+	if err != nil {
+		return "", err
+	}
+	switch size {
+	case 8:
+		return json.Number(strconv.FormatInt(int64(binary.BigEndian.Uint64(b)), 10)), nil
+	case 16:
+		if binary.BigEndian.Uint64(b) != 0 {
+			return "", errors.New("integer overflows 64 bits")
+		}
+		return json.Number(strconv.FormatUint(binary.BigEndian.Uint64(b[8:]), 10)), nil
+	}
+	return json.Number(strconv.FormatUint(readUint(b, int(size)), 10)), nil
+}
+
+// decodeReal decodes a float of 2^info bytes at offset.
+func (d *binaryDecoder) decodeReal(offset uint64, info byte) (float64, error) {
+	var f float64
+	switch info {
+	case 2:
+		b, err := d.bytesAt(offset, 4)
+		if err != nil {
+			return 0, err
+		}
+		f = float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case 3:
+		b, err := d.bytesAt(offset, 8)
+		if err != nil {
+			return 0, err
+		}
+		f = math.Float64frombits(binary.BigEndian.Uint64(b))
+	default:
+		return 0, fmt.Errorf("unsupported real size %d", 1<<info)
+	}
+	// Like XML <real>s, reals must be representable in JSON.
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return 0, fmt.Errorf("invalid real %v", f)
+	}
+	return f, nil
+}
+
+// decodeCount decodes the count of a data, string, array, or dict object,
+// and returns the offset of its contents. Counts of 15 or more are stored in
+// an integer object following the marker.
+func (d *binaryDecoder) decodeCount(offset uint64, info byte) (count, contents uint64, err error) {
+	if info != 0xf {
+		return uint64(info), offset, nil
+	}
+	marker, err := d.bytesAt(offset, 1)
+	if err != nil {
+		return 0, 0, err
+	}
+	if marker[0]>>4 != 0x1 || marker[0]&0x0f > 3 {
+		return 0, 0, fmt.Errorf("invalid count at offset %d", offset)
+	}
+	size := uint64(1) << (marker[0] & 0x0f)
+	b, err := d.bytesAt(offset+1, size)
+	if err != nil {
+		return 0, 0, err
+	}
+	return readUint(b, int(size)), offset + 1 + size, nil
+}
+
+// decodeRefs decodes the object references of an array (perEntry 1) or dict
+// (perEntry 2, keys then values) at offset.
+func (d *binaryDecoder) decodeRefs(offset uint64, info byte, perEntry uint64) ([]uint64, error) {
+	count, offset, err := d.decodeCount(offset, info)
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(d.objectRefSize)
+	if count > math.MaxUint64/perEntry/size {
+		return nil, fmt.Errorf("object at offset %d is truncated", offset)
+	}
+	n := count * perEntry
+	b, err := d.bytesAt(offset, n*size)
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]uint64, n)
+	for i := range refs {
+		refs[i] = readUint(b[uint64(i)*size:], d.objectRefSize)
+	}
+	return refs, nil
+}
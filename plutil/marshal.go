@@ -0,0 +1,142 @@
+package plutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const xmlHeader = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+`
+
+// Marshal returns the XML plist encoding of v.
+//
+// Like Unmarshal, Marshal converts v to JSON using the encoding/json package,
+// so v is encoded as json.Marshal would encode it, with the same `json:"name"`
+// tags: objects as dicts, in the order of their keys (e.g. struct field
+// order), numbers with a fraction or exponent as reals, and other numbers as
+// integers. time.Times are encoded as RFC 3339 strings and []bytes as base64
+// strings, which Unmarshal decodes back into time.Times and []bytes. Plists
+// have no null, so null values are omitted from dicts, and are an error
+// elsewhere.
+func (pl PLUtil) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode json: %w", err)
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	e := &xmlEncoder{b: new(bytes.Buffer)}
+	e.b.WriteString(xmlHeader)
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok == nil {
+		return nil, errors.New("cannot encode null as a plist")
+	}
+	if err := e.encodeValue(d, tok, 0); err != nil {
+		return nil, err
+	}
+	e.b.WriteString("</plist>\n")
+	return e.b.Bytes(), nil
+}
+
+type xmlEncoder struct {
+	b *bytes.Buffer
+}
+
+// element writes an element of text at depth.
+func (e *xmlEncoder) element(depth int, name, text string) {
+	e.indent(depth)
+	fmt.Fprintf(e.b, "<%s>", name)
+	// Writes to a bytes.Buffer do not fail.
+	xml.EscapeText(e.b, []byte(text))
+	fmt.Fprintf(e.b, "</%s>\n", name)
+}
+
+func (e *xmlEncoder) indent(depth int) {
+	e.b.WriteString(strings.Repeat("\t", depth))
+}
+
+// encodeValue encodes the JSON value started by tok, at depth.
+func (e *xmlEncoder) encodeValue(d *json.Decoder, tok json.Token, depth int) error {
+	switch tok := tok.(type) {
+	case json.Delim:
+		if tok == '{' {
+			return e.encodeDict(d, depth)
+		}
+		return e.encodeArray(d, depth)
+	case string:
+		e.element(depth, "string", tok)
+	case json.Number:
+		if strings.ContainsAny(string(tok), ".eE") {
+			e.element(depth, "real", string(tok))
+		} else {
+			e.element(depth, "integer", string(tok))
+		}
+	case bool:
+		e.indent(depth)
+		fmt.Fprintf(e.b, "<%t/>\n", tok)
+	case nil:
+		return errors.New("cannot encode null as a plist value")
+	default:
+		return fmt.Errorf("unexpected json token %v", tok)
+	}
+	return nil
+}
+
+func (e *xmlEncoder) encodeDict(d *json.Decoder, depth int) error {
+	e.indent(depth)
+	e.b.WriteString("<dict>\n")
+	for d.More() {
+		key, err := d.Token()
+		if err != nil {
+			return err
+		}
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if tok == nil {
+			continue
+		}
+		e.element(depth+1, "key", key.(string))
+		if err := e.encodeValue(d, tok, depth+1); err != nil {
+			return fmt.Errorf("key %q: %w", key, err)
+		}
+	}
+	// Consume the closing delimiter.
+	if _, err := d.Token(); err != nil {
+		return err
+	}
+	e.indent(depth)
+	e.b.WriteString("</dict>\n")
+	return nil
+}
+
+func (e *xmlEncoder) encodeArray(d *json.Decoder, depth int) error {
+	e.indent(depth)
+	e.b.WriteString("<array>\n")
+	for d.More() {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		if err := e.encodeValue(d, tok, depth+1); err != nil {
+			return err
+		}
+	}
+	// Consume the closing delimiter.
+	if _, err := d.Token(); err != nil {
+		return err
+	}
+	e.indent(depth)
+	e.b.WriteString("</array>\n")
+	return nil
+}
@@ -1,4 +1,7 @@
-// Package plutil implements plist unmarshalling using MacOS's plutil.
+// Package plutil implements plist unmarshalling and marshalling. XML and
+// binary plists are decoded natively, without running MacOS's plutil, so that
+// plists can be decoded in minimal environments where plutil is missing, such
+// as MacOS Recovery. Plists are encoded as XML.
 //
 //	data := `<?xml version="1.0" encoding="UTF-8"?>
 //	<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
@@ -24,30 +27,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"os/exec"
 )
 
 // PLUtil parses and unmarshals plist-encoded data.
-type PLUtil struct {
-	execCommand func(string, ...string) *exec.Cmd
-}
+type PLUtil struct{}
 
 // Option configures the behavior of PLUtil.
 type Option func(*PLUtil)
 
-// WithExecCommand FOR USE IN TESTS ONLY replaces all uses of exec.Command with
-// f. It's used in tests to avoid calling the real plutil.
-func WithExecCommand(f func(string, ...string) *exec.Cmd) Option {
-	return func(pl *PLUtil) {
-		pl.execCommand = f
-	}
-}
-
 // New returns a new PLUtil with the given options.
 func New(opts ...Option) PLUtil {
-	pl := PLUtil{
-		execCommand: exec.Command,
-	}
+	var pl PLUtil
 	for _, opt := range opts {
 		opt(&pl)
 	}
@@ -62,23 +52,25 @@ func New(opts ...Option) PLUtil {
 // by json.Unmarshal, and the names of the fields of v must match the names of
 // the keys of the plist-encoded data, or have `json:"name"` tags.
 func (pl PLUtil) Unmarshal(data []byte, v interface{}) error {
-	cmd := pl.execCommand(
-		"plutil",
-		"-convert", "json",
-		// Read from stdin.
-		"-",
-		// Output to stdout.
-		"-o", "-")
-	cmd.Stdin = bytes.NewReader(data)
-	stdout, err := cmd.Output()
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, exitErr.Stderr)
-	}
+	jsonData, err := toJSON(data)
 	if err != nil {
-		return fmt.Errorf("`%s` failed (%w)", cmd, err)
+		return fmt.Errorf("failed to decode plist: %w", err)
 	}
-	if err := json.Unmarshal(stdout, v); err != nil {
+	if err := json.Unmarshal(jsonData, v); err != nil {
 		return fmt.Errorf("failed to parse json: %w", err)
 	}
 	return nil
 }
+
+// toJSON converts an XML or binary plist to JSON.
+func toJSON(data []byte) ([]byte, error) {
+	decode := decodeXML
+	if bytes.HasPrefix(data, []byte(binaryMagic)) {
+		decode = decodeBinary
+	}
+	decoded, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(decoded)
+}
@@ -1,21 +1,17 @@
 package plutil
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"errors"
-	"os/exec"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
-
-	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
 )
 
-func TestHelperProcess(t *testing.T) {
-	fakecmd.HelperProcess(t)
-}
-
 type simpleStruct struct {
 	Val string `json:"val"`
 }
@@ -23,57 +19,33 @@ type simpleStruct struct {
 func TestUnmarshal(t *testing.T) {
 	tests := []struct {
 		name string
-		opts []fakecmd.Option
-		data []byte
+		data string
 		want simpleStruct
 	}{
 		{
-			name: "unmarshals JSON stdout",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("plutil", `{"val": "example"}`),
-			},
+			name: "unmarshals plist",
+			data: `<plist version="1.0"><dict><key>val</key><string>example</string></dict></plist>`,
 			want: simpleStruct{
 				Val: "example",
 			},
 		},
 		{
 			name: "ignores unknown fields",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("plutil", `{"val": "example", "unknown": "foo"}`),
-			},
+			data: `<plist version="1.0"><dict><key>val</key><string>example</string><key>unknown</key><string>foo</string></dict></plist>`,
 			want: simpleStruct{
 				Val: "example",
 			},
 		},
 		{
-			name: "ignores stderr (if exit code 0)",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("plutil", "{}"),
-				fakecmd.Stderr("plutil", "example non-fatal error"),
-			},
-			want: simpleStruct{},
-		},
-		{
-			name: "passes r to stdin",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("plutil", "{}"),
-				fakecmd.WantStdin("plutil", "example stdin"),
-			},
-			data: []byte("example stdin"),
+			name: "empty dict",
+			data: `<plist version="1.0"><dict/></plist>`,
 			want: simpleStruct{},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			execCmd := fakecmd.FakeCommand(t, test.opts...)
-			pl := New(WithExecCommand(execCmd))
 			got := simpleStruct{}
-			err := pl.Unmarshal(test.data, &got)
-			if err := fakecmd.AsHelperProcessErr(err); err != nil {
-				// TODO: it would be nice if we could `t.Fatal(string(exitErr.Stderr))` instead, but exec.Cmd.Wait() does not populate this field. I don't see why it couldn't. Add it!
-				t.Fatal(err)
-			}
-			if err != nil {
+			if err := New().Unmarshal([]byte(test.data), &got); err != nil {
 				t.Fatalf("Unmarshal returned unexpected error: %q, want: nil", err)
 			}
 			if diff := cmp.Diff(test.want, got); diff != "" {
@@ -84,42 +56,308 @@ func TestUnmarshal(t *testing.T) {
 }
 
 func TestUnmarshal_Errors(t *testing.T) {
-	var exitErr *exec.ExitError
-	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
 
 	tests := []struct {
 		name      string
-		opts      []fakecmd.Option
+		data      string
 		wantErrAs interface{}
 	}{
 		{
-			name: "non-0 exit code",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("plutil", "{}"),
-				fakecmd.Stderr("plutil", "example stderr foobar"),
-				fakecmd.ExitFail("plutil"),
-			},
-			wantErrAs: &exitErr,
+			name: "not a plist",
+			data: "not-a-plist",
 		},
 		{
-			name: "invalid JSON returns unmarshal error",
-			opts: []fakecmd.Option{
-				fakecmd.Stdout("plutil", "not-json"),
-			},
-			wantErrAs: &syntaxErr,
+			name: "unexpected root element",
+			data: `<dict><key>val</key><string>example</string></dict>`,
+		},
+		{
+			name: "unsupported element",
+			data: `<plist version="1.0"><dict><key>val</key><uid>1</uid></dict></plist>`,
+		},
+		{
+			name:      "mismatched type returns unmarshal error",
+			data:      `<plist version="1.0"><dict><key>val</key><integer>42</integer></dict></plist>`,
+			wantErrAs: &typeErr,
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			execCmd := fakecmd.FakeCommand(t, test.opts...)
-			pl := New(WithExecCommand(execCmd))
-			err := pl.Unmarshal(nil, &simpleStruct{})
-			if err := fakecmd.AsHelperProcessErr(err); err != nil {
-				t.Fatal(err)
+			err := New().Unmarshal([]byte(test.data), &simpleStruct{})
+			if err == nil {
+				t.Fatal("Unmarshal returned unexpected error: nil, want: non-nil")
 			}
-			if !errors.As(err, test.wantErrAs) {
+			if test.wantErrAs != nil && !errors.As(err, test.wantErrAs) {
 				t.Errorf("Unmarshal returned unexpected error: %v, want type: %v", err, reflect.TypeOf(test.wantErrAs).Elem())
 			}
 		})
 	}
 }
+
+type nested struct {
+	Device string `json:"Device"`
+}
+
+type allTypes struct {
+	String  string    `json:"String"`
+	Unicode string    `json:"Unicode"`
+	Empty   string    `json:"Empty"`
+	Integer int64     `json:"Integer"`
+	Large   uint64    `json:"Large"`
+	Real    float64   `json:"Real"`
+	True    bool      `json:"True"`
+	False   bool      `json:"False"`
+	Date    time.Time `json:"Date"`
+	Data    []byte    `json:"Data"`
+	Array   []nested  `json:"Array"`
+	Dict    nested    `json:"Dict"`
+}
+
+var wantAllTypes = allTypes{
+	String:  "a & b",
+	Unicode: "café \U0001F4BE",
+	Integer: -42,
+	Large:   18446744073709551615,
+	Real:    1.5,
+	True:    true,
+	Date:    time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC),
+	Data:    []byte("hello"),
+	Array:   []nested{{Device: "disk0s2"}},
+	Dict:    nested{Device: "disk1"},
+}
+
+func TestUnmarshal_XML(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<!-- Comments are ignored. -->
+	<key>String</key>
+	<string>a &amp; b</string>
+	<key>Unicode</key>
+	<string>café 💾</string>
+	<key>Empty</key>
+	<string/>
+	<key>Integer</key>
+	<integer>-42</integer>
+	<key>Large</key>
+	<integer>18446744073709551615</integer>
+	<key>Real</key>
+	<real>1.5</real>
+	<key>True</key>
+	<true/>
+	<key>False</key>
+	<false/>
+	<key>Date</key>
+	<date>2021-02-03T04:05:06Z</date>
+	<key>Data</key>
+	<data>
+	aGVs
+	bG8=
+	</data>
+	<key>Array</key>
+	<array>
+		<dict>
+			<key>Device</key>
+			<string>disk0s2</string>
+		</dict>
+	</array>
+	<key>Dict</key>
+	<dict>
+		<key>Device</key>
+		<string>disk1</string>
+	</dict>
+	<key>Unknown</key>
+	<string>ignored</string>
+</dict>
+</plist>`)
+	var got allTypes
+	if err := New().Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal returned unexpected error: %q, want: nil", err)
+	}
+	if diff := cmp.Diff(wantAllTypes, got); diff != "" {
+		t.Errorf("Unmarshal resulted in unexpected value. -want +got:\n%s", diff)
+	}
+}
+
+func TestUnmarshal_Binary(t *testing.T) {
+	// The plist of TestUnmarshal_XML, encoded as a binary plist.
+	data, err := base64.StdEncoding.DecodeString("" +
+		"YnBsaXN0MDDdAQIDBAUGBwgJCgsMDQ4PEBESExQVFhcYHB5WU3RyaW5nV1VuaWNvZGVVRW1wdHlXSW50ZWdlclVMYXJnZVRSZWFsVFRydWVV" +
+		"RmFsc2VURGF0ZVREYXRhVUFycmF5VERpY3RXVW5rbm93blVhICYgYmcAYwBhAGYA6QAg2D3cvlAT/////////9YUAAAAAAAAAAD//////////yM/" +
+		"+AAAAAAAAAkIM0HC5Sw5AAAARWhlbGxvoRnRGhtWRGV2aWNlV2Rpc2swczLRGh1VZGlzazFXaWdub3JlZAgjKjI4QEZLUFZbYGZrc3mIiZKjrK2u" +
+		"t72/wsnR1NoAAAAAAAABAQAAAAAAAAAfAAAAAAAAAAAAAAAAAAAA4g==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got allTypes
+	if err := New().Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal returned unexpected error: %q, want: nil", err)
+	}
+	if diff := cmp.Diff(wantAllTypes, got); diff != "" {
+		t.Errorf("Unmarshal resulted in unexpected value. -want +got:\n%s", diff)
+	}
+}
+
+// binaryPlist returns a binary plist of objects, the first of which is the top
+// object. Objects reference each other by index.
+func binaryPlist(objects ...[]byte) []byte {
+	data := []byte(binaryMagic)
+	var offsets []byte
+	for _, o := range objects {
+		offsets = append(offsets, byte(len(data)))
+		data = append(data, o...)
+	}
+	tableOffset := len(data)
+	data = append(data, offsets...)
+	trailer := make([]byte, binaryTrailerSize)
+	trailer[6] = 1 // Offset size.
+	trailer[7] = 1 // Reference size.
+	binary.BigEndian.PutUint64(trailer[8:], uint64(len(objects)))
+	binary.BigEndian.PutUint64(trailer[24:], uint64(tableOffset))
+	return append(data, trailer...)
+}
+
+func TestUnmarshal_BinaryErrors(t *testing.T) {
+	valid := binaryPlist(
+		[]byte{0xd1, 1, 2}, // {"val": "example"}
+		[]byte("\x53val"),
+		[]byte("\x57example"),
+	)
+	var got simpleStruct
+	if err := New().Unmarshal(valid, &got); err != nil || got.Val != "example" {
+		t.Fatalf("Unmarshal(valid) = (%+v, %v), want: ({Val: example}, nil)", got, err)
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "truncated trailer",
+			data: valid[:len(valid)-1],
+		},
+		{
+			name: "truncated object",
+			data: binaryPlist([]byte("\x57exam")),
+		},
+		{
+			name: "reference out of bounds",
+			data: binaryPlist([]byte{0xa1, 5}),
+		},
+		{
+			name: "object contains itself",
+			data: binaryPlist([]byte{0xa1, 0}),
+		},
+		{
+			name: "non-string dict key",
+			data: binaryPlist([]byte{0xd1, 1, 1}, []byte{0x10, 42}),
+		},
+		{
+			name: "unsupported object type",
+			data: binaryPlist([]byte{0x80, 1}),
+		},
+		{
+			name: "offset table out of bounds",
+			data: func() []byte {
+				data := append([]byte(nil), valid...)
+				binary.BigEndian.PutUint64(data[len(data)-8:], uint64(len(data)))
+				return data
+			}(),
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := New().Unmarshal(test.data, &simpleStruct{}); err == nil {
+				t.Error("Unmarshal returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	type job struct {
+		Label     string   `json:"Label"`
+		Args      []string `json:"ProgramArguments"`
+		Interval  int      `json:"StartInterval,omitempty"`
+		RunAtLoad bool     `json:"RunAtLoad"`
+		Ratio     float64  `json:"Ratio"`
+		Stdin     *string  `json:"StandardInPath"`
+	}
+	got, err := New().Marshal(job{
+		Label:     "com.example.job",
+		Args:      []string{"/usr/local/bin/tool", "-flag", "a & <b>"},
+		Interval:  3600,
+		RunAtLoad: true,
+		Ratio:     0.5,
+	})
+	if err != nil {
+		t.Fatalf("Marshal returned unexpected error: %v, want: nil", err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>com.example.job</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>/usr/local/bin/tool</string>
+		<string>-flag</string>
+		<string>a &amp; &lt;b&gt;</string>
+	</array>
+	<key>StartInterval</key>
+	<integer>3600</integer>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>Ratio</key>
+	<real>0.5</real>
+</dict>
+</plist>
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Errorf("Marshal returned unexpected plist. -want +got:\n%s", diff)
+	}
+}
+
+func TestMarshal_RoundTrip(t *testing.T) {
+	pl := New()
+	data, err := pl.Marshal(wantAllTypes)
+	if err != nil {
+		t.Fatalf("Marshal returned unexpected error: %v, want: nil", err)
+	}
+	var got allTypes
+	if err := pl.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Unmarshal returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(wantAllTypes, got); diff != "" {
+		t.Errorf("Unmarshal(Marshal(v)) resulted in unexpected value. -want +got:\n%s", diff)
+	}
+}
+
+func TestMarshal_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		v    interface{}
+	}{
+		{
+			name: "null",
+			v:    nil,
+		},
+		{
+			name: "null in array",
+			v:    []*string{nil},
+		},
+		{
+			name: "not encodable as json",
+			v:    map[string]interface{}{"ch": make(chan int)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := New().Marshal(test.v); err == nil {
+				t.Error("Marshal returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package plutil
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// decodeXML decodes an XML plist into the equivalent of json.Unmarshal into an
+// interface{}: dicts are decoded as maps, arrays as slices, integers as
+// json.Numbers, and so on. Dates are decoded as time.Times and data as
+// []bytes, which json.Marshal encodes as strings.
+func decodeXML(data []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, errors.New("no <plist> element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local != "plist" {
+			return nil, fmt.Errorf("unexpected root element <%s>, want <plist>", start.Name.Local)
+		}
+		value, end, err := nextStart(d)
+		if err != nil {
+			return nil, err
+		}
+		if end {
+			return nil, errors.New("empty <plist> element")
+		}
+		return decodeValue(d, value)
+	}
+}
+
+// nextStart returns the next start element, skipping character data, comments,
+// and directives. end is true if the enclosing element ends first.
+func nextStart(d *xml.Decoder) (start xml.StartElement, end bool, err error) {
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return xml.StartElement{}, false, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return xml.StartElement{}, false, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			return tok, false, nil
+		case xml.EndElement:
+			return xml.StartElement{}, true, nil
+		case xml.CharData:
+			if len(bytes.TrimSpace(tok)) != 0 {
+				return xml.StartElement{}, false, fmt.Errorf("unexpected text %q", tok)
+			}
+		}
+	}
+}
+
+// decodeValue decodes the value of the plist element started by start.
+func decodeValue(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]interface{})
+		for {
+			keyStart, end, err := nextStart(d)
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				return dict, nil
+			}
+			if keyStart.Name.Local != "key" {
+				return nil, fmt.Errorf("unexpected <%s> in <dict>, want <key>", keyStart.Name.Local)
+			}
+			var key string
+			if err := d.DecodeElement(&key, &keyStart); err != nil {
+				return nil, err
+			}
+			valueStart, end, err := nextStart(d)
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				return nil, fmt.Errorf("missing value for key %q", key)
+			}
+			value, err := decodeValue(d, valueStart)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", key, err)
+			}
+			dict[key] = value
+		}
+	case "array":
+		array := []interface{}{}
+		for {
+			elemStart, end, err := nextStart(d)
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				return array, nil
+			}
+			elem, err := decodeValue(d, elemStart)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, elem)
+		}
+	case "true", "false":
+		if err := d.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := d.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	switch start.Name.Local {
+	case "string":
+		return text, nil
+	case "integer":
+		text = strings.TrimSpace(text)
+		if _, err := strconv.ParseInt(text, 10, 64); err != nil {
+			if _, err := strconv.ParseUint(text, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid <integer> %q", text)
+			}
+		}
+		return json.Number(text), nil
+	case "real":
+		f, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+		if err != nil || math.IsInf(f, 0) || math.IsNaN(f) {
+			return nil, fmt.Errorf("invalid <real> %q", text)
+		}
+		return f, nil
+	case "date":
+		t, err := time.Parse(time.RFC3339, strings.TrimSpace(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid <date> %q", text)
+		}
+		return t, nil
+	case "data":
+		text = strings.Map(func(r rune) rune {
+			if unicode.IsSpace(r) {
+				return -1
+			}
+			return r
+		}, text)
+		b, err := base64.StdEncoding.DecodeString(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid <data>: %w", err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unsupported element <%s>", start.Name.Local)
+}
@@ -0,0 +1,95 @@
+// Package policy implements the administrator's policy of which volumes may
+// be cloned to, protecting against typos that would erase the wrong disk.
+//
+// The policy is stored as JSON in a file that must be owned by root and not
+// writable by anyone else, so that it cannot be loosened by the users it
+// restricts. For example:
+//
+//	{"allowed_targets": ["1A2B3C4D-*", "5E6F7A8B-0000-4000-8000-000000000001"]}
+//
+// A missing policy file allows all targets.
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// DefaultPath is the location of the policy file.
+const DefaultPath = "/Library/Application Support/offsite-apfs-backup/policy.json"
+
+// Policy restricts which volumes may be targets.
+type Policy struct {
+	// AllowedTargets are patterns, in the syntax of path.Match, of the
+	// volume UUIDs that may be targets. Matching is case-insensitive. If
+	// nil, all volumes may be targets.
+	AllowedTargets []string `json:"allowed_targets"`
+}
+
+// Load reads the policy stored at path. If no file exists at path, a Policy
+// that allows all targets is returned. An error is returned if the file is
+// not owned by root, or is writable by group or others.
+func Load(path string) (*Policy, error) {
+	return load(path, 0)
+}
+
+func load(file string, ownerUID uint32) (*Policy, error) {
+	f, err := os.Open(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Policy{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("error reading policy: %w", err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != ownerUID {
+		return nil, fmt.Errorf("invalid policy file %q: must be owned by uid %d", file, ownerUID)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return nil, fmt.Errorf("invalid policy file %q: must not be writable by group or others", file)
+	}
+	var p Policy
+	if err := json.NewDecoder(f).Decode(&p); err != nil {
+		return nil, fmt.Errorf("error parsing policy file %q: %w", file, err)
+	}
+	for _, pattern := range p.AllowedTargets {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid policy file %q: bad pattern %q: %w", file, pattern, err)
+		}
+	}
+	return &p, nil
+}
+
+// AllowsTarget returns true if the volume with UUID uuid may be a target.
+func (p *Policy) AllowsTarget(uuid string) bool {
+	if p.AllowedTargets == nil {
+		return true
+	}
+	for _, pattern := range p.AllowedTargets {
+		if ok, _ := path.Match(strings.ToUpper(pattern), strings.ToUpper(uuid)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTargets returns an error if any of targets may not be a target.
+func (p *Policy) CheckTargets(targets ...diskutil.VolumeInfo) error {
+	for _, t := range targets {
+		if !p.AllowsTarget(t.UUID) {
+			return fmt.Errorf("target %q (%s) is not allowed by policy", t.Name, t.UUID)
+		}
+	}
+	return nil
+}
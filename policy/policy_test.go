@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func writePolicy(t *testing.T, content string, perm os.FileMode) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	// Set permissions explicitly, as WriteFile's are subject to umask.
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	p, err := Load(filepath.Join(t.TempDir(), "policy.json"))
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	if !p.AllowsTarget("any-uuid") {
+		t.Error("AllowsTarget of missing policy returned false, want: true")
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		perm    os.FileMode
+	}{
+		{
+			name:    "writable by others",
+			content: `{"allowed_targets": ["*"]}`,
+			perm:    0666,
+		},
+		{
+			name:    "writable by group",
+			content: `{"allowed_targets": ["*"]}`,
+			perm:    0620,
+		},
+		{
+			name:    "invalid JSON",
+			content: `{"allowed_targets": `,
+			perm:    0644,
+		},
+		{
+			name:    "bad pattern",
+			content: `{"allowed_targets": ["["]}`,
+			perm:    0644,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := writePolicy(t, test.content, test.perm)
+			if _, err := load(path, uint32(os.Getuid())); err == nil {
+				t.Error("load returned unexpected error: nil, want: non-nil")
+			}
+		})
+	}
+}
+
+func TestLoad_WrongOwner(t *testing.T) {
+	path := writePolicy(t, `{"allowed_targets": ["*"]}`, 0644)
+	if _, err := load(path, uint32(os.Getuid())+1); err == nil {
+		t.Error("load returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestAllowsTarget(t *testing.T) {
+	path := writePolicy(t, `{"allowed_targets": ["1a2b3c4d-*", "5E6F7A8B-0000-4000-8000-000000000001"]}`, 0644)
+	p, err := load(path, uint32(os.Getuid()))
+	if err != nil {
+		t.Fatalf("load returned unexpected error: %v, want: nil", err)
+	}
+	tests := []struct {
+		uuid string
+		want bool
+	}{
+		{uuid: "1A2B3C4D-0000-4000-8000-000000000009", want: true},
+		{uuid: "5E6F7A8B-0000-4000-8000-000000000001", want: true},
+		{uuid: "5e6f7a8b-0000-4000-8000-000000000001", want: true},
+		{uuid: "5E6F7A8B-0000-4000-8000-000000000002", want: false},
+		{uuid: "", want: false},
+	}
+	for _, test := range tests {
+		if got := p.AllowsTarget(test.uuid); got != test.want {
+			t.Errorf("AllowsTarget(%q) = %t, want: %t", test.uuid, got, test.want)
+		}
+	}
+
+	allowed := diskutil.VolumeInfo{Name: "allowed", UUID: "1A2B3C4D-0000-4000-8000-000000000009"}
+	denied := diskutil.VolumeInfo{Name: "denied", UUID: "5E6F7A8B-0000-4000-8000-000000000002"}
+	if err := p.CheckTargets(allowed); err != nil {
+		t.Errorf("CheckTargets(allowed) returned unexpected error: %v, want: nil", err)
+	}
+	if err := p.CheckTargets(allowed, denied); err == nil {
+		t.Error("CheckTargets(allowed, denied) returned unexpected error: nil, want: non-nil")
+	}
+}
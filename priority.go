@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// prioritizeTargets returns targets in the order they are cloned to: in order
+// of decreasing priority in the configuration file, and otherwise in the order
+// they are given. Targets' priorities are looked up by the target as given,
+// its paired name, and its volume UUID. priorities maps the volume UUIDs of
+// attached targets to their priorities, e.g. to record in plans.
+func prioritizeTargets(ctx context.Context, w io.Writer, du diskutil.DiskUtil, targets []string) (ordered []string, priorities map[string]int, err error) {
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, err := loadState(*statePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	priority := make(map[string]int) // Map of target, as given, to its priority.
+	priorities = make(map[string]int)
+	for _, t := range targets {
+		ids := []string{t}
+		info, err := du.Info(ctx, t)
+		if err == nil {
+			if p, err := st.Pairing(info.UUID); err == nil {
+				ids = append(ids, p.TargetName)
+			}
+			ids = append(ids, info.UUID)
+		}
+		priority[t] = cfg.Priority(ids...)
+		if err == nil {
+			priorities[info.UUID] = priority[t]
+		}
+	}
+	ordered = append([]string(nil), targets...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return priority[ordered[i]] > priority[ordered[j]]
+	})
+	for i := range targets {
+		if ordered[i] != targets[i] {
+			var order []string
+			for _, t := range ordered {
+				order = append(order, fmt.Sprintf("%q (priority %d)", t, priority[t]))
+			}
+			fmt.Fprintf(w, "Cloning to targets in order of priority: %s\n", strings.Join(order, ", "))
+			break
+		}
+	}
+	return ordered, priorities, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/estimate"
+)
+
+// progressBarWidth is the number of characters between the brackets of a
+// progress bar.
+const progressBarWidth = 40
+
+// progressBar renders the progress of a restore as a single line that is
+// redrawn in place, e.g.
+//
+//	[####################--------------------]  50% about 5m0s remaining for this target, 12m0s overall
+type progressBar struct {
+	w io.Writer
+	// drawn is true if a line has been drawn since the last call to
+	// finish.
+	drawn bool
+}
+
+// update redraws the bar at percent, followed by status.
+func (b *progressBar) update(percent int, status string) {
+	filled := progressBarWidth * percent / 100
+	bar := strings.Repeat("#", filled) + strings.Repeat("-", progressBarWidth-filled)
+	// Return to the start of the line, and clear the previous bar after
+	// drawing the new one, in case it was longer.
+	fmt.Fprintf(b.w, "\r\t[%s] %3d%% %s\x1b[K", bar, percent, status)
+	b.drawn = true
+}
+
+// finish ends the bar's line, if a bar was drawn, so that the next bar is
+// drawn on a new line.
+func (b *progressBar) finish() {
+	if b.drawn {
+		fmt.Fprintln(b.w)
+		b.drawn = false
+	}
+}
+
+// remaining describes the estimated time remaining for the current target and
+// for all targets, or returns false if no estimate is available.
+func remaining(tracker *estimate.Tracker) (string, bool) {
+	target, overall, ok := tracker.Remaining()
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("about %s remaining for this target, %s overall", target.Round(time.Second), overall.Round(time.Second)), true
+}
+
+// printRemaining prints the estimated time remaining for the current target
+// and for all targets, if an estimate is available.
+func printRemaining(w io.Writer, tracker *estimate.Tracker) {
+	status, ok := remaining(tracker)
+	if !ok {
+		return
+	}
+	// asr does not end its progress output with a newline until the
+	// restore is complete, so start a new line.
+	fmt.Fprintf(w, "\n%s%s.\n", strings.ToUpper(status[:1]), status[1:])
+}
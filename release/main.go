@@ -0,0 +1,80 @@
+// Command release builds a universal (amd64 and arm64) MacOS binary of
+// offsite-apfs-backup for distribution. Completion scripts, launchd
+// templates, and the runbook are embedded in the binary (see the resources
+// package), so the binary is the only file that needs to be installed.
+//
+// Run from the root of the repository on MacOS:
+//
+//	go run ./release -version v1.2.3
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+var (
+	version = flag.String("version", "dev", `Version embedded in the binary.`)
+	outDir  = flag.String("o", "dist", `Directory to write the binary to.`)
+)
+
+// archs are the architectures included in the universal binary.
+var archs = []string{"amd64", "arm64"}
+
+const name = "offsite-apfs-backup"
+
+func main() {
+	flag.Parse()
+	if err := release(); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
+
+func release() error {
+	if err := os.MkdirAll(*outDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.MkdirTemp("", name)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	var binaries []string
+	for _, arch := range archs {
+		out := filepath.Join(tmp, name+"-"+arch)
+		fmt.Printf("Building %s...\n", out)
+		err := run(exec.Command("go", "build",
+			"-trimpath",
+			"-ldflags", "-s -w -X main.version="+*version,
+			"-o", out,
+			".",
+		), "GOOS=darwin", "GOARCH="+arch, "CGO_ENABLED=1")
+		if err != nil {
+			return err
+		}
+		binaries = append(binaries, out)
+	}
+
+	out := filepath.Join(*outDir, name)
+	fmt.Printf("Creating universal binary %s...\n", out)
+	args := append([]string{"-create", "-output", out}, binaries...)
+	return run(exec.Command("lipo", args...))
+}
+
+// run runs cmd with env added to the current environment.
+func run(cmd *exec.Cmd, env ...string) error {
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = os.Stdout
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+# bash completion for offsite-apfs-backup.
+# Install with: offsite-apfs-backup completion bash > /usr/local/etc/bash_completion.d/offsite-apfs-backup
+
+_offsite_apfs_backup() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local commands="advise audit batch bench-asr catalog clone completion diagnose estimate explain install-agent list-snapshots list-targets migrate-source mount plan restore retire run runbook schedule schema state status unmount verify watch"
+	local flags="-prune -keep -prune-source -verify-before-prune -initialize -dryrun -plan -only -wait -global-lock -state -audit-log -label -touch-id -yes -force -container -strict -health-interval -max-runtime -metrics-textfile -log-file -asr-attempts -asr-backoff -v -q -no-color -to-snapshot -prefer-newest-common -snapshot-before-clone -eject -launchd -config -explain -json-errors"
+
+	if [[ ${COMP_CWORD} -eq 1 && ${cur} != -* ]]; then
+		COMPREPLY=($(compgen -W "${commands}" -- "${cur}") $(compgen -d -- "${cur}"))
+		return
+	fi
+	case "${COMP_WORDS[1]}" in
+	completion)
+		COMPREPLY=($(compgen -W "bash zsh" -- "${cur}"))
+		return
+		;;
+	explain)
+		COMPREPLY=($(compgen -W "$(offsite-apfs-backup explain 2>/dev/null | grep -v $'^\t')" -- "${cur}"))
+		return
+		;;
+	schedule)
+		if [[ ${COMP_CWORD} -eq 2 ]]; then
+			COMPREPLY=($(compgen -W "install uninstall" -- "${cur}"))
+			return
+		fi
+		flags="-label -interval -on-mount -prune -strict -run-label -state"
+		;;
+	plan)
+		if [[ ${COMP_CWORD} -eq 2 ]]; then
+			COMPREPLY=($(compgen -W "diff" -- "${cur}"))
+		else
+			COMPREPLY=($(compgen -f -- "${cur}"))
+		fi
+		return
+		;;
+	schema)
+		COMPREPLY=($(compgen -W "audit batch-request batch-result config diagnostics error plan status" -- "${cur}"))
+		return
+		;;
+	state)
+		if [[ ${COMP_CWORD} -eq 2 ]]; then
+			COMPREPLY=($(compgen -W "migrate" -- "${cur}"))
+			return
+		fi
+		flags="-dryrun -state"
+		;;
+	retire)
+		flags="-erase -touch-id -state -audit-log"
+		;;
+	audit)
+		flags="-audit-log -volume -run -label -operation -since -json -config"
+		;;
+	catalog)
+		flags="-state -label -target -config -verbose"
+		;;
+	migrate-source)
+		flags="-dryrun -state -config"
+		;;
+	list-targets)
+		flags="-initialize"
+		;;
+	mount)
+		flags="-read-only -state"
+		;;
+	unmount | runbook)
+		flags="-state"
+		;;
+	list-snapshots)
+		flags="-state -config"
+		;;
+	status)
+		flags="-state -config -notify -json"
+		;;
+	advise | estimate)
+		flags="-state -config"
+		;;
+	verify)
+		flags="-path -hash -from-last-run -state"
+		;;
+	diagnose)
+		flags="-performance -state -o"
+		;;
+	watch)
+		flags="-interval -config -state -metrics-listen -dryrun"
+		;;
+	install-agent)
+		flags="-label -interval -on-mount -log -config -state"
+		;;
+	bench-asr)
+		flags="-dir -size -config -dryrun"
+		;;
+	batch)
+		flags="-wait -global-lock -strict -health-interval -state -audit-log"
+		;;
+	esac
+	if [[ ${cur} == -* ]]; then
+		COMPREPLY=($(compgen -W "${flags}" -- "${cur}"))
+	else
+		COMPREPLY=($(compgen -d -- "${cur}"))
+	fi
+}
+
+complete -o filenames -F _offsite_apfs_backup offsite-apfs-backup
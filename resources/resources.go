@@ -0,0 +1,114 @@
+// Package resources provides the files that are embedded in the binary, so
+// that shell completion, scheduling, and the runbook work without any files
+// installed alongside the binary.
+package resources
+
+import (
+	"bytes"
+	"embed"
+	"encoding/xml"
+	"fmt"
+	"text/template"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// Directories do not embed files starting with _, such as zsh completion
+// scripts, so they are listed explicitly.
+//
+//go:embed completion launchd runbook completion/_offsite-apfs-backup
+var files embed.FS
+
+// completions maps shell names to the path of their completion script.
+var completions = map[string]string{
+	"bash": "completion/offsite-apfs-backup.bash",
+	"zsh":  "completion/_offsite-apfs-backup",
+}
+
+// Shells are the shells that have completion scripts.
+var Shells = []string{"bash", "zsh"}
+
+// Completion returns the completion script for shell.
+func Completion(shell string) ([]byte, error) {
+	path, exists := completions[shell]
+	if !exists {
+		return nil, fmt.Errorf("no completion script for shell %q, want one of: %v", shell, Shells)
+	}
+	return files.ReadFile(path)
+}
+
+// LaunchdJob describes a launchd job that runs the binary.
+type LaunchdJob struct {
+	// Label uniquely identifies the job, e.g.
+	// com.voidingwarranties.offsite-apfs-backup.
+	Label string
+	// Args are the program arguments, starting with the path to the
+	// binary.
+	Args []string
+	// StdinPath, if set, is the path of the file used as the job's stdin.
+	StdinPath string
+	// LogPath is the path of the file that stdout and stderr are appended
+	// to.
+	LogPath string
+	// Interval, if non-zero, runs the job every Interval seconds.
+	Interval int
+	// OnMount runs the job whenever a volume is mounted.
+	OnMount bool
+}
+
+var launchdTemplate = template.Must(template.New("job.plist.tmpl").Funcs(template.FuncMap{
+	"xml": xmlEscape,
+}).ParseFS(files, "launchd/job.plist.tmpl"))
+
+// LaunchdPlist returns the launchd property list of job.
+func LaunchdPlist(job LaunchdJob) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if err := launchdTemplate.Execute(b, job); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// LaunchdCloneArgs returns the program arguments of a launchd job that runs the
+// binary at exe in -launchd mode, cloning source to targets. flags are passed
+// before the volumes, e.g. -prune.
+func LaunchdCloneArgs(exe string, flags []string, source string, targets ...string) []string {
+	args := append([]string{exe, "-launchd"}, flags...)
+	args = append(args, "--", source)
+	return append(args, targets...)
+}
+
+// LaunchdRunArgs returns the program arguments of a launchd job that runs the
+// binary at exe in -launchd mode, cloning the backup set named set. flags are
+// passed before the set, e.g. -config.
+func LaunchdRunArgs(exe string, flags []string, set string) []string {
+	args := append([]string{exe, "run", "-launchd"}, flags...)
+	return append(args, "--", set)
+}
+
+func xmlEscape(s string) (string, error) {
+	b := new(bytes.Buffer)
+	if err := xml.EscapeText(b, []byte(s)); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+var runbookTemplate = template.Must(template.ParseFS(files, "runbook/runbook.md.tmpl"))
+
+// Runbook returns the runbook for rotating the paired targets of st off-site.
+func Runbook(st *state.State, generated time.Time) ([]byte, error) {
+	b := new(bytes.Buffer)
+	err := runbookTemplate.Execute(b, struct {
+		Generated time.Time
+		Pairings  []state.Pairing
+	}{
+		Generated: generated,
+		Pairings:  st.Pairings,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,124 @@
+package resources
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+func TestCompletion(t *testing.T) {
+	for _, shell := range Shells {
+		script, err := Completion(shell)
+		if err != nil {
+			t.Errorf("Completion(%q) returned unexpected error: %v, want: nil", shell, err)
+		}
+		if len(script) == 0 {
+			t.Errorf("Completion(%q) returned an empty script", shell)
+		}
+	}
+	if _, err := Completion("fish"); err == nil {
+		t.Error("Completion(\"fish\") returned nil error, want non-nil")
+	}
+}
+
+func TestLaunchdPlist(t *testing.T) {
+	job := LaunchdJob{
+		Label:     "com.example.backup",
+		Args:      []string{"/usr/local/bin/offsite-apfs-backup", "batch", "-state", "/tmp/a & <b>"},
+		StdinPath: "/tmp/requests.json",
+		LogPath:   "/tmp/backup.log",
+		Interval:  3600,
+		OnMount:   true,
+	}
+	plist, err := LaunchdPlist(job)
+	if err != nil {
+		t.Fatalf("LaunchdPlist returned unexpected error: %v, want: nil", err)
+	}
+
+	// Parse the plist's top-level dict as alternating keys and values.
+	var doc struct {
+		Dict struct {
+			Items []struct {
+				XMLName xml.Name
+				Value   string   `xml:",chardata"`
+				Strings []string `xml:"string"`
+			} `xml:",any"`
+		} `xml:"dict"`
+	}
+	if err := xml.Unmarshal(plist, &doc); err != nil {
+		t.Fatalf("LaunchdPlist returned invalid XML: %v\n%s", err, plist)
+	}
+	got := make(map[string]interface{})
+	items := doc.Dict.Items
+	for i := 0; i+1 < len(items); i += 2 {
+		key, value := items[i].Value, items[i+1]
+		switch value.XMLName.Local {
+		case "array":
+			got[key] = value.Strings
+		case "true":
+			got[key] = "true"
+		default:
+			got[key] = strings.TrimSpace(value.Value)
+		}
+	}
+	want := map[string]interface{}{
+		"Label":             job.Label,
+		"ProgramArguments":  job.Args,
+		"StandardInPath":    job.StdinPath,
+		"StandardOutPath":   job.LogPath,
+		"StandardErrorPath": job.LogPath,
+		"StartInterval":     "3600",
+		"StartOnMount":      "true",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LaunchdPlist returned unexpected plist. -want +got:\n%s", diff)
+	}
+}
+
+func TestLaunchdCloneArgs(t *testing.T) {
+	got := LaunchdCloneArgs("/usr/local/bin/offsite-apfs-backup", []string{"-prune", "-state", "/tmp/state.json"}, "source-uuid", "target-1", "target-2")
+	want := []string{
+		"/usr/local/bin/offsite-apfs-backup",
+		"-launchd",
+		"-prune", "-state", "/tmp/state.json",
+		"--",
+		"source-uuid", "target-1", "target-2",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LaunchdCloneArgs returned unexpected args. -want +got:\n%s", diff)
+	}
+}
+
+func TestLaunchdRunArgs(t *testing.T) {
+	got := LaunchdRunArgs("/usr/local/bin/offsite-apfs-backup", []string{"-config", "/tmp/my config.json"}, "-homefolder")
+	want := []string{
+		"/usr/local/bin/offsite-apfs-backup",
+		"run",
+		"-launchd",
+		"-config", "/tmp/my config.json",
+		"--",
+		"-homefolder",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("LaunchdRunArgs returned unexpected args. -want +got:\n%s", diff)
+	}
+}
+
+func TestRunbook(t *testing.T) {
+	st := &state.State{}
+	st.Pair("source-uuid", "target-uuid", "offsite-1", time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	runbook, err := Runbook(st, time.Date(2021, 4, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("Runbook returned unexpected error: %v, want: nil", err)
+	}
+	for _, want := range []string{"Generated 2021-04-01", "offsite-1 (target-uuid)", "source-uuid on\n  2021-03-01"} {
+		if !strings.Contains(string(runbook), want) {
+			t.Errorf("Runbook returned runbook without %q:\n%s", want, runbook)
+		}
+	}
+}
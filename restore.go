@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/pairing"
+)
+
+// restoreCommand restores a local volume from an offsite target, e.g. after
+// losing the source, by cloning in the opposite direction, with the same flags
+// and checks as cloning volumes.
+func restoreCommand(args []string) error {
+	ctx := context.Background()
+	flag.CommandLine.Parse(args)
+	if flag.NArg() != 2 {
+		fmt.Fprintln(flag.CommandLine.Output(), "Error: exactly one <offsite volume> and one <local volume> are required")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	// -prune and -keep would prune the local volume, which restores would
+	// otherwise leave as it was on the offsite volume.
+	if *prune || *keep != 0 || *pruneSource || *container || *launchdMode || *snapshotBeforeClone {
+		err := errors.New("restore is incompatible with -prune, -keep, -prune-source, -container, -launchd, and -snapshot-before-clone")
+		fmt.Fprintln(flag.CommandLine.Output(), "Error:", err)
+		printJSONError(err, "")
+		flag.Usage()
+		os.Exit(exitUsage)
+	}
+	offsite, local := flag.Arg(0), flag.Arg(1)
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	// Restoring erases local, so refuse to restore a volume from another
+	// volume's target, unless initializing a new volume.
+	if err := pairing.CheckRestore(ctx, newDiskUtil(), st, offsite, local, *initialize); err != nil {
+		return fmt.Errorf("refusing to restore: %w", err)
+	}
+	fmt.Printf("Restoring %q from offsite target %q.\n", local, offsite)
+	cloneVolumes(offsite, []string{local}, restoreOptions()...)
+	return nil
+}
+
+// restoreOptions returns the runOptions of restores, which turn off the side
+// effects of cloning that only apply to offsite targets: the local volume is
+// not an offsite target of the offsite volume.
+func restoreOptions() []runOption {
+	return []runOption{
+		recordRun(false),
+		targetHistory(false),
+		ejectTargets(false),
+		targetPolicy(false),
+	}
+}
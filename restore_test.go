@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestRestoreOptions(t *testing.T) {
+	cfg := newRunConfig()
+	if !cfg.record || !cfg.history || !cfg.eject || !cfg.policy {
+		t.Fatalf("newRunConfig() = %+v, want record, history, eject, and policy", cfg)
+	}
+	cfg = newRunConfig(restoreOptions()...)
+	if cfg.record {
+		t.Error("Restores record clones in the state file, want not recorded")
+	}
+	if cfg.history {
+		t.Error("Restores write the history file to the local volume, want not written")
+	}
+	if cfg.eject {
+		t.Error("Restores eject the local volume, want not ejected")
+	}
+	if cfg.policy {
+		t.Error("Restores check the local volume against the policy file, want not checked")
+	}
+}
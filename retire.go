@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/audit"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// retire permanently removes a target from service: its pairing is removed
+// from the state file and, optionally, the volume is erased.
+func retire(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("retire", flag.ExitOnError)
+	erase := fs.Bool("erase", false, `If true, erase all data and snapshots on the target volume before retiring it.
+For encrypted volumes, this discards the volume's encryption keys, making the old data unrecoverable.
+The target must be attached.`)
+	fs.BoolVar(touchID, "touch-id", false, `If true, confirm with Touch ID instead of by typing the name of the target.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	auditPath := fs.String("audit-log", audit.DefaultPath, `Path to the append-only log of renames, snapshot deletions, erases, and destructive restores.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s retire [-erase] [-touch-id] [-state <path>] [-audit-log <path>] <target volume>
+
+  <target volume>
+    	Paired target volume to retire.
+    	May be a mount point, /dev/ path, volume UUID, or the name of a paired target.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <target volume> is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	target := fs.Arg(0)
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	du := audit.DiskUtil(newDiskUtil(), audit.New(*auditPath, audit.Clock(clk), audit.RunID(runIDs.NewID())))
+	info, infoErr := du.Info(ctx, target)
+	if *erase && infoErr != nil {
+		return fmt.Errorf("target must be attached to be erased: %v", infoErr)
+	}
+	var pairing state.Pairing
+	if infoErr == nil {
+		pairing, err = st.Pairing(info.UUID)
+	} else {
+		// Allow retiring targets that are no longer attached (e.g. lost
+		// or broken disks) by their recorded name or UUID.
+		pairing, err = st.Pairing(target)
+	}
+	if err != nil {
+		return err
+	}
+	if *erase && info.FileSystemType != "apfs" {
+		return errors.New("invalid target volume: does not contain an APFS file system")
+	}
+
+	if *erase {
+		fmt.Printf("This will erase all data and snapshots on %q (%s) and unpair it from source %s.\n", pairing.TargetName, pairing.TargetUUID, pairing.SourceUUID)
+	} else {
+		fmt.Printf("This will unpair %q (%s) from source %s.\n", pairing.TargetName, pairing.TargetUUID, pairing.SourceUUID)
+	}
+	if err := confirmTyped(pairing.TargetName); err != nil {
+		return err
+	}
+
+	if *erase {
+		if err := du.EraseVolume(ctx, info, info.Name); err != nil {
+			return fmt.Errorf("error erasing target: %v", err)
+		}
+		fmt.Println("Erased target.")
+	}
+	if _, err := st.Retire(pairing.TargetUUID, *erase, clk.Now()); err != nil {
+		return err
+	}
+	if err := st.Save(*statePath); err != nil {
+		return err
+	}
+	fmt.Printf("Retired %q.\n", pairing.TargetName)
+	return nil
+}
+
+// confirmTyped prompts the user to type want, and returns an error if they
+// type anything else. If -touch-id is set, the user confirms with Touch ID
+// instead.
+func confirmTyped(want string) error {
+	if *touchID {
+		return confirmTouchID(fmt.Sprintf("retire %s", want))
+	}
+	if err := checkInteractive(); err != nil {
+		return err
+	}
+	fmt.Printf("This cannot be undone. Type the name of the target (%s) to confirm: ", want)
+	r := bufio.NewReader(stdin)
+	response, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(response) != want {
+		return errDeclined
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Package rusage implements accounting of the CPU time and peak memory used by
+// this process and its child processes, e.g. asr, to decide whether clones
+// need to be run at a lower priority.
+package rusage
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// Usage is the resources used by a process.
+type Usage struct {
+	// User and System are the CPU time spent in user and system mode.
+	User   time.Duration
+	System time.Duration
+	// MaxRSS is the peak resident memory, in bytes.
+	MaxRSS uint64
+}
+
+// CPU returns the total CPU time.
+func (u Usage) CPU() time.Duration {
+	return u.User + u.System
+}
+
+// Add returns the combined usage of u and other, as if used by processes run
+// one after another: the sum of their CPU times, and the larger peak memory.
+func (u Usage) Add(other Usage) Usage {
+	sum := Usage{
+		User:   u.User + other.User,
+		System: u.System + other.System,
+		MaxRSS: u.MaxRSS,
+	}
+	if other.MaxRSS > sum.MaxRSS {
+		sum.MaxRSS = other.MaxRSS
+	}
+	return sum
+}
+
+// Sub returns the CPU time used since before, e.g. by this process during a
+// clone. The peak memory is u's, as peak memory is not measured per interval.
+func (u Usage) Sub(before Usage) Usage {
+	return Usage{
+		User:   u.User - before.User,
+		System: u.System - before.System,
+		MaxRSS: u.MaxRSS,
+	}
+}
+
+func (u Usage) String() string {
+	return fmt.Sprintf("%s CPU (%s user, %s system), %d bytes peak memory", u.CPU(), u.User, u.System, u.MaxRSS)
+}
+
+// Self returns the resources used by this process so far.
+func Self() (Usage, error) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return Usage{}, fmt.Errorf("getrusage failed: %w", err)
+	}
+	return fromRusage(&ru), nil
+}
+
+// FromProcessState returns the resources used by an exited child process, as
+// reported by wait4. ok is false if ps is nil, e.g. because the process never
+// started, or does not report its resources.
+func FromProcessState(ps *os.ProcessState) (u Usage, ok bool) {
+	if ps == nil {
+		return Usage{}, false
+	}
+	ru, ok := ps.SysUsage().(*syscall.Rusage)
+	if !ok || ru == nil {
+		return Usage{}, false
+	}
+	return fromRusage(ru), true
+}
+
+func fromRusage(ru *syscall.Rusage) Usage {
+	return Usage{
+		User:   time.Duration(ru.Utime.Nano()),
+		System: time.Duration(ru.Stime.Nano()),
+		MaxRSS: maxRSSBytes(ru.Maxrss),
+	}
+}
+
+// maxRSSBytes returns the ru_maxrss of getrusage in bytes. macOS reports it in
+// bytes, and Linux in kilobytes.
+func maxRSSBytes(maxrss int64) uint64 {
+	if runtime.GOOS == "darwin" {
+		return uint64(maxrss)
+	}
+	return uint64(maxrss) * 1024
+}
@@ -0,0 +1,57 @@
+package rusage
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUsage_Add(t *testing.T) {
+	a := Usage{User: time.Second, System: 2 * time.Second, MaxRSS: 100}
+	b := Usage{User: 3 * time.Second, System: 4 * time.Second, MaxRSS: 300}
+	want := Usage{User: 4 * time.Second, System: 6 * time.Second, MaxRSS: 300}
+	if diff := cmp.Diff(want, a.Add(b)); diff != "" {
+		t.Errorf("Add returned unexpected usage. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff(want, b.Add(a)); diff != "" {
+		t.Errorf("Add returned unexpected usage. -want +got:\n%s", diff)
+	}
+}
+
+func TestUsage_Sub(t *testing.T) {
+	before := Usage{User: time.Second, System: 2 * time.Second, MaxRSS: 100}
+	after := Usage{User: 3 * time.Second, System: 3 * time.Second, MaxRSS: 300}
+	want := Usage{User: 2 * time.Second, System: time.Second, MaxRSS: 300}
+	if diff := cmp.Diff(want, after.Sub(before)); diff != "" {
+		t.Errorf("Sub returned unexpected usage. -want +got:\n%s", diff)
+	}
+}
+
+func TestSelf(t *testing.T) {
+	u, err := Self()
+	if err != nil {
+		t.Fatalf("Self returned unexpected error: %v, want: nil", err)
+	}
+	if u.MaxRSS == 0 {
+		t.Errorf("Self returned 0 peak memory, want: non-zero")
+	}
+}
+
+func TestFromProcessState(t *testing.T) {
+	if _, ok := FromProcessState(nil); ok {
+		t.Error("FromProcessState(nil) returned ok: true, want: false")
+	}
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("unable to run true: %v", err)
+	}
+	u, ok := FromProcessState(cmd.ProcessState)
+	if !ok {
+		t.Fatal("FromProcessState returned ok: false, want: true")
+	}
+	if u.MaxRSS == 0 {
+		t.Errorf("FromProcessState returned 0 peak memory, want: non-zero")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/resources"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+const (
+	defaultLabel = "com.voidingwarranties.offsite-apfs-backup"
+	// launchDaemonsDir is where the plists of system-wide launchd jobs are
+	// installed. Clones require root, so jobs are daemons rather than
+	// per-user agents.
+	launchDaemonsDir = "/Library/LaunchDaemons"
+	scheduleLogPath  = "/Library/Logs/offsite-apfs-backup.log"
+)
+
+// schedule installs or uninstalls a launchd job that periodically clones a
+// source to targets.
+func schedule(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "install":
+			return scheduleInstall(args[1:])
+		case "uninstall":
+			return scheduleUninstall(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, `Usage: %[1]s schedule install [-label <label>] [-interval <duration>] [-on-mount] [-prune] [-strict] [-run-label <label>] [-state <path>] <source volume> <target volume> [<target volume>...]
+       %[1]s schedule uninstall [-label <label>]
+`, os.Args[0])
+	os.Exit(exitUsage)
+	return nil
+}
+
+func scheduleInstall(args []string) error {
+	fs := flag.NewFlagSet("schedule install", flag.ExitOnError)
+	label := fs.String("label", defaultLabel, `Label of the launchd job. Use different labels to install multiple schedules.`)
+	interval := fs.Duration("interval", 0, `How often to clone, e.g. 24h.`)
+	onMount := fs.Bool("on-mount", false, `If true, clone whenever a volume is mounted, e.g. when a target is attached.`)
+	prune := fs.Bool("prune", false, `If true, prune from targets the latest snapshot in common before each clone.`)
+	strict := fs.Bool("strict", false, `If true, scheduled clones fail if preflight checks warn about anything, e.g. a stale source snapshot.`)
+	runLabel := fs.String("run-label", "", `Name of the backup routine recorded with each scheduled run, e.g. weekly-offsite.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s schedule install [-label <label>] [-interval <duration>] [-on-mount] [-prune] [-strict] [-run-label <label>] [-state <path>] <source volume> <target volume> [<target volume>...]
+
+Installs and loads a launchd job that clones source to targets in -launchd
+mode, without confirmation. At least one of -interval or -on-mount is required.
+Targets that are not attached when the job runs are skipped.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() < 2 {
+		fmt.Fprintln(fs.Output(), "Error: <source volume> and at least one <target volume> are required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if err := validateLabel(*runLabel); err != nil {
+		fmt.Fprintln(fs.Output(), "Error:", err)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *interval <= 0 && !*onMount {
+		fmt.Fprintln(fs.Output(), "Error: at least one of -interval or -on-mount is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	// The job runs in -launchd mode, so that it never prompts.
+	flags := []string{"-state", *statePath}
+	if *prune {
+		flags = append(flags, "-prune")
+	}
+	if *strict {
+		flags = append(flags, "-strict")
+	}
+	if *runLabel != "" {
+		flags = append(flags, "-label", *runLabel)
+	}
+	plist, err := resources.LaunchdPlist(resources.LaunchdJob{
+		Label:    *label,
+		Args:     resources.LaunchdCloneArgs(exe, flags, fs.Arg(0), fs.Args()[1:]...),
+		LogPath:  scheduleLogPath,
+		Interval: int(interval.Round(time.Second).Seconds()),
+		OnMount:  *onMount,
+	})
+	if err != nil {
+		return err
+	}
+
+	plistPath, err := installLaunchdJob(*label, plist)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Installed %s. Logs are written to %s.\n", plistPath, scheduleLogPath)
+	return nil
+}
+
+// installLaunchdJob writes the plist of the launchd job labeled label, and
+// loads it. The path of the plist is returned.
+func installLaunchdJob(label string, plist []byte) (string, error) {
+	plistPath := filepath.Join(launchDaemonsDir, label+".plist")
+	if err := os.WriteFile(plistPath, plist, 0644); err != nil {
+		return "", err
+	}
+	if err := launchctl("load", "-w", plistPath); err != nil {
+		return "", err
+	}
+	return plistPath, nil
+}
+
+func scheduleUninstall(args []string) error {
+	fs := flag.NewFlagSet("schedule uninstall", flag.ExitOnError)
+	label := fs.String("label", defaultLabel, `Label of the launchd job to uninstall.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the state file the job was installed with.`)
+	fs.Parse(args)
+
+	plistPath := filepath.Join(launchDaemonsDir, *label+".plist")
+	if _, err := os.Stat(plistPath); err != nil {
+		return fmt.Errorf("no schedule installed with label %q: %v", *label, err)
+	}
+	if err := launchctl("unload", "-w", plistPath); err != nil {
+		return err
+	}
+	if err := os.Remove(plistPath); err != nil {
+		return err
+	}
+	// Jobs installed by earlier versions ran in batch mode, reading their
+	// request from a file next to the state file.
+	requestPath := filepath.Join(filepath.Dir(*statePath), *label+".json")
+	if err := os.Remove(requestPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	fmt.Printf("Uninstalled %s.\n", plistPath)
+	return nil
+}
+
+func launchctl(args ...string) error {
+	cmd := exec.Command("launchctl", args...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	return nil
+}
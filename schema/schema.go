@@ -0,0 +1,67 @@
+// Package schema implements forward migrations of versioned JSON files, e.g.
+// the state file and the history records on targets.
+//
+// A file's format version is recorded in its top level "version" key. Files
+// without one predate format versions, and are version 0. Each format is
+// migrated to the next by a Migration, so that files of any older format can
+// be read by the current version of the utility.
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Migration migrates a file, decoded as a JSON object, from one format version
+// to the next.
+type Migration func(map[string]interface{}) error
+
+// NewerError is returned by Migrate if a file's format version is newer than
+// the newest version the migrations migrate to. Files of newer formats could
+// be misinterpreted, so they must not be read.
+type NewerError struct {
+	Version   int
+	Supported int
+}
+
+func (err *NewerError) Error() string {
+	return fmt.Sprintf("format version %d is newer than the newest supported format version %d", err.Version, err.Supported)
+}
+
+// Version returns the format version of data.
+func Version(data []byte) (int, error) {
+	var v struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return 0, err
+	}
+	return v.Version, nil
+}
+
+// Migrate migrates data to the current format version, len(migrations):
+// migrations[i] migrates version i to version i+1. The version recorded in
+// the migrated data is left as it was, so that readers can tell the data was
+// migrated. A *NewerError is returned if data is of a newer version.
+func Migrate(data []byte, migrations []Migration) ([]byte, error) {
+	version, err := Version(data)
+	if err != nil {
+		return nil, err
+	}
+	if version > len(migrations) {
+		return nil, &NewerError{Version: version, Supported: len(migrations)}
+	}
+	if version == len(migrations) {
+		return data, nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	for ; version < len(migrations); version++ {
+		if err := migrations[version](m); err != nil {
+			return nil, fmt.Errorf("error migrating from format version %d: %w", version, err)
+		}
+	}
+	return json.Marshal(m)
+}
@@ -0,0 +1,70 @@
+package schema
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMigrate(t *testing.T) {
+	migrations := []Migration{
+		func(m map[string]interface{}) error {
+			m["name"] = m["old_name"]
+			delete(m, "old_name")
+			return nil
+		},
+		func(m map[string]interface{}) error {
+			m["count"] = 1
+			return nil
+		},
+	}
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "unversioned",
+			data: `{"old_name": "example"}`,
+			want: `{"count":1,"name":"example"}`,
+		},
+		{
+			name: "older version",
+			data: `{"version": 1, "name": "example"}`,
+			want: `{"count":1,"name":"example","version":1}`,
+		},
+		{
+			name: "current version",
+			data: `{"version": 2, "name": "example", "count": 3}`,
+			want: `{"version": 2, "name": "example", "count": 3}`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Migrate([]byte(test.data), migrations)
+			if err != nil {
+				t.Fatalf("Migrate returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, string(got)); diff != "" {
+				t.Errorf("Migrate returned unexpected data. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMigrate_Errors(t *testing.T) {
+	failing := []Migration{
+		func(map[string]interface{}) error { return errors.New("example error") },
+	}
+	var newer *NewerError
+	if _, err := Migrate([]byte(`{"version": 2}`), failing); !errors.As(err, &newer) || newer.Version != 2 || newer.Supported != 1 {
+		t.Errorf("Migrate of newer version returned unexpected error: %v, want: %v", err, &NewerError{Version: 2, Supported: 1})
+	}
+	if _, err := Migrate([]byte(`{}`), failing); err == nil {
+		t.Error("Migrate with failing migration returned unexpected error: nil, want: non-nil")
+	}
+	if _, err := Migrate([]byte(`not-json`), failing); err == nil {
+		t.Error("Migrate of invalid json returned unexpected error: nil, want: non-nil")
+	}
+}
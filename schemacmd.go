@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/audit"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/jsonschema"
+	"github.com/voidingwarranties/offsite-apfs-backup/plan"
+)
+
+// schemas are the JSON formats whose JSON Schemas are printed by the schema
+// command, by name.
+var schemas = map[string]struct {
+	description string
+	v           interface{}
+	// output is true if the format is written by the utility, rather
+	// than read by it.
+	output bool
+}{
+	"config":        {"the configuration file (see -config)", config.Config{}, false},
+	"status":        {"the output of status -json", statusReport{}, true},
+	"plan":          {"the plans written by -plan", plan.Plan{}, true},
+	"batch-request": {"the requests read by batch", batchRequest{}, false},
+	"batch-result":  {"the results written by batch", batchResult{}, true},
+	"audit":         {"the entries printed by audit -json", audit.Entry{}, true},
+	"diagnostics":   {"the bundle printed by diagnose", diagnostics{}, true},
+	"error":         {"the errors printed by -json-errors", jsonError{}, true},
+}
+
+// printSchema prints the JSON Schema of a JSON format read or written by the
+// utility, so that editors can validate the configuration file, and other
+// programs can generate types for the utility's output.
+func printSchema(args []string) error {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s schema <format>\n\nPrints the JSON Schema of <format>, one of:\n", os.Args[0])
+		var names []string
+		for name := range schemas {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(os.Stderr, "  %-14s %s\n", name, schemas[name].description)
+		}
+		os.Exit(exitUsage)
+	}
+	format, ok := schemas[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown format %q", args[0])
+	}
+	s, err := jsonschema.New(format.v, "offsite-apfs-backup "+args[0], jsonschema.Output(format.output))
+	if err != nil {
+		return fmt.Errorf("error generating schema of %s: %w", args[0], err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(s)
+}
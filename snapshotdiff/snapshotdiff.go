@@ -0,0 +1,160 @@
+// Package snapshotdiff implements comparing the APFS snapshots of a source
+// volume and a target volume cloned from it, e.g. to find the snapshot that
+// the target can be incrementally restored from.
+package snapshotdiff
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// ErrNoCommonSnapshot is returned by LatestCommon if source and target have no
+// snapshots in common.
+var ErrNoCommonSnapshot = errors.New("source and target have no snapshots in common")
+
+// Analysis describes how a target's snapshots relate to its source's.
+// Snapshots are identified by UUID, and every list is ordered most recent
+// first.
+type Analysis struct {
+	// Common are the snapshots in both source and target.
+	Common diskutil.SnapshotList
+	// SourceOnly are the snapshots in source but not in target, e.g.
+	// snapshots created since target was last cloned to, or pruned from
+	// target.
+	SourceOnly diskutil.SnapshotList
+	// TargetOnly are the snapshots in target but not in source, e.g.
+	// snapshots pruned from source, or created on target.
+	TargetOnly diskutil.SnapshotList
+	// Behind is the number of snapshots in source more recent than the
+	// latest common snapshot, which cloning would bring target up to
+	// date with. Ahead is the number of snapshots in target more recent
+	// than the latest common snapshot, which restoring target from it
+	// would discard. Both are 0 if there is no common snapshot.
+	Behind int
+	Ahead  int
+	// Newest is the common snapshot created most recently, by the
+	// timestamps in snapshot names, or unset if there is no common
+	// snapshot. It is the latest common snapshot unless source or target
+	// lists snapshots out of order, e.g. because their names were parsed
+	// with the wrong timestamps.
+	Newest diskutil.Snapshot
+	// Reason explains why the returned common snapshot was chosen, e.g.
+	// for plans.
+	Reason string
+}
+
+// OlderBase returns true if common, as returned by LatestCommon, is older than
+// Newest. Restoring target from an older base makes asr transfer more data
+// than restoring it from Newest.
+func (a Analysis) OlderBase(common diskutil.Snapshot) bool {
+	return a.Newest.UUID != "" && a.Newest.UUID != common.UUID && a.Newest.Created.After(common.Created)
+}
+
+// UpToDate returns true if source and target have the same latest snapshot.
+func (a Analysis) UpToDate() bool {
+	return len(a.Common) > 0 && a.Behind == 0 && a.Ahead == 0
+}
+
+// Strategy chooses which of the snapshots that source and target have in
+// common target is restored from.
+type Strategy int
+
+const (
+	// LatestInTarget chooses the most recent common snapshot in target, in
+	// the order diskutil lists target's snapshots.
+	LatestInTarget Strategy = iota
+	// PreferNewestCommon chooses the newest common snapshot, so that asr
+	// transfers as little as possible. Common snapshots are ordered by the
+	// timestamps in their names, unless those conflict with the order of
+	// their XIDs in source, e.g. because the names of another tool's
+	// snapshots were parsed with the wrong timestamps, in which case they
+	// are ordered by XID.
+	PreferNewestCommon
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case LatestInTarget:
+		return "latest-in-target"
+	case PreferNewestCommon:
+		return "prefer-newest-common"
+	default:
+		return fmt.Sprintf("Strategy(%d)", int(s))
+	}
+}
+
+// LatestCommon returns the most recent snapshot in both source and target,
+// and the analysis of their snapshots. source and target must be ordered most
+// recent first, as returned by diskutil's ListSnapshots. The analysis is
+// returned even if source and target have no snapshots in common, in which
+// case the error wraps ErrNoCommonSnapshot.
+func LatestCommon(source, target diskutil.SnapshotList) (diskutil.Snapshot, Analysis, error) {
+	return Choose(source, target, LatestInTarget)
+}
+
+// Choose is like LatestCommon, but chooses the common snapshot with strategy.
+// Analysis.Behind and Ahead are relative to the chosen snapshot.
+func Choose(source, target diskutil.SnapshotList, strategy Strategy) (diskutil.Snapshot, Analysis, error) {
+	var a Analysis
+	inSource := make(map[string]bool, len(source))
+	for _, s := range source {
+		inSource[s.UUID] = true
+	}
+	inTarget := make(map[string]bool, len(target))
+	for _, s := range target {
+		inTarget[s.UUID] = true
+	}
+	for _, s := range source {
+		if inTarget[s.UUID] {
+			a.Common = append(a.Common, s)
+			if a.Newest.UUID == "" || s.Created.After(a.Newest.Created) {
+				a.Newest = s
+			}
+		} else {
+			a.SourceOnly = append(a.SourceOnly, s)
+		}
+	}
+	for _, s := range target {
+		if !inSource[s.UUID] {
+			a.TargetOnly = append(a.TargetOnly, s)
+		}
+	}
+	if len(a.Common) == 0 {
+		return diskutil.Snapshot{}, a, ErrNoCommonSnapshot
+	}
+	var chosen diskutil.Snapshot
+	switch strategy {
+	case PreferNewestCommon:
+		chosen = a.Newest
+		a.Reason = fmt.Sprintf("newest of %d common snapshot(s) by the timestamps in their names", len(a.Common))
+		if byXID, ok := newestByXID(a.Common); ok && byXID.UUID != a.Newest.UUID {
+			chosen = byXID
+			a.Reason = fmt.Sprintf("newest of %d common snapshot(s) by XID, as the timestamps in their names disagree (%s is newest by timestamp)", len(a.Common), a.Newest)
+		}
+	default:
+		// The latest common snapshot is the most recent in target,
+		// like restoring from it would use.
+		chosen, _ = target.CommonWith(source)
+		a.Reason = "latest common snapshot in target"
+	}
+	a.Behind = len(source.After(chosen.UUID))
+	a.Ahead = len(target.After(chosen.UUID))
+	return chosen, a, nil
+}
+
+// newestByXID returns the snapshot with the largest XID, or false if any
+// snapshot's XID is unknown.
+func newestByXID(snaps diskutil.SnapshotList) (diskutil.Snapshot, bool) {
+	var newest diskutil.Snapshot
+	for _, s := range snaps {
+		if s.XID == 0 {
+			return diskutil.Snapshot{}, false
+		}
+		if s.XID > newest.XID {
+			newest = s
+		}
+	}
+	return newest, newest.UUID != ""
+}
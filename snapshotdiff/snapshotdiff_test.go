@@ -0,0 +1,279 @@
+package snapshotdiff
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestLatestCommon(t *testing.T) {
+	snap1 := diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	snap2 := diskutil.Snapshot{Name: "snap-2", UUID: "snap-2-uuid"}
+	snap3 := diskutil.Snapshot{Name: "snap-3", UUID: "snap-3-uuid"}
+	snap4 := diskutil.Snapshot{Name: "snap-4", UUID: "snap-4-uuid"}
+	older := diskutil.Snapshot{Name: "older", UUID: "older-uuid", Created: time.Date(2021, 5, 4, 0, 0, 0, 0, time.UTC)}
+	newer := diskutil.Snapshot{Name: "newer", UUID: "newer-uuid", Created: time.Date(2021, 5, 5, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name         string
+		source       diskutil.SnapshotList
+		target       diskutil.SnapshotList
+		want         diskutil.Snapshot
+		wantAnalysis Analysis
+		wantErr      error
+	}{
+		{
+			name:   "behind",
+			source: diskutil.SnapshotList{snap3, snap2, snap1},
+			target: diskutil.SnapshotList{snap1},
+			want:   snap1,
+			wantAnalysis: Analysis{
+				Common:     diskutil.SnapshotList{snap1},
+				SourceOnly: diskutil.SnapshotList{snap3, snap2},
+				Behind:     2,
+				Newest:     snap1,
+			},
+		},
+		{
+			name:   "up to date",
+			source: diskutil.SnapshotList{snap2, snap1},
+			target: diskutil.SnapshotList{snap2, snap1},
+			want:   snap2,
+			wantAnalysis: Analysis{
+				Common: diskutil.SnapshotList{snap2, snap1},
+				Newest: snap2,
+			},
+		},
+		{
+			name:   "ahead",
+			source: diskutil.SnapshotList{snap1},
+			target: diskutil.SnapshotList{snap2, snap1},
+			want:   snap1,
+			wantAnalysis: Analysis{
+				Common:     diskutil.SnapshotList{snap1},
+				TargetOnly: diskutil.SnapshotList{snap2},
+				Ahead:      1,
+				Newest:     snap1,
+			},
+		},
+		{
+			name:   "diverged",
+			source: diskutil.SnapshotList{snap4, snap2, snap1},
+			target: diskutil.SnapshotList{snap3, snap1},
+			want:   snap1,
+			wantAnalysis: Analysis{
+				Common:     diskutil.SnapshotList{snap1},
+				SourceOnly: diskutil.SnapshotList{snap4, snap2},
+				TargetOnly: diskutil.SnapshotList{snap3},
+				Behind:     2,
+				Ahead:      1,
+				Newest:     snap1,
+			},
+		},
+		{
+			name:   "target out of order",
+			source: diskutil.SnapshotList{newer, older},
+			target: diskutil.SnapshotList{older, newer},
+			want:   older,
+			wantAnalysis: Analysis{
+				Common: diskutil.SnapshotList{newer, older},
+				Behind: 1,
+				Newest: newer,
+			},
+		},
+		{
+			name:   "no common snapshot",
+			source: diskutil.SnapshotList{snap2},
+			target: diskutil.SnapshotList{snap1},
+			wantAnalysis: Analysis{
+				SourceOnly: diskutil.SnapshotList{snap2},
+				TargetOnly: diskutil.SnapshotList{snap1},
+			},
+			wantErr: ErrNoCommonSnapshot,
+		},
+		{
+			name:    "empty target",
+			source:  diskutil.SnapshotList{snap1},
+			wantErr: ErrNoCommonSnapshot,
+			wantAnalysis: Analysis{
+				SourceOnly: diskutil.SnapshotList{snap1},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, analysis, err := LatestCommon(test.source, test.target)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("LatestCommon(...) returned unexpected error: %v, want: %v", err, test.wantErr)
+			}
+			if got != test.want {
+				t.Errorf("LatestCommon(...) returned snapshot %v, want: %v", got, test.want)
+			}
+			// Reasons are tested by TestChoose.
+			if diff := cmp.Diff(test.wantAnalysis, analysis, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(Analysis{}, "Reason")); diff != "" {
+				t.Errorf("LatestCommon(...) returned unexpected Analysis. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestChoose(t *testing.T) {
+	day := func(d int) time.Time {
+		return time.Date(2021, 5, d, 0, 0, 0, 0, time.UTC)
+	}
+	// a and b are listed out of order in target, and c's name was parsed
+	// with the wrong timestamp, so it appears older than it is.
+	a := diskutil.Snapshot{Name: "a", UUID: "a-uuid", XID: 100, Created: day(1)}
+	b := diskutil.Snapshot{Name: "b", UUID: "b-uuid", XID: 200, Created: day(2)}
+	c := diskutil.Snapshot{Name: "c", UUID: "c-uuid", XID: 300, Created: day(1)}
+	latest := diskutil.Snapshot{Name: "latest", UUID: "latest-uuid", XID: 400, Created: day(4)}
+	noXID := func(s diskutil.Snapshot) diskutil.Snapshot {
+		s.XID = 0
+		return s
+	}
+	tests := []struct {
+		name       string
+		source     diskutil.SnapshotList
+		target     diskutil.SnapshotList
+		strategy   Strategy
+		want       diskutil.Snapshot
+		wantReason string
+		wantBehind int
+	}{
+		{
+			name:       "latest in target",
+			source:     diskutil.SnapshotList{latest, b, a},
+			target:     diskutil.SnapshotList{a, b},
+			strategy:   LatestInTarget,
+			want:       a,
+			wantReason: "latest common snapshot in target",
+			wantBehind: 2,
+		},
+		{
+			name:       "prefer newest common by timestamp",
+			source:     diskutil.SnapshotList{latest, b, a},
+			target:     diskutil.SnapshotList{a, b},
+			strategy:   PreferNewestCommon,
+			want:       b,
+			wantReason: "newest of 2 common snapshot(s) by the timestamps in their names",
+			wantBehind: 1,
+		},
+		{
+			name:       "prefer newest common by XID when timestamps disagree",
+			source:     diskutil.SnapshotList{latest, c, b, a},
+			target:     diskutil.SnapshotList{c, b, a},
+			strategy:   PreferNewestCommon,
+			want:       c,
+			wantReason: "newest of 3 common snapshot(s) by XID, as the timestamps in their names disagree (b (b-uuid) is newest by timestamp)",
+			wantBehind: 1,
+		},
+		{
+			name:       "prefer newest common without XIDs",
+			source:     diskutil.SnapshotList{latest, noXID(c), noXID(b), noXID(a)},
+			target:     diskutil.SnapshotList{noXID(c), noXID(b), noXID(a)},
+			strategy:   PreferNewestCommon,
+			want:       noXID(b),
+			wantReason: "newest of 3 common snapshot(s) by the timestamps in their names",
+			wantBehind: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, analysis, err := Choose(test.source, test.target, test.strategy)
+			if err != nil {
+				t.Fatalf("Choose(...) returned unexpected error: %v, want: nil", err)
+			}
+			if got != test.want {
+				t.Errorf("Choose(...) returned snapshot %v, want: %v", got, test.want)
+			}
+			if analysis.Reason != test.wantReason {
+				t.Errorf("Choose(...) returned reason %q, want: %q", analysis.Reason, test.wantReason)
+			}
+			if analysis.Behind != test.wantBehind {
+				t.Errorf("Choose(...) returned Behind %d, want: %d", analysis.Behind, test.wantBehind)
+			}
+		})
+	}
+}
+
+func TestStrategy_String(t *testing.T) {
+	for s, want := range map[Strategy]string{
+		LatestInTarget:     "latest-in-target",
+		PreferNewestCommon: "prefer-newest-common",
+	} {
+		if got := s.String(); got != want {
+			t.Errorf("%d.String() = %q, want: %q", int(s), got, want)
+		}
+	}
+}
+
+func TestAnalysis_UpToDate(t *testing.T) {
+	snap := diskutil.Snapshot{Name: "snap-1", UUID: "snap-1-uuid"}
+	tests := []struct {
+		name     string
+		analysis Analysis
+		want     bool
+	}{
+		{
+			name:     "up to date",
+			analysis: Analysis{Common: diskutil.SnapshotList{snap}},
+			want:     true,
+		},
+		{
+			name:     "behind",
+			analysis: Analysis{Common: diskutil.SnapshotList{snap}, Behind: 1},
+		},
+		{
+			name:     "ahead",
+			analysis: Analysis{Common: diskutil.SnapshotList{snap}, Ahead: 1},
+		},
+		{
+			name: "no common snapshot",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.analysis.UpToDate(); got != test.want {
+				t.Errorf("UpToDate() = %t, want: %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestAnalysis_OlderBase(t *testing.T) {
+	older := diskutil.Snapshot{Name: "older", UUID: "older-uuid", Created: time.Date(2021, 5, 4, 0, 0, 0, 0, time.UTC)}
+	newer := diskutil.Snapshot{Name: "newer", UUID: "newer-uuid", Created: time.Date(2021, 5, 5, 0, 0, 0, 0, time.UTC)}
+	tests := []struct {
+		name     string
+		analysis Analysis
+		common   diskutil.Snapshot
+		want     bool
+	}{
+		{
+			name:     "newest chosen",
+			analysis: Analysis{Newest: newer},
+			common:   newer,
+		},
+		{
+			name:     "older chosen",
+			analysis: Analysis{Newest: newer},
+			common:   older,
+			want:     true,
+		},
+		{
+			name:   "no common snapshot",
+			common: older,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.analysis.OlderBase(test.common); got != test.want {
+				t.Errorf("OlderBase(%v) = %t, want: %t", test.common, got, test.want)
+			}
+		})
+	}
+}
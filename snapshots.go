@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+	"github.com/voidingwarranties/offsite-apfs-backup/tmutil"
+)
+
+// listSnapshots prints a volume's snapshots, most recent first.
+func listSnapshots(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("list-snapshots", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	fs.StringVar(configPath, "config", config.DefaultPath, `Path to the JSON configuration file configuring how sizes and times are formatted.`)
+	fs.BoolVar(noPLUtil, "no-plutil", false, `Deprecated: has no effect, since plutil is never run.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s list-snapshots [-state <path>] [-config <path>] <volume>
+
+Prints the APFS snapshots of <volume>, most recent first, as ordered when
+choosing the snapshots to clone.
+
+  <volume>
+    	Volume to list the snapshots of.
+    	May be the name of a paired target, mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <volume> is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	du := newDiskUtil()
+	info, err := resolveTarget(ctx, *statePath, du, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	snaps, err := du.ListSnapshots(ctx, info)
+	if err != nil {
+		return fmt.Errorf("error listing snapshots of %q: %v", info.Name, err)
+	}
+	if len(snaps) == 0 {
+		fmt.Printf("%q (%s) has no snapshots.\n", info.Name, info.UUID)
+		return nil
+	}
+	f, err := loadFormatter(*configPath)
+	if err != nil {
+		return err
+	}
+	for _, s := range snaps {
+		fmt.Printf("%s  %s\n", f.Time(s.Created), s)
+	}
+	return nil
+}
+
+// snapshotSource creates a local snapshot of source, so that the latest
+// snapshot cloned from source is as fresh as possible.
+func snapshotSource(ctx context.Context, w io.Writer, du diskutil.DiskUtil, source string) error {
+	info, err := du.Info(ctx, source)
+	if err != nil {
+		return fmt.Errorf("invalid source volume: %v", err)
+	}
+	// tmutil snapshots volumes by their mount points.
+	if info.MountPoint == "" {
+		return fmt.Errorf("cannot create a snapshot of source %q: it is not mounted", info.Name)
+	}
+	if *dryrun {
+		fmt.Fprintf(w, "Would create a snapshot of source %q.\n", info.Name)
+		return nil
+	}
+	date, err := tmutil.New().LocalSnapshot(ctx, info.MountPoint)
+	if err != nil {
+		return fmt.Errorf("error creating a snapshot of source %q: %w", info.Name, err)
+	}
+	fmt.Fprintf(w, "Created snapshot of source %q: com.apple.TimeMachine.%s.local\n", info.Name, date)
+	return nil
+}
+
+// pruneSourceSnapshots prunes from source the snapshots it had in common with
+// targets before they were cloned to, unless source is paired with other
+// targets, which may still need them to be incrementally cloned to.
+func pruneSourceSnapshots(ctx context.Context, w io.Writer, du diskutil.DiskUtil, c cloner.Cloner, source string, targets []string) error {
+	sourceInfo, err := du.Info(ctx, source)
+	if err != nil {
+		return fmt.Errorf("invalid source volume: %v", err)
+	}
+	cloned := make(map[string]bool)
+	for _, t := range targets {
+		info, err := du.Info(ctx, t)
+		if err != nil {
+			return fmt.Errorf("invalid target volume: %v", err)
+		}
+		cloned[info.UUID] = true
+	}
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	var others []string
+	for _, p := range st.Pairings {
+		if p.SourceUUID == sourceInfo.UUID && !cloned[p.TargetUUID] {
+			others = append(others, fmt.Sprintf("%q", p.TargetName))
+		}
+	}
+	if len(others) > 0 {
+		fmt.Fprintf(w, "Not pruning source: it is also paired with %s, which were not cloned to.\n", strings.Join(others, ", "))
+		return nil
+	}
+	if *dryrun {
+		fmt.Fprintf(w, "Would prune the snapshots source %q had in common with targets from source.\n", sourceInfo.Name)
+		return nil
+	}
+	return c.PruneSource(ctx, source, targets...)
+}
@@ -0,0 +1,371 @@
+// Package state implements the persistent record of which target volumes are
+// paired with which source volumes, the catalog of completed clones, and of
+// targets that have been retired.
+//
+// State is stored as JSON in a single file. A missing file is equivalent to
+// empty state. State files record their format version, so that state files
+// of older formats are migrated when loaded, and state files of newer formats
+// are refused rather than misinterpreted.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/schema"
+)
+
+// DefaultPath is the location of the state file used when none is specified.
+const DefaultPath = "/Library/Application Support/offsite-apfs-backup/state.json"
+
+// FormatVersion is the version of the format of state files written by Save.
+// Load migrates state files of older formats, and refuses newer formats,
+// which this version of the utility could misinterpret.
+const FormatVersion = 1
+
+// migrations migrate state files from each format version to the next:
+// migrations[i] migrates version i to version i+1. FormatVersion must be
+// len(migrations).
+var migrations = []schema.Migration{
+	// Version 0 state files predate format versions, and are otherwise
+	// the same as version 1.
+	func(map[string]interface{}) error { return nil },
+}
+
+// ErrNewerFormat is returned by Load if the state file was written in a newer
+// format than FormatVersion.
+var ErrNewerFormat = errors.New("state file was written by a newer version of offsite-apfs-backup")
+
+// ToolVersion is the version of the utility recorded in state files by Save,
+// e.g. v1.2.3. It is set by the main package.
+var ToolVersion = "dev"
+
+// State is the persistent state of the backup utility.
+type State struct {
+	// Version is the format version of the state file as it was loaded,
+	// before it was migrated. Save writes FormatVersion.
+	Version int `json:"version"`
+	// WrittenBy is the ToolVersion that last saved the state file.
+	WrittenBy string         `json:"written_by,omitempty"`
+	Pairings  []Pairing      `json:"pairings"`
+	Catalog   []CatalogEntry `json:"catalog"`
+	Retired   []Retirement   `json:"retired"`
+}
+
+// Pairing records that a target volume is an off-site copy of a source volume.
+type Pairing struct {
+	SourceUUID string    `json:"source_uuid"`
+	TargetUUID string    `json:"target_uuid"`
+	TargetName string    `json:"target_name"`
+	Paired     time.Time `json:"paired"`
+	// Baseline is the snapshot the target was last initialized to, if the
+	// target was initialized by this utility.
+	Baseline *Baseline `json:"baseline,omitempty"`
+}
+
+// Baseline records the source snapshot a target was initialized to.
+type Baseline struct {
+	SnapshotUUID string    `json:"snapshot_uuid"`
+	SnapshotName string    `json:"snapshot_name"`
+	Created      time.Time `json:"created"`
+	Initialized  time.Time `json:"initialized"`
+}
+
+// CatalogEntry records a completed clone of a source volume to a target
+// volume.
+type CatalogEntry struct {
+	// RunID identifies the invocation that performed the clone. Clones to
+	// multiple targets in the same invocation share a RunID.
+	RunID string `json:"run_id,omitempty"`
+	// Label names the backup routine the run belongs to, e.g.
+	// weekly-offsite. Empty if the run was not labeled.
+	Label      string        `json:"label,omitempty"`
+	SourceUUID string        `json:"source_uuid"`
+	TargetUUID string        `json:"target_uuid"`
+	Started    time.Time     `json:"started"`
+	Duration   time.Duration `json:"duration"`
+	// ToolVersion is the version of the utility that performed the clone,
+	// if known.
+	ToolVersion string `json:"tool_version,omitempty"`
+	// Phases are the durations of the phases of the clone, by phase name
+	// (e.g. restore), if known.
+	Phases map[string]time.Duration `json:"phases,omitempty"`
+	// SourceUsedBytes is the space used by the source volume when it was
+	// cloned, if known.
+	SourceUsedBytes uint64 `json:"source_used_bytes,omitempty"`
+	// TargetClass describes the target's disk, e.g. "USB SSD", if known.
+	TargetClass string `json:"target_class,omitempty"`
+	// SnapshotUUID and SnapshotName identify the source snapshot the
+	// target was cloned to, if known, so that the clone can be verified
+	// later, after source has newer snapshots.
+	SnapshotUUID string `json:"snapshot_uuid,omitempty"`
+	SnapshotName string `json:"snapshot_name,omitempty"`
+	// Resources is the CPU time and peak memory the clone used, if known.
+	Resources *Resources `json:"resources,omitempty"`
+	// Retries is the number of times asr retried the clone's restore after
+	// transient errors, e.g. "Resource busy".
+	Retries int `json:"retries,omitempty"`
+}
+
+// Resources is the CPU time and peak memory used by a clone, by this tool and
+// by the asr commands it ran. The wall time is the clone's Duration.
+type Resources struct {
+	ToolCPU time.Duration `json:"tool_cpu"`
+	ASRCPU  time.Duration `json:"asr_cpu"`
+	// ToolPeakMemory is the peak memory of the run up to the end of the
+	// clone, and ASRPeakMemory the largest peak memory of its asr
+	// commands, in bytes.
+	ToolPeakMemory uint64 `json:"tool_peak_memory"`
+	ASRPeakMemory  uint64 `json:"asr_peak_memory"`
+}
+
+// Retirement records that a target volume was permanently removed from
+// service.
+type Retirement struct {
+	Pairing
+	Retired time.Time `json:"retired"`
+	// Erased is true if the volume was erased when it was retired.
+	Erased bool `json:"erased"`
+}
+
+// Load reads the state stored at path. If no file exists at path, empty state
+// is returned.
+func Load(path string) (*State, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &State{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading state: %w", err)
+	}
+	if data, err = migrate(data); err != nil {
+		return nil, fmt.Errorf("error migrating state file %q: %w", path, err)
+	}
+	var s State
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error parsing state file %q: %w", path, err)
+	}
+	return &s, nil
+}
+
+// migrate migrates the state file data to FormatVersion.
+func migrate(data []byte) ([]byte, error) {
+	migrated, err := schema.Migrate(data, migrations)
+	var newer *schema.NewerError
+	if errors.As(err, &newer) {
+		var v struct {
+			WrittenBy string `json:"written_by"`
+		}
+		json.Unmarshal(data, &v)
+		if v.WrittenBy == "" {
+			v.WrittenBy = "an unknown version"
+		}
+		return nil, fmt.Errorf("%w: written by %s in format version %d, but this version (%s) only supports up to format version %d - upgrade offsite-apfs-backup", ErrNewerFormat, v.WrittenBy, newer.Version, ToolVersion, newer.Supported)
+	}
+	return migrated, err
+}
+
+// WrittenByNewer returns true if the state file was last saved by a newer
+// release of the utility than ToolVersion. Newer releases may have recorded
+// information that this version ignores, and that is lost if it saves the
+// state file.
+func (s *State) WrittenByNewer() bool {
+	return newerVersion(s.WrittenBy, ToolVersion)
+}
+
+// newerVersion returns true if a and b are release versions of the form
+// v1.2.3, and a is newer than b.
+func newerVersion(a, b string) bool {
+	va, ok := parseVersion(a)
+	if !ok {
+		return false
+	}
+	vb, ok := parseVersion(b)
+	if !ok {
+		return false
+	}
+	for i := range va {
+		if va[i] != vb[i] {
+			return va[i] > vb[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses a release version of the form v1.2.3.
+func parseVersion(v string) ([3]int, bool) {
+	var parsed [3]int
+	if _, err := fmt.Sscanf(v, "v%d.%d.%d", &parsed[0], &parsed[1], &parsed[2]); err != nil {
+		return parsed, false
+	}
+	return parsed, fmt.Sprintf("v%d.%d.%d", parsed[0], parsed[1], parsed[2]) == v
+}
+
+// Save writes the state to path, creating any missing parent directories. The
+// file is replaced atomically so that an interrupted Save never leaves a
+// partially written state file behind.
+func (s *State) Save(path string) error {
+	s.Version = FormatVersion
+	s.WrittenBy = ToolVersion
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".state-*.json")
+	if err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("error writing state: %w", err)
+	}
+	return nil
+}
+
+// Pair records that target is paired with source, replacing any existing
+// pairing of target.
+func (s *State) Pair(sourceUUID, targetUUID, targetName string, now time.Time) {
+	for i, p := range s.Pairings {
+		if p.TargetUUID == targetUUID {
+			s.Pairings[i].SourceUUID = sourceUUID
+			s.Pairings[i].TargetName = targetName
+			return
+		}
+	}
+	s.Pairings = append(s.Pairings, Pairing{
+		SourceUUID: sourceUUID,
+		TargetUUID: targetUUID,
+		TargetName: targetName,
+		Paired:     now,
+	})
+}
+
+// ReplaceSource re-pairs all targets paired with the source with volume UUID
+// oldUUID to the source with volume UUID newUUID, e.g. after the source disk
+// is replaced. The re-paired pairings are returned.
+func (s *State) ReplaceSource(oldUUID, newUUID string) []Pairing {
+	var replaced []Pairing
+	for i, p := range s.Pairings {
+		if p.SourceUUID == oldUUID {
+			s.Pairings[i].SourceUUID = newUUID
+			replaced = append(replaced, s.Pairings[i])
+		}
+	}
+	return replaced
+}
+
+// SetBaseline records that the target with volume UUID targetUUID was
+// initialized to the baseline snapshot b, replacing any previous baseline.
+func (s *State) SetBaseline(targetUUID string, b Baseline) error {
+	for i, p := range s.Pairings {
+		if p.TargetUUID == targetUUID {
+			s.Pairings[i].Baseline = &b
+			return nil
+		}
+	}
+	return fmt.Errorf("volume %q is not a paired target", targetUUID)
+}
+
+// Record adds a completed clone to the catalog.
+func (s *State) Record(e CatalogEntry) {
+	s.Catalog = append(s.Catalog, e)
+}
+
+// History returns the catalog entries of clones to the target with volume
+// UUID targetUUID, in the order they were recorded.
+func (s *State) History(targetUUID string) []CatalogEntry {
+	var entries []CatalogEntry
+	for _, e := range s.Catalog {
+		if e.TargetUUID == targetUUID {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Labeled returns the catalog entries of clones in runs labeled label, in the
+// order they were recorded.
+func (s *State) Labeled(label string) []CatalogEntry {
+	var entries []CatalogEntry
+	for _, e := range s.Catalog {
+		if e.Label == label {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// LastRun returns the catalog entries of the clones in the most recently
+// recorded run, in the order they were recorded. Entries recorded without a
+// RunID are each their own run.
+func (s *State) LastRun() []CatalogEntry {
+	if len(s.Catalog) == 0 {
+		return nil
+	}
+	last := s.Catalog[len(s.Catalog)-1]
+	if last.RunID == "" {
+		return []CatalogEntry{last}
+	}
+	var entries []CatalogEntry
+	for _, e := range s.Catalog {
+		if e.RunID == last.RunID {
+			entries = append(entries, e)
+		}
+	}
+	return entries
+}
+
+// Pairing returns the pairing of the target identified by either its volume
+// UUID or name. If a name matches multiple pairings, an error is returned.
+func (s *State) Pairing(target string) (Pairing, error) {
+	var matches []Pairing
+	for _, p := range s.Pairings {
+		if p.TargetUUID == target {
+			return p, nil
+		}
+		if p.TargetName == target {
+			matches = append(matches, p)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return Pairing{}, fmt.Errorf("%q is not a paired target", target)
+	case 1:
+		return matches[0], nil
+	}
+	return Pairing{}, fmt.Errorf("%q matches %d paired targets, use the volume UUID instead", target, len(matches))
+}
+
+// Retire removes the pairing of the target with volume UUID targetUUID and
+// records its retirement.
+func (s *State) Retire(targetUUID string, erased bool, now time.Time) (Retirement, error) {
+	for i, p := range s.Pairings {
+		if p.TargetUUID != targetUUID {
+			continue
+		}
+		s.Pairings = append(s.Pairings[:i], s.Pairings[i+1:]...)
+		r := Retirement{
+			Pairing: p,
+			Retired: now,
+			Erased:  erased,
+		}
+		s.Retired = append(s.Retired, r)
+		return r, nil
+	}
+	return Retirement{}, fmt.Errorf("volume %q is not a paired target", targetUUID)
+}
@@ -0,0 +1,334 @@
+package state
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestLoad_MissingFile(t *testing.T) {
+	s, err := Load(filepath.Join(t.TempDir(), "state.json"))
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(&State{}, s, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Load returned unexpected state. -want +got:\n%s", diff)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "nested", "state.json")
+	now := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	want := &State{}
+	want.Pair("source-uuid", "target-uuid", "target-name", now)
+	want.Record(CatalogEntry{
+		RunID:      "run-1",
+		SourceUUID: "source-uuid",
+		TargetUUID: "target-uuid",
+		Started:    now,
+		Duration:   5 * time.Minute,
+	})
+	if err := want.Save(path); err != nil {
+		t.Fatalf("Save returned unexpected error: %v, want: nil", err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Load returned unexpected state. -want +got:\n%s", diff)
+	}
+}
+
+func TestLoad_MigratesOlderFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	data := `{"pairings": [{"source_uuid": "source-uuid", "target_uuid": "target-uuid", "target_name": "target-name"}]}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load returned unexpected error: %v, want: nil", err)
+	}
+	want := &State{
+		Pairings: []Pairing{{
+			SourceUUID: "source-uuid",
+			TargetUUID: "target-uuid",
+			TargetName: "target-name",
+		}},
+	}
+	if diff := cmp.Diff(want, got, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Load returned unexpected state. -want +got:\n%s", diff)
+	}
+}
+
+func TestLoad_NewerFormat(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	data := `{"version": 1000, "written_by": "v99.0.0", "pairings": []}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); !errors.Is(err, ErrNewerFormat) {
+		t.Errorf("Load returned unexpected error: %v, want: %v", err, ErrNewerFormat)
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{a: "v1.2.4", b: "v1.2.3", want: true},
+		{a: "v1.10.0", b: "v1.9.9", want: true},
+		{a: "v2.0.0", b: "v1.99.99", want: true},
+		{a: "v1.2.3", b: "v1.2.3", want: false},
+		{a: "v1.2.3", b: "v1.2.4", want: false},
+		{a: "v1.2.3", b: "dev", want: false},
+		{a: "dev", b: "v1.2.3", want: false},
+		{a: "v1.2.3-rc1", b: "v1.2.2", want: false},
+		{a: "", b: "v1.2.3", want: false},
+	}
+	for _, test := range tests {
+		if got := newerVersion(test.a, test.b); got != test.want {
+			t.Errorf("newerVersion(%q, %q) = %t, want: %t", test.a, test.b, got, test.want)
+		}
+	}
+}
+
+func TestPair_ReplacesExistingPairing(t *testing.T) {
+	now := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	s := &State{}
+	s.Pair("old-source-uuid", "target-uuid", "old-name", now)
+	s.Pair("new-source-uuid", "target-uuid", "new-name", now.Add(time.Hour))
+	want := []Pairing{
+		{
+			SourceUUID: "new-source-uuid",
+			TargetUUID: "target-uuid",
+			TargetName: "new-name",
+			Paired:     now,
+		},
+	}
+	if diff := cmp.Diff(want, s.Pairings); diff != "" {
+		t.Errorf("Pair resulted in unexpected pairings. -want +got:\n%s", diff)
+	}
+}
+
+func TestReplaceSource(t *testing.T) {
+	now := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	s := &State{}
+	s.Pair("old-source-uuid", "target-1-uuid", "target-1", now)
+	s.Pair("other-source-uuid", "target-2-uuid", "target-2", now)
+	s.Pair("old-source-uuid", "target-3-uuid", "target-3", now)
+	got := s.ReplaceSource("old-source-uuid", "new-source-uuid")
+	want := []Pairing{
+		{
+			SourceUUID: "new-source-uuid",
+			TargetUUID: "target-1-uuid",
+			TargetName: "target-1",
+			Paired:     now,
+		},
+		{
+			SourceUUID: "new-source-uuid",
+			TargetUUID: "target-3-uuid",
+			TargetName: "target-3",
+			Paired:     now,
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("ReplaceSource returned unexpected pairings. -want +got:\n%s", diff)
+	}
+	if p, _ := s.Pairing("target-2-uuid"); p.SourceUUID != "other-source-uuid" {
+		t.Errorf("ReplaceSource re-paired target-2 to %q, want: other-source-uuid", p.SourceUUID)
+	}
+	if got := s.ReplaceSource("old-source-uuid", "new-source-uuid"); len(got) != 0 {
+		t.Errorf("ReplaceSource of unpaired source returned %v, want: none", got)
+	}
+}
+
+func TestSetBaseline(t *testing.T) {
+	now := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	s := &State{}
+	s.Pair("source-uuid", "target-uuid", "target-name", now)
+	old := Baseline{SnapshotUUID: "old-snap-uuid", Created: now.Add(-48 * time.Hour)}
+	b := Baseline{
+		SnapshotUUID: "snap-uuid",
+		SnapshotName: "snap-name",
+		Created:      now.Add(-time.Hour),
+		Initialized:  now,
+	}
+	if err := s.SetBaseline("target-uuid", old); err != nil {
+		t.Fatalf("SetBaseline returned unexpected error: %v, want: nil", err)
+	}
+	if err := s.SetBaseline("target-uuid", b); err != nil {
+		t.Fatalf("SetBaseline returned unexpected error: %v, want: nil", err)
+	}
+	want := []Pairing{
+		{
+			SourceUUID: "source-uuid",
+			TargetUUID: "target-uuid",
+			TargetName: "target-name",
+			Paired:     now,
+			Baseline:   &b,
+		},
+	}
+	if diff := cmp.Diff(want, s.Pairings); diff != "" {
+		t.Errorf("SetBaseline resulted in unexpected pairings. -want +got:\n%s", diff)
+	}
+	if err := s.SetBaseline("unpaired-uuid", b); err == nil {
+		t.Error("SetBaseline of unpaired target returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestPairing(t *testing.T) {
+	s := &State{}
+	s.Pair("source-uuid", "target1-uuid", "target1", time.Time{})
+	s.Pair("source-uuid", "target2-uuid", "duplicate-name", time.Time{})
+	s.Pair("source-uuid", "target3-uuid", "duplicate-name", time.Time{})
+
+	tests := []struct {
+		name     string
+		target   string
+		wantUUID string
+		wantErr  bool
+	}{
+		{
+			name:     "by UUID",
+			target:   "target2-uuid",
+			wantUUID: "target2-uuid",
+		},
+		{
+			name:     "by name",
+			target:   "target1",
+			wantUUID: "target1-uuid",
+		},
+		{
+			name:    "ambiguous name",
+			target:  "duplicate-name",
+			wantErr: true,
+		},
+		{
+			name:    "not paired",
+			target:  "not-a-target",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := s.Pairing(test.target)
+			if test.wantErr {
+				if err == nil {
+					t.Fatal("Pairing returned unexpected error: nil, want: non-nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Pairing returned unexpected error: %v, want: nil", err)
+			}
+			if got.TargetUUID != test.wantUUID {
+				t.Errorf("Pairing returned target %q, want: %q", got.TargetUUID, test.wantUUID)
+			}
+		})
+	}
+}
+
+func TestRetire(t *testing.T) {
+	paired := time.Date(2021, 3, 1, 20, 35, 9, 0, time.UTC)
+	retired := paired.Add(24 * time.Hour)
+	s := &State{}
+	s.Pair("source-uuid", "target-uuid", "target-name", paired)
+	if _, err := s.Retire("target-uuid", true, retired); err != nil {
+		t.Fatalf("Retire returned unexpected error: %v, want: nil", err)
+	}
+	want := &State{
+		Retired: []Retirement{
+			{
+				Pairing: Pairing{
+					SourceUUID: "source-uuid",
+					TargetUUID: "target-uuid",
+					TargetName: "target-name",
+					Paired:     paired,
+				},
+				Retired: retired,
+				Erased:  true,
+			},
+		},
+	}
+	if diff := cmp.Diff(want, s, cmpopts.EquateEmpty()); diff != "" {
+		t.Errorf("Retire resulted in unexpected state. -want +got:\n%s", diff)
+	}
+	if _, err := s.Retire("target-uuid", true, retired); err == nil {
+		t.Error("Retire of unpaired target returned unexpected error: nil, want: non-nil")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	s := &State{}
+	first := CatalogEntry{TargetUUID: "target1-uuid", Duration: time.Minute}
+	other := CatalogEntry{TargetUUID: "target2-uuid", Duration: time.Hour}
+	second := CatalogEntry{TargetUUID: "target1-uuid", Duration: 2 * time.Minute}
+	s.Record(first)
+	s.Record(other)
+	s.Record(second)
+
+	want := []CatalogEntry{first, second}
+	if diff := cmp.Diff(want, s.History("target1-uuid")); diff != "" {
+		t.Errorf("History returned unexpected entries. -want +got:\n%s", diff)
+	}
+}
+
+func TestLastRun(t *testing.T) {
+	run1 := CatalogEntry{RunID: "run-1", TargetUUID: "target1-uuid"}
+	run2a := CatalogEntry{RunID: "run-2", TargetUUID: "target1-uuid"}
+	run2b := CatalogEntry{RunID: "run-2", TargetUUID: "target2-uuid"}
+	noRunID := CatalogEntry{TargetUUID: "target2-uuid"}
+
+	tests := []struct {
+		name    string
+		catalog []CatalogEntry
+		want    []CatalogEntry
+	}{
+		{
+			name: "empty catalog",
+		},
+		{
+			name:    "clones to several targets",
+			catalog: []CatalogEntry{run1, run2a, run2b},
+			want:    []CatalogEntry{run2a, run2b},
+		},
+		{
+			name:    "no run ID",
+			catalog: []CatalogEntry{noRunID, run1, noRunID},
+			want:    []CatalogEntry{noRunID},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &State{Catalog: test.catalog}
+			if diff := cmp.Diff(test.want, s.LastRun()); diff != "" {
+				t.Errorf("LastRun returned unexpected entries. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLabeled(t *testing.T) {
+	s := &State{}
+	weekly := CatalogEntry{Label: "weekly", TargetUUID: "target1-uuid"}
+	monthly := CatalogEntry{Label: "monthly", TargetUUID: "target1-uuid"}
+	unlabeled := CatalogEntry{TargetUUID: "target2-uuid"}
+	s.Record(weekly)
+	s.Record(monthly)
+	s.Record(unlabeled)
+
+	if diff := cmp.Diff([]CatalogEntry{weekly}, s.Labeled("weekly")); diff != "" {
+		t.Errorf("Labeled returned unexpected entries. -want +got:\n%s", diff)
+	}
+	if diff := cmp.Diff([]CatalogEntry{unlabeled}, s.Labeled("")); diff != "" {
+		t.Errorf("Labeled returned unexpected unlabeled entries. -want +got:\n%s", diff)
+	}
+}
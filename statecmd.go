@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/history"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// stateCommand manages the state file, and the history recorded on targets.
+func stateCommand(args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			return stateMigrate(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, `Usage: %[1]s state migrate [-dryrun] [-state <path>] [<target volume>...]
+`, os.Args[0])
+	os.Exit(exitUsage)
+	return nil
+}
+
+// stateMigrate migrates the state file, and the history of targets, to the
+// current format versions.
+func stateMigrate(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("state migrate", flag.ExitOnError)
+	dryrun := fs.Bool("dryrun", false, `If true, only report what would be migrated. Does not modify the state file or targets.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s state migrate [-dryrun] [-state <path>] [<target volume>...]
+
+Migrates the state file, and the history recorded on each given target, to the
+current format versions. Older formats are migrated whenever they are read, so
+migrating is never required, but it upgrades the files in place, e.g. before
+rotating targets off-site.
+
+Files of newer formats than this version supports are refused rather than
+migrated; upgrade offsite-apfs-backup to migrate them.
+
+  <target volume>
+    	Mounted target APFS volume(s) whose history to migrate.
+    	May be a mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if err := migrateStateFile(*statePath, *dryrun); err != nil {
+		return err
+	}
+	du := newDiskUtil()
+	var failed int
+	for _, t := range fs.Args() {
+		info, err := du.Info(ctx, t)
+		if err == nil && info.MountPoint == "" {
+			err = errors.New("volume is not mounted")
+		}
+		if err == nil {
+			err = migrateHistory(info.MountPoint, *dryrun)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(os.Stderr, "Error: failed to migrate history of %q: %v\n", t, err)
+			printExplanation(os.Stderr, err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to migrate history of %d/%d targets", failed, fs.NArg())
+	}
+	return nil
+}
+
+// migrateStateFile saves the state file at path in the current format, if it
+// was of an older format.
+func migrateStateFile(path string, dryrun bool) error {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("State file %q does not exist, nothing to migrate.\n", path)
+		return nil
+	}
+	st, err := loadState(path)
+	if err != nil {
+		printExplanation(os.Stderr, err)
+		return err
+	}
+	if st.Version == state.FormatVersion {
+		fmt.Printf("State file %q is already at format version %d.\n", path, state.FormatVersion)
+		return nil
+	}
+	if dryrun {
+		fmt.Printf("Would migrate state file %q from format version %d to %d.\n", path, st.Version, state.FormatVersion)
+		return nil
+	}
+	fmt.Printf("Migrating state file %q from format version %d to %d.\n", path, st.Version, state.FormatVersion)
+	return st.Save(path)
+}
+
+// migrateHistory writes the history recorded on the volume mounted at
+// mountPoint in the current format, if it was of an older format.
+func migrateHistory(mountPoint string, dryrun bool) error {
+	r, exists, err := history.Read(mountPoint)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		fmt.Printf("%q has no history, nothing to migrate.\n", mountPoint)
+		return nil
+	}
+	if r.Version == history.FormatVersion {
+		fmt.Printf("History of %q is already at format version %d.\n", mountPoint, history.FormatVersion)
+		return nil
+	}
+	if dryrun {
+		fmt.Printf("Would migrate history of %q from format version %d to %d.\n", mountPoint, r.Version, history.FormatVersion)
+		return nil
+	}
+	fmt.Printf("Migrating history of %q from format version %d to %d.\n", mountPoint, r.Version, history.FormatVersion)
+	return history.Write(mountPoint, r)
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/format"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+	"github.com/voidingwarranties/offsite-apfs-backup/pairing"
+	"github.com/voidingwarranties/offsite-apfs-backup/snapshotdiff"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// status prints each paired target, whether it is attached, when it was last
+// cloned to, and how far behind its source it is, followed by the quorum of
+// each target group.
+func status(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	configPath := fs.String("config", config.DefaultPath, `Path to the JSON configuration file defining target groups, and how sizes and times are formatted.`)
+	notifyLost := fs.Bool("notify", false, `If true, post a notification for each target group that has lost quorum.`)
+	asJSON := fs.Bool("json", false, `If true, print the status as JSON. See "schema status" for its JSON Schema.`)
+	fs.BoolVar(noPLUtil, "no-plutil", false, `Deprecated: has no effect, since plutil is never run.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s status [-state <path>] [-config <path>] [-notify] [-json]
+
+Prints each target paired in the state file, whether it is attached, and when
+it was last cloned to. Targets attached along with their sources also show
+their latest snapshot in common with source, and how many source snapshots
+they are behind. Then prints how full the APFS containers of attached sources
+are, and how many targets of each group in the configuration file were cloned
+to within the group's max_age, and whether that meets the group's quorum.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	st, err := loadState(*statePath)
+	if err != nil {
+		return err
+	}
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		return err
+	}
+	report, err := newStatusReport(ctx, st, cfg.Groups)
+	if err != nil {
+		return err
+	}
+	f, err := format.New(cfg.Format)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printStatus(report, f)
+	}
+	for _, g := range report.Groups {
+		if g.Met {
+			continue
+		}
+		logger.Log(oslog.Error, "target group %s lost quorum: %s", g.Name, g.quorum)
+		if *notifyLost {
+			if err := notify("Backup quorum lost", g.quorum.String()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to notify that %s lost quorum: %v\n", g.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// statusReport is the status of paired targets and target groups, as printed
+// by status -json.
+type statusReport struct {
+	Sources []sourceStatus `json:"sources"`
+	Targets []targetStatus `json:"targets"`
+	Groups  []groupStatus  `json:"groups"`
+}
+
+// sourceStatus is the status of the source of paired targets.
+type sourceStatus struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name,omitempty"`
+	Attached bool   `json:"attached"`
+	// ContainerUsedPercent and ContainerFree are the percent in use and
+	// the free bytes of the source's APFS container, if it is attached.
+	ContainerUsedPercent float64 `json:"container_used_percent,omitempty"`
+	ContainerFree        uint64  `json:"container_free,omitempty"`
+	// NearlyFull is true if the container is more than
+	// cloner.NearlyFullPercent full.
+	NearlyFull bool `json:"nearly_full"`
+}
+
+// targetStatus is the status of a paired target.
+type targetStatus struct {
+	Name       string `json:"name"`
+	UUID       string `json:"uuid"`
+	SourceUUID string `json:"source_uuid"`
+	Attached   bool   `json:"attached"`
+	// MountPoint is set if the target is mounted.
+	MountPoint string `json:"mount_point,omitempty"`
+	// LastCloned is when the target was last cloned to, if ever.
+	LastCloned *time.Time `json:"last_cloned,omitempty"`
+	// CommonSnapshot is the name of the latest snapshot in common with
+	// source, and Behind the number of source snapshots more recent than
+	// it, if both target and source are attached and have a snapshot in
+	// common.
+	CommonSnapshot string `json:"common_snapshot,omitempty"`
+	Behind         *int   `json:"behind,omitempty"`
+}
+
+// groupStatus is the quorum of a target group.
+type groupStatus struct {
+	Name string `json:"name"`
+	// Met is true if at least Quorum targets were cloned to within MaxAge.
+	Met    bool `json:"met"`
+	Quorum int  `json:"quorum"`
+	// MaxAge is in the syntax of time.ParseDuration, e.g. 336h0m0s.
+	MaxAge string   `json:"max_age"`
+	Fresh  []string `json:"fresh"`
+	Stale  []string `json:"stale"`
+
+	quorum config.Quorum
+}
+
+// newStatusReport returns the status of each paired target, and the quorum of
+// each of groups.
+func newStatusReport(ctx context.Context, st *state.State, groups []config.Group) (statusReport, error) {
+	report := statusReport{
+		Sources: []sourceStatus{},
+		Targets: []targetStatus{},
+		Groups:  []groupStatus{},
+	}
+	du := newDiskUtil()
+	sources := make(map[string]bool)
+	for _, p := range st.Pairings {
+		if sources[p.SourceUUID] {
+			continue
+		}
+		sources[p.SourceUUID] = true
+		s := sourceStatus{UUID: p.SourceUUID}
+		if info, err := du.Info(ctx, p.SourceUUID); err == nil {
+			s.Name = info.Name
+			s.Attached = true
+			if used, ok := info.ContainerUsed(); ok {
+				s.ContainerUsedPercent = math.Round(used*10) / 10
+				s.ContainerFree = info.ContainerFree
+				s.NearlyFull = used > cloner.NearlyFullPercent
+			}
+		}
+		report.Sources = append(report.Sources, s)
+	}
+	attached, err := pairing.AttachedTargets(ctx, du, st)
+	if err != nil {
+		return statusReport{}, err
+	}
+	volumes := make(map[string]diskutil.VolumeInfo) // Map of attached target UUID to its volume.
+	for _, a := range attached {
+		volumes[a.TargetUUID] = a.Volume
+	}
+	sourceSnaps := make(map[string]diskutil.SnapshotList) // Map of attached source UUID to its snapshots.
+	for _, s := range report.Sources {
+		if !s.Attached {
+			continue
+		}
+		info, err := du.Info(ctx, s.UUID)
+		if err != nil {
+			continue
+		}
+		if snaps, err := du.ListSnapshots(ctx, info); err == nil {
+			sourceSnaps[s.UUID] = snaps
+		}
+	}
+	for _, p := range st.Pairings {
+		t := targetStatus{
+			Name:       p.TargetName,
+			UUID:       p.TargetUUID,
+			SourceUUID: p.SourceUUID,
+		}
+		if info, ok := volumes[p.TargetUUID]; ok {
+			t.Attached = true
+			t.MountPoint = info.MountPoint
+			if snaps, ok := sourceSnaps[p.SourceUUID]; ok {
+				if targetSnaps, err := du.ListSnapshots(ctx, info); err == nil {
+					if common, analysis, err := snapshotdiff.LatestCommon(snaps, targetSnaps); err == nil {
+						t.CommonSnapshot = common.Name
+						t.Behind = &analysis.Behind
+					}
+				}
+			}
+		}
+		if h := st.History(p.TargetUUID); len(h) > 0 {
+			started := h[len(h)-1].Started
+			t.LastCloned = &started
+		}
+		report.Targets = append(report.Targets, t)
+	}
+
+	// lastCloned returns when target, a paired target name or volume
+	// UUID, was last cloned to.
+	lastCloned := func(target string) time.Time {
+		p, err := st.Pairing(target)
+		if err != nil {
+			return time.Time{}
+		}
+		h := st.History(p.TargetUUID)
+		if len(h) == 0 {
+			return time.Time{}
+		}
+		return h[len(h)-1].Started
+	}
+	for _, g := range groups {
+		q, err := g.Check(lastCloned, clk.Now())
+		if err != nil {
+			return statusReport{}, err
+		}
+		report.Groups = append(report.Groups, groupStatus{
+			Name:   g.Name,
+			Met:    q.Met(),
+			Quorum: q.Required,
+			MaxAge: q.MaxAge.String(),
+			Fresh:  append([]string{}, q.Fresh...),
+			Stale:  append([]string{}, q.Stale...),
+			quorum: q,
+		})
+	}
+	return report, nil
+}
+
+// printStatus prints each paired target, whether it is attached, and when it
+// was last cloned to, followed by the quorum of each group.
+func printStatus(report statusReport, f format.Formatter) {
+	if len(report.Targets) == 0 {
+		fmt.Println("No targets are paired.")
+	}
+	now := clk.Now()
+	for _, t := range report.Targets {
+		attached := "not attached"
+		if t.MountPoint != "" {
+			attached = "mounted at " + t.MountPoint
+		} else if t.Attached {
+			attached = "attached"
+		}
+		last := "never cloned to"
+		if t.LastCloned != nil {
+			last = fmt.Sprintf("last cloned to %s (%s ago)", f.Time(*t.LastCloned), now.Sub(*t.LastCloned).Round(time.Minute))
+		}
+		lag := ""
+		if t.Behind != nil {
+			lag = fmt.Sprintf(", %d source snapshot(s) behind (common snapshot: %s)", *t.Behind, t.CommonSnapshot)
+		}
+		fmt.Printf("%q (%s) from %s: %s, %s%s\n", t.Name, t.UUID, t.SourceUUID, attached, last, lag)
+	}
+	for _, s := range report.Sources {
+		if !s.Attached || s.ContainerUsedPercent == 0 {
+			continue
+		}
+		full := ""
+		if s.NearlyFull {
+			full = " - NEARLY FULL: thin its snapshots, as snapshots and restores slow down and may fail"
+		}
+		fmt.Printf("Source %q (%s): APFS container %.0f%% full (%s free)%s\n", s.Name, s.UUID, s.ContainerUsedPercent, f.Bytes(s.ContainerFree), full)
+	}
+	if len(report.Groups) == 0 {
+		return
+	}
+	fmt.Println()
+	for _, g := range report.Groups {
+		if g.Met {
+			fmt.Printf("%s: %s\n", g.Name, g.quorum)
+			continue
+		}
+		fmt.Printf("%s: QUORUM LOST: %s; stale: %s\n", g.Name, g.quorum, strings.Join(g.Stale, ", "))
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+)
+
+// listTargets prints the attached APFS volumes that a source is cloneable to.
+func listTargets(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("list-targets", flag.ExitOnError)
+	initialize := fs.Bool("initialize", false, `If true, list the volumes that could be initialized to source instead, i.e. writable APFS volumes without snapshots.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s list-targets [-initialize] <source volume>
+
+Lists the attached APFS volumes that <source volume> is cloneable to: writable
+APFS volumes of the same file system as source, with a snapshot in common with
+source. Use their UUIDs as <target volume>s.
+
+  <source volume>
+    	Source APFS volume.
+    	May be a mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fmt.Fprintln(fs.Output(), "Error: exactly one <source volume> is required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	// asr is nil, as listing never restores.
+	c := cloner.New(newDiskUtil(), nil, cloner.InitializeTargets(*initialize))
+	targets, err := c.EligibleTargets(ctx, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		fmt.Printf("No attached volumes are cloneable from %q.\n", fs.Arg(0))
+		return nil
+	}
+	for _, t := range targets {
+		fmt.Printf("%s  %q", t.Target.UUID, t.Target.Name)
+		if t.Target.MountPoint != "" {
+			fmt.Printf(" at %s", t.Target.MountPoint)
+		}
+		fmt.Println()
+		if !*initialize {
+			fmt.Printf("\tsnapshot in common: %s\n", t.Common)
+		}
+	}
+	return nil
+}
@@ -5,20 +5,28 @@
 // Images are mounted in a temporary directory, and automatically unmounted
 // during test cleanup.
 //
+// Images are attached and detached with the hdiutil package. Mounting is safe
+// for parallel tests: each mount's mount point and shadow file are namespaced
+// to the test and mount, and attaches and detaches are serialized system-wide.
+//
 // All VolumeInfo and Snapshot metadata are constructed independently, and
 // therefore suitable for testing the diskutil package.
 package diskimage
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
-	"github.com/voidingwarranties/offsite-apfs-backup/plutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/hdiutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/lock"
 )
 
 // Testdata disk images:
@@ -71,8 +79,8 @@ var (
 			FileSystem:     "Case-sensitive APFS",
 		},
 	}
-	snapshots = map[DiskImage][]diskutil.Snapshot{
-		SourceImg: []diskutil.Snapshot{
+	snapshots = map[DiskImage]diskutil.SnapshotList{
+		SourceImg: diskutil.SnapshotList{
 			{
 				Name:    "com.bombich.ccc.6AE4815C-1F9A-4D5E-86E1-19078BE01958.2021-03-01-203509",
 				UUID:    "D1ABE254-5B1B-4FDF-8DB3-1B4B4B825E39",
@@ -84,7 +92,7 @@ var (
 				Created: time.Date(2021, 3, 1, 20, 34, 33, 0, time.UTC),
 			},
 		},
-		TargetImg: []diskutil.Snapshot{
+		TargetImg: diskutil.SnapshotList{
 			{
 				Name:    "com.bombich.ccc.D7B2D286-3CE0-40B9-9797-EBF108ADAD30.2021-03-01-203433",
 				UUID:    "A175CCCF-0C56-4A46-97FB-CA267A540C96",
@@ -127,28 +135,49 @@ type DiskImage string
 
 func (img DiskImage) mountRO(t *testing.T, relpath string) diskutil.VolumeInfo {
 	t.Helper()
-	info, exists := infos[img]
-	if !exists {
-		t.Fatalf("unknown disk image %q", img)
-	}
-	path := filepath.Join(relpath, string(img))
-	info.MountPoint, info.Device = MountRO(t, path)
-	info.Writable = false
-	return info
+	return img.mount(t, relpath, hdiutil.AttachOptions{ReadOnly: true})
 }
 
 func (img DiskImage) mountRW(t *testing.T, relpath string) diskutil.VolumeInfo {
+	t.Helper()
+	return img.mount(t, relpath, hdiutil.AttachOptions{})
+}
+
+func (img DiskImage) mount(t *testing.T, relpath string, opts hdiutil.AttachOptions) diskutil.VolumeInfo {
 	t.Helper()
 	info, exists := infos[img]
 	if !exists {
 		t.Fatalf("unknown disk image %q", img)
 	}
 	path := filepath.Join(relpath, string(img))
-	info.MountPoint, info.Device = MountRW(t, path)
-	info.Writable = true
+	var attached hdiutil.Attached
+	info.MountPoint, info.Device, attached = mount(t, path, opts)
+	if info.FileSystemType == "apfs" {
+		info.Container = containerOf(info.Device)
+		for _, e := range attached.Entities {
+			if e.ContentHint == "Apple_APFS" {
+				info.PhysicalStores = append(info.PhysicalStores, diskutil.PhysicalStore{
+					Device: strings.TrimPrefix(e.Device, "/dev/"),
+				})
+			}
+		}
+	}
+	// Disk images do not support S.M.A.R.T.
+	info.SMARTStatus = "Not Supported"
+	info.Writable = !opts.ReadOnly
 	return info
 }
 
+// containerOf returns the APFS container reference of an APFS volume's device
+// node, e.g. disk5 for /dev/disk5s1.
+func containerOf(device string) string {
+	ref := strings.TrimPrefix(device, "/dev/")
+	if i := strings.LastIndex(ref, "s"); i > len("disk") {
+		ref = ref[:i]
+	}
+	return ref
+}
+
 // UUID returns the volume UUID of the disk image.
 func (img DiskImage) UUID(t *testing.T) string {
 	t.Helper()
@@ -160,7 +189,7 @@ func (img DiskImage) UUID(t *testing.T) string {
 }
 
 // Snapshots returns the APFS snapshots of the disk image.
-func (img DiskImage) Snapshots(t *testing.T) []diskutil.Snapshot {
+func (img DiskImage) Snapshots(t *testing.T) diskutil.SnapshotList {
 	snaps, exists := snapshots[img]
 	if !exists {
 		t.Fatalf("no snapshot information for disk image %q", img)
@@ -168,54 +197,45 @@ func (img DiskImage) Snapshots(t *testing.T) []diskutil.Snapshot {
 	return snaps
 }
 
-// MountRO mounts the disk image at `path` as a readonly volume and
-// returns the mount point and device node.
-func MountRO(t *testing.T, path string) (mountpoint, device string) {
-	t.Helper()
+// hdiutilLockDir is the directory of the lock that serializes `hdiutil attach`
+// and `hdiutil detach` across all test processes, e.g. packages tested in
+// parallel by `go test ./...`. Concurrent attaches and detaches make hdiutil
+// flaky.
+const hdiutilLockDir = "/tmp/offsite-apfs-backup-diskimage"
 
-	mountpoint = t.TempDir()
-	cmd := exec.Command(
-		"hdiutil", "attach",
-		// There's an odd bug in MacOS where repeatedly calling
-		// `hdiutil attach` and `hdiutil detach` on an image and it's
-		// volume will cause Finder to sometimes display multiple
-		// Macintosh HD volumes. The -nobrowse flag seems to prevent
-		// the visible symptoms of this bug, but this could also just
-		// be hiding weirdness.
-		"-nobrowse",
-		"-plist",
-		"-readonly",
-		"-mountpoint", mountpoint,
-		path,
-	)
-	stdout, err := cmd.Output()
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		// TODO: it would be nice if *exec.ExitError.Error() included the stderr, if any.
-		t.Fatalf("failed to mount %q (%v) with stderr: %s", path, err, exitErr.Stderr)
-	}
+// withHdiutilLock runs f while holding the system-wide hdiutil lock.
+func withHdiutilLock(f func() error) error {
+	l, err := lock.AcquireWait(context.Background(), hdiutilLockDir, "hdiutil", 100*time.Millisecond, nil)
 	if err != nil {
-		t.Fatalf("failed to mount %q (%v)", path, err)
+		return err
 	}
-	// Mount point may have changed by the time we cleanup (e.g. by `asr
-	// restore`). Get the the device node to use during cleanup.
-	device, err = parseHdiutilAttachOutput(stdout)
-	if err != nil {
-		t.Fatal(err)
-	}
-	t.Cleanup(func() {
-		if err := detach(device); err != nil {
-			t.Fatal(err)
-		}
-	})
-	// t.TempDir can return a path that contains a symlink. Evaluate the
-	// mount point, as `diskutil info` returns the non-symlink mount
-	// points. We could return `info.MountPoint`, but then
-	// diskutil_darwin_test's TestInfo wouldn't be truly testing the
-	// MountPoint value.
-	mountpoint, err = filepath.EvalSymlinks(mountpoint)
-	if err != nil {
+	defer l.Release()
+	return f()
+}
+
+// mountSeq numbers the mounts of this process, so that the resources of each
+// mount have unique names.
+var mountSeq uint64
+
+// namespace returns a new directory for the mount point and shadow file of the
+// disk image at path, unique to this mount. The directory is named after the
+// image, process, and mount number to make leftover resources identifiable.
+func namespace(t *testing.T, path string) string {
+	t.Helper()
+	n := atomic.AddUint64(&mountSeq, 1)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	dir := filepath.Join(t.TempDir(), fmt.Sprintf("%s-%d-%d", base, os.Getpid(), n))
+	if err := os.Mkdir(dir, 0755); err != nil {
 		t.Fatal(err)
 	}
+	return dir
+}
+
+// MountRO mounts the disk image at `path` as a readonly volume and
+// returns the mount point and device node.
+func MountRO(t *testing.T, path string) (mountpoint, device string) {
+	t.Helper()
+	mountpoint, device, _ = mount(t, path, hdiutil.AttachOptions{ReadOnly: true})
 	return mountpoint, device
 }
 
@@ -224,64 +244,88 @@ func MountRO(t *testing.T, path string) (mountpoint, device string) {
 // the shadow file rather than the disk image.
 func MountRW(t *testing.T, path string) (mountpoint, device string) {
 	t.Helper()
+	mountpoint, device, _ = mount(t, path, hdiutil.AttachOptions{})
+	return mountpoint, device
+}
 
-	mountpoint = t.TempDir()
-	shadow := filepath.Join(t.TempDir(), "shadow")
-	cmd := exec.Command(
-		"hdiutil", "attach",
-		"-nobrowse",
-		"-plist",
-		"-shadow", shadow,
-		"-mountpoint", mountpoint,
-		path,
-	)
-	stdout, err := cmd.Output()
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		t.Fatalf("failed to mount %q (%v) with stderr: %s", path, err, exitErr.Stderr)
+// mount mounts the disk image at path in a new namespace directory. Unless
+// opts is ReadOnly, writes are redirected to a shadow file.
+func mount(t *testing.T, path string, opts hdiutil.AttachOptions) (mountpoint, device string, attached hdiutil.Attached) {
+	t.Helper()
+	dir := namespace(t, path)
+	if !opts.ReadOnly {
+		opts.Shadow = filepath.Join(dir, "shadow")
 	}
+	return attach(t, path, filepath.Join(dir, "mnt"), opts)
+}
+
+// attach attaches the disk image at path at mountpoint, and detaches it
+// during test cleanup.
+func attach(t *testing.T, path, mountpoint string, opts hdiutil.AttachOptions) (string, string, hdiutil.Attached) {
+	t.Helper()
+	if err := os.Mkdir(mountpoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	opts.MountPoint = mountpoint
+	// There's an odd bug in MacOS where repeatedly calling `hdiutil attach`
+	// and `hdiutil detach` on an image and it's volume will cause Finder to
+	// sometimes display multiple Macintosh HD volumes. The -nobrowse flag
+	// seems to prevent the visible symptoms of this bug, but this could
+	// also just be hiding weirdness.
+	opts.NoBrowse = true
+	var attached hdiutil.Attached
+	err := withHdiutilLock(func() error {
+		var err error
+		attached, err = hdiutil.New().Attach(path, opts)
+		return err
+	})
 	if err != nil {
-		t.Fatalf("failed to mount %q (%v)", path, err)
+		t.Fatalf("failed to mount %q: %v", path, err)
 	}
-	device, err = parseHdiutilAttachOutput(stdout)
+	volume, err := attached.Volume()
 	if err != nil {
-		t.Fatal(err)
+		t.Fatalf("diskimage test utility only supports images with a single volume: %v", err)
 	}
+	// Mount point may have changed by the time we cleanup (e.g. by `asr
+	// restore`). Get the the device node to use during cleanup.
+	device := volume.Device
 	t.Cleanup(func() {
 		if err := detach(device); err != nil {
-			t.Fatal(err)
+			t.Error(err)
 		}
 	})
+	// t.TempDir can return a path that contains a symlink. Evaluate the
+	// mount point, as `diskutil info` returns the non-symlink mount
+	// points. We could return `info.MountPoint`, but then
+	// diskutil_darwin_test's TestInfo wouldn't be truly testing the
+	// MountPoint value.
 	mountpoint, err = filepath.EvalSymlinks(mountpoint)
 	if err != nil {
 		t.Fatal(err)
 	}
-	return mountpoint, device
+	return mountpoint, device, attached
 }
 
-func parseHdiutilAttachOutput(stdout []byte) (device string, err error) {
-	pl := plutil.New()
-	var info struct {
-		SystemEntities []struct {
-			MountPoint string `json:"mount-point"`
-			DevEntry   string `json:"dev-entry"`
-		} `json:"system-entities"`
-	}
-	if err := pl.Unmarshal(stdout, &info); err != nil {
-		return "", err
-	}
-	found := false
-	for _, e := range info.SystemEntities {
-		if e.MountPoint == "" {
-			continue
-		}
-		if found {
-			return "", errors.New("diskimage test utility only supports images with a single volume")
-		}
+// DetachError is returned when a disk image could not be detached. It
+// includes the error of every attempt, and the state of all attached images
+// after the last attempt.
+type DetachError struct {
+	Device string
+	// Attempts are the errors of each attempt to detach, including the
+	// stderr of `hdiutil detach`.
+	Attempts []error
+	// HdiutilInfo is the output of `hdiutil info` after the last attempt.
+	HdiutilInfo string
+}
 
-		found = true
-		device = e.DevEntry
+func (err *DetachError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "failed to unmount %q after %d tries:\n", err.Device, len(err.Attempts))
+	for i, a := range err.Attempts {
+		fmt.Fprintf(&b, "  attempt %d: %v\n", i+1, a)
 	}
-	return device, nil
+	fmt.Fprintf(&b, "hdiutil info:\n%s", err.HdiutilInfo)
+	return b.String()
 }
 
 // detach the device, retrying up to 2 additional times (with a small
@@ -291,17 +335,22 @@ func parseHdiutilAttachOutput(stdout []byte) (device string, err error) {
 func detach(device string) error {
 	const maxAttempts = 3
 	const initialDelay = time.Second
-	var err error
+	detachErr := &DetachError{Device: device}
 	for i := 0; i < maxAttempts; i++ {
 		time.Sleep(time.Duration(i) * initialDelay)
-		cmd := exec.Command("hdiutil", "detach", "-force", device)
-		err = cmd.Run()
+		err := withHdiutilLock(func() error {
+			return hdiutil.New().Detach(device, true)
+		})
 		if err == nil {
 			return nil
 		}
+		detachErr.Attempts = append(detachErr.Attempts, err)
 	}
-	if exitErr, ok := err.(*exec.ExitError); ok {
-		return fmt.Errorf("failed to unmount %q after %d tries: %v: %s", device, maxAttempts, err, exitErr.Stderr)
+	info, err := exec.Command("hdiutil", "info").CombinedOutput()
+	if err != nil {
+		detachErr.HdiutilInfo = fmt.Sprintf("(`hdiutil info` failed: %v) %s", err, info)
+	} else {
+		detachErr.HdiutilInfo = string(info)
 	}
-	return fmt.Errorf("failed to unmount %q after %d tries: %v", device, maxAttempts, err)
+	return detachErr
 }
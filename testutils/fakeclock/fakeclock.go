@@ -0,0 +1,55 @@
+// Package fakeclock provides fake implementations of clock.Clock and
+// clock.IDGenerator for tests.
+package fakeclock
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock is a clock.Clock that only changes time when told to.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// New returns a Clock whose current time is now.
+func New(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+// Now returns the fake current time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the fake current time forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// IDs is a clock.IDGenerator that generates predictable, sequential IDs:
+// <prefix>-1, <prefix>-2, etc.
+type IDs struct {
+	mu     sync.Mutex
+	prefix string
+	n      int
+}
+
+// NewIDs returns an IDs that generates IDs starting with prefix.
+func NewIDs(prefix string) *IDs {
+	return &IDs{prefix: prefix}
+}
+
+// NewID returns the next sequential ID.
+func (ids *IDs) NewID() string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	ids.n++
+	return fmt.Sprintf("%s-%d", ids.prefix, ids.n)
+}
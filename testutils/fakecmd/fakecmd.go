@@ -50,6 +50,7 @@
 package fakecmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -119,6 +120,16 @@ func WantArg(name string, arg string) Option {
 // exec.Command in tests. Inspired by the stdlib's exec_test. Modified to allow
 // specifying different stdouts, stderrs, stdins, and exit codes per command.
 func FakeCommand(t *testing.T, opts ...Option) func(string, ...string) *exec.Cmd {
+	fake := FakeCommandContext(t, opts...)
+	return func(name string, args ...string) *exec.Cmd {
+		return fake(context.Background(), name, args...)
+	}
+}
+
+// FakeCommandContext is like FakeCommand, but returns a function suitable for
+// replacing a call to exec.CommandContext. The fake command is killed if ctx
+// is done before it exits, and fails to start if ctx is already done.
+func FakeCommandContext(t *testing.T, opts ...Option) func(context.Context, string, ...string) *exec.Cmd {
 	conf := config{
 		stdouts:    make(map[string]string),
 		stderrs:    make(map[string]string),
@@ -129,9 +140,9 @@ func FakeCommand(t *testing.T, opts ...Option) func(string, ...string) *exec.Cmd
 	for _, opt := range opts {
 		opt(&conf)
 	}
-	return func(name string, args ...string) *exec.Cmd {
+	return func(ctx context.Context, name string, args ...string) *exec.Cmd {
 		validateArgs(t, name, conf.wantArgs[name], args)
-		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd := exec.CommandContext(ctx, os.Args[0], "-test.run=TestHelperProcess")
 		cmd.Env = append(os.Environ(),
 			"GO_WANT_HELPER_PROCESS=1",
 			fmt.Sprintf("GO_HELPER_PROCESS_STDOUT=%s", conf.stdouts[name]),
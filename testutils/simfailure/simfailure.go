@@ -0,0 +1,130 @@
+// Package simfailure generates pathological volumes for tests: diskutil output
+// and snapshot lists that are rare in practice, but that a clone must either
+// handle or fail on with a clear error, e.g. duplicate snapshots, snapshots
+// listed out of order, or thousands of snapshots.
+//
+// Unlike the disk images of the diskimage package, everything is generated, so
+// it can be used in tests on any platform.
+package simfailure
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// LongListLen is the number of snapshots in the "hundreds of snapshots" case.
+// It is limited by the size of the environment variable fakecmd passes
+// commands' stdout in; use Snapshots directly for longer lists.
+const LongListLen = 500
+
+// snapshotTimestampLayout is the layout of the timestamps in generated
+// snapshot names, as parsed by diskutil.ListSnapshots.
+const snapshotTimestampLayout = "2006-01-02-150405"
+
+// Snapshots returns n snapshots of the volume named volume, created an hour
+// apart, the most recent at newest. Like diskutil.SnapshotList, they are
+// ordered most recent first.
+func Snapshots(volume string, n int, newest time.Time) diskutil.SnapshotList {
+	snaps := make(diskutil.SnapshotList, n)
+	for i := range snaps {
+		created := newest.Add(-time.Duration(i) * time.Hour).UTC()
+		snaps[i] = diskutil.Snapshot{
+			Name:    fmt.Sprintf("com.example.backup.%s", created.Format(snapshotTimestampLayout)),
+			UUID:    fmt.Sprintf("%s-snapshot-%d-uuid", volume, n-i),
+			Created: created,
+		}
+	}
+	return snaps
+}
+
+// ListSnapshotsOutput returns the output of `diskutil apfs listsnapshots
+// -plist` for a volume with snaps, listed in the given order. Note that
+// diskutil lists snapshots oldest first, the reverse of diskutil.SnapshotList.
+func ListSnapshotsOutput(snaps ...diskutil.Snapshot) string {
+	b := new(strings.Builder)
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Snapshots</key>
+	<array>
+`)
+	for _, s := range snaps {
+		fmt.Fprintf(b, `		<dict>
+			<key>SnapshotName</key>
+			<string>%s</string>
+			<key>SnapshotUUID</key>
+			<string>%s</string>
+		</dict>
+`, s.Name, s.UUID)
+	}
+	b.WriteString(`	</array>
+</dict>
+</plist>
+`)
+	return b.String()
+}
+
+// reversed returns snaps oldest first, as listed by diskutil.
+func reversed(snaps diskutil.SnapshotList) []diskutil.Snapshot {
+	r := make([]diskutil.Snapshot, len(snaps))
+	for i, s := range snaps {
+		r[len(snaps)-1-i] = s
+	}
+	return r
+}
+
+// Case is a pathological `diskutil apfs listsnapshots -plist` output.
+type Case struct {
+	Name   string
+	Output string
+	// Want is the snapshots ListSnapshots should return, or nil if it
+	// should fail.
+	Want diskutil.SnapshotList
+	// WantErr is the error ListSnapshots should fail with, or nil if it
+	// should succeed.
+	WantErr error
+}
+
+// Cases returns all pathological ListSnapshots cases of a volume whose newest
+// snapshot was created at newest.
+func Cases(newest time.Time) []Case {
+	snaps := Snapshots("pathological", 3, newest)
+	long := Snapshots("pathological", LongListLen, newest)
+
+	duplicateName := snaps[1]
+	duplicateName.UUID = "duplicate-name-uuid"
+	duplicateUUID := snaps[1]
+	duplicateUUID.Name = fmt.Sprintf("com.example.other.%s", duplicateUUID.Created.Format(snapshotTimestampLayout))
+
+	return []Case{
+		{
+			Name:   "no snapshots",
+			Output: ListSnapshotsOutput(),
+			Want:   nil,
+		},
+		{
+			Name:    "duplicate names",
+			Output:  ListSnapshotsOutput(snaps[2], snaps[1], duplicateName, snaps[0]),
+			WantErr: diskutil.ErrDuplicateSnapshot,
+		},
+		{
+			Name:    "duplicate UUIDs",
+			Output:  ListSnapshotsOutput(snaps[2], snaps[1], duplicateUUID, snaps[0]),
+			WantErr: diskutil.ErrDuplicateSnapshot,
+		},
+		{
+			Name:    "out of order",
+			Output:  ListSnapshotsOutput(snaps[2], snaps[0], snaps[1]),
+			WantErr: diskutil.ErrSnapshotOrder,
+		},
+		{
+			Name:   "hundreds of snapshots",
+			Output: ListSnapshotsOutput(reversed(long)...),
+			Want:   long,
+		},
+	}
+}
@@ -0,0 +1,62 @@
+// Package tmutil implements creating local snapshots of APFS volumes using
+// MacOS's tmutil.
+package tmutil
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+)
+
+// TMUtil creates local snapshots. tmutil is killed if ctx is done before it
+// exits.
+type TMUtil interface {
+	// LocalSnapshot creates a local Time Machine snapshot of the APFS
+	// volume mounted at mountPoint, and returns the date in its name, e.g.
+	// 2021-02-03-040506 for com.apple.TimeMachine.2021-02-03-040506.local.
+	LocalSnapshot(ctx context.Context, mountPoint string) (string, error)
+}
+
+type tmUtil struct {
+	execCommand func(context.Context, string, ...string) *exec.Cmd
+}
+
+type option func(*tmUtil)
+
+func withExecCommand(f func(context.Context, string, ...string) *exec.Cmd) option {
+	return func(tm *tmUtil) {
+		tm.execCommand = f
+	}
+}
+
+// New returns a new TMUtil.
+func New(opts ...option) TMUtil {
+	tm := tmUtil{
+		execCommand: exec.CommandContext,
+	}
+	for _, opt := range opts {
+		opt(&tm)
+	}
+	return tm
+}
+
+// createdRE matches tmutil's output on success, e.g. "Created local snapshot
+// with date: 2021-02-03-040506".
+var createdRE = regexp.MustCompile(`Created local snapshot with date: (\d{4}-\d{2}-\d{2}-\d{6})`)
+
+func (tm tmUtil) LocalSnapshot(ctx context.Context, mountPoint string) (string, error) {
+	cmd := tm.execCommand(ctx, "tmutil", "localsnapshot", mountPoint)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+	stdout, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("`%s` failed (%w) with stderr: %s", cmd, err, stderr)
+	}
+	m := createdRE.FindSubmatch(stdout)
+	if m == nil {
+		return "", fmt.Errorf("`%s` returned unexpected output: %q", cmd, stdout)
+	}
+	return string(m[1]), nil
+}
@@ -0,0 +1,75 @@
+package tmutil
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/testutils/fakecmd"
+)
+
+func TestHelperProcess(t *testing.T) {
+	fakecmd.HelperProcess(t)
+}
+
+func newWithFakeCmd(t *testing.T, opts ...fakecmd.Option) TMUtil {
+	return New(withExecCommand(fakecmd.FakeCommandContext(t, opts...)))
+}
+
+func TestLocalSnapshot(t *testing.T) {
+	tm := newWithFakeCmd(t,
+		fakecmd.Stdout("tmutil", "NOTE: local snapshots are considered purgeable and may be removed at any time by deleted(8).\nCreated local snapshot with date: 2021-02-03-040506\n"),
+		fakecmd.WantArg("tmutil", "localsnapshot"),
+		fakecmd.WantArg("tmutil", "/Volumes/source"),
+	)
+	got, err := tm.LocalSnapshot(context.Background(), "/Volumes/source")
+	if err := fakecmd.AsHelperProcessErr(err); err != nil {
+		t.Fatal(err)
+	}
+	if err != nil {
+		t.Fatalf("LocalSnapshot returned unexpected error: %v, want: nil", err)
+	}
+	if want := "2021-02-03-040506"; got != want {
+		t.Errorf("LocalSnapshot returned %q, want: %q", got, want)
+	}
+}
+
+func TestLocalSnapshot_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []fakecmd.Option
+		wantExitErr bool
+	}{
+		{
+			name: "tmutil fails",
+			opts: []fakecmd.Option{
+				fakecmd.Stderr("tmutil", "example stderr"),
+				fakecmd.ExitFail("tmutil"),
+			},
+			wantExitErr: true,
+		},
+		{
+			name: "unexpected output",
+			opts: []fakecmd.Option{
+				fakecmd.Stdout("tmutil", "unexpected"),
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tm := newWithFakeCmd(t, test.opts...)
+			_, err := tm.LocalSnapshot(context.Background(), "/Volumes/source")
+			if err := fakecmd.AsHelperProcessErr(err); err != nil {
+				t.Fatal(err)
+			}
+			if err == nil {
+				t.Fatal("LocalSnapshot returned unexpected error: nil, want: non-nil")
+			}
+			var exitErr *exec.ExitError
+			if test.wantExitErr && !errors.As(err, &exitErr) {
+				t.Errorf("LocalSnapshot returned unexpected error: %v, want type: *exec.ExitError", err)
+			}
+		})
+	}
+}
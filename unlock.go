@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/keychain"
+)
+
+// unlockVolumes unlocks each of volumes that is an encrypted, locked APFS
+// volume, e.g. a FileVault source or an encrypted target, so that it can be
+// cloned from or to. Each passphrase is looked up in the keychain by the
+// volume's UUID, or read from the terminal if it is not in the keychain and
+// prompt is true, so that volumes are only unlocked with the user's consent.
+// The returned func locks the unlocked volumes again, skipping volumes that
+// have since been ejected.
+func unlockVolumes(ctx context.Context, w io.Writer, du diskutil.DiskUtil, kc keychain.Keychain, volumes []string, prompt bool) (relock func(), err error) {
+	var unlocked []diskutil.VolumeInfo
+	relock = func() {
+		for _, v := range unlocked {
+			info, err := du.Info(ctx, v.UUID)
+			if err != nil || info.Locked {
+				continue
+			}
+			if err := du.LockVolume(ctx, info); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to lock %q again: %v\n", info.Name, err)
+				continue
+			}
+			fmt.Fprintf(w, "Locked %q.\n", info.Name)
+		}
+	}
+	for _, v := range volumes {
+		info, err := du.Info(ctx, v)
+		if err != nil || !info.Locked {
+			// Volumes that do not exist fail preflight checks.
+			continue
+		}
+		passphrase, err := kc.Passphrase(ctx, keychain.Service, info.UUID)
+		if errors.Is(err, keychain.ErrNotFound) && prompt {
+			passphrase, err = readPassphrase(fmt.Sprintf("Passphrase for %q: ", info.Name))
+		}
+		if errors.Is(err, keychain.ErrNotFound) {
+			err = fmt.Errorf("%w - add it with `security add-generic-password -s %s -a %s -w`", err, keychain.Service, info.UUID)
+		}
+		if err != nil {
+			relock()
+			return nil, fmt.Errorf("error getting passphrase of locked volume %q: %w", info.Name, err)
+		}
+		if err := du.UnlockVolume(ctx, info, passphrase); err != nil {
+			relock()
+			return nil, fmt.Errorf("error unlocking %q: %w", info.Name, err)
+		}
+		fmt.Fprintf(w, "Unlocked %q.\n", info.Name)
+		unlocked = append(unlocked, info)
+	}
+	return relock, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cliio"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+)
+
+// unplugVerdict is whether a target is safe to disconnect at the end of a run.
+type unplugVerdict struct {
+	target string
+	safe   bool
+	reason string
+}
+
+func (v unplugVerdict) String() string {
+	if v.safe {
+		return fmt.Sprintf("%s: SAFE TO DISCONNECT (%s)", v.target, v.reason)
+	}
+	return fmt.Sprintf("%s: DO NOT DISCONNECT (%s)", v.target, v.reason)
+}
+
+// targetOutcome is what happened to a target during a run.
+type targetOutcome struct {
+	// cloneErr is the error cloning to the target, if any.
+	cloneErr error
+	// ejected is true if the target was ejected, and ejectErr the error
+	// ejecting it, if an eject was attempted and failed.
+	ejected  bool
+	ejectErr error
+	// deferred is true if the target was skipped because -max-runtime was
+	// exceeded.
+	deferred bool
+}
+
+// judgeUnplug returns whether target is safe to disconnect, given the outcome
+// of the run. A target is only safe to disconnect once it is cloned to
+// successfully, and is ejected or otherwise not mounted, so that no writes to
+// it are pending.
+func judgeUnplug(ctx context.Context, du diskutil.DiskUtil, target string, o targetOutcome) unplugVerdict {
+	v := unplugVerdict{target: target}
+	switch {
+	case o.cloneErr != nil:
+		// Includes verification failures, e.g. with -verify-before-prune.
+		v.reason = fmt.Sprintf("clone failed, so it may not have source's latest snapshot: %v", o.cloneErr)
+	case o.ejectErr != nil:
+		v.reason = fmt.Sprintf("eject failed: %v", o.ejectErr)
+	case o.ejected:
+		v.safe, v.reason = true, "ejected"
+	default:
+		info, err := du.Info(ctx, target)
+		switch {
+		case err != nil:
+			v.reason = fmt.Sprintf("could not check whether it is mounted: %v", err)
+		case info.MountPoint != "":
+			v.reason = fmt.Sprintf("still mounted at %s, so writes may be pending - eject it first, e.g. with -eject", info.MountPoint)
+		default:
+			v.safe, v.reason = true, "not mounted"
+		}
+	}
+	if o.deferred {
+		v.reason += "; not cloned to, as -max-runtime was exceeded"
+	}
+	return v
+}
+
+// printUnplugVerdicts prints, and logs, whether each target is safe to
+// disconnect, in the order of targets. Printed verdicts are styled with c.
+func printUnplugVerdicts(ctx context.Context, w io.Writer, c cliio.Colors, du diskutil.DiskUtil, targets []string, outcomes map[string]targetOutcome) {
+	fmt.Fprintln(w, c.Bold("Safe to unplug:"))
+	for _, t := range targets {
+		v := judgeUnplug(ctx, du, t, outcomes[t])
+		style := c.Red
+		if v.safe {
+			style = c.Green
+		}
+		fmt.Fprintf(w, "\t%s\n", style(v.String()))
+		level := oslog.Default
+		if !v.safe {
+			level = oslog.Error
+		}
+		logger.Log(level, "%s", v)
+	}
+}
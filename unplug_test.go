@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/cliio"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+func TestPrintUnplugVerdicts(t *testing.T) {
+	du := &fakeDiskUtil{volumes: map[string]diskutil.VolumeInfo{
+		"mounted":   {Name: "mounted", UUID: "mounted-uuid", MountPoint: "/Volumes/mounted"},
+		"unmounted": {Name: "unmounted", UUID: "unmounted-uuid"},
+	}}
+	tests := []struct {
+		name    string
+		target  string
+		outcome targetOutcome
+		want    string
+	}{
+		{
+			name:    "ejected",
+			target:  "mounted",
+			outcome: targetOutcome{ejected: true},
+			want:    "mounted: SAFE TO DISCONNECT (ejected)",
+		},
+		{
+			name:   "not mounted",
+			target: "unmounted",
+			want:   "unmounted: SAFE TO DISCONNECT (not mounted)",
+		},
+		{
+			name:   "still mounted",
+			target: "mounted",
+			want:   "mounted: DO NOT DISCONNECT (still mounted at /Volumes/mounted, so writes may be pending - eject it first, e.g. with -eject)",
+		},
+		{
+			name:    "clone in flight",
+			target:  "unmounted",
+			outcome: targetOutcome{cloneErr: fmt.Errorf("error restoring: %w", context.Canceled)},
+			want:    "unmounted: DO NOT DISCONNECT (clone failed, so it may not have source's latest snapshot: error restoring: context canceled)",
+		},
+		{
+			name:    "unverified",
+			target:  "unmounted",
+			outcome: targetOutcome{cloneErr: fmt.Errorf("%w: verification failed: target does not contain any snapshots", cloner.ErrPruneSkipped)},
+			want:    "unmounted: DO NOT DISCONNECT (clone failed, so it may not have source's latest snapshot: target could not be verified, so its common snapshot was not pruned: verification failed: target does not contain any snapshots)",
+		},
+		{
+			name:    "eject failed",
+			target:  "mounted",
+			outcome: targetOutcome{ejectErr: errors.New("volume busy")},
+			want:    "mounted: DO NOT DISCONNECT (eject failed: volume busy)",
+		},
+		{
+			name:    "deferred and not mounted",
+			target:  "unmounted",
+			outcome: targetOutcome{deferred: true},
+			want:    "unmounted: SAFE TO DISCONNECT (not mounted; not cloned to, as -max-runtime was exceeded)",
+		},
+		{
+			name:    "deferred and mounted",
+			target:  "mounted",
+			outcome: targetOutcome{deferred: true},
+			want:    "mounted: DO NOT DISCONNECT (still mounted at /Volumes/mounted, so writes may be pending - eject it first, e.g. with -eject; not cloned to, as -max-runtime was exceeded)",
+		},
+		{
+			name:   "missing",
+			target: "missing",
+			want:   `missing: DO NOT DISCONNECT (could not check whether it is mounted: no volume "missing")`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got bytes.Buffer
+			outcomes := map[string]targetOutcome{test.target: test.outcome}
+			printUnplugVerdicts(context.Background(), &got, cliio.Colors{}, du, []string{test.target}, outcomes)
+			want := "Safe to unplug:\n\t" + test.want + "\n"
+			if diff := cmp.Diff(want, got.String()); diff != "" {
+				t.Errorf("printUnplugVerdicts printed unexpected verdicts. -want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPrintUnplugVerdicts_Order(t *testing.T) {
+	du := &fakeDiskUtil{volumes: map[string]diskutil.VolumeInfo{
+		"target-1": {Name: "target-1", UUID: "target-1-uuid"},
+		"target-2": {Name: "target-2", UUID: "target-2-uuid", MountPoint: "/Volumes/target-2"},
+	}}
+	outcomes := map[string]targetOutcome{
+		"target-2": {cloneErr: errors.New("asr failed")},
+		"target-1": {ejected: true},
+	}
+	var got bytes.Buffer
+	printUnplugVerdicts(context.Background(), &got, cliio.Colors{}, du, []string{"target-2", "target-1"}, outcomes)
+	want := `Safe to unplug:
+	target-2: DO NOT DISCONNECT (clone failed, so it may not have source's latest snapshot: asr failed)
+	target-1: SAFE TO DISCONNECT (ejected)
+`
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("printUnplugVerdicts printed unexpected verdicts. -want +got:\n%s", diff)
+	}
+}
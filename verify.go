@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/checksum"
+	"github.com/voidingwarranties/offsite-apfs-backup/cloner"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/fileverify"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+// verify checks that targets contain the latest snapshot in source, without
+// modifying them.
+func verify(args []string) error {
+	ctx := context.Background()
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	var paths targetsFlag
+	fs.Var(&paths, "path", `Path, relative to the volumes' roots, of files to compare in the latest snapshot of source and targets. Directories are compared recursively, and "." compares every file.
+May be given more than once.`)
+	hash := fs.String("hash", string(checksum.XXHash), `Algorithm to hash files with when comparing -path: sha256, xxhash, or blake3.`)
+	fromLastRun := fs.Bool("from-last-run", false, `If true, verify the targets of the last run recorded in the state file, instead of the given volumes, against the source snapshots they were cloned to, e.g. to verify a clone days later, after source has newer snapshots. Targets that are not attached are skipped.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources, and the clones of each run.`)
+	fs.BoolVar(noPLUtil, "no-plutil", false, `Deprecated: has no effect, since plutil is never run.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s verify [-path <path>]... [-hash <algorithm>] <source volume> <target volume> [<target volume>...]
+       %[1]s verify -from-last-run [-state <path>] [-path <path>]... [-hash <algorithm>]
+
+Verifies that the latest snapshot in each target is the latest snapshot in
+source, and that the target's snapshots match the history recorded on it when
+it was last cloned to. Targets are not modified.
+
+If -path is given, the latest snapshot is also mounted read-only on source and
+each target, and the files at each path are compared by hash, to confirm the
+restore produced identical data.
+
+With -from-last-run, the targets of the last run are verified against the
+source snapshots they were cloned to, rather than source's latest snapshot, so
+that a clone can finish quickly and be verified in a later invocation.
+
+  <source volume>
+    	Source APFS volume.
+    	May be a mount point, /dev/ path, or volume UUID.
+  <target volume>
+    	Target APFS volume(s) to verify.
+    	May be a mount point, /dev/ path, or volume UUID.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *fromLastRun && fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: volumes cannot be given with -from-last-run")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if !*fromLastRun && fs.NArg() < 2 {
+		fmt.Fprintln(fs.Output(), "Error: <source volume> and at least one <target volume> are required")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	algorithm, err := checksum.ParseAlgorithm(*hash)
+	if err != nil {
+		fmt.Fprintln(fs.Output(), "Error:", err)
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+
+	du := newDiskUtil()
+	var jobs []verifyJob
+	if *fromLastRun {
+		if jobs, err = lastRunVerifyJobs(ctx, *statePath, du); err != nil {
+			return err
+		}
+	} else {
+		for _, t := range fs.Args()[1:] {
+			jobs = append(jobs, verifyJob{source: fs.Arg(0), target: t, name: t})
+		}
+	}
+
+	stdout := newPrefixWriter([]byte("\t"), os.Stdout)
+	var verified, failed int
+	for _, j := range jobs {
+		if j.skip != "" {
+			fmt.Printf("Skipping %q: %s.\n", j.name, j.skip)
+			continue
+		}
+		fmt.Printf("Verifying %q...\n", j.name)
+		opts := []cloner.Option{
+			cloner.Only(cloner.PhaseVerify),
+			cloner.History(true),
+			cloner.Stdout(stdout),
+		}
+		if j.snapshot != "" {
+			opts = append(opts, cloner.ToSnapshot(j.snapshot))
+		}
+		// asr is nil, as verifying never restores.
+		c := cloner.New(du, nil, opts...)
+		verified++
+		if err := c.Clone(ctx, j.source, j.target); err != nil {
+			failed++
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			printExplanation(os.Stderr, err)
+			continue
+		}
+		if len(paths) == 0 {
+			continue
+		}
+		result, err := verifyFiles(ctx, du, j.source, j.target, paths, algorithm)
+		if err != nil {
+			failed++
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s.\n", result)
+		if !result.OK() {
+			failed++
+		}
+	}
+	if verified == 0 {
+		return errors.New("none of the targets of the last run are attached")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d target(s) failed verification", failed, verified)
+	}
+	fmt.Printf("Verified %d target(s).\n", verified)
+	return nil
+}
+
+// verifyJob is a target to verify.
+type verifyJob struct {
+	source, target string
+	// name describes target, e.g. by its paired name.
+	name string
+	// snapshot, if set, is the source snapshot target was cloned to, which
+	// target is verified against instead of source's latest snapshot.
+	snapshot string
+	// skip, if set, is why target is not verified.
+	skip string
+}
+
+// lastRunVerifyJobs returns the targets of the last run recorded in the state
+// file at statePath, to verify against the snapshots they were cloned to.
+func lastRunVerifyJobs(ctx context.Context, statePath string, du diskutil.DiskUtil) ([]verifyJob, error) {
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	entries := st.LastRun()
+	if len(entries) == 0 {
+		return nil, errors.New("no runs are recorded in the state file")
+	}
+	fmt.Printf("Verifying the targets of %s, started %s.\n", describeRun(entries[0].RunID, entries[0].Label), entries[0].Started.Format(time.RFC3339))
+	var jobs []verifyJob
+	for _, e := range entries {
+		j := verifyJob{
+			source:   e.SourceUUID,
+			target:   e.TargetUUID,
+			name:     e.TargetUUID,
+			snapshot: e.SnapshotUUID,
+		}
+		if p, err := st.Pairing(e.TargetUUID); err == nil {
+			j.name = p.TargetName
+		}
+		if _, err := du.Info(ctx, e.TargetUUID); err != nil {
+			j.skip = "not attached"
+		} else if e.SnapshotUUID == "" {
+			return nil, fmt.Errorf("the last run did not record the snapshot %q was cloned to, as it was run by an older version - verify it with source's latest snapshot instead", j.name)
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// verifyFiles compares the files at paths in the latest snapshot of source and
+// target.
+func verifyFiles(ctx context.Context, du diskutil.DiskUtil, source, target string, paths []string, algorithm checksum.Algorithm) (fileverify.Result, error) {
+	sourceInfo, err := du.Info(ctx, source)
+	if err != nil {
+		return fileverify.Result{}, fmt.Errorf("invalid source volume: %v", err)
+	}
+	targetInfo, err := du.Info(ctx, target)
+	if err != nil {
+		return fileverify.Result{}, fmt.Errorf("invalid target volume: %v", err)
+	}
+	result, err := fileverify.New(du, fileverify.Algorithm(algorithm)).Verify(ctx, sourceInfo, targetInfo, paths)
+	if err != nil {
+		return fileverify.Result{}, fmt.Errorf("error comparing files in %q: %w", targetInfo.Name, err)
+	}
+	return result, nil
+}
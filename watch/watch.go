@@ -0,0 +1,106 @@
+// Package watch implements watching for APFS volumes to be attached, by
+// polling diskutil, so that targets can be cloned to as soon as they are
+// plugged in.
+package watch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// DefaultInterval is how often volumes are polled by default.
+const DefaultInterval = 5 * time.Second
+
+// Watcher reports APFS volumes as they are attached.
+type Watcher struct {
+	diskutil diskutil.DiskUtil
+	interval time.Duration
+	onError  func(error)
+
+	// attached is the set of UUIDs of the volumes attached at the last
+	// poll, or nil before the first poll.
+	attached map[string]bool
+}
+
+// Option configures a Watcher.
+type Option func(*Watcher)
+
+// Interval returns an Option that sets how often Watch polls volumes. Defaults
+// to DefaultInterval.
+func Interval(d time.Duration) Option {
+	return func(w *Watcher) {
+		w.interval = d
+	}
+}
+
+// OnError returns an Option that sets the func Watch calls with errors polling
+// volumes. Watch keeps polling after errors, as diskutil fails transiently
+// while disks are being attached. By default, errors are ignored.
+func OnError(f func(error)) Option {
+	return func(w *Watcher) {
+		w.onError = f
+	}
+}
+
+// New returns a new Watcher.
+func New(du diskutil.DiskUtil, opts ...Option) *Watcher {
+	w := &Watcher{
+		diskutil: du,
+		interval: DefaultInterval,
+		onError:  func(error) {},
+	}
+	for _, opt := range opts {
+		opt(w)
+	}
+	return w
+}
+
+// Poll returns the volumes that were attached since the previous Poll. The
+// first Poll only records the volumes that are already attached, and returns
+// none. A volume that is detached and attached again is returned again.
+func (w *Watcher) Poll(ctx context.Context) ([]diskutil.VolumeInfo, error) {
+	volumes, err := w.diskutil.APFSVolumes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error listing APFS volumes: %w", err)
+	}
+	attached := make(map[string]bool)
+	var appeared []diskutil.VolumeInfo
+	for _, v := range volumes {
+		attached[v.UUID] = true
+		if w.attached != nil && !w.attached[v.UUID] {
+			appeared = append(appeared, v)
+		}
+	}
+	w.attached = attached
+	return appeared, nil
+}
+
+// Watch polls volumes until ctx is done, calling f with each volume as it is
+// attached. Volumes already attached when Watch is called are not reported.
+// Polling pauses while f runs, so volumes attached meanwhile are reported once
+// f returns. Watch returns ctx's error.
+func (w *Watcher) Watch(ctx context.Context, f func(diskutil.VolumeInfo)) error {
+	if _, err := w.Poll(ctx); err != nil {
+		w.onError(err)
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			appeared, err := w.Poll(ctx)
+			if err != nil {
+				w.onError(err)
+				continue
+			}
+			for _, v := range appeared {
+				f(v)
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+)
+
+// fakeDiskUtil returns each of polls from successive calls to APFSVolumes, and
+// then the last of polls forever. A nil poll fails.
+type fakeDiskUtil struct {
+	diskutil.DiskUtil
+
+	mu    sync.Mutex
+	polls [][]diskutil.VolumeInfo
+}
+
+func (du *fakeDiskUtil) APFSVolumes(ctx context.Context) ([]diskutil.VolumeInfo, error) {
+	du.mu.Lock()
+	defer du.mu.Unlock()
+	poll := du.polls[0]
+	if len(du.polls) > 1 {
+		du.polls = du.polls[1:]
+	}
+	if poll == nil {
+		return nil, errors.New("diskutil failed")
+	}
+	return poll, nil
+}
+
+var (
+	source  = diskutil.VolumeInfo{Name: "source", UUID: "source-uuid"}
+	target1 = diskutil.VolumeInfo{Name: "target-1", UUID: "target-1-uuid"}
+	target2 = diskutil.VolumeInfo{Name: "target-2", UUID: "target-2-uuid"}
+)
+
+func TestPoll(t *testing.T) {
+	du := &fakeDiskUtil{polls: [][]diskutil.VolumeInfo{
+		{source, target1},
+		{source, target1},
+		{source, target1, target2},
+		{source},
+		{source, target1},
+	}}
+	w := New(du)
+	var got [][]diskutil.VolumeInfo
+	for i := 0; i < 5; i++ {
+		appeared, err := w.Poll(context.Background())
+		if err != nil {
+			t.Fatalf("Poll returned unexpected error: %v, want: nil", err)
+		}
+		got = append(got, appeared)
+	}
+	want := [][]diskutil.VolumeInfo{
+		nil,
+		nil,
+		{target2},
+		nil,
+		{target1},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Poll returned unexpected volumes. -want +got:\n%s", diff)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	du := &fakeDiskUtil{polls: [][]diskutil.VolumeInfo{
+		{source},
+		nil,
+		{source, target1},
+	}}
+	var errs int
+	w := New(du, Interval(time.Millisecond), OnError(func(error) { errs++ }))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []diskutil.VolumeInfo
+	err := w.Watch(ctx, func(v diskutil.VolumeInfo) {
+		got = append(got, v)
+		cancel()
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Watch returned unexpected error: %v, want: %v", err, context.Canceled)
+	}
+	if diff := cmp.Diff([]diskutil.VolumeInfo{target1}, got); diff != "" {
+		t.Errorf("Watch reported unexpected volumes. -want +got:\n%s", diff)
+	}
+	if errs != 1 {
+		t.Errorf("Watch reported %d errors, want: 1", errs)
+	}
+}
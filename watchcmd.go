@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/oslog"
+	"github.com/voidingwarranties/offsite-apfs-backup/resources"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+	"github.com/voidingwarranties/offsite-apfs-backup/watch"
+)
+
+// watchCommand runs until interrupted, cloning to known targets as they are
+// attached.
+func watchCommand(args []string) error {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	interval := fs.Duration("interval", watch.DefaultInterval, `How often to check for attached volumes.`)
+	cfgPath := fs.String("config", config.DefaultPath, `Path to the JSON configuration file naming the backup sets.`)
+	statePath := fs.String("state", state.DefaultPath, `Path to the file recording which targets are paired with which sources.`)
+	metricsListen := fs.String("metrics-listen", "", `If set, serve the metrics of every paired target in the Prometheus text format at /metrics on <address>, e.g. localhost:9433.`)
+	dryrun := fs.Bool("dryrun", false, `If true, run each clone with -dryrun, printing and logging the clone each attached target would trigger, with its plan, without cloning.`)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), `Usage: %s watch [-interval <duration>] [-config <path>] [-state <path>] [-metrics-listen <address>] [-dryrun]
+
+Runs until interrupted, cloning to each known target as soon as it is attached.
+A target of a backup set in the config file runs the set, as if by run; any
+other paired target is cloned to from the source it is paired with. Clones run
+in -launchd mode, without confirmation, one at a time. Volumes that are already
+attached when watch starts are not cloned to. With -dryrun, clones only print
+their plans, to check the config and state files before cloning on attach.
+`, os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fmt.Fprintln(fs.Output(), "Error: unexpected arguments")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	if *interval <= 0 {
+		fmt.Fprintln(fs.Output(), "Error: -interval must be positive")
+		fs.Usage()
+		os.Exit(exitUsage)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	du := newDiskUtil()
+	w := watch.New(du,
+		watch.Interval(*interval),
+		watch.OnError(func(err error) {
+			logger.Log(oslog.Error, "%v", err)
+		}),
+	)
+	if *metricsListen != "" {
+		go func() {
+			if err := serveMetrics(ctx, *metricsListen, *statePath); err != nil {
+				fmt.Fprintln(os.Stderr, "Error: serving metrics:", err)
+				logger.Log(oslog.Error, "serving metrics: %v", err)
+			}
+		}()
+	}
+	fmt.Println("Watching for targets to be attached...")
+	err = w.Watch(ctx, func(v diskutil.VolumeInfo) {
+		// Reload the config and state files, so that changes made
+		// while watching take effect.
+		args, err := watchCloneArgs(ctx, du, exe, *cfgPath, *statePath, *dryrun, v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %q was attached: %v\n", v.Name, err)
+			logger.Log(oslog.Error, "%q was attached: %v", v.Name, err)
+			return
+		}
+		if args == nil {
+			return
+		}
+		if *dryrun {
+			fmt.Printf("Target %q was attached. Would run %q; printing its plan...\n", v.Name, args[1:])
+			logger.Log(oslog.Default, "Target %q (%s) was attached; dry run of %q", v.Name, v.UUID, args[1:])
+		} else {
+			fmt.Printf("Target %q was attached. Running %q...\n", v.Name, args[1:])
+			logger.Log(oslog.Default, "Target %q (%s) was attached", v.Name, v.UUID)
+		}
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: clone to %q failed: %v\n", v.Name, err)
+			return
+		}
+		if *dryrun {
+			fmt.Printf("Dry run of clone to %q completed.\n", v.Name)
+			return
+		}
+		fmt.Printf("Clone to %q completed.\n", v.Name)
+	})
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// watchCloneArgs returns the program arguments that clone to target, which was
+// just attached: running the backup set that target is a target of, if any, or
+// cloning to target from the source it is paired with. If dryrun is true, the
+// clone is a dry run. nil is returned if target is not a known target.
+func watchCloneArgs(ctx context.Context, du diskutil.DiskUtil, exe, cfgPath, statePath string, dryrun bool, target diskutil.VolumeInfo) ([]string, error) {
+	flags := []string{"-state", statePath}
+	if dryrun {
+		flags = append(flags, "-dryrun")
+	}
+	cfg, err := config.Load(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, set := range cfg.Sets {
+		for _, t := range set.Targets {
+			// Sets may name targets by mount point or name, so resolve
+			// them rather than comparing strings.
+			info, err := du.Info(ctx, t)
+			if err == nil && info.UUID == target.UUID {
+				return resources.LaunchdRunArgs(exe, append([]string{"-config", cfgPath}, flags...), set.Name), nil
+			}
+		}
+	}
+	st, err := loadState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range st.Pairings {
+		if p.TargetUUID == target.UUID {
+			return resources.LaunchdCloneArgs(exe, flags, p.SourceUUID, p.TargetUUID), nil
+		}
+	}
+	return nil, nil
+}
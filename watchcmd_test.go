@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/voidingwarranties/offsite-apfs-backup/config"
+	"github.com/voidingwarranties/offsite-apfs-backup/diskutil"
+	"github.com/voidingwarranties/offsite-apfs-backup/state"
+)
+
+func TestWatchCloneArgs(t *testing.T) {
+	setTarget := diskutil.VolumeInfo{Name: "set-target", UUID: "set-target-uuid"}
+	pairedTarget := diskutil.VolumeInfo{Name: "paired-target", UUID: "paired-target-uuid"}
+	unknown := diskutil.VolumeInfo{Name: "unknown", UUID: "unknown-uuid"}
+	du := &fakeDiskUtil{volumes: map[string]diskutil.VolumeInfo{
+		"/Volumes/set-target": setTarget,
+	}}
+
+	dir := t.TempDir()
+	cfgPath := filepath.Join(dir, "config.json")
+	statePath := filepath.Join(dir, "state.json")
+	cfg := &config.Config{Sets: []config.Set{{
+		Name:    "offsite",
+		Source:  "/Volumes/source",
+		Targets: []string{"/Volumes/set-target"},
+	}}}
+	if err := cfg.Save(cfgPath); err != nil {
+		t.Fatalf("Save returned unexpected error: %v, want: nil", err)
+	}
+	st := &state.State{}
+	st.Pair("source-uuid", pairedTarget.UUID, pairedTarget.Name, time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC))
+	if err := st.Save(statePath); err != nil {
+		t.Fatalf("Save returned unexpected error: %v, want: nil", err)
+	}
+
+	exe := "/usr/local/bin/offsite-apfs-backup"
+	tests := []struct {
+		name   string
+		target diskutil.VolumeInfo
+		dryrun bool
+		want   []string
+	}{
+		{
+			name:   "set target",
+			target: setTarget,
+			want:   []string{exe, "run", "-launchd", "-config", cfgPath, "-state", statePath, "--", "offsite"},
+		},
+		{
+			name:   "set target dry run",
+			target: setTarget,
+			dryrun: true,
+			want:   []string{exe, "run", "-launchd", "-config", cfgPath, "-state", statePath, "-dryrun", "--", "offsite"},
+		},
+		{
+			name:   "paired target",
+			target: pairedTarget,
+			want:   []string{exe, "-launchd", "-state", statePath, "--", "source-uuid", pairedTarget.UUID},
+		},
+		{
+			name:   "paired target dry run",
+			target: pairedTarget,
+			dryrun: true,
+			want:   []string{exe, "-launchd", "-state", statePath, "-dryrun", "--", "source-uuid", pairedTarget.UUID},
+		},
+		{
+			name:   "unknown target",
+			target: unknown,
+			dryrun: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := watchCloneArgs(context.Background(), du, exe, cfgPath, statePath, test.dryrun, test.target)
+			if err != nil {
+				t.Fatalf("watchCloneArgs returned unexpected error: %v, want: nil", err)
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("watchCloneArgs returned unexpected args. -want +got:\n%s", diff)
+			}
+		})
+	}
+}